@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+
+	"github.com/dangogh/silver-eureka/internal/alert"
+	"github.com/dangogh/silver-eureka/internal/ban"
+	"github.com/dangogh/silver-eureka/internal/clientip"
+	"github.com/dangogh/silver-eureka/internal/config"
+	"github.com/dangogh/silver-eureka/internal/database"
+	"github.com/dangogh/silver-eureka/internal/retention"
+	"github.com/dangogh/silver-eureka/internal/router"
+)
+
+// reloader re-reads the configuration on SIGHUP and applies the settings
+// that can change while serving: rate limits, trusted proxies, retention,
+// ban rules, alert rules, IP anonymization and log level. Anything else is reported as needing a restart.
+type reloader struct {
+	args      []string
+	cfg       *config.Config
+	logLevel  *slog.LevelVar
+	retention *retention.Manager
+	db        *database.DB
+	router    *router.Router
+	bans      *ban.Manager
+	alerts    *alert.Engine
+}
+
+// reload applies the current configuration, keeping the running settings
+// if it is invalid
+func (r *reloader) reload() {
+	next, err := config.Parse(flag.NewFlagSet(os.Args[0], flag.ContinueOnError), r.args)
+	if err != nil {
+		slog.Error("Configuration reload failed, keeping current settings", "error", err)
+		return
+	}
+	level, err := config.ParseLogLevel(next.LogLevel)
+	if err != nil {
+		slog.Error("Configuration reload failed, keeping current settings", "error", err)
+		return
+	}
+	proxies, err := next.TrustedProxyPrefixes()
+	if err != nil {
+		slog.Error("Configuration reload failed, keeping current settings", "error", err)
+		return
+	}
+	policy, err := retention.PolicyFrom(next)
+	if err != nil {
+		slog.Error("Configuration reload failed, keeping current settings", "error", err)
+		return
+	}
+	if err := r.router.SetRateLimits(next.RateLimit); err != nil {
+		slog.Error("Configuration reload failed, keeping current settings", "error", err)
+		return
+	}
+	clientip.SetTrustedProxies(proxies)
+	if err := r.bans.SetConfig(next.Ban); err != nil {
+		slog.Error("Failed to apply ban settings", "error", err)
+	}
+	if err := r.alerts.SetConfig(next.Alerts); err != nil {
+		slog.Error("Failed to apply alert settings", "error", err)
+	}
+	r.logLevel.Set(level)
+	r.retention.SetPolicy(policy)
+	if anon, err := next.Anonymize.Anonymizer(); err != nil {
+		slog.Error("Failed to apply anonymize settings", "error", err)
+	} else if err := r.db.SetAnonymizer(anon); err != nil {
+		slog.Error("Failed to record anonymize settings", "error", err)
+	}
+
+	for _, setting := range config.RestartRequired(r.cfg, next) {
+		slog.Warn("Setting changed but requires a restart", "setting", setting)
+	}
+
+	// Only the reloadable settings are now in effect
+	r.cfg.LogLevel = next.LogLevel
+	r.cfg.TrustedProxies = next.TrustedProxies
+	r.cfg.LogRetentionDays = next.LogRetentionDays
+	r.cfg.Retention = next.Retention
+	r.cfg.Maintenance = next.Maintenance
+	r.cfg.Anonymize = next.Anonymize
+	rateLimit := next.RateLimit
+	rateLimit.CatchAll.MaxEntries = r.cfg.RateLimit.CatchAll.MaxEntries
+	rateLimit.Stats.MaxEntries = r.cfg.RateLimit.Stats.MaxEntries
+	rateLimit.Web.MaxEntries = r.cfg.RateLimit.Web.MaxEntries
+	rateLimit.Health.MaxEntries = r.cfg.RateLimit.Health.MaxEntries
+	r.cfg.RateLimit = rateLimit
+	r.cfg.Ban = next.Ban
+	r.cfg.Alerts = next.Alerts
+	slog.Info("Configuration reloaded",
+		"log_level", next.LogLevel,
+		"retention_days", next.LogRetentionDays,
+		"auto_ban", next.Ban.AutoBan,
+		"alert_rules", len(next.Alerts.Rules),
+	)
+}
@@ -2,36 +2,91 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/dangogh/silver-eureka/internal/alert"
+	"github.com/dangogh/silver-eureka/internal/auth"
+	"github.com/dangogh/silver-eureka/internal/backup"
+	"github.com/dangogh/silver-eureka/internal/ban"
+	"github.com/dangogh/silver-eureka/internal/banner"
+	"github.com/dangogh/silver-eureka/internal/cli"
+	"github.com/dangogh/silver-eureka/internal/clientip"
 	"github.com/dangogh/silver-eureka/internal/config"
 	"github.com/dangogh/silver-eureka/internal/database"
+	"github.com/dangogh/silver-eureka/internal/digest"
+	"github.com/dangogh/silver-eureka/internal/forward"
+	"github.com/dangogh/silver-eureka/internal/handler"
+	"github.com/dangogh/silver-eureka/internal/listener"
+	"github.com/dangogh/silver-eureka/internal/middleware"
+	"github.com/dangogh/silver-eureka/internal/retention"
 	"github.com/dangogh/silver-eureka/internal/router"
+	"github.com/dangogh/silver-eureka/internal/sensor"
+	"github.com/dangogh/silver-eureka/internal/sink"
+	"github.com/dangogh/silver-eureka/internal/telemetry"
 )
 
 func main() {
-	// Initialize structured JSON logger
+	// Initialize structured JSON logger; the level is set from the config
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(slog.LevelDebug)
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelDebug,
+		Level: logLevel,
 	}))
 	slog.SetDefault(logger)
 
-	if err := run(); err != nil {
+	// Admin subcommands work on the database directly; their output goes
+	// to stdout, so only warnings are logged, to stderr
+	args := os.Args[1:]
+	if len(args) > 0 && cli.IsCommand(args[0]) {
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})))
+		os.Exit(cli.Run(cli.Env{Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr}, args))
+	}
+	if len(args) > 0 && args[0] == "serve" {
+		args = args[1:]
+	}
+
+	if err := run(args, logLevel); err != nil {
 		slog.Error("Application error", "error", err)
 		os.Exit(1)
 	}
 }
 
-func run() error {
+func run(args []string, logLevel *slog.LevelVar) error {
 	// Load configuration
-	cfg := config.Load()
+	cfg, err := config.Parse(flag.CommandLine, args)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	if flag.NArg() > 0 {
+		return fmt.Errorf("unknown command %q (see \"%s help\")", flag.Arg(0), os.Args[0])
+	}
+	level, err := config.ParseLogLevel(cfg.LogLevel)
+	if err != nil {
+		return err
+	}
+	logLevel.Set(level)
+
+	// Believe forwarding headers only from the trusted proxies
+	proxies, err := cfg.TrustedProxyPrefixes()
+	if err != nil {
+		return err
+	}
+	clientip.SetTrustedProxies(proxies)
+	if cfg.Metrics.Enabled {
+		warnMetricsBehindProxy(cfg.Metrics.AllowList, proxies)
+	}
 
 	// Ensure database directory exists
 	dbDir := cfg.DBPath
@@ -55,97 +110,349 @@ func run() error {
 
 	slog.Info("Database initialized successfully", "database", cfg.DBPath)
 
-	// Log auth status
-	if cfg.AuthUsername != "" && cfg.AuthPassword != "" {
-		slog.Info("HTTP Basic Auth enabled for /stats/* endpoints")
+	// Anonymize client addresses before they are stored
+	anon, err := cfg.Anonymize.Anonymizer()
+	if err != nil {
+		return err
+	}
+	if err := db.SetAnonymizer(anon); err != nil {
+		return err
+	}
+	slog.Info("IP anonymization", "mode", anon.Mode(), "key_id", anon.KeyID())
+	db.SetSensorID(cfg.Sensor.ID)
+
+	// Log auth status; accounts created with "user add" or "token create"
+	// also enable authentication
+	authenticator := auth.New(db, cfg.AuthUsername, cfg.AuthPassword)
+	if authenticator.Enabled() {
+		slog.Info("Authentication enabled for /stats/* endpoints")
 	} else {
-		slog.Warn("HTTP Basic Auth not configured - stats endpoints are public")
+		slog.Warn("Authentication not configured - stats endpoints are public")
 	}
 
 	// Log retention status
 	if cfg.LogRetentionDays > 0 {
-		slog.Info("Log retention enabled", "retention_days", cfg.LogRetentionDays)
+		slog.Info("Log retention enabled", "retention_days", cfg.LogRetentionDays, "body_retention_days", cfg.Retention.BodyDays)
 	} else {
 		slog.Info("Log retention disabled - logs will be kept indefinitely")
 	}
+	policy, err := retention.PolicyFrom(cfg)
+	if err != nil {
+		return err
+	}
+	slog.Info("Rollup retention",
+		"hourly_days", policy.HourlyDays,
+		"daily_days", policy.DailyDays,
+		"archive_dir", policy.ArchiveDir,
+		"max_db_size_mb", cfg.Retention.MaxDBSizeMB,
+	)
+	window := "any time"
+	if !policy.Window.IsZero() {
+		window = policy.Window.String()
+	}
+	autoVacuum, err := db.AutoVacuumMode()
+	if err != nil {
+		slog.Warn("Failed to read auto_vacuum mode", "error", err)
+	}
+	slog.Info("Maintenance",
+		"window", window,
+		"batch_size", policy.BatchSize,
+		"batch_pause", policy.BatchPause.String(),
+		"auto_vacuum", autoVacuum,
+	)
 
-	// Create HTTP router with all endpoints
-	h := router.New(db, cfg.AuthUsername, cfg.AuthPassword)
-
-	// Create HTTP server with concurrency-friendly settings
-	server := &http.Server{
-		Addr:              fmt.Sprintf(":%d", cfg.Port),
-		Handler:           h,
-		ReadTimeout:       15 * time.Second,
-		WriteTimeout:      15 * time.Second,
-		IdleTimeout:       60 * time.Second,
-		ReadHeaderTimeout: 5 * time.Second,
-		MaxHeaderBytes:    1 << 20, // 1MB
-	}
-
-	// Channel to listen for errors coming from the listener
-	serverErrors := make(chan error, 1)
-
-	// Start the HTTP server in a goroutine
-	go func() {
-		slog.Info("HTTP server starting", "port", cfg.Port)
-		serverErrors <- server.ListenAndServe()
+	// Record rate-limited requests as per-IP per-minute counters
+	drops := middleware.NewDropCounter(db, 30*time.Second)
+	defer func() {
+		if err := drops.Stop(); err != nil {
+			slog.Error("Failed to flush rate limit drops", "error", err)
+		}
 	}()
 
-	// Start background log cleanup goroutine if retention is enabled
-	if cfg.LogRetentionDays > 0 {
+	// Load the IP ban list
+	bans, err := ban.NewManager(db, cfg.Ban)
+	if err != nil {
+		return fmt.Errorf("failed to load ban list: %w", err)
+	}
+	defer bans.Stop()
+	if cfg.Ban.AutoBan {
+		slog.Info("Automatic IP banning enabled", "threshold", cfg.Ban.Threshold, "window", cfg.Ban.Window.String())
+	}
+
+	// Database snapshots, on demand and optionally on a schedule
+	backups, err := backup.NewManager(db, cfg.Backup)
+	if err != nil {
+		return fmt.Errorf("failed to set up backups: %w", err)
+	}
+	backups.Start()
+	defer backups.Stop()
+	if cfg.Backup.Interval > 0 {
+		slog.Info("Scheduled backups enabled", "interval", cfg.Backup.Interval.String(), "keep", cfg.Backup.Keep, "dir", cfg.Backup.Dir)
+	}
+
+	// Alert rules, evaluated on requests as they arrive and on the logs
+	alerts, err := alert.NewEngine(db, cfg.Alerts)
+	if err != nil {
+		return fmt.Errorf("failed to set up alerts: %w", err)
+	}
+	alerts.AnonymizeWith(db.AnonymizeIP)
+	alerts.Start()
+	defer alerts.Stop()
+	if cfg.Alerts.Enabled() {
+		slog.Info("Alerting enabled", "rules", len(cfg.Alerts.Rules), "webhooks", len(cfg.Alerts.Webhooks))
+	}
+
+	// Email digests on each recipient's schedule
+	digests, err := digest.NewManager(db, cfg.Digest)
+	if err != nil {
+		return fmt.Errorf("failed to set up digests: %w", err)
+	}
+	digests.Start()
+	defer digests.Stop()
+	if cfg.Digest.Enabled() {
+		slog.Info("Email digests enabled", "recipients", len(cfg.Digest.Recipients), "smtp_host", cfg.Digest.SMTP.Host)
+	}
+
+	// Send each logged request to the event sinks and the syslog collector
+	events, err := sink.FromConfig(cfg.Events)
+	if err != nil {
+		return fmt.Errorf("failed to set up event sinks: %w", err)
+	}
+	events.AnonymizeWith(db.AnonymizeIP)
+	if cfg.Syslog.Enabled() {
+		forwarder, err := forward.New(cfg.Syslog)
+		if err != nil {
+			events.Stop()
+			return fmt.Errorf("failed to set up syslog forwarding: %w", err)
+		}
+		forwarder.Start()
+		if err := events.Add(forwarder, cfg.Events.Sink(config.SinkConfig{Name: forwarder.Name()})); err != nil {
+			forwarder.Stop()
+			events.Stop()
+			return fmt.Errorf("failed to set up syslog forwarding: %w", err)
+		}
+		slog.Info("Syslog forwarding enabled", "address", cfg.Syslog.Address, "network", cfg.Syslog.Network, "format", cfg.Syslog.Format)
+	}
+	events.Start()
+	defer events.Stop()
+	if len(cfg.Events.Sinks) > 0 {
+		slog.Info("Event sinks enabled", "sinks", len(cfg.Events.Sinks))
+	}
+
+	// Export request logs, traces and, if bridged, our own logs over OTLP
+	var exporter *telemetry.Exporter
+	if cfg.OTel.Enabled() {
+		exporter, err = telemetry.New(cfg.OTel)
+		if err != nil {
+			return fmt.Errorf("failed to set up OpenTelemetry export: %w", err)
+		}
+		exporter.AnonymizeWith(db.AnonymizeIP)
+		exporter.Start()
+		defer exporter.Stop()
+		if cfg.OTel.BridgeSlog {
+			logger := slog.Default()
+			slog.SetDefault(slog.New(exporter.Handler(logger.Handler())))
+			// Shutdown logging stops being exported before the last flush
+			defer slog.SetDefault(logger)
+		}
+		slog.Info("OpenTelemetry export enabled", "endpoint", cfg.OTel.Endpoint, "protocol", cfg.OTel.Protocol,
+			"logs", cfg.OTel.Logs, "traces", cfg.OTel.Traces, "sample_ratio", cfg.OTel.SampleRatio)
+	}
+
+	// Forward logged requests to a collector, and collect them from sensors
+	var observers []handler.Observer
+	if cfg.Sensor.Enabled() {
+		forwarder, err := sensor.New(cfg.Sensor)
+		if err != nil {
+			return fmt.Errorf("failed to set up sensor forwarding: %w", err)
+		}
+		forwarder.Start()
+		defer forwarder.Stop()
+		observers = append(observers, forwarder)
+		slog.Info("Sensor forwarding enabled", "id", cfg.Sensor.ID, "collector", cfg.Sensor.CollectorURL,
+			"mtls", cfg.Sensor.CertFile != "", "spool_dir", cfg.Sensor.SpoolDir)
+	}
+	collectorErrors := make(chan error, 1)
+	if cfg.Collector.Enabled() {
+		collector, err := sensor.NewCollector(db, cfg.Collector)
+		if err != nil {
+			return fmt.Errorf("failed to set up collector: %w", err)
+		}
 		go func() {
-			// Run cleanup immediately on startup
-			if deleted, err := db.CleanupOldLogs(cfg.LogRetentionDays); err != nil {
-				slog.Error("Failed to cleanup old logs on startup", "error", err)
-			} else if deleted > 0 {
-				slog.Info("Cleaned up old logs on startup", "deleted", deleted)
-			}
+			collectorErrors <- collector.Start()
+		}()
+		defer collector.Stop()
+	}
+
+	// Create HTTP router with all endpoints
+	h, err := router.NewWithOptions(db, router.Options{
+		AuthUsername:    cfg.AuthUsername,
+		AuthPassword:    cfg.AuthPassword,
+		Auth:            authenticator,
+		RateLimit:       &cfg.RateLimit,
+		RejectObservers: []middleware.RejectObserver{drops},
+		Bans:            bans,
+		Metrics:         &cfg.Metrics,
+		Backups:         backups,
+		Capture:         &cfg.Capture,
+		Alerts:          alerts,
+		Digest:          digests,
+		Events:          events,
+		Telemetry:       exporter,
+		LogObservers:    observers,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create router: %w", err)
+	}
+
+	// Imitate other protocols for their scanners on the banner listeners
+	if cfg.Banners.Enabled() {
+		banners, err := banner.New(db, cfg.Banners, bans.IsBanned)
+		if err != nil {
+			return fmt.Errorf("failed to open banner listeners: %w", err)
+		}
+		banners.Start()
+		defer banners.Stop()
+	}
 
-			// Then run daily
-			ticker := time.NewTicker(24 * time.Hour)
-			defer ticker.Stop()
-
-			for range ticker.C {
-				deleted, err := db.CleanupOldLogs(cfg.LogRetentionDays)
-				if err != nil {
-					slog.Error("Failed to cleanup old logs", "error", err)
-				} else if deleted > 0 {
-					slog.Info("Cleaned up old logs", "deleted", deleted, "retention_days", cfg.LogRetentionDays)
-				} else {
-					slog.Debug("Log cleanup ran, no old logs found")
+	// Open every listener before serving any, so a bad one fails the start.
+	// Sniffing listeners record clients that don't speak HTTP as banner
+	// listeners do.
+	sniffed := banner.Recorder(db, bans.IsBanned)
+	listenerConfigs := cfg.ServedListeners()
+	var listeners []net.Listener
+	for _, lc := range listenerConfigs {
+		ln, err := listener.Listen(lc, sniffed)
+		if err != nil {
+			for _, open := range listeners {
+				if err := open.Close(); err != nil {
+					// Never served, nothing to lose
 				}
 			}
+			return err
+		}
+		listeners = append(listeners, ln)
+	}
+
+	// Create an HTTP server per listener with concurrency-friendly settings
+	servers := make([]*http.Server, len(listeners))
+	for i, lc := range listenerConfigs {
+		servers[i] = &http.Server{
+			Handler:           h,
+			ReadTimeout:       15 * time.Second,
+			WriteTimeout:      15 * time.Second,
+			IdleTimeout:       60 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+			MaxHeaderBytes:    1 << 20, // 1MB
+			BaseContext:       listener.BaseContext(lc.Label),
+		}
+	}
+
+	// Channel to listen for errors coming from the listeners
+	serverErrors := make(chan error, len(servers))
+
+	// Start the HTTP servers in goroutines
+	for i, server := range servers {
+		lc, ln := listenerConfigs[i], listeners[i]
+		go func() {
+			slog.Info("HTTP server starting", "address", ln.Addr().String(), "label", lc.Label,
+				"tls", lc.CertFile != "", "proxy_protocol", lc.ProxyProtocol)
+			serverErrors <- server.Serve(ln)
 		}()
 	}
 
+	// Expire old logs and rollups now and then daily
+	expiry := retention.NewManager(db, policy)
+	expiry.Start()
+	defer expiry.Stop()
+
+	// Reload runtime settings on SIGHUP
+	reloader := &reloader{
+		args:      args,
+		cfg:       cfg,
+		logLevel:  logLevel,
+		retention: expiry,
+		db:        db,
+		router:    h,
+		bans:      bans,
+		alerts:    alerts,
+	}
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
 	// Channel to listen for interrupt or terminate signals
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 
 	// Block until we receive a signal or an error
-	select {
-	case err := <-serverErrors:
-		return fmt.Errorf("server error: %w", err)
-
-	case sig := <-shutdown:
-		slog.Info("Shutdown signal received", "signal", sig.String())
-
-		// Give outstanding requests a deadline for completion
-		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-		defer cancel()
-
-		// Attempt graceful shutdown
-		if err := server.Shutdown(ctx); err != nil {
-			// Force close if graceful shutdown fails
-			if closeErr := server.Close(); closeErr != nil {
-				slog.Error("Failed to force close server", "error", closeErr)
+	for {
+		select {
+		case err := <-serverErrors:
+			return fmt.Errorf("server error: %w", err)
+
+		case err := <-collectorErrors:
+			if err != nil {
+				return fmt.Errorf("collector error: %w", err)
 			}
-			return fmt.Errorf("could not gracefully shutdown server: %w", err)
+
+		case <-hangup:
+			reloader.reload()
+
+		case sig := <-shutdown:
+			return shutdownServers(servers, sig)
 		}
+	}
+}
+
+// shutdownServers gracefully stops the HTTP servers together after a
+// shutdown signal
+func shutdownServers(servers []*http.Server, sig os.Signal) error {
+	slog.Info("Shutdown signal received", "signal", sig.String())
+
+	// Give outstanding requests a deadline for completion
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
 
-		slog.Info("Server stopped gracefully")
+	// Attempt graceful shutdown
+	errs := make([]error, len(servers))
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := server.Shutdown(ctx); err != nil {
+				// Force close if graceful shutdown fails
+				if closeErr := server.Close(); closeErr != nil {
+					slog.Error("Failed to force close server", "error", closeErr)
+				}
+				errs[i] = err
+			}
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("could not gracefully shutdown server: %w", err)
 	}
 
+	slog.Info("Server stopped gracefully")
 	return nil
 }
+
+// warnMetricsBehindProxy warns when a trusted proxy may scrape /metrics
+// without the token: requests it forwards come from its address, so every
+// client behind it would be let in
+func warnMetricsBehindProxy(allowList []string, proxies []netip.Prefix) {
+	for _, cidr := range allowList {
+		allowed, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			continue
+		}
+		for _, proxy := range proxies {
+			if allowed.Overlaps(proxy) {
+				slog.Warn("The metrics allow-list covers a trusted proxy, letting every client behind it scrape /metrics; clear metrics.allow and use the token",
+					"allow", cidr, "proxy", proxy.String())
+				return
+			}
+		}
+	}
+}
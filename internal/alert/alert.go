@@ -0,0 +1,299 @@
+// Package alert evaluates alerting rules on incoming requests and on the
+// logged aggregates, and sends the alerts they raise to webhooks
+package alert
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/clientip"
+	"github.com/dangogh/silver-eureka/internal/config"
+	"github.com/dangogh/silver-eureka/internal/database"
+	"github.com/dangogh/silver-eureka/internal/metrics"
+)
+
+// Store answers the aggregate rules
+type Store interface {
+	NewSources(since time.Time, minURLs int) ([]database.NewSource, error)
+	URLRates(recent time.Time, minRecent int, baselineFrom, baselineTo time.Time) ([]database.URLRate, error)
+	CountRequestsSince(since time.Time) (int64, error)
+}
+
+// Alert is raised by a rule
+type Alert struct {
+	Rule    string `json:"rule"`
+	Type    string `json:"type"`
+	Subject string `json:"subject,omitempty"` // the IP, URL or pattern alerted on
+	Summary string `json:"summary"`
+	// Details are the figures behind the alert, by name
+	Details map[string]any `json:"details,omitempty"`
+	// Suppressed counts the repeats held back by the cooldown since the
+	// last notification of this alert
+	Suppressed int       `json:"suppressed"`
+	Time       time.Time `json:"time"`
+}
+
+// key identifies repeats of an alert
+func (a Alert) key() string {
+	return a.Rule + "\x00" + a.Subject
+}
+
+// Engine evaluates the rules: signature rules on each request, the others
+// on the logged requests every interval. Repeats of an alert within the
+// cooldown are counted rather than sent.
+type Engine struct {
+	store    Store
+	notifier *notifier
+	// anonymize rewrites the client addresses of signature alerts
+	anonymize func(ip string) string
+
+	mu         sync.Mutex
+	cfg        config.AlertConfig
+	sent       map[string]time.Time // last notification per alert key
+	suppressed map[string]int       // repeats held back per alert key
+
+	done chan struct{}
+	once sync.Once
+	now  func() time.Time
+}
+
+// NewEngine creates an Engine; Start begins evaluating the aggregate rules
+func NewEngine(store Store, cfg config.AlertConfig) (*Engine, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &Engine{
+		store:      store,
+		notifier:   newNotifier(),
+		cfg:        cfg,
+		sent:       make(map[string]time.Time),
+		suppressed: make(map[string]int),
+		done:       make(chan struct{}),
+		now:        time.Now,
+	}, nil
+}
+
+// SetConfig replaces the rules, webhooks and delivery settings. Cooldowns
+// already running are kept.
+func (e *Engine) SetConfig(cfg config.AlertConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	e.cfg = cfg
+	e.mu.Unlock()
+	return nil
+}
+
+// config returns the current settings
+func (e *Engine) config() config.AlertConfig {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.cfg
+}
+
+// Start evaluates the aggregate rules every interval until Stop is called
+func (e *Engine) Start() {
+	e.notifier.start()
+	go func() {
+		for {
+			timer := time.NewTimer(e.config().Interval)
+			select {
+			case <-timer.C:
+				e.evaluate()
+			case <-e.done:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends evaluation and abandons deliveries still queued or retrying
+func (e *Engine) Stop() {
+	e.once.Do(func() {
+		close(e.done)
+		e.notifier.stop()
+	})
+}
+
+// AnonymizeWith sets how the client addresses of requests are rewritten
+// in the alerts they raise. It must be called before Middleware is used.
+func (e *Engine) AnonymizeWith(fn func(ip string) string) {
+	e.anonymize = fn
+}
+
+// Middleware checks each request against the signature rules
+func (e *Engine) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := clientip.FromRequest(r)
+			if e.anonymize != nil {
+				ip = e.anonymize(ip)
+			}
+			e.Observe(ip, r.URL.String(), r.UserAgent())
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Observe raises an alert for each signature rule with a pattern found in
+// url or userAgent
+func (e *Engine) Observe(ip, url, userAgent string) {
+	cfg := e.config()
+	if !cfg.Enabled() {
+		return
+	}
+	lowerURL, lowerAgent := strings.ToLower(url), strings.ToLower(userAgent)
+	for _, rule := range cfg.Rules {
+		if rule.Type != config.AlertSignature {
+			continue
+		}
+		for _, pattern := range rule.Patterns {
+			p := strings.ToLower(pattern)
+			if !strings.Contains(lowerURL, p) && !strings.Contains(lowerAgent, p) {
+				continue
+			}
+			e.raise(rule, Alert{
+				Subject: pattern,
+				Summary: fmt.Sprintf("Signature %q seen from %s", pattern, ip),
+				Details: map[string]any{"ip_address": ip, "url": url, "user_agent": userAgent},
+			})
+			break
+		}
+	}
+}
+
+// evaluate checks the aggregate rules once and drops cooldowns that have
+// run out
+func (e *Engine) evaluate() {
+	cfg := e.config()
+	if !cfg.Enabled() {
+		return
+	}
+	now := e.now()
+	for _, rule := range cfg.Rules {
+		var err error
+		switch rule.Type {
+		case config.AlertNewIPURLs:
+			err = e.checkNewSources(rule, now)
+		case config.AlertURLSpike:
+			err = e.checkURLSpikes(rule, now)
+		case config.AlertRequestRate:
+			err = e.checkRequestRate(rule, now)
+		}
+		if err != nil {
+			slog.Error("Failed to evaluate alert rule", "rule", rule.Name, "error", err)
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for key, sent := range e.sent {
+		if now.Sub(sent) >= e.longestCooldown() {
+			delete(e.sent, key)
+			delete(e.suppressed, key)
+		}
+	}
+}
+
+// longestCooldown returns the longest cooldown of any rule; e.mu is held
+func (e *Engine) longestCooldown() time.Duration {
+	longest := e.cfg.Cooldown
+	for _, rule := range e.cfg.Rules {
+		longest = max(longest, rule.Cooldown)
+	}
+	return longest
+}
+
+// checkNewSources raises an alert for each new address requesting more
+// than the threshold of URLs within the window
+func (e *Engine) checkNewSources(rule config.AlertRule, now time.Time) error {
+	sources, err := e.store.NewSources(now.Add(-rule.Window), rule.Threshold)
+	if err != nil {
+		return err
+	}
+	for _, s := range sources {
+		e.raise(rule, Alert{
+			Subject: s.IPAddress,
+			Summary: fmt.Sprintf("New source %s requested %d URLs within %s", s.IPAddress, s.URLs, rule.Window),
+			Details: map[string]any{"ip_address": s.IPAddress, "urls": s.URLs, "requests": s.Requests},
+		})
+	}
+	return nil
+}
+
+// checkURLSpikes raises an alert for each URL requested at least the
+// threshold of times within the window and more than factor times as
+// often as over the baseline hours
+func (e *Engine) checkURLSpikes(rule config.AlertRule, now time.Time) error {
+	baselineTo := now.UTC().Truncate(time.Hour)
+	rates, err := e.store.URLRates(now.Add(-rule.Window), rule.Threshold, baselineTo.Add(-rule.Baseline), baselineTo)
+	if err != nil {
+		return err
+	}
+	for _, r := range rates {
+		usual := float64(r.Baseline) * float64(rule.Window) / float64(rule.Baseline)
+		if float64(r.Recent) <= rule.Factor*usual {
+			continue
+		}
+		e.raise(rule, Alert{
+			Subject: r.URL,
+			Summary: fmt.Sprintf("%s requested %d times within %s, usually %.1f", r.URL, r.Recent, rule.Window, usual),
+			Details: map[string]any{"url": r.URL, "requests": r.Recent, "usual": math.Round(usual*100) / 100},
+		})
+	}
+	return nil
+}
+
+// checkRequestRate raises an alert when more than the threshold of
+// requests were logged within the window
+func (e *Engine) checkRequestRate(rule config.AlertRule, now time.Time) error {
+	n, err := e.store.CountRequestsSince(now.Add(-rule.Window))
+	if err != nil {
+		return err
+	}
+	if n <= int64(rule.Threshold) {
+		return nil
+	}
+	e.raise(rule, Alert{
+		Summary: fmt.Sprintf("%d requests within %s, above %d", n, rule.Window, rule.Threshold),
+		Details: map[string]any{"requests": n, "threshold": rule.Threshold},
+	})
+	return nil
+}
+
+// raise sends a rule's alert to every webhook unless the same alert was
+// sent within the cooldown, in which case it is counted as suppressed
+func (e *Engine) raise(rule config.AlertRule, a Alert) {
+	a.Rule, a.Type, a.Time = rule.Name, rule.Type, e.now().UTC()
+	key := a.key()
+
+	e.mu.Lock()
+	cooldown := e.cfg.Cooldown
+	if rule.Cooldown > 0 {
+		cooldown = rule.Cooldown
+	}
+	if sent, ok := e.sent[key]; ok && a.Time.Sub(sent) < cooldown {
+		e.suppressed[key]++
+		e.mu.Unlock()
+		metrics.AlertsRaised.WithLabelValues(rule.Name, "suppressed").Inc()
+		return
+	}
+	a.Suppressed = e.suppressed[key]
+	e.sent[key] = a.Time
+	delete(e.suppressed, key)
+	cfg := e.cfg
+	e.mu.Unlock()
+
+	metrics.AlertsRaised.WithLabelValues(rule.Name, "sent").Inc()
+	slog.Warn("Alert raised", "rule", a.Rule, "subject", a.Subject, "summary", a.Summary, "suppressed", a.Suppressed)
+	for _, w := range cfg.Webhooks {
+		e.notifier.enqueue(delivery{webhook: w, alert: a, cfg: cfg})
+	}
+}
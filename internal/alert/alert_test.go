@@ -0,0 +1,222 @@
+package alert
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/config"
+	"github.com/dangogh/silver-eureka/internal/database"
+)
+
+// receiver is a stub webhook endpoint recording the bodies it is sent and
+// answering with the queued status codes, then 200
+type receiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	bodies   [][]byte
+	statuses []int
+	got      chan struct{}
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	t.Helper()
+	r := &receiver{statuses: statuses, got: make(chan struct{}, 100)}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			t.Errorf("Failed to read webhook body: %v", err)
+		}
+		r.mu.Lock()
+		r.bodies = append(r.bodies, body)
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		r.mu.Unlock()
+		w.WriteHeader(status)
+		r.got <- struct{}{}
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// wait waits for n more requests
+func (r *receiver) wait(t *testing.T, n int) {
+	t.Helper()
+	for range n {
+		select {
+		case <-r.got:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for a webhook request")
+		}
+	}
+}
+
+// alerts decodes the JSON alerts received
+func (r *receiver) alerts(t *testing.T) []Alert {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	alerts := make([]Alert, len(r.bodies))
+	for i, body := range r.bodies {
+		if err := json.Unmarshal(body, &alerts[i]); err != nil {
+			t.Fatalf("Failed to decode alert %s: %v", body, err)
+		}
+	}
+	return alerts
+}
+
+// fakeStore answers the aggregate rules with fixed results
+type fakeStore struct {
+	sources  []database.NewSource
+	rates    []database.URLRate
+	requests int64
+}
+
+func (s *fakeStore) NewSources(since time.Time, minURLs int) ([]database.NewSource, error) {
+	return s.sources, nil
+}
+
+func (s *fakeStore) URLRates(recent time.Time, minRecent int, baselineFrom, baselineTo time.Time) ([]database.URLRate, error) {
+	return s.rates, nil
+}
+
+func (s *fakeStore) CountRequestsSince(since time.Time) (int64, error) {
+	return s.requests, nil
+}
+
+func newTestEngine(t *testing.T, store Store, url string, rules ...config.AlertRule) (*Engine, *time.Time) {
+	t.Helper()
+	cfg := config.DefaultAlertConfig()
+	cfg.Interval = time.Hour
+	cfg.RetryBackoff = time.Millisecond
+	cfg.Webhooks = []config.Webhook{{Name: "stub", URL: url}}
+	cfg.Rules = rules
+	e, err := NewEngine(store, cfg)
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+	now := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	e.now = func() time.Time { return now }
+	e.Start()
+	t.Cleanup(e.Stop)
+	return e, &now
+}
+
+func TestEngine_SignatureCooldown(t *testing.T) {
+	recv := newReceiver(t)
+	e, now := newTestEngine(t, &fakeStore{}, recv.URL, config.AlertRule{
+		Name: "log4shell", Type: config.AlertSignature, Patterns: []string{"${JNDI:"},
+	})
+	e.AnonymizeWith(func(ip string) string { return "anon:" + ip })
+	handler := e.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	request := func(userAgent string) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "192.0.2.1:4000"
+		req.Header.Set("User-Agent", userAgent)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	request("${jndi:ldap://x/a}")
+	recv.wait(t, 1)
+	request("Mozilla/5.0")        // no match
+	request("${jndi:ldap://x/b}") // within the cooldown
+	request("${jndi:ldap://x/c}")
+	*now = now.Add(config.DefaultAlertConfig().Cooldown)
+	request("${jndi:ldap://x/d}")
+	recv.wait(t, 1)
+
+	alerts := recv.alerts(t)
+	if len(alerts) != 2 {
+		t.Fatalf("Expected 2 notifications, got %+v", alerts)
+	}
+	first, second := alerts[0], alerts[1]
+	if first.Rule != "log4shell" || first.Type != config.AlertSignature || first.Subject != "${JNDI:" ||
+		first.Details["ip_address"] != "anon:192.0.2.1" || first.Details["user_agent"] != "${jndi:ldap://x/a}" {
+		t.Errorf("Unexpected first alert: %+v", first)
+	}
+	if first.Suppressed != 0 || second.Suppressed != 2 {
+		t.Errorf("Expected 0 then 2 suppressed repeats, got %d and %d", first.Suppressed, second.Suppressed)
+	}
+}
+
+func TestEngine_AggregateRules(t *testing.T) {
+	recv := newReceiver(t)
+	store := &fakeStore{
+		sources: []database.NewSource{{IPAddress: "198.51.100.9", URLs: 40, Requests: 52}},
+		rates: []database.URLRate{
+			{URL: "/wp-login.php", Recent: 30, Baseline: 144}, // usual 1 per 10m
+			{URL: "/", Recent: 30, Baseline: 1440},            // usual 10 per 10m
+		},
+		requests: 5000,
+	}
+	e, now := newTestEngine(t, store, recv.URL,
+		config.AlertRule{Name: "scanner", Type: config.AlertNewIPURLs, Threshold: 20, Window: 10 * time.Minute},
+		config.AlertRule{Name: "spike", Type: config.AlertURLSpike, Threshold: 10, Window: 10 * time.Minute, Baseline: 24 * time.Hour, Factor: 5},
+		config.AlertRule{Name: "flood", Type: config.AlertRequestRate, Threshold: 1000, Window: time.Minute, Cooldown: time.Minute},
+	)
+
+	e.evaluate()
+	recv.wait(t, 3)
+	got := make(map[string]Alert)
+	for _, a := range recv.alerts(t) {
+		got[a.Rule+" "+a.Subject] = a
+	}
+	if a, ok := got["scanner 198.51.100.9"]; !ok || a.Details["urls"] != float64(40) {
+		t.Errorf("Expected a new source alert, got %+v", got)
+	}
+	if a, ok := got["spike /wp-login.php"]; !ok || a.Details["usual"] != float64(1) {
+		t.Errorf("Expected a URL spike alert, got %+v", got)
+	}
+	if _, ok := got["spike /"]; ok {
+		t.Error("Expected no alert for a URL within its usual rate")
+	}
+	if _, ok := got["flood "]; !ok {
+		t.Errorf("Expected a request rate alert, got %+v", got)
+	}
+
+	// Only the rule with the shorter cooldown repeats
+	*now = now.Add(2 * time.Minute)
+	store.requests = 900
+	e.evaluate()
+	store.requests = 1200
+	e.evaluate()
+	recv.wait(t, 1)
+	alerts := recv.alerts(t)
+	if last := alerts[len(alerts)-1]; len(alerts) != 4 || last.Rule != "flood" || last.Suppressed != 0 {
+		t.Errorf("Expected one more request rate alert, got %+v", alerts)
+	}
+}
+
+func TestEngine_SetConfig(t *testing.T) {
+	recv := newReceiver(t)
+	e, _ := newTestEngine(t, &fakeStore{}, recv.URL, config.AlertRule{
+		Name: "probe", Type: config.AlertSignature, Patterns: []string{"/.env"},
+	})
+
+	cfg := e.config()
+	cfg.Rules = nil
+	if err := e.SetConfig(cfg); err != nil {
+		t.Fatalf("SetConfig failed: %v", err)
+	}
+	e.Observe("192.0.2.1", "/.env", "")
+
+	cfg.Rules = []config.AlertRule{{Name: "probe", Type: "tag"}}
+	if err := e.SetConfig(cfg); err == nil {
+		t.Error("Expected an invalid rule to be rejected")
+	}
+
+	select {
+	case <-recv.got:
+		t.Error("Expected no notification without rules")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
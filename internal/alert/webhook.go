@@ -0,0 +1,246 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/config"
+	"github.com/dangogh/silver-eureka/internal/metrics"
+)
+
+const (
+	// queueSize is the number of deliveries that can wait; more are dropped
+	queueSize = 100
+
+	// workers deliver concurrently so that one slow webhook doesn't hold
+	// up the others
+	workers = 4
+)
+
+// delivery is an alert on its way to a webhook, with the settings in
+// effect when it was raised
+type delivery struct {
+	webhook config.Webhook
+	alert   Alert
+	cfg     config.AlertConfig
+}
+
+// notifier delivers alerts to webhooks, retrying failures with backoff
+type notifier struct {
+	client *http.Client
+	queue  chan delivery
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// newNotifier creates a notifier; start runs its workers
+func newNotifier() *notifier {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &notifier{
+		client: &http.Client{},
+		queue:  make(chan delivery, queueSize),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// start runs the delivery workers
+func (n *notifier) start() {
+	for range workers {
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			for {
+				select {
+				case d := <-n.queue:
+					n.deliver(d)
+				case <-n.ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+}
+
+// stop cancels deliveries in progress and waits for the workers to exit
+func (n *notifier) stop() {
+	n.cancel()
+	n.wg.Wait()
+}
+
+// enqueue queues a delivery, dropping it when the queue is full
+func (n *notifier) enqueue(d delivery) {
+	select {
+	case n.queue <- d:
+	default:
+		metrics.AlertDeliveries.WithLabelValues("dropped").Inc()
+		slog.Error("Alert queue full, notification dropped", "webhook", webhookName(d.webhook), "rule", d.alert.Rule)
+	}
+}
+
+// deliver sends d, retrying network errors, 429 and 5xx responses
+func (n *notifier) deliver(d delivery) {
+	body, err := payload(d.webhook.Format, d.alert)
+	if err != nil {
+		metrics.AlertDeliveries.WithLabelValues("failed").Inc()
+		slog.Error("Failed to format alert", "webhook", webhookName(d.webhook), "error", err)
+		return
+	}
+
+	backoff := d.cfg.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := n.post(d.webhook.URL, body, d.cfg.Timeout)
+		if err == nil {
+			metrics.AlertDeliveries.WithLabelValues("ok").Inc()
+			return
+		}
+		if !retry || attempt >= d.cfg.Retries {
+			metrics.AlertDeliveries.WithLabelValues("failed").Inc()
+			slog.Error("Alert delivery failed",
+				"webhook", webhookName(d.webhook),
+				"rule", d.alert.Rule,
+				"attempts", attempt+1,
+				"error", err,
+			)
+			return
+		}
+		metrics.AlertDeliveries.WithLabelValues("retry").Inc()
+		slog.Warn("Alert delivery failed, retrying", "webhook", webhookName(d.webhook), "in", backoff.String(), "error", err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-n.ctx.Done():
+			timer.Stop()
+			return
+		}
+		backoff *= 2
+	}
+}
+
+// post sends body to target and reports whether a failure is worth retrying
+func (n *notifier) post(target string, body []byte, timeout time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(n.ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("invalid webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		// The URL is left out: webhook URLs carry their credentials
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return n.ctx.Err() == nil, err
+	}
+	defer func() {
+		if _, err := io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)); err != nil {
+			// Draining only lets the connection be reused
+		}
+		if err := resp.Body.Close(); err != nil {
+			// Nothing left to read
+		}
+	}()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook returned %s", resp.Status)
+	default:
+		return false, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+}
+
+// webhookName names a webhook in logs without revealing its URL
+func webhookName(w config.Webhook) string {
+	if w.Name != "" {
+		return w.Name
+	}
+	u, err := url.Parse(w.URL)
+	if err != nil {
+		return "webhook"
+	}
+	return u.Host
+}
+
+// payload renders an alert in a webhook format
+func payload(format string, a Alert) ([]byte, error) {
+	switch format {
+	case config.WebhookSlack:
+		return json.Marshal(slackMessage(a))
+	case config.WebhookTeams:
+		return json.Marshal(teamsCard(a))
+	default:
+		return json.Marshal(a)
+	}
+}
+
+// fact is a named value shown in chat messages
+type fact struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// facts lists an alert's details, sorted by name, and the repeats
+// suppressed since the last notification
+func facts(a Alert) []fact {
+	names := make([]string, 0, len(a.Details))
+	for name := range a.Details {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := make([]fact, 0, len(names)+1)
+	for _, name := range names {
+		list = append(list, fact{name, fmt.Sprint(a.Details[name])})
+	}
+	if a.Suppressed > 0 {
+		list = append(list, fact{"suppressed", fmt.Sprint(a.Suppressed)})
+	}
+	return list
+}
+
+// slackEscaper escapes the characters Slack reads as markup
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// slackMessage renders an alert as a Slack incoming webhook message
+func slackMessage(a Alert) map[string]any {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*Alert: %s*\n%s", slackEscaper.Replace(a.Rule), slackEscaper.Replace(a.Summary))
+	for _, f := range facts(a) {
+		fmt.Fprintf(&b, "\n• %s: `%s`", f.Name, slackEscaper.Replace(strings.ReplaceAll(f.Value, "`", "'")))
+	}
+	return map[string]any{"text": b.String()}
+}
+
+// teamsCard renders an alert as a Microsoft Teams message card
+func teamsCard(a Alert) map[string]any {
+	return map[string]any{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"summary":    a.Summary,
+		"themeColor": "D9534F",
+		"title":      "Alert: " + a.Rule,
+		"sections": []map[string]any{{
+			"activityTitle":    a.Summary,
+			"activitySubtitle": a.Time.Format(time.RFC3339),
+			"facts":            facts(a),
+		}},
+	}
+}
@@ -0,0 +1,142 @@
+// Package anonymize replaces client IP addresses before they are stored:
+// truncated to their network, or pseudonymized with a keyed HMAC.
+package anonymize
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+)
+
+// Anonymization modes
+const (
+	Raw      = "raw"      // addresses are stored as seen
+	Truncate = "truncate" // IPv4 to its /24, IPv6 to its /48
+	HMAC     = "hmac"     // a keyed pseudonym per address
+)
+
+// Prefix lengths kept by Truncate
+const (
+	IPv4Prefix = 24
+	IPv6Prefix = 48
+)
+
+// ValidMode reports whether mode is one of the anonymization modes
+func ValidMode(mode string) bool {
+	return mode == Raw || mode == Truncate || mode == HMAC
+}
+
+// Anonymizer rewrites addresses in one mode. A nil Anonymizer stores them raw.
+type Anonymizer struct {
+	mode string
+	keys []hmacKey // newest first
+}
+
+// hmacKey is an HMAC key and its identifier
+type hmacKey struct {
+	key []byte
+	id  string
+}
+
+// New returns an Anonymizer for mode. HMAC needs at least one key, newest
+// first: the first makes new pseudonyms, and the older ones are kept so
+// that Pseudonyms still finds what they made. Pseudonyms name their key,
+// and the same address gets a new pseudonym under each key.
+func New(mode string, keys []string) (*Anonymizer, error) {
+	switch mode {
+	case Raw, Truncate:
+		return &Anonymizer{mode: mode}, nil
+	case HMAC:
+		if len(keys) == 0 {
+			return nil, fmt.Errorf("hmac mode needs a key")
+		}
+		a := &Anonymizer{mode: mode}
+		for i, key := range keys {
+			if key == "" {
+				return nil, fmt.Errorf("hmac key %d is empty", i)
+			}
+			a.keys = append(a.keys, hmacKey{key: []byte(key), id: KeyID(key)})
+		}
+		return a, nil
+	default:
+		return nil, fmt.Errorf("unknown anonymization mode %q (want raw, truncate or hmac)", mode)
+	}
+}
+
+// KeyID returns the short identifier of an HMAC key used in pseudonyms
+func KeyID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:4])
+}
+
+// Mode returns the anonymization mode
+func (a *Anonymizer) Mode() string {
+	if a == nil {
+		return Raw
+	}
+	return a.mode
+}
+
+// KeyID returns the identifier of the HMAC key in use, or "" in other modes
+func (a *Anonymizer) KeyID() string {
+	if a == nil || len(a.keys) == 0 {
+		return ""
+	}
+	return a.keys[0].id
+}
+
+// Anonymize returns the address to store for ip. Values that are not IP
+// addresses, such as existing pseudonyms, are returned unchanged.
+func (a *Anonymizer) Anonymize(ip string) string {
+	if a == nil || a.mode == Raw {
+		return ip
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap().WithZone("")
+
+	if a.mode == Truncate {
+		bits := IPv6Prefix
+		if addr.Is4() {
+			bits = IPv4Prefix
+		}
+		prefix, err := addr.Prefix(bits)
+		if err != nil {
+			// bits is valid for the address family
+			return ip
+		}
+		return prefix.Addr().String()
+	}
+
+	return a.keys[0].pseudonym(addr)
+}
+
+// Pseudonyms returns the pseudonyms of ip under each HMAC key, the current
+// one first, so that an address can be looked up across rotations. It
+// returns nil in other modes and for values that are not IP addresses.
+func (a *Anonymizer) Pseudonyms(ip string) []string {
+	if a == nil || a.mode != HMAC {
+		return nil
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil
+	}
+	addr = addr.Unmap().WithZone("")
+	pseudonyms := make([]string, len(a.keys))
+	for i, k := range a.keys {
+		pseudonyms[i] = k.pseudonym(addr)
+	}
+	return pseudonyms
+}
+
+// pseudonym returns the pseudonym of addr under k
+func (k hmacKey) pseudonym(addr netip.Addr) string {
+	mac := hmac.New(sha256.New, k.key)
+	mac.Write(addr.AsSlice())
+	return "h:" + k.id + ":" + hex.EncodeToString(mac.Sum(nil)[:8])
+}
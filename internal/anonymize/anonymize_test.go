@@ -0,0 +1,84 @@
+package anonymize
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAnonymize_Truncate(t *testing.T) {
+	a, err := New(Truncate, nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	tests := map[string]string{
+		"192.0.2.77":              "192.0.2.0",
+		"::ffff:198.51.100.9":     "198.51.100.0",
+		"2001:db8:abcd:12::1":     "2001:db8:abcd::",
+		"fe80::1%eth0":            "fe80::",
+		"unknown":                 "unknown",
+		"h:0123abcd:00112233aabb": "h:0123abcd:00112233aabb",
+	}
+	for ip, want := range tests {
+		if got := a.Anonymize(ip); got != want {
+			t.Errorf("Anonymize(%q) = %q, want %q", ip, got, want)
+		}
+	}
+}
+
+func TestAnonymize_HMAC(t *testing.T) {
+	a, err := New(HMAC, []string{"first-key-0123456789"})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	p := a.Anonymize("192.0.2.1")
+	if !strings.HasPrefix(p, "h:"+KeyID("first-key-0123456789")+":") || len(p) != 27 {
+		t.Errorf("Unexpected pseudonym %q", p)
+	}
+	if a.Anonymize("::ffff:192.0.2.1") != p {
+		t.Error("Expected IPv4-mapped addresses to get the IPv4 pseudonym")
+	}
+	if a.Anonymize("192.0.2.2") == p {
+		t.Error("Expected different addresses to get different pseudonyms")
+	}
+	if a.Anonymize(p) != p {
+		t.Error("Expected pseudonyms to be left alone")
+	}
+
+	// A rotated key gives new pseudonyms
+	rotated, err := New(HMAC, []string{"second-key-0123456789", "first-key-0123456789"})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if rotated.Anonymize("192.0.2.1") == p {
+		t.Error("Expected a new pseudonym after key rotation")
+	}
+	if rotated.KeyID() != KeyID("second-key-0123456789") {
+		t.Errorf("Expected the first key in use, got %s", rotated.KeyID())
+	}
+
+	// The older key still finds what it made
+	pseudonyms := rotated.Pseudonyms("::ffff:192.0.2.1")
+	if len(pseudonyms) != 2 || pseudonyms[0] != rotated.Anonymize("192.0.2.1") || pseudonyms[1] != p {
+		t.Errorf("Expected the pseudonyms under both keys, got %v", pseudonyms)
+	}
+	if a.Pseudonyms(p) != nil {
+		t.Error("Expected no pseudonyms for a value that is not an address")
+	}
+	if truncate, _ := New(Truncate, nil); truncate.Pseudonyms("192.0.2.1") != nil {
+		t.Error("Expected no pseudonyms outside hmac mode")
+	}
+
+	if _, err := New(HMAC, nil); err == nil {
+		t.Error("Expected hmac mode without a key to fail")
+	}
+	if _, err := New(HMAC, []string{"first-key-0123456789", ""}); err == nil {
+		t.Error("Expected an empty key to fail")
+	}
+}
+
+func TestAnonymize_Raw(t *testing.T) {
+	var a *Anonymizer
+	if a.Mode() != Raw || a.Anonymize("192.0.2.1") != "192.0.2.1" {
+		t.Error("Expected a nil Anonymizer to keep addresses raw")
+	}
+}
@@ -0,0 +1,314 @@
+package auth
+
+import (
+	"container/list"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/database"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// iterations is the PBKDF2-HMAC-SHA256 work factor for new hashes
+	iterations = 600000
+
+	// cacheTTL is how long a verified password is remembered, so Basic
+	// Auth on every API request doesn't pay for PBKDF2 each time
+	cacheTTL = 5 * time.Minute
+
+	// tokenPrefix marks API tokens so they are recognisable in configs and logs
+	tokenPrefix = "ge_"
+
+	// usersRefresh is how often the stored users and tokens are counted
+	// again, so one added while serving takes effect within it
+	usersRefresh = 10 * time.Second
+
+	// maxFailures is how many failed logins a client may make in a row
+	// before it must wait failureInterval for each further attempt
+	maxFailures     = 10
+	failureInterval = 6 * time.Second
+
+	// maxFailureClients bounds the clients whose failed logins are tracked;
+	// beyond it the one that failed least recently is forgotten
+	maxFailureClients = 10000
+)
+
+// HashPassword returns an encoded PBKDF2-SHA256 hash of password:
+// "pbkdf2-sha256$<iterations>$<salt>$<key>"
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+	return hashWith(password, salt, iterations)
+}
+
+func hashWith(password string, salt []byte, iter int) (string, error) {
+	key, err := pbkdf2.Key(sha256.New, password, salt, iter, 32)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", iter, enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+}
+
+// CheckPassword reports whether password matches an encoded hash
+func CheckPassword(encoded, password string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iter, err := strconv.Atoi(parts[1])
+	if err != nil || iter < 1 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	candidate, err := hashWith(password, salt, iter)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(candidate), []byte(encoded)) == 1
+}
+
+// NewToken generates an API token and the hash to store for it
+func NewToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	token = tokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return token, HashToken(token), nil
+}
+
+// HashToken returns the stored form of an API token. Tokens are long and
+// random, so a fast hash is enough.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Store looks up stored credentials
+type Store interface {
+	GetUser(username string) (*database.User, error)
+	GetAPITokenByHash(tokenHash string) (*database.APIToken, error)
+	CountCredentials() (int, error)
+	CountUsers() (int, error)
+}
+
+// Authenticator checks credentials against the configured admin account
+// and the users and API tokens in the store
+type Authenticator struct {
+	store    Store
+	username string
+	password string
+
+	mu                 sync.Mutex
+	verified           map[[32]byte]verifiedLogin // recently verified user/password pairs
+	hasUsers           bool                       // whether the store held users when last counted
+	usersChecked       time.Time                  // when the users were last counted
+	hasCredentials     bool                       // whether the store held users or tokens when last counted
+	credentialsChecked time.Time                  // when the users and tokens were last counted
+	failures           map[string]*list.Element   // failed login limiters per client
+	failed             *list.List                 // failure entries, front = most recent
+
+	now   func() time.Time
+	check func(encoded, password string) bool
+}
+
+// verifiedLogin remembers a verified password until it expires, or until
+// the user's stored hash changes
+type verifiedLogin struct {
+	hash    string
+	expires time.Time
+}
+
+// failureEntry limits one client's failed logins
+type failureEntry struct {
+	client  string
+	limiter *rate.Limiter
+}
+
+// New creates an Authenticator. Authentication is enabled when an admin
+// account is configured or the store holds any users or tokens.
+func New(store Store, username, password string) *Authenticator {
+	return &Authenticator{
+		store:    store,
+		username: username,
+		password: password,
+		verified: make(map[[32]byte]verifiedLogin),
+		failures: make(map[string]*list.Element),
+		failed:   list.New(),
+		now:      time.Now,
+		check:    CheckPassword,
+	}
+}
+
+// Enabled reports whether protected endpoints require credentials. The
+// stored users and tokens are counted at most every usersRefresh, so the
+// first one added while serving turns authentication on; if the store
+// can't be read it is on, so a database error never opens the API.
+func (a *Authenticator) Enabled() bool {
+	if a.username != "" && a.password != "" {
+		return true
+	}
+	if a.store == nil {
+		return false
+	}
+	now := a.now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if now.Sub(a.credentialsChecked) < usersRefresh {
+		return a.hasCredentials
+	}
+	n, err := a.store.CountCredentials()
+	if err != nil {
+		slog.Error("Failed to count stored credentials", "error", err)
+		return true
+	}
+	a.hasCredentials, a.credentialsChecked = n > 0, now
+	return a.hasCredentials
+}
+
+// dummyHash is checked for unknown users so they take as long as known ones
+var dummyHash = sync.OnceValue(func() string {
+	h, _ := hashWith("", make([]byte, 16), iterations) // fixed parameters can't fail
+	return h
+})
+
+// CheckPassword reports whether username and password are valid. Once
+// client has failed too many logins it is refused without checking, so
+// guessing can't keep the server busy hashing.
+func (a *Authenticator) CheckPassword(client, username, password string) bool {
+	now := a.now()
+	if !a.allowAttempt(client, now) {
+		return false
+	}
+	if a.checkPassword(username, password, now) {
+		return true
+	}
+	a.fail(client, now)
+	return false
+}
+
+// checkPassword checks username and password against the admin account and
+// the stored users
+func (a *Authenticator) checkPassword(username, password string, now time.Time) bool {
+	if a.username != "" && a.password != "" {
+		userMatch := subtle.ConstantTimeCompare([]byte(username), []byte(a.username)) == 1
+		passMatch := subtle.ConstantTimeCompare([]byte(password), []byte(a.password)) == 1
+		if userMatch && passMatch {
+			return true
+		}
+	}
+	if a.store == nil || username == "" || !a.storeHasUsers(now) {
+		return false
+	}
+
+	user, err := a.store.GetUser(username)
+	if err != nil {
+		slog.Error("Failed to look up user", "error", err)
+		return false
+	}
+	if user == nil {
+		a.check(dummyHash(), password)
+		return false
+	}
+
+	// A cached login only counts while the stored hash is unchanged, so a
+	// changed password or deleted user takes effect at once
+	key := sha256.Sum256([]byte(username + "\x00" + password))
+	a.mu.Lock()
+	login, ok := a.verified[key]
+	a.mu.Unlock()
+	if ok && now.Before(login.expires) && login.hash == user.PasswordHash {
+		return true
+	}
+
+	if !a.check(user.PasswordHash, password) {
+		return false
+	}
+
+	a.mu.Lock()
+	for k, l := range a.verified {
+		if !now.Before(l.expires) {
+			delete(a.verified, k)
+		}
+	}
+	a.verified[key] = verifiedLogin{hash: user.PasswordHash, expires: now.Add(cacheTTL)}
+	a.mu.Unlock()
+	return true
+}
+
+// storeHasUsers reports whether the store holds any users, counting them
+// at most every usersRefresh
+func (a *Authenticator) storeHasUsers(now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if now.Sub(a.usersChecked) < usersRefresh {
+		return a.hasUsers
+	}
+	n, err := a.store.CountUsers()
+	if err != nil {
+		slog.Error("Failed to count users", "error", err)
+		return a.hasUsers
+	}
+	a.hasUsers, a.usersChecked = n > 0, now
+	return a.hasUsers
+}
+
+// allowAttempt reports whether client may try to log in
+func (a *Authenticator) allowAttempt(client string, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	el, ok := a.failures[client]
+	return !ok || el.Value.(*failureEntry).limiter.TokensAt(now) >= 1
+}
+
+// fail counts a failed login against client
+func (a *Authenticator) fail(client string, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	el, ok := a.failures[client]
+	if ok {
+		a.failed.MoveToFront(el)
+	} else {
+		el = a.failed.PushFront(&failureEntry{
+			client:  client,
+			limiter: rate.NewLimiter(rate.Every(failureInterval), maxFailures),
+		})
+		a.failures[client] = el
+		for a.failed.Len() > maxFailureClients {
+			oldest := a.failed.Back()
+			a.failed.Remove(oldest)
+			delete(a.failures, oldest.Value.(*failureEntry).client)
+		}
+	}
+	el.Value.(*failureEntry).limiter.AllowN(now, 1)
+}
+
+// CheckToken reports whether token is a valid API token
+func (a *Authenticator) CheckToken(token string) bool {
+	if a.store == nil || !strings.HasPrefix(token, tokenPrefix) {
+		return false
+	}
+	t, err := a.store.GetAPITokenByHash(HashToken(token))
+	if err != nil {
+		slog.Error("Failed to look up API token", "error", err)
+		return false
+	}
+	return t != nil
+}
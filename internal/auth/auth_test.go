@@ -0,0 +1,258 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/database"
+)
+
+// fakeStore is an in-memory Store
+type fakeStore struct {
+	users   map[string]string // username -> password hash
+	tokens  map[string]string // token hash -> name
+	lookups int
+	counts  int
+}
+
+func (s *fakeStore) GetUser(username string) (*database.User, error) {
+	s.lookups++
+	hash, ok := s.users[username]
+	if !ok {
+		return nil, nil
+	}
+	return &database.User{Username: username, PasswordHash: hash}, nil
+}
+
+func (s *fakeStore) GetAPITokenByHash(tokenHash string) (*database.APIToken, error) {
+	name, ok := s.tokens[tokenHash]
+	if !ok {
+		return nil, nil
+	}
+	return &database.APIToken{Name: name, TokenHash: tokenHash}, nil
+}
+
+func (s *fakeStore) CountCredentials() (int, error) {
+	return len(s.users) + len(s.tokens), nil
+}
+
+func (s *fakeStore) CountUsers() (int, error) {
+	s.counts++
+	return len(s.users), nil
+}
+
+// fastHash hashes with few iterations to keep tests quick
+func fastHash(t *testing.T, password string) string {
+	t.Helper()
+	h, err := hashWith(password, []byte("0123456789abcdef"), 1000)
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	return h
+}
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("HashPassword failed: %v", err)
+	}
+	if !strings.HasPrefix(hash, "pbkdf2-sha256$600000$") {
+		t.Errorf("Unexpected hash format: %s", hash)
+	}
+	if !CheckPassword(hash, "correct horse") {
+		t.Error("Expected password to match its hash")
+	}
+	if CheckPassword(hash, "battery staple") {
+		t.Error("Expected wrong password to be rejected")
+	}
+
+	other, _ := HashPassword("correct horse")
+	if other == hash {
+		t.Error("Expected different salts to give different hashes")
+	}
+
+	for _, bad := range []string{"", "plain", "md5$1$a$b", "pbkdf2-sha256$x$a$b", "pbkdf2-sha256$10$!!$b"} {
+		if CheckPassword(bad, "") {
+			t.Errorf("Expected malformed hash %q to be rejected", bad)
+		}
+	}
+}
+
+func TestNewToken(t *testing.T) {
+	token, hash, err := NewToken()
+	if err != nil {
+		t.Fatalf("NewToken failed: %v", err)
+	}
+	if !strings.HasPrefix(token, tokenPrefix) {
+		t.Errorf("Expected token prefix %q, got %s", tokenPrefix, token)
+	}
+	if hash != HashToken(token) {
+		t.Error("Expected returned hash to match HashToken")
+	}
+	if other, _, _ := NewToken(); other == token {
+		t.Error("Expected tokens to be unique")
+	}
+}
+
+func TestAuthenticator(t *testing.T) {
+	token, tokenHash, _ := NewToken()
+	store := &fakeStore{
+		users:  map[string]string{"alice": fastHash(t, "alicepass")},
+		tokens: map[string]string{tokenHash: "ci"},
+	}
+	a := New(store, "admin", "secret")
+
+	if !a.Enabled() {
+		t.Fatal("Expected authenticator to be enabled")
+	}
+	if !a.CheckPassword("192.0.2.1", "admin", "secret") {
+		t.Error("Expected configured admin to be accepted")
+	}
+	if !a.CheckPassword("192.0.2.1", "alice", "alicepass") {
+		t.Error("Expected stored user to be accepted")
+	}
+	if a.CheckPassword("192.0.2.1", "alice", "wrong") {
+		t.Error("Expected wrong password to be rejected")
+	}
+	if a.CheckPassword("192.0.2.1", "admin", "alicepass") {
+		t.Error("Expected mismatched user and password to be rejected")
+	}
+	if !a.CheckToken(token) {
+		t.Error("Expected stored token to be accepted")
+	}
+	if a.CheckToken(tokenPrefix + "unknown") {
+		t.Error("Expected unknown token to be rejected")
+	}
+}
+
+func TestAuthenticator_Cache(t *testing.T) {
+	store := &fakeStore{users: map[string]string{"alice": fastHash(t, "alicepass")}}
+	a := New(store, "", "")
+	now := time.Now()
+	a.now = func() time.Time { return now }
+	hashes := 0
+	a.check = func(encoded, password string) bool {
+		hashes++
+		return CheckPassword(encoded, password)
+	}
+
+	for i := 0; i < 3; i++ {
+		if !a.CheckPassword("192.0.2.1", "alice", "alicepass") {
+			t.Fatal("Expected stored user to be accepted")
+		}
+	}
+	if hashes != 1 {
+		t.Errorf("Expected verified password to be cached, got %d hashes", hashes)
+	}
+
+	now = now.Add(cacheTTL)
+	if !a.CheckPassword("192.0.2.1", "alice", "alicepass") {
+		t.Fatal("Expected stored user to be accepted")
+	}
+	if hashes != 2 {
+		t.Errorf("Expected expired cache entry to be checked again, got %d hashes", hashes)
+	}
+
+	// A changed password or deleted user takes effect at once
+	store.users["alice"] = fastHash(t, "newpass")
+	if a.CheckPassword("192.0.2.1", "alice", "alicepass") {
+		t.Error("Expected the old password to be rejected after a change")
+	}
+	if !a.CheckPassword("192.0.2.1", "alice", "newpass") {
+		t.Error("Expected the new password to be accepted")
+	}
+	delete(store.users, "alice")
+	if a.CheckPassword("192.0.2.1", "alice", "newpass") {
+		t.Error("Expected a deleted user to be rejected")
+	}
+}
+
+func TestAuthenticator_NoUsers(t *testing.T) {
+	store := &fakeStore{}
+	a := New(store, "admin", "secret")
+	now := time.Now()
+	a.now = func() time.Time { return now }
+	hashes := 0
+	a.check = func(encoded, password string) bool {
+		hashes++
+		return false
+	}
+
+	for i := 0; i < 3; i++ {
+		a.CheckPassword("192.0.2.1", "alice", "guess")
+	}
+	if hashes != 0 || store.lookups != 0 {
+		t.Errorf("Expected no lookups or hashing without users, got %d lookups and %d hashes", store.lookups, hashes)
+	}
+	if store.counts != 1 {
+		t.Errorf("Expected users to be counted once, got %d", store.counts)
+	}
+
+	// A user added while serving is seen after the refresh
+	store.users = map[string]string{"alice": fastHash(t, "alicepass")}
+	a.check = CheckPassword
+	now = now.Add(usersRefresh)
+	if !a.CheckPassword("192.0.2.1", "alice", "alicepass") {
+		t.Error("Expected a new user to be accepted after the refresh")
+	}
+}
+
+func TestAuthenticator_FailureLimit(t *testing.T) {
+	store := &fakeStore{users: map[string]string{"alice": fastHash(t, "alicepass")}}
+	a := New(store, "admin", "secret")
+	now := time.Now()
+	a.now = func() time.Time { return now }
+
+	for i := 0; i < maxFailures; i++ {
+		a.CheckPassword("192.0.2.1", "alice", "guess")
+	}
+	lookups := store.lookups
+	if a.CheckPassword("192.0.2.1", "alice", "alicepass") || a.CheckPassword("192.0.2.1", "admin", "secret") {
+		t.Error("Expected a client with too many failures to be refused")
+	}
+	if store.lookups != lookups {
+		t.Error("Expected a refused client not to be checked")
+	}
+
+	// Other clients are unaffected, and the client may try again later
+	if !a.CheckPassword("192.0.2.2", "alice", "alicepass") {
+		t.Error("Expected another client to be accepted")
+	}
+	now = now.Add(failureInterval)
+	if !a.CheckPassword("192.0.2.1", "alice", "alicepass") {
+		t.Error("Expected the client to be accepted after waiting")
+	}
+}
+
+func TestAuthenticator_Disabled(t *testing.T) {
+	a := New(&fakeStore{}, "", "")
+	if a.Enabled() {
+		t.Error("Expected authenticator with no credentials to be disabled")
+	}
+
+	a = New(&fakeStore{tokens: map[string]string{"x": "ci"}}, "", "")
+	if !a.Enabled() {
+		t.Error("Expected a stored token to enable authentication")
+	}
+}
+
+func TestAuthenticator_EnabledWhileServing(t *testing.T) {
+	store := &fakeStore{}
+	a := New(store, "", "")
+	now := time.Now()
+	a.now = func() time.Time { return now }
+	if a.Enabled() {
+		t.Fatal("Expected authenticator with no credentials to be disabled")
+	}
+
+	// A token added while serving turns authentication on after the refresh
+	store.tokens = map[string]string{"x": "ci"}
+	if a.Enabled() {
+		t.Error("Expected the credentials to be counted at most every refresh")
+	}
+	now = now.Add(usersRefresh)
+	if !a.Enabled() {
+		t.Error("Expected a token added while serving to enable authentication")
+	}
+}
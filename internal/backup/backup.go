@@ -0,0 +1,396 @@
+// Package backup takes compressed online snapshots of the database, keeps
+// a rotating set of them, and restores a snapshot over the database file
+package backup
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/config"
+	"github.com/dangogh/silver-eureka/internal/database"
+	"github.com/dangogh/silver-eureka/internal/metrics"
+)
+
+const (
+	// snapshotPrefix and snapshotSuffix bracket the UTC time in snapshot names
+	snapshotPrefix = "requests-"
+	snapshotSuffix = ".db.gz"
+
+	// timeLayout sorts lexically in time order
+	timeLayout = "20060102T150405.000Z"
+)
+
+// Store writes a consistent copy of the live database
+type Store interface {
+	BackupTo(path string) error
+}
+
+// Snapshot describes a stored backup
+type Snapshot struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Manager takes snapshots on demand and on a schedule and rotates them
+type Manager struct {
+	store Store
+	cfg   config.BackupConfig
+
+	// mu serialises snapshots and rotation
+	mu sync.Mutex
+
+	done chan struct{}
+	once sync.Once
+	now  func() time.Time
+}
+
+// NewManager creates a Manager, creating the snapshot directory if needed
+func NewManager(store Store, cfg config.BackupConfig) (*Manager, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	return &Manager{
+		store: store,
+		cfg:   cfg,
+		done:  make(chan struct{}),
+		now:   time.Now,
+	}, nil
+}
+
+// Start takes a snapshot every configured interval until Stop is called.
+// It does nothing when no interval is configured.
+func (m *Manager) Start() {
+	if m.cfg.Interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(m.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if s, err := m.Create(); err != nil {
+					slog.Error("Scheduled backup failed", "error", err)
+				} else {
+					slog.Info("Scheduled backup complete", "snapshot", s.Name, "size", s.Size)
+				}
+			case <-m.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends scheduled snapshots
+func (m *Manager) Stop() {
+	m.once.Do(func() {
+		close(m.done)
+	})
+}
+
+// Create takes a snapshot and removes the oldest ones beyond the configured count
+func (m *Manager) Create() (*Snapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	created := m.now().UTC()
+	name := snapshotPrefix + created.Format(timeLayout) + snapshotSuffix
+	path := filepath.Join(m.cfg.Dir, name)
+
+	size, err := Write(m.store, path)
+	if err != nil {
+		metrics.BackupRuns.WithLabelValues("error").Inc()
+		return nil, err
+	}
+	metrics.BackupRuns.WithLabelValues("ok").Inc()
+	metrics.BackupLastSuccess.Set(float64(created.Unix()))
+	metrics.BackupSize.Set(float64(size))
+
+	if err := m.rotate(); err != nil {
+		slog.Error("Failed to rotate backups", "error", err)
+	}
+	return &Snapshot{Name: name, Size: size, CreatedAt: created}, nil
+}
+
+// List returns the stored snapshots, newest first
+func (m *Manager) List() ([]Snapshot, error) {
+	entries, err := os.ReadDir(m.cfg.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	snapshots := []Snapshot{}
+	for _, entry := range entries {
+		created, ok := parseName(entry.Name())
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // removed while listing
+		}
+		snapshots = append(snapshots, Snapshot{Name: entry.Name(), Size: info.Size(), CreatedAt: created})
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Name > snapshots[j].Name
+	})
+	return snapshots, nil
+}
+
+// Open opens a stored snapshot by name for reading
+func (m *Manager) Open(name string) (*os.File, error) {
+	if _, ok := parseName(name); !ok {
+		return nil, os.ErrNotExist
+	}
+	return os.Open(filepath.Join(m.cfg.Dir, name))
+}
+
+// rotate removes the oldest snapshots beyond the configured count
+func (m *Manager) rotate() error {
+	if m.cfg.Keep <= 0 {
+		return nil
+	}
+	snapshots, err := m.List()
+	if err != nil {
+		return err
+	}
+	for _, s := range snapshots[min(m.cfg.Keep, len(snapshots)):] {
+		if err := os.Remove(filepath.Join(m.cfg.Dir, s.Name)); err != nil {
+			return fmt.Errorf("failed to remove old backup: %w", err)
+		}
+		slog.Info("Removed old backup", "snapshot", s.Name)
+	}
+	return nil
+}
+
+// parseName returns the creation time encoded in a snapshot file name.
+// Only names produced by Create are accepted, so a name from a request
+// can't reach outside the snapshot directory.
+func parseName(name string) (time.Time, bool) {
+	stamp, ok := strings.CutPrefix(name, snapshotPrefix)
+	if !ok {
+		return time.Time{}, false
+	}
+	stamp, ok = strings.CutSuffix(stamp, snapshotSuffix)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(timeLayout, stamp)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// Write snapshots the database to a gzip file at path and returns its size.
+// The file appears only once it is complete.
+func Write(store Store, path string) (int64, error) {
+	dir := filepath.Dir(path)
+	raw, err := os.CreateTemp(dir, ".backup-*.db")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	rawPath := raw.Name()
+	defer func() {
+		if err := os.Remove(rawPath); err != nil {
+			// Already gone
+		}
+	}()
+	// VACUUM INTO refuses to overwrite, so only the name is reserved
+	if err := raw.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Remove(rawPath); err != nil {
+		return 0, err
+	}
+	if err := store.BackupTo(rawPath); err != nil {
+		return 0, err
+	}
+
+	partPath := path + ".part"
+	size, err := compress(rawPath, partPath)
+	if err != nil {
+		if rmErr := os.Remove(partPath); rmErr != nil {
+			// Nothing was written
+		}
+		return 0, err
+	}
+	if err := os.Rename(partPath, path); err != nil {
+		return 0, fmt.Errorf("failed to finish backup: %w", err)
+	}
+	return size, nil
+}
+
+// compress gzips src into dst and returns the compressed size
+func compress(src, dst string) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := in.Close(); err != nil {
+			// Read-only, nothing to flush
+		}
+	}()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create backup file: %w", err)
+	}
+	zw := gzip.NewWriter(out)
+	zw.Name = strings.TrimSuffix(filepath.Base(strings.TrimSuffix(dst, ".part")), ".gz")
+	if _, err := io.Copy(zw, in); err != nil {
+		if closeErr := out.Close(); closeErr != nil {
+			// The copy error is reported
+		}
+		return 0, fmt.Errorf("failed to compress backup: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		if closeErr := out.Close(); closeErr != nil {
+			// The compression error is reported
+		}
+		return 0, fmt.Errorf("failed to compress backup: %w", err)
+	}
+	if err := out.Sync(); err != nil {
+		if closeErr := out.Close(); closeErr != nil {
+			// The sync error is reported
+		}
+		return 0, fmt.Errorf("failed to write backup: %w", err)
+	}
+	info, err := out.Stat()
+	if err != nil {
+		if closeErr := out.Close(); closeErr != nil {
+			// The stat error is reported
+		}
+		return 0, err
+	}
+	if err := out.Close(); err != nil {
+		return 0, fmt.Errorf("failed to write backup: %w", err)
+	}
+	return info.Size(), nil
+}
+
+// Restore replaces the database at dbPath with a snapshot, which may be
+// gzip-compressed. The snapshot is checked first: it must pass SQLite's
+// integrity check, contain the request log table and have a schema version
+// no newer than this build. The previous database, if any, is kept as
+// dbPath + ".pre-restore". The server must not be running. It returns the
+// snapshot's schema version; older versions are migrated on next open.
+func Restore(src, dbPath string) (int, error) {
+	tmpPath := dbPath + ".restore"
+	if err := decompress(src, tmpPath); err != nil {
+		if rmErr := os.Remove(tmpPath); rmErr != nil {
+			// Nothing was written
+		}
+		return 0, err
+	}
+	defer func() {
+		if err := os.Remove(tmpPath); err != nil {
+			// Renamed into place
+		}
+	}()
+
+	version, err := verify(tmpPath)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := os.Stat(dbPath); err == nil {
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			if err := os.Rename(dbPath+suffix, dbPath+".pre-restore"+suffix); err != nil && !os.IsNotExist(err) {
+				return 0, fmt.Errorf("failed to move current database aside: %w", err)
+			}
+		}
+	}
+	if err := os.Rename(tmpPath, dbPath); err != nil {
+		return 0, fmt.Errorf("failed to replace database: %w", err)
+	}
+	return version, nil
+}
+
+// verify checks that the database file at path can be restored
+func verify(path string) (int, error) {
+	db, err := database.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("snapshot is not a usable database: %w", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			slog.Error("Failed to close snapshot", "error", err)
+		}
+	}()
+
+	if err := db.CheckIntegrity(); err != nil {
+		return 0, fmt.Errorf("snapshot is damaged: %w", err)
+	}
+	ok, err := db.HasTable("request_logs")
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, fmt.Errorf("snapshot is not a gather-requests database")
+	}
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return 0, err
+	}
+	if latest := database.LatestSchemaVersion(); version > latest {
+		return 0, fmt.Errorf("snapshot schema version %d is newer than this build supports (%d)", version, latest)
+	}
+	return version, nil
+}
+
+// decompress copies src to dst, gunzipping it if it is compressed
+func decompress(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer func() {
+		if err := in.Close(); err != nil {
+			// Read-only, nothing to flush
+		}
+	}()
+
+	var r io.Reader = in
+	magic := make([]byte, 2)
+	if _, err := io.ReadFull(in, magic); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		if _, err := in.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		zr, err := gzip.NewReader(in)
+		if err != nil {
+			return fmt.Errorf("failed to read compressed snapshot: %w", err)
+		}
+		r = zr
+	} else if _, err := in.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create database file: %w", err)
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		if closeErr := out.Close(); closeErr != nil {
+			// The copy error is reported
+		}
+		return fmt.Errorf("failed to extract snapshot: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to extract snapshot: %w", err)
+	}
+	return nil
+}
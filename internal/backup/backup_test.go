@@ -0,0 +1,245 @@
+package backup
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/config"
+	"github.com/dangogh/silver-eureka/internal/database"
+)
+
+func setupTestDB(t *testing.T) (*database.DB, string) {
+	t.Helper()
+	dbPath := filepath.Join(t.TempDir(), "requests.db")
+	db, err := database.New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			// Ignore close errors in test cleanup
+		}
+	})
+	return db, dbPath
+}
+
+func newTestManager(t *testing.T, db *database.DB, keep int) *Manager {
+	t.Helper()
+	m, err := NewManager(db, config.BackupConfig{Dir: filepath.Join(t.TempDir(), "backups"), Keep: keep})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	// Step the clock so every snapshot gets its own name
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	m.now = func() time.Time {
+		now = now.Add(time.Minute)
+		return now
+	}
+	return m
+}
+
+func TestCreateAndRotate(t *testing.T) {
+	db, _ := setupTestDB(t)
+	if err := db.LogRequest("192.0.2.1", "/backup"); err != nil {
+		t.Fatalf("Failed to log request: %v", err)
+	}
+	m := newTestManager(t, db, 2)
+
+	var names []string
+	for i := 0; i < 3; i++ {
+		s, err := m.Create()
+		if err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		if s.Size <= 0 {
+			t.Errorf("Expected a non-empty snapshot, got %+v", s)
+		}
+		names = append(names, s.Name)
+	}
+
+	snapshots, err := m.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(snapshots) != 2 {
+		t.Fatalf("Expected 2 snapshots after rotation, got %d", len(snapshots))
+	}
+	if snapshots[0].Name != names[2] || snapshots[1].Name != names[1] {
+		t.Errorf("Expected the newest snapshots newest first, got %+v", snapshots)
+	}
+
+	// No temporary files are left behind
+	entries, _ := os.ReadDir(m.cfg.Dir)
+	if len(entries) != 2 {
+		t.Errorf("Expected only snapshots in the backup directory, got %d entries", len(entries))
+	}
+}
+
+func TestOpen_RejectsOtherNames(t *testing.T) {
+	db, _ := setupTestDB(t)
+	m := newTestManager(t, db, 0)
+
+	for _, name := range []string{"../requests.db", "requests.db", "requests-x.db.gz", "/etc/passwd"} {
+		if _, err := m.Open(name); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected Open(%q) to be rejected, got %v", name, err)
+		}
+	}
+}
+
+func TestRestore(t *testing.T) {
+	db, _ := setupTestDB(t)
+	if err := db.LogRequest("192.0.2.1", "/snapshot"); err != nil {
+		t.Fatalf("Failed to log request: %v", err)
+	}
+	snapshot := filepath.Join(t.TempDir(), "snap.db.gz")
+	if _, err := Write(db, snapshot); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	// Restore over a different database
+	_, target := setupTestDB(t)
+	version, err := Restore(snapshot, target)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if version != database.LatestSchemaVersion() {
+		t.Errorf("Expected schema version %d, got %d", database.LatestSchemaVersion(), version)
+	}
+	if _, err := os.Stat(target + ".pre-restore"); err != nil {
+		t.Errorf("Expected previous database to be kept: %v", err)
+	}
+
+	restored, err := database.New(target)
+	if err != nil {
+		t.Fatalf("Failed to open restored database: %v", err)
+	}
+	defer func() {
+		if err := restored.Close(); err != nil {
+			t.Errorf("Failed to close restored database: %v", err)
+		}
+	}()
+	logs, err := restored.GetLogs(0)
+	if err != nil {
+		t.Fatalf("Failed to read restored logs: %v", err)
+	}
+	if len(logs) != 1 || logs[0].URL != "/snapshot" {
+		t.Errorf("Unexpected restored logs: %+v", logs)
+	}
+}
+
+func TestRestore_Rejects(t *testing.T) {
+	dir := t.TempDir()
+
+	garbage := filepath.Join(dir, "garbage")
+	if err := os.WriteFile(garbage, []byte("not a database"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	// A database from a newer build
+	newer := filepath.Join(dir, "newer.db")
+	db, err := database.New(newer)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+	conn, err := sql.Open("sqlite3", newer)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	if _, err := conn.Exec("PRAGMA user_version = 9999"); err != nil {
+		t.Fatalf("Failed to set schema version: %v", err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+
+	// An SQLite database that isn't ours
+	foreign := filepath.Join(dir, "foreign.db")
+	other, err := database.Open(foreign)
+	if err != nil {
+		t.Fatalf("Failed to create foreign database: %v", err)
+	}
+	if err := other.Close(); err != nil {
+		t.Fatalf("Failed to close foreign database: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"not a database", garbage, "not a usable database"},
+		{"newer schema", newer, "newer than this build"},
+		{"foreign database", foreign, "not a gather-requests database"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := filepath.Join(t.TempDir(), "live.db")
+			if err := os.WriteFile(target, []byte("live"), 0600); err != nil {
+				t.Fatalf("Failed to write target: %v", err)
+			}
+
+			_, err := Restore(tt.src, target)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Expected error containing %q, got %v", tt.want, err)
+			}
+			if data, _ := os.ReadFile(target); string(data) != "live" {
+				t.Error("Expected the live database to be untouched")
+			}
+			if _, err := os.Stat(target + ".restore"); !os.IsNotExist(err) {
+				t.Error("Expected the temporary restore file to be removed")
+			}
+		})
+	}
+}
+
+func TestHandlers(t *testing.T) {
+	db, _ := setupTestDB(t)
+	m := newTestManager(t, db, 0)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /backups", m.HandleList)
+	mux.HandleFunc("POST /backups", m.HandleCreate)
+	mux.HandleFunc("GET /backups/{name}", m.HandleDownload)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/backups", nil))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201 from create, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	snapshots, _ := m.List()
+	if len(snapshots) != 1 {
+		t.Fatalf("Expected 1 snapshot, got %d", len(snapshots))
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/backups", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), snapshots[0].Name) {
+		t.Errorf("Expected list to include the snapshot, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/backups/"+snapshots[0].Name, nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/gzip" {
+		t.Errorf("Expected gzip download, got %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if int64(rec.Body.Len()) != snapshots[0].Size {
+		t.Errorf("Expected %d bytes, got %d", snapshots[0].Size, rec.Body.Len())
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/backups/requests.db", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown name, got %d", rec.Code)
+	}
+}
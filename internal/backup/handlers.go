@@ -0,0 +1,77 @@
+package backup
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+// HandleList returns the stored snapshots as JSON, newest first
+func (m *Manager) HandleList(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("Handler invoked: HandleList (backups)", "method", r.Method, "path", r.URL.Path)
+
+	snapshots, err := m.List()
+	if err != nil {
+		slog.Error("Failed to list backups", "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list backups", "details": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, snapshots)
+}
+
+// HandleCreate takes a snapshot now
+func (m *Manager) HandleCreate(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("Handler invoked: HandleCreate (backups)", "method", r.Method, "path", r.URL.Path)
+
+	s, err := m.Create()
+	if err != nil {
+		slog.Error("Backup failed", "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "backup failed", "details": err.Error()})
+		return
+	}
+
+	slog.Info("Backup complete", "snapshot", s.Name, "size", s.Size)
+	writeJSON(w, http.StatusCreated, s)
+}
+
+// HandleDownload streams the snapshot named by the {name} path value
+func (m *Manager) HandleDownload(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("Handler invoked: HandleDownload (backups)", "method", r.Method, "path", r.URL.Path)
+
+	name := r.PathValue("name")
+	f, err := m.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "backup not found"})
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to open backup", "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to open backup", "details": err.Error()})
+		return
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			// Read-only, nothing to flush
+		}
+	}()
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+name+"\"")
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, f); err != nil {
+		// Response already started
+	}
+}
+
+// writeJSON writes v as a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		// Response already started
+	}
+}
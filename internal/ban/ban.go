@@ -0,0 +1,492 @@
+package ban
+
+import (
+	"container/list"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/clientip"
+	"github.com/dangogh/silver-eureka/internal/config"
+	"github.com/dangogh/silver-eureka/internal/database"
+	"github.com/dangogh/silver-eureka/internal/middleware"
+)
+
+// Ban sources
+const (
+	SourceAuto   = "auto"
+	SourceManual = "manual"
+)
+
+// MaxStrikeEntries bounds the clients with recent strikes kept in memory;
+// beyond it the client struck least recently is forgotten
+const MaxStrikeEntries = 100000
+
+// Store persists bans
+type Store interface {
+	SaveBan(b database.Ban) error
+	DeleteBan(cidr string) (bool, error)
+	GetBan(cidr string) (*database.Ban, error)
+	GetBans() ([]database.Ban, error)
+}
+
+// Manager keeps the active ban list in memory, counts strikes against
+// client IPs and bans them fail2ban-style once they cross the threshold
+type Manager struct {
+	store Store
+
+	mu     sync.RWMutex
+	cfg    config.BanConfig
+	hosts  map[netip.Addr]database.Ban    // single-address bans
+	nets   map[netip.Prefix]database.Ban  // network bans
+	strike map[netip.Prefix]*list.Element // recent strikes per client
+	struck *list.List                     // strike entries, front = most recently struck
+	max    int                            // strike entries kept before evicting
+
+	ticker *time.Ticker
+	done   chan struct{}
+	once   sync.Once
+
+	now func() time.Time
+}
+
+// NewManager creates a Manager and loads the active bans from the store
+func NewManager(store Store, cfg config.BanConfig) (*Manager, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	m := &Manager{
+		store:  store,
+		cfg:    cfg,
+		strike: make(map[netip.Prefix]*list.Element),
+		struck: list.New(),
+		max:    MaxStrikeEntries,
+		ticker: time.NewTicker(time.Minute),
+		done:   make(chan struct{}),
+		now:    time.Now,
+	}
+	if err := m.Load(); err != nil {
+		return nil, err
+	}
+
+	go m.cleanupRoutine()
+
+	return m, nil
+}
+
+// SetConfig replaces the auto-ban settings and signatures. Strikes already
+// counted are kept and judged against the new threshold and window.
+func (m *Manager) SetConfig(cfg config.BanConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.cfg = cfg
+	m.mu.Unlock()
+	return nil
+}
+
+// Load replaces the in-memory ban list with the active bans in the store
+func (m *Manager) Load() error {
+	bans, err := m.store.GetBans()
+	if err != nil {
+		return fmt.Errorf("failed to load bans: %w", err)
+	}
+
+	hosts := make(map[netip.Addr]database.Ban)
+	nets := make(map[netip.Prefix]database.Ban)
+	now := m.now()
+	for _, b := range bans {
+		if !b.Active(now) {
+			continue
+		}
+		prefix, err := ParseCIDR(b.CIDR)
+		if err != nil {
+			slog.Warn("Skipping invalid ban entry", "cidr", b.CIDR, "error", err)
+			continue
+		}
+		if prefix.IsSingleIP() {
+			hosts[prefix.Addr()] = b
+		} else {
+			nets[prefix] = b
+		}
+	}
+
+	m.mu.Lock()
+	m.hosts = hosts
+	m.nets = nets
+	m.mu.Unlock()
+
+	return nil
+}
+
+// ParseCIDR parses an IP address or CIDR into a canonical, masked prefix
+func ParseCIDR(value string) (netip.Prefix, error) {
+	value = strings.TrimSpace(value)
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR %q: %w", value, err)
+		}
+		if prefix.Addr().Is4In6() {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), max(prefix.Bits()-96, 0))
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP address %q: %w", value, err)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// IsBanned reports whether ip is covered by an active ban
+func (m *Manager) IsBanned(ip string) bool {
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	now := m.now()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if b, ok := m.hosts[addr]; ok && b.Active(now) {
+		return true
+	}
+	for prefix, b := range m.nets {
+		if prefix.Contains(addr) && b.Active(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// Ban adds or replaces a ban; a zero duration bans permanently
+func (m *Manager) Ban(cidr, reason, source string, duration time.Duration) (database.Ban, error) {
+	prefix, err := ParseCIDR(cidr)
+	if err != nil {
+		return database.Ban{}, err
+	}
+
+	offenses := 1
+	existing, err := m.store.GetBan(prefix.String())
+	if err != nil {
+		return database.Ban{}, err
+	}
+	if existing != nil {
+		offenses = existing.Offenses + 1
+	}
+
+	return m.save(prefix, reason, source, offenses, duration)
+}
+
+// save persists a ban and adds it to the in-memory list
+func (m *Manager) save(prefix netip.Prefix, reason, source string, offenses int, duration time.Duration) (database.Ban, error) {
+	now := m.now()
+	b := database.Ban{
+		CIDR:      prefix.String(),
+		Reason:    reason,
+		Source:    source,
+		Offenses:  offenses,
+		CreatedAt: now,
+	}
+	if duration > 0 {
+		expires := now.Add(duration)
+		b.ExpiresAt = &expires
+	}
+
+	if err := m.store.SaveBan(b); err != nil {
+		return database.Ban{}, fmt.Errorf("failed to save ban: %w", err)
+	}
+
+	m.mu.Lock()
+	if prefix.IsSingleIP() {
+		m.hosts[prefix.Addr()] = b
+	} else {
+		m.nets[prefix] = b
+	}
+	m.mu.Unlock()
+
+	slog.Warn("IP banned",
+		"cidr", b.CIDR,
+		"reason", reason,
+		"source", source,
+		"offenses", offenses,
+		"expires_at", b.ExpiresAt,
+	)
+	return b, nil
+}
+
+// Unban removes a ban and reports whether it existed
+func (m *Manager) Unban(cidr string) (bool, error) {
+	prefix, err := ParseCIDR(cidr)
+	if err != nil {
+		return false, err
+	}
+
+	removed, err := m.store.DeleteBan(prefix.String())
+	if err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	if prefix.IsSingleIP() {
+		delete(m.hosts, prefix.Addr())
+	} else {
+		delete(m.nets, prefix)
+	}
+	m.mu.Unlock()
+
+	if removed {
+		slog.Info("IP unbanned", "cidr", prefix.String())
+	}
+	return removed, nil
+}
+
+// List returns all stored bans, including expired ones
+func (m *Manager) List() ([]database.Ban, error) {
+	return m.store.GetBans()
+}
+
+// Active returns the bans currently in effect
+func (m *Manager) Active() []database.Ban {
+	now := m.now()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	bans := make([]database.Ban, 0, len(m.hosts)+len(m.nets))
+	for _, b := range m.hosts {
+		if b.Active(now) {
+			bans = append(bans, b)
+		}
+	}
+	for _, b := range m.nets {
+		if b.Active(now) {
+			bans = append(bans, b)
+		}
+	}
+	return bans
+}
+
+// strikeEntry holds one client's recent strikes
+type strikeEntry struct {
+	key     netip.Prefix
+	strikes []time.Time
+}
+
+// Strike records an offense against ip and bans it once the threshold is
+// reached within the window; it reports whether a ban was issued. ip is
+// the trusted client address, or a network, and anything unparseable is
+// ignored.
+func (m *Manager) Strike(ip, reason string) bool {
+	prefix, err := ParseCIDR(ip)
+	if err != nil {
+		return false
+	}
+
+	m.mu.Lock()
+	cfg := m.cfg
+	if !cfg.AutoBan {
+		m.mu.Unlock()
+		return false
+	}
+
+	now := m.now()
+	el, ok := m.strike[prefix]
+	if ok {
+		m.struck.MoveToFront(el)
+	} else {
+		el = m.struck.PushFront(&strikeEntry{key: prefix})
+		m.strike[prefix] = el
+		for m.struck.Len() > m.max {
+			// A sweep of distinct addresses can't grow memory without bound
+			m.forget(m.struck.Back())
+		}
+	}
+	entry := el.Value.(*strikeEntry)
+	entry.strikes = append(pruneStrikes(entry.strikes, now.Add(-cfg.Window)), now)
+	if len(entry.strikes) < cfg.Threshold {
+		m.mu.Unlock()
+		return false
+	}
+	m.forget(el)
+	m.mu.Unlock()
+
+	offenses := 1
+	existing, err := m.store.GetBan(prefix.String())
+	if err != nil {
+		slog.Error("Failed to look up previous ban", "ip", ip, "error", err)
+	} else if existing != nil {
+		offenses = existing.Offenses + 1
+	}
+
+	if _, err := m.save(prefix, reason, SourceAuto, offenses, escalate(cfg, offenses)); err != nil {
+		slog.Error("Failed to ban IP", "ip", ip, "error", err)
+		return false
+	}
+	return true
+}
+
+// escalate returns the ban length for the given offense count
+func escalate(cfg config.BanConfig, offenses int) time.Duration {
+	d := float64(cfg.Duration) * math.Pow(cfg.Multiplier, float64(offenses-1))
+	if cfg.MaxDuration > 0 && d > float64(cfg.MaxDuration) {
+		return cfg.MaxDuration
+	}
+	if d > float64(math.MaxInt64) {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(d)
+}
+
+// forget drops a client's strike entry; the caller holds m.mu
+func (m *Manager) forget(el *list.Element) {
+	m.struck.Remove(el)
+	delete(m.strike, el.Value.(*strikeEntry).key)
+}
+
+// pruneStrikes drops strikes older than cutoff
+func pruneStrikes(strikes []time.Time, cutoff time.Time) []time.Time {
+	kept := strikes[:0]
+	for _, t := range strikes {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+// ObserveReject counts per-IP rate limit rejections as strikes
+func (m *Manager) ObserveReject(routeGroup, ip, scope string) {
+	if scope != middleware.ScopePerIP {
+		// Global rejections are not the client's fault
+		return
+	}
+	m.Strike(ip, "rate limit exceeded on "+routeGroup)
+}
+
+// MatchSignature returns the first configured signature found in url
+func (m *Manager) MatchSignature(url string) (string, bool) {
+	m.mu.RLock()
+	signatures := m.cfg.Signatures
+	m.mu.RUnlock()
+
+	lower := strings.ToLower(url)
+	for _, sig := range signatures {
+		if sig != "" && strings.Contains(lower, strings.ToLower(sig)) {
+			return sig, true
+		}
+	}
+	return "", false
+}
+
+// Middleware rejects requests from banned IPs before any other processing,
+// either by closing the connection or with a static 403
+func (m *Manager) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := clientip.FromRequest(r)
+			if !m.IsBanned(ip) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			m.mu.RLock()
+			action := m.cfg.Action
+			m.mu.RUnlock()
+
+			if action == config.BanActionClose {
+				if hj, ok := w.(http.Hijacker); ok {
+					if conn, _, err := hj.Hijack(); err == nil {
+						if err := conn.Close(); err != nil {
+							// Connection already gone
+						}
+						return
+					}
+				}
+			}
+
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Connection", "close")
+			w.WriteHeader(http.StatusForbidden)
+			if _, err := w.Write([]byte("403 forbidden\n")); err != nil {
+				// Response already started
+			}
+		})
+	}
+}
+
+// SignatureMiddleware counts requests whose URL matches a signature as strikes
+func (m *Manager) SignatureMiddleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if sig, ok := m.MatchSignature(r.URL.String()); ok {
+				m.Strike(clientip.FromRequest(r), "signature match: "+sig)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// cleanupRoutine periodically reloads the ban list, taking in bans added
+// or lifted with the admin CLI, and drops stale strikes and expired bans
+func (m *Manager) cleanupRoutine() {
+	for {
+		select {
+		case <-m.ticker.C:
+			if err := m.Load(); err != nil {
+				slog.Error("Failed to reload bans", "error", err)
+			}
+			m.cleanup()
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// cleanup drops stale strikes and expired bans from memory
+func (m *Manager) cleanup() {
+	now := m.now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// The list is ordered by last strike, so stop at the first recent entry
+	cutoff := now.Add(-m.cfg.Window)
+	for el := m.struck.Back(); el != nil; el = m.struck.Back() {
+		strikes := el.Value.(*strikeEntry).strikes
+		if len(strikes) > 0 && strikes[len(strikes)-1].After(cutoff) {
+			break
+		}
+		m.forget(el)
+	}
+	for addr, b := range m.hosts {
+		if !b.Active(now) {
+			delete(m.hosts, addr)
+		}
+	}
+	for prefix, b := range m.nets {
+		if !b.Active(now) {
+			delete(m.nets, prefix)
+		}
+	}
+}
+
+// Stop stops the cleanup goroutine
+func (m *Manager) Stop() {
+	m.once.Do(func() {
+		m.ticker.Stop()
+		close(m.done)
+	})
+}
@@ -0,0 +1,420 @@
+package ban
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/config"
+	"github.com/dangogh/silver-eureka/internal/database"
+)
+
+func setupTestDB(t *testing.T) *database.DB {
+	t.Helper()
+	dbPath := t.TempDir() + "/bans.db"
+	db, err := database.New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			// Ignore close errors in test cleanup
+		}
+		if err := os.Remove(dbPath); err != nil {
+			// Ignore remove errors in test cleanup
+		}
+	})
+	return db
+}
+
+func newTestManager(t *testing.T, cfg config.BanConfig) *Manager {
+	t.Helper()
+	m, err := NewManager(setupTestDB(t), cfg)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	t.Cleanup(m.Stop)
+	return m
+}
+
+func TestParseCIDR(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "192.0.2.1", want: "192.0.2.1/32"},
+		{in: "192.0.2.77/24", want: "192.0.2.0/24"},
+		{in: "::ffff:192.0.2.1", want: "192.0.2.1/32"},
+		{in: "2001:db8::1", want: "2001:db8::1/128"},
+		{in: "2001:db8::1/64", want: "2001:db8::/64"},
+		{in: "not-an-ip", wantErr: true},
+		{in: "192.0.2.0/33", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseCIDR(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCIDR(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && got.String() != tt.want {
+				t.Errorf("ParseCIDR(%q) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestManager_BanAndUnban(t *testing.T) {
+	m := newTestManager(t, config.DefaultBanConfig())
+
+	if _, err := m.Ban("198.51.100.0/24", "scanner network", SourceManual, 0); err != nil {
+		t.Fatalf("Failed to ban network: %v", err)
+	}
+	if _, err := m.Ban("192.0.2.1", "scanner", SourceManual, time.Hour); err != nil {
+		t.Fatalf("Failed to ban IP: %v", err)
+	}
+
+	if !m.IsBanned("198.51.100.77") {
+		t.Error("Expected address inside banned network to be banned")
+	}
+	if !m.IsBanned("192.0.2.1") {
+		t.Error("Expected banned IP to be banned")
+	}
+	if m.IsBanned("192.0.2.2") {
+		t.Error("Expected other IP not to be banned")
+	}
+	if m.IsBanned("garbage") {
+		t.Error("Expected unparseable IP not to be banned")
+	}
+
+	removed, err := m.Unban("192.0.2.1")
+	if err != nil || !removed {
+		t.Fatalf("Expected unban to succeed, got removed=%v err=%v", removed, err)
+	}
+	if m.IsBanned("192.0.2.1") {
+		t.Error("Expected IP to be unbanned")
+	}
+	// The network ban is unaffected
+	if !m.IsBanned("198.51.100.1") {
+		t.Error("Expected network ban to remain")
+	}
+}
+
+func TestManager_LoadPersistedBans(t *testing.T) {
+	db := setupTestDB(t)
+	first, err := NewManager(db, config.DefaultBanConfig())
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer first.Stop()
+
+	if _, err := first.Ban("192.0.2.1", "persist", SourceManual, 0); err != nil {
+		t.Fatalf("Failed to ban: %v", err)
+	}
+
+	second, err := NewManager(db, config.DefaultBanConfig())
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer second.Stop()
+
+	if !second.IsBanned("192.0.2.1") {
+		t.Error("Expected ban to be loaded from the database")
+	}
+}
+
+func TestManager_StrikesAndEscalation(t *testing.T) {
+	cfg := config.DefaultBanConfig()
+	cfg.AutoBan = true
+	cfg.Threshold = 3
+	cfg.Duration = time.Hour
+	cfg.Multiplier = 2
+	cfg.MaxDuration = 3 * time.Hour
+	m := newTestManager(t, cfg)
+
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if m.Strike("192.0.2.1", "test") {
+			t.Fatalf("Strike %d should not ban yet", i+1)
+		}
+	}
+	if !m.Strike("192.0.2.1", "test") {
+		t.Fatal("Third strike should ban")
+	}
+	if !m.IsBanned("192.0.2.1") {
+		t.Fatal("Expected IP to be banned")
+	}
+
+	expected := []time.Duration{time.Hour, 2 * time.Hour, 3 * time.Hour}
+	for offense, want := range expected {
+		bans := m.Active()
+		if len(bans) != 1 {
+			t.Fatalf("Expected 1 active ban, got %d", len(bans))
+		}
+		if got := bans[0].ExpiresAt.Sub(now); got != want {
+			t.Errorf("Offense %d: expected ban of %s, got %s", offense+1, want, got)
+		}
+
+		// Let the ban expire and offend again
+		now = now.Add(want + time.Minute)
+		for i := 0; i < cfg.Threshold; i++ {
+			m.Strike("192.0.2.1", "test")
+		}
+	}
+}
+
+func TestManager_StrikesOutsideWindow(t *testing.T) {
+	cfg := config.DefaultBanConfig()
+	cfg.AutoBan = true
+	cfg.Threshold = 2
+	cfg.Window = time.Minute
+	m := newTestManager(t, cfg)
+
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	m.Strike("192.0.2.1", "test")
+	now = now.Add(2 * time.Minute)
+	if m.Strike("192.0.2.1", "test") {
+		t.Error("Strikes outside the window should not accumulate")
+	}
+
+	m.cleanup()
+	if len(m.strike) != 1 {
+		t.Errorf("Expected 1 tracked IP after cleanup, got %d", len(m.strike))
+	}
+}
+
+func TestManager_StrikeKeys(t *testing.T) {
+	cfg := config.DefaultBanConfig()
+	cfg.AutoBan = true
+	cfg.Threshold = 2
+	m := newTestManager(t, cfg)
+
+	// Unparseable values are not tracked
+	if m.Strike("not-an-ip, 192.0.2.1", "test") || len(m.strike) != 0 {
+		t.Errorf("Expected an unparseable address to be ignored, got %d tracked", len(m.strike))
+	}
+
+	// Spellings of one address share a count
+	m.Strike("::ffff:192.0.2.1", "test")
+	if !m.Strike(" 192.0.2.1", "test") {
+		t.Error("Expected a mapped and a plain address to count as one client")
+	}
+}
+
+func TestManager_StrikesBounded(t *testing.T) {
+	cfg := config.DefaultBanConfig()
+	cfg.AutoBan = true
+	cfg.Threshold = 2
+	m := newTestManager(t, cfg)
+	m.max = 2
+
+	m.Strike("192.0.2.1", "test")
+	m.Strike("192.0.2.2", "test")
+	m.Strike("192.0.2.3", "test")
+	if len(m.strike) != 2 || m.struck.Len() != 2 {
+		t.Fatalf("Expected 2 tracked IPs, got %d", len(m.strike))
+	}
+
+	// The least recently struck client was forgotten
+	if m.Strike("192.0.2.1", "test") {
+		t.Error("Expected the evicted client's count to start over")
+	}
+	if !m.Strike("192.0.2.3", "test") {
+		t.Error("Expected a tracked client to be banned")
+	}
+}
+
+func TestManager_AutoBanDisabled(t *testing.T) {
+	cfg := config.DefaultBanConfig()
+	cfg.Threshold = 1
+	m := newTestManager(t, cfg)
+
+	if m.Strike("192.0.2.1", "test") {
+		t.Error("Expected no ban when auto-ban is disabled")
+	}
+}
+
+func TestManager_SetConfig(t *testing.T) {
+	m := newTestManager(t, config.DefaultBanConfig())
+
+	cfg := config.DefaultBanConfig()
+	cfg.Action = "explode"
+	if err := m.SetConfig(cfg); err == nil {
+		t.Error("Expected invalid config to be rejected")
+	}
+
+	cfg = config.DefaultBanConfig()
+	cfg.AutoBan = true
+	cfg.Threshold = 1
+	cfg.Signatures = []string{"/xmlrpc.php"}
+	if err := m.SetConfig(cfg); err != nil {
+		t.Fatalf("SetConfig() error = %v", err)
+	}
+	if _, ok := m.MatchSignature("/.env"); ok {
+		t.Error("Expected old signatures to be replaced")
+	}
+	if _, ok := m.MatchSignature("/xmlrpc.php"); !ok {
+		t.Error("Expected new signature to match")
+	}
+	if !m.Strike("192.0.2.1", "test") {
+		t.Error("Expected auto-ban to be enabled by the new config")
+	}
+}
+
+func TestManager_ObserveReject(t *testing.T) {
+	cfg := config.DefaultBanConfig()
+	cfg.AutoBan = true
+	cfg.Threshold = 1
+	m := newTestManager(t, cfg)
+
+	m.ObserveReject("catchall", "192.0.2.1", "global")
+	if m.IsBanned("192.0.2.1") {
+		t.Error("Global rejections should not count as strikes")
+	}
+
+	m.ObserveReject("catchall", "192.0.2.1", "per_ip")
+	if !m.IsBanned("192.0.2.1") {
+		t.Error("Per-IP rejections should count as strikes")
+	}
+}
+
+func TestManager_SignatureMiddleware(t *testing.T) {
+	cfg := config.DefaultBanConfig()
+	cfg.AutoBan = true
+	cfg.Threshold = 1
+	cfg.Signatures = []string{"/etc/passwd"}
+	m := newTestManager(t, cfg)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	handler := m.SignatureMiddleware()(next)
+
+	req := httptest.NewRequest(http.MethodGet, "/harmless", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if m.IsBanned("192.0.2.1") {
+		t.Fatal("Expected harmless URL not to strike")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/cgi-bin/../../ETC/PASSWD", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected request to still reach the handler, got %d", rec.Code)
+	}
+	if !m.IsBanned("192.0.2.1") {
+		t.Error("Expected signature match to ban")
+	}
+}
+
+func TestManager_Middleware(t *testing.T) {
+	for _, action := range []string{config.BanActionStatic, config.BanActionClose} {
+		t.Run(action, func(t *testing.T) {
+			cfg := config.DefaultBanConfig()
+			cfg.Action = action
+			m := newTestManager(t, cfg)
+			if _, err := m.Ban("192.0.2.1", "test", SourceManual, 0); err != nil {
+				t.Fatalf("Failed to ban: %v", err)
+			}
+
+			called := false
+			handler := m.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+			}))
+
+			// httptest.ResponseRecorder can't be hijacked, so close falls back to static
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if called {
+				t.Error("Expected banned request not to reach the handler")
+			}
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("Expected status 403, got %d", rec.Code)
+			}
+
+			req = httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = "192.0.2.2:1234"
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if !called {
+				t.Error("Expected unbanned request to reach the handler")
+			}
+		})
+	}
+}
+
+func TestManager_MiddlewareClosesConnection(t *testing.T) {
+	m := newTestManager(t, config.DefaultBanConfig())
+	if _, err := m.Ban("127.0.0.1", "test", SourceManual, 0); err != nil {
+		t.Fatalf("Failed to ban: %v", err)
+	}
+
+	server := httptest.NewServer(m.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err == nil {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			// Ignore close errors in test cleanup
+		}
+		t.Fatalf("Expected connection to be closed, got status %d", resp.StatusCode)
+	}
+}
+
+func TestManager_Export(t *testing.T) {
+	m := newTestManager(t, config.DefaultBanConfig())
+	for _, cidr := range []string{"192.0.2.1", "198.51.100.0/24", "2001:db8::/32"} {
+		if _, err := m.Ban(cidr, "test", SourceManual, 0); err != nil {
+			t.Fatalf("Failed to ban %s: %v", cidr, err)
+		}
+	}
+
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{format: FormatText, want: []string{"192.0.2.1/32\n", "198.51.100.0/24\n", "2001:db8::/32\n"}},
+		{format: FormatNftables, want: []string{"table inet silver_eureka", "type ipv4_addr", "elements = { 192.0.2.1/32, 198.51.100.0/24 }", "elements = { 2001:db8::/32 }"}},
+		{format: FormatIptables, want: []string{"create silver_eureka_banned_v4 hash:net family inet", "add silver_eureka_banned_v4 198.51.100.0/24 -exist", "add silver_eureka_banned_v6 2001:db8::/32 -exist"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := m.Export(&buf, tt.format); err != nil {
+				t.Fatalf("Export failed: %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(buf.String(), want) {
+					t.Errorf("Expected %s export to contain %q, got:\n%s", tt.format, want, buf.String())
+				}
+			}
+		})
+	}
+
+	if err := m.Export(&bytes.Buffer{}, "bogus"); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+}
+
+func TestEscalate_Overflow(t *testing.T) {
+	cfg := config.DefaultBanConfig()
+	cfg.MaxDuration = 0
+	if d := escalate(cfg, 1000); d <= 0 {
+		t.Errorf("Expected escalation to saturate, got %s", d)
+	}
+}
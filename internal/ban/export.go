@@ -0,0 +1,71 @@
+package ban
+
+import (
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// Export formats
+const (
+	FormatText     = "text"
+	FormatNftables = "nftables"
+	FormatIptables = "iptables"
+)
+
+// Export writes the active ban list in the given format: a plain CIDR list,
+// an nftables table definition, or ipset restore commands for iptables
+func (m *Manager) Export(w io.Writer, format string) error {
+	var v4, v6 []string
+	for _, b := range m.Active() {
+		prefix, err := netip.ParsePrefix(b.CIDR)
+		if err != nil {
+			continue
+		}
+		if prefix.Addr().Is4() {
+			v4 = append(v4, prefix.String())
+		} else {
+			v6 = append(v6, prefix.String())
+		}
+	}
+	sort.Strings(v4)
+	sort.Strings(v6)
+
+	var out strings.Builder
+	switch format {
+	case FormatText, "":
+		for _, cidr := range append(v4, v6...) {
+			out.WriteString(cidr + "\n")
+		}
+	case FormatNftables:
+		out.WriteString("table inet silver_eureka {\n")
+		writeNftSet(&out, "banned_v4", "ipv4_addr", v4)
+		writeNftSet(&out, "banned_v6", "ipv6_addr", v6)
+		out.WriteString("}\n")
+	case FormatIptables:
+		out.WriteString("create silver_eureka_banned_v4 hash:net family inet -exist\n")
+		out.WriteString("create silver_eureka_banned_v6 hash:net family inet6 -exist\n")
+		for _, cidr := range v4 {
+			out.WriteString("add silver_eureka_banned_v4 " + cidr + " -exist\n")
+		}
+		for _, cidr := range v6 {
+			out.WriteString("add silver_eureka_banned_v6 " + cidr + " -exist\n")
+		}
+	default:
+		return fmt.Errorf("unknown export format %q", format)
+	}
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// writeNftSet writes one nftables interval set
+func writeNftSet(out *strings.Builder, name, typ string, elements []string) {
+	fmt.Fprintf(out, "\tset %s {\n\t\ttype %s\n\t\tflags interval\n", name, typ)
+	if len(elements) > 0 {
+		fmt.Fprintf(out, "\t\telements = { %s }\n", strings.Join(elements, ", "))
+	}
+	out.WriteString("\t}\n")
+}
@@ -0,0 +1,122 @@
+package ban
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// banRequest is the body accepted by HandleAdd
+type banRequest struct {
+	CIDR     string `json:"cidr"`
+	Reason   string `json:"reason"`
+	Duration string `json:"duration"` // Go duration, empty = permanent
+}
+
+// HandleList returns all stored bans as JSON
+func (m *Manager) HandleList(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("Handler invoked: HandleList (bans)", "method", r.Method, "path", r.URL.Path)
+
+	bans, err := m.List()
+	if err != nil {
+		slog.Error("Failed to list bans", "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to retrieve bans", "details": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, bans)
+}
+
+// HandleAdd bans an IP address or CIDR from a JSON request body
+func (m *Manager) HandleAdd(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("Handler invoked: HandleAdd (bans)", "method", r.Method, "path", r.URL.Path)
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<16)
+
+	var req banRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body", "details": err.Error()})
+		return
+	}
+
+	var duration time.Duration
+	if req.Duration != "" {
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid duration", "details": req.Duration})
+			return
+		}
+		duration = d
+	}
+
+	if _, err := ParseCIDR(req.CIDR); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid cidr", "details": err.Error()})
+		return
+	}
+
+	b, err := m.Ban(req.CIDR, req.Reason, SourceManual, duration)
+	if err != nil {
+		slog.Error("Failed to add ban", "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to add ban", "details": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, b)
+}
+
+// HandleRemove lifts the ban given by the cidr query parameter
+func (m *Manager) HandleRemove(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("Handler invoked: HandleRemove (bans)", "method", r.Method, "path", r.URL.Path)
+
+	cidr := r.URL.Query().Get("cidr")
+	if _, err := ParseCIDR(cidr); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid cidr", "details": err.Error()})
+		return
+	}
+
+	removed, err := m.Unban(cidr)
+	if err != nil {
+		slog.Error("Failed to remove ban", "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to remove ban", "details": err.Error()})
+		return
+	}
+	if !removed {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "ban not found"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleExport returns the active ban list as text, nftables or iptables
+func (m *Manager) HandleExport(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("Handler invoked: HandleExport (bans)", "method", r.Method, "path", r.URL.Path)
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = FormatText
+	}
+
+	var buf bytes.Buffer
+	if err := m.Export(&buf, format); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid export format", "details": err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"banned-"+format+".txt\"")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		// Response already started
+	}
+}
+
+// writeJSON writes v as a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		// Response already started
+	}
+}
@@ -0,0 +1,102 @@
+package ban
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dangogh/silver-eureka/internal/config"
+	"github.com/dangogh/silver-eureka/internal/database"
+)
+
+func TestHandleAddListRemove(t *testing.T) {
+	m := newTestManager(t, config.DefaultBanConfig())
+
+	body := strings.NewReader(`{"cidr":"192.0.2.0/24","reason":"scanner","duration":"24h"}`)
+	req := httptest.NewRequest(http.MethodPost, "/stats/bans", body)
+	rec := httptest.NewRecorder()
+	m.HandleAdd(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/stats/bans", nil)
+	rec = httptest.NewRecorder()
+	m.HandleList(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	var bans []database.Ban
+	if err := json.NewDecoder(rec.Body).Decode(&bans); err != nil {
+		t.Fatalf("Failed to decode bans: %v", err)
+	}
+	if len(bans) != 1 || bans[0].CIDR != "192.0.2.0/24" || bans[0].ExpiresAt == nil {
+		t.Fatalf("Unexpected bans: %+v", bans)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/stats/bans?cidr=192.0.2.0/24", nil)
+	rec = httptest.NewRecorder()
+	m.HandleRemove(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	m.HandleRemove(rec, httptest.NewRequest(http.MethodDelete, "/stats/bans?cidr=192.0.2.0/24", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for missing ban, got %d", rec.Code)
+	}
+}
+
+func TestHandleAdd_BadRequests(t *testing.T) {
+	m := newTestManager(t, config.DefaultBanConfig())
+
+	for name, body := range map[string]string{
+		"malformed JSON":   `{`,
+		"invalid cidr":     `{"cidr":"nope"}`,
+		"invalid duration": `{"cidr":"192.0.2.1","duration":"soon"}`,
+		"negative":         `{"cidr":"192.0.2.1","duration":"-1h"}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			m.HandleAdd(rec, httptest.NewRequest(http.MethodPost, "/stats/bans", strings.NewReader(body)))
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("Expected status 400, got %d", rec.Code)
+			}
+		})
+	}
+}
+
+func TestHandleRemove_InvalidCIDR(t *testing.T) {
+	m := newTestManager(t, config.DefaultBanConfig())
+
+	rec := httptest.NewRecorder()
+	m.HandleRemove(rec, httptest.NewRequest(http.MethodDelete, "/stats/bans?cidr=bogus", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", rec.Code)
+	}
+}
+
+func TestHandleExport(t *testing.T) {
+	m := newTestManager(t, config.DefaultBanConfig())
+	if _, err := m.Ban("192.0.2.1", "test", SourceManual, 0); err != nil {
+		t.Fatalf("Failed to ban: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	m.HandleExport(rec, httptest.NewRequest(http.MethodGet, "/stats/bans/export", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	if rec.Body.String() != "192.0.2.1/32\n" {
+		t.Errorf("Unexpected text export: %q", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	m.HandleExport(rec, httptest.NewRequest(http.MethodGet, "/stats/bans/export?format=pf", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for unknown format, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,262 @@
+// Package banner serves low-interaction listeners for scanners of
+// protocols other than HTTP, such as SSH, Telnet, Redis, SMTP and RDP.
+// Each connection is greeted with the protocol's banner, answered much as
+// the protocol would, and recorded with the first bytes the client sent.
+package banner
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/config"
+	"github.com/dangogh/silver-eureka/internal/database"
+	"github.com/dangogh/silver-eureka/internal/listener"
+	"github.com/dangogh/silver-eureka/internal/metrics"
+)
+
+// acceptBackoff is the pause after a failed accept, such as when the
+// process is out of file descriptors
+const acceptBackoff = 100 * time.Millisecond
+
+// Store records connections
+type Store interface {
+	LogConnection(c database.Connection) error
+}
+
+// Server serves the banner listeners
+type Server struct {
+	store  Store
+	cfg    config.BannerConfig
+	banned func(ip string) bool
+
+	listeners []net.Listener
+	wg        sync.WaitGroup
+
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+	stopping bool
+}
+
+// New opens the listeners of cfg. Connections from addresses for which
+// banned, when not nil, returns true are closed without being recorded.
+func New(store Store, cfg config.BannerConfig, banned func(ip string) bool) (*Server, error) {
+	s := &Server{store: store, cfg: cfg, banned: banned, conns: make(map[net.Conn]struct{})}
+	for _, lc := range cfg.Listeners {
+		ln, err := listener.Listen(config.ListenerConfig{Address: lc.Address, Label: lc.Label, ProxyProtocol: lc.ProxyProtocol}, nil)
+		if err != nil {
+			for _, open := range s.listeners {
+				if err := open.Close(); err != nil {
+					// Never served, nothing to lose
+				}
+			}
+			return nil, err
+		}
+		s.listeners = append(s.listeners, ln)
+	}
+	return s, nil
+}
+
+// Start serves the listeners until Stop
+func (s *Server) Start() {
+	for i, ln := range s.listeners {
+		lc := s.cfg.Listeners[i]
+		slog.Info("Banner listener starting", "address", ln.Addr().String(), "protocol", lc.Protocol,
+			"label", lc.Label, "proxy_protocol", lc.ProxyProtocol)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serve(ln, lc)
+		}()
+	}
+}
+
+// Stop closes the listeners and open connections, and waits for the
+// connections to be recorded
+func (s *Server) Stop() {
+	s.mu.Lock()
+	s.stopping = true
+	for conn := range s.conns {
+		if err := conn.Close(); err != nil {
+			// Already closed by its handler
+		}
+	}
+	s.mu.Unlock()
+	for _, ln := range s.listeners {
+		if err := ln.Close(); err != nil {
+			slog.Warn("Failed to close banner listener", "address", ln.Addr().String(), "error", err)
+		}
+	}
+	s.wg.Wait()
+}
+
+// serve accepts connections on ln, handling at most MaxConns at a time
+func (s *Server) serve(ln net.Listener, lc config.BannerListenerConfig) {
+	slots := make(chan struct{}, s.cfg.MaxConns)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			slog.Warn("Banner listener failed to accept a connection", "address", ln.Addr().String(), "error", err)
+			time.Sleep(acceptBackoff)
+			continue
+		}
+		select {
+		case slots <- struct{}{}:
+		default:
+			metrics.BannerConnections.WithLabelValues(lc.Protocol, "dropped").Inc()
+			if err := conn.Close(); err != nil {
+				// Nothing was sent, nothing to lose
+			}
+			continue
+		}
+		if !s.track(conn) {
+			<-slots
+			if err := conn.Close(); err != nil {
+				// Stopping, nothing to lose
+			}
+			continue
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() { <-slots }()
+			defer s.untrack(conn)
+			s.handle(conn, lc)
+		}()
+	}
+}
+
+// track adds conn to the open connections, unless the server is stopping
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopping {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+// untrack closes conn and removes it from the open connections
+func (s *Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	if err := conn.Close(); err != nil {
+		// Closed by Stop, or by the client
+	}
+}
+
+// handle greets a client, captures what it sends until CaptureBytes, the
+// timeout or the client closing, and records the connection
+func (s *Server) handle(conn net.Conn, lc config.BannerListenerConfig) {
+	start := time.Now()
+	if err := conn.SetDeadline(start.Add(s.cfg.Timeout)); err != nil {
+		return
+	}
+	if err := listener.Handshake(conn); err != nil {
+		// Logged by the listener
+		return
+	}
+	ip, port := addresses(conn)
+	if s.banned != nil && s.banned(ip) {
+		metrics.BannerConnections.WithLabelValues(lc.Protocol, "banned").Inc()
+		return
+	}
+
+	proto := protocols[lc.Protocol]
+	greeting := lc.Banner
+	if greeting == "" {
+		greeting = proto.banner
+	}
+	payload := s.capture(conn, greeting, proto)
+
+	logConnection(s.store, database.Connection{
+		Timestamp: start,
+		IPAddress: ip,
+		Protocol:  lc.Protocol,
+		Listener:  lc.Label,
+		LocalPort: port,
+		Payload:   payload,
+	})
+}
+
+// Recorder returns a listener.Recorder recording the connections of
+// sniffing HTTP listeners as connections to banner listeners, except those
+// from addresses for which banned, when not nil, returns true
+func Recorder(store Store, banned func(ip string) bool) listener.Recorder {
+	return func(sn listener.Sniffed) {
+		ip, port := addresses(sn.Conn)
+		if banned != nil && banned(ip) {
+			metrics.BannerConnections.WithLabelValues(sn.Protocol, "banned").Inc()
+			return
+		}
+		logConnection(store, database.Connection{
+			IPAddress: ip,
+			Protocol:  sn.Protocol,
+			Listener:  sn.Label,
+			LocalPort: port,
+			Payload:   sn.Payload,
+		})
+	}
+}
+
+// addresses returns the client address of conn and the port it connected to
+func addresses(conn net.Conn) (string, int) {
+	ip := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	var port int
+	if addr, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		port = addr.Port
+	}
+	return ip, port
+}
+
+// logConnection records c, counting and logging the result
+func logConnection(store Store, c database.Connection) {
+	if err := store.LogConnection(c); err != nil {
+		metrics.BannerConnections.WithLabelValues(c.Protocol, "error").Inc()
+		slog.Error("Failed to record connection", "protocol", c.Protocol, "remote_addr", c.IPAddress, "error", err)
+		return
+	}
+	metrics.BannerConnections.WithLabelValues(c.Protocol, "logged").Inc()
+	slog.Debug("Recorded connection", "protocol", c.Protocol, "remote_addr", c.IPAddress, "port", c.LocalPort,
+		"bytes", len(c.Payload))
+}
+
+// capture sends greeting and returns the bytes the client sends, up to
+// CaptureBytes, answering them as proto does
+func (s *Server) capture(conn net.Conn, greeting string, proto protocol) []byte {
+	if greeting != "" {
+		if _, err := conn.Write([]byte(greeting)); err != nil {
+			return nil
+		}
+	}
+	captured := make([]byte, 0, s.cfg.CaptureBytes)
+	buf := make([]byte, min(s.cfg.CaptureBytes, 4096))
+	for len(captured) < s.cfg.CaptureBytes {
+		n, err := conn.Read(buf[:min(len(buf), s.cfg.CaptureBytes-len(captured))])
+		if n > 0 {
+			captured = append(captured, buf[:n]...)
+			if proto.reply != nil {
+				if reply := proto.reply(captured, captured[len(captured)-n:]); len(reply) > 0 {
+					if _, err := conn.Write(reply); err != nil {
+						break
+					}
+				}
+			}
+		}
+		if err != nil {
+			// The client closed, went quiet or was cut off by Stop
+			break
+		}
+	}
+	return captured
+}
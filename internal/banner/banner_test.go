@@ -0,0 +1,267 @@
+package banner
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/config"
+	"github.com/dangogh/silver-eureka/internal/database"
+	"github.com/dangogh/silver-eureka/internal/listener"
+)
+
+// memStore records connections in memory
+type memStore struct {
+	mu    sync.Mutex
+	conns []database.Connection
+	added chan struct{}
+}
+
+func newMemStore() *memStore {
+	return &memStore{added: make(chan struct{}, 16)}
+}
+
+func (m *memStore) LogConnection(c database.Connection) error {
+	m.mu.Lock()
+	m.conns = append(m.conns, c)
+	m.mu.Unlock()
+	m.added <- struct{}{}
+	return nil
+}
+
+// next waits for the next recorded connection
+func (m *memStore) next(t *testing.T) database.Connection {
+	t.Helper()
+	select {
+	case <-m.added:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a connection to be recorded")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.conns[len(m.conns)-1]
+}
+
+// count returns the number of recorded connections
+func (m *memStore) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.conns)
+}
+
+// start serves a banner listener for lc on a free port and returns its
+// address
+func start(t *testing.T, store Store, cfg config.BannerConfig, lc config.BannerListenerConfig, banned func(string) bool) string {
+	t.Helper()
+	lc.Address = "127.0.0.1:0"
+	cfg.Listeners = []config.BannerListenerConfig{lc}
+	s, err := New(store, cfg, banned)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	s.Start()
+	t.Cleanup(s.Stop)
+	return s.listeners[0].Addr().String()
+}
+
+func dial(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := conn.Close(); err != nil {
+			// Closing is best effort in tests
+		}
+	})
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("SetDeadline failed: %v", err)
+	}
+	return conn
+}
+
+func TestServer_SSH(t *testing.T) {
+	store := newMemStore()
+	addr := start(t, store, config.DefaultBannerConfig(), config.BannerListenerConfig{Protocol: config.BannerSSH, Label: "alt-ssh"}, nil)
+
+	conn := dial(t, addr)
+	greeting, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || !strings.HasPrefix(greeting, "SSH-2.0-OpenSSH") {
+		t.Fatalf("Expected an SSH banner, got %q (%v)", greeting, err)
+	}
+	if _, err := io.WriteString(conn, "SSH-2.0-Go\r\n"); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	c := store.next(t)
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatalf("Invalid address %q: %v", addr, err)
+	}
+	if c.Protocol != "ssh" || c.Listener != "alt-ssh" || c.IPAddress != "127.0.0.1" || strconv.Itoa(c.LocalPort) != port ||
+		string(c.Payload) != "SSH-2.0-Go\r\n" || c.Timestamp.IsZero() {
+		t.Errorf("Unexpected connection: %+v", c)
+	}
+}
+
+func TestServer_SMTP(t *testing.T) {
+	store := newMemStore()
+	lc := config.BannerListenerConfig{Protocol: config.BannerSMTP, Banner: "220 relay.example ESMTP\r\n"}
+	addr := start(t, store, config.DefaultBannerConfig(), lc, nil)
+
+	conn := dial(t, addr)
+	r := bufio.NewReader(conn)
+	for _, step := range []struct{ send, want string }{
+		{"", "220 relay.example ESMTP"},
+		{"HELO scanner\r\n", "250 mail.example.com"},
+		{"RCPT TO:<victim@example.org>\r\n", "554 5.7.1"},
+		{"QUIT\r\n", "221 2.0.0"},
+	} {
+		if step.send != "" {
+			if _, err := io.WriteString(conn, step.send); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+		}
+		line, err := r.ReadString('\n')
+		if err != nil || !strings.HasPrefix(line, step.want) {
+			t.Fatalf("After %q: expected %q, got %q (%v)", step.send, step.want, line, err)
+		}
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if c := store.next(t); string(c.Payload) != "HELO scanner\r\nRCPT TO:<victim@example.org>\r\nQUIT\r\n" {
+		t.Errorf("Unexpected payload %q", c.Payload)
+	}
+}
+
+func TestServer_CaptureLimitAndTimeout(t *testing.T) {
+	store := newMemStore()
+	cfg := config.DefaultBannerConfig()
+	cfg.CaptureBytes = 4
+	cfg.Timeout = 200 * time.Millisecond
+	addr := start(t, store, cfg, config.BannerListenerConfig{Protocol: config.BannerRaw}, nil)
+
+	// Capture stops at capture_bytes
+	conn := dial(t, addr)
+	if _, err := io.WriteString(conn, "abcdefgh"); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if c := store.next(t); string(c.Payload) != "abcd" {
+		t.Errorf("Expected the first 4 bytes, got %q", c.Payload)
+	}
+
+	// A client that sends nothing is recorded at the timeout
+	dial(t, addr)
+	if c := store.next(t); len(c.Payload) != 0 || c.Protocol != "raw" {
+		t.Errorf("Expected an empty payload, got %+v", c)
+	}
+}
+
+func TestServer_BannedAndFull(t *testing.T) {
+	store := newMemStore()
+	addr := start(t, store, config.DefaultBannerConfig(), config.BannerListenerConfig{Protocol: config.BannerRedis},
+		func(ip string) bool { return ip == "127.0.0.1" })
+
+	conn := dial(t, addr)
+	if _, err := io.WriteString(conn, "PING\r\n"); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if n, err := conn.Read(make([]byte, 16)); err == nil {
+		t.Errorf("Expected a banned client to be disconnected, read %d bytes", n)
+	}
+
+	cfg := config.DefaultBannerConfig()
+	cfg.MaxConns = 1
+	addr = start(t, store, cfg, config.BannerListenerConfig{Protocol: config.BannerRedis}, nil)
+	first := dial(t, addr)
+	if _, err := io.WriteString(first, "PING\r\n"); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if reply, err := bufio.NewReader(first).ReadString('\n'); err != nil || reply != "+PONG\r\n" {
+		t.Fatalf("Expected PONG, got %q (%v)", reply, err)
+	}
+	// The first connection holds the only slot
+	if n, err := dial(t, addr).Read(make([]byte, 16)); err == nil {
+		t.Errorf("Expected a connection over max_conns to be closed, read %d bytes", n)
+	}
+	if n := store.count(); n != 0 {
+		t.Errorf("Expected no connection recorded yet, got %d", n)
+	}
+}
+
+func TestReplies(t *testing.T) {
+	// Replies to the bytes sent in chunks, as read
+	reply := func(name string, chunks ...string) string {
+		var captured, out []byte
+		for _, chunk := range chunks {
+			captured = append(captured, chunk...)
+			if p := protocols[name]; p.reply != nil {
+				out = append(out, p.reply(captured, []byte(chunk))...)
+			}
+		}
+		return string(out)
+	}
+
+	if got := reply(config.BannerTelnet, "root\r", "\n", "admin\r\n"); got != "Password: \r\nLogin incorrect\r\nlogin: " {
+		t.Errorf("Unexpected telnet replies %q", got)
+	}
+	if got := reply(config.BannerSMTP, "EHLO x\r\nAU", "TH PLAIN AAAA\r\nVRFY root\r\n"); !strings.HasSuffix(got,
+		"250 8BITMIME\r\n535 5.7.8 Error: authentication failed\r\n502 5.5.2 Error: command not recognized\r\n") {
+		t.Errorf("Unexpected SMTP replies %q", got)
+	}
+	if got := reply(config.BannerRedis, "*1\r\n$4\r\nINFO\r\n"); got != "-NOAUTH Authentication required.\r\n" {
+		t.Errorf("Unexpected Redis reply %q", got)
+	}
+	// An X.224 Connection Request, then the TLS ClientHello it leads to
+	if got := reply(config.BannerRDP, "\x03\x00\x00\x13\x0e\xe0\x00\x00\x00\x00\x00\x01\x00\x08\x00\x03\x00\x00\x00", "\x16\x03\x01"); got != string(rdpConfirm) {
+		t.Errorf("Unexpected RDP reply %x", got)
+	}
+	if got := reply(config.BannerRaw, "anything\n"); got != "" {
+		t.Errorf("Expected no raw reply, got %q", got)
+	}
+}
+
+func TestProtocols(t *testing.T) {
+	for _, name := range config.BannerProtocols {
+		if _, ok := protocols[name]; !ok {
+			t.Errorf("Protocol %q has no implementation", name)
+		}
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	store := newMemStore()
+	record := Recorder(store, func(ip string) bool { return ip == "192.0.2.66" })
+
+	conn := &addrConn{remote: &net.TCPAddr{IP: net.ParseIP("198.51.100.7"), Port: 40000}, local: &net.TCPAddr{Port: 8080}}
+	record(listener.Sniffed{Conn: conn, Label: "web", Protocol: listener.ProtocolTLS, Payload: []byte("\x16\x03\x01")})
+	if c := store.next(t); c.IPAddress != "198.51.100.7" || c.LocalPort != 8080 || c.Listener != "web" ||
+		c.Protocol != "tls" || string(c.Payload) != "\x16\x03\x01" {
+		t.Errorf("Unexpected connection: %+v", c)
+	}
+
+	conn.remote = &net.TCPAddr{IP: net.ParseIP("192.0.2.66"), Port: 40000}
+	record(listener.Sniffed{Conn: conn, Protocol: listener.ProtocolUnknown})
+	if n := store.count(); n != 1 {
+		t.Errorf("Expected a banned client not to be recorded, got %d connections", n)
+	}
+}
+
+// addrConn is a connection with only addresses
+type addrConn struct {
+	net.Conn
+	remote, local net.Addr
+}
+
+func (c *addrConn) RemoteAddr() net.Addr { return c.remote }
+func (c *addrConn) LocalAddr() net.Addr  { return c.local }
@@ -0,0 +1,107 @@
+package banner
+
+import (
+	"bytes"
+
+	"github.com/dangogh/silver-eureka/internal/config"
+)
+
+// protocol imitates a service well enough for a scanner to show what it
+// is after
+type protocol struct {
+	// banner is sent as soon as a client connects, unless the listener
+	// sets its own; "" = none, the client speaks first
+	banner string
+	// reply returns the answer to chunk, the bytes just read, given all
+	// the bytes captured so far, ending with chunk; nil = none
+	reply func(captured, chunk []byte) []byte
+}
+
+// protocols holds the protocol of each config.BannerProtocols name
+var protocols = map[string]protocol{
+	config.BannerSSH:    {banner: "SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.10\r\n"},
+	config.BannerTelnet: {banner: "\r\nUbuntu 22.04.4 LTS\r\nlogin: ", reply: telnetReply},
+	config.BannerRedis:  {reply: redisReply},
+	config.BannerSMTP:   {banner: "220 mail.example.com ESMTP Postfix (Ubuntu)\r\n", reply: smtpReply},
+	config.BannerRDP:    {reply: rdpReply},
+	config.BannerRaw:    {},
+}
+
+// completedLines returns the lines of captured that chunk completes, and
+// the number of lines before them
+func completedLines(captured, chunk []byte) ([][]byte, int) {
+	start := bytes.LastIndexByte(captured[:len(captured)-len(chunk)], '\n') + 1
+	lines := bytes.Split(captured[start:], []byte("\n"))
+	return lines[:len(lines)-1], bytes.Count(captured[:start], []byte("\n"))
+}
+
+// telnetReply asks for a password after each user name and refuses it
+func telnetReply(captured, chunk []byte) []byte {
+	lines, before := completedLines(captured, chunk)
+	var reply []byte
+	for i := range lines {
+		if (before+i)%2 == 0 {
+			reply = append(reply, "Password: "...)
+		} else {
+			reply = append(reply, "\r\nLogin incorrect\r\nlogin: "...)
+		}
+	}
+	return reply
+}
+
+// redisReply answers PING and refuses every other command for want of
+// authentication
+func redisReply(captured, chunk []byte) []byte {
+	if !bytes.HasSuffix(chunk, []byte("\n")) {
+		return nil
+	}
+	if bytes.Contains(bytes.ToUpper(chunk), []byte("PING")) {
+		return []byte("+PONG\r\n")
+	}
+	return []byte("-NOAUTH Authentication required.\r\n")
+}
+
+// smtpReplies are the answers to SMTP commands, by verb
+var smtpReplies = map[string]string{
+	"EHLO": "250-mail.example.com\r\n250-PIPELINING\r\n250-SIZE 10240000\r\n250-AUTH PLAIN LOGIN\r\n250 8BITMIME\r\n",
+	"HELO": "250 mail.example.com\r\n",
+	"MAIL": "250 2.1.0 Ok\r\n",
+	"RCPT": "554 5.7.1 Relay access denied\r\n",
+	"DATA": "554 5.5.1 Error: no valid recipients\r\n",
+	"AUTH": "535 5.7.8 Error: authentication failed\r\n",
+	"RSET": "250 2.0.0 Ok\r\n",
+	"NOOP": "250 2.0.0 Ok\r\n",
+	"QUIT": "221 2.0.0 Bye\r\n",
+}
+
+// smtpReply answers each command as a mail server that relays nothing
+func smtpReply(captured, chunk []byte) []byte {
+	lines, _ := completedLines(captured, chunk)
+	var reply []byte
+	for _, line := range lines {
+		verb, _, _ := bytes.Cut(bytes.TrimSpace(line), []byte(" "))
+		answer, ok := smtpReplies[string(bytes.ToUpper(verb))]
+		if !ok {
+			answer = "502 5.5.2 Error: command not recognized\r\n"
+		}
+		reply = append(reply, answer...)
+	}
+	return reply
+}
+
+// rdpConfirm is an X.224 Connection Confirm selecting TLS, after which the
+// client sends a TLS ClientHello
+var rdpConfirm = []byte{
+	0x03, 0x00, 0x00, 0x13, // TPKT, 19 bytes
+	0x0e, 0xd0, 0x00, 0x00, 0x12, 0x34, 0x00, // X.224 Connection Confirm
+	0x02, 0x00, 0x08, 0x00, 0x01, 0x00, 0x00, 0x00, // RDP_NEG_RSP: PROTOCOL_SSL
+}
+
+// rdpReply confirms a connection request, the first thing an RDP client
+// sends
+func rdpReply(captured, chunk []byte) []byte {
+	if len(captured) != len(chunk) || !bytes.HasPrefix(chunk, []byte{0x03, 0x00}) {
+		return nil
+	}
+	return rdpConfirm
+}
@@ -0,0 +1,170 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/dangogh/silver-eureka/internal/auth"
+	"golang.org/x/term"
+)
+
+// minPasswordLength is the shortest password user add and passwd accept
+const minPasswordLength = 8
+
+// runUser implements "user add|passwd|delete <username>"
+func runUser(env Env, args []string) error {
+	if len(args) == 0 {
+		return usageError("missing subcommand")
+	}
+	action := args[0]
+	if action != "add" && action != "passwd" && action != "delete" {
+		return usageError("unknown subcommand %q", action)
+	}
+
+	fs := newFlagSet(env, "user "+action)
+	cfg, err := parseConfig(fs, args[1:])
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || fs.Arg(0) == "" {
+		return usageError("expected one username")
+	}
+	username := fs.Arg(0)
+
+	if action == "delete" {
+		db, err := openDatabase(cfg.DBPath)
+		if err != nil {
+			return err
+		}
+		defer closeDatabase(env, db)
+
+		deleted, err := db.DeleteUser(username)
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			return fmt.Errorf("user %q not found", username)
+		}
+		fmt.Fprintf(env.Stdout, "user %q deleted\n", username)
+		return nil
+	}
+
+	password, err := readPassword(env)
+	if err != nil {
+		return err
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDatabase(cfg.DBPath)
+	if err != nil {
+		return err
+	}
+	defer closeDatabase(env, db)
+
+	if action == "add" {
+		if err := db.CreateUser(username, hash); err != nil {
+			return err
+		}
+		fmt.Fprintf(env.Stdout, "user %q created\n", username)
+		return nil
+	}
+	if err := db.SetPassword(username, hash); err != nil {
+		return err
+	}
+	fmt.Fprintf(env.Stdout, "password changed for %q\n", username)
+	return nil
+}
+
+// readPassword prompts for a password twice without echo when stdin is a
+// terminal; otherwise it reads the first line of stdin, for scripts
+func readPassword(env Env) (string, error) {
+	if f, ok := env.Stdin.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		fmt.Fprint(env.Stderr, "Password: ")
+		first, err := term.ReadPassword(int(f.Fd()))
+		fmt.Fprintln(env.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("failed to read password: %w", err)
+		}
+		fmt.Fprint(env.Stderr, "Repeat password: ")
+		second, err := term.ReadPassword(int(f.Fd()))
+		fmt.Fprintln(env.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("failed to read password: %w", err)
+		}
+		if string(first) != string(second) {
+			return "", fmt.Errorf("passwords do not match")
+		}
+		return checkPassword(string(first))
+	}
+
+	line, err := bufio.NewReader(env.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	return checkPassword(strings.TrimRight(line, "\r\n"))
+}
+
+// checkPassword rejects passwords that are too short
+func checkPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+	return password, nil
+}
+
+// runToken implements "token create|revoke <name>". A new token is printed
+// once; only its hash is stored.
+func runToken(env Env, args []string) error {
+	if len(args) == 0 {
+		return usageError("missing subcommand")
+	}
+	action := args[0]
+	if action != "create" && action != "revoke" {
+		return usageError("unknown subcommand %q", action)
+	}
+
+	fs := newFlagSet(env, "token "+action)
+	cfg, err := parseConfig(fs, args[1:])
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || fs.Arg(0) == "" {
+		return usageError("expected one token name")
+	}
+	name := fs.Arg(0)
+
+	db, err := openDatabase(cfg.DBPath)
+	if err != nil {
+		return err
+	}
+	defer closeDatabase(env, db)
+
+	if action == "revoke" {
+		deleted, err := db.DeleteAPIToken(name)
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			return fmt.Errorf("token %q not found", name)
+		}
+		fmt.Fprintf(env.Stdout, "token %q revoked\n", name)
+		return nil
+	}
+
+	token, hash, err := auth.NewToken()
+	if err != nil {
+		return err
+	}
+	if err := db.CreateAPIToken(name, hash); err != nil {
+		return err
+	}
+	fmt.Fprintf(env.Stderr, "token %q created; it is shown only once:\n", name)
+	fmt.Fprintln(env.Stdout, token)
+	return nil
+}
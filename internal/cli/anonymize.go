@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/anonymize"
+)
+
+// runAnonymize implements "anonymize", which rewrites the addresses stored
+// for older requests in the configured mode, or the one given with -mode
+func runAnonymize(env Env, args []string) error {
+	fs := newFlagSet(env, "anonymize")
+	var before time.Time
+	fs.Var(timeFlag{&before, time.Now()}, "before", "Anonymize addresses stored for requests older than this time")
+	mode := fs.String("mode", "", "truncate or hmac (default: the configured anonymize mode)")
+	cfg, err := parseConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	if before.IsZero() {
+		return usageError("-before is required")
+	}
+	if *mode == "" {
+		*mode = cfg.Anonymize.Mode
+	}
+	if *mode == anonymize.Raw {
+		return usageError("-mode must be truncate or hmac")
+	}
+	anon, err := anonymize.New(*mode, cfg.Anonymize.HMACKeys)
+	if err != nil {
+		return usageError("%v", err)
+	}
+
+	db, err := openDatabase(cfg.DBPath)
+	if err != nil {
+		return err
+	}
+	defer closeDatabase(env, db)
+
+	res, err := db.AnonymizeBefore(before, anon)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(env.Stdout, "anonymized (%s) addresses before %s: %d logs, %d rollup rows, %d rate limit counters, %d connections\n",
+		anon.Mode(), res.Before.Format(time.RFC3339), res.Logs, res.Rollups, res.Drops, res.Connections)
+	return nil
+}
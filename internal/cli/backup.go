@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/dangogh/silver-eureka/internal/backup"
+	"github.com/dangogh/silver-eureka/internal/database"
+)
+
+// runBackup implements "backup", which writes a compressed snapshot into
+// the backup directory (rotating old ones) or to the -o file
+func runBackup(env Env, args []string) error {
+	fs := newFlagSet(env, "backup")
+	output := fs.String("o", "", "Write the snapshot to this file instead of the backup directory")
+	cfg, err := parseConfig(fs, args)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDatabase(cfg.DBPath)
+	if err != nil {
+		return err
+	}
+	defer closeDatabase(env, db)
+
+	if *output != "" {
+		size, err := backup.Write(db, *output)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(env.Stdout, "wrote %s (%d bytes)\n", *output, size)
+		return nil
+	}
+
+	m, err := backup.NewManager(db, cfg.Backup)
+	if err != nil {
+		return err
+	}
+	s, err := m.Create()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(env.Stdout, "wrote %s (%d bytes)\n", filepath.Join(cfg.Backup.Dir, s.Name), s.Size)
+	return nil
+}
+
+// runRestore implements "restore <snapshot>". The snapshot may be a path
+// or the name of a file in the backup directory.
+func runRestore(env Env, args []string) error {
+	fs := newFlagSet(env, "restore")
+	cfg, err := parseConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError("expected one snapshot file")
+	}
+
+	src := fs.Arg(0)
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		if candidate := filepath.Join(cfg.Backup.Dir, src); candidate != src {
+			if _, err := os.Stat(candidate); err == nil {
+				src = candidate
+			}
+		}
+	}
+
+	version, err := backup.Restore(src, cfg.DBPath)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(env.Stdout, "restored %s to %s (schema version %d)\n", src, cfg.DBPath, version)
+	if version < database.LatestSchemaVersion() {
+		fmt.Fprintf(env.Stdout, "the schema will be migrated to version %d on next start\n", database.LatestSchemaVersion())
+	}
+	if _, err := os.Stat(cfg.DBPath + ".pre-restore"); err == nil {
+		fmt.Fprintf(env.Stdout, "previous database kept as %s.pre-restore\n", cfg.DBPath)
+	}
+	return nil
+}
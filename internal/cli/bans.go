@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/ban"
+)
+
+// runBan implements "ban list|add|remove". A running server picks up the
+// changes within a minute.
+func runBan(env Env, args []string) error {
+	if len(args) == 0 {
+		return usageError("missing subcommand")
+	}
+	action := args[0]
+	if action != "list" && action != "add" && action != "remove" {
+		return usageError("unknown subcommand %q", action)
+	}
+
+	fs := newFlagSet(env, "ban "+action)
+	var reason *string
+	var duration *time.Duration
+	if action == "add" {
+		reason = fs.String("reason", "", "Why the address is banned")
+		duration = fs.Duration("duration", 0, "How long the ban lasts (0 = permanent)")
+	}
+	cfg, err := parseConfig(fs, args[1:])
+	if err != nil {
+		return err
+	}
+	if action == "list" && fs.NArg() != 0 {
+		return usageError("unexpected arguments")
+	}
+	if action != "list" && (fs.NArg() != 1 || fs.Arg(0) == "") {
+		return usageError("expected one address or CIDR")
+	}
+	if duration != nil && *duration < 0 {
+		return usageError("duration must not be negative")
+	}
+
+	db, err := openDatabase(cfg.DBPath)
+	if err != nil {
+		return err
+	}
+	defer closeDatabase(env, db)
+
+	bans, err := ban.NewManager(db, cfg.Ban)
+	if err != nil {
+		return err
+	}
+	defer bans.Stop()
+
+	switch action {
+	case "add":
+		b, err := bans.Ban(fs.Arg(0), *reason, ban.SourceManual, *duration)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(env.Stdout, "banned %s\n", b.CIDR)
+	case "remove":
+		removed, err := bans.Unban(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		if !removed {
+			return fmt.Errorf("ban on %q not found", fs.Arg(0))
+		}
+		fmt.Fprintf(env.Stdout, "lifted ban on %s\n", fs.Arg(0))
+	default:
+		list, err := bans.List()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(env.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "CIDR\tSOURCE\tOFFENSES\tCREATED\tEXPIRES\tREASON")
+		for _, b := range list {
+			expires := "never"
+			if b.ExpiresAt != nil {
+				expires = formatTime(*b.ExpiresAt)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n", b.CIDR, b.Source, b.Offenses, formatTime(b.CreatedAt), expires, b.Reason)
+		}
+		return tw.Flush()
+	}
+	return nil
+}
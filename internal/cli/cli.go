@@ -0,0 +1,260 @@
+// Package cli implements the gather-requests admin subcommands, which work
+// directly against the database file
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/config"
+	"github.com/dangogh/silver-eureka/internal/database"
+)
+
+// Env holds the streams a command reads and writes
+type Env struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// command is one admin subcommand
+type command struct {
+	usage string
+	run   func(env Env, args []string) error
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"query":     {"query [-ip addr|cidr] [-url substr] [-since t] [-until t] [-limit n] [-format table|json|ndjson|csv]", runQuery},
+		"stats":     {"stats summary|endpoints|sources [-since t] [-until t] [-limit n] [-format table|json]", runStats},
+		"export":    {"export [-format json|ndjson|csv] [-o file] [-ip addr|cidr] [-url substr] [-since t] [-until t]", runExport},
+		"import":    {"import [-format auto|clf|nginx-json|json|ndjson|csv] [-sensor id] [-dry-run] [file]", runImport},
+		"purge":     {"purge -before t [-vacuum]", runPurge},
+		"vacuum":    {"vacuum", runVacuum},
+		"anonymize": {"anonymize -before t [-mode truncate|hmac]", runAnonymize},
+		"user":      {"user add|passwd|delete <username>", runUser},
+		"token":     {"token create|revoke <name>", runToken},
+		"ban":       {"ban list|add|remove [-reason text] [-duration d] <addr|cidr>", runBan},
+		"migrate":   {"migrate [-status]", runMigrate},
+		"backup":    {"backup [-o file]", runBackup},
+		"restore":   {"restore <snapshot>", runRestore},
+		"config":    {"config check", runConfig},
+	}
+}
+
+// usageErr is a command line mistake, reported with exit status 2
+type usageErr struct {
+	msg string
+}
+
+func (e *usageErr) Error() string {
+	return e.msg
+}
+
+// IsCommand reports whether name is an admin subcommand
+func IsCommand(name string) bool {
+	_, ok := commands[name]
+	return ok || name == "help"
+}
+
+// Run executes the subcommand named by args[0] and returns the process
+// exit status: 0 on success, 1 on failure and 2 on bad usage
+func Run(env Env, args []string) int {
+	if len(args) == 0 || args[0] == "help" {
+		printUsage(env.Stdout)
+		return 0
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(env.Stderr, "unknown command %q\n", args[0])
+		printUsage(env.Stderr)
+		return 2
+	}
+
+	err := cmd.run(env, args[1:])
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.As(err, new(*usageErr)):
+		fmt.Fprintf(env.Stderr, "%v\nusage: gather-requests %s\n", err, cmd.usage)
+		return 2
+	default:
+		fmt.Fprintf(env.Stderr, "%s: %v\n", args[0], err)
+		return 1
+	}
+}
+
+func printUsage(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "usage: gather-requests [serve] [flags]")
+	for _, name := range names {
+		fmt.Fprintf(w, "       gather-requests %s\n", commands[name].usage)
+	}
+	fmt.Fprintln(w, "\nEvery command also accepts the server's -config and -db flags.")
+}
+
+// usageError reports a command line mistake
+func usageError(format string, args ...any) error {
+	return &usageErr{msg: fmt.Sprintf(format, args...)}
+}
+
+// newFlagSet returns a flag set for a command whose errors are reported by Run
+func newFlagSet(env Env, name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(env.Stderr)
+	return fs
+}
+
+// parseConfig parses a command's flags along with the server configuration
+// (config file, environment and flags), which supplies the database path.
+// Command flags must be defined on fs first.
+func parseConfig(fs *flag.FlagSet, args []string) (*config.Config, error) {
+	cfg, err := config.Parse(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	// The flag package stops at the first argument, so later flags would
+	// silently be taken as arguments
+	for _, arg := range fs.Args() {
+		if strings.HasPrefix(arg, "-") && arg != "-" {
+			return nil, usageError("flag %s must come before the command's arguments", arg)
+		}
+	}
+	return cfg, nil
+}
+
+// openDatabase opens the database at path, creating its directory and
+// applying pending migrations as the server does
+func openDatabase(path string) (*database.DB, error) {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create database directory: %w", err)
+		}
+	}
+	db, err := database.New(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	return db, nil
+}
+
+// closeDatabase closes db, reporting a failure on stderr
+func closeDatabase(env Env, db *database.DB) {
+	if err := db.Close(); err != nil {
+		fmt.Fprintf(env.Stderr, "failed to close database: %v\n", err)
+	}
+}
+
+// parseTime accepts an RFC 3339 time, a date ("2006-01-02"), a date and
+// time ("2006-01-02 15:04"), or an age such as "90d" or "36h" measured back
+// from now
+func parseTime(value string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (want RFC 3339, YYYY-MM-DD[ HH:MM[:SS]] or an age like 30d or 12h)", value)
+}
+
+// timeFlag is a flag.Value holding a time parsed by parseTime
+type timeFlag struct {
+	t   *time.Time
+	now time.Time
+}
+
+func (f timeFlag) String() string {
+	if f.t == nil || f.t.IsZero() {
+		return ""
+	}
+	return f.t.Format(time.RFC3339)
+}
+
+func (f timeFlag) Set(value string) error {
+	t, err := parseTime(value, f.now)
+	if err != nil {
+		return err
+	}
+	*f.t = t
+	return nil
+}
+
+// prefixFlag is a flag.Value holding an address or CIDR prefix filter
+type prefixFlag struct {
+	p *netip.Prefix
+}
+
+func (f prefixFlag) String() string {
+	if f.p == nil || !f.p.IsValid() {
+		return ""
+	}
+	return f.p.String()
+}
+
+func (f prefixFlag) Set(value string) error {
+	p, err := database.ParseIPFilter(value)
+	if err != nil {
+		return err
+	}
+	*f.p = p
+	return nil
+}
+
+// filterFlags defines the log filter flags shared by query and export
+func filterFlags(fs *flag.FlagSet, f *database.LogFilter) {
+	now := time.Now()
+	fs.StringVar(&f.IP, "ip", "", "Only logs from this address or CIDR prefix")
+	fs.StringVar(&f.URL, "url", "", "Only logs whose URL contains this text")
+	fs.Var(timeFlag{&f.Since, now}, "since", "Only logs at or after this time")
+	fs.Var(timeFlag{&f.Until, now}, "until", "Only logs before this time")
+}
+
+// runConfig implements "config check", which validates the configuration
+// the server would start with and prints every problem found
+func runConfig(env Env, args []string) error {
+	if len(args) == 0 || args[0] != "check" {
+		return usageError("missing subcommand")
+	}
+
+	fs := newFlagSet(env, "config check")
+	if _, err := config.Parse(fs, args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return fmt.Errorf("configuration invalid:\n%w", err)
+	}
+	fmt.Fprintln(env.Stdout, "configuration OK")
+	return nil
+}
@@ -0,0 +1,422 @@
+package cli
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/auth"
+	"github.com/dangogh/silver-eureka/internal/database"
+)
+
+// runCLI runs a command with stdin and returns its exit status, stdout
+// and stderr
+func runCLI(t *testing.T, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	env := Env{Stdin: strings.NewReader(stdin), Stdout: &stdout, Stderr: &stderr}
+	code := Run(env, args)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestImportExportQuery(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "cli.db")
+	input := `[
+		{"IPAddress":"10.0.0.1","URL":"/wp-login.php","Timestamp":"2025-01-01T00:00:00Z"},
+		{"IPAddress":"192.0.2.1","URL":"/.env","Timestamp":"2025-01-02T00:00:00Z"}
+	]`
+
+	code, out, errOut := runCLI(t, input, "import", "-db="+dbPath)
+	if code != 0 {
+		t.Fatalf("import exited %d: %s", code, errOut)
+	}
+	if !strings.Contains(out, "imported 2 logs") {
+		t.Errorf("Unexpected import output: %s", out)
+	}
+
+	code, out, errOut = runCLI(t, "", "query", "-db="+dbPath, "-ip=10.0.0.0/8", "-format=json")
+	if code != 0 {
+		t.Fatalf("query exited %d: %s", code, errOut)
+	}
+	var logs []database.RequestLog
+	if err := json.Unmarshal([]byte(out), &logs); err != nil {
+		t.Fatalf("Failed to parse query output: %v\n%s", err, out)
+	}
+	if len(logs) != 1 || logs[0].URL != "/wp-login.php" {
+		t.Errorf("Unexpected query result: %+v", logs)
+	}
+
+	code, out, _ = runCLI(t, "", "query", "-db="+dbPath)
+	if code != 0 || !strings.Contains(out, "IP ADDRESS") || !strings.Contains(out, "/.env") {
+		t.Errorf("Unexpected table output (exit %d): %s", code, out)
+	}
+
+	for _, format := range []string{"json", "ndjson", "csv"} {
+		t.Run(format, func(t *testing.T) {
+			code, exported, errOut := runCLI(t, "", "export", "-db="+dbPath, "-format="+format)
+			if code != 0 {
+				t.Fatalf("export exited %d: %s", code, errOut)
+			}
+
+			// Importing an export into a fresh database round-trips the logs
+			copyPath := filepath.Join(t.TempDir(), "copy.db")
+			if code, _, errOut := runCLI(t, exported, "import", "-db="+copyPath, "-format="+format); code != 0 {
+				t.Fatalf("import exited %d: %s", code, errOut)
+			}
+			code, out, _ := runCLI(t, "", "export", "-db="+copyPath, "-format="+format)
+			if code != 0 {
+				t.Fatalf("second export exited %d", code)
+			}
+			if out != exported {
+				t.Errorf("Round trip changed the export:\n%s\nvs\n%s", exported, out)
+			}
+		})
+	}
+}
+
+func TestStats(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "cli.db")
+	if code, _, errOut := runCLI(t, `[{"IPAddress":"192.0.2.1","URL":"/a"},{"IPAddress":"192.0.2.1","URL":"/b"}]`, "import", "-db="+dbPath); code != 0 {
+		t.Fatalf("import exited %d: %s", code, errOut)
+	}
+
+	code, out, _ := runCLI(t, "", "stats", "summary", "-db="+dbPath, "-format=json")
+	if code != 0 {
+		t.Fatalf("stats summary exited %d", code)
+	}
+	var summary database.Summary
+	if err := json.Unmarshal([]byte(out), &summary); err != nil {
+		t.Fatalf("Failed to parse summary: %v", err)
+	}
+	if summary.TotalRequests != 2 || summary.UniqueURLs != 2 {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+
+	code, out, _ = runCLI(t, "", "stats", "endpoints", "-db="+dbPath, "-limit=1")
+	if code != 0 || strings.Count(strings.TrimSpace(out), "\n") != 1 {
+		t.Errorf("Expected header and one row (exit %d): %s", code, out)
+	}
+
+	code, out, _ = runCLI(t, "", "stats", "endpoints", "-db="+dbPath, "-group-by=normalized", "-format=json")
+	if code != 0 || !strings.Contains(out, `"/a"`) {
+		t.Errorf("Expected endpoints by normalized path (exit %d): %s", code, out)
+	}
+	if code, _, _ := runCLI(t, "", "stats", "endpoints", "-db="+dbPath, "-group-by=host"); code != 2 {
+		t.Errorf("Expected exit 2 for an unknown grouping, got %d", code)
+	}
+
+	code, out, _ = runCLI(t, "", "stats", "sources", "-db="+dbPath, "-ip=192.0.2.0/24", "-prefix-v4=24")
+	if code != 0 || !strings.Contains(out, "192.0.2.0/24") {
+		t.Errorf("Expected sources grouped by /24 (exit %d): %s", code, out)
+	}
+	if code, _, _ := runCLI(t, "", "stats", "sources", "-db="+dbPath, "-ip=bogus"); code != 1 {
+		t.Errorf("Expected exit 1 for an invalid IP filter, got %d", code)
+	}
+
+	if code, _, _ := runCLI(t, "", "stats", "bogus", "-db="+dbPath); code != 2 {
+		t.Errorf("Expected exit 2 for unknown report, got %d", code)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "cli.db")
+	old := time.Now().AddDate(0, 0, -40).UTC().Format(time.RFC3339)
+	input := `[{"IPAddress":"192.0.2.1","URL":"/old","Timestamp":"` + old + `"},{"IPAddress":"192.0.2.1","URL":"/new"}]`
+	if code, _, errOut := runCLI(t, input, "import", "-db="+dbPath); code != 0 {
+		t.Fatalf("import exited %d: %s", code, errOut)
+	}
+
+	if code, _, _ := runCLI(t, "", "purge", "-db="+dbPath); code != 2 {
+		t.Errorf("Expected exit 2 without -before, got %d", code)
+	}
+	code, out, errOut := runCLI(t, "", "purge", "-db="+dbPath, "-before=30d", "-vacuum")
+	if code != 0 {
+		t.Fatalf("purge exited %d: %s", code, errOut)
+	}
+	if !strings.HasPrefix(out, "deleted 1 logs") {
+		t.Errorf("Unexpected purge output: %s", out)
+	}
+	if code, _, _ := runCLI(t, "", "vacuum", "-db="+dbPath); code != 0 {
+		t.Errorf("vacuum exited %d", code)
+	}
+}
+
+func TestAnonymize(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "cli.db")
+	old := time.Now().AddDate(0, 0, -40).UTC().Format(time.RFC3339)
+	input := `[{"IPAddress":"192.0.2.1","URL":"/old","Timestamp":"` + old + `"},{"IPAddress":"192.0.2.1","URL":"/new"}]`
+	if code, _, errOut := runCLI(t, input, "import", "-db="+dbPath); code != 0 {
+		t.Fatalf("import exited %d: %s", code, errOut)
+	}
+
+	if code, _, _ := runCLI(t, "", "anonymize", "-db="+dbPath, "-before=30d"); code != 2 {
+		t.Errorf("Expected exit 2 with the raw mode configured, got %d", code)
+	}
+	code, out, errOut := runCLI(t, "", "anonymize", "-db="+dbPath, "-before=30d", "-mode=truncate")
+	if code != 0 {
+		t.Fatalf("anonymize exited %d: %s", code, errOut)
+	}
+	if !strings.Contains(out, ": 1 logs, 2 rollup rows") {
+		t.Errorf("Unexpected anonymize output: %s", out)
+	}
+
+	_, out, _ = runCLI(t, "", "query", "-db="+dbPath, "-format=csv")
+	if !strings.Contains(out, "192.0.2.0,/old") || !strings.Contains(out, "192.0.2.1,/new") {
+		t.Errorf("Expected only the old log anonymized, got:\n%s", out)
+	}
+	_, out, _ = runCLI(t, "", "stats", "summary", "-db="+dbPath)
+	if !strings.Contains(out, "raw, truncate") {
+		t.Errorf("Expected the summary to list both modes, got:\n%s", out)
+	}
+}
+
+func TestImportCompressed(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "cli.db")
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(`{"IPAddress":"192.0.2.1","URL":"/archived","Timestamp":"2025-01-01T00:00:00Z"}` + "\n")); err != nil {
+		t.Fatalf("Failed to compress input: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to compress input: %v", err)
+	}
+
+	code, out, errOut := runCLI(t, buf.String(), "import", "-db="+dbPath, "-format=ndjson")
+	if code != 0 {
+		t.Fatalf("import exited %d: %s", code, errOut)
+	}
+	if out != "imported 1 logs\n" {
+		t.Errorf("Unexpected import output: %s", out)
+	}
+}
+
+func TestImportAccessLog(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "cli.db")
+	input := `192.0.2.1 - - [10/Oct/2023:13:55:36 -0700] "GET /wp-login.php HTTP/1.1" 404 153 "-" "curl/8.0"
+192.0.2.2 - - [10/Oct/2023:13:55:37 -0700] "GET /.env HTTP/1.1" 404 153 "-" "curl/8.0"
+not a log line
+`
+
+	code, out, errOut := runCLI(t, input, "import", "-db="+dbPath, "-dry-run")
+	if code != 0 {
+		t.Fatalf("import exited %d: %s", code, errOut)
+	}
+	if out != "would import 2 logs, skipped 0 duplicates and 1 invalid records\n" {
+		t.Errorf("Unexpected dry run output: %s", out)
+	}
+	if !strings.Contains(errOut, "skipped line 3: ") {
+		t.Errorf("Expected the invalid line reported, got %s", errOut)
+	}
+
+	if code, out, errOut = runCLI(t, input, "import", "-db="+dbPath, "-sensor=web-1"); code != 0 {
+		t.Fatalf("import exited %d: %s", code, errOut)
+	}
+	if !strings.HasPrefix(out, "imported 2 logs") {
+		t.Errorf("Unexpected import output: %s", out)
+	}
+	if code, out, errOut = runCLI(t, input, "import", "-db="+dbPath, "-sensor=web-1", "-format=clf"); code != 0 {
+		t.Fatalf("import exited %d: %s", code, errOut)
+	}
+	if out != "imported 0 logs, skipped 2 duplicates and 1 invalid records\n" {
+		t.Errorf("Expected a re-import to skip everything, got %s", out)
+	}
+
+	code, out, errOut = runCLI(t, "", "query", "-db="+dbPath, "-format=ndjson")
+	if code != 0 {
+		t.Fatalf("query exited %d: %s", code, errOut)
+	}
+	if strings.Count(out, `"web-1"`) != 2 {
+		t.Errorf("Expected 2 logs tagged web-1, got %s", out)
+	}
+
+	for _, args := range [][]string{{"-format=xml"}, {"-sensor=bad id"}} {
+		if code, _, _ := runCLI(t, input, append([]string{"import", "-db=" + dbPath}, args...)...); code != 2 {
+			t.Errorf("import %v exited %d, want 2", args, code)
+		}
+	}
+}
+
+func TestUserAndToken(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "cli.db")
+
+	if code, _, errOut := runCLI(t, "short\n", "user", "add", "-db="+dbPath, "alice"); code != 1 || !strings.Contains(errOut, "at least") {
+		t.Errorf("Expected short password to be rejected (exit %d): %s", code, errOut)
+	}
+	if code, _, errOut := runCLI(t, "first-password\n", "user", "add", "-db="+dbPath, "alice"); code != 0 {
+		t.Fatalf("user add exited %d: %s", code, errOut)
+	}
+	if code, _, _ := runCLI(t, "first-password\n", "user", "add", "-db="+dbPath, "alice"); code != 1 {
+		t.Errorf("Expected duplicate user add to fail, got exit %d", code)
+	}
+	if code, _, errOut := runCLI(t, "second-password\n", "user", "passwd", "-db="+dbPath, "alice"); code != 0 {
+		t.Fatalf("user passwd exited %d: %s", code, errOut)
+	}
+	if code, _, _ := runCLI(t, "second-password\n", "user", "passwd", "-db="+dbPath, "bob"); code != 1 {
+		t.Errorf("Expected passwd for unknown user to fail, got exit %d", code)
+	}
+
+	code, out, errOut := runCLI(t, "", "token", "create", "-db="+dbPath, "ci")
+	if code != 0 {
+		t.Fatalf("token create exited %d: %s", code, errOut)
+	}
+	token := strings.TrimSpace(out)
+
+	db, err := database.New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	a := auth.New(db, "", "")
+	if !a.CheckPassword("192.0.2.1", "alice", "second-password") {
+		t.Error("Expected changed password to be accepted")
+	}
+	if !a.CheckToken(token) {
+		t.Error("Expected created token to be accepted")
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+
+	if code, _, _ := runCLI(t, "", "token", "revoke", "-db="+dbPath, "ci"); code != 0 {
+		t.Errorf("token revoke exited %d", code)
+	}
+	if code, _, _ := runCLI(t, "", "token", "revoke", "-db="+dbPath, "ci"); code != 1 {
+		t.Errorf("Expected second revoke to fail, got exit %d", code)
+	}
+
+	if code, out, errOut := runCLI(t, "", "user", "delete", "-db="+dbPath, "alice"); code != 0 || !strings.Contains(out, "deleted") {
+		t.Errorf("user delete exited %d: %s", code, errOut)
+	}
+	if code, _, _ := runCLI(t, "", "user", "delete", "-db="+dbPath, "alice"); code != 1 {
+		t.Errorf("Expected second delete to fail, got exit %d", code)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "cli.db")
+
+	code, out, _ := runCLI(t, "", "migrate", "-db="+dbPath, "-status")
+	if code != 0 || !strings.HasPrefix(out, "schema version 0") {
+		t.Errorf("Unexpected migrate -status output (exit %d): %s", code, out)
+	}
+	code, out, _ = runCLI(t, "", "migrate", "-db="+dbPath)
+	if code != 0 || !strings.Contains(out, "applied 1:") {
+		t.Errorf("Unexpected migrate output (exit %d): %s", code, out)
+	}
+	code, out, _ = runCLI(t, "", "migrate", "-db="+dbPath)
+	if code != 0 || !strings.Contains(out, "up to date") {
+		t.Errorf("Expected schema to be up to date (exit %d): %s", code, out)
+	}
+}
+
+func TestRun_Usage(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	env := Env{Stdin: strings.NewReader(""), Stdout: &stdout, Stderr: &stderr}
+
+	if code := Run(env, []string{"bogus"}); code != 2 {
+		t.Errorf("Expected exit 2 for unknown command, got %d", code)
+	}
+	if code := Run(env, []string{"help"}); code != 0 || !strings.Contains(stdout.String(), "gather-requests query") {
+		t.Errorf("Expected help to list commands (exit %d): %s", code, stdout.String())
+	}
+	if code := Run(env, []string{"user", "add", "alice", "-db=x.db"}); code != 2 {
+		t.Errorf("Expected exit 2 for a flag after arguments, got %d", code)
+	}
+	if !IsCommand("query") || IsCommand("serve") {
+		t.Error("Unexpected IsCommand result")
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2025, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"30d", now.AddDate(0, 0, -30)},
+		{"36h", now.Add(-36 * time.Hour)},
+		{"2025-01-02T03:04:05Z", time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{"2025-01-02", time.Date(2025, 1, 2, 0, 0, 0, 0, time.Local)},
+		{"2025-01-02 03:04", time.Date(2025, 1, 2, 3, 4, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		got, err := parseTime(tt.value, now)
+		if err != nil {
+			t.Errorf("parseTime(%q) failed: %v", tt.value, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseTime(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"", "yesterday", "-5d", "2025-13-01"} {
+		if _, err := parseTime(bad, now); err == nil {
+			t.Errorf("Expected parseTime(%q) to fail", bad)
+		}
+	}
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "cli.db")
+	backupDir := "-backup-dir=" + filepath.Join(dir, "backups")
+	if code, _, errOut := runCLI(t, `[{"IPAddress":"192.0.2.1","URL":"/kept"}]`, "import", "-db="+dbPath); code != 0 {
+		t.Fatalf("import exited %d: %s", code, errOut)
+	}
+
+	code, out, errOut := runCLI(t, "", "backup", "-db="+dbPath, backupDir)
+	if code != 0 {
+		t.Fatalf("backup exited %d: %s", code, errOut)
+	}
+	snapshot := strings.Fields(out)[1]
+
+	// Change the database, then restore the snapshot by name
+	if code, _, _ := runCLI(t, "", "purge", "-db="+dbPath, "-before=0s"); code != 0 {
+		t.Fatalf("purge exited %d", code)
+	}
+	code, out, errOut = runCLI(t, "", "restore", "-db="+dbPath, backupDir, filepath.Base(snapshot))
+	if code != 0 {
+		t.Fatalf("restore exited %d: %s", code, errOut)
+	}
+	if !strings.Contains(out, "pre-restore") {
+		t.Errorf("Expected restore to report the kept database: %s", out)
+	}
+
+	code, out, _ = runCLI(t, "", "query", "-db="+dbPath)
+	if code != 0 || !strings.Contains(out, "/kept") {
+		t.Errorf("Expected restored log (exit %d): %s", code, out)
+	}
+
+	if code, _, _ := runCLI(t, "", "restore", "-db="+dbPath, filepath.Join(dir, "missing.db.gz")); code != 1 {
+		t.Errorf("Expected restore of a missing file to fail, got exit %d", code)
+	}
+}
+
+func TestBan(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "cli.db")
+
+	if code, out, errOut := runCLI(t, "", "ban", "add", "-db="+dbPath, "-reason=scanner", "-duration=24h", "198.51.100.7/24"); code != 0 || !strings.Contains(out, "198.51.100.0/24") {
+		t.Fatalf("ban add exited %d: %s%s", code, out, errOut)
+	}
+	if code, _, _ := runCLI(t, "", "ban", "add", "-db="+dbPath, "not-an-address"); code != 1 {
+		t.Errorf("Expected an invalid address to fail, got exit %d", code)
+	}
+	code, out, errOut := runCLI(t, "", "ban", "list", "-db="+dbPath)
+	if code != 0 || !strings.Contains(out, "198.51.100.0/24") || !strings.Contains(out, "scanner") {
+		t.Errorf("ban list exited %d: %s%s", code, out, errOut)
+	}
+	if code, _, errOut := runCLI(t, "", "ban", "remove", "-db="+dbPath, "198.51.100.0/24"); code != 0 {
+		t.Errorf("ban remove exited %d: %s", code, errOut)
+	}
+	if code, _, _ := runCLI(t, "", "ban", "remove", "-db="+dbPath, "198.51.100.0/24"); code != 1 {
+		t.Errorf("Expected removing a missing ban to fail, got exit %d", code)
+	}
+	if code, _, _ := runCLI(t, "", "ban", "lift"); code != 2 {
+		t.Errorf("Expected an unknown subcommand to be a usage error, got exit %d", code)
+	}
+}
@@ -0,0 +1,364 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/anonymize"
+	"github.com/dangogh/silver-eureka/internal/config"
+	"github.com/dangogh/silver-eureka/internal/database"
+	"github.com/dangogh/silver-eureka/internal/importer"
+)
+
+// csvHeader is the column layout of CSV exports
+var csvHeader = []string{"id", "ip_address", "url", "timestamp"}
+
+// logWriter writes a stream of request logs in one output format
+type logWriter interface {
+	write(database.RequestLog) error
+	close() error
+}
+
+// newLogWriter returns a writer for format: table, json, ndjson or csv.
+// The json format matches the /stats/download endpoint.
+func newLogWriter(w io.Writer, format string) (logWriter, error) {
+	switch format {
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tTIMESTAMP\tIP ADDRESS\tURL")
+		return &tableLogWriter{tw: tw}, nil
+	case "json":
+		return &jsonLogWriter{w: w}, nil
+	case "ndjson":
+		return &ndjsonLogWriter{enc: json.NewEncoder(w)}, nil
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return nil, err
+		}
+		return &csvLogWriter{cw: cw}, nil
+	default:
+		return nil, usageError("unknown format %q", format)
+	}
+}
+
+type tableLogWriter struct {
+	tw *tabwriter.Writer
+}
+
+func (t *tableLogWriter) write(log database.RequestLog) error {
+	_, err := fmt.Fprintf(t.tw, "%d\t%s\t%s\t%s\n", log.ID, log.Timestamp.Format(time.RFC3339), log.IPAddress, log.URL)
+	return err
+}
+
+func (t *tableLogWriter) close() error {
+	return t.tw.Flush()
+}
+
+// jsonLogWriter writes a single JSON array without buffering the logs
+type jsonLogWriter struct {
+	w     io.Writer
+	count int
+}
+
+func (j *jsonLogWriter) write(log database.RequestLog) error {
+	b, err := json.Marshal(log)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if j.count == 0 {
+		sep = "[\n"
+	}
+	j.count++
+	_, err = fmt.Fprintf(j.w, "%s%s", sep, b)
+	return err
+}
+
+func (j *jsonLogWriter) close() error {
+	if j.count == 0 {
+		_, err := fmt.Fprintln(j.w, "[]")
+		return err
+	}
+	_, err := fmt.Fprintln(j.w, "\n]")
+	return err
+}
+
+type ndjsonLogWriter struct {
+	enc *json.Encoder
+}
+
+func (n *ndjsonLogWriter) write(log database.RequestLog) error {
+	return n.enc.Encode(log)
+}
+
+func (n *ndjsonLogWriter) close() error {
+	return nil
+}
+
+type csvLogWriter struct {
+	cw *csv.Writer
+}
+
+func (c *csvLogWriter) write(log database.RequestLog) error {
+	return c.cw.Write([]string{
+		strconv.FormatInt(log.ID, 10),
+		log.IPAddress,
+		log.URL,
+		log.Timestamp.Format(time.RFC3339Nano),
+	})
+}
+
+func (c *csvLogWriter) close() error {
+	c.cw.Flush()
+	return c.cw.Error()
+}
+
+// writeLogs streams the logs matching f to w
+func writeLogs(db *database.DB, f database.LogFilter, w logWriter) error {
+	if err := db.EachLog(f, w.write); err != nil {
+		return err
+	}
+	return w.close()
+}
+
+// runQuery implements "query", a filtered listing of the newest logs
+func runQuery(env Env, args []string) error {
+	fs := newFlagSet(env, "query")
+	var f database.LogFilter
+	filterFlags(fs, &f)
+	fs.IntVar(&f.Limit, "limit", 100, "Maximum number of logs to list (0 = all)")
+	format := fs.String("format", "table", "Output format: table, json, ndjson or csv")
+	cfg, err := parseConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	// Addresses are looked up under the configured HMAC keys
+	if f.Anonymizer, err = cfg.Anonymize.Anonymizer(); err != nil {
+		return err
+	}
+
+	w, err := newLogWriter(env.Stdout, *format)
+	if err != nil {
+		return err
+	}
+	db, err := openDatabase(cfg.DBPath)
+	if err != nil {
+		return err
+	}
+	defer closeDatabase(env, db)
+
+	return writeLogs(db, f, w)
+}
+
+// runExport implements "export", which writes matching logs oldest first
+func runExport(env Env, args []string) error {
+	fs := newFlagSet(env, "export")
+	f := database.LogFilter{Ascending: true}
+	filterFlags(fs, &f)
+	format := fs.String("format", "json", "Output format: json, ndjson or csv")
+	output := fs.String("o", "-", "Output file (- = stdout)")
+	cfg, err := parseConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	if *format == "table" {
+		return usageError("unknown format %q", *format)
+	}
+	// CSV has no columns for the details
+	f.Details = *format != "csv"
+	if f.Anonymizer, err = cfg.Anonymize.Anonymizer(); err != nil {
+		return err
+	}
+
+	db, err := openDatabase(cfg.DBPath)
+	if err != nil {
+		return err
+	}
+	defer closeDatabase(env, db)
+
+	out := env.Stdout
+	var file *os.File
+	if *output != "-" {
+		file, err = os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer func() {
+			if err := file.Close(); err != nil {
+				// Already closed on success
+			}
+		}()
+		out = file
+	}
+
+	buf := bufio.NewWriter(out)
+	w, err := newLogWriter(buf, *format)
+	if err != nil {
+		return err
+	}
+	if err := writeLogs(db, f, w); err != nil {
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+	if file != nil {
+		if err := file.Close(); err != nil {
+			return fmt.Errorf("failed to write export: %w", err)
+		}
+	}
+	return nil
+}
+
+// runImport implements "import", which reads access logs or logs written
+// by export, skipping those already stored. IDs in exports are ignored;
+// timestamps are kept.
+func runImport(env Env, args []string) error {
+	fs := newFlagSet(env, "import")
+	format := fs.String("format", importer.FormatAuto, "Input format: "+strings.Join(importer.Formats, ", "))
+	sensor := fs.String("sensor", "", "Sensor ID to tag access log records with")
+	dryRun := fs.Bool("dry-run", false, "Report what would be imported without storing it")
+	cfg, err := parseConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return usageError("at most one input file")
+	}
+	if !slices.Contains(importer.Formats, *format) {
+		return usageError("unknown format %q", *format)
+	}
+	if *sensor != "" && !config.ValidSensorID(*sensor) {
+		return usageError("invalid sensor ID %q", *sensor)
+	}
+
+	in := env.Stdin
+	if name := fs.Arg(0); name != "" && name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			return fmt.Errorf("failed to open input file: %w", err)
+		}
+		defer func() {
+			if err := file.Close(); err != nil {
+				// Read-only, nothing to flush
+			}
+		}()
+		in = file
+	}
+
+	db, err := openDatabase(cfg.DBPath)
+	if err != nil {
+		return err
+	}
+	defer closeDatabase(env, db)
+
+	// Addresses are stored as the server would store them
+	anon, err := cfg.Anonymize.Anonymizer()
+	if err != nil {
+		return err
+	}
+	if anon.Mode() != anonymize.Raw {
+		if err := db.SetAnonymizer(anon); err != nil {
+			return err
+		}
+	}
+
+	last := time.Now()
+	report, err := importer.Run(context.Background(), db, in, importer.Options{
+		Format: *format,
+		Sensor: *sensor,
+		DryRun: *dryRun,
+		Progress: func(r importer.Report) {
+			if time.Since(last) >= importProgressInterval {
+				last = time.Now()
+				fmt.Fprintf(env.Stderr, "read %d records: %d imported, %d duplicates, %d invalid\n",
+					r.Records, r.Imported, r.Duplicates, r.Invalid)
+			}
+		},
+	})
+	for _, problem := range report.Errors {
+		fmt.Fprintf(env.Stderr, "skipped %s\n", problem)
+	}
+	if err != nil {
+		if report.Imported > 0 && !report.DryRun {
+			fmt.Fprintf(env.Stderr, "imported %d logs before the error\n", report.Imported)
+		}
+		return err
+	}
+	verb := "imported"
+	if report.DryRun {
+		verb = "would import"
+	}
+	fmt.Fprintf(env.Stdout, "%s %d logs", verb, report.Imported)
+	if report.Duplicates > 0 || report.Invalid > 0 {
+		fmt.Fprintf(env.Stdout, ", skipped %d duplicates and %d invalid records", report.Duplicates, report.Invalid)
+	}
+	fmt.Fprintln(env.Stdout)
+	return nil
+}
+
+// importProgressInterval is the least time between progress reports
+const importProgressInterval = 5 * time.Second
+
+// runPurge implements "purge", which deletes logs older than a cutoff
+func runPurge(env Env, args []string) error {
+	fs := newFlagSet(env, "purge")
+	var before time.Time
+	fs.Var(timeFlag{&before, time.Now()}, "before", "Delete logs older than this time")
+	vacuum := fs.Bool("vacuum", false, "Reclaim disk space after deleting")
+	cfg, err := parseConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	if before.IsZero() {
+		return usageError("-before is required")
+	}
+
+	db, err := openDatabase(cfg.DBPath)
+	if err != nil {
+		return err
+	}
+	defer closeDatabase(env, db)
+
+	deleted, err := db.PurgeBefore(before)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(env.Stdout, "deleted %d logs older than %s\n", deleted, before.Format(time.RFC3339))
+	if *vacuum {
+		return db.Vacuum()
+	}
+	return nil
+}
+
+// runVacuum implements "vacuum"
+func runVacuum(env Env, args []string) error {
+	fs := newFlagSet(env, "vacuum")
+	cfg, err := parseConfig(fs, args)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDatabase(cfg.DBPath)
+	if err != nil {
+		return err
+	}
+	defer closeDatabase(env, db)
+
+	if err := db.Vacuum(); err != nil {
+		return err
+	}
+	fmt.Fprintln(env.Stdout, "vacuum complete")
+	return nil
+}
@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/dangogh/silver-eureka/internal/database"
+)
+
+// runMigrate implements "migrate", which brings the schema up to date and
+// lists the migrations applied; with -status it only reports the version
+func runMigrate(env Env, args []string) error {
+	fs := newFlagSet(env, "migrate")
+	status := fs.Bool("status", false, "Report the schema version without migrating")
+	cfg, err := parseConfig(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(cfg.DBPath); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create database directory: %w", err)
+		}
+	}
+	db, err := database.Open(cfg.DBPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer closeDatabase(env, db)
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	latest := database.LatestSchemaVersion()
+	fmt.Fprintf(env.Stdout, "schema version %d (latest %d)\n", version, latest)
+	if *status {
+		return nil
+	}
+
+	applied, err := db.Migrate()
+	for _, m := range applied {
+		fmt.Fprintf(env.Stdout, "applied %d: %s\n", m.Version, m.Description)
+	}
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		fmt.Fprintln(env.Stdout, "schema is up to date")
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/database"
+)
+
+// runStats implements "stats summary|endpoints|sources", the same reports
+// as the /stats/* endpoints, printed as a table or JSON
+func runStats(env Env, args []string) error {
+	if len(args) == 0 {
+		return usageError("missing report name")
+	}
+	report := args[0]
+	switch report {
+	case "summary", "endpoints", "sources":
+	default:
+		return usageError("unknown report %q", report)
+	}
+
+	fs := newFlagSet(env, "stats "+report)
+	limit := fs.Int("limit", 0, "Maximum number of rows (0 = all)")
+	format := fs.String("format", "table", "Output format: table or json")
+	var filter database.StatsFilter
+	now := time.Now()
+	fs.Var(timeFlag{&filter.Since, now}, "since", "Only requests at or after this time")
+	fs.Var(timeFlag{&filter.Until, now}, "until", "Only requests before this time")
+	fs.Var(prefixFlag{&filter.IP}, "ip", "Only requests from this address or CIDR prefix")
+	groupBy := fs.String("group-by", database.GroupByURL, "Group endpoints by url, path, query or normalized path")
+	v4Bits := fs.Int("prefix-v4", 0, "Group sources by IPv4 prefixes of this length (0 = by address)")
+	v6Bits := fs.Int("prefix-v6", 0, "Group sources by IPv6 prefixes of this length (0 = by address)")
+	cfg, err := parseConfig(fs, args[1:])
+	if err != nil {
+		return err
+	}
+	if *format != "table" && *format != "json" {
+		return usageError("unknown format %q", *format)
+	}
+	if !database.ValidGrouping(*groupBy) {
+		return usageError("unknown grouping %q", *groupBy)
+	}
+
+	db, err := openDatabase(cfg.DBPath)
+	if err != nil {
+		return err
+	}
+	defer closeDatabase(env, db)
+
+	var data any
+	switch report {
+	case "summary":
+		data, err = db.QuerySummary(filter)
+	case "endpoints":
+		var rows []database.EndpointStats
+		rows, err = db.QueryEndpointStatsBy(filter, *groupBy)
+		data = truncate(rows, *limit)
+	case "sources":
+		var rows []database.SourceStats
+		if *v4Bits > 0 || *v6Bits > 0 {
+			rows, err = db.QueryPrefixStats(filter, *v4Bits, *v6Bits)
+		} else {
+			rows, err = db.QuerySourceStats(filter)
+		}
+		data = truncate(rows, *limit)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(env.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(data)
+	}
+	return writeStatsTable(env.Stdout, data)
+}
+
+// truncate returns at most limit rows (0 = all)
+func truncate[T any](rows []T, limit int) []T {
+	if limit > 0 && len(rows) > limit {
+		return rows[:limit]
+	}
+	return rows
+}
+
+// writeStatsTable prints a stats report as aligned columns
+func writeStatsTable(w io.Writer, data any) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	switch v := data.(type) {
+	case *database.Summary:
+		fmt.Fprintf(tw, "Total requests\t%d\n", v.TotalRequests)
+		fmt.Fprintf(tw, "Unique IPs\t%d\n", v.UniqueIPs)
+		fmt.Fprintf(tw, "Unique URLs\t%d\n", v.UniqueURLs)
+		fmt.Fprintf(tw, "First request\t%s\n", formatTime(v.FirstRequest))
+		fmt.Fprintf(tw, "Last request\t%s\n", formatTime(v.LastRequest))
+		fmt.Fprintf(tw, "IP addresses\t%s\n", strings.Join(v.IPModes, ", "))
+	case []database.EndpointStats:
+		fmt.Fprintln(tw, "COUNT\tUNIQUE IPS\tFIRST SEEN\tLAST SEEN\tURL")
+		for _, s := range v {
+			fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\n", s.Count, s.UniqueIPs, formatTime(s.FirstSeen), formatTime(s.LastSeen), s.URL)
+		}
+	case []database.SourceStats:
+		fmt.Fprintln(tw, "COUNT\tUNIQUE URLS\tRATE LIMITED\tCONNECTIONS\tFIRST SEEN\tLAST SEEN\tIP ADDRESS")
+		for _, s := range v {
+			fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%s\t%s\t%s\n", s.Count, s.UniqueURLs, s.RateLimited, s.Connections,
+				formatTime(s.FirstSeen), formatTime(s.LastSeen), s.IPAddress)
+		}
+	}
+	return tw.Flush()
+}
+
+// formatTime prints a timestamp, or "-" when it is unset
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
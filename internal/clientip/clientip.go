@@ -0,0 +1,99 @@
+// Package clientip tells who sent a request: the connection's peer, or
+// the client a trusted proxy forwarded it for.
+package clientip
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+)
+
+// DefaultTrustedProxies are the peers whose forwarding headers are believed
+// unless SetTrustedProxies says otherwise: loopback and private networks
+var DefaultTrustedProxies = []netip.Prefix{
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("::1/128"),
+	netip.MustParsePrefix("fc00::/7"),
+}
+
+// trustedProxies holds the prefixes set by SetTrustedProxies
+var trustedProxies atomic.Pointer[[]netip.Prefix]
+
+func init() {
+	SetTrustedProxies(DefaultTrustedProxies)
+}
+
+// SetTrustedProxies replaces the peers whose X-Forwarded-For and X-Real-IP
+// headers FromRequest believes. It is safe to call while serving.
+func SetTrustedProxies(prefixes []netip.Prefix) {
+	trusted := append([]netip.Prefix(nil), prefixes...)
+	trustedProxies.Store(&trusted)
+}
+
+// FromRequest returns the address of the client that sent r. The connection's
+// peer is the client unless it is a trusted proxy, in which case the
+// nearest untrusted hop of X-Forwarded-For, or else X-Real-IP, is. Entries
+// a client put in X-Forwarded-For itself are never reached, since its own
+// address is appended after them.
+func FromRequest(r *http.Request) string {
+	peer := remoteIP(r.RemoteAddr)
+	if !isTrustedProxy(peer) {
+		return peer
+	}
+
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := normalizeIP(hops[i])
+			if hop == "" {
+				continue
+			}
+			if i == 0 || !isTrustedProxy(hop) {
+				return hop
+			}
+		}
+	}
+	if xri := normalizeIP(r.Header.Get("X-Real-IP")); xri != "" {
+		return xri
+	}
+	return peer
+}
+
+// remoteIP returns the address of a RemoteAddr such as "192.0.2.1:443",
+// "[2001:db8::1]:443" or one without a port
+func remoteIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	return normalizeIP(host)
+}
+
+// normalizeIP trims an address taken from a header and returns it in its
+// canonical form, or as given when it doesn't parse
+func normalizeIP(ip string) string {
+	ip = strings.TrimSpace(ip)
+	if addr, err := netip.ParseAddr(ip); err == nil {
+		return addr.Unmap().String()
+	}
+	return ip
+}
+
+// isTrustedProxy reports whether ip is within the trusted proxies
+func isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	for _, prefix := range *trustedProxies.Load() {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
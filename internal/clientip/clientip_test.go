@@ -0,0 +1,75 @@
+package clientip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestFromRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        string
+		xri        string
+		expectedIP string
+	}{
+		{"RemoteAddr only", "192.168.1.1:12345", "", "", "192.168.1.1"},
+		{"RemoteAddr without port", "192.168.1.1", "", "", "192.168.1.1"},
+		{"IPv6 RemoteAddr", "[2001:db8::1]:443", "", "", "2001:db8::1"},
+		{"IPv6 loopback RemoteAddr", "[::1]:5000", "", "", "::1"},
+		{"IPv6 RemoteAddr without port", "2001:db8::2", "", "", "2001:db8::2"},
+		{"IPv4-mapped RemoteAddr", "[::ffff:192.0.2.1]:443", "", "", "192.0.2.1"},
+		{"X-Forwarded-For from a proxy", "10.0.0.1:12345", "203.0.113.1", "", "203.0.113.1"},
+		{"X-Forwarded-For nearest untrusted hop", "10.0.0.1:12345", "203.0.113.1, 198.51.100.1, 192.0.2.1", "", "192.0.2.1"},
+		{"X-Forwarded-For through several proxies", "10.0.0.1:12345", "203.0.113.1, 10.0.0.7", "", "203.0.113.1"},
+		{"X-Forwarded-For with whitespace", "10.0.0.1:12345", " 203.0.113.1 ", "", "203.0.113.1"},
+		{"X-Forwarded-For only proxies", "10.0.0.1:12345", "10.0.0.8, 10.0.0.7", "", "10.0.0.8"},
+		{"X-Real-IP from a proxy", "10.0.0.1:12345", "", "203.0.113.1", "203.0.113.1"},
+		{"X-Real-IP with whitespace", "10.0.0.1:12345", "", " 203.0.113.2 ", "203.0.113.2"},
+		{"X-Forwarded-For over X-Real-IP", "10.0.0.1:12345", "203.0.113.1", "198.51.100.1", "203.0.113.1"},
+		{"X-Forwarded-For from a client ignored", "203.0.113.5:12345", "127.0.0.1", "", "203.0.113.5"},
+		{"X-Real-IP from a client ignored", "[2001:db8::5]:443", "", "::1", "2001:db8::5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if tt.xri != "" {
+				req.Header.Set("X-Real-IP", tt.xri)
+			}
+
+			if ip := FromRequest(req); ip != tt.expectedIP {
+				t.Errorf("Expected IP %s, got %s", tt.expectedIP, ip)
+			}
+		})
+	}
+}
+
+func TestSetTrustedProxies(t *testing.T) {
+	t.Cleanup(func() { SetTrustedProxies(DefaultTrustedProxies) })
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "198.51.100.10:443"
+	req.Header.Set("X-Forwarded-For", "203.0.113.1")
+	if ip := FromRequest(req); ip != "198.51.100.10" {
+		t.Errorf("Expected the peer by default, got %s", ip)
+	}
+
+	SetTrustedProxies([]netip.Prefix{netip.MustParsePrefix("198.51.100.0/24")})
+	if ip := FromRequest(req); ip != "203.0.113.1" {
+		t.Errorf("Expected the forwarded client of a trusted proxy, got %s", ip)
+	}
+
+	// Nothing trusted: headers are never believed
+	SetTrustedProxies(nil)
+	req.RemoteAddr = "127.0.0.1:443"
+	if ip := FromRequest(req); ip != "127.0.0.1" {
+		t.Errorf("Expected the peer with no trusted proxies, got %s", ip)
+	}
+}
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Alert rule types
+const (
+	AlertNewIPURLs   = "new_ip_urls"  // an IP not seen before requests more than Threshold URLs within Window
+	AlertURLSpike    = "url_spike"    // a URL is requested Factor times more often than over Baseline
+	AlertSignature   = "signature"    // a request's URL or user agent contains one of Patterns
+	AlertRequestRate = "request_rate" // more than Threshold requests within Window
+)
+
+// Webhook payload formats
+const (
+	WebhookJSON  = "json"  // the alert as a JSON object
+	WebhookSlack = "slack" // a Slack-compatible incoming webhook message
+	WebhookTeams = "teams" // a Microsoft Teams message card
+)
+
+// AlertRule is a condition that raises an alert
+type AlertRule struct {
+	Name      string        `yaml:"name"`      // unique, names the rule in notifications
+	Type      string        `yaml:"type"`      // one of the alert rule types
+	Threshold int           `yaml:"threshold"` // URLs or requests; for url_spike, the fewest requests that count as a spike
+	Window    time.Duration `yaml:"window"`    // period the rule counts requests over
+	Baseline  time.Duration `yaml:"baseline"`  // url_spike: whole hours before the current one giving the usual rate
+	Factor    float64       `yaml:"factor"`    // url_spike: how many times the usual rate is a spike
+	Patterns  []string      `yaml:"patterns"`  // signature: case-insensitive substrings
+	Cooldown  time.Duration `yaml:"cooldown"`  // overrides the alerts cooldown when set
+}
+
+// Webhook is a notification target
+type Webhook struct {
+	Name   string `yaml:"name"`   // names the webhook in logs instead of its URL
+	URL    string `yaml:"url"`    // http or https endpoint
+	Format string `yaml:"format"` // one of the webhook formats (default json)
+}
+
+// AlertConfig holds the alerting rules and where their alerts are sent.
+// Alerting is off until both rules and webhooks are configured.
+type AlertConfig struct {
+	Interval     time.Duration `yaml:"interval"`      // how often the aggregate rules are evaluated
+	Cooldown     time.Duration `yaml:"cooldown"`      // minimum time between notifications of the same alert
+	Retries      int           `yaml:"retries"`       // further delivery attempts after a failure
+	RetryBackoff time.Duration `yaml:"retry_backoff"` // wait before the first retry, doubled for each one after
+	Timeout      time.Duration `yaml:"timeout"`       // limit for each delivery attempt
+	Webhooks     []Webhook     `yaml:"webhooks"`
+	Rules        []AlertRule   `yaml:"rules"`
+}
+
+// DefaultAlertConfig returns the built-in alert settings, with no rules
+func DefaultAlertConfig() AlertConfig {
+	return AlertConfig{
+		Interval:     time.Minute,
+		Cooldown:     30 * time.Minute,
+		Retries:      3,
+		RetryBackoff: 2 * time.Second,
+		Timeout:      10 * time.Second,
+	}
+}
+
+// Enabled reports whether there are rules and somewhere to send their alerts
+func (a AlertConfig) Enabled() bool {
+	return len(a.Rules) > 0 && len(a.Webhooks) > 0
+}
+
+// Validate checks that the alert settings are usable
+func (a AlertConfig) Validate() error {
+	if a.Interval < time.Second {
+		return fmt.Errorf("alert interval must be at least 1s, got %s", a.Interval)
+	}
+	if a.Cooldown < 0 {
+		return fmt.Errorf("alert cooldown must not be negative, got %s", a.Cooldown)
+	}
+	if a.Retries < 0 {
+		return fmt.Errorf("alert retries must not be negative, got %d", a.Retries)
+	}
+	if a.RetryBackoff <= 0 {
+		return fmt.Errorf("alert retry_backoff must be positive, got %s", a.RetryBackoff)
+	}
+	if a.Timeout <= 0 {
+		return fmt.Errorf("alert timeout must be positive, got %s", a.Timeout)
+	}
+	for i, w := range a.Webhooks {
+		u, err := url.Parse(w.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhook %d: url must be an http or https URL", i+1)
+		}
+		switch w.Format {
+		case "", WebhookJSON, WebhookSlack, WebhookTeams:
+		default:
+			return fmt.Errorf("webhook %d: format must be %q, %q or %q, got %q", i+1, WebhookJSON, WebhookSlack, WebhookTeams, w.Format)
+		}
+	}
+	if len(a.Rules) > 0 && len(a.Webhooks) == 0 {
+		return fmt.Errorf("alert rules need at least one webhook")
+	}
+	names := make(map[string]bool, len(a.Rules))
+	for _, r := range a.Rules {
+		if r.Name == "" {
+			return fmt.Errorf("alert rule name must not be empty")
+		}
+		if names[r.Name] {
+			return fmt.Errorf("duplicate alert rule %q", r.Name)
+		}
+		names[r.Name] = true
+		if err := r.Validate(); err != nil {
+			return fmt.Errorf("alert rule %q: %w", r.Name, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks that the rule has the settings its type needs
+func (r AlertRule) Validate() error {
+	if r.Cooldown < 0 {
+		return fmt.Errorf("cooldown must not be negative, got %s", r.Cooldown)
+	}
+	switch r.Type {
+	case AlertSignature:
+		if len(r.Patterns) == 0 {
+			return fmt.Errorf("patterns must not be empty")
+		}
+		for _, p := range r.Patterns {
+			if p == "" {
+				return fmt.Errorf("patterns must not contain an empty pattern")
+			}
+		}
+		return nil
+	case AlertNewIPURLs, AlertRequestRate, AlertURLSpike:
+	default:
+		return fmt.Errorf("type must be %s, %s, %s or %s, got %q",
+			AlertNewIPURLs, AlertURLSpike, AlertSignature, AlertRequestRate, r.Type)
+	}
+	if r.Threshold < 1 {
+		return fmt.Errorf("threshold must be at least 1, got %d", r.Threshold)
+	}
+	if r.Window < time.Second {
+		return fmt.Errorf("window must be at least 1s, got %s", r.Window)
+	}
+	if r.Type == AlertURLSpike {
+		if r.Baseline < time.Hour || r.Baseline%time.Hour != 0 {
+			return fmt.Errorf("baseline must be a whole number of hours, got %s", r.Baseline)
+		}
+		if r.Factor <= 1 {
+			return fmt.Errorf("factor must be greater than 1, got %g", r.Factor)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/dangogh/silver-eureka/internal/anonymize"
+)
+
+// minHMACKeyLength is the shortest HMAC key accepted, in bytes
+const minHMACKeyLength = 16
+
+// AnonymizeConfig selects how client IP addresses are stored
+type AnonymizeConfig struct {
+	Mode     string   `yaml:"mode"`      // raw, truncate or hmac
+	HMACKeys []string `yaml:"hmac_keys"` // newest first; the first makes pseudonyms, all are matched
+}
+
+// DefaultAnonymizeConfig returns the built-in setting: raw addresses
+func DefaultAnonymizeConfig() AnonymizeConfig {
+	return AnonymizeConfig{Mode: anonymize.Raw}
+}
+
+// Validate checks the mode and, for hmac, the keys
+func (a AnonymizeConfig) Validate() error {
+	if !anonymize.ValidMode(a.Mode) {
+		return fmt.Errorf("unknown mode %q (want raw, truncate or hmac)", a.Mode)
+	}
+	if a.Mode == anonymize.HMAC && len(a.HMACKeys) == 0 {
+		return fmt.Errorf("hmac mode needs at least one key in hmac_keys")
+	}
+	for i, key := range a.HMACKeys {
+		if len(key) < minHMACKeyLength {
+			return fmt.Errorf("hmac_keys[%d] must be at least %d bytes", i, minHMACKeyLength)
+		}
+	}
+	return nil
+}
+
+// Anonymizer returns the anonymizer for these settings
+func (a AnonymizeConfig) Anonymizer() (*anonymize.Anonymizer, error) {
+	return anonymize.New(a.Mode, a.HMACKeys)
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// BackupConfig holds the database snapshot settings
+type BackupConfig struct {
+	Dir      string        `yaml:"dir"`      // directory for compressed snapshots (empty = beside the database)
+	Keep     int           `yaml:"keep"`     // snapshots kept after rotation (0 = keep all)
+	Interval time.Duration `yaml:"interval"` // time between scheduled snapshots (0 = no schedule)
+}
+
+// DefaultBackupConfig returns the built-in backup settings: seven
+// snapshots, taken only on demand. Parse puts them next to the database
+// unless a directory is set.
+func DefaultBackupConfig() BackupConfig {
+	return BackupConfig{
+		Keep: 7,
+	}
+}
+
+// DefaultBackupDir returns the snapshot directory used for the database at
+// dbPath when none is set: "backups" beside the database file
+func DefaultBackupDir(dbPath string) string {
+	return filepath.Join(filepath.Dir(dbPath), "backups")
+}
+
+// Validate checks that the backup settings are usable
+func (b BackupConfig) Validate() error {
+	if b.Keep < 0 {
+		return fmt.Errorf("backup keep must not be negative, got %d", b.Keep)
+	}
+	if b.Interval < 0 {
+		return fmt.Errorf("backup interval must not be negative, got %s", b.Interval)
+	}
+	if b.Interval > 0 && b.Interval < time.Minute {
+		return fmt.Errorf("backup interval must be at least 1m, got %s", b.Interval)
+	}
+	return nil
+}
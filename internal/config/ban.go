@@ -0,0 +1,61 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Ban actions
+const (
+	BanActionClose  = "close"  // close the connection without a response
+	BanActionStatic = "static" // reply with a static 403
+)
+
+// BanConfig holds the automatic IP ban settings
+type BanConfig struct {
+	AutoBan     bool          `yaml:"auto_ban"`     // ban IPs automatically after repeated offenses
+	Threshold   int           `yaml:"threshold"`    // strikes within Window that trigger a ban
+	Window      time.Duration `yaml:"window"`       // sliding window for counting strikes
+	Duration    time.Duration `yaml:"duration"`     // length of the first ban
+	MaxDuration time.Duration `yaml:"max_duration"` // cap for escalated bans (0 = no cap)
+	Multiplier  float64       `yaml:"multiplier"`   // ban length multiplier for each repeat offense
+	Action      string        `yaml:"action"`       // BanActionClose or BanActionStatic
+	Signatures  []string      `yaml:"signatures"`   // URL substrings that count as a strike
+}
+
+// DefaultBanConfig returns the built-in ban settings
+func DefaultBanConfig() BanConfig {
+	return BanConfig{
+		AutoBan:     false,
+		Threshold:   5,
+		Window:      10 * time.Minute,
+		Duration:    time.Hour,
+		MaxDuration: 7 * 24 * time.Hour,
+		Multiplier:  4,
+		Action:      BanActionClose,
+		Signatures:  []string{"${jndi:", "/etc/passwd", "/.env", "/.git/config", "cgi-bin/luci"},
+	}
+}
+
+// Validate checks that the ban settings are usable
+func (b BanConfig) Validate() error {
+	if b.Threshold < 1 {
+		return fmt.Errorf("ban threshold must be at least 1, got %d", b.Threshold)
+	}
+	if b.Window <= 0 {
+		return fmt.Errorf("ban window must be positive, got %s", b.Window)
+	}
+	if b.Duration <= 0 {
+		return fmt.Errorf("ban duration must be positive, got %s", b.Duration)
+	}
+	if b.MaxDuration < 0 {
+		return fmt.Errorf("ban max duration must not be negative, got %s", b.MaxDuration)
+	}
+	if b.Multiplier < 1 {
+		return fmt.Errorf("ban multiplier must be at least 1, got %g", b.Multiplier)
+	}
+	if b.Action != BanActionClose && b.Action != BanActionStatic {
+		return fmt.Errorf("ban action must be %q or %q, got %q", BanActionClose, BanActionStatic, b.Action)
+	}
+	return nil
+}
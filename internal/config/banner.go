@@ -0,0 +1,98 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Banner listener protocols
+const (
+	BannerSSH    = "ssh"
+	BannerTelnet = "telnet"
+	BannerRedis  = "redis"
+	BannerSMTP   = "smtp"
+	BannerRDP    = "rdp"
+	BannerRaw    = "raw" // sends the configured banner, if any, and only listens
+)
+
+// BannerProtocols lists the protocols a banner listener can imitate
+var BannerProtocols = []string{BannerSSH, BannerTelnet, BannerRedis, BannerSMTP, BannerRDP, BannerRaw}
+
+// MaxBannerCapture is the largest capture_bytes accepted
+const MaxBannerCapture = 64 << 10
+
+// BannerListenerConfig is a TCP port on which a protocol other than HTTP
+// is imitated
+type BannerListenerConfig struct {
+	Address  string `yaml:"address"`  // host:port, e.g. ":2222"
+	Protocol string `yaml:"protocol"` // one of BannerProtocols
+	Label    string `yaml:"label"`    // recorded with each connection; empty = none
+	// Banner replaces the protocol's greeting, sent as soon as a client
+	// connects; empty = the protocol's own, if it has one
+	Banner string `yaml:"banner"`
+	// ProxyProtocol expects a PROXY protocol header on every connection,
+	// as for an HTTP listener
+	ProxyProtocol bool `yaml:"proxy_protocol"`
+}
+
+// BannerConfig holds the low-interaction listeners for scanners of
+// protocols other than HTTP. Each connection is recorded with the first
+// bytes the client sent.
+type BannerConfig struct {
+	Listeners    []BannerListenerConfig `yaml:"listeners"`
+	CaptureBytes int                    `yaml:"capture_bytes"` // leading bytes of each connection recorded
+	Timeout      time.Duration          `yaml:"timeout"`       // longest a connection is held open
+	MaxConns     int                    `yaml:"max_conns"`     // open connections per listener; more are closed at once
+}
+
+// DefaultBannerConfig returns the built-in banner settings, with no
+// listeners
+func DefaultBannerConfig() BannerConfig {
+	return BannerConfig{
+		CaptureBytes: 1024,
+		Timeout:      10 * time.Second,
+		MaxConns:     100,
+	}
+}
+
+// Enabled reports whether any banner listener is configured
+func (b BannerConfig) Enabled() bool {
+	return len(b.Listeners) > 0
+}
+
+// Validate checks that the banner settings are usable
+func (b BannerConfig) Validate() error {
+	if b.CaptureBytes < 1 || b.CaptureBytes > MaxBannerCapture {
+		return fmt.Errorf("capture_bytes must be between 1 and %d, got %d", MaxBannerCapture, b.CaptureBytes)
+	}
+	if b.Timeout <= 0 {
+		return fmt.Errorf("timeout must be positive, got %s", b.Timeout)
+	}
+	if b.MaxConns < 1 {
+		return fmt.Errorf("max_conns must be at least 1, got %d", b.MaxConns)
+	}
+	seen := make(map[string]bool, len(b.Listeners))
+	for i, l := range b.Listeners {
+		_, port, err := net.SplitHostPort(l.Address)
+		if err != nil {
+			return fmt.Errorf("listener %d: address must be host:port, got %q", i+1, l.Address)
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("listener %d: port must be between 1 and 65535, got %q", i+1, port)
+		}
+		if seen[l.Address] {
+			return fmt.Errorf("listener %d: address %q is also another listener's", i+1, l.Address)
+		}
+		seen[l.Address] = true
+		if !slices.Contains(BannerProtocols, l.Protocol) {
+			return fmt.Errorf("listener %d: protocol must be one of %v, got %q", i+1, BannerProtocols, l.Protocol)
+		}
+		if l.Label != "" && !ValidSensorID(l.Label) {
+			return fmt.Errorf("listener %d: label must be 1 to 64 letters, digits, dots, dashes or underscores, got %q", i+1, l.Label)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxCaptureBodyBytes is the most of a request body that can be captured;
+// the catch-all handler reads no more than this
+const maxCaptureBodyBytes = 1 << 20
+
+// CaptureConfig selects what the catch-all handler stores of each request
+// beyond its address, URL and user agent, for full-text search
+type CaptureConfig struct {
+	Headers       bool     `yaml:"headers"`        // store request headers
+	BodyBytes     int      `yaml:"body_bytes"`     // leading body bytes stored as text (0 = none)
+	RedactHeaders []string `yaml:"redact_headers"` // headers stored with their values replaced
+}
+
+// DefaultCaptureConfig returns the built-in capture settings: headers with
+// credentials redacted, and the first 4 KiB of each body
+func DefaultCaptureConfig() CaptureConfig {
+	return CaptureConfig{
+		Headers:       true,
+		BodyBytes:     4096,
+		RedactHeaders: []string{"Authorization", "Cookie", "Proxy-Authorization"},
+	}
+}
+
+// Validate checks that the capture settings are usable
+func (c CaptureConfig) Validate() error {
+	if c.BodyBytes < 0 || c.BodyBytes > maxCaptureBodyBytes {
+		return fmt.Errorf("body_bytes must be between 0 and %d, got %d", maxCaptureBodyBytes, c.BodyBytes)
+	}
+	for _, name := range c.RedactHeaders {
+		if name == "" || strings.ContainsAny(name, ": \t") {
+			return fmt.Errorf("invalid header name %q in redact_headers", name)
+		}
+	}
+	return nil
+}
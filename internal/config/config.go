@@ -1,68 +1,549 @@
 package config
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"log/slog"
 	"os"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/anonymize"
 )
 
 // Config holds the application configuration
 type Config struct {
-	Port             int
-	DBPath           string
-	AuthUsername     string
-	AuthPassword     string
-	LogRetentionDays int
+	Port             int               `yaml:"port"`
+	Listeners        []ListenerConfig  `yaml:"listeners"`
+	TrustedProxies   []string          `yaml:"trusted_proxies"` // CIDRs whose X-Forwarded-For and X-Real-IP are believed
+	DBPath           string            `yaml:"db"`
+	AuthUsername     string            `yaml:"auth_username"`
+	AuthPassword     string            `yaml:"auth_password"`
+	LogLevel         string            `yaml:"log_level"`
+	LogRetentionDays int               `yaml:"log_retention_days"`
+	RateLimit        RateLimitConfig   `yaml:"rate_limits"`
+	Ban              BanConfig         `yaml:"bans"`
+	Metrics          MetricsConfig     `yaml:"metrics"`
+	Backup           BackupConfig      `yaml:"backup"`
+	Retention        RetentionConfig   `yaml:"retention"`
+	Maintenance      MaintenanceConfig `yaml:"maintenance"`
+	Anonymize        AnonymizeConfig   `yaml:"anonymize"`
+	Capture          CaptureConfig     `yaml:"capture"`
+	Alerts           AlertConfig       `yaml:"alerts"`
+	Digest           DigestConfig      `yaml:"digest"`
+	Syslog           SyslogConfig      `yaml:"syslog"`
+	Events           EventsConfig      `yaml:"events"`
+	OTel             OTelConfig        `yaml:"otel"`
+	Sensor           SensorConfig      `yaml:"sensor"`
+	Collector        CollectorConfig   `yaml:"collector"`
+	Banners          BannerConfig      `yaml:"banners"`
+}
+
+// Default returns the built-in configuration
+func Default() *Config {
+	return &Config{
+		Port:             8080, // default HTTP port
+		TrustedProxies:   DefaultTrustedProxies(),
+		DBPath:           "data/requests.db",
+		LogLevel:         "debug",
+		LogRetentionDays: 30,
+		RateLimit:        DefaultRateLimitConfig(),
+		Ban:              DefaultBanConfig(),
+		Metrics:          DefaultMetricsConfig(),
+		Backup:           DefaultBackupConfig(),
+		Retention:        DefaultRetentionConfig(),
+		Maintenance:      DefaultMaintenanceConfig(),
+		Anonymize:        DefaultAnonymizeConfig(),
+		Capture:          DefaultCaptureConfig(),
+		Alerts:           DefaultAlertConfig(),
+		Digest:           DefaultDigestConfig(),
+		Syslog:           DefaultSyslogConfig(),
+		Events:           DefaultEventsConfig(),
+		OTel:             DefaultOTelConfig(),
+		Sensor:           DefaultSensorConfig(),
+		Collector:        DefaultCollectorConfig(),
+		Banners:          DefaultBannerConfig(),
+	}
 }
 
 // Load loads configuration from flags
-// Priority: command-line flag > environment variable > default
+// Priority: command-line flag > environment variable > config file > default
 func Load() *Config {
 	return LoadWithFlagSet(flag.CommandLine, os.Args[1:])
 }
 
-// LoadWithFlagSet loads configuration with a custom flag set (for testing)
+// LoadWithFlagSet loads configuration with a custom flag set (for testing).
+// Invalid settings are logged and replaced by their defaults.
 func LoadWithFlagSet(fs *flag.FlagSet, args []string) *Config {
-	// Check environment variables
-	dbPath := os.Getenv("DB_PATH")
-	if dbPath == "" {
-		dbPath = "data/requests.db"
+	cfg, err := Parse(fs, args)
+	if err != nil {
+		slog.Warn("Ignoring invalid configuration", "error", err)
 	}
+	return cfg
+}
 
-	authUser := os.Getenv("AUTH_USERNAME")
-	authPass := os.Getenv("AUTH_PASSWORD")
+// Parse builds the configuration from defaults, the -config file (or
+// CONFIG_FILE), environment variables and flags, in increasing priority.
+// It reports every problem found; the returned Config is always usable,
+// with invalid settings reset to their defaults.
+func Parse(fs *flag.FlagSet, args []string) (*Config, error) {
+	cfg := Default()
+	flags := defineFlags(fs, cfg)
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
 
-	// Log retention (default 30 days)
-	logRetention := 30
-	if retentionEnv := os.Getenv("LOG_RETENTION_DAYS"); retentionEnv != "" {
-		if parsed, err := strconv.Atoi(retentionEnv); err == nil && parsed > 0 {
-			logRetention = parsed
+	var errs []error
+	path := os.Getenv("CONFIG_FILE")
+	if *flags.config != "" {
+		path = *flags.config
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			errs = append(errs, err)
 		}
 	}
+	errs = append(errs, cfg.applyEnv()...)
+	errs = append(errs, flags.apply(fs, cfg)...)
 
-	cfg := &Config{
-		Port:             8080, // default HTTP port
-		DBPath:           dbPath,
-		AuthUsername:     authUser,
-		AuthPassword:     authPass,
-		LogRetentionDays: logRetention,
+	for _, s := range cfg.sections() {
+		if err := s.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.name, err))
+			s.reset()
+		}
+	}
+	if cfg.Backup.Dir == "" {
+		cfg.Backup.Dir = DefaultBackupDir(cfg.DBPath)
+	}
+	return cfg, errors.Join(errs...)
+}
+
+// Validate checks every setting and reports all problems found
+func (c *Config) Validate() error {
+	var errs []error
+	for _, s := range c.sections() {
+		if err := s.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// section is an independently validated part of the configuration, named
+// by its config file key, with a function restoring its default
+type section struct {
+	name     string
+	validate func() error
+	reset    func()
+}
+
+// sections lists the validated parts of the configuration
+func (c *Config) sections() []section {
+	def := Default()
+	sections := []section{
+		{"port", func() error {
+			if c.Port < 1 || c.Port > 65535 {
+				return fmt.Errorf("must be between 1 and 65535, got %d", c.Port)
+			}
+			return nil
+		}, func() { c.Port = def.Port }},
+		{"listeners", func() error {
+			return validateListeners(c.Listeners)
+		}, func() { c.Listeners = nil }},
+		{"trusted_proxies", func() error {
+			_, err := c.TrustedProxyPrefixes()
+			return err
+		}, func() { c.TrustedProxies = def.TrustedProxies }},
+		{"db", func() error {
+			if strings.TrimSpace(c.DBPath) == "" {
+				return fmt.Errorf("must not be empty")
+			}
+			return nil
+		}, func() { c.DBPath = def.DBPath }},
+		{"auth_username", func() error {
+			if (c.AuthUsername == "") != (c.AuthPassword == "") {
+				return fmt.Errorf("auth_username and auth_password must be set together")
+			}
+			return nil
+		}, func() { c.AuthUsername, c.AuthPassword = "", "" }},
+		{"log_level", func() error {
+			_, err := ParseLogLevel(c.LogLevel)
+			return err
+		}, func() { c.LogLevel = def.LogLevel }},
+		{"log_retention_days", func() error {
+			if c.LogRetentionDays < 0 {
+				return fmt.Errorf("must not be negative, got %d", c.LogRetentionDays)
+			}
+			return nil
+		}, func() { c.LogRetentionDays = def.LogRetentionDays }},
+	}
+	defGroups := rateLimitGroups(&def.RateLimit)
+	for i, group := range rateLimitGroups(&c.RateLimit) {
+		sections = append(sections, section{"rate_limits." + group.name, group.policy.Validate,
+			func() { *group.policy = *defGroups[i].policy }})
 	}
+	return append(sections,
+		section{"bans", c.Ban.Validate, func() { c.Ban = def.Ban }},
+		section{"metrics.allow", c.Metrics.Validate, func() { c.Metrics.AllowList = def.Metrics.AllowList }},
+		section{"backup", c.Backup.Validate, func() { c.Backup = def.Backup }},
+		section{"retention", c.Retention.Validate, func() { c.Retention = def.Retention }},
+		section{"maintenance", c.Maintenance.Validate, func() { c.Maintenance = def.Maintenance }},
+		// An invalid anonymize setting must not fall back to storing raw addresses
+		section{"anonymize", c.Anonymize.Validate, func() { c.Anonymize = AnonymizeConfig{Mode: anonymize.Truncate} }},
+		section{"capture", c.Capture.Validate, func() { c.Capture = def.Capture }},
+		section{"alerts", c.Alerts.Validate, func() { c.Alerts = def.Alerts }},
+		section{"digest", c.Digest.Validate, func() { c.Digest = def.Digest }},
+		section{"syslog", c.Syslog.Validate, func() { c.Syslog = def.Syslog }},
+		section{"events", c.Events.Validate, func() { c.Events = def.Events }},
+		section{"otel", c.OTel.Validate, func() { c.OTel = def.OTel }},
+		section{"sensor", c.Sensor.Validate, func() { c.Sensor = def.Sensor }},
+		section{"collector", c.Collector.Validate, func() { c.Collector = def.Collector }},
+		section{"banners", c.Banners.Validate, func() { c.Banners = def.Banners }},
+	)
+}
 
-	// Command-line flags
-	port := fs.Int("port", cfg.Port, "HTTP server port")
-	dbPathFlag := fs.String("db", cfg.DBPath, "Database file path")
-	authUserFlag := fs.String("auth-user", cfg.AuthUsername, "Username for HTTP Basic Auth (optional)")
-	authPassFlag := fs.String("auth-pass", cfg.AuthPassword, "Password for HTTP Basic Auth (optional)")
-	logRetentionFlag := fs.Int("log-retention-days", cfg.LogRetentionDays, "Number of days to retain logs (0 = keep forever)")
-	_ = fs.Parse(args)
+// ParseLogLevel parses a log level name: debug, info, warn or error
+func ParseLogLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q (want debug, info, warn or error)", name)
+	}
+	return level, nil
+}
 
-	cfg.Port = *port
-	cfg.DBPath = *dbPathFlag
-	cfg.AuthUsername = *authUserFlag
-	cfg.AuthPassword = *authPassFlag
-	if *logRetentionFlag >= 0 {
-		cfg.LogRetentionDays = *logRetentionFlag
+// applyEnv overrides settings from environment variables
+func (c *Config) applyEnv() []error {
+	var errs []error
+	envInt := func(name string, dst *int) {
+		if value := os.Getenv(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid integer %q", name, value))
+				return
+			}
+			*dst = n
+		}
+	}
+	envString := func(name string, dst *string) {
+		if value := os.Getenv(name); value != "" {
+			*dst = value
+		}
 	}
 
-	return cfg
+	envInt("PORT", &c.Port)
+	envString("DB_PATH", &c.DBPath)
+	envString("AUTH_USERNAME", &c.AuthUsername)
+	envString("AUTH_PASSWORD", &c.AuthPassword)
+	envString("LOG_LEVEL", &c.LogLevel)
+	envInt("LOG_RETENTION_DAYS", &c.LogRetentionDays)
+
+	for _, group := range rateLimitGroups(&c.RateLimit) {
+		spec := os.Getenv(group.env)
+		if spec == "" {
+			continue
+		}
+		policy, err := ParseRateLimitPolicy(spec, *group.policy)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", group.env, err))
+			continue
+		}
+		*group.policy = policy
+	}
+
+	if value := os.Getenv("AUTO_BAN"); value != "" {
+		autoBan, err := strconv.ParseBool(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("AUTO_BAN: invalid boolean %q", value))
+		} else {
+			c.Ban.AutoBan = autoBan
+		}
+	}
+
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		c.TrustedProxies = splitList(proxies)
+	}
+
+	envString("METRICS_TOKEN", &c.Metrics.Token)
+	if allow := os.Getenv("METRICS_ALLOW"); allow != "" {
+		c.Metrics.AllowList = splitList(allow)
+	}
+
+	envString("BACKUP_DIR", &c.Backup.Dir)
+	envInt("BACKUP_KEEP", &c.Backup.Keep)
+	if value := os.Getenv("BACKUP_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("BACKUP_INTERVAL: invalid duration %q", value))
+		} else {
+			c.Backup.Interval = interval
+		}
+	}
+
+	envInt("BODY_RETENTION_DAYS", &c.Retention.BodyDays)
+	envInt("HOURLY_ROLLUP_RETENTION_DAYS", &c.Retention.HourlyRollupDays)
+	envInt("DAILY_ROLLUP_RETENTION_DAYS", &c.Retention.DailyRollupDays)
+	envString("ARCHIVE_DIR", &c.Retention.ArchiveDir)
+	envInt("MAX_DB_SIZE_MB", &c.Retention.MaxDBSizeMB)
+
+	envString("MAINTENANCE_WINDOW", &c.Maintenance.Window)
+	envInt("MAINTENANCE_BATCH_SIZE", &c.Maintenance.BatchSize)
+	if value := os.Getenv("MAINTENANCE_BATCH_PAUSE"); value != "" {
+		pause, err := time.ParseDuration(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("MAINTENANCE_BATCH_PAUSE: invalid duration %q", value))
+		} else {
+			c.Maintenance.BatchPause = pause
+		}
+	}
+	envInt("MAINTENANCE_VACUUM_PAGES", &c.Maintenance.VacuumPages)
+
+	envString("ANONYMIZE_IPS", &c.Anonymize.Mode)
+	if keys := os.Getenv("ANONYMIZE_HMAC_KEYS"); keys != "" {
+		c.Anonymize.HMACKeys = splitList(keys)
+	}
+
+	if value := os.Getenv("CAPTURE_HEADERS"); value != "" {
+		headers, err := strconv.ParseBool(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("CAPTURE_HEADERS: invalid boolean %q", value))
+		} else {
+			c.Capture.Headers = headers
+		}
+	}
+	envInt("CAPTURE_BODY_BYTES", &c.Capture.BodyBytes)
+	if redact := os.Getenv("CAPTURE_REDACT_HEADERS"); redact != "" {
+		c.Capture.RedactHeaders = splitList(redact)
+	}
+
+	envString("SMTP_HOST", &c.Digest.SMTP.Host)
+	envInt("SMTP_PORT", &c.Digest.SMTP.Port)
+	envString("SMTP_USERNAME", &c.Digest.SMTP.Username)
+	envString("SMTP_PASSWORD", &c.Digest.SMTP.Password)
+	envString("DIGEST_FROM", &c.Digest.From)
+	envString("SYSLOG_ADDRESS", &c.Syslog.Address)
+	envString("SYSLOG_NETWORK", &c.Syslog.Network)
+	envString("SYSLOG_FORMAT", &c.Syslog.Format)
+
+	// The standard OpenTelemetry SDK variables
+	envString("OTEL_EXPORTER_OTLP_ENDPOINT", &c.OTel.Endpoint)
+	envString("OTEL_EXPORTER_OTLP_PROTOCOL", &c.OTel.Protocol)
+	envString("OTEL_SERVICE_NAME", &c.OTel.ServiceName)
+	if value := os.Getenv("OTEL_TRACES_SAMPLER_ARG"); value != "" {
+		ratio, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("OTEL_TRACES_SAMPLER_ARG: invalid number %q", value))
+		} else {
+			c.OTel.SampleRatio = ratio
+		}
+	}
+
+	envString("SENSOR_ID", &c.Sensor.ID)
+	envString("SENSOR_COLLECTOR_URL", &c.Sensor.CollectorURL)
+	envString("SENSOR_TOKEN", &c.Sensor.Token)
+	envString("COLLECTOR_LISTEN", &c.Collector.Listen)
+	return errs
+}
+
+// flagValues holds the parsed command-line flags
+type flagValues struct {
+	config         *string
+	port           *int
+	dbPath         *string
+	authUser       *string
+	authPass       *string
+	logLevel       *string
+	logRetention   *int
+	rateLimits     map[string]*string
+	autoBan        *bool
+	banThreshold   *int
+	banWindow      *time.Duration
+	banDuration    *time.Duration
+	banMaxDuration *time.Duration
+	banMultiplier  *float64
+	banAction      *string
+	banSignatures  *string
+	metrics        *bool
+	metricsToken   *string
+	metricsAllow   *string
+	trustedProxies *string
+	backupDir      *string
+	backupKeep     *int
+	backupInterval *time.Duration
+	bodyRetention  *int
+	hourlyRollup   *int
+	dailyRollup    *int
+	archiveDir     *string
+	maxDBSize      *int
+	maintWindow    *string
+	maintBatch     *int
+	maintPause     *time.Duration
+	maintVacuum    *int
+	anonymize      *string
+	captureHeaders *bool
+	captureBody    *int
+}
+
+// defineFlags registers the command-line flags, using cfg for the defaults shown in -help
+func defineFlags(fs *flag.FlagSet, cfg *Config) *flagValues {
+	f := &flagValues{
+		config:       fs.String("config", "", "Path to a YAML config file"),
+		port:         fs.Int("port", cfg.Port, "HTTP server port"),
+		dbPath:       fs.String("db", cfg.DBPath, "Database file path"),
+		authUser:     fs.String("auth-user", cfg.AuthUsername, "Username for HTTP Basic Auth (optional)"),
+		authPass:     fs.String("auth-pass", cfg.AuthPassword, "Password for HTTP Basic Auth (optional)"),
+		logLevel:     fs.String("log-level", cfg.LogLevel, "Log level: debug, info, warn or error"),
+		logRetention: fs.Int("log-retention-days", cfg.LogRetentionDays, "Number of days to retain logs (0 = keep forever)"),
+		rateLimits:   map[string]*string{},
+	}
+	for _, group := range rateLimitGroups(&cfg.RateLimit) {
+		f.rateLimits[group.name] = fs.String("rate-limit-"+group.name, "",
+			"Rate limit policy for "+group.name+" routes (e.g. per-ip=100,global=10000,exempt=10.0.0.0/8,ipv4-prefix=24)")
+	}
+	f.autoBan = fs.Bool("auto-ban", cfg.Ban.AutoBan, "Automatically ban IPs that repeatedly trip rate limits or signatures")
+	f.banThreshold = fs.Int("ban-threshold", cfg.Ban.Threshold, "Strikes within the ban window that trigger a ban")
+	f.banWindow = fs.Duration("ban-window", cfg.Ban.Window, "Window for counting ban strikes")
+	f.banDuration = fs.Duration("ban-duration", cfg.Ban.Duration, "Length of the first automatic ban")
+	f.banMaxDuration = fs.Duration("ban-max-duration", cfg.Ban.MaxDuration, "Maximum length of an escalated ban (0 = no cap)")
+	f.banMultiplier = fs.Float64("ban-multiplier", cfg.Ban.Multiplier, "Ban length multiplier for each repeat offense")
+	f.banAction = fs.String("ban-action", cfg.Ban.Action, "Response to banned IPs: close or static")
+	f.banSignatures = fs.String("ban-signatures", strings.Join(cfg.Ban.Signatures, ","), "Comma-separated URL substrings that count as a ban strike")
+	f.metrics = fs.Bool("metrics", cfg.Metrics.Enabled, "Serve Prometheus metrics at /metrics")
+	f.metricsToken = fs.String("metrics-token", cfg.Metrics.Token, "Bearer token that grants access to /metrics (optional)")
+	f.metricsAllow = fs.String("metrics-allow", strings.Join(cfg.Metrics.AllowList, ","), "Comma-separated CIDRs allowed to scrape /metrics without a token")
+	f.trustedProxies = fs.String("trusted-proxies", strings.Join(cfg.TrustedProxies, ","), "Comma-separated CIDRs of proxies whose X-Forwarded-For and X-Real-IP headers are believed (empty = none)")
+	f.backupDir = fs.String("backup-dir", cfg.Backup.Dir, "Directory for database snapshots (default: backups beside the database)")
+	f.backupKeep = fs.Int("backup-keep", cfg.Backup.Keep, "Number of snapshots to keep (0 = keep all)")
+	f.backupInterval = fs.Duration("backup-interval", cfg.Backup.Interval, "Time between scheduled snapshots (0 = on demand only)")
+	f.bodyRetention = fs.Int("body-retention-days", cfg.Retention.BodyDays, "Number of days to retain captured request headers and bodies (0 = as long as the log)")
+	f.hourlyRollup = fs.Int("hourly-rollup-retention-days", cfg.Retention.HourlyRollupDays, "Number of days to retain hourly statistics rollups (0 = keep forever)")
+	f.dailyRollup = fs.Int("daily-rollup-retention-days", cfg.Retention.DailyRollupDays, "Number of days to retain daily statistics rollups (0 = keep forever)")
+	f.archiveDir = fs.String("archive-dir", cfg.Retention.ArchiveDir, "Directory for compressed NDJSON archives of expired logs (optional)")
+	f.maxDBSize = fs.Int("max-db-size-mb", cfg.Retention.MaxDBSizeMB, "Expire the oldest data when the database grows beyond this size (0 = no cap)")
+	f.maintWindow = fs.String("maintenance-window", cfg.Maintenance.Window, "Daily local time window for retention cleanup, e.g. 02:00-05:00 (empty = any time)")
+	f.maintBatch = fs.Int("maintenance-batch-size", cfg.Maintenance.BatchSize, "Rows deleted per step during retention cleanup")
+	f.maintPause = fs.Duration("maintenance-batch-pause", cfg.Maintenance.BatchPause, "Pause between retention cleanup steps")
+	f.maintVacuum = fs.Int("maintenance-vacuum-pages", cfg.Maintenance.VacuumPages, "Pages returned to the file system per incremental vacuum step")
+	f.anonymize = fs.String("anonymize-ips", cfg.Anonymize.Mode, "How client IPs are stored: raw, truncate (IPv4 /24, IPv6 /48) or hmac (keys from the config file or ANONYMIZE_HMAC_KEYS)")
+	f.captureHeaders = fs.Bool("capture-headers", cfg.Capture.Headers, "Store request headers of logged requests for search")
+	f.captureBody = fs.Int("capture-body-bytes", cfg.Capture.BodyBytes, "Leading bytes of each logged request body stored for search (0 = none)")
+	return f
+}
+
+// apply copies the flags given on the command line into cfg
+func (f *flagValues) apply(fs *flag.FlagSet, cfg *Config) []error {
+	fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "port":
+			cfg.Port = *f.port
+		case "db":
+			cfg.DBPath = *f.dbPath
+		case "auth-user":
+			cfg.AuthUsername = *f.authUser
+		case "auth-pass":
+			cfg.AuthPassword = *f.authPass
+		case "log-level":
+			cfg.LogLevel = *f.logLevel
+		case "log-retention-days":
+			cfg.LogRetentionDays = *f.logRetention
+		case "auto-ban":
+			cfg.Ban.AutoBan = *f.autoBan
+		case "ban-threshold":
+			cfg.Ban.Threshold = *f.banThreshold
+		case "ban-window":
+			cfg.Ban.Window = *f.banWindow
+		case "ban-duration":
+			cfg.Ban.Duration = *f.banDuration
+		case "ban-max-duration":
+			cfg.Ban.MaxDuration = *f.banMaxDuration
+		case "ban-multiplier":
+			cfg.Ban.Multiplier = *f.banMultiplier
+		case "ban-action":
+			cfg.Ban.Action = *f.banAction
+		case "ban-signatures":
+			cfg.Ban.Signatures = splitList(*f.banSignatures)
+		case "metrics":
+			cfg.Metrics.Enabled = *f.metrics
+		case "metrics-token":
+			cfg.Metrics.Token = *f.metricsToken
+		case "metrics-allow":
+			cfg.Metrics.AllowList = splitList(*f.metricsAllow)
+		case "trusted-proxies":
+			cfg.TrustedProxies = splitList(*f.trustedProxies)
+		case "backup-dir":
+			cfg.Backup.Dir = *f.backupDir
+		case "backup-keep":
+			cfg.Backup.Keep = *f.backupKeep
+		case "backup-interval":
+			cfg.Backup.Interval = *f.backupInterval
+		case "body-retention-days":
+			cfg.Retention.BodyDays = *f.bodyRetention
+		case "hourly-rollup-retention-days":
+			cfg.Retention.HourlyRollupDays = *f.hourlyRollup
+		case "daily-rollup-retention-days":
+			cfg.Retention.DailyRollupDays = *f.dailyRollup
+		case "archive-dir":
+			cfg.Retention.ArchiveDir = *f.archiveDir
+		case "max-db-size-mb":
+			cfg.Retention.MaxDBSizeMB = *f.maxDBSize
+		case "maintenance-window":
+			cfg.Maintenance.Window = *f.maintWindow
+		case "maintenance-batch-size":
+			cfg.Maintenance.BatchSize = *f.maintBatch
+		case "maintenance-batch-pause":
+			cfg.Maintenance.BatchPause = *f.maintPause
+		case "maintenance-vacuum-pages":
+			cfg.Maintenance.VacuumPages = *f.maintVacuum
+		case "anonymize-ips":
+			cfg.Anonymize.Mode = *f.anonymize
+		case "capture-headers":
+			cfg.Capture.Headers = *f.captureHeaders
+		case "capture-body-bytes":
+			cfg.Capture.BodyBytes = *f.captureBody
+		}
+	})
+
+	var errs []error
+	for _, group := range rateLimitGroups(&cfg.RateLimit) {
+		spec := *f.rateLimits[group.name]
+		if spec == "" {
+			continue
+		}
+		policy, err := ParseRateLimitPolicy(spec, *group.policy)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("-rate-limit-%s: %w", group.name, err))
+			continue
+		}
+		*group.policy = policy
+	}
+	return errs
+}
+
+// rateLimitGroup binds a route group name to its policy and environment variable
+type rateLimitGroup struct {
+	name   string
+	env    string
+	policy *RateLimitPolicy
+}
+
+// rateLimitGroups lists the configurable route groups
+func rateLimitGroups(rl *RateLimitConfig) []rateLimitGroup {
+	return []rateLimitGroup{
+		{name: "catchall", env: "RATE_LIMIT_CATCHALL", policy: &rl.CatchAll},
+		{name: "stats", env: "RATE_LIMIT_STATS", policy: &rl.Stats},
+		{name: "web", env: "RATE_LIMIT_WEB", policy: &rl.Web},
+		{name: "health", env: "RATE_LIMIT_HEALTH", policy: &rl.Health},
+	}
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
@@ -2,7 +2,9 @@ package config
 
 import (
 	"flag"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestLoad_Defaults(t *testing.T) {
@@ -43,3 +45,350 @@ func TestLoad_LogRetentionZero(t *testing.T) {
 		t.Errorf("Expected log retention 0 (disabled), got %d", cfg.LogRetentionDays)
 	}
 }
+
+func TestLoad_RateLimitDefaults(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg := LoadWithFlagSet(fs, []string{})
+
+	if cfg.RateLimit.CatchAll.PerIPPerMin != 100 {
+		t.Errorf("Expected catch-all per-IP limit 100, got %d", cfg.RateLimit.CatchAll.PerIPPerMin)
+	}
+	if cfg.RateLimit.CatchAll.GlobalPerMin != 10000 {
+		t.Errorf("Expected catch-all global limit 10000, got %d", cfg.RateLimit.CatchAll.GlobalPerMin)
+	}
+	if len(cfg.RateLimit.Health.Exempt) == 0 {
+		t.Error("Expected health policy to exempt loopback addresses")
+	}
+}
+
+func TestLoad_RateLimitFlag(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg := LoadWithFlagSet(fs, []string{"-rate-limit-stats=per-ip=20,exempt=10.0.0.0/8|192.168.0.0/16,ipv4-prefix=24"})
+
+	if cfg.RateLimit.Stats.PerIPPerMin != 20 {
+		t.Errorf("Expected stats per-IP limit 20, got %d", cfg.RateLimit.Stats.PerIPPerMin)
+	}
+	if len(cfg.RateLimit.Stats.Exempt) != 2 {
+		t.Errorf("Expected 2 exempt CIDRs, got %v", cfg.RateLimit.Stats.Exempt)
+	}
+	if cfg.RateLimit.Stats.IPv4Prefix != 24 {
+		t.Errorf("Expected IPv4 prefix 24, got %d", cfg.RateLimit.Stats.IPv4Prefix)
+	}
+	// Other groups keep their defaults
+	if cfg.RateLimit.CatchAll.PerIPPerMin != 100 {
+		t.Errorf("Expected catch-all per-IP limit unchanged, got %d", cfg.RateLimit.CatchAll.PerIPPerMin)
+	}
+}
+
+func TestLoad_RateLimitEnv(t *testing.T) {
+	t.Setenv("RATE_LIMIT_CATCHALL", "per-ip=5,global=50")
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg := LoadWithFlagSet(fs, []string{})
+
+	if cfg.RateLimit.CatchAll.PerIPPerMin != 5 || cfg.RateLimit.CatchAll.GlobalPerMin != 50 {
+		t.Errorf("Expected catch-all limits 5/50 from env, got %d/%d",
+			cfg.RateLimit.CatchAll.PerIPPerMin, cfg.RateLimit.CatchAll.GlobalPerMin)
+	}
+}
+
+func TestLoad_RateLimitInvalidKeepsDefault(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg := LoadWithFlagSet(fs, []string{"-rate-limit-web=per-ip=abc"})
+
+	if cfg.RateLimit.Web.PerIPPerMin != DefaultRateLimitConfig().Web.PerIPPerMin {
+		t.Errorf("Expected invalid policy to be ignored, got per-IP limit %d", cfg.RateLimit.Web.PerIPPerMin)
+	}
+}
+
+func TestParseRateLimitPolicy(t *testing.T) {
+	base := DefaultRateLimitConfig().CatchAll
+
+	tests := []struct {
+		name    string
+		spec    string
+		wantErr bool
+	}{
+		{name: "empty spec", spec: ""},
+		{name: "all fields", spec: "per-ip=10,per-ip-burst=2,global=100,global-burst=20,ipv4-prefix=24,ipv6-prefix=64,max-entries=5000"},
+		{name: "exempt list", spec: "exempt=10.0.0.0/8|2001:db8::/32"},
+		{name: "missing value", spec: "per-ip", wantErr: true},
+		{name: "unknown key", spec: "bogus=1", wantErr: true},
+		{name: "non-numeric", spec: "global=lots", wantErr: true},
+		{name: "negative", spec: "per-ip=-1", wantErr: true},
+		{name: "ipv4 prefix out of range", spec: "ipv4-prefix=33", wantErr: true},
+		{name: "ipv6 prefix out of range", spec: "ipv6-prefix=129", wantErr: true},
+		{name: "invalid CIDR", spec: "exempt=not-a-cidr", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseRateLimitPolicy(tt.spec, base)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseRateLimitPolicy(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoad_BanFlags(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg := LoadWithFlagSet(fs, []string{
+		"-auto-ban",
+		"-ban-threshold=3",
+		"-ban-duration=30m",
+		"-ban-action=static",
+		"-ban-signatures=/admin, /phpmyadmin",
+	})
+
+	if !cfg.Ban.AutoBan {
+		t.Error("Expected auto-ban to be enabled")
+	}
+	if cfg.Ban.Threshold != 3 {
+		t.Errorf("Expected ban threshold 3, got %d", cfg.Ban.Threshold)
+	}
+	if cfg.Ban.Duration.Minutes() != 30 {
+		t.Errorf("Expected ban duration 30m, got %s", cfg.Ban.Duration)
+	}
+	if cfg.Ban.Action != BanActionStatic {
+		t.Errorf("Expected ban action static, got %s", cfg.Ban.Action)
+	}
+	if len(cfg.Ban.Signatures) != 2 || cfg.Ban.Signatures[1] != "/phpmyadmin" {
+		t.Errorf("Unexpected signatures: %v", cfg.Ban.Signatures)
+	}
+}
+
+func TestLoad_BanInvalidKeepsDefault(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg := LoadWithFlagSet(fs, []string{"-ban-action=explode", "-ban-threshold=3"})
+
+	if cfg.Ban.Action != BanActionClose || cfg.Ban.Threshold != DefaultBanConfig().Threshold {
+		t.Errorf("Expected invalid ban settings to be ignored, got %+v", cfg.Ban)
+	}
+}
+
+func TestBanConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*BanConfig)
+	}{
+		{"threshold", func(b *BanConfig) { b.Threshold = 0 }},
+		{"window", func(b *BanConfig) { b.Window = 0 }},
+		{"duration", func(b *BanConfig) { b.Duration = 0 }},
+		{"max duration", func(b *BanConfig) { b.MaxDuration = -1 }},
+		{"multiplier", func(b *BanConfig) { b.Multiplier = 0.5 }},
+		{"action", func(b *BanConfig) { b.Action = "drop" }},
+	}
+
+	if err := DefaultBanConfig().Validate(); err != nil {
+		t.Fatalf("Expected default ban config to be valid, got: %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := DefaultBanConfig()
+			tt.modify(&b)
+			if err := b.Validate(); err == nil {
+				t.Error("Expected validation error")
+			}
+		})
+	}
+}
+
+func TestLoad_MetricsDefaults(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg := LoadWithFlagSet(fs, []string{})
+
+	if !cfg.Metrics.Enabled {
+		t.Error("Expected metrics to be enabled by default")
+	}
+	if cfg.Metrics.Token != "" {
+		t.Errorf("Expected no default metrics token, got %q", cfg.Metrics.Token)
+	}
+	if len(cfg.Metrics.AllowList) != 2 {
+		t.Errorf("Expected loopback allow-list by default, got %v", cfg.Metrics.AllowList)
+	}
+}
+
+func TestLoad_MetricsFlagsAndEnv(t *testing.T) {
+	t.Setenv("METRICS_TOKEN", "from-env")
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg := LoadWithFlagSet(fs, []string{"-metrics-allow=10.0.0.0/8"})
+
+	if cfg.Metrics.Token != "from-env" {
+		t.Errorf("Expected metrics token from env, got %q", cfg.Metrics.Token)
+	}
+	if len(cfg.Metrics.AllowList) != 1 || cfg.Metrics.AllowList[0] != "10.0.0.0/8" {
+		t.Errorf("Expected allow-list [10.0.0.0/8], got %v", cfg.Metrics.AllowList)
+	}
+
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg = LoadWithFlagSet(fs, []string{"-metrics=false", "-metrics-allow=not-a-cidr"})
+	if cfg.Metrics.Enabled {
+		t.Error("Expected metrics to be disabled by flag")
+	}
+	if len(cfg.Metrics.AllowList) != 2 {
+		t.Errorf("Expected invalid allow-list to fall back to loopback, got %v", cfg.Metrics.AllowList)
+	}
+}
+
+func TestLoad_Backup(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg := LoadWithFlagSet(fs, []string{})
+	if cfg.Backup.Dir != filepath.Join("data", "backups") || cfg.Backup.Keep != 7 || cfg.Backup.Interval != 0 {
+		t.Errorf("Unexpected backup defaults: %+v", cfg.Backup)
+	}
+
+	// Snapshots go beside the database by default
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg = LoadWithFlagSet(fs, []string{"-db=/srv/honeypot/requests.db"})
+	if cfg.Backup.Dir != "/srv/honeypot/backups" {
+		t.Errorf("Expected backups beside the database, got %q", cfg.Backup.Dir)
+	}
+
+	t.Setenv("BACKUP_INTERVAL", "6h")
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg = LoadWithFlagSet(fs, []string{"-backup-dir=/var/backups", "-backup-keep=14"})
+	if cfg.Backup.Dir != "/var/backups" || cfg.Backup.Keep != 14 || cfg.Backup.Interval.Hours() != 6 {
+		t.Errorf("Unexpected backup settings: %+v", cfg.Backup)
+	}
+
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg = LoadWithFlagSet(fs, []string{"-backup-interval=5s"})
+	if want := (BackupConfig{Dir: filepath.Join("data", "backups"), Keep: 7}); cfg.Backup != want {
+		t.Errorf("Expected invalid backup settings to fall back to defaults, got %+v", cfg.Backup)
+	}
+}
+
+func TestLoad_Retention(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg := LoadWithFlagSet(fs, []string{})
+	if cfg.Retention != DefaultRetentionConfig() {
+		t.Errorf("Unexpected retention defaults: %+v", cfg.Retention)
+	}
+
+	t.Setenv("ARCHIVE_DIR", "/var/archive")
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg = LoadWithFlagSet(fs, []string{"-body-retention-days=0", "-hourly-rollup-retention-days=14", "-daily-rollup-retention-days=0", "-max-db-size-mb=512"})
+	want := RetentionConfig{BodyDays: 0, HourlyRollupDays: 14, DailyRollupDays: 0, ArchiveDir: "/var/archive", MaxDBSizeMB: 512}
+	if cfg.Retention != want {
+		t.Errorf("Expected %+v, got %+v", want, cfg.Retention)
+	}
+
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg = LoadWithFlagSet(fs, []string{"-max-db-size-mb=-1"})
+	if cfg.Retention != DefaultRetentionConfig() {
+		t.Errorf("Expected invalid retention settings to fall back to defaults, got %+v", cfg.Retention)
+	}
+
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg = LoadWithFlagSet(fs, []string{"-body-retention-days=-1"})
+	if cfg.Retention != DefaultRetentionConfig() {
+		t.Errorf("Expected invalid retention settings to fall back to defaults, got %+v", cfg.Retention)
+	}
+}
+
+func TestRollupRetention(t *testing.T) {
+	tests := []struct {
+		logDays, hourlyDays, dailyDays int
+		wantHourly, wantDaily          int
+	}{
+		{30, 90, 730, 90, 730},
+		{120, 90, 730, 120, 730}, // never shorter than the raw logs
+		{30, 90, 60, 90, 90},     // daily never shorter than hourly
+		{0, 90, 730, 0, 0},       // raw logs kept forever
+		{30, 0, 730, 0, 0},
+		{30, 90, 0, 90, 0},
+	}
+	for _, tt := range tests {
+		cfg := &Config{LogRetentionDays: tt.logDays, Retention: RetentionConfig{HourlyRollupDays: tt.hourlyDays, DailyRollupDays: tt.dailyDays}}
+		if got := cfg.HourlyRollupRetention(); got != tt.wantHourly {
+			t.Errorf("HourlyRollupRetention() with %+v = %d, want %d", tt, got, tt.wantHourly)
+		}
+		if got := cfg.DailyRollupRetention(); got != tt.wantDaily {
+			t.Errorf("DailyRollupRetention() with %+v = %d, want %d", tt, got, tt.wantDaily)
+		}
+	}
+}
+
+func TestLoad_Maintenance(t *testing.T) {
+	t.Setenv("MAINTENANCE_BATCH_PAUSE", "250ms")
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg := LoadWithFlagSet(fs, []string{"-maintenance-window=22:30-04:00", "-maintenance-batch-size=500"})
+	want := MaintenanceConfig{Window: "22:30-04:00", BatchSize: 500, BatchPause: 250 * time.Millisecond, VacuumPages: 1000}
+	if cfg.Maintenance != want {
+		t.Errorf("Expected %+v, got %+v", want, cfg.Maintenance)
+	}
+
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg = LoadWithFlagSet(fs, []string{"-maintenance-window=nightly"})
+	if cfg.Maintenance != DefaultMaintenanceConfig() {
+		t.Errorf("Expected an invalid window to fall back to defaults, got %+v", cfg.Maintenance)
+	}
+}
+
+func TestWindowNext(t *testing.T) {
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 1, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		window     string
+		t          time.Time
+		start, end time.Time
+	}{
+		{"02:00-05:00", at(10, 1, 0), at(10, 2, 0), at(10, 5, 0)},
+		{"02:00-05:00", at(10, 3, 0), at(10, 3, 0), at(10, 5, 0)}, // already open
+		{"02:00-05:00", at(10, 5, 0), at(11, 2, 0), at(11, 5, 0)}, // the end is exclusive
+		{"23:00-01:30", at(10, 12, 0), at(10, 23, 0), at(11, 1, 30)},
+		{"23:00-01:30", at(11, 0, 45), at(11, 0, 45), at(11, 1, 30)}, // opened yesterday
+		{"", at(10, 12, 0), at(10, 12, 0), time.Time{}},
+	}
+	for _, tt := range tests {
+		w, err := ParseWindow(tt.window)
+		if err != nil {
+			t.Fatalf("ParseWindow(%q) failed: %v", tt.window, err)
+		}
+		if w.String() != tt.window {
+			t.Errorf("Window(%q).String() = %q", tt.window, w.String())
+		}
+		start, end := w.Next(tt.t)
+		if !start.Equal(tt.start) || !end.Equal(tt.end) {
+			t.Errorf("Window(%q).Next(%v) = %v, %v; want %v, %v", tt.window, tt.t, start, end, tt.start, tt.end)
+		}
+	}
+
+	for _, bad := range []string{"02:00", "25:00-03:00", "02:00-02:00"} {
+		if _, err := ParseWindow(bad); err == nil {
+			t.Errorf("Expected ParseWindow(%q) to fail", bad)
+		}
+	}
+}
+
+func TestLoad_Anonymize(t *testing.T) {
+	t.Setenv("ANONYMIZE_HMAC_KEYS", "new-key-0123456789,old-key-0123456789")
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg := LoadWithFlagSet(fs, []string{"-anonymize-ips=hmac"})
+	if cfg.Anonymize.Mode != "hmac" || len(cfg.Anonymize.HMACKeys) != 2 {
+		t.Errorf("Unexpected anonymize settings: %+v", cfg.Anonymize)
+	}
+
+	// An invalid setting falls back to truncating rather than raw
+	t.Setenv("ANONYMIZE_HMAC_KEYS", "short")
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg = LoadWithFlagSet(fs, []string{"-anonymize-ips=hmac"})
+	if cfg.Anonymize.Mode != "truncate" {
+		t.Errorf("Expected an invalid hmac setup to fall back to truncate, got %+v", cfg.Anonymize)
+	}
+}
+
+func TestLoad_Capture(t *testing.T) {
+	t.Setenv("CAPTURE_REDACT_HEADERS", "Authorization, X-Api-Key")
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg := LoadWithFlagSet(fs, []string{"-capture-headers=false", "-capture-body-bytes=128"})
+	if cfg.Capture.Headers || cfg.Capture.BodyBytes != 128 || len(cfg.Capture.RedactHeaders) != 2 {
+		t.Errorf("Unexpected capture settings: %+v", cfg.Capture)
+	}
+
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	if _, err := Parse(fs, []string{"-capture-body-bytes=-1"}); err == nil {
+		t.Error("Expected a negative body size to be invalid")
+	}
+}
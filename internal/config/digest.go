@@ -0,0 +1,137 @@
+package config
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// SMTP connection security
+const (
+	SMTPStartTLS = "starttls" // upgrade a plain connection with STARTTLS
+	SMTPTLS      = "tls"      // connect with TLS, usually to port 465
+	SMTPNone     = "none"     // no encryption; only for a local relay
+)
+
+// Digest schedules
+const (
+	DigestDaily  = "daily"  // covers the day before each send
+	DigestWeekly = "weekly" // covers the week before each send
+)
+
+// SMTPConfig is the mail server the digests are sent through
+type SMTPConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"` // authenticates with PLAIN when set
+	Password string `yaml:"password"`
+	TLS      string `yaml:"tls"` // SMTPStartTLS, SMTPTLS or SMTPNone
+}
+
+// DigestRecipient is an address and when it receives the digest
+type DigestRecipient struct {
+	Address  string `yaml:"address"`
+	Schedule string `yaml:"schedule"` // DigestDaily or DigestWeekly
+	At       string `yaml:"at"`       // local time of day sent, HH:MM
+	Weekday  string `yaml:"weekday"`  // day a weekly digest is sent
+}
+
+// DigestConfig holds the email digest settings. Digests are off until an
+// SMTP host and recipients are configured.
+type DigestConfig struct {
+	SMTP       SMTPConfig        `yaml:"smtp"`
+	From       string            `yaml:"from"`
+	Top        int               `yaml:"top"` // endpoints, sources and new sources listed
+	Recipients []DigestRecipient `yaml:"recipients"`
+}
+
+// DefaultDigestConfig returns the built-in digest settings, with no
+// recipients
+func DefaultDigestConfig() DigestConfig {
+	return DigestConfig{
+		SMTP: SMTPConfig{Port: 587, TLS: SMTPStartTLS},
+		Top:  10,
+	}
+}
+
+// Enabled reports whether digests can be sent
+func (d DigestConfig) Enabled() bool {
+	return d.SMTP.Host != "" && len(d.Recipients) > 0
+}
+
+// Validate checks that the digest settings are usable
+func (d DigestConfig) Validate() error {
+	if d.SMTP.Port < 1 || d.SMTP.Port > 65535 {
+		return fmt.Errorf("smtp port must be between 1 and 65535, got %d", d.SMTP.Port)
+	}
+	switch d.SMTP.TLS {
+	case SMTPStartTLS, SMTPTLS, SMTPNone:
+	default:
+		return fmt.Errorf("smtp tls must be %q, %q or %q, got %q", SMTPStartTLS, SMTPTLS, SMTPNone, d.SMTP.TLS)
+	}
+	if (d.SMTP.Username == "") != (d.SMTP.Password == "") {
+		return fmt.Errorf("smtp username and password must be set together")
+	}
+	if d.Top < 1 {
+		return fmt.Errorf("top must be at least 1, got %d", d.Top)
+	}
+	if len(d.Recipients) == 0 {
+		return nil
+	}
+	if d.SMTP.Host == "" {
+		return fmt.Errorf("recipients need an smtp host")
+	}
+	if _, err := mail.ParseAddress(d.From); err != nil {
+		return fmt.Errorf("invalid from address %q", d.From)
+	}
+	for _, r := range d.Recipients {
+		if err := r.Validate(); err != nil {
+			return fmt.Errorf("recipient %q: %w", r.Address, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks the recipient's address and schedule
+func (r DigestRecipient) Validate() error {
+	if _, err := mail.ParseAddress(r.Address); err != nil {
+		return fmt.Errorf("invalid address")
+	}
+	if r.Schedule != DigestDaily && r.Schedule != DigestWeekly {
+		return fmt.Errorf("schedule must be %q or %q, got %q", DigestDaily, DigestWeekly, r.Schedule)
+	}
+	if _, err := r.TimeOfDay(); err != nil {
+		return err
+	}
+	if _, err := r.Day(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// TimeOfDay returns the time after midnight the digest is sent, 08:00 by
+// default
+func (r DigestRecipient) TimeOfDay() (time.Duration, error) {
+	if r.At == "" {
+		return 8 * time.Hour, nil
+	}
+	t, err := time.Parse("15:04", r.At)
+	if err != nil {
+		return 0, fmt.Errorf("at must be a time of day as HH:MM, got %q", r.At)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Day returns the weekday a weekly digest is sent, Monday by default
+func (r DigestRecipient) Day() (time.Weekday, error) {
+	if r.Weekday == "" {
+		return time.Monday, nil
+	}
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(r.Weekday, d.String()) {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("weekday must be a day name such as monday, got %q", r.Weekday)
+}
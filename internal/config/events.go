@@ -0,0 +1,174 @@
+package config
+
+import (
+	"fmt"
+	"net/netip"
+	"net/url"
+	"time"
+)
+
+// Event sink types
+const (
+	SinkFile = "file" // rotating NDJSON files
+	SinkHTTP = "http" // NDJSON POSTed to an HTTP endpoint
+)
+
+// SinkFilter selects the events a sink receives. Empty lists match
+// everything.
+type SinkFilter struct {
+	Methods    []string `yaml:"methods"`     // request methods, e.g. POST
+	Paths      []string `yaml:"paths"`       // URL path prefixes
+	ExcludeIPs []string `yaml:"exclude_ips"` // CIDRs whose requests are not sent
+}
+
+// SinkConfig is one destination for captured requests. Batching and retry
+// settings left at zero take the events section's values; the other
+// fields apply to the sink types noted.
+type SinkConfig struct {
+	Name          string        `yaml:"name"` // unique, names the sink in logs and metrics
+	Type          string        `yaml:"type"`
+	Filter        SinkFilter    `yaml:"filter"`
+	BatchSize     int           `yaml:"batch_size"`     // events written together
+	FlushInterval time.Duration `yaml:"flush_interval"` // longest an event waits for a batch to fill
+	QueueSize     int           `yaml:"queue_size"`     // events waiting; more are dropped
+	Retries       int           `yaml:"retries"`        // attempts after a failed write
+	RetryBackoff  time.Duration `yaml:"retry_backoff"`  // wait before the first retry, doubling
+	Timeout       time.Duration `yaml:"timeout"`        // limit for one write
+
+	Path      string `yaml:"path"`        // file: the current file; rotated ones get .1, .2, ...
+	MaxSizeMB int    `yaml:"max_size_mb"` // file: size at which the file is rotated
+	MaxFiles  int    `yaml:"max_files"`   // file: rotated files kept
+
+	URL     string            `yaml:"url"`     // http: endpoint
+	Headers map[string]string `yaml:"headers"` // http: sent with each request, e.g. Authorization
+}
+
+// EventsConfig holds the sinks each captured request is sent to besides
+// the database, and their default batching and retry settings
+type EventsConfig struct {
+	BatchSize     int           `yaml:"batch_size"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	QueueSize     int           `yaml:"queue_size"`
+	Retries       int           `yaml:"retries"`
+	RetryBackoff  time.Duration `yaml:"retry_backoff"`
+	Timeout       time.Duration `yaml:"timeout"`
+	Sinks         []SinkConfig  `yaml:"sinks"`
+}
+
+// DefaultEventsConfig returns the built-in event settings, with no sinks
+func DefaultEventsConfig() EventsConfig {
+	return EventsConfig{
+		BatchSize:     100,
+		FlushInterval: time.Second,
+		QueueSize:     10000,
+		Retries:       3,
+		RetryBackoff:  time.Second,
+		Timeout:       10 * time.Second,
+	}
+}
+
+// Sink returns s with the settings it leaves at zero taken from e and the
+// type's defaults
+func (e EventsConfig) Sink(s SinkConfig) SinkConfig {
+	if s.BatchSize == 0 {
+		s.BatchSize = e.BatchSize
+	}
+	if s.FlushInterval == 0 {
+		s.FlushInterval = e.FlushInterval
+	}
+	if s.QueueSize == 0 {
+		s.QueueSize = e.QueueSize
+	}
+	if s.Retries == 0 {
+		s.Retries = e.Retries
+	}
+	if s.RetryBackoff == 0 {
+		s.RetryBackoff = e.RetryBackoff
+	}
+	if s.Timeout == 0 {
+		s.Timeout = e.Timeout
+	}
+	if s.Type == SinkFile {
+		if s.MaxSizeMB == 0 {
+			s.MaxSizeMB = 100
+		}
+		if s.MaxFiles == 0 {
+			s.MaxFiles = 5
+		}
+	}
+	return s
+}
+
+// Validate checks that the event settings and every sink are usable
+func (e EventsConfig) Validate() error {
+	if e.BatchSize < 1 {
+		return fmt.Errorf("batch_size must be at least 1, got %d", e.BatchSize)
+	}
+	if e.FlushInterval <= 0 {
+		return fmt.Errorf("flush_interval must be positive, got %s", e.FlushInterval)
+	}
+	if e.QueueSize < 1 {
+		return fmt.Errorf("queue_size must be at least 1, got %d", e.QueueSize)
+	}
+	if e.Retries < 0 {
+		return fmt.Errorf("retries must not be negative, got %d", e.Retries)
+	}
+	if e.RetryBackoff <= 0 {
+		return fmt.Errorf("retry_backoff must be positive, got %s", e.RetryBackoff)
+	}
+	if e.Timeout <= 0 {
+		return fmt.Errorf("timeout must be positive, got %s", e.Timeout)
+	}
+	names := make(map[string]bool, len(e.Sinks))
+	for _, s := range e.Sinks {
+		if s.Name == "" {
+			return fmt.Errorf("sink name must not be empty")
+		}
+		if names[s.Name] {
+			return fmt.Errorf("duplicate sink %q", s.Name)
+		}
+		names[s.Name] = true
+		if err := e.Sink(s).Validate(); err != nil {
+			return fmt.Errorf("sink %q: %w", s.Name, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks a sink's settings, after defaults are applied
+func (s SinkConfig) Validate() error {
+	if s.BatchSize < 1 || s.QueueSize < 1 || s.Retries < 0 || s.FlushInterval <= 0 || s.RetryBackoff <= 0 || s.Timeout <= 0 {
+		return fmt.Errorf("batch_size and queue_size must be at least 1, retries not negative and durations positive")
+	}
+	if err := s.Filter.validate(); err != nil {
+		return err
+	}
+
+	switch s.Type {
+	case SinkFile:
+		if s.Path == "" {
+			return fmt.Errorf("file sink needs a path")
+		}
+		if s.MaxSizeMB < 1 || s.MaxFiles < 1 {
+			return fmt.Errorf("max_size_mb and max_files must be at least 1")
+		}
+	case SinkHTTP:
+		u, err := url.Parse(s.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("http sink url must be an http or https URL")
+		}
+	default:
+		return fmt.Errorf("type must be %q or %q, got %q", SinkFile, SinkHTTP, s.Type)
+	}
+	return nil
+}
+
+// validate checks the filter's networks
+func (f SinkFilter) validate() error {
+	for _, ip := range f.ExcludeIPs {
+		if _, err := netip.ParsePrefix(ip); err != nil {
+			return fmt.Errorf("invalid CIDR %q in exclude_ips", ip)
+		}
+	}
+	return nil
+}
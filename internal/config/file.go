@@ -0,0 +1,87 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loadFile applies a YAML config file on top of c. Only the keys present in
+// the file are changed; unknown keys are an error so typos aren't ignored.
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			// Read-only file, nothing to flush
+		}
+	}()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// RestartRequired lists the settings, by config file key, that differ
+// between old and next but only take effect after a restart
+func RestartRequired(old, next *Config) []string {
+	var changed []string
+	if old.Port != next.Port {
+		changed = append(changed, "port")
+	}
+	if !reflect.DeepEqual(old.Listeners, next.Listeners) {
+		changed = append(changed, "listeners")
+	}
+	if old.DBPath != next.DBPath {
+		changed = append(changed, "db")
+	}
+	if old.AuthUsername != next.AuthUsername || old.AuthPassword != next.AuthPassword {
+		changed = append(changed, "auth_username", "auth_password")
+	}
+	oldGroups := rateLimitGroups(&old.RateLimit)
+	for i, group := range rateLimitGroups(&next.RateLimit) {
+		if oldGroups[i].policy.MaxEntries != group.policy.MaxEntries {
+			changed = append(changed, "rate_limits."+group.name+".max_entries")
+		}
+	}
+	if !reflect.DeepEqual(old.Metrics, next.Metrics) {
+		changed = append(changed, "metrics")
+	}
+	if old.Backup != next.Backup {
+		changed = append(changed, "backup")
+	}
+	if !reflect.DeepEqual(old.Capture, next.Capture) {
+		changed = append(changed, "capture")
+	}
+	if !reflect.DeepEqual(old.Digest, next.Digest) {
+		changed = append(changed, "digest")
+	}
+	if old.Syslog != next.Syslog {
+		changed = append(changed, "syslog")
+	}
+	if !reflect.DeepEqual(old.Events, next.Events) {
+		changed = append(changed, "events")
+	}
+	if !reflect.DeepEqual(old.OTel, next.OTel) {
+		changed = append(changed, "otel")
+	}
+	if old.Sensor != next.Sensor {
+		changed = append(changed, "sensor")
+	}
+	if !reflect.DeepEqual(old.Collector, next.Collector) {
+		changed = append(changed, "collector")
+	}
+	if !reflect.DeepEqual(old.Banners, next.Banners) {
+		changed = append(changed, "banners")
+	}
+	return changed
+}
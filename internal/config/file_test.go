@@ -0,0 +1,572 @@
+package config
+
+import (
+	"flag"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	return path
+}
+
+func TestParse_ConfigFile(t *testing.T) {
+	path := writeConfigFile(t, `
+port: 9090
+db: /var/lib/requests.db
+log_level: warn
+log_retention_days: 7
+rate_limits:
+  stats:
+    per_ip: 20
+    exempt: [10.0.0.0/8]
+bans:
+  auto_ban: true
+  window: 5m
+metrics:
+  allow: [192.168.0.0/16]
+`)
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg, err := Parse(fs, []string{"-config", path})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if cfg.Port != 9090 || cfg.DBPath != "/var/lib/requests.db" || cfg.LogLevel != "warn" || cfg.LogRetentionDays != 7 {
+		t.Errorf("Unexpected top-level settings: %+v", cfg)
+	}
+	if cfg.RateLimit.Stats.PerIPPerMin != 20 || len(cfg.RateLimit.Stats.Exempt) != 1 {
+		t.Errorf("Unexpected stats policy: %+v", cfg.RateLimit.Stats)
+	}
+	// Keys not in the file keep their defaults
+	if cfg.RateLimit.Stats.GlobalPerMin != DefaultRateLimitConfig().Stats.GlobalPerMin {
+		t.Errorf("Expected stats global limit to keep its default, got %d", cfg.RateLimit.Stats.GlobalPerMin)
+	}
+	if !cfg.Ban.AutoBan || cfg.Ban.Window != 5*time.Minute || cfg.Ban.Threshold != DefaultBanConfig().Threshold {
+		t.Errorf("Unexpected ban settings: %+v", cfg.Ban)
+	}
+	if len(cfg.Metrics.AllowList) != 1 || !cfg.Metrics.Enabled {
+		t.Errorf("Unexpected metrics settings: %+v", cfg.Metrics)
+	}
+}
+
+func TestParse_Alerts(t *testing.T) {
+	path := writeConfigFile(t, `
+alerts:
+  cooldown: 1h
+  webhooks:
+    - name: ops
+      url: https://hooks.example.com/T000/B000
+      format: slack
+  rules:
+    - name: log4shell
+      type: signature
+      patterns: ["${jndi:"]
+    - name: spike
+      type: url_spike
+      threshold: 50
+      window: 10m
+      baseline: 24h
+      factor: 5
+`)
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg, err := Parse(fs, []string{"-config", path})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !cfg.Alerts.Enabled() || cfg.Alerts.Cooldown != time.Hour || cfg.Alerts.Interval != DefaultAlertConfig().Interval {
+		t.Errorf("Unexpected alert settings: %+v", cfg.Alerts)
+	}
+	if len(cfg.Alerts.Rules) != 2 || cfg.Alerts.Rules[1].Factor != 5 || cfg.Alerts.Webhooks[0].Format != WebhookSlack {
+		t.Errorf("Unexpected alert rules or webhooks: %+v", cfg.Alerts)
+	}
+
+	tests := []struct {
+		name   string
+		alerts string
+	}{
+		{"rules without webhooks", "rules: [{name: a, type: signature, patterns: [x]}]"},
+		{"unknown type", "webhooks: [{url: http://x}]\n  rules: [{name: a, type: tag}]"},
+		{"duplicate name", "webhooks: [{url: http://x}]\n  rules: [{name: a, type: signature, patterns: [x]}, {name: a, type: signature, patterns: [y]}]"},
+		{"no threshold", "webhooks: [{url: http://x}]\n  rules: [{name: a, type: request_rate, window: 1m}]"},
+		{"partial baseline hour", "webhooks: [{url: http://x}]\n  rules: [{name: a, type: url_spike, threshold: 1, window: 1m, baseline: 90m, factor: 2}]"},
+		{"bad webhook URL", "webhooks: [{url: ftp://x}]"},
+		{"bad format", "webhooks: [{url: http://x, format: xml}]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfigFile(t, "alerts:\n  "+tt.alerts+"\n")
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			cfg, err := Parse(fs, []string{"-config", path})
+			if err == nil || !strings.Contains(err.Error(), "alerts:") {
+				t.Fatalf("Expected an alerts error, got %v", err)
+			}
+			if cfg.Alerts.Enabled() {
+				t.Error("Expected invalid alert settings to be reset")
+			}
+		})
+	}
+}
+
+func TestParse_Digest(t *testing.T) {
+	path := writeConfigFile(t, `
+digest:
+  smtp:
+    host: mail.example.com
+    username: digest
+  from: Silver Eureka <digest@example.com>
+  recipients:
+    - address: ops@example.com
+      schedule: daily
+      at: "07:30"
+    - address: lead@example.com
+      schedule: weekly
+      weekday: friday
+`)
+	t.Setenv("SMTP_PASSWORD", "secret")
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg, err := Parse(fs, []string{"-config", path})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !cfg.Digest.Enabled() || cfg.Digest.SMTP.Port != 587 || cfg.Digest.SMTP.TLS != SMTPStartTLS || cfg.Digest.SMTP.Password != "secret" {
+		t.Errorf("Unexpected digest settings: %+v", cfg.Digest)
+	}
+	if at, _ := cfg.Digest.Recipients[0].TimeOfDay(); at != 7*time.Hour+30*time.Minute {
+		t.Errorf("TimeOfDay = %v, want 7h30m", at)
+	}
+	if day, _ := cfg.Digest.Recipients[1].Day(); day != time.Friday {
+		t.Errorf("Day = %v, want Friday", day)
+	}
+
+	tests := []struct {
+		name   string
+		digest string
+	}{
+		{"recipients without host", "from: a@example.com\n  recipients: [{address: b@example.com, schedule: daily}]"},
+		{"bad schedule", "smtp: {host: x}\n  from: a@example.com\n  recipients: [{address: b@example.com, schedule: hourly}]"},
+		{"bad time", "smtp: {host: x}\n  from: a@example.com\n  recipients: [{address: b@example.com, schedule: daily, at: '25:00'}]"},
+		{"bad weekday", "smtp: {host: x}\n  from: a@example.com\n  recipients: [{address: b@example.com, schedule: weekly, weekday: someday}]"},
+		{"bad address", "smtp: {host: x}\n  from: a@example.com\n  recipients: [{address: nobody, schedule: daily}]"},
+		{"bad tls", "smtp: {host: x, tls: ssl}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfigFile(t, "digest:\n  "+tt.digest+"\n")
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			cfg, err := Parse(fs, []string{"-config", path})
+			if err == nil || !strings.Contains(err.Error(), "digest:") {
+				t.Fatalf("Expected a digest error, got %v", err)
+			}
+			if cfg.Digest.Enabled() {
+				t.Error("Expected invalid digest settings to be reset")
+			}
+		})
+	}
+}
+
+func TestParse_Syslog(t *testing.T) {
+	for _, bad := range []string{
+		"address: siem.example.com",
+		"address: siem:514\n  network: sctp",
+		"address: siem:514\n  format: json",
+		"address: siem:514\n  facility: local9",
+		"address: siem:514\n  app_name: silver eureka",
+		"address: siem:514\n  buffer_max_mb: 0",
+	} {
+		path := writeConfigFile(t, "syslog:\n  "+bad+"\n")
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		cfg, err := Parse(fs, []string{"-config", path})
+		if err == nil || !strings.Contains(err.Error(), "syslog:") {
+			t.Errorf("%q: expected a syslog error, got %v", bad, err)
+		}
+		if cfg.Syslog.Enabled() {
+			t.Errorf("%q: expected invalid syslog settings to be reset", bad)
+		}
+	}
+
+	path := writeConfigFile(t, `
+syslog:
+  address: siem.example.com:6514
+  network: tls
+  format: leef
+  facility: local3
+`)
+	t.Setenv("SYSLOG_FORMAT", "cef")
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg, err := Parse(fs, []string{"-config", path})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !cfg.Syslog.Enabled() || cfg.Syslog.Network != SyslogTLS || cfg.Syslog.Format != SyslogCEF ||
+		SyslogFacilities[cfg.Syslog.Facility] != 19 || cfg.Syslog.BufferMaxMB != 100 {
+		t.Errorf("Unexpected syslog settings: %+v", cfg.Syslog)
+	}
+}
+
+func TestParse_Events(t *testing.T) {
+	for _, bad := range []string{
+		"batch_size: 0",
+		"sinks:\n    - {name: a, type: file}",
+		"sinks:\n    - {name: a, type: kafka}",
+		"sinks:\n    - {name: a, type: http, url: 'ftp://collector'}",
+		"sinks:\n    - {name: a, type: s3}",
+		"sinks:\n    - {name: a, type: file, path: a.ndjson}\n    - {name: a, type: file, path: b.ndjson}",
+	} {
+		path := writeConfigFile(t, "events:\n  "+bad+"\n")
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		cfg, err := Parse(fs, []string{"-config", path})
+		if err == nil || !strings.Contains(err.Error(), "events:") {
+			t.Errorf("%q: expected an events error, got %v", bad, err)
+		}
+		if len(cfg.Events.Sinks) != 0 {
+			t.Errorf("%q: expected invalid event settings to be reset", bad)
+		}
+	}
+
+	path := writeConfigFile(t, `
+events:
+  batch_size: 50
+  sinks:
+    - name: archive
+      type: file
+      path: data/events.ndjson
+    - name: collector
+      type: http
+      url: https://collector.example.com/ingest
+      batch_size: 10
+      filter:
+        methods: [POST]
+        exclude_ips: [10.0.0.0/8]
+`)
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg, err := Parse(fs, []string{"-config", path})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(cfg.Events.Sinks) != 2 {
+		t.Fatalf("Expected 2 sinks, got %+v", cfg.Events.Sinks)
+	}
+	archive := cfg.Events.Sink(cfg.Events.Sinks[0])
+	if archive.BatchSize != 50 || archive.MaxSizeMB != 100 || archive.MaxFiles != 5 || archive.Retries != 3 {
+		t.Errorf("Unexpected file sink settings: %+v", archive)
+	}
+	stream := cfg.Events.Sink(cfg.Events.Sinks[1])
+	if stream.BatchSize != 10 || stream.Filter.Methods[0] != "POST" || stream.Filter.ExcludeIPs[0] != "10.0.0.0/8" {
+		t.Errorf("Unexpected http sink settings: %+v", stream)
+	}
+}
+
+func TestParse_OTel(t *testing.T) {
+	for _, bad := range []string{
+		"endpoint: otel:4318",
+		"endpoint: http://otel:4318\n  protocol: http/json",
+		"endpoint: http://otel:4318\n  sample_ratio: 1.5",
+		"endpoint: http://otel:4318\n  queue_size: 10",
+		"endpoint: http://otel:4318\n  service_name: ''",
+	} {
+		path := writeConfigFile(t, "otel:\n  "+bad+"\n")
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		cfg, err := Parse(fs, []string{"-config", path})
+		if err == nil || !strings.Contains(err.Error(), "otel:") {
+			t.Errorf("%q: expected an otel error, got %v", bad, err)
+		}
+		if cfg.OTel.Enabled() {
+			t.Errorf("%q: expected invalid otel settings to be reset", bad)
+		}
+	}
+
+	path := writeConfigFile(t, `
+otel:
+  endpoint: https://otel.example.com:4318
+  bridge_slog: true
+  logs: false
+  headers:
+    Api-Key: secret
+`)
+	t.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "grpc")
+	t.Setenv("OTEL_TRACES_SAMPLER_ARG", "0.1")
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg, err := Parse(fs, []string{"-config", path})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !cfg.OTel.Enabled() || cfg.OTel.Protocol != OTLPGRPC || cfg.OTel.SampleRatio != 0.1 ||
+		cfg.OTel.Logs || !cfg.OTel.Traces || !cfg.OTel.BridgeSlog || cfg.OTel.Headers["Api-Key"] != "secret" {
+		t.Errorf("Unexpected otel settings: %+v", cfg.OTel)
+	}
+}
+
+func TestParse_SensorAndCollector(t *testing.T) {
+	for _, bad := range []string{
+		"sensor:\n  id: edge 1",
+		"sensor:\n  collector_url: central:9443",
+		"sensor:\n  collector_url: https://central:9443/ingest",
+		"sensor:\n  collector_url: http://central:9443/ingest\n  cert_file: sensor.pem\n  key_file: sensor.key",
+		"sensor:\n  token: abc\n  batch_size: 100000",
+		"collector:\n  listen: ':9443'",
+		"collector:\n  listen: ':9443'\n  client_ca_file: ca.pem",
+		"collector:\n  listen: ':9443'\n  tokens:\n    edge-1: short",
+		"collector:\n  listen: ':9443'\n  tokens:\n    edge-1: 0123456789abcdef\n    edge-2: 0123456789abcdef",
+	} {
+		path := writeConfigFile(t, bad+"\n")
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		cfg, err := Parse(fs, []string{"-config", path})
+		if err == nil || !(strings.Contains(err.Error(), "sensor:") || strings.Contains(err.Error(), "collector:")) {
+			t.Errorf("%q: expected a sensor or collector error, got %v", bad, err)
+		}
+		if cfg.Sensor.Enabled() || cfg.Collector.Enabled() {
+			t.Errorf("%q: expected invalid settings to be reset", bad)
+		}
+	}
+
+	path := writeConfigFile(t, `
+sensor:
+  id: central
+  spool_dir: /var/spool/sensor
+collector:
+  listen: ":9443"
+  cert_file: collector.pem
+  key_file: collector.key
+  client_ca_file: sensors-ca.pem
+  tokens:
+    edge-1: 0123456789abcdef
+`)
+	t.Setenv("SENSOR_COLLECTOR_URL", "https://upstream:9443/ingest")
+	t.Setenv("SENSOR_TOKEN", "fedcba9876543210")
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg, err := Parse(fs, []string{"-config", path})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !cfg.Sensor.Enabled() || cfg.Sensor.ID != "central" || cfg.Sensor.Token != "fedcba9876543210" ||
+		cfg.Sensor.SpoolDir != "/var/spool/sensor" || cfg.Sensor.BatchSize != 500 {
+		t.Errorf("Unexpected sensor settings: %+v", cfg.Sensor)
+	}
+	if !cfg.Collector.Enabled() || cfg.Collector.ClientCAFile != "sensors-ca.pem" || cfg.Collector.Tokens["edge-1"] != "0123456789abcdef" {
+		t.Errorf("Unexpected collector settings: %+v", cfg.Collector)
+	}
+}
+
+func TestParse_Listeners(t *testing.T) {
+	for _, bad := range []string{
+		"listeners:\n  - address: '8443'",
+		"listeners:\n  - address: ':0'",
+		"listeners:\n  - address: ':8443'\n  - address: ':8443'",
+		"listeners:\n  - address: ':8443'\n    label: alt tls",
+		"listeners:\n  - address: ':8443'\n    cert_file: server.pem",
+	} {
+		path := writeConfigFile(t, bad+"\n")
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		cfg, err := Parse(fs, []string{"-config", path})
+		if err == nil || !strings.Contains(err.Error(), "listeners:") {
+			t.Errorf("%q: expected a listeners error, got %v", bad, err)
+		}
+		if cfg.Listeners != nil {
+			t.Errorf("%q: expected invalid listeners to be reset", bad)
+		}
+	}
+
+	cfg := Default()
+	if got := cfg.ServedListeners(); len(got) != 1 || got[0] != (ListenerConfig{Address: ":8080"}) {
+		t.Errorf("Expected the port as the only listener, got %+v", got)
+	}
+
+	path := writeConfigFile(t, `
+port: 9090
+listeners:
+  - address: ":8080"
+    label: web
+  - address: "127.0.0.1:8443"
+    label: behind-lb
+    cert_file: server.pem
+    key_file: server.key
+    proxy_protocol: true
+    sniff: true
+`)
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg, err := Parse(fs, []string{"-config", path})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	got := cfg.ServedListeners()
+	if len(got) != 2 || got[0].Label != "web" || !got[1].ProxyProtocol || got[1].KeyFile != "server.key" || got[0].Sniff || !got[1].Sniff {
+		t.Errorf("Unexpected listeners: %+v", got)
+	}
+}
+
+func TestParse_TrustedProxies(t *testing.T) {
+	cfg, err := Parse(flag.NewFlagSet("test", flag.ContinueOnError), nil)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if prefixes, err := cfg.TrustedProxyPrefixes(); err != nil || len(prefixes) != 6 || !prefixes[0].Contains(netip.MustParseAddr("127.0.0.1")) {
+		t.Errorf("Expected loopback and private networks by default, got %v (%v)", prefixes, err)
+	}
+
+	path := writeConfigFile(t, "trusted_proxies: [198.51.100.0/24, proxy]\n")
+	cfg, err = Parse(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-config", path})
+	if err == nil || !strings.Contains(err.Error(), "trusted_proxies:") {
+		t.Errorf("Expected a trusted_proxies error, got %v", err)
+	}
+	if !reflect.DeepEqual(cfg.TrustedProxies, DefaultTrustedProxies()) {
+		t.Errorf("Expected invalid trusted proxies to be reset, got %v", cfg.TrustedProxies)
+	}
+
+	// An empty list trusts no proxy
+	cfg, err = Parse(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-config", path, "-trusted-proxies="})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if prefixes, err := cfg.TrustedProxyPrefixes(); err != nil || len(prefixes) != 0 {
+		t.Errorf("Expected no trusted proxies, got %v (%v)", prefixes, err)
+	}
+}
+
+func TestParse_Banners(t *testing.T) {
+	for _, bad := range []string{
+		"banners:\n  listeners:\n    - address: ':2222'\n      protocol: ftp",
+		"banners:\n  listeners:\n    - address: '2222'\n      protocol: ssh",
+		"banners:\n  listeners:\n    - address: ':23'\n      protocol: telnet\n    - address: ':23'\n      protocol: raw",
+		"banners:\n  listeners:\n    - address: ':6379'\n      protocol: redis\n      label: 'no spaces'",
+		"banners:\n  capture_bytes: 0",
+		"banners:\n  timeout: 0s",
+	} {
+		path := writeConfigFile(t, bad+"\n")
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		cfg, err := Parse(fs, []string{"-config", path})
+		if err == nil || !strings.Contains(err.Error(), "banners:") {
+			t.Errorf("%q: expected a banners error, got %v", bad, err)
+		}
+		if cfg.Banners.Enabled() {
+			t.Errorf("%q: expected invalid banners to be reset", bad)
+		}
+	}
+
+	path := writeConfigFile(t, `
+banners:
+  capture_bytes: 2048
+  listeners:
+    - address: ":2222"
+      protocol: ssh
+      label: alt-ssh
+      banner: "SSH-2.0-dropbear_2022.83\r\n"
+    - address: ":3389"
+      protocol: rdp
+`)
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg, err := Parse(fs, []string{"-config", path})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	b := cfg.Banners
+	if !b.Enabled() || len(b.Listeners) != 2 || b.CaptureBytes != 2048 || b.Timeout != 10*time.Second ||
+		b.Listeners[0].Banner != "SSH-2.0-dropbear_2022.83\r\n" || b.Listeners[1].Protocol != BannerRDP {
+		t.Errorf("Unexpected banner settings: %+v", b)
+	}
+}
+
+func TestParse_Precedence(t *testing.T) {
+	path := writeConfigFile(t, "port: 9090\nlog_retention_days: 7\nlog_level: warn\n")
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("LOG_RETENTION_DAYS", "14")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg, err := Parse(fs, []string{"-log-level=error"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if cfg.Port != 9090 {
+		t.Errorf("Expected port from file, got %d", cfg.Port)
+	}
+	if cfg.LogRetentionDays != 14 {
+		t.Errorf("Expected env to override file, got %d", cfg.LogRetentionDays)
+	}
+	if cfg.LogLevel != "error" {
+		t.Errorf("Expected flag to override file, got %s", cfg.LogLevel)
+	}
+}
+
+func TestParse_ReportsAllErrors(t *testing.T) {
+	path := writeConfigFile(t, `
+port: 70000
+log_level: loud
+rate_limits:
+  catchall:
+    per_ipp: 5
+bans:
+  threshold: 0
+`)
+	t.Setenv("LOG_RETENTION_DAYS", "soon")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg, err := Parse(fs, []string{"-config", path})
+	if err == nil {
+		t.Fatal("Expected errors")
+	}
+
+	for _, want := range []string{
+		"line 6: field per_ipp not found",
+		"LOG_RETENTION_DAYS: invalid integer",
+		"port: must be between 1 and 65535",
+		"log_level: unknown log level",
+		"bans: ban threshold must be at least 1",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %q, got:\n%v", want, err)
+		}
+	}
+
+	// Invalid settings fall back to their defaults
+	def := Default()
+	if cfg.Port != def.Port || cfg.LogLevel != def.LogLevel || cfg.Ban.Threshold != def.Ban.Threshold {
+		t.Errorf("Expected invalid settings to be reset, got %+v", cfg)
+	}
+}
+
+func TestParse_MissingFile(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	if _, err := Parse(fs, []string{"-config", filepath.Join(t.TempDir(), "missing.yaml")}); err == nil {
+		t.Error("Expected error for missing config file")
+	}
+}
+
+func TestParse_AuthPair(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	if _, err := Parse(fs, []string{"-auth-user=admin"}); err == nil {
+		t.Error("Expected error when only the username is set")
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	old := Default()
+	next := Default()
+	next.LogLevel = "info"
+	next.RateLimit.CatchAll.PerIPPerMin = 1
+	if changed := RestartRequired(old, next); len(changed) != 0 {
+		t.Errorf("Expected only reloadable changes, got %v", changed)
+	}
+
+	next.Port = 9999
+	next.RateLimit.Web.MaxEntries = 10
+	next.Metrics.Token = "x"
+	next.Backup.Keep = 3
+	next.Listeners = []ListenerConfig{{Address: ":8443"}}
+	next.Banners.MaxConns = 5
+	changed := strings.Join(RestartRequired(old, next), ",")
+	if changed != "port,listeners,rate_limits.web.max_entries,metrics,backup,banners" {
+		t.Errorf("Unexpected restart-required settings: %s", changed)
+	}
+}
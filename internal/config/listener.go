@@ -0,0 +1,95 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+
+	"github.com/dangogh/silver-eureka/internal/clientip"
+)
+
+// ListenerConfig describes an address the server accepts requests on.
+// Requests are recorded with the listener's label and the port the client
+// connected to.
+type ListenerConfig struct {
+	Address  string `yaml:"address"`   // host:port, e.g. ":8443"
+	Label    string `yaml:"label"`     // recorded with each request; empty = none
+	CertFile string `yaml:"cert_file"` // PEM server certificate; serves HTTPS when set
+	KeyFile  string `yaml:"key_file"`  // PEM key of cert_file
+	// ProxyProtocol expects a PROXY protocol v1 or v2 header on every
+	// connection, as sent by HAProxy or a cloud load balancer, and records
+	// the client and port it names. Only the proxy must be able to connect.
+	ProxyProtocol bool `yaml:"proxy_protocol"`
+	// Sniff classifies each connection by its first bytes. Clients that
+	// speak TLS are served HTTPS when the listener has a certificate; other
+	// protocols are recorded as connections to a banner listener would be.
+	Sniff bool `yaml:"sniff"`
+	// SniffMaxConns limits the connections awaiting their first bytes;
+	// more are closed at once. 0 means DefaultSniffMaxConns.
+	SniffMaxConns int `yaml:"sniff_max_conns"`
+}
+
+// DefaultSniffMaxConns is the default of ListenerConfig.SniffMaxConns
+const DefaultSniffMaxConns = 100
+
+// ServedListeners returns the listeners to serve: those configured, or one
+// without a label on port
+func (c *Config) ServedListeners() []ListenerConfig {
+	if len(c.Listeners) > 0 {
+		return c.Listeners
+	}
+	return []ListenerConfig{{Address: ":" + strconv.Itoa(c.Port)}}
+}
+
+// validateListeners checks that the listeners are usable and that no two
+// share an address
+func validateListeners(listeners []ListenerConfig) error {
+	seen := make(map[string]bool, len(listeners))
+	for i, l := range listeners {
+		_, port, err := net.SplitHostPort(l.Address)
+		if err != nil {
+			return fmt.Errorf("listener %d: address must be host:port, got %q", i+1, l.Address)
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("listener %d: port must be between 1 and 65535, got %q", i+1, port)
+		}
+		if seen[l.Address] {
+			return fmt.Errorf("listener %d: address %q is also another listener's", i+1, l.Address)
+		}
+		seen[l.Address] = true
+		if l.Label != "" && !ValidSensorID(l.Label) {
+			return fmt.Errorf("listener %d: label must be 1 to 64 letters, digits, dots, dashes or underscores, got %q", i+1, l.Label)
+		}
+		if l.SniffMaxConns < 0 {
+			return fmt.Errorf("listener %d: sniff_max_conns must not be negative, got %d", i+1, l.SniffMaxConns)
+		}
+		if (l.CertFile == "") != (l.KeyFile == "") {
+			return fmt.Errorf("listener %d: cert_file and key_file must be set together", i+1)
+		}
+	}
+	return nil
+}
+
+// DefaultTrustedProxies returns the proxies trusted by default: loopback
+// and private networks
+func DefaultTrustedProxies() []string {
+	proxies := make([]string, len(clientip.DefaultTrustedProxies))
+	for i, prefix := range clientip.DefaultTrustedProxies {
+		proxies[i] = prefix.String()
+	}
+	return proxies
+}
+
+// TrustedProxyPrefixes parses the trusted proxies
+func (c *Config) TrustedProxyPrefixes() ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(c.TrustedProxies))
+	for _, cidr := range c.TrustedProxies {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
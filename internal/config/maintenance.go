@@ -0,0 +1,114 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MaintenanceConfig paces retention cleanup: when it may run, and how much
+// it deletes or vacuums per step before letting request logging back in
+type MaintenanceConfig struct {
+	Window      string        `yaml:"window"`       // daily "HH:MM-HH:MM" in local time ("" = any time)
+	BatchSize   int           `yaml:"batch_size"`   // rows deleted per step
+	BatchPause  time.Duration `yaml:"batch_pause"`  // wait between steps
+	VacuumPages int           `yaml:"vacuum_pages"` // pages returned to the file system per step
+}
+
+// DefaultMaintenanceConfig returns the built-in maintenance settings: any
+// time of day, in steps of 1000 rows or pages with 50ms between them
+func DefaultMaintenanceConfig() MaintenanceConfig {
+	return MaintenanceConfig{
+		BatchSize:   1000,
+		BatchPause:  50 * time.Millisecond,
+		VacuumPages: 1000,
+	}
+}
+
+// Validate checks that the maintenance settings are usable
+func (m MaintenanceConfig) Validate() error {
+	if _, err := ParseWindow(m.Window); err != nil {
+		return err
+	}
+	if m.BatchSize < 1 {
+		return fmt.Errorf("batch_size must be at least 1, got %d", m.BatchSize)
+	}
+	if m.BatchPause < 0 {
+		return fmt.Errorf("batch_pause must not be negative, got %s", m.BatchPause)
+	}
+	if m.VacuumPages < 1 {
+		return fmt.Errorf("vacuum_pages must be at least 1, got %d", m.VacuumPages)
+	}
+	return nil
+}
+
+// Window is a daily period in local time. It may span midnight. The zero
+// Window places no restriction.
+type Window struct {
+	Start, End time.Duration // offsets from midnight
+}
+
+// ParseWindow parses "HH:MM-HH:MM"; an empty string is the zero Window
+func ParseWindow(s string) (Window, error) {
+	if strings.TrimSpace(s) == "" {
+		return Window{}, nil
+	}
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return Window{}, fmt.Errorf("window %q must look like 02:00-05:00", s)
+	}
+	var w Window
+	for _, part := range []struct {
+		text string
+		dst  *time.Duration
+	}{{from, &w.Start}, {to, &w.End}} {
+		t, err := time.Parse("15:04", strings.TrimSpace(part.text))
+		if err != nil {
+			return Window{}, fmt.Errorf("window %q: invalid time %q", s, strings.TrimSpace(part.text))
+		}
+		*part.dst = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	if w.Start == w.End {
+		return Window{}, fmt.Errorf("window %q is empty", s)
+	}
+	return w, nil
+}
+
+// IsZero reports whether w places no restriction
+func (w Window) IsZero() bool {
+	return w.Start == w.End
+}
+
+// Next returns the start and end of the first window that ends after t;
+// the start is t itself when t falls within a window
+func (w Window) Next(t time.Time) (start, end time.Time) {
+	if w.IsZero() {
+		return t, time.Time{}
+	}
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	// A window spanning midnight may have opened yesterday
+	for d := -1; ; d++ {
+		day := midnight.AddDate(0, 0, d)
+		start, end = day.Add(w.Start), day.Add(w.End)
+		if !end.After(start) {
+			end = day.AddDate(0, 0, 1).Add(w.End)
+		}
+		if end.After(t) {
+			if start.Before(t) {
+				start = t
+			}
+			return start, end
+		}
+	}
+}
+
+// String formats w as ParseWindow reads it
+func (w Window) String() string {
+	if w.IsZero() {
+		return ""
+	}
+	clock := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return clock(w.Start) + "-" + clock(w.End)
+}
@@ -0,0 +1,32 @@
+package config
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// MetricsConfig holds the /metrics endpoint settings
+type MetricsConfig struct {
+	Enabled   bool     `yaml:"enabled"` // serve /metrics
+	Token     string   `yaml:"token"`   // bearer token that grants access (empty = none)
+	AllowList []string `yaml:"allow"`   // CIDRs allowed to scrape without a token
+}
+
+// DefaultMetricsConfig returns the built-in metrics settings: enabled and
+// reachable from loopback only
+func DefaultMetricsConfig() MetricsConfig {
+	return MetricsConfig{
+		Enabled:   true,
+		AllowList: []string{"127.0.0.0/8", "::1/128"},
+	}
+}
+
+// Validate checks that the allow-list entries are valid CIDRs
+func (m MetricsConfig) Validate() error {
+	for _, cidr := range m.AllowList {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			return fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// OTLP transports, named as in OTEL_EXPORTER_OTLP_PROTOCOL
+const (
+	OTLPGRPC = "grpc"
+	OTLPHTTP = "http/protobuf"
+)
+
+// OTelConfig holds the settings for exporting request logs, traces and the
+// application's own logs to an OpenTelemetry collector over OTLP. Export
+// is off until an endpoint is set.
+type OTelConfig struct {
+	// Endpoint is the collector's base URL, e.g. http://otel:4318 for
+	// http/protobuf or http://otel:4317 for grpc; https uses TLS
+	Endpoint    string            `yaml:"endpoint"`
+	Protocol    string            `yaml:"protocol"` // OTLPGRPC or OTLPHTTP
+	Headers     map[string]string `yaml:"headers"`  // sent with each export, e.g. an API key
+	ServiceName string            `yaml:"service_name"`
+
+	Logs        bool    `yaml:"logs"`         // export each captured request as a log record
+	Traces      bool    `yaml:"traces"`       // trace requests through middleware, handler and database
+	SampleRatio float64 `yaml:"sample_ratio"` // fraction of traces kept, 0 to 1
+	BridgeSlog  bool    `yaml:"bridge_slog"`  // also export the application's own log lines
+
+	BatchSize     int           `yaml:"batch_size"`     // records or spans per export
+	FlushInterval time.Duration `yaml:"flush_interval"` // longest wait before a partial batch is exported
+	QueueSize     int           `yaml:"queue_size"`     // records or spans waiting; more are dropped
+	Timeout       time.Duration `yaml:"timeout"`        // limit for one export
+}
+
+// DefaultOTelConfig returns the built-in export settings, with no
+// collector
+func DefaultOTelConfig() OTelConfig {
+	return OTelConfig{
+		Protocol:      OTLPHTTP,
+		ServiceName:   "silver-eureka",
+		Logs:          true,
+		Traces:        true,
+		SampleRatio:   1,
+		BatchSize:     512,
+		FlushInterval: 5 * time.Second,
+		QueueSize:     2048,
+		Timeout:       10 * time.Second,
+	}
+}
+
+// Enabled reports whether anything is exported
+func (o OTelConfig) Enabled() bool {
+	return o.Endpoint != ""
+}
+
+// Validate checks that the export settings are usable
+func (o OTelConfig) Validate() error {
+	if o.Endpoint != "" {
+		u, err := url.Parse(o.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("endpoint must be an http or https URL, got %q", o.Endpoint)
+		}
+	}
+	if o.Protocol != OTLPGRPC && o.Protocol != OTLPHTTP {
+		return fmt.Errorf("protocol must be %q or %q, got %q", OTLPGRPC, OTLPHTTP, o.Protocol)
+	}
+	if o.ServiceName == "" {
+		return fmt.Errorf("service_name must not be empty")
+	}
+	if o.SampleRatio < 0 || o.SampleRatio > 1 {
+		return fmt.Errorf("sample_ratio must be between 0 and 1, got %g", o.SampleRatio)
+	}
+	if o.BatchSize < 1 {
+		return fmt.Errorf("batch_size must be at least 1, got %d", o.BatchSize)
+	}
+	if o.FlushInterval <= 0 {
+		return fmt.Errorf("flush_interval must be positive, got %s", o.FlushInterval)
+	}
+	if o.QueueSize < o.BatchSize {
+		return fmt.Errorf("queue_size must be at least batch_size, got %d", o.QueueSize)
+	}
+	if o.Timeout <= 0 {
+		return fmt.Errorf("timeout must be positive, got %s", o.Timeout)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// RateLimitPolicy holds the limits applied to one group of routes
+type RateLimitPolicy struct {
+	PerIPPerMin  int      `yaml:"per_ip"`       // requests per minute per client key (0 = unlimited)
+	PerIPBurst   int      `yaml:"per_ip_burst"` // burst size per client key
+	GlobalPerMin int      `yaml:"global"`       // requests per minute across all clients (0 = unlimited)
+	GlobalBurst  int      `yaml:"global_burst"` // global burst size
+	Exempt       []string `yaml:"exempt"`       // CIDRs that bypass this policy
+	IPv4Prefix   int      `yaml:"ipv4_prefix"`  // aggregate IPv4 clients by prefix length (32 = single address)
+	IPv6Prefix   int      `yaml:"ipv6_prefix"`  // aggregate IPv6 clients by prefix length (128 = single address)
+	MaxEntries   int      `yaml:"max_entries"`  // per-client limiters kept in memory (0 = built-in default)
+}
+
+// RateLimitConfig holds the rate limit policy for each route group
+type RateLimitConfig struct {
+	CatchAll RateLimitPolicy `yaml:"catchall"` // logged catch-all handler
+	Stats    RateLimitPolicy `yaml:"stats"`    // /stats/* API
+	Web      RateLimitPolicy `yaml:"web"`      // login, dashboard and stats views
+	Health   RateLimitPolicy `yaml:"health"`   // /health
+}
+
+// DefaultRateLimitConfig returns the built-in rate limit policies
+func DefaultRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{
+		CatchAll: RateLimitPolicy{
+			PerIPPerMin:  100,
+			PerIPBurst:   10,
+			GlobalPerMin: 10000,
+			GlobalBurst:  1000,
+			IPv4Prefix:   32,
+			IPv6Prefix:   128,
+		},
+		Stats: RateLimitPolicy{
+			PerIPPerMin:  300,
+			PerIPBurst:   30,
+			GlobalPerMin: 3000,
+			GlobalBurst:  300,
+			IPv4Prefix:   32,
+			IPv6Prefix:   128,
+		},
+		Web: RateLimitPolicy{
+			PerIPPerMin:  300,
+			PerIPBurst:   30,
+			GlobalPerMin: 3000,
+			GlobalBurst:  300,
+			IPv4Prefix:   32,
+			IPv6Prefix:   128,
+		},
+		Health: RateLimitPolicy{
+			PerIPPerMin:  60,
+			PerIPBurst:   10,
+			GlobalPerMin: 600,
+			GlobalBurst:  60,
+			Exempt:       []string{"127.0.0.0/8", "::1/128"},
+			IPv4Prefix:   32,
+			IPv6Prefix:   128,
+		},
+	}
+}
+
+// ParseRateLimitPolicy applies a comma-separated policy spec on top of base.
+// Example: "per-ip=100,per-ip-burst=10,global=10000,global-burst=1000,exempt=10.0.0.0/8|::1/128,ipv4-prefix=24,ipv6-prefix=64,max-entries=100000"
+func ParseRateLimitPolicy(spec string, base RateLimitPolicy) (RateLimitPolicy, error) {
+	policy := base
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return base, fmt.Errorf("invalid rate limit setting %q: expected key=value", field)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		if key == "exempt" {
+			policy.Exempt = nil
+			for _, cidr := range strings.Split(value, "|") {
+				if cidr = strings.TrimSpace(cidr); cidr != "" {
+					policy.Exempt = append(policy.Exempt, cidr)
+				}
+			}
+			continue
+		}
+
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return base, fmt.Errorf("invalid value for rate limit setting %q: %w", key, err)
+		}
+		switch key {
+		case "per-ip":
+			policy.PerIPPerMin = n
+		case "per-ip-burst":
+			policy.PerIPBurst = n
+		case "global":
+			policy.GlobalPerMin = n
+		case "global-burst":
+			policy.GlobalBurst = n
+		case "ipv4-prefix":
+			policy.IPv4Prefix = n
+		case "ipv6-prefix":
+			policy.IPv6Prefix = n
+		case "max-entries":
+			policy.MaxEntries = n
+		default:
+			return base, fmt.Errorf("unknown rate limit setting %q", key)
+		}
+	}
+
+	if err := policy.Validate(); err != nil {
+		return base, err
+	}
+	return policy, nil
+}
+
+// Validate checks that the policy values are within range
+func (p RateLimitPolicy) Validate() error {
+	if p.PerIPPerMin < 0 || p.PerIPBurst < 0 || p.GlobalPerMin < 0 || p.GlobalBurst < 0 || p.MaxEntries < 0 {
+		return fmt.Errorf("rate limit values must not be negative")
+	}
+	if p.IPv4Prefix < 0 || p.IPv4Prefix > 32 {
+		return fmt.Errorf("ipv4-prefix must be between 0 and 32, got %d", p.IPv4Prefix)
+	}
+	if p.IPv6Prefix < 0 || p.IPv6Prefix > 128 {
+		return fmt.Errorf("ipv6-prefix must be between 0 and 128, got %d", p.IPv6Prefix)
+	}
+	for _, cidr := range p.Exempt {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			return fmt.Errorf("invalid exempt CIDR %q: %w", cidr, err)
+		}
+	}
+	return nil
+}
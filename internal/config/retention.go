@@ -0,0 +1,63 @@
+package config
+
+import "fmt"
+
+// RetentionConfig holds the lifetimes of captured request details and the
+// statistics rollups, the archive for expired raw logs and the database
+// size cap. Raw logs expire after Config.LogRetentionDays.
+type RetentionConfig struct {
+	BodyDays         int    `yaml:"body_retention_days"` // captured headers and bodies are cleared after this (0 = kept with the log)
+	HourlyRollupDays int    `yaml:"hourly_rollup_days"`  // 0 = keep forever
+	DailyRollupDays  int    `yaml:"daily_rollup_days"`   // 0 = keep forever
+	ArchiveDir       string `yaml:"archive_dir"`         // expired raw logs are written here first ("" = not archived)
+	MaxDBSizeMB      int    `yaml:"max_db_size_mb"`      // oldest data is expired above this size (0 = no cap)
+}
+
+// DefaultRetentionConfig returns the built-in retention settings: captured
+// headers and bodies for 7 days, hourly rollups for 90 days, daily rollups
+// for two years, no archive and no cap
+func DefaultRetentionConfig() RetentionConfig {
+	return RetentionConfig{
+		BodyDays:         7,
+		HourlyRollupDays: 90,
+		DailyRollupDays:  730,
+	}
+}
+
+// Validate checks that the retention settings are usable
+func (r RetentionConfig) Validate() error {
+	if r.BodyDays < 0 {
+		return fmt.Errorf("body_retention_days must not be negative, got %d", r.BodyDays)
+	}
+	if r.HourlyRollupDays < 0 {
+		return fmt.Errorf("hourly_rollup_days must not be negative, got %d", r.HourlyRollupDays)
+	}
+	if r.DailyRollupDays < 0 {
+		return fmt.Errorf("daily_rollup_days must not be negative, got %d", r.DailyRollupDays)
+	}
+	if r.MaxDBSizeMB < 0 {
+		return fmt.Errorf("max_db_size_mb must not be negative, got %d", r.MaxDBSizeMB)
+	}
+	return nil
+}
+
+// HourlyRollupRetention returns the number of days hourly rollups are kept
+// (0 = forever). It is never shorter than the raw log retention, since the
+// stats answer whole hours from the rollups wherever a range allows.
+func (c *Config) HourlyRollupRetention() int {
+	return atLeast(c.Retention.HourlyRollupDays, c.LogRetentionDays)
+}
+
+// DailyRollupRetention returns the number of days daily rollups are kept
+// (0 = forever), never shorter than the hourly rollups
+func (c *Config) DailyRollupRetention() int {
+	return atLeast(c.Retention.DailyRollupDays, c.HourlyRollupRetention())
+}
+
+// atLeast returns the longer of two retention periods, where 0 is forever
+func atLeast(days, floor int) int {
+	if days == 0 || floor == 0 {
+		return 0
+	}
+	return max(days, floor)
+}
@@ -0,0 +1,163 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+)
+
+// SensorConfig holds the settings for forwarding each logged request to a
+// central collector. Forwarding is off until a collector URL is set; the
+// ID alone tags the requests logged here.
+type SensorConfig struct {
+	// ID tags the requests logged in this instance's own database. The
+	// collector tags forwarded requests with the ID it authenticated.
+	ID           string `yaml:"id"`
+	CollectorURL string `yaml:"collector_url"` // the collector's ingest URL, e.g. https://central:9443/ingest
+	Token        string `yaml:"token"`         // bearer token, when not authenticating with a certificate
+	CertFile     string `yaml:"cert_file"`     // PEM client certificate for mutual TLS
+	KeyFile      string `yaml:"key_file"`      // PEM key of cert_file
+	CAFile       string `yaml:"ca_file"`       // PEM CA bundle trusted for the collector (empty = system roots)
+	// SpoolDir holds the requests not yet accepted by the collector, so
+	// none are lost while it can't be reached or across restarts
+	SpoolDir      string        `yaml:"spool_dir"`
+	SpoolMaxMB    int           `yaml:"spool_max_mb"`   // newest requests are dropped above this
+	BatchSize     int           `yaml:"batch_size"`     // requests per upload
+	FlushInterval time.Duration `yaml:"flush_interval"` // longest wait before a partial batch is sent
+	RetryInterval time.Duration `yaml:"retry_interval"` // between attempts to reach the collector
+	Timeout       time.Duration `yaml:"timeout"`        // limit for one upload
+}
+
+// DefaultSensorConfig returns the built-in sensor settings, with no
+// collector
+func DefaultSensorConfig() SensorConfig {
+	return SensorConfig{
+		SpoolDir:      "data/sensor-spool",
+		SpoolMaxMB:    100,
+		BatchSize:     500,
+		FlushInterval: 5 * time.Second,
+		RetryInterval: 30 * time.Second,
+		Timeout:       30 * time.Second,
+	}
+}
+
+// Enabled reports whether requests are forwarded
+func (s SensorConfig) Enabled() bool {
+	return s.CollectorURL != ""
+}
+
+// Validate checks that the sensor settings are usable
+func (s SensorConfig) Validate() error {
+	if s.ID != "" && !ValidSensorID(s.ID) {
+		return fmt.Errorf("id must be 1 to 64 letters, digits, dots, dashes or underscores, got %q", s.ID)
+	}
+	if s.CollectorURL != "" {
+		u, err := url.Parse(s.CollectorURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("collector_url must be an http or https URL, got %q", s.CollectorURL)
+		}
+		if s.Token == "" && s.CertFile == "" {
+			return fmt.Errorf("a token or cert_file is needed to authenticate with the collector")
+		}
+		if s.CertFile != "" && u.Scheme != "https" {
+			return fmt.Errorf("cert_file needs an https collector_url")
+		}
+	}
+	if (s.CertFile == "") != (s.KeyFile == "") {
+		return fmt.Errorf("cert_file and key_file must be set together")
+	}
+	if s.SpoolDir == "" {
+		return fmt.Errorf("spool_dir must not be empty")
+	}
+	if s.SpoolMaxMB < 1 {
+		return fmt.Errorf("spool_max_mb must be at least 1, got %d", s.SpoolMaxMB)
+	}
+	if s.BatchSize < 1 || s.BatchSize > MaxSensorBatch {
+		return fmt.Errorf("batch_size must be between 1 and %d, got %d", MaxSensorBatch, s.BatchSize)
+	}
+	if s.FlushInterval <= 0 {
+		return fmt.Errorf("flush_interval must be positive, got %s", s.FlushInterval)
+	}
+	if s.RetryInterval < 100*time.Millisecond {
+		return fmt.Errorf("retry_interval must be at least 100ms, got %s", s.RetryInterval)
+	}
+	if s.Timeout <= 0 {
+		return fmt.Errorf("timeout must be positive, got %s", s.Timeout)
+	}
+	return nil
+}
+
+// MaxSensorBatch is the most requests a collector accepts in one upload
+const MaxSensorBatch = 5000
+
+// CollectorConfig holds the settings of the ingest API that sensors
+// forward requests to. The API is off until a listen address is set.
+type CollectorConfig struct {
+	Listen   string `yaml:"listen"`    // host:port of the ingest API, apart from the main port
+	CertFile string `yaml:"cert_file"` // PEM server certificate; empty serves plain HTTP, e.g. behind a TLS proxy
+	KeyFile  string `yaml:"key_file"`  // PEM key of cert_file
+	// ClientCAFile is a PEM CA bundle: a sensor presenting a certificate
+	// signed by it is identified by the certificate's common name
+	ClientCAFile string `yaml:"client_ca_file"`
+	// Tokens maps sensor IDs to the bearer tokens they authenticate with
+	Tokens map[string]string `yaml:"tokens"`
+}
+
+// DefaultCollectorConfig returns the built-in collector settings, with the
+// ingest API off
+func DefaultCollectorConfig() CollectorConfig {
+	return CollectorConfig{}
+}
+
+// Enabled reports whether the ingest API is served
+func (c CollectorConfig) Enabled() bool {
+	return c.Listen != ""
+}
+
+// Validate checks that the collector settings are usable
+func (c CollectorConfig) Validate() error {
+	if c.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+			return fmt.Errorf("listen must be host:port, got %q", c.Listen)
+		}
+		if c.ClientCAFile == "" && len(c.Tokens) == 0 {
+			return fmt.Errorf("client_ca_file or tokens must be set to authenticate sensors")
+		}
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return fmt.Errorf("cert_file and key_file must be set together")
+	}
+	if c.ClientCAFile != "" && c.CertFile == "" {
+		return fmt.Errorf("client_ca_file needs cert_file, as client certificates need TLS")
+	}
+	seen := make(map[string]bool, len(c.Tokens))
+	for id, token := range c.Tokens {
+		if !ValidSensorID(id) {
+			return fmt.Errorf("sensor id %q in tokens must be 1 to 64 letters, digits, dots, dashes or underscores", id)
+		}
+		if len(token) < 16 {
+			return fmt.Errorf("token of sensor %q must be at least 16 characters", id)
+		}
+		if seen[token] {
+			return fmt.Errorf("token of sensor %q is also another sensor's", id)
+		}
+		seen[token] = true
+	}
+	return nil
+}
+
+// ValidSensorID reports whether id can name a sensor: 1 to 64 letters,
+// digits, dots, dashes or underscores
+func ValidSensorID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '.' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
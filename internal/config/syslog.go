@@ -0,0 +1,110 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// Syslog transports
+const (
+	SyslogUDP = "udp"
+	SyslogTCP = "tcp"
+	SyslogTLS = "tls"
+)
+
+// Syslog message formats
+const (
+	SyslogCEF  = "cef"  // ArcSight Common Event Format
+	SyslogLEEF = "leef" // IBM QRadar Log Event Extended Format
+)
+
+// SyslogFacilities are the facility names accepted in the config, by code
+var SyslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// SyslogConfig holds the settings for forwarding each logged request to a
+// syslog collector. Forwarding is off until an address is set.
+type SyslogConfig struct {
+	Address  string `yaml:"address"`  // collector host:port
+	Network  string `yaml:"network"`  // SyslogUDP, SyslogTCP or SyslogTLS
+	Format   string `yaml:"format"`   // SyslogCEF or SyslogLEEF
+	Facility string `yaml:"facility"` // facility name, e.g. local0
+	AppName  string `yaml:"app_name"`
+	Hostname string `yaml:"hostname"` // empty = the host's name
+	CAFile   string `yaml:"ca_file"`  // PEM CA bundle trusted for tls (empty = system roots)
+	// BufferDir holds messages that could not be sent until the collector
+	// is back
+	BufferDir     string        `yaml:"buffer_dir"`
+	BufferMaxMB   int           `yaml:"buffer_max_mb"`  // newest messages are dropped above this
+	RetryInterval time.Duration `yaml:"retry_interval"` // between attempts to reach the collector
+}
+
+// DefaultSyslogConfig returns the built-in forwarding settings, with no
+// collector
+func DefaultSyslogConfig() SyslogConfig {
+	return SyslogConfig{
+		Network:       SyslogTCP,
+		Format:        SyslogCEF,
+		Facility:      "local0",
+		AppName:       "silver-eureka",
+		BufferDir:     "data/syslog-buffer",
+		BufferMaxMB:   100,
+		RetryInterval: 5 * time.Second,
+	}
+}
+
+// Enabled reports whether requests are forwarded
+func (s SyslogConfig) Enabled() bool {
+	return s.Address != ""
+}
+
+// Validate checks that the forwarding settings are usable
+func (s SyslogConfig) Validate() error {
+	if s.Address != "" {
+		if _, _, err := net.SplitHostPort(s.Address); err != nil {
+			return fmt.Errorf("address must be host:port, got %q", s.Address)
+		}
+	}
+	switch s.Network {
+	case SyslogUDP, SyslogTCP, SyslogTLS:
+	default:
+		return fmt.Errorf("network must be %q, %q or %q, got %q", SyslogUDP, SyslogTCP, SyslogTLS, s.Network)
+	}
+	if s.Format != SyslogCEF && s.Format != SyslogLEEF {
+		return fmt.Errorf("format must be %q or %q, got %q", SyslogCEF, SyslogLEEF, s.Format)
+	}
+	if _, ok := SyslogFacilities[s.Facility]; !ok {
+		return fmt.Errorf("unknown facility %q", s.Facility)
+	}
+	if s.AppName == "" || len(s.AppName) > 48 || !syslogName(s.AppName) {
+		return fmt.Errorf("app_name must be 1 to 48 printable ASCII characters without spaces, got %q", s.AppName)
+	}
+	if len(s.Hostname) > 255 || !syslogName(s.Hostname) {
+		return fmt.Errorf("hostname must be at most 255 printable ASCII characters without spaces, got %q", s.Hostname)
+	}
+	if s.BufferDir == "" {
+		return fmt.Errorf("buffer_dir must not be empty")
+	}
+	if s.BufferMaxMB < 1 {
+		return fmt.Errorf("buffer_max_mb must be at least 1, got %d", s.BufferMaxMB)
+	}
+	if s.RetryInterval < 100*time.Millisecond {
+		return fmt.Errorf("retry_interval must be at least 100ms, got %s", s.RetryInterval)
+	}
+	return nil
+}
+
+// syslogName reports whether s is allowed in an RFC 5424 header field
+func syslogName(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 33 || s[i] > 126 {
+			return false
+		}
+	}
+	return true
+}
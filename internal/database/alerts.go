@@ -0,0 +1,105 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// NewSource is an IP address first seen recently, with what it requested
+type NewSource struct {
+	IPAddress string
+	URLs      int64 // distinct URLs
+	Requests  int64
+}
+
+// NewSources returns the addresses whose earliest request in the logs is at
+// or after since and that requested more than minURLs distinct URLs since
+func (db *DB) NewSources(since time.Time, minURLs int) ([]NewSource, error) {
+	since = since.Local()
+	rows, err := db.conn.Query(`
+		SELECT l.ip_address, COUNT(DISTINCT l.url), COUNT(*)
+		FROM request_logs l
+		WHERE l.timestamp >= ?
+		GROUP BY l.ip_address
+		HAVING COUNT(DISTINCT l.url) > ?
+			AND NOT EXISTS (SELECT 1 FROM request_logs p WHERE p.ip_address = l.ip_address AND p.timestamp < ?)
+		ORDER BY 2 DESC, 1`, since, minURLs, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query new sources: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			// Ignore close errors
+		}
+	}()
+
+	var sources []NewSource
+	for rows.Next() {
+		var s NewSource
+		if err := rows.Scan(&s.IPAddress, &s.URLs, &s.Requests); err != nil {
+			return nil, fmt.Errorf("failed to scan new source: %w", err)
+		}
+		sources = append(sources, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("new source iteration error: %w", err)
+	}
+	return sources, nil
+}
+
+// URLRate compares the recent requests for a URL with its earlier ones
+type URLRate struct {
+	URL      string
+	Recent   int64 // requests since the recent cutoff
+	Baseline int64 // requests in the baseline hours
+}
+
+// URLRates returns the URLs requested at least minRecent times since
+// recent, with their requests in the whole UTC hours of [baselineFrom,
+// baselineTo) counted from the hourly rollups
+func (db *DB) URLRates(recent time.Time, minRecent int, baselineFrom, baselineTo time.Time) ([]URLRate, error) {
+	rows, err := db.conn.Query(`
+		WITH recent AS (
+			SELECT url, COUNT(*) AS n FROM request_logs
+			WHERE timestamp >= ?
+			GROUP BY url HAVING COUNT(*) >= ?
+		)
+		SELECT r.url, r.n, COALESCE((
+			SELECT SUM(h.count) FROM rollup_urls_hourly h
+			WHERE h.bucket >= ? AND h.bucket < ? AND h.url = r.url
+		), 0)
+		FROM recent r
+		ORDER BY r.n DESC, r.url`,
+		recent.Local(), minRecent,
+		baselineFrom.UTC().Format(bucketLayout), baselineTo.UTC().Format(bucketLayout))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query URL rates: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			// Ignore close errors
+		}
+	}()
+
+	var rates []URLRate
+	for rows.Next() {
+		var r URLRate
+		if err := rows.Scan(&r.URL, &r.Recent, &r.Baseline); err != nil {
+			return nil, fmt.Errorf("failed to scan URL rate: %w", err)
+		}
+		rates = append(rates, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("URL rate iteration error: %w", err)
+	}
+	return rates, nil
+}
+
+// CountRequestsSince returns the number of requests logged at or after since
+func (db *DB) CountRequestsSince(since time.Time) (int64, error) {
+	var n int64
+	if err := db.conn.QueryRow("SELECT COUNT(*) FROM request_logs WHERE timestamp >= ?", since.Local()).Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to count requests: %w", err)
+	}
+	return n, nil
+}
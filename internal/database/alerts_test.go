@@ -0,0 +1,54 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAlertQueries(t *testing.T) {
+	db := setupTestDB(t)
+	now := time.Date(2026, 3, 2, 12, 30, 0, 0, time.UTC)
+	window := now.Add(-10 * time.Minute)
+
+	// 192.0.2.1 was seen yesterday; 192.0.2.2 is new
+	insertLog(t, db, "192.0.2.1", "/", now.Add(-24*time.Hour))
+	for _, url := range []string{"/a", "/b", "/c"} {
+		insertLog(t, db, "192.0.2.1", url, now.Add(-time.Minute))
+		insertLog(t, db, "192.0.2.2", url, now.Add(-time.Minute))
+	}
+	insertLog(t, db, "192.0.2.3", "/a", now.Add(-2*time.Minute))
+	// Baseline hours for /a
+	insertLog(t, db, "192.0.2.4", "/a", now.Add(-3*time.Hour))
+	insertLog(t, db, "192.0.2.4", "/a", now.Add(-2*time.Hour))
+
+	sources, err := db.NewSources(window, 2)
+	if err != nil {
+		t.Fatalf("NewSources failed: %v", err)
+	}
+	if len(sources) != 1 || sources[0] != (NewSource{IPAddress: "192.0.2.2", URLs: 3, Requests: 3}) {
+		t.Errorf("Expected only the new address, got %+v", sources)
+	}
+
+	hour := now.Truncate(time.Hour)
+	rates, err := db.URLRates(window, 2, hour.Add(-24*time.Hour), hour)
+	if err != nil {
+		t.Fatalf("URLRates failed: %v", err)
+	}
+	want := []URLRate{{URL: "/a", Recent: 3, Baseline: 2}, {URL: "/b", Recent: 2}, {URL: "/c", Recent: 2}}
+	if len(rates) != len(want) {
+		t.Fatalf("Expected %+v, got %+v", want, rates)
+	}
+	for i := range want {
+		if rates[i] != want[i] {
+			t.Errorf("Rate %d: expected %+v, got %+v", i, want[i], rates[i])
+		}
+	}
+
+	n, err := db.CountRequestsSince(window)
+	if err != nil {
+		t.Fatalf("CountRequestsSince failed: %v", err)
+	}
+	if n != 7 {
+		t.Errorf("Expected 7 recent requests, got %d", n)
+	}
+}
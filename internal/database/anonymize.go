@@ -0,0 +1,360 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/anonymize"
+)
+
+// The ip_anonymization table records how stored addresses were anonymized:
+// one row each time the mode, or HMAC key, used for new requests changes,
+// and one for each rewrite of older rows with rewritten_before set.
+
+// SetAnonymizer sets how LogRequest and RecordRateLimitDrops store client
+// addresses (nil = raw) and records a change of mode or key
+func (db *DB) SetAnonymizer(a *anonymize.Anonymizer) error {
+	db.anon.Store(a)
+
+	var mode, keyID string
+	err := db.conn.QueryRow(`SELECT mode, key_id FROM ip_anonymization
+		WHERE rewritten_before IS NULL ORDER BY id DESC LIMIT 1`).Scan(&mode, &keyID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to read anonymization history: %w", err)
+	}
+	if err == nil && mode == a.Mode() && keyID == a.KeyID() {
+		return nil
+	}
+	if _, err := db.conn.Exec("INSERT INTO ip_anonymization (changed_at, mode, key_id) VALUES (?, ?, ?)",
+		time.Now().UTC().Format(bucketLayout), a.Mode(), a.KeyID()); err != nil {
+		return fmt.Errorf("failed to record anonymization mode: %w", err)
+	}
+	return nil
+}
+
+// Anonymize returns ip and d as they would be stored now, for passing a
+// request on to other destinations: the address anonymized and, unless
+// addresses are kept raw, the forwarding headers left out
+func (db *DB) Anonymize(ip string, d RequestDetails) (string, RequestDetails) {
+	anon := db.anon.Load()
+	if anon.Mode() == anonymize.Raw {
+		return ip, d
+	}
+	if d.Headers != nil {
+		d.Headers = d.Headers.Clone()
+		for _, name := range forwardingHeaders {
+			d.Headers.Del(name)
+		}
+	}
+	return anon.Anonymize(ip), d
+}
+
+// AnonymizeIP returns ip as it would be stored now
+func (db *DB) AnonymizeIP(ip string) string {
+	return db.anon.Load().Anonymize(ip)
+}
+
+// IPModes returns the anonymization modes of the addresses stored for
+// requests within f, according to the recorded history
+func (db *DB) IPModes(f StatsFilter) ([]string, error) {
+	rows, err := db.conn.Query("SELECT changed_at, mode, rewritten_before FROM ip_anonymization ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query anonymization history: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			// Ignore close errors
+		}
+	}()
+
+	type period struct {
+		from time.Time
+		mode string
+	}
+	var ingest []period
+	var modes []string
+	for rows.Next() {
+		var changedAt, mode string
+		var before sql.NullString
+		if err := rows.Scan(&changedAt, &mode, &before); err != nil {
+			return nil, fmt.Errorf("failed to scan anonymization history: %w", err)
+		}
+		from, err := time.ParseInLocation(bucketLayout, changedAt, time.UTC)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse changed_at: %w", err)
+		}
+		if !before.Valid {
+			ingest = append(ingest, period{from, mode})
+			continue
+		}
+		// A rewrite covers everything logged before its cutoff
+		cutoff, err := time.ParseInLocation(bucketLayout, before.String, time.UTC)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse rewritten_before: %w", err)
+		}
+		if f.Since.IsZero() || f.Since.Before(cutoff) {
+			modes = append(modes, mode)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("anonymization history iteration error: %w", err)
+	}
+
+	// A mode for new requests counts if any were logged while it was set
+	oldestLog, err := db.OldestLog()
+	if err != nil {
+		return nil, err
+	}
+	for i, p := range ingest {
+		from, to := p.from, time.Time{}
+		if i+1 < len(ingest) {
+			to = ingest[i+1].from
+		}
+		if !f.Since.IsZero() && f.Since.After(from) {
+			from = f.Since
+		}
+		if !f.Until.IsZero() && (to.IsZero() || f.Until.Before(to)) {
+			to = f.Until
+		}
+		if !to.IsZero() && !from.Before(to) {
+			continue
+		}
+		found, err := db.hasRequests(from, to, oldestLog)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			modes = append(modes, p.mode)
+		}
+	}
+	slices.Sort(modes)
+	return slices.Compact(modes), nil
+}
+
+// hasRequests reports whether any request was logged in [from, to); a
+// zero to is open. The raw logs answer from oldestLog on, and the daily
+// rollups, to the day, for the time before it.
+func (db *DB) hasRequests(from, to, oldestLog time.Time) (bool, error) {
+	where, args := "timestamp >= ?", []any{from.Local()}
+	if !to.IsZero() {
+		where += " AND timestamp < ?"
+		args = append(args, to.Local())
+	}
+	query := "SELECT EXISTS (SELECT 1 FROM request_logs WHERE " + where + ")"
+
+	rawFrom := oldestLog.UTC().Truncate(24 * time.Hour)
+	if oldestLog.IsZero() || from.Before(rawFrom) {
+		rollupTo := to
+		if !oldestLog.IsZero() && (rollupTo.IsZero() || rawFrom.Before(rollupTo)) {
+			rollupTo = rawFrom
+		}
+		where = "bucket >= ?"
+		args = append(args, from.UTC().Truncate(24*time.Hour).Format(bucketLayout))
+		if !rollupTo.IsZero() {
+			where += " AND bucket < ?"
+			args = append(args, rollupTo.UTC().Format(bucketLayout))
+		}
+		query += " OR EXISTS (SELECT 1 FROM rollup_totals_daily WHERE " + where + ")"
+	}
+
+	var found bool
+	if err := db.conn.QueryRow(query, args...).Scan(&found); err != nil {
+		return false, fmt.Errorf("failed to check for requests: %w", err)
+	}
+	return found, nil
+}
+
+// AnonymizeResult counts the rows rewritten by AnonymizeBefore
+type AnonymizeResult struct {
+	Before      time.Time // the cutoff used, the start of a UTC day
+	Logs        int64     // request logs
+	Rollups     int64     // hourly and daily rollup rows
+	Drops       int64     // rate limit drop counters
+	Connections int64     // banner listener connections
+}
+
+// AnonymizeBefore rewrites the addresses stored for requests before cutoff
+// with a: in the request logs, both rollups, the rate limit drop counters
+// and the banner listener connections. cutoff is rounded down to the start
+// of its UTC day, so whole daily rollups are rewritten, and each day is its
+// own transaction.
+// Rollup rows and counters whose addresses become the same are merged.
+func (db *DB) AnonymizeBefore(cutoff time.Time, a *anonymize.Anonymizer) (AnonymizeResult, error) {
+	res := AnonymizeResult{Before: cutoff.UTC().Truncate(24 * time.Hour)}
+	if a.Mode() == anonymize.Raw {
+		return res, fmt.Errorf("rewriting addresses needs the truncate or hmac mode")
+	}
+
+	oldest, err := db.oldestAddress()
+	if err != nil {
+		return res, err
+	}
+	for day := oldest.UTC().Truncate(24 * time.Hour); !oldest.IsZero() && day.Before(res.Before); day = day.Add(24 * time.Hour) {
+		if err := db.anonymizeDay(day, a, &res); err != nil {
+			return res, err
+		}
+	}
+
+	if _, err := db.conn.Exec("INSERT INTO ip_anonymization (changed_at, mode, key_id, rewritten_before) VALUES (?, ?, ?, ?)",
+		time.Now().UTC().Format(bucketLayout), a.Mode(), a.KeyID(), res.Before.Format(bucketLayout)); err != nil {
+		return res, fmt.Errorf("failed to record anonymization: %w", err)
+	}
+	return res, nil
+}
+
+// oldestAddress returns the time of the oldest stored address, or the zero
+// time when there is none
+func (db *DB) oldestAddress() (time.Time, error) {
+	var oldest time.Time
+	for _, query := range []string{
+		"SELECT MIN(timestamp) FROM request_logs",
+		"SELECT MIN(bucket) FROM rollup_ips_daily",
+		"SELECT MIN(minute) FROM rate_limit_drops",
+		"SELECT MIN(timestamp) FROM tcp_connections",
+	} {
+		var value sql.NullString
+		if err := db.conn.QueryRow(query).Scan(&value); err != nil {
+			return time.Time{}, fmt.Errorf("failed to query oldest address: %w", err)
+		}
+		if !value.Valid {
+			continue
+		}
+		t, err := parseTimestamp(value.String)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to parse oldest address time: %w", err)
+		}
+		if oldest.IsZero() || t.Before(oldest) {
+			oldest = t
+		}
+	}
+	return oldest, nil
+}
+
+// anonymizeDay rewrites the addresses stored for one UTC day
+func (db *DB) anonymizeDay(day time.Time, a *anonymize.Anonymizer, res *AnonymizeResult) error {
+	from, to := day.Local(), day.Add(24*time.Hour).Local()
+	fromBucket, toBucket := day.Format(bucketLayout), day.Add(24*time.Hour).Format(bucketLayout)
+
+	tables := []struct {
+		name     string
+		inDay    string // selects the day's rows
+		args     []any
+		move     string // moves an address's rows for the day to a new address
+		affected *int64
+	}{
+		{"request_logs", "timestamp >= ? AND timestamp < ?", []any{from, to}, "", &res.Logs},
+		{"rollup_ips_hourly", "bucket >= ? AND bucket < ?", []any{fromBucket, toBucket}, moveRollup("rollup_ips_hourly"), &res.Rollups},
+		{"rollup_ips_daily", "bucket >= ? AND bucket < ?", []any{fromBucket, toBucket}, moveRollup("rollup_ips_daily"), &res.Rollups},
+		{"rate_limit_drops", "minute >= ? AND minute < ?", []any{from, to}, `
+			INSERT INTO rate_limit_drops (ip_address, ip_bin, ip_family, minute, route_group, scope, count)
+			SELECT ?, ?, ip_family, minute, route_group, scope, count FROM rate_limit_drops
+			WHERE ip_address = ? AND minute >= ? AND minute < ?
+			ON CONFLICT (ip_address, minute, route_group, scope)
+			DO UPDATE SET count = count + excluded.count`, &res.Drops},
+		{"tcp_connections", "timestamp >= ? AND timestamp < ?", []any{from, to}, "", &res.Connections},
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	for _, t := range tables {
+		ips, err := distinctAddresses(tx, t.name, t.inDay, t.args)
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				// Log but don't mask original error
+			}
+			return err
+		}
+		for _, ip := range ips {
+			next := a.Anonymize(ip)
+			if next == ip {
+				continue
+			}
+			n, err := moveAddress(tx, t.name, t.inDay, t.args, t.move, ip, next)
+			if err != nil {
+				if rbErr := tx.Rollback(); rbErr != nil {
+					// Log but don't mask original error
+				}
+				return fmt.Errorf("failed to anonymize %s: %w", t.name, err)
+			}
+			*t.affected += n
+		}
+	}
+	// Stored headers may name the address too
+	if err := scrubForwardingHeaders(tx, from, to); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			// Log but don't mask original error
+		}
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit anonymization: %w", err)
+	}
+	return nil
+}
+
+// moveRollup returns the statement merging an address's rollup rows for a
+// day into those of a new address. The distinct URLs of merged rows are
+// added up, like those of several buckets.
+func moveRollup(table string) string {
+	return `
+		INSERT INTO ` + table + ` (bucket, sensor_id, listener, local_port, ip_address, ip_bin, ip_family,
+			count, unique_urls, first_seen, last_seen)
+		SELECT bucket, sensor_id, listener, local_port, ?, ?, ip_family, count, unique_urls, first_seen, last_seen
+		FROM ` + table + `
+		WHERE ip_address = ? AND bucket >= ? AND bucket < ?
+		ON CONFLICT (bucket, sensor_id, listener, local_port, ip_address) DO UPDATE SET
+			count = count + excluded.count,
+			unique_urls = unique_urls + excluded.unique_urls,
+			first_seen = min(first_seen, excluded.first_seen),
+			last_seen = max(last_seen, excluded.last_seen)`
+}
+
+// distinctAddresses returns the addresses in table's rows matching cond
+func distinctAddresses(tx *sql.Tx, table, cond string, args []any) ([]string, error) {
+	rows, err := tx.Query("SELECT DISTINCT ip_address FROM "+table+" WHERE "+cond, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s addresses: %w", table, err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			// Ignore close errors
+		}
+	}()
+	var ips []string
+	for rows.Next() {
+		var ip string
+		if err := rows.Scan(&ip); err != nil {
+			return nil, fmt.Errorf("failed to scan %s address: %w", table, err)
+		}
+		ips = append(ips, ip)
+	}
+	return ips, rows.Err()
+}
+
+// moveAddress replaces ip with next in table's rows matching cond, merging
+// with move when given, and returns the number of rows rewritten. The
+// address family is kept, as a pseudonym doesn't show it.
+func moveAddress(tx *sql.Tx, table, cond string, args []any, move, ip, next string) (int64, error) {
+	where := " WHERE ip_address = ? AND " + cond
+	whereArgs := append([]any{ip}, args...)
+	nextArgs := append([]any{next, storedKey(next)}, whereArgs...)
+	var result sql.Result
+	var err error
+	if move == "" {
+		result, err = tx.Exec("UPDATE "+table+" SET ip_address = ?, ip_bin = ?"+where, nextArgs...)
+	} else {
+		if _, err = tx.Exec(move, nextArgs...); err != nil {
+			return 0, err
+		}
+		result, err = tx.Exec("DELETE FROM "+table+where, whereArgs...)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,177 @@
+package database
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/anonymize"
+)
+
+func newAnonymizer(t *testing.T, mode string) *anonymize.Anonymizer {
+	t.Helper()
+	a, err := anonymize.New(mode, []string{"test-key-0123456789"})
+	if err != nil {
+		t.Fatalf("Failed to create anonymizer: %v", err)
+	}
+	return a
+}
+
+func TestLogRequest_Anonymized(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.SetAnonymizer(newAnonymizer(t, anonymize.HMAC)); err != nil {
+		t.Fatalf("SetAnonymizer failed: %v", err)
+	}
+	if err := db.LogRequest("192.0.2.1", "/a"); err != nil {
+		t.Fatalf("Failed to log request: %v", err)
+	}
+	if err := db.RecordRateLimitDrops([]RateLimitDrop{
+		{IPAddress: "192.0.2.1", Minute: time.Now(), RouteGroup: "catchall", Scope: "per_ip", Count: 1},
+	}); err != nil {
+		t.Fatalf("Failed to record drops: %v", err)
+	}
+
+	sources, err := db.GetSourceStats()
+	if err != nil {
+		t.Fatalf("Failed to get source stats: %v", err)
+	}
+	if len(sources) != 1 || !strings.HasPrefix(sources[0].IPAddress, "h:") || sources[0].RateLimited != 1 {
+		t.Errorf("Expected one pseudonymized source with its drops, got %+v", sources)
+	}
+
+	summary, err := db.GetSummary()
+	if err != nil {
+		t.Fatalf("Failed to get summary: %v", err)
+	}
+	if !reflect.DeepEqual(summary.IPModes, []string{"hmac"}) {
+		t.Errorf("Expected only hmac, as nothing was logged raw, got %v", summary.IPModes)
+	}
+
+	// Logs from before the anonymizer was set were stored raw
+	insertLog(t, db, "192.0.2.9", "/older", time.Now().AddDate(0, 0, -3))
+	summary, err = db.GetSummary()
+	if err != nil {
+		t.Fatalf("Failed to get summary: %v", err)
+	}
+	if !reflect.DeepEqual(summary.IPModes, []string{"hmac", "raw"}) {
+		t.Errorf("Expected raw and hmac modes over all time, got %v", summary.IPModes)
+	}
+	summary, err = db.QuerySummary(StatsFilter{Since: time.Now().AddDate(0, 0, -1)})
+	if err != nil {
+		t.Fatalf("Failed to get summary: %v", err)
+	}
+	if !reflect.DeepEqual(summary.IPModes, []string{"hmac"}) {
+		t.Errorf("Expected only hmac for the last day, got %v", summary.IPModes)
+	}
+}
+
+func TestSetAnonymizer_RecordsChanges(t *testing.T) {
+	db := setupTestDB(t)
+	for _, mode := range []string{anonymize.Truncate, anonymize.Truncate, anonymize.HMAC} {
+		if err := db.SetAnonymizer(newAnonymizer(t, mode)); err != nil {
+			t.Fatalf("SetAnonymizer failed: %v", err)
+		}
+	}
+	var n int
+	if err := db.conn.QueryRow("SELECT COUNT(*) FROM ip_anonymization").Scan(&n); err != nil {
+		t.Fatalf("Failed to count history: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("Expected the initial raw entry and two changes, got %d entries", n)
+	}
+}
+
+func TestQueryLogs_RotatedKeys(t *testing.T) {
+	db := setupTestDB(t)
+	older, err := anonymize.New(anonymize.HMAC, []string{"old-key-0123456789"})
+	if err != nil {
+		t.Fatalf("Failed to create anonymizer: %v", err)
+	}
+	rotated, err := anonymize.New(anonymize.HMAC, []string{"new-key-0123456789", "old-key-0123456789"})
+	if err != nil {
+		t.Fatalf("Failed to create anonymizer: %v", err)
+	}
+
+	if err := db.SetAnonymizer(older); err != nil {
+		t.Fatalf("SetAnonymizer failed: %v", err)
+	}
+	if err := db.LogRequest("192.0.2.1", "/before"); err != nil {
+		t.Fatalf("Failed to log request: %v", err)
+	}
+	if err := db.SetAnonymizer(rotated); err != nil {
+		t.Fatalf("SetAnonymizer failed: %v", err)
+	}
+	if err := db.LogRequest("192.0.2.1", "/after"); err != nil {
+		t.Fatalf("Failed to log request: %v", err)
+	}
+	if err := db.LogRequest("192.0.2.2", "/other"); err != nil {
+		t.Fatalf("Failed to log request: %v", err)
+	}
+
+	logs, err := db.QueryLogs(LogFilter{IP: "192.0.2.1"})
+	if err != nil {
+		t.Fatalf("QueryLogs failed: %v", err)
+	}
+	if len(logs) != 2 || logs[0].IPAddress == logs[1].IPAddress {
+		t.Errorf("Expected the address's logs under both keys, got %+v", logs)
+	}
+
+	// Only the old key is known to the filter's anonymizer
+	logs, err = db.QueryLogs(LogFilter{IP: "192.0.2.1", Anonymizer: older})
+	if err != nil {
+		t.Fatalf("QueryLogs failed: %v", err)
+	}
+	if len(logs) != 1 || logs[0].URL != "/before" {
+		t.Errorf("Expected the log made under the old key, got %+v", logs)
+	}
+}
+
+func TestAnonymizeBefore(t *testing.T) {
+	db := setupTestDB(t)
+	old := time.Now().AddDate(0, 0, -40)
+	insertLog(t, db, "192.0.2.1", "/old", old)
+	insertLog(t, db, "192.0.2.2", "/old", old.Add(time.Minute))
+	insertLog(t, db, "192.0.2.1", "/new", time.Now())
+	if err := db.RecordRateLimitDrops([]RateLimitDrop{
+		{IPAddress: "192.0.2.1", Minute: old, RouteGroup: "catchall", Scope: "per_ip", Count: 2},
+		{IPAddress: "192.0.2.2", Minute: old, RouteGroup: "catchall", Scope: "per_ip", Count: 3},
+	}); err != nil {
+		t.Fatalf("Failed to record drops: %v", err)
+	}
+
+	res, err := db.AnonymizeBefore(time.Now().AddDate(0, 0, -30), newAnonymizer(t, anonymize.Truncate))
+	if err != nil {
+		t.Fatalf("AnonymizeBefore failed: %v", err)
+	}
+	if res.Logs != 2 || res.Rollups != 4 || res.Drops != 2 {
+		t.Errorf("Expected 2 logs, 4 rollup rows and 2 counters rewritten, got %+v", res)
+	}
+
+	// The two old addresses merge into one network in every table
+	logs, err := db.QueryLogs(LogFilter{Ascending: true})
+	if err != nil {
+		t.Fatalf("Failed to query logs: %v", err)
+	}
+	if len(logs) != 3 || logs[0].IPAddress != "192.0.2.0" || logs[1].IPAddress != "192.0.2.0" || logs[2].IPAddress != "192.0.2.1" {
+		t.Errorf("Expected only the old logs truncated, got %+v", logs)
+	}
+	sources, err := db.QuerySourceStats(StatsFilter{Until: time.Now().AddDate(0, 0, -30)})
+	if err != nil {
+		t.Fatalf("Failed to get source stats: %v", err)
+	}
+	if len(sources) != 1 || sources[0].IPAddress != "192.0.2.0" || sources[0].Count != 2 || sources[0].RateLimited != 5 {
+		t.Errorf("Expected the old rollups merged, got %+v", sources)
+	}
+	drops, err := db.GetRateLimitDrops(0)
+	if err != nil {
+		t.Fatalf("Failed to get drops: %v", err)
+	}
+	if len(drops) != 1 || drops[0].IPAddress != "192.0.2.0" || drops[0].Count != 5 {
+		t.Errorf("Expected the drop counters merged, got %+v", drops)
+	}
+
+	if _, err := db.AnonymizeBefore(time.Now(), nil); err == nil {
+		t.Error("Expected a raw rewrite to fail")
+	}
+}
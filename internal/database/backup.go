@@ -0,0 +1,36 @@
+package database
+
+import (
+	"fmt"
+)
+
+// BackupTo writes a consistent, compacted copy of the database to path
+// while it stays online. path must not already exist.
+func (db *DB) BackupTo(path string) error {
+	if _, err := db.conn.Exec("VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("failed to back up database: %w", err)
+	}
+	return nil
+}
+
+// CheckIntegrity runs SQLite's integrity check and reports the first problem found
+func (db *DB) CheckIntegrity() error {
+	var result string
+	if err := db.conn.QueryRow("PRAGMA integrity_check(1)").Scan(&result); err != nil {
+		return fmt.Errorf("failed to check integrity: %w", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("integrity check failed: %s", result)
+	}
+	return nil
+}
+
+// HasTable reports whether the database contains the named table
+func (db *DB) HasTable(name string) (bool, error) {
+	var n int
+	err := db.conn.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up table %s: %w", name, err)
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,125 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Ban represents a banned IP address or network
+type Ban struct {
+	CIDR      string     `json:"cidr"`
+	Reason    string     `json:"reason"`
+	Source    string     `json:"source"` // "auto" or "manual"
+	Offenses  int        `json:"offenses"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // nil = permanent
+}
+
+// Active reports whether the ban is in effect at the given time
+func (b Ban) Active(now time.Time) bool {
+	return b.ExpiresAt == nil || now.Before(*b.ExpiresAt)
+}
+
+// SaveBan inserts or replaces a ban
+func (db *DB) SaveBan(b Ban) error {
+	var expiresAt interface{}
+	if b.ExpiresAt != nil {
+		expiresAt = *b.ExpiresAt
+	}
+
+	return db.executeWithRetry(func() error {
+		_, err := db.conn.Exec(`
+			INSERT INTO ip_bans (cidr, reason, source, offenses, created_at, expires_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (cidr) DO UPDATE SET
+				reason = excluded.reason,
+				source = excluded.source,
+				offenses = excluded.offenses,
+				created_at = excluded.created_at,
+				expires_at = excluded.expires_at
+		`, b.CIDR, sanitizeInput(b.Reason, 256), b.Source, b.Offenses, b.CreatedAt, expiresAt)
+		return err
+	})
+}
+
+// DeleteBan removes a ban and reports whether it existed
+func (db *DB) DeleteBan(cidr string) (bool, error) {
+	result, err := db.conn.Exec(`DELETE FROM ip_bans WHERE cidr = ?`, cidr)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete ban: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return n > 0, nil
+}
+
+// GetBan retrieves a single ban, including expired ones, so repeat
+// offenders can be escalated
+func (db *DB) GetBan(cidr string) (*Ban, error) {
+	row := db.conn.QueryRow(`
+		SELECT cidr, reason, source, offenses, created_at, expires_at
+		FROM ip_bans WHERE cidr = ?
+	`, cidr)
+
+	b, err := scanBan(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ban: %w", err)
+	}
+	return b, nil
+}
+
+// GetBans retrieves all bans, newest first
+func (db *DB) GetBans() ([]Ban, error) {
+	rows, err := db.conn.Query(`
+		SELECT cidr, reason, source, offenses, created_at, expires_at
+		FROM ip_bans ORDER BY created_at DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query bans: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			// Ignore close errors
+		}
+	}()
+
+	var bans []Ban
+	for rows.Next() {
+		b, err := scanBan(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan ban: %w", err)
+		}
+		bans = append(bans, *b)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("bans iteration error: %w", err)
+	}
+
+	return bans, nil
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanBan scans one ip_bans row
+func scanBan(row rowScanner) (*Ban, error) {
+	var b Ban
+	var expiresAt sql.NullTime
+	if err := row.Scan(&b.CIDR, &b.Reason, &b.Source, &b.Offenses, &b.CreatedAt, &expiresAt); err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		t := expiresAt.Time
+		b.ExpiresAt = &t
+	}
+	return &b, nil
+}
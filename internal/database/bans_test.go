@@ -0,0 +1,103 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSaveAndGetBan(t *testing.T) {
+	db := setupTestDB(t)
+
+	expires := time.Now().Add(time.Hour)
+	if err := db.SaveBan(Ban{CIDR: "192.0.2.1/32", Reason: "test", Source: "manual", Offenses: 1, CreatedAt: time.Now(), ExpiresAt: &expires}); err != nil {
+		t.Fatalf("Failed to save ban: %v", err)
+	}
+	if err := db.SaveBan(Ban{CIDR: "198.51.100.0/24", Reason: "permanent", Source: "manual", Offenses: 1, CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("Failed to save ban: %v", err)
+	}
+
+	b, err := db.GetBan("192.0.2.1/32")
+	if err != nil {
+		t.Fatalf("Failed to get ban: %v", err)
+	}
+	if b == nil || b.Reason != "test" || b.ExpiresAt == nil {
+		t.Fatalf("Unexpected ban: %+v", b)
+	}
+
+	b, err = db.GetBan("198.51.100.0/24")
+	if err != nil {
+		t.Fatalf("Failed to get ban: %v", err)
+	}
+	if b == nil || b.ExpiresAt != nil {
+		t.Errorf("Expected permanent ban, got %+v", b)
+	}
+
+	missing, err := db.GetBan("203.0.113.1/32")
+	if err != nil {
+		t.Fatalf("Failed to get missing ban: %v", err)
+	}
+	if missing != nil {
+		t.Errorf("Expected nil for missing ban, got %+v", missing)
+	}
+
+	bans, err := db.GetBans()
+	if err != nil {
+		t.Fatalf("Failed to list bans: %v", err)
+	}
+	if len(bans) != 2 {
+		t.Errorf("Expected 2 bans, got %d", len(bans))
+	}
+}
+
+func TestSaveBan_Upsert(t *testing.T) {
+	db := setupTestDB(t)
+
+	if err := db.SaveBan(Ban{CIDR: "192.0.2.1/32", Source: "auto", Offenses: 1, CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("Failed to save ban: %v", err)
+	}
+	if err := db.SaveBan(Ban{CIDR: "192.0.2.1/32", Source: "auto", Offenses: 2, CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("Failed to save ban: %v", err)
+	}
+
+	b, err := db.GetBan("192.0.2.1/32")
+	if err != nil {
+		t.Fatalf("Failed to get ban: %v", err)
+	}
+	if b.Offenses != 2 {
+		t.Errorf("Expected offenses 2 after upsert, got %d", b.Offenses)
+	}
+}
+
+func TestDeleteBan(t *testing.T) {
+	db := setupTestDB(t)
+
+	if err := db.SaveBan(Ban{CIDR: "192.0.2.1/32", Source: "manual", Offenses: 1, CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("Failed to save ban: %v", err)
+	}
+
+	removed, err := db.DeleteBan("192.0.2.1/32")
+	if err != nil || !removed {
+		t.Fatalf("Expected ban to be removed, got removed=%v err=%v", removed, err)
+	}
+
+	removed, err = db.DeleteBan("192.0.2.1/32")
+	if err != nil || removed {
+		t.Errorf("Expected second delete to report not found, got removed=%v err=%v", removed, err)
+	}
+}
+
+func TestBanActive(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	future := now.Add(time.Minute)
+
+	if !(Ban{}).Active(now) {
+		t.Error("Expected permanent ban to be active")
+	}
+	if (Ban{ExpiresAt: &past}).Active(now) {
+		t.Error("Expected expired ban to be inactive")
+	}
+	if !(Ban{ExpiresAt: &future}).Active(now) {
+		t.Error("Expected unexpired ban to be active")
+	}
+}
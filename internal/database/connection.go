@@ -0,0 +1,148 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// maxPayload is the most of a connection's payload stored
+const maxPayload = 64 << 10
+
+// MaxConnectionStats is the most rows QueryConnectionStats returns
+const MaxConnectionStats = 1000
+
+// Connection is a connection to a banner listener, which imitates a
+// protocol other than HTTP
+type Connection struct {
+	Timestamp time.Time // when the client connected; zero = now
+	IPAddress string
+	Protocol  string // e.g. "ssh"
+	Listener  string // label of the listener, "" when it has none
+	LocalPort int    // port the client connected to
+	Payload   []byte // leading bytes the client sent
+}
+
+// ConnectionStats represents the connections to banner listeners with one
+// protocol, listener, port and payload
+type ConnectionStats struct {
+	Protocol    string    `json:"protocol"`
+	Listener    string    `json:"listener"`
+	Port        int       `json:"port"`
+	PayloadHash string    `json:"payload_hash"`
+	Payload     []byte    `json:"payload"` // base64 in JSON
+	Count       int64     `json:"count"`
+	UniqueIPs   int64     `json:"unique_ips"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// PayloadHash returns the hex SHA-256 digest identifying a payload
+func PayloadHash(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// LogConnection records a connection to a banner listener with the hash of
+// its payload
+func (db *DB) LogConnection(c Connection) error {
+	ipAddress, ipBin, ipFamily := storedAddress(c.IPAddress, db.anon.Load())
+	payload := c.Payload
+	if len(payload) > maxPayload {
+		payload = payload[:maxPayload]
+	}
+	if payload == nil {
+		payload = []byte{}
+	}
+	ts := c.Timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+
+	return db.executeWithRetry(func() error {
+		_, err := db.conn.Exec(`INSERT INTO tcp_connections (timestamp, ip_address, ip_bin, ip_family, protocol,
+			listener, local_port, payload, payload_hash, sensor_id)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			ts.Local(), ipAddress, ipBin, ipFamily, sanitizeInput(c.Protocol, 32),
+			sanitizeInput(c.Listener, 64), c.LocalPort, payload, PayloadHash(payload), db.sensorID)
+		return err
+	})
+}
+
+// connectionsFilter returns the WHERE clause selecting the connections
+// within f, and its arguments
+func connectionsFilter(f StatsFilter) (string, []any) {
+	var where []string
+	var args []any
+	if !f.Since.IsZero() {
+		where = append(where, "timestamp >= ?")
+		args = append(args, f.Since.Local())
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "timestamp < ?")
+		args = append(args, f.Until.Local())
+	}
+	if cond, condArgs := prefixCondition(f.IP); cond != "" {
+		where = append(where, cond)
+		args = append(args, condArgs...)
+	}
+	if f.Sensor != "" {
+		where = append(where, "sensor_id = ?")
+		args = append(args, f.Sensor)
+	}
+	if f.Listener != "" {
+		where = append(where, "listener = ?")
+		args = append(args, f.Listener)
+	}
+	if f.Port != 0 {
+		where = append(where, "local_port = ?")
+		args = append(args, f.Port)
+	}
+	return whereClause(where), args
+}
+
+// QueryConnectionStats returns the connections to banner listeners within
+// f grouped by protocol, listener, port and payload, most frequent first,
+// up to MaxConnectionStats groups
+func (db *DB) QueryConnectionStats(f StatsFilter) ([]ConnectionStats, error) {
+	where, args := connectionsFilter(f)
+	rows, err := db.conn.Query(`
+		SELECT protocol, listener, local_port, payload_hash, MAX(payload),
+			COUNT(*) AS count, COUNT(DISTINCT ip_address), MIN(timestamp), MAX(timestamp)
+		FROM tcp_connections`+where+`
+		GROUP BY protocol, listener, local_port, payload_hash
+		ORDER BY count DESC, protocol, local_port, payload_hash
+		LIMIT ?`, append(args, MaxConnectionStats)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query connection stats: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			// Ignore close errors
+		}
+	}()
+
+	var stats []ConnectionStats
+	for rows.Next() {
+		var s ConnectionStats
+		var firstSeen, lastSeen string
+		if err := rows.Scan(&s.Protocol, &s.Listener, &s.Port, &s.PayloadHash, &s.Payload,
+			&s.Count, &s.UniqueIPs, &firstSeen, &lastSeen); err != nil {
+			return nil, fmt.Errorf("failed to scan connection stats: %w", err)
+		}
+		if s.FirstSeen, err = parseTimestamp(firstSeen); err != nil {
+			return nil, fmt.Errorf("failed to parse first_seen: %w", err)
+		}
+		if s.LastSeen, err = parseTimestamp(lastSeen); err != nil {
+			return nil, fmt.Errorf("failed to parse last_seen: %w", err)
+		}
+		stats = append(stats, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("connection stats iteration error: %w", err)
+	}
+
+	return stats, nil
+}
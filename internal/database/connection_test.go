@@ -0,0 +1,101 @@
+package database
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestLogConnection(t *testing.T) {
+	db := setupTestDB(t)
+
+	ssh := []byte("SSH-2.0-Go\r\n")
+	for _, c := range []Connection{
+		{IPAddress: "192.0.2.1", Protocol: "ssh", Listener: "alt-ssh", LocalPort: 2222, Payload: ssh},
+		{IPAddress: "192.0.2.2", Protocol: "ssh", Listener: "alt-ssh", LocalPort: 2222, Payload: ssh},
+		{IPAddress: "192.0.2.2", Protocol: "ssh", Listener: "alt-ssh", LocalPort: 2222, Payload: ssh},
+		{IPAddress: "198.51.100.7", Protocol: "redis", LocalPort: 6379, Payload: []byte("*1\r\n$4\r\nPING\r\n")},
+		{IPAddress: "198.51.100.7", Protocol: "rdp", LocalPort: 3389, Timestamp: time.Now().Add(-48 * time.Hour)},
+	} {
+		if err := db.LogConnection(c); err != nil {
+			t.Fatalf("Failed to log connection: %v", err)
+		}
+	}
+
+	stats, err := db.QueryConnectionStats(StatsFilter{})
+	if err != nil {
+		t.Fatalf("Failed to query connection stats: %v", err)
+	}
+	if len(stats) != 3 {
+		t.Fatalf("Expected 3 groups, got %+v", stats)
+	}
+	if s := stats[0]; s.Protocol != "ssh" || s.Listener != "alt-ssh" || s.Port != 2222 || s.Count != 3 || s.UniqueIPs != 2 ||
+		s.PayloadHash != PayloadHash(ssh) || string(s.Payload) != string(ssh) {
+		t.Errorf("Unexpected SSH group: %+v", s)
+	}
+	// An empty payload is hashed too
+	if s := stats[1]; s.Protocol != "rdp" || len(s.Payload) != 0 || s.PayloadHash != PayloadHash(nil) {
+		t.Errorf("Unexpected RDP group: %+v", s)
+	}
+
+	filtered, err := db.QueryConnectionStats(StatsFilter{Since: time.Now().Add(-time.Hour), Port: 6379})
+	if err != nil {
+		t.Fatalf("Failed to query connection stats: %v", err)
+	}
+	if len(filtered) != 1 || filtered[0].Protocol != "redis" {
+		t.Errorf("Expected the Redis connection only, got %+v", filtered)
+	}
+
+	// Retention deletes old connections with the logs
+	if _, err := db.PurgeLogs(context.Background(), time.Now().Add(-24*time.Hour), BatchOptions{}); err != nil {
+		t.Fatalf("Failed to purge logs: %v", err)
+	}
+	if stats, err := db.QueryConnectionStats(StatsFilter{Port: 3389}); err != nil || len(stats) != 0 {
+		t.Errorf("Expected the old connection to be purged, got %+v (%v)", stats, err)
+	}
+}
+
+func TestQuerySourceStats_Connections(t *testing.T) {
+	db := setupTestDB(t)
+
+	if err := db.LogRequest("192.0.2.1", "/a"); err != nil {
+		t.Fatalf("Failed to log request: %v", err)
+	}
+	for _, ip := range []string{"192.0.2.1", "192.0.2.1", "198.51.100.9"} {
+		if err := db.LogConnection(Connection{IPAddress: ip, Protocol: "telnet", LocalPort: 23}); err != nil {
+			t.Fatalf("Failed to log connection: %v", err)
+		}
+	}
+
+	stats, err := db.QuerySourceStats(StatsFilter{})
+	if err != nil {
+		t.Fatalf("Failed to query source stats: %v", err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("Expected 2 sources, got %+v", stats)
+	}
+	if s := stats[0]; s.IPAddress != "192.0.2.1" || s.Count != 1 || s.Connections != 2 {
+		t.Errorf("Unexpected first source: %+v", s)
+	}
+	// An address that only connected to a banner listener still shows up
+	if s := stats[1]; s.IPAddress != "198.51.100.9" || s.Count != 0 || s.Connections != 1 || s.FirstSeen.IsZero() {
+		t.Errorf("Unexpected second source: %+v", s)
+	}
+
+	stats, err = db.QuerySourceStats(StatsFilter{IP: netip.MustParsePrefix("198.51.100.0/24")})
+	if err != nil {
+		t.Fatalf("Failed to query source stats: %v", err)
+	}
+	if len(stats) != 1 || stats[0].IPAddress != "198.51.100.9" {
+		t.Errorf("Expected the filtered source only, got %+v", stats)
+	}
+
+	prefixes, err := db.QueryPrefixStats(StatsFilter{}, 24, 0)
+	if err != nil {
+		t.Fatalf("Failed to query prefix stats: %v", err)
+	}
+	if len(prefixes) != 2 || prefixes[0].IPAddress != "192.0.2.0/24" || prefixes[0].Connections != 2 || prefixes[1].Connections != 1 {
+		t.Errorf("Unexpected prefix stats: %+v", prefixes)
+	}
+}
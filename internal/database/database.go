@@ -1,17 +1,24 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/dangogh/silver-eureka/internal/anonymize"
+	"github.com/dangogh/silver-eureka/internal/metrics"
+	"github.com/dangogh/silver-eureka/internal/telemetry"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 // DB wraps the sql.DB connection
 type DB struct {
-	conn *sql.DB
+	conn     *sql.DB
+	anon     atomic.Pointer[anonymize.Anonymizer]
+	sensorID string // tags the requests logged here, see SetSensorID
 }
 
 // RequestLog represents a logged HTTP request
@@ -20,6 +27,10 @@ type RequestLog struct {
 	IPAddress string
 	URL       string
 	Timestamp time.Time
+	Sensor    string // ID of the sensor that captured it, "" when untagged
+	// Details is what was captured beyond the address and URL, loaded only
+	// when LogFilter.Details is set
+	Details *RequestDetails `json:",omitempty"`
 }
 
 // EndpointStats represents statistics for a specific endpoint
@@ -28,16 +39,24 @@ type EndpointStats struct {
 	Count     int64     `json:"count"`
 	FirstSeen time.Time `json:"first_seen"`
 	LastSeen  time.Time `json:"last_seen"`
-	UniqueIPs int64     `json:"unique_ips"`
+	// UniqueIPs counts distinct addresses, exactly while the raw logs
+	// cover the range; before that an address is counted once per rollup
+	// bucket it was seen in
+	UniqueIPs int64 `json:"unique_ips"`
 }
 
 // SourceStats represents statistics for a specific IP address
 type SourceStats struct {
-	IPAddress  string    `json:"ip_address"`
-	Count      int64     `json:"count"`
-	FirstSeen  time.Time `json:"first_seen"`
-	LastSeen   time.Time `json:"last_seen"`
-	UniqueURLs int64     `json:"unique_urls"`
+	IPAddress string    `json:"ip_address"`
+	Count     int64     `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	// UniqueURLs counts distinct URLs like EndpointStats.UniqueIPs
+	UniqueURLs int64 `json:"unique_urls"`
+	// RateLimited counts requests from this IP rejected by the rate limiter
+	RateLimited int64 `json:"rate_limited"`
+	// Connections counts this IP's connections to banner listeners
+	Connections int64 `json:"connections"`
 }
 
 // Summary represents overall statistics
@@ -47,10 +66,58 @@ type Summary struct {
 	UniqueURLs    int64     `json:"unique_urls"`
 	FirstRequest  time.Time `json:"first_request"`
 	LastRequest   time.Time `json:"last_request"`
+	// IPModes lists how the addresses counted were anonymized
+	IPModes []string `json:"ip_modes"`
+}
+
+// SensorStats represents statistics for the requests captured by one
+// sensor
+type SensorStats struct {
+	Sensor     string    `json:"sensor"`
+	Count      int64     `json:"count"`
+	FirstSeen  time.Time `json:"first_seen"`
+	LastSeen   time.Time `json:"last_seen"`
+	UniqueIPs  int64     `json:"unique_ips"`
+	UniqueURLs int64     `json:"unique_urls"`
 }
 
-// New creates a new database connection and initializes the schema
+// ListenerStats represents statistics for the requests that arrived on one
+// listener and port
+type ListenerStats struct {
+	Listener   string    `json:"listener"`
+	Port       int       `json:"port"`
+	Count      int64     `json:"count"`
+	FirstSeen  time.Time `json:"first_seen"`
+	LastSeen   time.Time `json:"last_seen"`
+	UniqueIPs  int64     `json:"unique_ips"`
+	UniqueURLs int64     `json:"unique_urls"`
+}
+
+// New opens the database and applies any pending schema migrations
 func New(dbPath string) (*DB, error) {
+	db, err := Open(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Migrate(); err != nil {
+		if closeErr := db.conn.Close(); closeErr != nil {
+			// Log but don't mask the original error
+		}
+		return nil, fmt.Errorf("failed to initialize schema: %w", err)
+	}
+	if err := db.checkSearchIndex(); err != nil {
+		if closeErr := db.conn.Close(); closeErr != nil {
+			// Log but don't mask the original error
+		}
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Open opens the database without touching the schema
+func Open(dbPath string) (*DB, error) {
 	conn, err := sql.Open("sqlite3", dbPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
@@ -65,22 +132,23 @@ func New(dbPath string) (*DB, error) {
 	}
 
 	db := &DB{conn: conn}
-
-	// Initialize schema
-	if err := db.initSchema(); err != nil {
+	if err := db.configure(); err != nil {
 		if closeErr := conn.Close(); closeErr != nil {
 			// Log but don't mask the original error
 		}
-		return nil, fmt.Errorf("failed to initialize schema: %w", err)
+		return nil, err
 	}
 
 	return db, nil
 }
 
-// initSchema creates the necessary tables if they don't exist
-func (db *DB) initSchema() error {
+// configure sets the connection pragmas and pool limits
+func (db *DB) configure() error {
 	// Configure SQLite for better performance and concurrency
+	// auto_vacuum only takes effect before the first table is created;
+	// migration 12 converts databases created without it
 	pragmas := `
+	PRAGMA auto_vacuum = INCREMENTAL;
 	PRAGMA journal_mode = WAL;
 	PRAGMA synchronous = NORMAL;
 	PRAGMA cache_size = -64000;
@@ -98,33 +166,58 @@ func (db *DB) initSchema() error {
 	db.conn.SetConnMaxLifetime(0)               // Connections don't expire
 	db.conn.SetConnMaxIdleTime(time.Minute * 5) // Close idle connections after 5 min
 
-	query := `
-	CREATE TABLE IF NOT EXISTS request_logs (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		ip_address TEXT NOT NULL,
-		url TEXT NOT NULL,
-		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-	CREATE INDEX IF NOT EXISTS idx_timestamp ON request_logs(timestamp);
-	CREATE INDEX IF NOT EXISTS idx_ip_address ON request_logs(ip_address);
-	CREATE INDEX IF NOT EXISTS idx_url ON request_logs(url);
-	`
-
-	_, err := db.conn.Exec(query)
-	return err
+	return nil
 }
 
 // LogRequest logs an HTTP request to the database with retry logic
-func (db *DB) LogRequest(ipAddress, url string) error { // Sanitize inputs to prevent log injection and data issues
-	ipAddress = sanitizeInput(ipAddress, 45) // Max IPv6 length
-	url = sanitizeInput(url, 2048)           // Max URL length
+func (db *DB) LogRequest(ipAddress, url string) error {
+	return db.LogRequestDetails(ipAddress, url, RequestDetails{})
+}
+
+// LogRequestDetails logs an HTTP request with its user agent, headers and
+// body, as far as they were captured
+func (db *DB) LogRequestDetails(ipAddress, url string, d RequestDetails) error {
+	return db.LogRequestContext(context.Background(), ipAddress, url, d)
+}
+
+// LogRequestContext is LogRequestDetails, traced as a child of the span in
+// ctx
+func (db *DB) LogRequestContext(ctx context.Context, ipAddress, url string, d RequestDetails) error {
+	_, span := telemetry.StartSpan(ctx, "INSERT request_logs", telemetry.SpanClient,
+		telemetry.String("db.system.name", "sqlite"),
+		telemetry.String("db.operation.name", "INSERT"),
+		telemetry.String("db.collection.name", "request_logs"),
+	)
+	defer span.End()
+
+	// Sanitize inputs to prevent log injection and data issues
+	anon := db.anon.Load()
+	ipAddress, ipBin, ipFamily := storedAddress(ipAddress, anon)
+	url = sanitizeInput(url, 2048) // Max URL length
+	parts := splitURL(url)
+	userAgent := sanitizeInput(d.UserAgent, 1024)
+	headers := formatHeaders(d.Headers, anon.Mode() != anonymize.Raw)
+	body := bodyText(d.Body)
+	listener := sanitizeInput(d.Listener, 64)
 
 	// Execute with retry logic
-	return db.executeWithRetry(func() error {
-		query := `INSERT INTO request_logs (ip_address, url, timestamp) VALUES (?, ?, ?)`
-		_, err := db.conn.Exec(query, ipAddress, url, time.Now())
+	start := time.Now()
+	err := db.executeWithRetry(func() error {
+		query := `INSERT INTO request_logs (ip_address, ip_bin, ip_family, url, path, query, params, norm_path,
+			user_agent, headers, body, sensor_id, listener, local_port, timestamp)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		_, err := db.conn.Exec(query, ipAddress, ipBin, ipFamily, url, parts.path, parts.query, parts.params, parts.normPath,
+			userAgent, headers, body, db.sensorID, listener, d.LocalPort, time.Now())
 		return err
 	})
+	metrics.DBInsertDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.RequestsLogged.WithLabelValues("error").Inc()
+		span.RecordError(err)
+		return err
+	}
+	metrics.RequestsLogged.WithLabelValues("ok").Inc()
+	return nil
 }
 
 // executeWithRetry executes a database operation with exponential backoff retry logic
@@ -140,15 +233,18 @@ func (db *DB) executeWithRetry(operation func() error) error {
 		if !isRetryableError(err) {
 			return fmt.Errorf("failed to execute operation: %w", err)
 		}
+		metrics.DBBusy.Inc()
 
 		// Don't sleep on the last attempt
 		if attempt < maxRetries {
+			metrics.DBRetries.Inc()
 			// Exponential backoff: 10ms, 20ms, 40ms
 			backoff := time.Millisecond * time.Duration(10*(1<<uint(attempt)))
 			time.Sleep(backoff)
 		}
 	}
 
+	metrics.DBRetryExhausted.Inc()
 	return fmt.Errorf("failed to execute operation after %d retries", maxRetries)
 }
 
@@ -189,10 +285,10 @@ func (db *DB) GetLogs(limit int) ([]RequestLog, error) {
 	var err error
 
 	if limit > 0 {
-		query = `SELECT id, ip_address, url, timestamp FROM request_logs ORDER BY timestamp DESC LIMIT ?`
+		query = `SELECT id, ip_address, url, timestamp, sensor_id FROM request_logs ORDER BY timestamp DESC LIMIT ?`
 		rows, err = db.conn.Query(query, limit)
 	} else {
-		query = `SELECT id, ip_address, url, timestamp FROM request_logs ORDER BY timestamp DESC`
+		query = `SELECT id, ip_address, url, timestamp, sensor_id FROM request_logs ORDER BY timestamp DESC`
 		rows, err = db.conn.Query(query)
 	}
 
@@ -208,7 +304,7 @@ func (db *DB) GetLogs(limit int) ([]RequestLog, error) {
 	var logs []RequestLog
 	for rows.Next() {
 		var log RequestLog
-		if err := rows.Scan(&log.ID, &log.IPAddress, &log.URL, &log.Timestamp); err != nil {
+		if err := rows.Scan(&log.ID, &log.IPAddress, &log.URL, &log.Timestamp, &log.Sensor); err != nil {
 			return nil, fmt.Errorf("failed to scan row: %w", err)
 		}
 		logs = append(logs, log)
@@ -221,11 +317,13 @@ func (db *DB) GetLogs(limit int) ([]RequestLog, error) {
 	return logs, nil
 }
 
+// MaxDownloadLogs limits the logs returned at once to prevent memory
+// exhaustion. For larger exports, implement pagination or streaming.
+const MaxDownloadLogs = 100000
+
 // GetAllLogs retrieves all request logs from the database with a safety limit
 func (db *DB) GetAllLogs() ([]RequestLog, error) {
-	// Limit to 100k records to prevent memory exhaustion
-	// For larger exports, implement pagination or streaming
-	return db.GetLogs(100000)
+	return db.GetLogs(MaxDownloadLogs)
 }
 
 // Close closes the database connection
@@ -246,150 +344,28 @@ func (db *DB) Ping() error {
 
 // GetEndpointStats retrieves statistics grouped by endpoint/URL
 func (db *DB) GetEndpointStats() ([]EndpointStats, error) {
-	query := `
-		SELECT 
-			url,
-			COUNT(*) as count,
-			MIN(timestamp) as first_seen,
-			MAX(timestamp) as last_seen,
-			COUNT(DISTINCT ip_address) as unique_ips
-		FROM request_logs
-		GROUP BY url
-		ORDER BY count DESC
-	`
-
-	rows, err := db.conn.Query(query)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query endpoint stats: %w", err)
-	}
-	defer func() {
-		if err := rows.Close(); err != nil {
-			// Ignore close errors
-		}
-	}()
-
-	var stats []EndpointStats
-	for rows.Next() {
-		var s EndpointStats
-		var firstSeen, lastSeen string
-		if err := rows.Scan(&s.URL, &s.Count, &firstSeen, &lastSeen, &s.UniqueIPs); err != nil {
-			return nil, fmt.Errorf("failed to scan endpoint stats: %w", err)
-		}
-		// Parse timestamps
-		if s.FirstSeen, err = time.Parse("2006-01-02 15:04:05.999999999-07:00", firstSeen); err != nil {
-			if s.FirstSeen, err = time.Parse("2006-01-02 15:04:05", firstSeen); err != nil {
-				return nil, fmt.Errorf("failed to parse first_seen: %w", err)
-			}
-		}
-		if s.LastSeen, err = time.Parse("2006-01-02 15:04:05.999999999-07:00", lastSeen); err != nil {
-			if s.LastSeen, err = time.Parse("2006-01-02 15:04:05", lastSeen); err != nil {
-				return nil, fmt.Errorf("failed to parse last_seen: %w", err)
-			}
-		}
-		stats = append(stats, s)
-	}
-
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("endpoint stats iteration error: %w", err)
-	}
-
-	return stats, nil
+	return db.QueryEndpointStats(StatsFilter{})
 }
 
-// GetSourceStats retrieves statistics grouped by IP address
+// GetSourceStats retrieves statistics grouped by IP address, including
+// IPs that were only ever rejected by the rate limiter
 func (db *DB) GetSourceStats() ([]SourceStats, error) {
-	query := `
-		SELECT 
-			ip_address,
-			COUNT(*) as count,
-			MIN(timestamp) as first_seen,
-			MAX(timestamp) as last_seen,
-			COUNT(DISTINCT url) as unique_urls
-		FROM request_logs
-		GROUP BY ip_address
-		ORDER BY count DESC
-	`
+	return db.QuerySourceStats(StatsFilter{})
+}
 
-	rows, err := db.conn.Query(query)
+// parseTimestamp parses a timestamp as stored by the sqlite3 driver,
+// falling back to SQLite's CURRENT_TIMESTAMP format
+func parseTimestamp(value string) (time.Time, error) {
+	t, err := time.Parse("2006-01-02 15:04:05.999999999-07:00", value)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query source stats: %w", err)
+		t, err = time.Parse("2006-01-02 15:04:05", value)
 	}
-	defer func() {
-		if err := rows.Close(); err != nil {
-			// Ignore close errors
-		}
-	}()
-
-	var stats []SourceStats
-	for rows.Next() {
-		var s SourceStats
-		var firstSeen, lastSeen string
-		if err := rows.Scan(&s.IPAddress, &s.Count, &firstSeen, &lastSeen, &s.UniqueURLs); err != nil {
-			return nil, fmt.Errorf("failed to scan source stats: %w", err)
-		}
-		// Parse timestamps
-		if s.FirstSeen, err = time.Parse("2006-01-02 15:04:05.999999999-07:00", firstSeen); err != nil {
-			if s.FirstSeen, err = time.Parse("2006-01-02 15:04:05", firstSeen); err != nil {
-				return nil, fmt.Errorf("failed to parse first_seen: %w", err)
-			}
-		}
-		if s.LastSeen, err = time.Parse("2006-01-02 15:04:05.999999999-07:00", lastSeen); err != nil {
-			if s.LastSeen, err = time.Parse("2006-01-02 15:04:05", lastSeen); err != nil {
-				return nil, fmt.Errorf("failed to parse last_seen: %w", err)
-			}
-		}
-		stats = append(stats, s)
-	}
-
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("source stats iteration error: %w", err)
-	}
-
-	return stats, nil
+	return t, err
 }
 
 // GetSummary retrieves overall statistics
 func (db *DB) GetSummary() (*Summary, error) {
-	query := `
-		SELECT 
-			COUNT(*) as total_requests,
-			COUNT(DISTINCT ip_address) as unique_ips,
-			COUNT(DISTINCT url) as unique_urls,
-			MIN(timestamp) as first_request,
-			MAX(timestamp) as last_request
-		FROM request_logs
-	`
-
-	var summary Summary
-	var firstRequest, lastRequest sql.NullString
-	err := db.conn.QueryRow(query).Scan(
-		&summary.TotalRequests,
-		&summary.UniqueIPs,
-		&summary.UniqueURLs,
-		&firstRequest,
-		&lastRequest,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query summary stats: %w", err)
-	}
-
-	// Parse timestamps if they exist (not NULL)
-	if firstRequest.Valid {
-		if summary.FirstRequest, err = time.Parse("2006-01-02 15:04:05.999999999-07:00", firstRequest.String); err != nil {
-			if summary.FirstRequest, err = time.Parse("2006-01-02 15:04:05", firstRequest.String); err != nil {
-				return nil, fmt.Errorf("failed to parse first_request: %w", err)
-			}
-		}
-	}
-	if lastRequest.Valid {
-		if summary.LastRequest, err = time.Parse("2006-01-02 15:04:05.999999999-07:00", lastRequest.String); err != nil {
-			if summary.LastRequest, err = time.Parse("2006-01-02 15:04:05", lastRequest.String); err != nil {
-				return nil, fmt.Errorf("failed to parse last_request: %w", err)
-			}
-		}
-	}
-
-	return &summary, nil
+	return db.QuerySummary(StatsFilter{})
 }
 
 // CleanupOldLogs deletes logs older than retentionDays and returns the number deleted
@@ -402,27 +378,17 @@ func (db *DB) CleanupOldLogs(retentionDays int) (int64, error) {
 	// Calculate cutoff timestamp
 	cutoff := time.Now().AddDate(0, 0, -retentionDays)
 
-	// Delete old logs
-	result, err := db.conn.Exec(
-		"DELETE FROM request_logs WHERE timestamp < ?",
-		cutoff,
-	)
-	if err != nil {
-		return 0, fmt.Errorf("failed to cleanup old logs: %w", err)
-	}
-
-	deleted, err := result.RowsAffected()
+	// Delete old logs; rate limit drop counters follow the same retention
+	deleted, err := db.PurgeBefore(cutoff)
 	if err != nil {
-		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+		return deleted, fmt.Errorf("failed to cleanup old logs: %w", err)
 	}
 
-	// Run VACUUM to reclaim disk space if we deleted records
-	// VACUUM can be slow, but we run cleanup off-peak
+	// Return the freed pages in small steps rather than with a blocking VACUUM
 	if deleted > 0 {
-		if _, err := db.conn.Exec("VACUUM"); err != nil {
-			// Log warning but don't fail - VACUUM is optimization
+		if _, err := db.IncrementalVacuum(context.Background(), BatchOptions{}); err != nil {
 			// The deletion already succeeded
-			return deleted, fmt.Errorf("cleanup succeeded but VACUUM failed: %w", err)
+			return deleted, fmt.Errorf("cleanup succeeded but vacuum failed: %w", err)
 		}
 	}
 
@@ -0,0 +1,175 @@
+package database
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/anonymize"
+)
+
+// insertDetailedLog stores a request with its captured details, sensor and
+// listener
+const insertDetailedLog = `INSERT INTO request_logs (ip_address, ip_bin, ip_family, url, path, query, params, norm_path,
+	user_agent, headers, body, sensor_id, listener, local_port, timestamp)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+// ImportRecord is a request read from an access log or an export
+type ImportRecord struct {
+	Timestamp time.Time // zero = now
+	IPAddress string
+	URL       string
+	Sensor    string
+	Details   RequestDetails
+}
+
+// ImportOptions controls how an Import stores records
+type ImportOptions struct {
+	DryRun bool // count what would be imported without writing it
+}
+
+// importWindow is how far back from the newest record an Import remembers
+// the records it has seen, once it remembers importWindowKeys of them
+const (
+	importWindow     = time.Hour
+	importWindowKeys = 100_000
+)
+
+// Import adds records to the request logs in batches, skipping those
+// already stored. A record duplicates a stored request with the same
+// timestamp, address, URL and sensor. Identical records are told apart by
+// count: the n-th is skipped only when n such requests were stored before
+// the import began, so importing a log twice adds nothing the second time
+// while repeated requests within the same second are all kept. Records are
+// expected roughly in time order; an identical record more than an hour
+// older than the newest one seen may be skipped once the import has seen
+// many others. Addresses are stored as the server would store them;
+// pseudonyms and truncated addresses in exports are left as they are.
+type Import struct {
+	db   *DB
+	opts ImportOptions
+	anon *anonymize.Anonymizer // as set when the import started
+
+	seen   map[[16]byte]*importCount
+	newest int64
+}
+
+// rowQuerier is a database or transaction
+type rowQuerier interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// importCount tracks the records of one key
+type importCount struct {
+	ts     int64 // Unix nanoseconds
+	seen   int64 // records so far
+	stored int64 // requests stored before the import
+}
+
+// NewImport starts an import
+func (db *DB) NewImport(opts ImportOptions) *Import {
+	return &Import{db: db, opts: opts, anon: db.anon.Load(), seen: make(map[[16]byte]*importCount)}
+}
+
+// Add stores records in a single transaction, or only counts them in a
+// dry run, and returns the number imported and skipped as duplicates
+func (im *Import) Add(records []ImportRecord) (imported, duplicates int64, err error) {
+	var q rowQuerier = im.db.conn
+	var tx *sql.Tx
+	var stmt *sql.Stmt
+	if !im.opts.DryRun {
+		tx, err = im.db.conn.Begin()
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer func() {
+			if err := tx.Rollback(); err != nil {
+				// Already committed
+			}
+		}()
+		stmt, err = tx.Prepare(insertDetailedLog)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to prepare insert: %w", err)
+		}
+		defer func() {
+			if err := stmt.Close(); err != nil {
+				// Ignore close errors
+			}
+		}()
+		q = tx
+	}
+
+	now := time.Now()
+	for _, rec := range records {
+		ts := rec.Timestamp
+		if ts.IsZero() {
+			ts = now
+		}
+		ip, ipBin, ipFamily := storedAddress(rec.IPAddress, im.anon)
+		url := sanitizeInput(rec.URL, 2048)
+		sensor := sanitizeInput(rec.Sensor, 64)
+
+		count, err := im.count(q, ts, ip, url, sensor)
+		if err != nil {
+			return 0, 0, err
+		}
+		count.seen++
+		if count.seen <= count.stored {
+			duplicates++
+			continue
+		}
+		if stmt != nil {
+			parts := splitURL(url)
+			if _, err := stmt.Exec(ip, ipBin, ipFamily, url, parts.path, parts.query, parts.params, parts.normPath,
+				sanitizeInput(rec.Details.UserAgent, 1024), formatHeaders(rec.Details.Headers, im.anon.Mode() != anonymize.Raw),
+				bodyText(rec.Details.Body), sensor, sanitizeInput(rec.Details.Listener, 64), rec.Details.LocalPort, ts.Local()); err != nil {
+				return 0, 0, fmt.Errorf("failed to import log: %w", err)
+			}
+		}
+		imported++
+	}
+
+	if tx != nil {
+		if err := tx.Commit(); err != nil {
+			return 0, 0, fmt.Errorf("failed to commit import: %w", err)
+		}
+	}
+	im.prune()
+	return imported, duplicates, nil
+}
+
+// count returns the tally of the records like this one, looking up the
+// matching stored requests the first time
+func (im *Import) count(q rowQuerier, ts time.Time, ip, url, sensor string) (*importCount, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\x00%s\x00%s\x00%s", ts.UnixNano(), ip, url, sensor)
+	var key [16]byte
+	copy(key[:], h.Sum(nil))
+	if c, ok := im.seen[key]; ok {
+		return c, nil
+	}
+
+	c := &importCount{ts: ts.UnixNano()}
+	if err := q.QueryRow(`SELECT COUNT(*) FROM request_logs
+		WHERE timestamp = ? AND ip_address = ? AND url = ? AND sensor_id = ?`,
+		ts.Local(), ip, url, sensor).Scan(&c.stored); err != nil {
+		return nil, fmt.Errorf("failed to look up duplicates: %w", err)
+	}
+	im.seen[key] = c
+	im.newest = max(im.newest, c.ts)
+	return c, nil
+}
+
+// prune forgets records well before the newest once there are many
+func (im *Import) prune() {
+	if len(im.seen) < importWindowKeys {
+		return
+	}
+	cutoff := im.newest - int64(importWindow)
+	for key, c := range im.seen {
+		if c.ts < cutoff {
+			delete(im.seen, key)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/dangogh/silver-eureka/internal/anonymize"
+	"github.com/dangogh/silver-eureka/internal/metrics"
+)
+
+// Client addresses are stored as text and, when they are IP addresses, as
+// a 16-byte key in ip_bin: IPv6 addresses as they are and IPv4 addresses
+// mapped into ::ffff:0:0/96. Keys compare like the addresses, so a CIDR
+// prefix is a range of keys. ip_family is 4 or 6 for the address seen,
+// and stays set when it is stored as an HMAC pseudonym, which has no key.
+// Values that are not addresses, such as garbage from a forwarded header,
+// have neither.
+
+// parseIP parses an address as stored: IPv4-mapped addresses are unmapped
+// and zones dropped. An address with a port, as some proxies forward it,
+// is accepted too.
+func parseIP(ip string) (netip.Addr, bool) {
+	ip = strings.TrimSpace(ip)
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		addrPort, portErr := netip.ParseAddrPort(ip)
+		if portErr != nil {
+			return netip.Addr{}, false
+		}
+		addr = addrPort.Addr()
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+// ipKey returns the ip_bin key of addr
+func ipKey(addr netip.Addr) []byte {
+	key := addr.As16()
+	return key[:]
+}
+
+// ipFamily returns the ip_family of addr
+func ipFamily(addr netip.Addr) int {
+	if addr.Unmap().Is4() {
+		return 4
+	}
+	return 6
+}
+
+// storedAddress returns the ip_address, ip_bin and ip_family to store for
+// a client address, anonymized with a
+func storedAddress(ip string, a *anonymize.Anonymizer) (string, []byte, any) {
+	ip = sanitizeInput(ip, 45) // Max IPv6 length
+	addr, ok := parseIP(ip)
+	if !ok {
+		metrics.InvalidIPs.Inc()
+		return ip, nil, nil
+	}
+	text := a.Anonymize(addr.String())
+	return text, storedKey(text), ipFamily(addr)
+}
+
+// storedKey returns the ip_bin key of a stored address, or nil when it is
+// not an IP address
+func storedKey(ip string) []byte {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil
+	}
+	return ipKey(addr.Unmap())
+}
+
+// ParseIPFilter parses an address or CIDR prefix filter. A single address
+// is the prefix holding only that address.
+func ParseIPFilter(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid IP filter %q: %w", s, err)
+		}
+		return p.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP filter %q: %w", s, err)
+	}
+	addr = addr.Unmap().WithZone("")
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// keyRange returns the first and last ip_bin keys within p
+func keyRange(p netip.Prefix) ([]byte, []byte) {
+	p = p.Masked()
+	bits := p.Bits()
+	if p.Addr().Is4() {
+		bits += 96
+	}
+	lo := p.Addr().As16()
+	hi := lo
+	for i := bits; i < 128; i++ {
+		hi[i/8] |= 0x80 >> (i % 8)
+	}
+	return lo[:], hi[:]
+}
+
+// prefixCondition returns the condition selecting rows whose address is
+// within p, or "" for the zero prefix
+func prefixCondition(p netip.Prefix) (string, []any) {
+	if !p.IsValid() {
+		return "", nil
+	}
+	lo, hi := keyRange(p)
+	return "ip_bin BETWEEN ? AND ?", []any{lo, hi}
+}
+
+// backfillIPKeys sets ip_bin and ip_family for the addresses stored before
+// they existed. SQLite can't parse addresses, so the keys are computed
+// here and joined back in through a temporary table.
+func backfillIPKeys(tx *sql.Tx) error {
+	tables := []string{"request_logs", "rollup_hourly", "rollup_daily", "rate_limit_drops"}
+	var selects []string
+	for _, table := range tables {
+		selects = append(selects, "SELECT ip_address FROM "+table)
+	}
+	rows, err := tx.Query(strings.Join(selects, " UNION "))
+	if err != nil {
+		return fmt.Errorf("failed to query addresses: %w", err)
+	}
+	var ips []string
+	for rows.Next() {
+		var ip string
+		if err := rows.Scan(&ip); err != nil {
+			if closeErr := rows.Close(); closeErr != nil {
+				// Ignore close errors
+			}
+			return fmt.Errorf("failed to scan address: %w", err)
+		}
+		ips = append(ips, ip)
+	}
+	if err := rows.Close(); err != nil {
+		return fmt.Errorf("failed to read addresses: %w", err)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("address iteration error: %w", err)
+	}
+
+	if _, err := tx.Exec("CREATE TEMP TABLE ip_keys (ip_address TEXT PRIMARY KEY, ip_bin BLOB, ip_family INTEGER)"); err != nil {
+		return fmt.Errorf("failed to create key table: %w", err)
+	}
+	for _, ip := range ips {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			continue
+		}
+		if _, err := tx.Exec("INSERT INTO ip_keys VALUES (?, ?, ?)", ip, ipKey(addr.Unmap()), ipFamily(addr)); err != nil {
+			return fmt.Errorf("failed to store key: %w", err)
+		}
+	}
+	for _, table := range tables {
+		if _, err := tx.Exec(`UPDATE ` + table + ` SET ip_bin = k.ip_bin, ip_family = k.ip_family
+			FROM ip_keys k WHERE k.ip_address = ` + table + `.ip_address`); err != nil {
+			return fmt.Errorf("failed to backfill %s: %w", table, err)
+		}
+	}
+	if _, err := tx.Exec("DROP TABLE temp.ip_keys"); err != nil {
+		return fmt.Errorf("failed to drop key table: %w", err)
+	}
+	return nil
+}
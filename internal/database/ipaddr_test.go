@@ -0,0 +1,132 @@
+package database
+
+import (
+	"bytes"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestLogRequest_NormalizesIP(t *testing.T) {
+	db := setupTestDB(t)
+	for _, ip := range []string{"::ffff:192.0.2.5", "192.0.2.6:4431", " 2001:DB8::1 ", "fe80::1%eth0", "<script>"} {
+		if err := db.LogRequest(ip, "/"); err != nil {
+			t.Fatalf("Failed to log request: %v", err)
+		}
+	}
+
+	rows, err := db.conn.Query("SELECT ip_address, ip_bin, ip_family FROM request_logs ORDER BY id")
+	if err != nil {
+		t.Fatalf("Failed to query logs: %v", err)
+	}
+	defer func() {
+		_ = rows.Close() //nolint:errcheck // Test cleanup
+	}()
+	want := []struct {
+		ip     string
+		family int64
+	}{
+		{"192.0.2.5", 4},
+		{"192.0.2.6", 4},
+		{"2001:db8::1", 6},
+		{"fe80::1", 6},
+		{"<script>", 0},
+	}
+	for i := 0; rows.Next(); i++ {
+		var ip string
+		var key []byte
+		var family *int64
+		if err := rows.Scan(&ip, &key, &family); err != nil {
+			t.Fatalf("Failed to scan log: %v", err)
+		}
+		if ip != want[i].ip {
+			t.Errorf("Log %d: expected address %q, got %q", i, want[i].ip, ip)
+		}
+		if want[i].family == 0 {
+			if key != nil || family != nil {
+				t.Errorf("Log %d: expected no key or family for %q", i, ip)
+			}
+			continue
+		}
+		if family == nil || *family != want[i].family {
+			t.Errorf("Log %d: expected family %d, got %v", i, want[i].family, family)
+		}
+		if !bytes.Equal(key, ipKey(netip.MustParseAddr(want[i].ip))) {
+			t.Errorf("Log %d: unexpected key %x", i, key)
+		}
+	}
+}
+
+func TestKeyRange(t *testing.T) {
+	tests := []struct {
+		prefix, lo, hi string
+	}{
+		{"185.220.0.0/16", "185.220.0.0", "185.220.255.255"},
+		{"10.1.2.3/12", "10.0.0.0", "10.15.255.255"},
+		{"192.0.2.1/32", "192.0.2.1", "192.0.2.1"},
+		{"2001:db8::/32", "2001:db8::", "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff"},
+	}
+	for _, tt := range tests {
+		lo, hi := keyRange(netip.MustParsePrefix(tt.prefix))
+		if !bytes.Equal(lo, ipKey(netip.MustParseAddr(tt.lo))) || !bytes.Equal(hi, ipKey(netip.MustParseAddr(tt.hi))) {
+			t.Errorf("keyRange(%s) = %x-%x, want %s-%s", tt.prefix, lo, hi, tt.lo, tt.hi)
+		}
+	}
+}
+
+func TestQueryPrefixStats(t *testing.T) {
+	db := setupTestDB(t)
+	base := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	insertLog(t, db, "185.220.101.1", "/a", base)
+	insertLog(t, db, "185.220.101.2", "/a", base.Add(time.Minute))
+	insertLog(t, db, "185.220.101.2", "/b", base.Add(2*time.Minute))
+	insertLog(t, db, "185.220.102.1", "/a", base.Add(3*time.Minute))
+	insertLog(t, db, "2001:db8:1:2::1", "/a", base)
+	insertLog(t, db, "2001:db8:1:3::1", "/c", base)
+	insertLog(t, db, "h:0123abcd:00112233aabbccdd", "/a", base)
+	if err := db.RecordRateLimitDrops([]RateLimitDrop{
+		{IPAddress: "185.220.101.9", Minute: base, RouteGroup: "catchall", Scope: "per_ip", Count: 4},
+	}); err != nil {
+		t.Fatalf("Failed to record drops: %v", err)
+	}
+
+	stats, err := db.QueryPrefixStats(StatsFilter{}, 24, 48)
+	if err != nil {
+		t.Fatalf("QueryPrefixStats failed: %v", err)
+	}
+	want := []SourceStats{
+		{IPAddress: "185.220.101.0/24", Count: 3, UniqueURLs: 2, RateLimited: 4},
+		{IPAddress: "2001:db8:1::/48", Count: 2, UniqueURLs: 2},
+		{IPAddress: "185.220.102.0/24", Count: 1, UniqueURLs: 1},
+		{IPAddress: "h:0123abcd:00112233aabbccdd", Count: 1, UniqueURLs: 1},
+	}
+	if len(stats) != len(want) {
+		t.Fatalf("Expected %d groups, got %+v", len(want), stats)
+	}
+	for i, s := range stats {
+		if s.IPAddress != want[i].IPAddress || s.Count != want[i].Count || s.UniqueURLs != want[i].UniqueURLs || s.RateLimited != want[i].RateLimited {
+			t.Errorf("Group %d: expected %+v, got %+v", i, want[i], s)
+		}
+	}
+
+	// A prefix filter applies to the rollups and drop counters too
+	filter := StatsFilter{IP: netip.MustParsePrefix("185.220.0.0/16")}
+	sources, err := db.QuerySourceStats(filter)
+	if err != nil {
+		t.Fatalf("QuerySourceStats failed: %v", err)
+	}
+	if len(sources) != 4 {
+		t.Errorf("Expected the four addresses within the prefix, got %+v", sources)
+	}
+	summary, err := db.QuerySummary(filter)
+	if err != nil {
+		t.Fatalf("QuerySummary failed: %v", err)
+	}
+	if summary.TotalRequests != 4 || summary.UniqueIPs != 3 {
+		t.Errorf("Expected 4 requests from 3 addresses, got %+v", summary)
+	}
+
+	if _, err := db.QueryPrefixStats(StatsFilter{}, 33, 48); err == nil {
+		t.Error("Expected an out of range prefix length to fail")
+	}
+}
@@ -0,0 +1,253 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Long deletes and space reclamation run in bounded steps, each its own
+// short transaction, so LogRequest only ever waits for one step rather
+// than a whole cleanup.
+
+// DefaultBatchSize is the number of rows deleted, or pages vacuumed, per
+// step when BatchOptions.Size is not set
+const DefaultBatchSize = 1000
+
+// BatchOptions paces a long delete or incremental vacuum
+type BatchOptions struct {
+	Size  int           // rows or pages per step (0 = DefaultBatchSize)
+	Pause time.Duration // wait between steps, letting writers in
+	// Progress, when set, is called after each step with the rows
+	// deleted or pages freed by that step
+	Progress func(n int64)
+}
+
+func (o BatchOptions) size() int {
+	if o.Size <= 0 {
+		return DefaultBatchSize
+	}
+	return o.Size
+}
+
+// pause waits between steps; it returns the context's error once done
+func (o BatchOptions) pause(ctx context.Context) error {
+	if o.Pause <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(o.Pause)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// deleteBatched deletes the rows of table matching cond in steps of at
+// most opts.Size rows, selected by key, and returns the number deleted.
+// It stops early, returning the context's error, when ctx is done.
+func (db *DB) deleteBatched(ctx context.Context, table, key, cond string, args []any, opts BatchOptions) (int64, error) {
+	query := fmt.Sprintf("DELETE FROM %s WHERE (%s) IN (SELECT %s FROM %s WHERE %s LIMIT ?)",
+		table, key, key, table, cond)
+	return db.execBatched(ctx, "delete from "+table, query, args, opts)
+}
+
+// execBatched runs query, which changes at most its last argument's rows,
+// until a step changes fewer than opts.Size, and returns the rows changed.
+// what names the change in errors.
+func (db *DB) execBatched(ctx context.Context, what, query string, args []any, opts BatchOptions) (int64, error) {
+	args = append(args[:len(args):len(args)], opts.size())
+
+	var changed int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return changed, err
+		}
+		res, err := db.conn.ExecContext(ctx, query, args...)
+		if err != nil {
+			return changed, fmt.Errorf("failed to %s: %w", what, err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return changed, fmt.Errorf("failed to get rows affected: %w", err)
+		}
+		changed += n
+		if n > 0 && opts.Progress != nil {
+			opts.Progress(n)
+		}
+		if n < int64(opts.size()) {
+			return changed, nil
+		}
+		if err := opts.pause(ctx); err != nil {
+			return changed, err
+		}
+	}
+}
+
+// ClearDetails drops, in batches, the headers and body captured for logs
+// older than cutoff and returns the number of logs cleared. The logs
+// themselves, and their user agents, are kept until they expire.
+func (db *DB) ClearDetails(ctx context.Context, cutoff time.Time, opts BatchOptions) (int64, error) {
+	return db.execBatched(ctx, "clear request details", `UPDATE request_logs SET headers = NULL, body = NULL WHERE id IN (
+		SELECT id FROM request_logs WHERE timestamp < ? AND (headers IS NOT NULL OR body IS NOT NULL) LIMIT ?)`,
+		[]any{cutoff.Local()}, opts)
+}
+
+// PurgeLogs deletes logs, rate limit drop counters and banner listener
+// connections older than cutoff in batches and returns the number of logs
+// deleted. Progress counts logs only.
+func (db *DB) PurgeLogs(ctx context.Context, cutoff time.Time, opts BatchOptions) (int64, error) {
+	cutoff = cutoff.Local()
+	deleted, err := db.deleteBatched(ctx, "request_logs", "id", "timestamp < ?", []any{cutoff}, opts)
+	if err != nil {
+		return deleted, fmt.Errorf("failed to purge logs: %w", err)
+	}
+
+	opts.Progress = nil
+	if _, err := db.deleteBatched(ctx, "rate_limit_drops", "rowid", "minute < ?", []any{cutoff}, opts); err != nil {
+		return deleted, fmt.Errorf("failed to purge rate limit drops: %w", err)
+	}
+	if _, err := db.deleteBatched(ctx, "tcp_connections", "id", "timestamp < ?", []any{cutoff}, opts); err != nil {
+		return deleted, fmt.Errorf("failed to purge connections: %w", err)
+	}
+	return deleted, nil
+}
+
+// PurgeHourlyRollups deletes, in batches, hourly rollup buckets that end
+// by cutoff and returns the number of rows deleted
+func (db *DB) PurgeHourlyRollups(ctx context.Context, cutoff time.Time, opts BatchOptions) (int64, error) {
+	return db.purgeRollups(ctx, rollupLevels[1], cutoff, opts)
+}
+
+// PurgeDailyRollups deletes, in batches, daily rollup buckets that end by
+// cutoff and returns the number of rows deleted
+func (db *DB) PurgeDailyRollups(ctx context.Context, cutoff time.Time, opts BatchOptions) (int64, error) {
+	return db.purgeRollups(ctx, rollupLevels[0], cutoff, opts)
+}
+
+func (db *DB) purgeRollups(ctx context.Context, level rollupLevel, cutoff time.Time, opts BatchOptions) (int64, error) {
+	last := cutoff.Add(-level.unit).UTC().Format(bucketLayout)
+	var deleted int64
+	for _, kind := range rollupKinds {
+		table := kind.table(level.name)
+		n, err := db.deleteBatched(ctx, table, "rowid", "bucket <= ?", []any{last}, opts)
+		deleted += n
+		if err != nil {
+			return deleted, fmt.Errorf("failed to purge %s: %w", table, err)
+		}
+	}
+	return deleted, nil
+}
+
+// AutoVacuumMode returns the database's auto_vacuum setting: none, full or
+// incremental. Databases created before incremental auto-vacuum was the
+// default report none until a full Vacuum converts them.
+func (db *DB) AutoVacuumMode() (string, error) {
+	var mode int
+	if err := db.conn.QueryRow("PRAGMA auto_vacuum").Scan(&mode); err != nil {
+		return "", fmt.Errorf("failed to read auto_vacuum: %w", err)
+	}
+	switch mode {
+	case 1:
+		return "full", nil
+	case 2:
+		return "incremental", nil
+	default:
+		return "none", nil
+	}
+}
+
+// FreePages returns the number of unused pages in the database file
+func (db *DB) FreePages() (int64, error) {
+	var free int64
+	if err := db.conn.QueryRow("PRAGMA freelist_count").Scan(&free); err != nil {
+		return 0, fmt.Errorf("failed to read free page count: %w", err)
+	}
+	return free, nil
+}
+
+// IncrementalVacuum returns free pages to the file system in steps of at
+// most opts.Size pages until none are left, and returns the number freed.
+// Unlike Vacuum it never holds the write lock for long. It does nothing
+// unless the database uses incremental auto-vacuum.
+func (db *DB) IncrementalVacuum(ctx context.Context, opts BatchOptions) (int64, error) {
+	var freed int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return freed, err
+		}
+		before, err := db.FreePages()
+		if err != nil {
+			return freed, err
+		}
+		if before == 0 {
+			return freed, nil
+		}
+		if err := db.vacuumStep(ctx, opts.size()); err != nil {
+			return freed, err
+		}
+		after, err := db.FreePages()
+		if err != nil {
+			return freed, err
+		}
+		// No progress means auto-vacuum is off for this database
+		if after >= before {
+			return freed, nil
+		}
+		freed += before - after
+		if opts.Progress != nil {
+			opts.Progress(before - after)
+		}
+		if err := opts.pause(ctx); err != nil {
+			return freed, err
+		}
+	}
+}
+
+// vacuumStep frees up to pages pages. The pragma frees one page per result
+// step, so its rows are drained rather than executed once.
+func (db *DB) vacuumStep(ctx context.Context, pages int) error {
+	rows, err := db.conn.QueryContext(ctx, fmt.Sprintf("PRAGMA incremental_vacuum(%d)", pages))
+	if err != nil {
+		return fmt.Errorf("failed to vacuum incrementally: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			// Ignore close errors
+		}
+	}()
+	for rows.Next() {
+		// Each row is a step of the vacuum
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to vacuum incrementally: %w", err)
+	}
+	return nil
+}
+
+// Vacuum rebuilds the database file to reclaim all free pages. It holds
+// the write lock throughout, so it is meant for the vacuum command rather
+// than the running server. It also switches databases created without
+// incremental auto-vacuum over to it.
+func (db *DB) Vacuum() error {
+	ctx := context.Background()
+	// Both statements must run on the same connection
+	conn, err := db.conn.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to vacuum database: %w", err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			// Returned to the pool, nothing to report
+		}
+	}()
+	if _, err := conn.ExecContext(ctx, "PRAGMA auto_vacuum = INCREMENTAL"); err != nil {
+		return fmt.Errorf("failed to set auto_vacuum: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, "VACUUM"); err != nil {
+		return fmt.Errorf("failed to vacuum database: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPurgeLogs_Batches(t *testing.T) {
+	db := setupTestDB(t)
+	old := time.Now().AddDate(0, 0, -10)
+	for i := 0; i < 10; i++ {
+		insertLog(t, db, "192.0.2.1", "/old", old.Add(time.Duration(i)*time.Second))
+	}
+	if err := db.LogRequest("192.0.2.2", "/new"); err != nil {
+		t.Fatalf("Failed to log request: %v", err)
+	}
+
+	var steps []int64
+	deleted, err := db.PurgeLogs(context.Background(), time.Now().AddDate(0, 0, -1), BatchOptions{
+		Size:     3,
+		Progress: func(n int64) { steps = append(steps, n) },
+	})
+	if err != nil {
+		t.Fatalf("PurgeLogs failed: %v", err)
+	}
+	if deleted != 10 {
+		t.Errorf("Expected 10 logs deleted, got %d", deleted)
+	}
+	if len(steps) != 4 || steps[0] != 3 || steps[3] != 1 {
+		t.Errorf("Expected batches of 3, 3, 3 and 1, got %v", steps)
+	}
+
+	logs, err := db.QueryLogs(LogFilter{})
+	if err != nil {
+		t.Fatalf("Failed to query logs: %v", err)
+	}
+	if len(logs) != 1 || logs[0].URL != "/new" {
+		t.Errorf("Expected only the new log to remain, got %+v", logs)
+	}
+}
+
+func TestPurgeLogs_Cancelled(t *testing.T) {
+	db := setupTestDB(t)
+	insertLog(t, db, "192.0.2.1", "/old", time.Now().AddDate(0, 0, -10))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	deleted, err := db.PurgeLogs(ctx, time.Now(), BatchOptions{})
+	if !errors.Is(err, context.Canceled) || deleted != 0 {
+		t.Errorf("Expected a cancelled purge to delete nothing, got %d (%v)", deleted, err)
+	}
+}
+
+func TestPurgeRollups_Batches(t *testing.T) {
+	db := setupTestDB(t)
+	old := time.Now().AddDate(0, 0, -100)
+	for i := 0; i < 5; i++ {
+		insertLog(t, db, "192.0.2.1", "/old", old.Add(time.Duration(i)*time.Hour))
+	}
+
+	var steps []int64
+	deleted, err := db.PurgeHourlyRollups(context.Background(), time.Now().AddDate(0, 0, -90), BatchOptions{
+		Size:     2,
+		Progress: func(n int64) { steps = append(steps, n) },
+	})
+	if err != nil {
+		t.Fatalf("PurgeHourlyRollups failed: %v", err)
+	}
+	// A total, URL and address row for each hour
+	if deleted != 15 {
+		t.Errorf("Expected 15 rollup rows deleted, got %d", deleted)
+	}
+	for _, n := range steps {
+		if n > 2 {
+			t.Errorf("Expected batches of at most 2, got %v", steps)
+			break
+		}
+	}
+	if oldest, err := db.OldestHourlyRollup(); err != nil || !oldest.IsZero() {
+		t.Errorf("Expected no hourly rollups left, oldest is %v (%v)", oldest, err)
+	}
+}
+
+func TestIncrementalVacuum(t *testing.T) {
+	db := setupTestDB(t)
+	if mode, err := db.AutoVacuumMode(); err != nil || mode != "incremental" {
+		t.Fatalf("Expected new databases to use incremental auto-vacuum, got %q (%v)", mode, err)
+	}
+
+	old := time.Now().AddDate(0, 0, -10)
+	url := "/" + strings.Repeat("x", 2000)
+	for i := 0; i < 200; i++ {
+		insertLog(t, db, "192.0.2.1", url, old.Add(time.Duration(i)*time.Second))
+	}
+	if _, err := db.PurgeBefore(time.Now()); err != nil {
+		t.Fatalf("Failed to purge logs: %v", err)
+	}
+	free, err := db.FreePages()
+	if err != nil || free == 0 {
+		t.Fatalf("Expected free pages after the purge, got %d (%v)", free, err)
+	}
+
+	var steps int
+	freed, err := db.IncrementalVacuum(context.Background(), BatchOptions{
+		Size:     10,
+		Progress: func(int64) { steps++ },
+	})
+	if err != nil {
+		t.Fatalf("IncrementalVacuum failed: %v", err)
+	}
+	if freed != free || steps < int(free/10) {
+		t.Errorf("Expected %d pages freed in steps of 10, got %d in %d steps", free, freed, steps)
+	}
+	if free, err = db.FreePages(); err != nil || free != 0 {
+		t.Errorf("Expected no free pages left, got %d (%v)", free, err)
+	}
+}
+
+func TestVacuum_EnablesIncremental(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "legacy.db")
+
+	// A database created before incremental auto-vacuum was the default
+	conn, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	if _, err := conn.Exec("CREATE TABLE legacy (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+
+	db, err := Open(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("Failed to close database: %v", err)
+		}
+	}()
+	if mode, err := db.AutoVacuumMode(); err != nil || mode != "none" {
+		t.Fatalf("Expected auto_vacuum none before a full vacuum, got %q (%v)", mode, err)
+	}
+	if err := db.Vacuum(); err != nil {
+		t.Fatalf("Vacuum failed: %v", err)
+	}
+	if mode, err := db.AutoVacuumMode(); err != nil || mode != "incremental" {
+		t.Errorf("Expected auto_vacuum incremental after a full vacuum, got %q (%v)", mode, err)
+	}
+}
@@ -0,0 +1,795 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+)
+
+// Migration is one step of the schema history. Migrations are applied in
+// order and the last applied version is recorded in PRAGMA user_version.
+type Migration struct {
+	Version     int
+	Description string
+	sql         string
+	// step, when set, runs after sql in the same transaction, for changes
+	// SQLite can't make by itself
+	step func(*sql.Tx) error
+	// before, when set, runs ahead of the transaction, for statements
+	// such as VACUUM that SQLite refuses inside one
+	before func(*DB) error
+}
+
+// migrations is the schema history; append new steps, never edit old ones
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "request logs, rate limit drops and IP bans",
+		sql: `
+		CREATE TABLE IF NOT EXISTS request_logs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			ip_address TEXT NOT NULL,
+			url TEXT NOT NULL,
+			timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS idx_timestamp ON request_logs(timestamp);
+		CREATE INDEX IF NOT EXISTS idx_ip_address ON request_logs(ip_address);
+		CREATE INDEX IF NOT EXISTS idx_url ON request_logs(url);
+
+		CREATE TABLE IF NOT EXISTS rate_limit_drops (
+			ip_address TEXT NOT NULL,
+			minute DATETIME NOT NULL,
+			route_group TEXT NOT NULL,
+			scope TEXT NOT NULL,
+			count INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (ip_address, minute, route_group, scope)
+		);
+		CREATE INDEX IF NOT EXISTS idx_rate_limit_drops_minute ON rate_limit_drops(minute);
+
+		CREATE TABLE IF NOT EXISTS ip_bans (
+			cidr TEXT PRIMARY KEY,
+			reason TEXT NOT NULL DEFAULT '',
+			source TEXT NOT NULL DEFAULT 'manual',
+			offenses INTEGER NOT NULL DEFAULT 1,
+			created_at DATETIME NOT NULL,
+			expires_at DATETIME
+		);
+		`,
+	},
+	{
+		Version:     2,
+		Description: "users and API tokens",
+		sql: `
+		CREATE TABLE users (
+			username TEXT PRIMARY KEY,
+			password_hash TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL
+		);
+
+		CREATE TABLE api_tokens (
+			name TEXT PRIMARY KEY,
+			token_hash TEXT NOT NULL UNIQUE,
+			created_at DATETIME NOT NULL
+		);
+		`,
+	},
+	{
+		Version:     3,
+		Description: "hourly and daily statistics rollups",
+		sql: `
+		CREATE TABLE rollup_hourly (
+			bucket TEXT NOT NULL,
+			url TEXT NOT NULL,
+			ip_address TEXT NOT NULL,
+			count INTEGER NOT NULL,
+			first_seen DATETIME,
+			last_seen DATETIME,
+			PRIMARY KEY (bucket, url, ip_address)
+		) WITHOUT ROWID;
+
+		CREATE TABLE rollup_daily (
+			bucket TEXT NOT NULL,
+			url TEXT NOT NULL,
+			ip_address TEXT NOT NULL,
+			count INTEGER NOT NULL,
+			first_seen DATETIME,
+			last_seen DATETIME,
+			PRIMARY KEY (bucket, url, ip_address)
+		) WITHOUT ROWID;
+
+		INSERT INTO rollup_hourly (bucket, url, ip_address, count, first_seen, last_seen)
+		SELECT COALESCE(strftime('%Y-%m-%d %H:00:00', timestamp), strftime('%Y-%m-%d %H:00:00', 'now')),
+			url, ip_address, COUNT(*), MIN(timestamp), MAX(timestamp)
+		FROM request_logs
+		GROUP BY 1, url, ip_address;
+
+		INSERT INTO rollup_daily (bucket, url, ip_address, count, first_seen, last_seen)
+		SELECT COALESCE(strftime('%Y-%m-%d 00:00:00', timestamp), strftime('%Y-%m-%d 00:00:00', 'now')),
+			url, ip_address, COUNT(*), MIN(timestamp), MAX(timestamp)
+		FROM request_logs
+		GROUP BY 1, url, ip_address;
+
+		CREATE TRIGGER request_logs_rollup AFTER INSERT ON request_logs
+		BEGIN
+			INSERT INTO rollup_hourly (bucket, url, ip_address, count, first_seen, last_seen)
+			VALUES (COALESCE(strftime('%Y-%m-%d %H:00:00', NEW.timestamp), strftime('%Y-%m-%d %H:00:00', 'now')),
+				NEW.url, NEW.ip_address, 1, NEW.timestamp, NEW.timestamp)
+			ON CONFLICT (bucket, url, ip_address) DO UPDATE SET
+				count = count + 1,
+				first_seen = min(first_seen, excluded.first_seen),
+				last_seen = max(last_seen, excluded.last_seen);
+
+			INSERT INTO rollup_daily (bucket, url, ip_address, count, first_seen, last_seen)
+			VALUES (COALESCE(strftime('%Y-%m-%d 00:00:00', NEW.timestamp), strftime('%Y-%m-%d 00:00:00', 'now')),
+				NEW.url, NEW.ip_address, 1, NEW.timestamp, NEW.timestamp)
+			ON CONFLICT (bucket, url, ip_address) DO UPDATE SET
+				count = count + 1,
+				first_seen = min(first_seen, excluded.first_seen),
+				last_seen = max(last_seen, excluded.last_seen);
+		END;
+		`,
+	},
+	{
+		Version:     4,
+		Description: "IP anonymization history",
+		sql: `
+		CREATE TABLE ip_anonymization (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			changed_at TEXT NOT NULL,
+			mode TEXT NOT NULL,
+			key_id TEXT NOT NULL DEFAULT '',
+			rewritten_before TEXT
+		);
+
+		-- Everything logged so far was stored raw
+		INSERT INTO ip_anonymization (changed_at, mode) VALUES ('0001-01-01 00:00:00', 'raw');
+		`,
+	},
+	{
+		Version:     5,
+		Description: "binary IP address keys and address families",
+		sql: `
+		ALTER TABLE request_logs ADD COLUMN ip_bin BLOB;
+		ALTER TABLE request_logs ADD COLUMN ip_family INTEGER;
+		CREATE INDEX idx_ip_bin ON request_logs(ip_bin);
+
+		ALTER TABLE rollup_hourly ADD COLUMN ip_bin BLOB;
+		ALTER TABLE rollup_hourly ADD COLUMN ip_family INTEGER;
+		ALTER TABLE rollup_daily ADD COLUMN ip_bin BLOB;
+		ALTER TABLE rollup_daily ADD COLUMN ip_family INTEGER;
+		ALTER TABLE rate_limit_drops ADD COLUMN ip_bin BLOB;
+		ALTER TABLE rate_limit_drops ADD COLUMN ip_family INTEGER;
+
+		DROP TRIGGER request_logs_rollup;
+		CREATE TRIGGER request_logs_rollup AFTER INSERT ON request_logs
+		BEGIN
+			INSERT INTO rollup_hourly (bucket, url, ip_address, ip_bin, ip_family, count, first_seen, last_seen)
+			VALUES (COALESCE(strftime('%Y-%m-%d %H:00:00', NEW.timestamp), strftime('%Y-%m-%d %H:00:00', 'now')),
+				NEW.url, NEW.ip_address, NEW.ip_bin, NEW.ip_family, 1, NEW.timestamp, NEW.timestamp)
+			ON CONFLICT (bucket, url, ip_address) DO UPDATE SET
+				count = count + 1,
+				first_seen = min(first_seen, excluded.first_seen),
+				last_seen = max(last_seen, excluded.last_seen);
+
+			INSERT INTO rollup_daily (bucket, url, ip_address, ip_bin, ip_family, count, first_seen, last_seen)
+			VALUES (COALESCE(strftime('%Y-%m-%d 00:00:00', NEW.timestamp), strftime('%Y-%m-%d 00:00:00', 'now')),
+				NEW.url, NEW.ip_address, NEW.ip_bin, NEW.ip_family, 1, NEW.timestamp, NEW.timestamp)
+			ON CONFLICT (bucket, url, ip_address) DO UPDATE SET
+				count = count + 1,
+				first_seen = min(first_seen, excluded.first_seen),
+				last_seen = max(last_seen, excluded.last_seen);
+		END;
+		`,
+		step: backfillIPKeys,
+	},
+	{
+		Version:     6,
+		Description: "decomposed and normalized request URLs",
+		sql: `
+		ALTER TABLE request_logs ADD COLUMN path TEXT;
+		ALTER TABLE request_logs ADD COLUMN query TEXT;
+		ALTER TABLE request_logs ADD COLUMN params TEXT;
+		ALTER TABLE request_logs ADD COLUMN norm_path TEXT;
+		CREATE INDEX idx_norm_path ON request_logs(norm_path);
+
+		ALTER TABLE rollup_hourly ADD COLUMN path TEXT;
+		ALTER TABLE rollup_hourly ADD COLUMN query TEXT;
+		ALTER TABLE rollup_hourly ADD COLUMN norm_path TEXT;
+		ALTER TABLE rollup_daily ADD COLUMN path TEXT;
+		ALTER TABLE rollup_daily ADD COLUMN query TEXT;
+		ALTER TABLE rollup_daily ADD COLUMN norm_path TEXT;
+
+		DROP TRIGGER request_logs_rollup;
+		CREATE TRIGGER request_logs_rollup AFTER INSERT ON request_logs
+		BEGIN
+			INSERT INTO rollup_hourly (bucket, url, ip_address, ip_bin, ip_family, path, query, norm_path, count, first_seen, last_seen)
+			VALUES (COALESCE(strftime('%Y-%m-%d %H:00:00', NEW.timestamp), strftime('%Y-%m-%d %H:00:00', 'now')),
+				NEW.url, NEW.ip_address, NEW.ip_bin, NEW.ip_family, NEW.path, NEW.query, NEW.norm_path, 1, NEW.timestamp, NEW.timestamp)
+			ON CONFLICT (bucket, url, ip_address) DO UPDATE SET
+				count = count + 1,
+				first_seen = min(first_seen, excluded.first_seen),
+				last_seen = max(last_seen, excluded.last_seen);
+
+			INSERT INTO rollup_daily (bucket, url, ip_address, ip_bin, ip_family, path, query, norm_path, count, first_seen, last_seen)
+			VALUES (COALESCE(strftime('%Y-%m-%d 00:00:00', NEW.timestamp), strftime('%Y-%m-%d 00:00:00', 'now')),
+				NEW.url, NEW.ip_address, NEW.ip_bin, NEW.ip_family, NEW.path, NEW.query, NEW.norm_path, 1, NEW.timestamp, NEW.timestamp)
+			ON CONFLICT (bucket, url, ip_address) DO UPDATE SET
+				count = count + 1,
+				first_seen = min(first_seen, excluded.first_seen),
+				last_seen = max(last_seen, excluded.last_seen);
+		END;
+		`,
+		step: backfillURLParts,
+	},
+	{
+		Version:     7,
+		Description: "request details and full-text search",
+		sql: `
+		ALTER TABLE request_logs ADD COLUMN user_agent TEXT;
+		ALTER TABLE request_logs ADD COLUMN headers TEXT;
+		ALTER TABLE request_logs ADD COLUMN body TEXT;
+		`,
+		step: createSearchIndex,
+	},
+	{
+		Version:     8,
+		Description: "sensor IDs on request logs and rollups",
+		// The rollups are rebuilt, as a primary key can't be altered
+		sql: `
+		ALTER TABLE request_logs ADD COLUMN sensor_id TEXT NOT NULL DEFAULT '';
+		CREATE INDEX idx_sensor_id ON request_logs(sensor_id);
+
+		DROP TRIGGER request_logs_rollup;
+
+		CREATE TABLE rollup_hourly_new (
+			bucket TEXT NOT NULL,
+			sensor_id TEXT NOT NULL DEFAULT '',
+			url TEXT NOT NULL,
+			ip_address TEXT NOT NULL,
+			ip_bin BLOB,
+			ip_family INTEGER,
+			path TEXT,
+			query TEXT,
+			norm_path TEXT,
+			count INTEGER NOT NULL,
+			first_seen DATETIME,
+			last_seen DATETIME,
+			PRIMARY KEY (bucket, sensor_id, url, ip_address)
+		) WITHOUT ROWID;
+		INSERT INTO rollup_hourly_new (bucket, url, ip_address, ip_bin, ip_family, path, query, norm_path, count, first_seen, last_seen)
+		SELECT bucket, url, ip_address, ip_bin, ip_family, path, query, norm_path, count, first_seen, last_seen FROM rollup_hourly;
+		DROP TABLE rollup_hourly;
+		ALTER TABLE rollup_hourly_new RENAME TO rollup_hourly;
+
+		CREATE TABLE rollup_daily_new (
+			bucket TEXT NOT NULL,
+			sensor_id TEXT NOT NULL DEFAULT '',
+			url TEXT NOT NULL,
+			ip_address TEXT NOT NULL,
+			ip_bin BLOB,
+			ip_family INTEGER,
+			path TEXT,
+			query TEXT,
+			norm_path TEXT,
+			count INTEGER NOT NULL,
+			first_seen DATETIME,
+			last_seen DATETIME,
+			PRIMARY KEY (bucket, sensor_id, url, ip_address)
+		) WITHOUT ROWID;
+		INSERT INTO rollup_daily_new (bucket, url, ip_address, ip_bin, ip_family, path, query, norm_path, count, first_seen, last_seen)
+		SELECT bucket, url, ip_address, ip_bin, ip_family, path, query, norm_path, count, first_seen, last_seen FROM rollup_daily;
+		DROP TABLE rollup_daily;
+		ALTER TABLE rollup_daily_new RENAME TO rollup_daily;
+
+		CREATE TRIGGER request_logs_rollup AFTER INSERT ON request_logs
+		BEGIN
+			INSERT INTO rollup_hourly (bucket, sensor_id, url, ip_address, ip_bin, ip_family, path, query, norm_path, count, first_seen, last_seen)
+			VALUES (COALESCE(strftime('%Y-%m-%d %H:00:00', NEW.timestamp), strftime('%Y-%m-%d %H:00:00', 'now')),
+				NEW.sensor_id, NEW.url, NEW.ip_address, NEW.ip_bin, NEW.ip_family, NEW.path, NEW.query, NEW.norm_path, 1, NEW.timestamp, NEW.timestamp)
+			ON CONFLICT (bucket, sensor_id, url, ip_address) DO UPDATE SET
+				count = count + 1,
+				first_seen = min(first_seen, excluded.first_seen),
+				last_seen = max(last_seen, excluded.last_seen);
+
+			INSERT INTO rollup_daily (bucket, sensor_id, url, ip_address, ip_bin, ip_family, path, query, norm_path, count, first_seen, last_seen)
+			VALUES (COALESCE(strftime('%Y-%m-%d 00:00:00', NEW.timestamp), strftime('%Y-%m-%d 00:00:00', 'now')),
+				NEW.sensor_id, NEW.url, NEW.ip_address, NEW.ip_bin, NEW.ip_family, NEW.path, NEW.query, NEW.norm_path, 1, NEW.timestamp, NEW.timestamp)
+			ON CONFLICT (bucket, sensor_id, url, ip_address) DO UPDATE SET
+				count = count + 1,
+				first_seen = min(first_seen, excluded.first_seen),
+				last_seen = max(last_seen, excluded.last_seen);
+		END;
+
+		-- Return the pages of the old rollups, where auto-vacuum allows
+		PRAGMA incremental_vacuum;
+		`,
+	},
+	{
+		Version:     9,
+		Description: "listener labels and local ports on request logs and rollups",
+		// The rollups are rebuilt, as a primary key can't be altered
+		sql: `
+		ALTER TABLE request_logs ADD COLUMN listener TEXT NOT NULL DEFAULT '';
+		ALTER TABLE request_logs ADD COLUMN local_port INTEGER NOT NULL DEFAULT 0;
+		CREATE INDEX idx_listener ON request_logs(listener, local_port);
+
+		DROP TRIGGER request_logs_rollup;
+
+		CREATE TABLE rollup_hourly_new (
+			bucket TEXT NOT NULL,
+			sensor_id TEXT NOT NULL DEFAULT '',
+			listener TEXT NOT NULL DEFAULT '',
+			local_port INTEGER NOT NULL DEFAULT 0,
+			url TEXT NOT NULL,
+			ip_address TEXT NOT NULL,
+			ip_bin BLOB,
+			ip_family INTEGER,
+			path TEXT,
+			query TEXT,
+			norm_path TEXT,
+			count INTEGER NOT NULL,
+			first_seen DATETIME,
+			last_seen DATETIME,
+			PRIMARY KEY (bucket, sensor_id, listener, local_port, url, ip_address)
+		) WITHOUT ROWID;
+		INSERT INTO rollup_hourly_new (bucket, sensor_id, url, ip_address, ip_bin, ip_family, path, query, norm_path, count, first_seen, last_seen)
+		SELECT bucket, sensor_id, url, ip_address, ip_bin, ip_family, path, query, norm_path, count, first_seen, last_seen FROM rollup_hourly;
+		DROP TABLE rollup_hourly;
+		ALTER TABLE rollup_hourly_new RENAME TO rollup_hourly;
+
+		CREATE TABLE rollup_daily_new (
+			bucket TEXT NOT NULL,
+			sensor_id TEXT NOT NULL DEFAULT '',
+			listener TEXT NOT NULL DEFAULT '',
+			local_port INTEGER NOT NULL DEFAULT 0,
+			url TEXT NOT NULL,
+			ip_address TEXT NOT NULL,
+			ip_bin BLOB,
+			ip_family INTEGER,
+			path TEXT,
+			query TEXT,
+			norm_path TEXT,
+			count INTEGER NOT NULL,
+			first_seen DATETIME,
+			last_seen DATETIME,
+			PRIMARY KEY (bucket, sensor_id, listener, local_port, url, ip_address)
+		) WITHOUT ROWID;
+		INSERT INTO rollup_daily_new (bucket, sensor_id, url, ip_address, ip_bin, ip_family, path, query, norm_path, count, first_seen, last_seen)
+		SELECT bucket, sensor_id, url, ip_address, ip_bin, ip_family, path, query, norm_path, count, first_seen, last_seen FROM rollup_daily;
+		DROP TABLE rollup_daily;
+		ALTER TABLE rollup_daily_new RENAME TO rollup_daily;
+
+		CREATE TRIGGER request_logs_rollup AFTER INSERT ON request_logs
+		BEGIN
+			INSERT INTO rollup_hourly (bucket, sensor_id, listener, local_port, url, ip_address, ip_bin, ip_family, path, query, norm_path, count, first_seen, last_seen)
+			VALUES (COALESCE(strftime('%Y-%m-%d %H:00:00', NEW.timestamp), strftime('%Y-%m-%d %H:00:00', 'now')),
+				NEW.sensor_id, NEW.listener, NEW.local_port, NEW.url, NEW.ip_address, NEW.ip_bin, NEW.ip_family, NEW.path, NEW.query, NEW.norm_path,
+				1, NEW.timestamp, NEW.timestamp)
+			ON CONFLICT (bucket, sensor_id, listener, local_port, url, ip_address) DO UPDATE SET
+				count = count + 1,
+				first_seen = min(first_seen, excluded.first_seen),
+				last_seen = max(last_seen, excluded.last_seen);
+
+			INSERT INTO rollup_daily (bucket, sensor_id, listener, local_port, url, ip_address, ip_bin, ip_family, path, query, norm_path, count, first_seen, last_seen)
+			VALUES (COALESCE(strftime('%Y-%m-%d 00:00:00', NEW.timestamp), strftime('%Y-%m-%d 00:00:00', 'now')),
+				NEW.sensor_id, NEW.listener, NEW.local_port, NEW.url, NEW.ip_address, NEW.ip_bin, NEW.ip_family, NEW.path, NEW.query, NEW.norm_path,
+				1, NEW.timestamp, NEW.timestamp)
+			ON CONFLICT (bucket, sensor_id, listener, local_port, url, ip_address) DO UPDATE SET
+				count = count + 1,
+				first_seen = min(first_seen, excluded.first_seen),
+				last_seen = max(last_seen, excluded.last_seen);
+		END;
+
+		-- Return the pages of the old rollups, where auto-vacuum allows
+		PRAGMA incremental_vacuum;
+		`,
+	},
+	{
+		Version:     10,
+		Description: "connections to banner listeners",
+		sql: `
+		CREATE TABLE tcp_connections (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp DATETIME NOT NULL,
+			ip_address TEXT NOT NULL,
+			ip_bin BLOB,
+			ip_family INTEGER,
+			protocol TEXT NOT NULL,
+			listener TEXT NOT NULL DEFAULT '',
+			local_port INTEGER NOT NULL DEFAULT 0,
+			payload BLOB NOT NULL,
+			payload_hash TEXT NOT NULL,
+			sensor_id TEXT NOT NULL DEFAULT ''
+		);
+		CREATE INDEX idx_tcp_connections_timestamp ON tcp_connections(timestamp);
+		CREATE INDEX idx_tcp_connections_ip_bin ON tcp_connections(ip_bin);
+		CREATE INDEX idx_tcp_connections_payload ON tcp_connections(protocol, local_port, payload_hash);
+		`,
+	},
+	{
+		Version:     11,
+		Description: "separate per-URL, per-IP and total rollups",
+		// The URL and address pairs grew as fast as the raw logs. Each
+		// bucket now has a row per URL, per address and per listener, with
+		// the distinct addresses of each URL and URLs of each address
+		// counted as requests arrive: a pair is new to a bucket when the
+		// previous request from the address to the URL fell in another.
+		// The tables keep their rowid so purges can batch on it.
+		sql: `
+		DROP TRIGGER request_logs_rollup;
+
+		-- Finds the previous request of a pair; it also serves address lookups
+		DROP INDEX idx_ip_address;
+		CREATE INDEX idx_ip_url ON request_logs(ip_address, url);
+
+		CREATE TABLE rollup_totals_hourly (
+			bucket TEXT NOT NULL,
+			sensor_id TEXT NOT NULL DEFAULT '',
+			listener TEXT NOT NULL DEFAULT '',
+			local_port INTEGER NOT NULL DEFAULT 0,
+			count INTEGER NOT NULL,
+			first_seen DATETIME,
+			last_seen DATETIME,
+			PRIMARY KEY (bucket, sensor_id, listener, local_port)
+		);
+		CREATE TABLE rollup_urls_hourly (
+			bucket TEXT NOT NULL,
+			sensor_id TEXT NOT NULL DEFAULT '',
+			listener TEXT NOT NULL DEFAULT '',
+			local_port INTEGER NOT NULL DEFAULT 0,
+			url TEXT NOT NULL,
+			path TEXT,
+			query TEXT,
+			norm_path TEXT,
+			count INTEGER NOT NULL,
+			unique_ips INTEGER NOT NULL,
+			first_seen DATETIME,
+			last_seen DATETIME,
+			PRIMARY KEY (bucket, sensor_id, listener, local_port, url)
+		);
+		CREATE TABLE rollup_ips_hourly (
+			bucket TEXT NOT NULL,
+			sensor_id TEXT NOT NULL DEFAULT '',
+			listener TEXT NOT NULL DEFAULT '',
+			local_port INTEGER NOT NULL DEFAULT 0,
+			ip_address TEXT NOT NULL,
+			ip_bin BLOB,
+			ip_family INTEGER,
+			count INTEGER NOT NULL,
+			unique_urls INTEGER NOT NULL,
+			first_seen DATETIME,
+			last_seen DATETIME,
+			PRIMARY KEY (bucket, sensor_id, listener, local_port, ip_address)
+		);
+
+		-- Each pair row of the old table is one address for its URL and one
+		-- URL for its address
+		INSERT INTO rollup_totals_hourly (bucket, sensor_id, listener, local_port, count, first_seen, last_seen)
+		SELECT bucket, sensor_id, listener, local_port, SUM(count), MIN(first_seen), MAX(last_seen)
+		FROM rollup_hourly GROUP BY bucket, sensor_id, listener, local_port;
+		INSERT INTO rollup_urls_hourly (bucket, sensor_id, listener, local_port, url, path, query, norm_path, count, unique_ips, first_seen, last_seen)
+		SELECT bucket, sensor_id, listener, local_port, url, MAX(path), MAX(query), MAX(norm_path), SUM(count), COUNT(*), MIN(first_seen), MAX(last_seen)
+		FROM rollup_hourly GROUP BY bucket, sensor_id, listener, local_port, url;
+		INSERT INTO rollup_ips_hourly (bucket, sensor_id, listener, local_port, ip_address, ip_bin, ip_family, count, unique_urls, first_seen, last_seen)
+		SELECT bucket, sensor_id, listener, local_port, ip_address, MAX(ip_bin), MAX(ip_family), SUM(count), COUNT(*), MIN(first_seen), MAX(last_seen)
+		FROM rollup_hourly GROUP BY bucket, sensor_id, listener, local_port, ip_address;
+		DROP TABLE rollup_hourly;
+
+		CREATE TABLE rollup_totals_daily (
+			bucket TEXT NOT NULL,
+			sensor_id TEXT NOT NULL DEFAULT '',
+			listener TEXT NOT NULL DEFAULT '',
+			local_port INTEGER NOT NULL DEFAULT 0,
+			count INTEGER NOT NULL,
+			first_seen DATETIME,
+			last_seen DATETIME,
+			PRIMARY KEY (bucket, sensor_id, listener, local_port)
+		);
+		CREATE TABLE rollup_urls_daily (
+			bucket TEXT NOT NULL,
+			sensor_id TEXT NOT NULL DEFAULT '',
+			listener TEXT NOT NULL DEFAULT '',
+			local_port INTEGER NOT NULL DEFAULT 0,
+			url TEXT NOT NULL,
+			path TEXT,
+			query TEXT,
+			norm_path TEXT,
+			count INTEGER NOT NULL,
+			unique_ips INTEGER NOT NULL,
+			first_seen DATETIME,
+			last_seen DATETIME,
+			PRIMARY KEY (bucket, sensor_id, listener, local_port, url)
+		);
+		CREATE TABLE rollup_ips_daily (
+			bucket TEXT NOT NULL,
+			sensor_id TEXT NOT NULL DEFAULT '',
+			listener TEXT NOT NULL DEFAULT '',
+			local_port INTEGER NOT NULL DEFAULT 0,
+			ip_address TEXT NOT NULL,
+			ip_bin BLOB,
+			ip_family INTEGER,
+			count INTEGER NOT NULL,
+			unique_urls INTEGER NOT NULL,
+			first_seen DATETIME,
+			last_seen DATETIME,
+			PRIMARY KEY (bucket, sensor_id, listener, local_port, ip_address)
+		);
+
+		INSERT INTO rollup_totals_daily (bucket, sensor_id, listener, local_port, count, first_seen, last_seen)
+		SELECT bucket, sensor_id, listener, local_port, SUM(count), MIN(first_seen), MAX(last_seen)
+		FROM rollup_daily GROUP BY bucket, sensor_id, listener, local_port;
+		INSERT INTO rollup_urls_daily (bucket, sensor_id, listener, local_port, url, path, query, norm_path, count, unique_ips, first_seen, last_seen)
+		SELECT bucket, sensor_id, listener, local_port, url, MAX(path), MAX(query), MAX(norm_path), SUM(count), COUNT(*), MIN(first_seen), MAX(last_seen)
+		FROM rollup_daily GROUP BY bucket, sensor_id, listener, local_port, url;
+		INSERT INTO rollup_ips_daily (bucket, sensor_id, listener, local_port, ip_address, ip_bin, ip_family, count, unique_urls, first_seen, last_seen)
+		SELECT bucket, sensor_id, listener, local_port, ip_address, MAX(ip_bin), MAX(ip_family), SUM(count), COUNT(*), MIN(first_seen), MAX(last_seen)
+		FROM rollup_daily GROUP BY bucket, sensor_id, listener, local_port, ip_address;
+		DROP TABLE rollup_daily;
+
+		CREATE TRIGGER request_logs_rollup AFTER INSERT ON request_logs
+		BEGIN
+			INSERT INTO rollup_totals_hourly (bucket, sensor_id, listener, local_port, count, first_seen, last_seen)
+			VALUES (COALESCE(strftime('%Y-%m-%d %H:00:00', NEW.timestamp), strftime('%Y-%m-%d %H:00:00', 'now')), NEW.sensor_id, NEW.listener, NEW.local_port, 1, NEW.timestamp, NEW.timestamp)
+			ON CONFLICT (bucket, sensor_id, listener, local_port) DO UPDATE SET
+				count = count + 1,
+				first_seen = min(first_seen, excluded.first_seen),
+				last_seen = max(last_seen, excluded.last_seen);
+
+			INSERT INTO rollup_urls_hourly (bucket, sensor_id, listener, local_port, url, path, query, norm_path, count, unique_ips, first_seen, last_seen)
+			VALUES (COALESCE(strftime('%Y-%m-%d %H:00:00', NEW.timestamp), strftime('%Y-%m-%d %H:00:00', 'now')), NEW.sensor_id, NEW.listener, NEW.local_port, NEW.url, NEW.path, NEW.query, NEW.norm_path,
+				1, COALESCE((
+				SELECT strftime('%Y-%m-%d %H:00:00', timestamp) FROM request_logs
+				WHERE ip_address = NEW.ip_address AND url = NEW.url AND sensor_id = NEW.sensor_id
+					AND listener = NEW.listener AND local_port = NEW.local_port AND id < NEW.id
+				ORDER BY id DESC LIMIT 1
+			), '') <> COALESCE(strftime('%Y-%m-%d %H:00:00', NEW.timestamp), strftime('%Y-%m-%d %H:00:00', 'now')), NEW.timestamp, NEW.timestamp)
+			ON CONFLICT (bucket, sensor_id, listener, local_port, url) DO UPDATE SET
+				count = count + 1,
+				unique_ips = unique_ips + excluded.unique_ips,
+				first_seen = min(first_seen, excluded.first_seen),
+				last_seen = max(last_seen, excluded.last_seen);
+
+			INSERT INTO rollup_ips_hourly (bucket, sensor_id, listener, local_port, ip_address, ip_bin, ip_family, count, unique_urls, first_seen, last_seen)
+			VALUES (COALESCE(strftime('%Y-%m-%d %H:00:00', NEW.timestamp), strftime('%Y-%m-%d %H:00:00', 'now')), NEW.sensor_id, NEW.listener, NEW.local_port, NEW.ip_address, NEW.ip_bin, NEW.ip_family,
+				1, COALESCE((
+				SELECT strftime('%Y-%m-%d %H:00:00', timestamp) FROM request_logs
+				WHERE ip_address = NEW.ip_address AND url = NEW.url AND sensor_id = NEW.sensor_id
+					AND listener = NEW.listener AND local_port = NEW.local_port AND id < NEW.id
+				ORDER BY id DESC LIMIT 1
+			), '') <> COALESCE(strftime('%Y-%m-%d %H:00:00', NEW.timestamp), strftime('%Y-%m-%d %H:00:00', 'now')), NEW.timestamp, NEW.timestamp)
+			ON CONFLICT (bucket, sensor_id, listener, local_port, ip_address) DO UPDATE SET
+				count = count + 1,
+				unique_urls = unique_urls + excluded.unique_urls,
+				first_seen = min(first_seen, excluded.first_seen),
+				last_seen = max(last_seen, excluded.last_seen);
+
+			INSERT INTO rollup_totals_daily (bucket, sensor_id, listener, local_port, count, first_seen, last_seen)
+			VALUES (COALESCE(strftime('%Y-%m-%d 00:00:00', NEW.timestamp), strftime('%Y-%m-%d 00:00:00', 'now')), NEW.sensor_id, NEW.listener, NEW.local_port, 1, NEW.timestamp, NEW.timestamp)
+			ON CONFLICT (bucket, sensor_id, listener, local_port) DO UPDATE SET
+				count = count + 1,
+				first_seen = min(first_seen, excluded.first_seen),
+				last_seen = max(last_seen, excluded.last_seen);
+
+			INSERT INTO rollup_urls_daily (bucket, sensor_id, listener, local_port, url, path, query, norm_path, count, unique_ips, first_seen, last_seen)
+			VALUES (COALESCE(strftime('%Y-%m-%d 00:00:00', NEW.timestamp), strftime('%Y-%m-%d 00:00:00', 'now')), NEW.sensor_id, NEW.listener, NEW.local_port, NEW.url, NEW.path, NEW.query, NEW.norm_path,
+				1, COALESCE((
+				SELECT strftime('%Y-%m-%d 00:00:00', timestamp) FROM request_logs
+				WHERE ip_address = NEW.ip_address AND url = NEW.url AND sensor_id = NEW.sensor_id
+					AND listener = NEW.listener AND local_port = NEW.local_port AND id < NEW.id
+				ORDER BY id DESC LIMIT 1
+			), '') <> COALESCE(strftime('%Y-%m-%d 00:00:00', NEW.timestamp), strftime('%Y-%m-%d 00:00:00', 'now')), NEW.timestamp, NEW.timestamp)
+			ON CONFLICT (bucket, sensor_id, listener, local_port, url) DO UPDATE SET
+				count = count + 1,
+				unique_ips = unique_ips + excluded.unique_ips,
+				first_seen = min(first_seen, excluded.first_seen),
+				last_seen = max(last_seen, excluded.last_seen);
+
+			INSERT INTO rollup_ips_daily (bucket, sensor_id, listener, local_port, ip_address, ip_bin, ip_family, count, unique_urls, first_seen, last_seen)
+			VALUES (COALESCE(strftime('%Y-%m-%d 00:00:00', NEW.timestamp), strftime('%Y-%m-%d 00:00:00', 'now')), NEW.sensor_id, NEW.listener, NEW.local_port, NEW.ip_address, NEW.ip_bin, NEW.ip_family,
+				1, COALESCE((
+				SELECT strftime('%Y-%m-%d 00:00:00', timestamp) FROM request_logs
+				WHERE ip_address = NEW.ip_address AND url = NEW.url AND sensor_id = NEW.sensor_id
+					AND listener = NEW.listener AND local_port = NEW.local_port AND id < NEW.id
+				ORDER BY id DESC LIMIT 1
+			), '') <> COALESCE(strftime('%Y-%m-%d 00:00:00', NEW.timestamp), strftime('%Y-%m-%d 00:00:00', 'now')), NEW.timestamp, NEW.timestamp)
+			ON CONFLICT (bucket, sensor_id, listener, local_port, ip_address) DO UPDATE SET
+				count = count + 1,
+				unique_urls = unique_urls + excluded.unique_urls,
+				first_seen = min(first_seen, excluded.first_seen),
+				last_seen = max(last_seen, excluded.last_seen);
+		END;
+
+		-- Return the pages of the old rollups, where auto-vacuum allows
+		PRAGMA incremental_vacuum;
+		`,
+	},
+	{
+		Version:     12,
+		Description: "incremental auto-vacuum for databases created without it",
+		// auto_vacuum only changes on an existing database with a full
+		// VACUUM; until then retention can't shrink the file
+		before: func(db *DB) error {
+			mode, err := db.AutoVacuumMode()
+			if err != nil {
+				return err
+			}
+			if mode == "incremental" {
+				return nil
+			}
+			slog.Info("Converting database to incremental auto-vacuum, this rewrites the file once", "auto_vacuum", mode)
+			return db.Vacuum()
+		},
+	},
+	{
+		Version:     13,
+		Description: "count pairs new to a rollup bucket whatever order requests arrive in",
+		// Imports and sensor batches insert requests out of time order, so
+		// a pair is new to a bucket when no other request of it falls in
+		// the bucket, rather than when the previous one by ID fell in another
+		sql: `
+		DROP TRIGGER request_logs_rollup;
+
+		CREATE TRIGGER request_logs_rollup AFTER INSERT ON request_logs
+		BEGIN
+			INSERT INTO rollup_totals_hourly (bucket, sensor_id, listener, local_port, count, first_seen, last_seen)
+			VALUES (COALESCE(strftime('%Y-%m-%d %H:00:00', NEW.timestamp), strftime('%Y-%m-%d %H:00:00', 'now')), NEW.sensor_id, NEW.listener, NEW.local_port, 1, NEW.timestamp, NEW.timestamp)
+			ON CONFLICT (bucket, sensor_id, listener, local_port) DO UPDATE SET
+				count = count + 1,
+				first_seen = min(first_seen, excluded.first_seen),
+				last_seen = max(last_seen, excluded.last_seen);
+
+			INSERT INTO rollup_urls_hourly (bucket, sensor_id, listener, local_port, url, path, query, norm_path, count, unique_ips, first_seen, last_seen)
+			VALUES (COALESCE(strftime('%Y-%m-%d %H:00:00', NEW.timestamp), strftime('%Y-%m-%d %H:00:00', 'now')), NEW.sensor_id, NEW.listener, NEW.local_port, NEW.url, NEW.path, NEW.query, NEW.norm_path,
+				1, NOT EXISTS (
+				SELECT 1 FROM request_logs
+				WHERE ip_address = NEW.ip_address AND url = NEW.url AND sensor_id = NEW.sensor_id
+					AND listener = NEW.listener AND local_port = NEW.local_port AND id <> NEW.id
+					AND strftime('%Y-%m-%d %H:00:00', timestamp) = COALESCE(strftime('%Y-%m-%d %H:00:00', NEW.timestamp), strftime('%Y-%m-%d %H:00:00', 'now'))
+			), NEW.timestamp, NEW.timestamp)
+			ON CONFLICT (bucket, sensor_id, listener, local_port, url) DO UPDATE SET
+				count = count + 1,
+				unique_ips = unique_ips + excluded.unique_ips,
+				first_seen = min(first_seen, excluded.first_seen),
+				last_seen = max(last_seen, excluded.last_seen);
+
+			INSERT INTO rollup_ips_hourly (bucket, sensor_id, listener, local_port, ip_address, ip_bin, ip_family, count, unique_urls, first_seen, last_seen)
+			VALUES (COALESCE(strftime('%Y-%m-%d %H:00:00', NEW.timestamp), strftime('%Y-%m-%d %H:00:00', 'now')), NEW.sensor_id, NEW.listener, NEW.local_port, NEW.ip_address, NEW.ip_bin, NEW.ip_family,
+				1, NOT EXISTS (
+				SELECT 1 FROM request_logs
+				WHERE ip_address = NEW.ip_address AND url = NEW.url AND sensor_id = NEW.sensor_id
+					AND listener = NEW.listener AND local_port = NEW.local_port AND id <> NEW.id
+					AND strftime('%Y-%m-%d %H:00:00', timestamp) = COALESCE(strftime('%Y-%m-%d %H:00:00', NEW.timestamp), strftime('%Y-%m-%d %H:00:00', 'now'))
+			), NEW.timestamp, NEW.timestamp)
+			ON CONFLICT (bucket, sensor_id, listener, local_port, ip_address) DO UPDATE SET
+				count = count + 1,
+				unique_urls = unique_urls + excluded.unique_urls,
+				first_seen = min(first_seen, excluded.first_seen),
+				last_seen = max(last_seen, excluded.last_seen);
+
+			INSERT INTO rollup_totals_daily (bucket, sensor_id, listener, local_port, count, first_seen, last_seen)
+			VALUES (COALESCE(strftime('%Y-%m-%d 00:00:00', NEW.timestamp), strftime('%Y-%m-%d 00:00:00', 'now')), NEW.sensor_id, NEW.listener, NEW.local_port, 1, NEW.timestamp, NEW.timestamp)
+			ON CONFLICT (bucket, sensor_id, listener, local_port) DO UPDATE SET
+				count = count + 1,
+				first_seen = min(first_seen, excluded.first_seen),
+				last_seen = max(last_seen, excluded.last_seen);
+
+			INSERT INTO rollup_urls_daily (bucket, sensor_id, listener, local_port, url, path, query, norm_path, count, unique_ips, first_seen, last_seen)
+			VALUES (COALESCE(strftime('%Y-%m-%d 00:00:00', NEW.timestamp), strftime('%Y-%m-%d 00:00:00', 'now')), NEW.sensor_id, NEW.listener, NEW.local_port, NEW.url, NEW.path, NEW.query, NEW.norm_path,
+				1, NOT EXISTS (
+				SELECT 1 FROM request_logs
+				WHERE ip_address = NEW.ip_address AND url = NEW.url AND sensor_id = NEW.sensor_id
+					AND listener = NEW.listener AND local_port = NEW.local_port AND id <> NEW.id
+					AND strftime('%Y-%m-%d 00:00:00', timestamp) = COALESCE(strftime('%Y-%m-%d 00:00:00', NEW.timestamp), strftime('%Y-%m-%d 00:00:00', 'now'))
+			), NEW.timestamp, NEW.timestamp)
+			ON CONFLICT (bucket, sensor_id, listener, local_port, url) DO UPDATE SET
+				count = count + 1,
+				unique_ips = unique_ips + excluded.unique_ips,
+				first_seen = min(first_seen, excluded.first_seen),
+				last_seen = max(last_seen, excluded.last_seen);
+
+			INSERT INTO rollup_ips_daily (bucket, sensor_id, listener, local_port, ip_address, ip_bin, ip_family, count, unique_urls, first_seen, last_seen)
+			VALUES (COALESCE(strftime('%Y-%m-%d 00:00:00', NEW.timestamp), strftime('%Y-%m-%d 00:00:00', 'now')), NEW.sensor_id, NEW.listener, NEW.local_port, NEW.ip_address, NEW.ip_bin, NEW.ip_family,
+				1, NOT EXISTS (
+				SELECT 1 FROM request_logs
+				WHERE ip_address = NEW.ip_address AND url = NEW.url AND sensor_id = NEW.sensor_id
+					AND listener = NEW.listener AND local_port = NEW.local_port AND id <> NEW.id
+					AND strftime('%Y-%m-%d 00:00:00', timestamp) = COALESCE(strftime('%Y-%m-%d 00:00:00', NEW.timestamp), strftime('%Y-%m-%d 00:00:00', 'now'))
+			), NEW.timestamp, NEW.timestamp)
+			ON CONFLICT (bucket, sensor_id, listener, local_port, ip_address) DO UPDATE SET
+				count = count + 1,
+				unique_urls = unique_urls + excluded.unique_urls,
+				first_seen = min(first_seen, excluded.first_seen),
+				last_seen = max(last_seen, excluded.last_seen);
+		END;
+		`,
+	},
+	{
+		Version:     14,
+		Description: "FTS5 search index for databases indexed with FTS4",
+		step:        rebuildSearchIndex,
+	},
+	{
+		Version:     15,
+		Description: "batches received from sensors",
+		sql: `
+		CREATE TABLE IF NOT EXISTS sensor_batches (
+			sensor_id TEXT NOT NULL,
+			batch_id TEXT NOT NULL,
+			accepted INTEGER NOT NULL,
+			received_at DATETIME NOT NULL,
+			PRIMARY KEY (sensor_id, batch_id)
+		);
+		CREATE INDEX IF NOT EXISTS idx_sensor_batches_received ON sensor_batches(received_at);
+		`,
+	},
+}
+
+// LatestSchemaVersion returns the schema version this build migrates to
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// SchemaVersion returns the schema version recorded in the database
+func (db *DB) SchemaVersion() (int, error) {
+	var version int
+	if err := db.conn.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// Migrate applies pending migrations, each in its own transaction, and
+// returns the ones applied. A database from a newer build is an error.
+func (db *DB) Migrate() ([]Migration, error) {
+	current, err := db.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	if current > LatestSchemaVersion() {
+		return nil, fmt.Errorf("database schema version %d is newer than this build supports (%d)", current, LatestSchemaVersion())
+	}
+
+	var applied []Migration
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+
+		if m.before != nil {
+			if err := m.before(db); err != nil {
+				return applied, fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Description, err)
+			}
+		}
+		tx, err := db.conn.Begin()
+		if err != nil {
+			return applied, fmt.Errorf("failed to begin migration %d: %w", m.Version, err)
+		}
+		if _, err := tx.Exec(m.sql); err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				// Rollback failure doesn't change the outcome
+			}
+			return applied, fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Description, err)
+		}
+		if m.step != nil {
+			if err := m.step(tx); err != nil {
+				if rbErr := tx.Rollback(); rbErr != nil {
+					// Rollback failure doesn't change the outcome
+				}
+				return applied, fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Description, err)
+			}
+		}
+		// PRAGMA doesn't accept bound parameters
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", m.Version)); err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				// Rollback failure doesn't change the outcome
+			}
+			return applied, fmt.Errorf("failed to record migration %d: %w", m.Version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return applied, fmt.Errorf("failed to commit migration %d: %w", m.Version, err)
+		}
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
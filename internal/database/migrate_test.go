@@ -0,0 +1,166 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "migrate.db")
+
+	db, err := Open(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("Failed to close database: %v", err)
+		}
+	}()
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		t.Fatalf("Failed to read schema version: %v", err)
+	}
+	if version != 0 {
+		t.Fatalf("Expected version 0 for a new database, got %d", version)
+	}
+
+	applied, err := db.Migrate()
+	if err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if len(applied) != LatestSchemaVersion() {
+		t.Errorf("Expected %d migrations applied, got %d", LatestSchemaVersion(), len(applied))
+	}
+	if version, _ := db.SchemaVersion(); version != LatestSchemaVersion() {
+		t.Errorf("Expected schema version %d, got %d", LatestSchemaVersion(), version)
+	}
+
+	// A second run has nothing to do
+	applied, err = db.Migrate()
+	if err != nil {
+		t.Fatalf("Second migrate failed: %v", err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("Expected no migrations on second run, got %d", len(applied))
+	}
+}
+
+func TestMigrate_NewerDatabase(t *testing.T) {
+	db := setupTestDB(t)
+
+	if _, err := db.conn.Exec("PRAGMA user_version = 9999"); err != nil {
+		t.Fatalf("Failed to set user_version: %v", err)
+	}
+	if _, err := db.Migrate(); err == nil {
+		t.Error("Expected error migrating a database from a newer build")
+	}
+}
+
+func TestMigrate_ExistingUnversionedDatabase(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "legacy.db")
+
+	// Databases created before migrations existed have the version 1
+	// tables but user_version 0
+	db, err := Open(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	if _, err := db.conn.Exec(migrations[0].sql); err != nil {
+		t.Fatalf("Failed to create legacy schema: %v", err)
+	}
+	if _, err := db.conn.Exec(`INSERT INTO request_logs (ip_address, url) VALUES
+		('::ffff:192.0.2.1', '/legacy/1?a=b'), ('not-an-ip', '/legacy/2')`); err != nil {
+		t.Fatalf("Failed to insert legacy logs: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+
+	db, err = New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to migrate legacy database: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("Failed to close database: %v", err)
+		}
+	}()
+
+	logs, err := db.GetLogs(0)
+	if err != nil {
+		t.Fatalf("Failed to get logs: %v", err)
+	}
+	if len(logs) != 2 {
+		t.Errorf("Expected legacy logs to survive migration, got %d logs", len(logs))
+	}
+
+	// Existing addresses get their keys, so prefix filters find them
+	logs, err = db.QueryLogs(LogFilter{IP: "192.0.2.0/24"})
+	if err != nil {
+		t.Fatalf("Failed to query logs: %v", err)
+	}
+	if len(logs) != 1 || logs[0].IPAddress != "::ffff:192.0.2.1" {
+		t.Errorf("Expected the legacy address within the prefix, got %+v", logs)
+	}
+
+	// and their URLs are decomposed
+	endpoints, err := db.QueryEndpointStatsBy(StatsFilter{}, GroupByNormalized)
+	if err != nil {
+		t.Fatalf("Failed to get endpoint stats: %v", err)
+	}
+	if len(endpoints) != 1 || endpoints[0].URL != "/legacy/{id}" || endpoints[0].Count != 2 {
+		t.Errorf("Expected the legacy URLs normalized together, got %+v", endpoints)
+	}
+}
+
+func TestMigrate_ConvertsAutoVacuum(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "vacuum.db")
+
+	// Databases created before incremental auto-vacuum have it off
+	db, err := New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	ctx := context.Background()
+	conn, err := db.conn.Conn(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+	for _, stmt := range []string{"PRAGMA auto_vacuum = NONE", "VACUUM", "PRAGMA user_version = 11"} {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			t.Fatalf("%s failed: %v", stmt, err)
+		}
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatalf("Failed to release connection: %v", err)
+	}
+	if mode, _ := db.AutoVacuumMode(); mode != "none" {
+		t.Fatalf("Expected auto_vacuum none before migrating, got %q", mode)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+
+	db, err = New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("Failed to close database: %v", err)
+		}
+	}()
+	mode, err := db.AutoVacuumMode()
+	if err != nil {
+		t.Fatalf("Failed to read auto_vacuum: %v", err)
+	}
+	if mode != "incremental" {
+		t.Errorf("Expected auto_vacuum incremental after migrating, got %q", mode)
+	}
+	if version, _ := db.SchemaVersion(); version != LatestSchemaVersion() {
+		t.Errorf("Expected schema version %d, got %d", LatestSchemaVersion(), version)
+	}
+}
@@ -0,0 +1,234 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/anonymize"
+)
+
+// LogFilter selects request logs. Zero-valued fields match everything.
+type LogFilter struct {
+	// IP is an address, CIDR prefix or other stored value, such as a
+	// pseudonym. An address also matches its HMAC pseudonyms.
+	IP     string
+	URL    string    // substring of the URL
+	Sensor string    // ID of the sensor that captured the request
+	Since  time.Time // inclusive lower bound
+	Until  time.Time // exclusive upper bound
+	Limit  int       // maximum number of logs (0 = no limit)
+	// Ascending returns the oldest logs first instead of the newest
+	Ascending bool
+	// Details also loads each log's user agent, headers, body and listener
+	Details bool
+	// Anonymizer holds the HMAC keys an address's pseudonyms are made with
+	// (nil = those the database stores new requests with)
+	Anonymizer *anonymize.Anonymizer
+}
+
+// EachLog calls fn for every log matching f without holding them all in
+// memory. Iteration stops at the first error returned by fn.
+func (db *DB) EachLog(f LogFilter, fn func(RequestLog) error) error {
+	var where []string
+	var args []any
+
+	if f.IP != "" {
+		prefix, err := ParseIPFilter(f.IP)
+		switch {
+		case err == nil:
+			cond, condArgs := prefixCondition(prefix)
+			// An address also matches its pseudonyms under every HMAC key
+			anon := f.Anonymizer
+			if anon == nil {
+				anon = db.anon.Load()
+			}
+			if pseudonyms := anon.Pseudonyms(prefix.Addr().String()); prefix.IsSingleIP() && len(pseudonyms) > 0 {
+				cond = "(" + cond + " OR ip_address IN (?" + strings.Repeat(", ?", len(pseudonyms)-1) + "))"
+				for _, p := range pseudonyms {
+					condArgs = append(condArgs, p)
+				}
+			}
+			where = append(where, cond)
+			args = append(args, condArgs...)
+		case strings.Contains(f.IP, "/"):
+			return err
+		default:
+			// Not an address, so it can only match the stored text
+			where = append(where, "ip_address = ?")
+			args = append(args, f.IP)
+		}
+	}
+	if f.URL != "" {
+		where = append(where, "instr(url, ?) > 0")
+		args = append(args, f.URL)
+	}
+	if f.Sensor != "" {
+		where = append(where, "sensor_id = ?")
+		args = append(args, f.Sensor)
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "timestamp >= ?")
+		args = append(args, f.Since.Local())
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "timestamp < ?")
+		args = append(args, f.Until.Local())
+	}
+
+	query := "SELECT id, ip_address, url, timestamp, sensor_id"
+	if f.Details {
+		query += ", user_agent, headers, body, listener, local_port"
+	}
+	query += " FROM request_logs"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	if f.Ascending {
+		query += " ORDER BY timestamp ASC, id ASC"
+	} else {
+		query += " ORDER BY timestamp DESC, id DESC"
+	}
+	if f.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, f.Limit)
+	}
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query logs: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			// Ignore close errors
+		}
+	}()
+
+	for rows.Next() {
+		var log RequestLog
+		dest := []any{&log.ID, &log.IPAddress, &log.URL, &log.Timestamp, &log.Sensor}
+		var userAgent, headers, body sql.NullString
+		var d RequestDetails
+		if f.Details {
+			dest = append(dest, &userAgent, &headers, &body, &d.Listener, &d.LocalPort)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return fmt.Errorf("failed to scan row: %w", err)
+		}
+		if f.Details {
+			d.UserAgent = userAgent.String
+			if headers.Valid {
+				d.Headers = parseHeaders(headers.String)
+			}
+			if body.Valid {
+				d.Body = []byte(body.String)
+			}
+			log.Details = &d
+		}
+		if err := fn(log); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("row iteration error: %w", err)
+	}
+	return nil
+}
+
+// QueryLogs returns the logs matching f
+func (db *DB) QueryLogs(f LogFilter) ([]RequestLog, error) {
+	var logs []RequestLog
+	err := db.EachLog(f, func(log RequestLog) error {
+		logs = append(logs, log)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// ImportLogs inserts logs in a single transaction, keeping their timestamps
+// and assigning new IDs. It returns the number of logs inserted.
+// Timestamps are stored in local time like those written by LogRequest, so
+// that range comparisons on the stored text stay ordered.
+func (db *DB) ImportLogs(logs []RequestLog) (int64, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil {
+			// Already committed
+		}
+	}()
+
+	stmt, err := tx.Prepare(`INSERT INTO request_logs (ip_address, ip_bin, ip_family, url, path, query, params, norm_path, sensor_id, timestamp)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare insert: %w", err)
+	}
+	defer func() {
+		if err := stmt.Close(); err != nil {
+			// Ignore close errors
+		}
+	}()
+
+	var inserted int64
+	for _, log := range logs {
+		ts := log.Timestamp
+		if ts.IsZero() {
+			ts = time.Now()
+		}
+		ip, ipBin, ipFamily := storedAddress(log.IPAddress, nil)
+		url := sanitizeInput(log.URL, 2048)
+		parts := splitURL(url)
+		if _, err := stmt.Exec(ip, ipBin, ipFamily, url, parts.path, parts.query, parts.params, parts.normPath, sanitizeInput(log.Sensor, 64), ts.Local()); err != nil {
+			return 0, fmt.Errorf("failed to import log: %w", err)
+		}
+		inserted++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit import: %w", err)
+	}
+	return inserted, nil
+}
+
+// PurgeBefore deletes logs and rate limit drop counters older than cutoff
+// and returns the number of logs deleted
+func (db *DB) PurgeBefore(cutoff time.Time) (int64, error) {
+	return db.PurgeLogs(context.Background(), cutoff, BatchOptions{})
+}
+
+// OldestLog returns the timestamp of the oldest request log, or the zero
+// time when there are none
+func (db *DB) OldestLog() (time.Time, error) {
+	var oldest sql.NullString
+	if err := db.conn.QueryRow("SELECT MIN(timestamp) FROM request_logs").Scan(&oldest); err != nil {
+		return time.Time{}, fmt.Errorf("failed to query oldest log: %w", err)
+	}
+	if !oldest.Valid {
+		return time.Time{}, nil
+	}
+	return parseTimestamp(oldest.String)
+}
+
+// UsedSize returns the bytes of the database file holding data. Pages
+// freed by deletes don't count, so it drops before the file shrinks.
+func (db *DB) UsedSize() (int64, error) {
+	var pageSize, pages, free int64
+	if err := db.conn.QueryRow("PRAGMA page_size").Scan(&pageSize); err != nil {
+		return 0, fmt.Errorf("failed to read page size: %w", err)
+	}
+	if err := db.conn.QueryRow("PRAGMA page_count").Scan(&pages); err != nil {
+		return 0, fmt.Errorf("failed to read page count: %w", err)
+	}
+	if err := db.conn.QueryRow("PRAGMA freelist_count").Scan(&free); err != nil {
+		return 0, fmt.Errorf("failed to read free page count: %w", err)
+	}
+	return (pages - free) * pageSize, nil
+}
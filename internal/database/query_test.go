@@ -0,0 +1,108 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueryLogs(t *testing.T) {
+	db := setupTestDB(t)
+
+	base := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	imported, err := db.ImportLogs([]RequestLog{
+		{IPAddress: "10.0.0.1", URL: "/wp-login.php", Timestamp: base},
+		{IPAddress: "10.0.0.2", URL: "/admin", Timestamp: base.Add(time.Hour)},
+		{IPAddress: "192.0.2.1", URL: "/wp-admin/", Timestamp: base.Add(2 * time.Hour)},
+		{IPAddress: "2001:db8::1", URL: "/.env", Timestamp: base.Add(3 * time.Hour)},
+	})
+	if err != nil {
+		t.Fatalf("Failed to import logs: %v", err)
+	}
+	if imported != 4 {
+		t.Fatalf("Expected 4 logs imported, got %d", imported)
+	}
+
+	tests := []struct {
+		name   string
+		filter LogFilter
+		want   []string // URLs in result order
+	}{
+		{name: "all newest first", filter: LogFilter{}, want: []string{"/.env", "/wp-admin/", "/admin", "/wp-login.php"}},
+		{name: "ascending", filter: LogFilter{Ascending: true, Limit: 2}, want: []string{"/wp-login.php", "/admin"}},
+		{name: "exact IP", filter: LogFilter{IP: "10.0.0.2"}, want: []string{"/admin"}},
+		{name: "CIDR", filter: LogFilter{IP: "10.0.0.0/8"}, want: []string{"/admin", "/wp-login.php"}},
+		{name: "CIDR with limit", filter: LogFilter{IP: "10.0.0.0/8", Limit: 1}, want: []string{"/admin"}},
+		{name: "IPv4-mapped IP", filter: LogFilter{IP: "::ffff:10.0.0.2"}, want: []string{"/admin"}},
+		{name: "IPv6 CIDR", filter: LogFilter{IP: "2001:db8::/32"}, want: []string{"/.env"}},
+		{name: "IPv6 CIDR excludes IPv4", filter: LogFilter{IP: "2001::/16"}, want: []string{"/.env"}},
+		{name: "odd CIDR", filter: LogFilter{IP: "192.0.0.0/22"}, want: []string{"/wp-admin/"}},
+		{name: "URL substring", filter: LogFilter{URL: "wp-"}, want: []string{"/wp-admin/", "/wp-login.php"}},
+		{name: "time range", filter: LogFilter{Since: base.Add(time.Hour), Until: base.Add(3 * time.Hour)}, want: []string{"/wp-admin/", "/admin"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs, err := db.QueryLogs(tt.filter)
+			if err != nil {
+				t.Fatalf("QueryLogs failed: %v", err)
+			}
+			if len(logs) != len(tt.want) {
+				t.Fatalf("Expected %d logs, got %d: %+v", len(tt.want), len(logs), logs)
+			}
+			for i, log := range logs {
+				if log.URL != tt.want[i] {
+					t.Errorf("Log %d: expected %s, got %s", i, tt.want[i], log.URL)
+				}
+			}
+		})
+	}
+
+	if _, err := db.QueryLogs(LogFilter{IP: "10.0.0.0/99"}); err == nil {
+		t.Error("Expected error for invalid CIDR filter")
+	}
+}
+
+func TestImportLogs_KeepsTimestamps(t *testing.T) {
+	db := setupTestDB(t)
+
+	ts := time.Date(2024, 2, 29, 8, 30, 0, 0, time.UTC)
+	if _, err := db.ImportLogs([]RequestLog{{ID: 42, IPAddress: "192.0.2.1", URL: "/x", Timestamp: ts}}); err != nil {
+		t.Fatalf("Failed to import logs: %v", err)
+	}
+
+	logs, err := db.GetLogs(0)
+	if err != nil {
+		t.Fatalf("Failed to get logs: %v", err)
+	}
+	if len(logs) != 1 || !logs[0].Timestamp.Equal(ts) {
+		t.Errorf("Expected imported timestamp %v, got %+v", ts, logs)
+	}
+}
+
+func TestPurgeBefore(t *testing.T) {
+	db := setupTestDB(t)
+
+	old := time.Now().AddDate(0, 0, -10)
+	if _, err := db.ImportLogs([]RequestLog{
+		{IPAddress: "192.0.2.1", URL: "/old", Timestamp: old},
+		{IPAddress: "192.0.2.1", URL: "/new", Timestamp: time.Now()},
+	}); err != nil {
+		t.Fatalf("Failed to import logs: %v", err)
+	}
+
+	deleted, err := db.PurgeBefore(time.Now().AddDate(0, 0, -5))
+	if err != nil {
+		t.Fatalf("PurgeBefore failed: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("Expected 1 log deleted, got %d", deleted)
+	}
+	if err := db.Vacuum(); err != nil {
+		t.Errorf("Vacuum failed: %v", err)
+	}
+
+	logs, _ := db.GetLogs(0)
+	if len(logs) != 1 || logs[0].URL != "/new" {
+		t.Errorf("Expected only /new to remain, got %+v", logs)
+	}
+}
@@ -0,0 +1,97 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// RateLimitDrop is a counter of requests rejected by the rate limiter for
+// one IP address, route group and scope within one minute
+type RateLimitDrop struct {
+	IPAddress  string    `json:"ip_address"`
+	Minute     time.Time `json:"minute"`
+	RouteGroup string    `json:"route_group"`
+	Scope      string    `json:"scope"` // "per_ip" or "global"
+	Count      int64     `json:"count"`
+}
+
+// RecordRateLimitDrops adds drop counters to the rate_limit_drops table,
+// merging them with any existing counters for the same minute
+func (db *DB) RecordRateLimitDrops(drops []RateLimitDrop) error {
+	if len(drops) == 0 {
+		return nil
+	}
+
+	return db.executeWithRetry(func() error {
+		tx, err := db.conn.Begin()
+		if err != nil {
+			return err
+		}
+
+		stmt, err := tx.Prepare(`
+			INSERT INTO rate_limit_drops (ip_address, ip_bin, ip_family, minute, route_group, scope, count)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (ip_address, minute, route_group, scope)
+			DO UPDATE SET count = count + excluded.count
+		`)
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				// Log but don't mask original error
+			}
+			return err
+		}
+		defer func() {
+			if err := stmt.Close(); err != nil {
+				// Ignore close errors
+			}
+		}()
+
+		anon := db.anon.Load()
+		for _, d := range drops {
+			ip, ipBin, ipFamily := storedAddress(d.IPAddress, anon)
+			if _, err := stmt.Exec(ip, ipBin, ipFamily, d.Minute.Truncate(time.Minute), d.RouteGroup, d.Scope, d.Count); err != nil {
+				if rbErr := tx.Rollback(); rbErr != nil {
+					// Log but don't mask original error
+				}
+				return err
+			}
+		}
+
+		return tx.Commit()
+	})
+}
+
+// GetRateLimitDrops retrieves the most recent drop counters with optional limit
+func (db *DB) GetRateLimitDrops(limit int) ([]RateLimitDrop, error) {
+	query := `SELECT ip_address, minute, route_group, scope, count FROM rate_limit_drops ORDER BY minute DESC, count DESC`
+	args := []interface{}{}
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rate limit drops: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			// Ignore close errors
+		}
+	}()
+
+	var drops []RateLimitDrop
+	for rows.Next() {
+		var d RateLimitDrop
+		if err := rows.Scan(&d.IPAddress, &d.Minute, &d.RouteGroup, &d.Scope, &d.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan rate limit drop: %w", err)
+		}
+		drops = append(drops, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rate limit drops iteration error: %w", err)
+	}
+
+	return drops, nil
+}
@@ -0,0 +1,122 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecordRateLimitDrops(t *testing.T) {
+	db := setupTestDB(t)
+
+	minute := time.Now().Truncate(time.Minute)
+	drops := []RateLimitDrop{
+		{IPAddress: "192.0.2.1", Minute: minute, RouteGroup: "catchall", Scope: "per_ip", Count: 5},
+		{IPAddress: "192.0.2.2", Minute: minute, RouteGroup: "catchall", Scope: "global", Count: 2},
+	}
+	if err := db.RecordRateLimitDrops(drops); err != nil {
+		t.Fatalf("Failed to record drops: %v", err)
+	}
+
+	// Same key again should merge into the existing counter
+	if err := db.RecordRateLimitDrops(drops[:1]); err != nil {
+		t.Fatalf("Failed to record drops: %v", err)
+	}
+
+	got, err := db.GetRateLimitDrops(0)
+	if err != nil {
+		t.Fatalf("Failed to get drops: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 drop counters, got %d", len(got))
+	}
+	if got[0].IPAddress != "192.0.2.1" || got[0].Count != 10 {
+		t.Errorf("Expected 192.0.2.1 with count 10, got %s with %d", got[0].IPAddress, got[0].Count)
+	}
+}
+
+func TestRecordRateLimitDrops_Empty(t *testing.T) {
+	db := setupTestDB(t)
+
+	if err := db.RecordRateLimitDrops(nil); err != nil {
+		t.Errorf("Expected no error for empty drops, got: %v", err)
+	}
+}
+
+func TestGetRateLimitDrops_Limit(t *testing.T) {
+	db := setupTestDB(t)
+
+	minute := time.Now().Truncate(time.Minute)
+	var drops []RateLimitDrop
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		drops = append(drops, RateLimitDrop{IPAddress: ip, Minute: minute, RouteGroup: "catchall", Scope: "per_ip", Count: 1})
+	}
+	if err := db.RecordRateLimitDrops(drops); err != nil {
+		t.Fatalf("Failed to record drops: %v", err)
+	}
+
+	got, err := db.GetRateLimitDrops(2)
+	if err != nil {
+		t.Fatalf("Failed to get drops: %v", err)
+	}
+	if len(got) != 2 {
+		t.Errorf("Expected 2 drop counters with limit, got %d", len(got))
+	}
+}
+
+func TestGetSourceStats_RateLimited(t *testing.T) {
+	db := setupTestDB(t)
+
+	if err := db.LogRequest("192.0.2.1", "/a"); err != nil {
+		t.Fatalf("Failed to log request: %v", err)
+	}
+	minute := time.Now().Truncate(time.Minute)
+	if err := db.RecordRateLimitDrops([]RateLimitDrop{
+		{IPAddress: "192.0.2.1", Minute: minute, RouteGroup: "catchall", Scope: "per_ip", Count: 7},
+		{IPAddress: "198.51.100.9", Minute: minute, RouteGroup: "catchall", Scope: "global", Count: 3},
+	}); err != nil {
+		t.Fatalf("Failed to record drops: %v", err)
+	}
+
+	stats, err := db.GetSourceStats()
+	if err != nil {
+		t.Fatalf("Failed to get source stats: %v", err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("Expected 2 sources, got %d", len(stats))
+	}
+
+	bySource := map[string]SourceStats{}
+	for _, s := range stats {
+		bySource[s.IPAddress] = s
+	}
+	if s := bySource["192.0.2.1"]; s.Count != 1 || s.RateLimited != 7 {
+		t.Errorf("Expected 192.0.2.1 count 1 rate_limited 7, got %d/%d", s.Count, s.RateLimited)
+	}
+	// An IP that was only ever rejected still shows up
+	if s := bySource["198.51.100.9"]; s.Count != 0 || s.RateLimited != 3 {
+		t.Errorf("Expected 198.51.100.9 count 0 rate_limited 3, got %d/%d", s.Count, s.RateLimited)
+	}
+}
+
+func TestCleanupOldLogs_RemovesOldDrops(t *testing.T) {
+	db := setupTestDB(t)
+
+	if err := db.RecordRateLimitDrops([]RateLimitDrop{
+		{IPAddress: "192.0.2.1", Minute: time.Now().AddDate(0, 0, -40), RouteGroup: "catchall", Scope: "per_ip", Count: 1},
+		{IPAddress: "192.0.2.2", Minute: time.Now(), RouteGroup: "catchall", Scope: "per_ip", Count: 1},
+	}); err != nil {
+		t.Fatalf("Failed to record drops: %v", err)
+	}
+
+	if _, err := db.CleanupOldLogs(30); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+
+	got, err := db.GetRateLimitDrops(0)
+	if err != nil {
+		t.Fatalf("Failed to get drops: %v", err)
+	}
+	if len(got) != 1 || got[0].IPAddress != "192.0.2.2" {
+		t.Errorf("Expected only the recent drop to remain, got %+v", got)
+	}
+}
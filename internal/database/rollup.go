@@ -0,0 +1,873 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The rollup tables count requests per UTC hour and per UTC day for each
+// sensor, listener and local port: in total, per URL and per IP address.
+// The per-URL rows also count the distinct addresses that requested the
+// URL in the bucket, and the per-address rows the distinct URLs. A trigger
+// on request_logs keeps them current. Totals and distinct addresses and
+// URLs overall are exact for any range of buckets. Distinct counts per URL
+// or address come from the raw logs for the part of a range they cover,
+// and add up those of each bucket before it. Rollups are not touched when
+// raw logs are deleted, so they can be kept for longer.
+
+// bucketLayout formats a UTC bucket start as stored in the rollup tables
+const bucketLayout = "2006-01-02 15:04:05"
+
+// StatsFilter limits statistics to a time range and, optionally, to the
+// addresses within a prefix, the requests of one sensor and those that
+// arrived on one listener or port. Zero values match everything. Only the
+// per-address rollups know addresses, so statistics per URL filtered by
+// address come from the raw logs alone.
+type StatsFilter struct {
+	Since    time.Time    // inclusive lower bound
+	Until    time.Time    // exclusive upper bound
+	IP       netip.Prefix // addresses within this prefix, see ParseIPFilter
+	Sensor   string       // ID of the sensor that captured the requests
+	Listener string       // label of the listener the requests arrived on
+	Port     int          // local port the requests arrived on
+}
+
+// rollupLevel is a bucket length and the name of its rollup tables
+type rollupLevel struct {
+	name string
+	unit time.Duration
+}
+
+// rollupLevels lists the rollup levels, coarsest first
+var rollupLevels = []rollupLevel{
+	{"daily", 24 * time.Hour},
+	{"hourly", time.Hour},
+}
+
+// rollupKind is one family of rollup tables, with the columns read from
+// them and how the same columns are computed from the raw logs
+type rollupKind struct {
+	name    string // tables are rollup_<name>_<level>
+	key     string // primary key, less the bucket
+	columns string
+	raw     string
+	hasIP   bool // rows carry ip_bin, so an address filter can use them
+}
+
+// The rollup kinds
+var (
+	totalRollups = rollupKind{
+		name:    "totals",
+		key:     "sensor_id, listener, local_port",
+		columns: "sensor_id, listener, local_port, count, first_seen, last_seen",
+		raw:     "sensor_id, listener, local_port, COUNT(*) AS count, MIN(timestamp) AS first_seen, MAX(timestamp) AS last_seen",
+	}
+	urlRollups = rollupKind{
+		name:    "urls",
+		key:     "sensor_id, listener, local_port, url",
+		columns: "sensor_id, listener, local_port, url, path, query, norm_path, count, unique_ips, first_seen, last_seen",
+		raw: `sensor_id, listener, local_port, url, path, query, norm_path, COUNT(*) AS count,
+			COUNT(DISTINCT ip_address) AS unique_ips, MIN(timestamp) AS first_seen, MAX(timestamp) AS last_seen`,
+	}
+	ipRollups = rollupKind{
+		name:    "ips",
+		key:     "sensor_id, listener, local_port, ip_address",
+		columns: "sensor_id, listener, local_port, ip_address, ip_bin, count, unique_urls, first_seen, last_seen",
+		raw: `sensor_id, listener, local_port, ip_address, ip_bin, COUNT(*) AS count,
+			COUNT(DISTINCT url) AS unique_urls, MIN(timestamp) AS first_seen, MAX(timestamp) AS last_seen`,
+		hasIP: true,
+	}
+	rollupKinds = []rollupKind{totalRollups, urlRollups, ipRollups}
+)
+
+// table returns the kind's table at level
+func (k rollupKind) table(level string) string {
+	return "rollup_" + k.name + "_" + level
+}
+
+// segment is part of a stats range answered from one source: a rollup
+// level, or the raw logs when level is empty
+type segment struct {
+	level    string
+	from, to time.Time
+}
+
+// planSegments splits [since, until) into the fewest segments, using whole
+// days and hours from the rollups and raw logs only for the ragged edges
+func planSegments(since, until time.Time, levels []rollupLevel) []segment {
+	if len(levels) == 0 {
+		if nonEmpty(since, until) {
+			return []segment{{"", since, until}}
+		}
+		return nil
+	}
+
+	level := levels[0]
+	from, to := since, until
+	if !from.IsZero() {
+		from = since.Truncate(level.unit)
+		if from.Before(since) {
+			from = from.Add(level.unit)
+		}
+	}
+	if !to.IsZero() {
+		to = until.Truncate(level.unit)
+	}
+	if !nonEmpty(from, to) {
+		return planSegments(since, until, levels[1:])
+	}
+
+	var segs []segment
+	if !since.IsZero() && since.Before(from) {
+		segs = append(segs, planSegments(since, from, levels[1:])...)
+	}
+	segs = append(segs, segment{level.name, from, to})
+	if !until.IsZero() && to.Before(until) {
+		segs = append(segs, planSegments(to, until, levels[1:])...)
+	}
+	return segs
+}
+
+// nonEmpty reports whether [from, to) contains any time; zero bounds are open
+func nonEmpty(from, to time.Time) bool {
+	return from.IsZero() || to.IsZero() || from.Before(to)
+}
+
+// rollupQuery returns a query for the kind's rows within f, read from its
+// rollup tables for whole buckets and computed from the raw logs for the
+// rest, so the same key can appear in several rows
+func rollupQuery(f StatsFilter, kind rollupKind) (string, []any) {
+	var parts []string
+	var args []any
+	levels := rollupLevels
+	if f.IP.IsValid() && !kind.hasIP {
+		levels = nil
+	}
+	for _, seg := range planSegments(f.Since, f.Until, levels) {
+		if seg.level == "" {
+			where, whereArgs := rawConditions(f, seg.from, seg.to)
+			args = append(args, whereArgs...)
+			parts = append(parts, "SELECT "+kind.raw+" FROM request_logs"+whereClause(where)+" GROUP BY "+kind.key)
+			continue
+		}
+		where, whereArgs := filterConditions(f)
+		args = append(args, whereArgs...)
+		if !seg.from.IsZero() {
+			where = append(where, "bucket >= ?")
+			args = append(args, seg.from.UTC().Format(bucketLayout))
+		}
+		if !seg.to.IsZero() {
+			where = append(where, "bucket < ?")
+			args = append(args, seg.to.UTC().Format(bucketLayout))
+		}
+		parts = append(parts, "SELECT "+kind.columns+" FROM "+kind.table(seg.level)+whereClause(where))
+	}
+	return strings.Join(parts, " UNION ALL "), args
+}
+
+// filterConditions returns the conditions selecting the rows of f's
+// address prefix, sensor, listener and port, and their arguments
+func filterConditions(f StatsFilter) ([]string, []any) {
+	var where []string
+	var args []any
+	if cond, condArgs := prefixCondition(f.IP); cond != "" {
+		where = append(where, cond)
+		args = append(args, condArgs...)
+	}
+	if f.Sensor != "" {
+		where = append(where, "sensor_id = ?")
+		args = append(args, f.Sensor)
+	}
+	if f.Listener != "" {
+		where = append(where, "listener = ?")
+		args = append(args, f.Listener)
+	}
+	if f.Port != 0 {
+		where = append(where, "local_port = ?")
+		args = append(args, f.Port)
+	}
+	return where, args
+}
+
+// rawConditions returns filterConditions for the raw logs logged within
+// [from, to)
+func rawConditions(f StatsFilter, from, to time.Time) ([]string, []any) {
+	where, args := filterConditions(f)
+	// Raw timestamps compare as text in local time, as in EachLog
+	if !from.IsZero() {
+		where = append(where, "timestamp >= ?")
+		args = append(args, from.Local())
+	}
+	if !to.IsZero() {
+		where = append(where, "timestamp < ?")
+		args = append(args, to.Local())
+	}
+	return where, args
+}
+
+// rawCoverage returns the start of the first whole hour the raw logs
+// cover, or the zero time when there are none. Retention deletes the
+// oldest logs first, so every request logged since is still there.
+func (db *DB) rawCoverage() (time.Time, error) {
+	var oldest sql.NullString
+	if err := db.conn.QueryRow("SELECT MIN(timestamp) FROM request_logs").Scan(&oldest); err != nil {
+		return time.Time{}, fmt.Errorf("failed to query oldest log: %w", err)
+	}
+	if !oldest.Valid {
+		return time.Time{}, nil
+	}
+	t, err := parseTimestamp(oldest.String)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse oldest log time: %w", err)
+	}
+	start := t.Truncate(time.Hour)
+	if start.Before(t) {
+		start = start.Add(time.Hour)
+	}
+	return start, nil
+}
+
+// splitCoverage splits f at the start of the raw logs' coverage, see
+// rawCoverage, into the older part and the part they cover, reporting
+// whether each is non-empty
+func (db *DB) splitCoverage(f StatsFilter) (older, covered StatsFilter, hasOlder, hasCovered bool, err error) {
+	start, err := db.rawCoverage()
+	if err != nil {
+		return older, covered, false, false, err
+	}
+	if start.IsZero() {
+		return f, covered, true, false, nil
+	}
+	older, covered = f, f
+	if older.Until.IsZero() || older.Until.After(start) {
+		older.Until = start
+	}
+	if covered.Since.Before(start) {
+		covered.Since = start
+	}
+	return older, covered, nonEmpty(older.Since, older.Until), nonEmpty(covered.Since, covered.Until), nil
+}
+
+// distinctQuery returns a query for the number of distinct values of
+// counted per value of group within f, with columns grp and n. It is exact
+// for the part of f the raw logs cover; before that it adds up perBucket,
+// the distinct counts of each bucket of kind's rollups. group must be
+// computable from both.
+func (db *DB) distinctQuery(f StatsFilter, kind rollupKind, group, counted, perBucket string) (string, []any, error) {
+	older, covered, hasOlder, hasCovered, err := db.splitCoverage(f)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var parts []string
+	var args []any
+	if hasOlder {
+		rows, rowArgs := rollupQuery(older, kind)
+		parts = append(parts, "SELECT "+group+" AS grp, SUM("+perBucket+") AS n FROM ("+rows+") GROUP BY 1")
+		args = append(args, rowArgs...)
+	}
+	if hasCovered {
+		where, whereArgs := rawConditions(covered, covered.Since, covered.Until)
+		parts = append(parts, "SELECT "+group+" AS grp, COUNT(DISTINCT "+counted+") AS n FROM request_logs"+
+			whereClause(where)+" GROUP BY 1")
+		args = append(args, whereArgs...)
+	}
+	if len(parts) == 0 {
+		return "SELECT NULL AS grp, 0 AS n WHERE 0", nil, nil
+	}
+	return "SELECT grp, SUM(n) AS n FROM (" + strings.Join(parts, " UNION ALL ") + ") GROUP BY grp", args, nil
+}
+
+// countsKind returns the rollups that count requests within f: the totals,
+// or the per-address rows when filtering by address
+func countsKind(f StatsFilter) rollupKind {
+	if f.IP.IsValid() {
+		return ipRollups
+	}
+	return totalRollups
+}
+
+// breakdownQuery returns a query for the requests, first and last times
+// and distinct addresses and URLs within f per value of keys, columns of
+// every rollup, with columns keys, count, first_seen, last_seen,
+// unique_ips and unique_urls
+func breakdownQuery(f StatsFilter, keys string) (string, []any) {
+	counts, args := rollupQuery(f, countsKind(f))
+	ips, ipArgs := rollupQuery(f, ipRollups)
+	urls, urlArgs := rollupQuery(f, urlRollups)
+	args = append(append(args, ipArgs...), urlArgs...)
+	return `
+		WITH counts AS (` + counts + `), ips AS (` + ips + `), urls AS (` + urls + `),
+		requests AS (
+			SELECT ` + keys + `, SUM(count) AS count, MIN(first_seen) AS first_seen, MAX(last_seen) AS last_seen
+			FROM counts GROUP BY ` + keys + `
+		), addresses AS (
+			SELECT ` + keys + `, COUNT(DISTINCT ip_address) AS n FROM ips GROUP BY ` + keys + `
+		), paths AS (
+			SELECT ` + keys + `, COUNT(DISTINCT url) AS n FROM urls GROUP BY ` + keys + `
+		)
+		SELECT r.*, COALESCE(a.n, 0) AS unique_ips, COALESCE(p.n, 0) AS unique_urls
+		FROM requests r
+		LEFT JOIN addresses a USING (` + keys + `)
+		LEFT JOIN paths p USING (` + keys + `)`, args
+}
+
+// whereClause joins conditions into a WHERE clause, or returns "" for none
+func whereClause(where []string) string {
+	if len(where) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(where, " AND ")
+}
+
+// QueryEndpointStats returns statistics grouped by URL within f
+func (db *DB) QueryEndpointStats(f StatsFilter) ([]EndpointStats, error) {
+	return db.QueryEndpointStatsBy(f, GroupByURL)
+}
+
+// QueryEndpointStatsBy returns statistics within f grouped by one form of
+// the URL, a GroupBy constant. The URL of each row is the group's value.
+func (db *DB) QueryEndpointStatsBy(f StatsFilter, by string) ([]EndpointStats, error) {
+	column, ok := groupColumns[by]
+	if !ok {
+		return nil, fmt.Errorf("unknown endpoint grouping %q (want url, path, query or normalized)", by)
+	}
+	group := "COALESCE(" + column + ", url)"
+	urls, args := rollupQuery(f, urlRollups)
+	seen, seenArgs, err := db.distinctQuery(f, urlRollups, group, "ip_address", "unique_ips")
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, seenArgs...)
+	query := `
+		WITH urls AS (` + urls + `), seen AS (` + seen + `), counts AS (
+			SELECT
+				` + group + ` as grp,
+				SUM(count) as count,
+				MIN(first_seen) as first_seen,
+				MAX(last_seen) as last_seen
+			FROM urls
+			GROUP BY 1
+		)
+		SELECT c.grp, c.count, c.first_seen, c.last_seen, COALESCE(s.n, 0)
+		FROM counts c
+		LEFT JOIN seen s ON s.grp = c.grp
+		ORDER BY c.count DESC, c.last_seen DESC, c.grp
+	`
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query endpoint stats: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			// Ignore close errors
+		}
+	}()
+
+	var stats []EndpointStats
+	for rows.Next() {
+		var s EndpointStats
+		var firstSeen, lastSeen string
+		if err := rows.Scan(&s.URL, &s.Count, &firstSeen, &lastSeen, &s.UniqueIPs); err != nil {
+			return nil, fmt.Errorf("failed to scan endpoint stats: %w", err)
+		}
+		if s.FirstSeen, err = parseTimestamp(firstSeen); err != nil {
+			return nil, fmt.Errorf("failed to parse first_seen: %w", err)
+		}
+		if s.LastSeen, err = parseTimestamp(lastSeen); err != nil {
+			return nil, fmt.Errorf("failed to parse last_seen: %w", err)
+		}
+		stats = append(stats, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("endpoint stats iteration error: %w", err)
+	}
+
+	return stats, nil
+}
+
+// QuerySourceStats returns statistics grouped by IP address within f,
+// including IPs that were only ever rejected by the rate limiter or only
+// connected to banner listeners
+func (db *DB) QuerySourceStats(f StatsFilter) ([]SourceStats, error) {
+	ips, args := rollupQuery(f, ipRollups)
+	seen, seenArgs, err := db.distinctQuery(f, ipRollups, "ip_address", "url", "unique_urls")
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, seenArgs...)
+	where, dropArgs := db.dropsFilter(f)
+	args = append(args, dropArgs...)
+	connWhere, connArgs := connectionsFilter(f)
+	args = append(args, connArgs...)
+	query := `
+		WITH ips AS (` + ips + `), seen AS (` + seen + `), logged AS (
+			SELECT
+				ip_address,
+				SUM(count) as count,
+				MIN(first_seen) as first_seen,
+				MAX(last_seen) as last_seen
+			FROM ips
+			GROUP BY ip_address
+		), dropped AS (
+			SELECT
+				ip_address,
+				SUM(count) as rate_limited,
+				MIN(minute) as first_seen,
+				MAX(minute) as last_seen
+			FROM rate_limit_drops` + where + `
+			GROUP BY ip_address
+		), connected AS (
+			SELECT
+				ip_address,
+				COUNT(*) as connections,
+				MIN(timestamp) as first_seen,
+				MAX(timestamp) as last_seen
+			FROM tcp_connections` + connWhere + `
+			GROUP BY ip_address
+		), sources AS (
+			SELECT ip_address FROM logged
+			UNION SELECT ip_address FROM dropped
+			UNION SELECT ip_address FROM connected
+		)
+		-- Seen times come from requests and connections, and from drops
+		-- only for addresses with neither
+		SELECT s.ip_address, COALESCE(l.count, 0),
+			MIN(COALESCE(l.first_seen, c.first_seen, d.first_seen), COALESCE(c.first_seen, l.first_seen, d.first_seen)),
+			MAX(COALESCE(l.last_seen, c.last_seen, d.last_seen), COALESCE(c.last_seen, l.last_seen, d.last_seen)),
+			COALESCE(u.n, 0), COALESCE(d.rate_limited, 0), COALESCE(c.connections, 0)
+		FROM sources s
+		LEFT JOIN logged l ON l.ip_address = s.ip_address
+		LEFT JOIN seen u ON u.grp = s.ip_address
+		LEFT JOIN dropped d ON d.ip_address = s.ip_address
+		LEFT JOIN connected c ON c.ip_address = s.ip_address
+		ORDER BY 2 DESC, 7 DESC, 6 DESC, 1
+	`
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query source stats: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			// Ignore close errors
+		}
+	}()
+
+	var stats []SourceStats
+	for rows.Next() {
+		var s SourceStats
+		var firstSeen, lastSeen string
+		if err := rows.Scan(&s.IPAddress, &s.Count, &firstSeen, &lastSeen, &s.UniqueURLs, &s.RateLimited, &s.Connections); err != nil {
+			return nil, fmt.Errorf("failed to scan source stats: %w", err)
+		}
+		if s.FirstSeen, err = parseTimestamp(firstSeen); err != nil {
+			return nil, fmt.Errorf("failed to parse first_seen: %w", err)
+		}
+		if s.LastSeen, err = parseTimestamp(lastSeen); err != nil {
+			return nil, fmt.Errorf("failed to parse last_seen: %w", err)
+		}
+		stats = append(stats, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("source stats iteration error: %w", err)
+	}
+
+	return stats, nil
+}
+
+// dropsFilter returns the WHERE clause selecting rate limit drop counters
+// within f, and its arguments. Drops are only counted here, so a filter for
+// another sensor selects none, and they are not counted per listener, so
+// neither does a filter for a listener or port.
+func (db *DB) dropsFilter(f StatsFilter) (string, []any) {
+	var where []string
+	var args []any
+	if f.Sensor != "" && f.Sensor != db.sensorID || f.Listener != "" || f.Port != 0 {
+		where = append(where, "0")
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "minute >= ?")
+		args = append(args, f.Since.Local())
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "minute < ?")
+		args = append(args, f.Until.Local())
+	}
+	if cond, condArgs := prefixCondition(f.IP); cond != "" {
+		where = append(where, cond)
+		args = append(args, condArgs...)
+	}
+	return whereClause(where), args
+}
+
+// QueryPrefixStats returns statistics within f like QuerySourceStats, but
+// grouped by network: IPv4 addresses by their /v4Bits prefix and IPv6
+// addresses by their /v6Bits prefix, where 0 keeps each address apart.
+// Stored values that aren't addresses, such as pseudonyms, stay as they are.
+func (db *DB) QueryPrefixStats(f StatsFilter, v4Bits, v6Bits int) ([]SourceStats, error) {
+	if v4Bits < 0 || v4Bits > 32 {
+		return nil, fmt.Errorf("IPv4 prefix length %d out of range (0-32)", v4Bits)
+	}
+	if v6Bits < 0 || v6Bits > 128 {
+		return nil, fmt.Errorf("IPv6 prefix length %d out of range (0-128)", v6Bits)
+	}
+
+	// Masking isn't possible in SQL, so per-address rows are merged here
+	groups := make(map[string]*SourceStats)
+	groupName := func(ip string, key []byte) string {
+		if len(key) != 16 {
+			return ip
+		}
+		addr := netip.AddrFrom16([16]byte(key)).Unmap()
+		bits := v6Bits
+		if addr.Is4() {
+			bits = v4Bits
+		}
+		if bits == 0 {
+			return ip
+		}
+		// bits is within the family's range, checked above
+		prefix, _ := addr.Prefix(bits)
+		return prefix.String()
+	}
+	add := func(ip string, key []byte, first, last string) (*SourceStats, error) {
+		name := groupName(ip, key)
+		firstSeen, err := parseTimestamp(first)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse first_seen: %w", err)
+		}
+		lastSeen, err := parseTimestamp(last)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse last_seen: %w", err)
+		}
+		g, ok := groups[name]
+		if !ok {
+			g = &SourceStats{IPAddress: name, FirstSeen: firstSeen, LastSeen: lastSeen}
+			groups[name] = g
+		}
+		if firstSeen.Before(g.FirstSeen) {
+			g.FirstSeen = firstSeen
+		}
+		if lastSeen.After(g.LastSeen) {
+			g.LastSeen = lastSeen
+		}
+		return g, nil
+	}
+
+	ips, args := rollupQuery(f, ipRollups)
+	err := db.eachSourceRow(`
+		WITH ips AS (`+ips+`)
+		SELECT ip_address, ip_bin, SUM(count), MIN(first_seen), MAX(last_seen)
+		FROM ips
+		GROUP BY ip_address`, args, func(rows *sql.Rows) error {
+		var ip, first, last string
+		var key []byte
+		var count int64
+		if err := rows.Scan(&ip, &key, &count, &first, &last); err != nil {
+			return fmt.Errorf("failed to scan source stats: %w", err)
+		}
+		g, err := add(ip, key, first, last)
+		if err != nil {
+			return err
+		}
+		g.Count += count
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Distinct URLs are exact where the raw logs cover f, as in
+	// distinctQuery, and add up those of each address and bucket before
+	older, covered, hasOlder, hasCovered, err := db.splitCoverage(f)
+	if err != nil {
+		return nil, err
+	}
+	if hasOlder {
+		ips, args := rollupQuery(older, ipRollups)
+		err := db.eachSourceRow(`
+			WITH ips AS (`+ips+`)
+			SELECT ip_address, ip_bin, SUM(unique_urls)
+			FROM ips
+			GROUP BY ip_address`, args, func(rows *sql.Rows) error {
+			var ip string
+			var key []byte
+			var urls int64
+			if err := rows.Scan(&ip, &key, &urls); err != nil {
+				return fmt.Errorf("failed to scan source stats: %w", err)
+			}
+			if g := groups[groupName(ip, key)]; g != nil {
+				g.UniqueURLs += urls
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if hasCovered {
+		urls := make(map[string]map[string]bool)
+		where, args := rawConditions(covered, covered.Since, covered.Until)
+		err := db.eachSourceRow(`
+			SELECT DISTINCT ip_address, ip_bin, url
+			FROM request_logs`+whereClause(where), args, func(rows *sql.Rows) error {
+			var ip, url string
+			var key []byte
+			if err := rows.Scan(&ip, &key, &url); err != nil {
+				return fmt.Errorf("failed to scan source stats: %w", err)
+			}
+			name := groupName(ip, key)
+			if urls[name] == nil {
+				urls[name] = make(map[string]bool)
+			}
+			urls[name][url] = true
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		for name, set := range urls {
+			if g := groups[name]; g != nil {
+				g.UniqueURLs += int64(len(set))
+			}
+		}
+	}
+
+	where, args := db.dropsFilter(f)
+	err = db.eachSourceRow(`
+		SELECT ip_address, ip_bin, SUM(count), MIN(minute), MAX(minute)
+		FROM rate_limit_drops`+where+`
+		GROUP BY ip_address`, args, func(rows *sql.Rows) error {
+		var ip, first, last string
+		var key []byte
+		var count int64
+		if err := rows.Scan(&ip, &key, &count, &first, &last); err != nil {
+			return fmt.Errorf("failed to scan source stats: %w", err)
+		}
+		g, err := add(ip, key, first, last)
+		if err != nil {
+			return err
+		}
+		g.RateLimited += count
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	where, args = connectionsFilter(f)
+	err = db.eachSourceRow(`
+		SELECT ip_address, ip_bin, COUNT(*), MIN(timestamp), MAX(timestamp)
+		FROM tcp_connections`+where+`
+		GROUP BY ip_address`, args, func(rows *sql.Rows) error {
+		var ip, first, last string
+		var key []byte
+		var count int64
+		if err := rows.Scan(&ip, &key, &count, &first, &last); err != nil {
+			return fmt.Errorf("failed to scan source stats: %w", err)
+		}
+		g, err := add(ip, key, first, last)
+		if err != nil {
+			return err
+		}
+		g.Connections += count
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]SourceStats, 0, len(groups))
+	for _, g := range groups {
+		stats = append(stats, *g)
+	}
+	slices.SortFunc(stats, func(a, b SourceStats) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(b.Connections, a.Connections),
+			cmp.Compare(b.RateLimited, a.RateLimited), strings.Compare(a.IPAddress, b.IPAddress))
+	})
+	return stats, nil
+}
+
+// eachSourceRow runs a source stats query and calls fn for each row
+func (db *DB) eachSourceRow(query string, args []any, fn func(*sql.Rows) error) error {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query source stats: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			// Ignore close errors
+		}
+	}()
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("source stats iteration error: %w", err)
+	}
+	return nil
+}
+
+// QuerySensorStats returns statistics grouped by sensor within f. Requests
+// logged without a sensor ID are grouped under "".
+func (db *DB) QuerySensorStats(f StatsFilter) ([]SensorStats, error) {
+	query, args := breakdownQuery(f, "sensor_id")
+	query += " ORDER BY r.count DESC, r.sensor_id"
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sensor stats: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			// Ignore close errors
+		}
+	}()
+
+	var stats []SensorStats
+	for rows.Next() {
+		var s SensorStats
+		var firstSeen, lastSeen string
+		if err := rows.Scan(&s.Sensor, &s.Count, &firstSeen, &lastSeen, &s.UniqueIPs, &s.UniqueURLs); err != nil {
+			return nil, fmt.Errorf("failed to scan sensor stats: %w", err)
+		}
+		if s.FirstSeen, err = parseTimestamp(firstSeen); err != nil {
+			return nil, fmt.Errorf("failed to parse first_seen: %w", err)
+		}
+		if s.LastSeen, err = parseTimestamp(lastSeen); err != nil {
+			return nil, fmt.Errorf("failed to parse last_seen: %w", err)
+		}
+		stats = append(stats, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("sensor stats iteration error: %w", err)
+	}
+
+	return stats, nil
+}
+
+// QueryListenerStats returns statistics within f grouped by the listener
+// and local port the requests arrived on. Requests logged before listeners
+// were recorded, or forwarded by sensors that don't record them, are
+// grouped under "" and port 0.
+func (db *DB) QueryListenerStats(f StatsFilter) ([]ListenerStats, error) {
+	query, args := breakdownQuery(f, "listener, local_port")
+	query += " ORDER BY r.count DESC, r.listener, r.local_port"
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query listener stats: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			// Ignore close errors
+		}
+	}()
+
+	var stats []ListenerStats
+	for rows.Next() {
+		var s ListenerStats
+		var firstSeen, lastSeen string
+		if err := rows.Scan(&s.Listener, &s.Port, &s.Count, &firstSeen, &lastSeen, &s.UniqueIPs, &s.UniqueURLs); err != nil {
+			return nil, fmt.Errorf("failed to scan listener stats: %w", err)
+		}
+		if s.FirstSeen, err = parseTimestamp(firstSeen); err != nil {
+			return nil, fmt.Errorf("failed to parse first_seen: %w", err)
+		}
+		if s.LastSeen, err = parseTimestamp(lastSeen); err != nil {
+			return nil, fmt.Errorf("failed to parse last_seen: %w", err)
+		}
+		stats = append(stats, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("listener stats iteration error: %w", err)
+	}
+
+	return stats, nil
+}
+
+// QuerySummary returns overall statistics within f
+func (db *DB) QuerySummary(f StatsFilter) (*Summary, error) {
+	counts, args := rollupQuery(f, countsKind(f))
+	ips, ipArgs := rollupQuery(f, ipRollups)
+	urls, urlArgs := rollupQuery(f, urlRollups)
+	args = append(append(args, ipArgs...), urlArgs...)
+	query := `
+		WITH counts AS (` + counts + `), ips AS (` + ips + `), urls AS (` + urls + `)
+		SELECT
+			(SELECT COALESCE(SUM(count), 0) FROM counts) as total_requests,
+			(SELECT COUNT(DISTINCT ip_address) FROM ips) as unique_ips,
+			(SELECT COUNT(DISTINCT url) FROM urls) as unique_urls,
+			(SELECT MIN(first_seen) FROM counts) as first_request,
+			(SELECT MAX(last_seen) FROM counts) as last_request
+	`
+
+	var summary Summary
+	var firstRequest, lastRequest sql.NullString
+	err := db.conn.QueryRow(query, args...).Scan(
+		&summary.TotalRequests,
+		&summary.UniqueIPs,
+		&summary.UniqueURLs,
+		&firstRequest,
+		&lastRequest,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query summary stats: %w", err)
+	}
+
+	// Timestamps are NULL when nothing was logged in the range
+	if firstRequest.Valid {
+		if summary.FirstRequest, err = parseTimestamp(firstRequest.String); err != nil {
+			return nil, fmt.Errorf("failed to parse first_request: %w", err)
+		}
+	}
+	if lastRequest.Valid {
+		if summary.LastRequest, err = parseTimestamp(lastRequest.String); err != nil {
+			return nil, fmt.Errorf("failed to parse last_request: %w", err)
+		}
+	}
+
+	if summary.IPModes, err = db.IPModes(f); err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// PurgeHourlyRollupsBefore deletes hourly rollup buckets that end by
+// cutoff and returns the number of rows deleted
+func (db *DB) PurgeHourlyRollupsBefore(cutoff time.Time) (int64, error) {
+	return db.PurgeHourlyRollups(context.Background(), cutoff, BatchOptions{})
+}
+
+// PurgeDailyRollupsBefore deletes daily rollup buckets that end by cutoff,
+// so a partly expired day is kept, and returns the number of rows deleted
+func (db *DB) PurgeDailyRollupsBefore(cutoff time.Time) (int64, error) {
+	return db.PurgeDailyRollups(context.Background(), cutoff, BatchOptions{})
+}
+
+// OldestHourlyRollup returns the start of the oldest hourly rollup bucket,
+// or the zero time when there is none
+func (db *DB) OldestHourlyRollup() (time.Time, error) {
+	var bucket sql.NullString
+	if err := db.conn.QueryRow("SELECT MIN(bucket) FROM " + totalRollups.table("hourly")).Scan(&bucket); err != nil {
+		return time.Time{}, fmt.Errorf("failed to query oldest rollup: %w", err)
+	}
+	if !bucket.Valid {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation(bucketLayout, bucket.String, time.UTC)
+}
@@ -0,0 +1,433 @@
+package database
+
+import (
+	"net/netip"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPlanSegments(t *testing.T) {
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 1, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name         string
+		since, until time.Time
+		want         []segment
+	}{
+		{"unbounded", time.Time{}, time.Time{}, []segment{
+			{"daily", time.Time{}, time.Time{}},
+		}},
+		{"ragged", at(1, 10, 30), at(3, 5, 15), []segment{
+			{"", at(1, 10, 30), at(1, 11, 0)},
+			{"hourly", at(1, 11, 0), at(2, 0, 0)},
+			{"daily", at(2, 0, 0), at(3, 0, 0)},
+			{"hourly", at(3, 0, 0), at(3, 5, 0)},
+			{"", at(3, 5, 0), at(3, 5, 15)},
+		}},
+		{"whole days", at(1, 0, 0), at(3, 0, 0), []segment{
+			{"daily", at(1, 0, 0), at(3, 0, 0)},
+		}},
+		{"since only", at(1, 23, 0), time.Time{}, []segment{
+			{"hourly", at(1, 23, 0), at(2, 0, 0)},
+			{"daily", at(2, 0, 0), time.Time{}},
+		}},
+		{"until only", time.Time{}, at(5, 12, 0), []segment{
+			{"daily", time.Time{}, at(5, 0, 0)},
+			{"hourly", at(5, 0, 0), at(5, 12, 0)},
+		}},
+		{"within an hour", at(1, 10, 5), at(1, 10, 55), []segment{
+			{"", at(1, 10, 5), at(1, 10, 55)},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := planSegments(tt.since, tt.until, rollupLevels)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("planSegments() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// insertLog adds a request log at a given time, as the import does
+func insertLog(t *testing.T, db *DB, ip, url string, ts time.Time) {
+	t.Helper()
+	ip, ipBin, ipFamily := storedAddress(ip, nil)
+	parts := splitURL(url)
+	if _, err := db.conn.Exec(`INSERT INTO request_logs (ip_address, ip_bin, ip_family, url, path, query, params, norm_path, timestamp)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, ip, ipBin, ipFamily, url, parts.path, parts.query, parts.params, parts.normPath, ts.Local()); err != nil {
+		t.Fatalf("Failed to insert log: %v", err)
+	}
+}
+
+func TestQueryStats_MatchesRawLogs(t *testing.T) {
+	db := setupTestDB(t)
+
+	base := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	logs := []struct {
+		ip, url string
+		offset  time.Duration
+	}{
+		{"192.0.2.1", "/a", 30 * time.Minute},
+		{"192.0.2.1", "/a", 45 * time.Minute},
+		{"192.0.2.2", "/a", 5 * time.Hour},
+		{"192.0.2.1", "/b", 26 * time.Hour},
+		{"192.0.2.3", "/b", 26*time.Hour + 10*time.Minute},
+		{"192.0.2.3", "/a", 49 * time.Hour},
+		{"192.0.2.4", "/c", 73*time.Hour + 59*time.Minute},
+	}
+	for _, l := range logs {
+		insertLog(t, db, l.ip, l.url, base.Add(l.offset))
+	}
+
+	filters := []StatsFilter{
+		{},
+		{Since: base.Add(40 * time.Minute)},
+		{Until: base.Add(49*time.Hour + time.Minute)},
+		{Since: base.Add(26*time.Hour + 5*time.Minute), Until: base.Add(74 * time.Hour)},
+		{Since: base.Add(24 * time.Hour), Until: base.Add(48 * time.Hour)},
+		{Since: base.Add(10 * time.Minute), Until: base.Add(31 * time.Minute)},
+	}
+	for _, f := range filters {
+		// Count the expected results straight from the test data
+		var total int64
+		ips, urls := map[string]bool{}, map[string]bool{}
+		perURL := map[string]int64{}
+		urlIPs, ipURLs := map[string]map[string]bool{}, map[string]map[string]bool{}
+		for _, l := range logs {
+			ts := base.Add(l.offset)
+			if (!f.Since.IsZero() && ts.Before(f.Since)) || (!f.Until.IsZero() && !ts.Before(f.Until)) {
+				continue
+			}
+			total++
+			ips[l.ip] = true
+			urls[l.url] = true
+			perURL[l.url]++
+			if urlIPs[l.url] == nil {
+				urlIPs[l.url] = map[string]bool{}
+			}
+			urlIPs[l.url][l.ip] = true
+			if ipURLs[l.ip] == nil {
+				ipURLs[l.ip] = map[string]bool{}
+			}
+			ipURLs[l.ip][l.url] = true
+		}
+
+		summary, err := db.QuerySummary(f)
+		if err != nil {
+			t.Fatalf("QuerySummary(%+v) failed: %v", f, err)
+		}
+		if summary.TotalRequests != total || summary.UniqueIPs != int64(len(ips)) || summary.UniqueURLs != int64(len(urls)) {
+			t.Errorf("QuerySummary(%+v) = %d requests, %d IPs, %d URLs; want %d, %d, %d",
+				f, summary.TotalRequests, summary.UniqueIPs, summary.UniqueURLs, total, len(ips), len(urls))
+		}
+
+		endpoints, err := db.QueryEndpointStats(f)
+		if err != nil {
+			t.Fatalf("QueryEndpointStats(%+v) failed: %v", f, err)
+		}
+		if len(endpoints) != len(perURL) {
+			t.Errorf("QueryEndpointStats(%+v) returned %d URLs, want %d", f, len(endpoints), len(perURL))
+		}
+		for _, e := range endpoints {
+			if e.Count != perURL[e.URL] || e.UniqueIPs != int64(len(urlIPs[e.URL])) {
+				t.Errorf("QueryEndpointStats(%+v) counted %d from %d IPs for %s, want %d from %d",
+					f, e.Count, e.UniqueIPs, e.URL, perURL[e.URL], len(urlIPs[e.URL]))
+			}
+		}
+
+		sources, err := db.QuerySourceStats(f)
+		if err != nil {
+			t.Fatalf("QuerySourceStats(%+v) failed: %v", f, err)
+		}
+		if len(sources) != len(ips) {
+			t.Errorf("QuerySourceStats(%+v) returned %d IPs, want %d", f, len(sources), len(ips))
+		}
+		for _, s := range sources {
+			if s.UniqueURLs != int64(len(ipURLs[s.IPAddress])) {
+				t.Errorf("QuerySourceStats(%+v) counted %d URLs for %s, want %d", f, s.UniqueURLs, s.IPAddress, len(ipURLs[s.IPAddress]))
+			}
+		}
+	}
+}
+
+func TestRollups_OutliveRawLogs(t *testing.T) {
+	db := setupTestDB(t)
+
+	old := time.Now().AddDate(0, 0, -100)
+	insertLog(t, db, "192.0.2.1", "/old", old)
+	insertLog(t, db, "192.0.2.2", "/old", old.Add(time.Minute))
+	if err := db.LogRequest("192.0.2.3", "/new"); err != nil {
+		t.Fatalf("Failed to log request: %v", err)
+	}
+
+	if _, err := db.CleanupOldLogs(30); err != nil {
+		t.Fatalf("Failed to clean up logs: %v", err)
+	}
+	summary, err := db.GetSummary()
+	if err != nil {
+		t.Fatalf("Failed to get summary: %v", err)
+	}
+	if summary.TotalRequests != 3 || summary.UniqueIPs != 3 {
+		t.Errorf("Expected rollups to keep all 3 requests, got %+v", summary)
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -90)
+	hourly, err := db.PurgeHourlyRollupsBefore(cutoff)
+	if err != nil {
+		t.Fatalf("Failed to purge hourly rollups: %v", err)
+	}
+	daily, err := db.PurgeDailyRollupsBefore(cutoff)
+	if err != nil {
+		t.Fatalf("Failed to purge daily rollups: %v", err)
+	}
+	// The total, the URL and each old IP, at each level
+	if hourly != 4 || daily != 4 {
+		t.Errorf("Expected 4 rollup rows deleted at each level, got %d hourly and %d daily", hourly, daily)
+	}
+	if oldest, err := db.OldestHourlyRollup(); err != nil || oldest.Before(cutoff) {
+		t.Errorf("Expected no hourly rollups before the cutoff, oldest is %v (%v)", oldest, err)
+	}
+	summary, err = db.GetSummary()
+	if err != nil {
+		t.Fatalf("Failed to get summary: %v", err)
+	}
+	if summary.TotalRequests != 1 {
+		t.Errorf("Expected 1 request after rollup cleanup, got %d", summary.TotalRequests)
+	}
+}
+
+func TestRollups_UniqueCounts(t *testing.T) {
+	db := setupTestDB(t)
+
+	hour := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	insertLog(t, db, "192.0.2.1", "/a", hour)
+	insertLog(t, db, "192.0.2.1", "/a", hour.Add(time.Minute))
+	insertLog(t, db, "192.0.2.2", "/a", hour.Add(2*time.Minute))
+	insertLog(t, db, "192.0.2.1", "/b", hour.Add(3*time.Minute))
+	insertLog(t, db, "192.0.2.1", "/a", hour.Add(time.Hour))
+
+	var uniqueIPs, uniqueURLs int64
+	if err := db.conn.QueryRow("SELECT SUM(unique_ips) FROM rollup_urls_hourly WHERE url = '/a'").Scan(&uniqueIPs); err != nil {
+		t.Fatalf("Failed to read URL rollups: %v", err)
+	}
+	if err := db.conn.QueryRow("SELECT SUM(unique_urls) FROM rollup_ips_hourly WHERE ip_address = '192.0.2.1'").Scan(&uniqueURLs); err != nil {
+		t.Fatalf("Failed to read address rollups: %v", err)
+	}
+	// Two addresses in the first hour and one again in the next
+	if uniqueIPs != 3 {
+		t.Errorf("Expected 3 addresses summed over the hours of /a, got %d", uniqueIPs)
+	}
+	if uniqueURLs != 3 {
+		t.Errorf("Expected 3 URLs summed over the hours of 192.0.2.1, got %d", uniqueURLs)
+	}
+
+	// Whole days and the summary count each address once
+	f := StatsFilter{Since: hour.Truncate(24 * time.Hour), Until: hour.Truncate(24*time.Hour).AddDate(0, 0, 1)}
+	endpoints, err := db.QueryEndpointStats(f)
+	if err != nil {
+		t.Fatalf("Failed to get endpoint stats: %v", err)
+	}
+	if len(endpoints) != 2 || endpoints[0].URL != "/a" || endpoints[0].Count != 4 || endpoints[0].UniqueIPs != 2 {
+		t.Errorf("Expected /a with 4 requests from 2 addresses in the daily rollup, got %+v", endpoints)
+	}
+	summary, err := db.QuerySummary(StatsFilter{Since: hour, Until: hour.Add(2 * time.Hour)})
+	if err != nil {
+		t.Fatalf("Failed to get summary: %v", err)
+	}
+	if summary.TotalRequests != 5 || summary.UniqueIPs != 2 || summary.UniqueURLs != 2 {
+		t.Errorf("Expected 5 requests from 2 addresses to 2 URLs, got %+v", summary)
+	}
+}
+
+func TestRollups_UniqueCountsOutOfOrder(t *testing.T) {
+	db := setupTestDB(t)
+
+	// Imported and forwarded requests arrive out of time order
+	day := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	insertLog(t, db, "192.0.2.1", "/a", day)
+	insertLog(t, db, "192.0.2.1", "/a", day.AddDate(0, 0, 1))
+	insertLog(t, db, "192.0.2.1", "/a", day.Add(time.Minute))
+
+	var uniqueIPs int64
+	if err := db.conn.QueryRow("SELECT unique_ips FROM rollup_urls_daily WHERE url = '/a' AND bucket = ?",
+		day.Truncate(24*time.Hour).Format(bucketLayout)).Scan(&uniqueIPs); err != nil {
+		t.Fatalf("Failed to read URL rollups: %v", err)
+	}
+	if uniqueIPs != 1 {
+		t.Errorf("Expected 1 address for /a on the first day, got %d", uniqueIPs)
+	}
+
+	// An address seen on several days counts once while the raw logs
+	// cover the range
+	f := StatsFilter{Since: day.Truncate(24 * time.Hour), Until: day.AddDate(0, 0, 7)}
+	endpoints, err := db.QueryEndpointStats(f)
+	if err != nil {
+		t.Fatalf("Failed to get endpoint stats: %v", err)
+	}
+	if len(endpoints) != 1 || endpoints[0].Count != 3 || endpoints[0].UniqueIPs != 1 {
+		t.Errorf("Expected /a with 3 requests from 1 address, got %+v", endpoints)
+	}
+	sources, err := db.QuerySourceStats(f)
+	if err != nil {
+		t.Fatalf("Failed to get source stats: %v", err)
+	}
+	if len(sources) != 1 || sources[0].UniqueURLs != 1 {
+		t.Errorf("Expected 192.0.2.1 with 1 URL, got %+v", sources)
+	}
+
+	// and once per day when only the rollups are left
+	if _, err := db.conn.Exec("DELETE FROM request_logs"); err != nil {
+		t.Fatalf("Failed to delete logs: %v", err)
+	}
+	endpoints, err = db.QueryEndpointStats(f)
+	if err != nil {
+		t.Fatalf("Failed to get endpoint stats: %v", err)
+	}
+	if len(endpoints) != 1 || endpoints[0].Count != 3 || endpoints[0].UniqueIPs != 2 {
+		t.Errorf("Expected /a with 3 requests and 2 daily addresses, got %+v", endpoints)
+	}
+}
+
+func TestMigrate_BackfillsRollups(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "backfill.db")
+
+	db, err := Open(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("Failed to close database: %v", err)
+		}
+	}()
+
+	// A version 2 database with logs from before the rollups existed
+	for _, m := range migrations[:2] {
+		if _, err := db.conn.Exec(m.sql); err != nil {
+			t.Fatalf("Failed to apply migration %d: %v", m.Version, err)
+		}
+	}
+	if _, err := db.conn.Exec("PRAGMA user_version = 2"); err != nil {
+		t.Fatalf("Failed to set user_version: %v", err)
+	}
+	ts := time.Date(2026, 2, 1, 12, 0, 0, 0, time.UTC)
+	for _, l := range []struct {
+		ip, url string
+		ts      time.Time
+	}{
+		{"192.0.2.1", "/a", ts},
+		{"192.0.2.1", "/a", ts.Add(time.Minute)},
+		{"192.0.2.2", "/b", ts.Add(25 * time.Hour)},
+	} {
+		if _, err := db.conn.Exec("INSERT INTO request_logs (ip_address, url, timestamp) VALUES (?, ?, ?)",
+			l.ip, l.url, l.ts.Local()); err != nil {
+			t.Fatalf("Failed to insert log: %v", err)
+		}
+	}
+
+	if _, err := db.Migrate(); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+
+	// Delete the raw logs so only the rollups can answer
+	if _, err := db.PurgeBefore(ts.Add(48 * time.Hour)); err != nil {
+		t.Fatalf("Failed to purge logs: %v", err)
+	}
+	endpoints, err := db.GetEndpointStats()
+	if err != nil {
+		t.Fatalf("Failed to get endpoint stats: %v", err)
+	}
+	if len(endpoints) != 2 || endpoints[0].URL != "/a" || endpoints[0].Count != 2 || endpoints[0].UniqueIPs != 1 {
+		t.Errorf("Unexpected endpoint stats after backfill: %+v", endpoints)
+	}
+	if !endpoints[0].FirstSeen.Equal(ts) || !endpoints[0].LastSeen.Equal(ts.Add(time.Minute)) {
+		t.Errorf("Expected first and last seen from the logs, got %v and %v", endpoints[0].FirstSeen, endpoints[0].LastSeen)
+	}
+
+	// The rollups get address keys too
+	sources, err := db.QuerySourceStats(StatsFilter{IP: netip.MustParsePrefix("192.0.2.2/32")})
+	if err != nil {
+		t.Fatalf("Failed to get source stats: %v", err)
+	}
+	if len(sources) != 1 || sources[0].IPAddress != "192.0.2.2" || sources[0].Count != 1 || sources[0].UniqueURLs != 1 {
+		t.Errorf("Expected only 192.0.2.2 from the filtered rollups, got %+v", sources)
+	}
+
+	// URLs by address are only known from the raw logs
+	endpoints, err = db.QueryEndpointStats(StatsFilter{IP: netip.MustParsePrefix("192.0.2.2/32")})
+	if err != nil {
+		t.Fatalf("Failed to get endpoint stats: %v", err)
+	}
+	if len(endpoints) != 0 {
+		t.Errorf("Expected no endpoints by address without raw logs, got %+v", endpoints)
+	}
+}
+
+func TestQueryListenerStats(t *testing.T) {
+	db := setupTestDB(t)
+
+	// Two days back, so statistics come from the rollups as well
+	old := time.Now().AddDate(0, 0, -2)
+	if _, err := db.LogSensorRequests("", []SensorRequest{
+		{Timestamp: old, IPAddress: "192.0.2.1", URL: "/", Details: RequestDetails{Listener: "alt-http", LocalPort: 8080}},
+		{Timestamp: old, IPAddress: "192.0.2.2", URL: "/", Details: RequestDetails{Listener: "alt-http", LocalPort: 8000}},
+	}); err != nil {
+		t.Fatalf("LogSensorRequests failed: %v", err)
+	}
+	for _, ip := range []string{"192.0.2.1", "198.51.100.1", "198.51.100.2"} {
+		if err := db.LogRequestDetails(ip, "/.env", RequestDetails{Listener: "web", LocalPort: 80}); err != nil {
+			t.Fatalf("LogRequestDetails failed: %v", err)
+		}
+	}
+	if err := db.LogRequest("203.0.113.1", "/unlabelled"); err != nil {
+		t.Fatalf("LogRequest failed: %v", err)
+	}
+	if err := db.RecordRateLimitDrops([]RateLimitDrop{
+		{IPAddress: "203.0.113.9", Minute: time.Now(), RouteGroup: "catchall", Scope: "per_ip", Count: 4},
+	}); err != nil {
+		t.Fatalf("Failed to record drops: %v", err)
+	}
+
+	stats, err := db.QueryListenerStats(StatsFilter{})
+	if err != nil {
+		t.Fatalf("QueryListenerStats failed: %v", err)
+	}
+	if len(stats) != 4 {
+		t.Fatalf("Expected 4 listener and port pairs, got %+v", stats)
+	}
+	if s := stats[0]; s.Listener != "web" || s.Port != 80 || s.Count != 3 || s.UniqueIPs != 3 || s.UniqueURLs != 1 {
+		t.Errorf("Expected web on port 80 first with 3 requests, got %+v", s)
+	}
+	if s := stats[3]; s.Listener != "alt-http" || s.Port != 8080 || s.Count != 1 {
+		t.Errorf("Expected alt-http on 8080 last, got %+v", s)
+	}
+
+	summary, err := db.QuerySummary(StatsFilter{Listener: "alt-http", Since: old.Add(-time.Hour)})
+	if err != nil {
+		t.Fatalf("Failed to get summary: %v", err)
+	}
+	if summary.TotalRequests != 2 {
+		t.Errorf("Expected 2 alt-http requests, got %d", summary.TotalRequests)
+	}
+	endpoints, err := db.QueryEndpointStats(StatsFilter{Port: 80})
+	if err != nil {
+		t.Fatalf("Failed to get endpoint stats: %v", err)
+	}
+	if len(endpoints) != 1 || endpoints[0].URL != "/.env" || endpoints[0].Count != 3 {
+		t.Errorf("Expected only port 80's endpoint, got %+v", endpoints)
+	}
+
+	// Rate limit drops are not counted per listener
+	sources, err := db.QuerySourceStats(StatsFilter{Listener: "web"})
+	if err != nil {
+		t.Fatalf("Failed to get source stats: %v", err)
+	}
+	if len(sources) != 3 {
+		t.Errorf("Expected web's three sources without the drops, got %+v", sources)
+	}
+}
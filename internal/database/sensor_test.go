@@ -0,0 +1,110 @@
+package database
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLogSensorRequests(t *testing.T) {
+	db := setupTestDB(t)
+	db.SetSensorID("central")
+
+	// Two days back, so statistics come from the rollups as well
+	old := time.Now().AddDate(0, 0, -2)
+	n, err := db.LogSensorRequests("edge-1", []SensorRequest{
+		{Timestamp: old, IPAddress: "192.0.2.1", URL: "/wp-login.php"},
+		{Timestamp: old.Add(time.Minute), IPAddress: "192.0.2.2", URL: "/wp-login.php",
+			Details: RequestDetails{UserAgent: "scanner", Headers: http.Header{"X-Probe": {"jndi"}}}},
+	})
+	if err != nil {
+		t.Fatalf("LogSensorRequests failed: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Expected 2 requests stored, got %d", n)
+	}
+	if _, err := db.LogSensorRequests("edge-2", []SensorRequest{{IPAddress: "198.51.100.1", URL: "/.env"}}); err != nil {
+		t.Fatalf("LogSensorRequests failed: %v", err)
+	}
+	if err := db.LogRequest("203.0.113.1", "/local"); err != nil {
+		t.Fatalf("LogRequest failed: %v", err)
+	}
+	if err := db.RecordRateLimitDrops([]RateLimitDrop{
+		{IPAddress: "203.0.113.9", Minute: time.Now(), RouteGroup: "catchall", Scope: "per_ip", Count: 4},
+	}); err != nil {
+		t.Fatalf("Failed to record drops: %v", err)
+	}
+
+	logs, err := db.QueryLogs(LogFilter{Sensor: "edge-1", Ascending: true})
+	if err != nil {
+		t.Fatalf("Failed to query logs: %v", err)
+	}
+	if len(logs) != 2 || logs[0].Sensor != "edge-1" || logs[0].IPAddress != "192.0.2.1" {
+		t.Errorf("Expected the two edge-1 logs, got %+v", logs)
+	}
+	logs, err = db.QueryLogs(LogFilter{Sensor: "central"})
+	if err != nil {
+		t.Fatalf("Failed to query logs: %v", err)
+	}
+	if len(logs) != 1 || logs[0].URL != "/local" {
+		t.Errorf("Expected the local request tagged with the collector's ID, got %+v", logs)
+	}
+
+	sensors, err := db.QuerySensorStats(StatsFilter{})
+	if err != nil {
+		t.Fatalf("QuerySensorStats failed: %v", err)
+	}
+	if len(sensors) != 3 {
+		t.Fatalf("Expected 3 sensors, got %+v", sensors)
+	}
+	if s := sensors[0]; s.Sensor != "edge-1" || s.Count != 2 || s.UniqueIPs != 2 || s.UniqueURLs != 1 {
+		t.Errorf("Expected edge-1 first with 2 requests from 2 IPs, got %+v", s)
+	}
+
+	endpoints, err := db.QueryEndpointStats(StatsFilter{Sensor: "edge-2"})
+	if err != nil {
+		t.Fatalf("Failed to get endpoint stats: %v", err)
+	}
+	if len(endpoints) != 1 || endpoints[0].URL != "/.env" {
+		t.Errorf("Expected only edge-2's endpoint, got %+v", endpoints)
+	}
+
+	// Rate limit drops belong to the instance that counted them
+	sources, err := db.QuerySourceStats(StatsFilter{Sensor: "edge-1"})
+	if err != nil {
+		t.Fatalf("Failed to get source stats: %v", err)
+	}
+	if len(sources) != 2 {
+		t.Errorf("Expected edge-1's two sources without the local drops, got %+v", sources)
+	}
+	sources, err = db.QuerySourceStats(StatsFilter{Sensor: "central"})
+	if err != nil {
+		t.Fatalf("Failed to get source stats: %v", err)
+	}
+	if len(sources) != 2 {
+		t.Errorf("Expected the local source and the dropped one, got %+v", sources)
+	}
+
+	summary, err := db.QuerySummary(StatsFilter{Sensor: "edge-1", Since: old.Add(-time.Hour)})
+	if err != nil {
+		t.Fatalf("Failed to get summary: %v", err)
+	}
+	if summary.TotalRequests != 2 {
+		t.Errorf("Expected 2 edge-1 requests in the range, got %d", summary.TotalRequests)
+	}
+
+	hits, err := db.Search(SearchFilter{Query: "jndi", Sensor: "edge-1"})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(hits) != 1 || hits[0].Sensor != "edge-1" {
+		t.Errorf("Expected the edge-1 request with the header, got %+v", hits)
+	}
+	hits, err = db.Search(SearchFilter{Query: "jndi", Sensor: "edge-2"})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(hits) != 0 {
+		t.Errorf("Expected no edge-2 hits, got %+v", hits)
+	}
+}
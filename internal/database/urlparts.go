@@ -0,0 +1,171 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Each request URL is also stored decomposed: path and query hold the raw
+// path and query string, params the decoded query parameters as a JSON
+// object, and norm_path the path normalized so that requests for the same
+// resource group together. The rollups carry path, query and norm_path, so
+// endpoint statistics can be grouped by any of them.
+
+// Endpoint statistics groupings
+const (
+	GroupByURL        = "url"        // the URL as requested
+	GroupByPath       = "path"       // the raw path, without the query
+	GroupByQuery      = "query"      // the raw query string
+	GroupByNormalized = "normalized" // the normalized path
+)
+
+// groupColumns maps the groupings to their columns
+var groupColumns = map[string]string{
+	GroupByURL:        "url",
+	GroupByPath:       "path",
+	GroupByQuery:      "query",
+	GroupByNormalized: "norm_path",
+}
+
+// ValidGrouping reports whether by is one of the endpoint groupings
+func ValidGrouping(by string) bool {
+	_, ok := groupColumns[by]
+	return ok
+}
+
+// Path segments replaced by placeholders when normalizing
+var (
+	numericSegment = regexp.MustCompile(`^[0-9]+$`)
+	uuidSegment    = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+)
+
+// urlParts is a request URL decomposed for storage
+type urlParts struct {
+	path     string
+	query    string
+	params   any // JSON text, or nil without a query
+	normPath string
+}
+
+// splitURL decomposes a stored URL. Absolute URLs, as sent to proxies,
+// lose their scheme and host, and fragments are dropped.
+func splitURL(raw string) urlParts {
+	path, query, _ := strings.Cut(raw, "?")
+	if u, err := url.Parse(raw); err == nil && u.IsAbs() {
+		path, query = u.EscapedPath(), u.RawQuery
+	}
+	path, _, _ = strings.Cut(path, "#")
+	query, _, _ = strings.Cut(query, "#")
+
+	parts := urlParts{path: path, query: query, normPath: normalizePath(path)}
+	if query != "" {
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			// Pairs that fail to decode are skipped; the raw query keeps them
+		}
+		params := make(map[string][]string, len(values))
+		for name, list := range values {
+			name = cleanText(name)
+			for _, v := range list {
+				params[name] = append(params[name], cleanText(v))
+			}
+		}
+		if data, err := json.Marshal(params); err == nil {
+			parts.params = string(data)
+		}
+	}
+	return parts
+}
+
+// normalizePath decodes a path one segment at a time, collapses repeated
+// slashes and replaces numeric IDs and UUIDs with {id} and {uuid}. An
+// encoded slash stays encoded, so decoding never adds a segment, and
+// encodings that don't decode are kept as they are.
+func normalizePath(path string) string {
+	segments := strings.Split(path, "/")
+	kept := segments[:0]
+	for i, seg := range segments {
+		if seg == "" && i > 0 && i < len(segments)-1 {
+			continue
+		}
+		if decoded, err := url.PathUnescape(seg); err == nil {
+			seg = strings.ReplaceAll(cleanText(decoded), "/", "%2F")
+		}
+		switch {
+		case numericSegment.MatchString(seg):
+			seg = "{id}"
+		case uuidSegment.MatchString(seg):
+			seg = "{uuid}"
+		}
+		kept = append(kept, seg)
+	}
+	return strings.Join(kept, "/")
+}
+
+// cleanText drops control characters and invalid UTF-8 from decoded text
+func cleanText(s string) string {
+	if !utf8.ValidString(s) {
+		s = strings.ToValidUTF8(s, "\uFFFD")
+	}
+	return sanitizeInput(s, len(s))
+}
+
+// backfillURLParts decomposes the URLs stored before the parts existed,
+// joined back in through a temporary table like backfillIPKeys
+func backfillURLParts(tx *sql.Tx) error {
+	tables := []string{"request_logs", "rollup_hourly", "rollup_daily"}
+	var selects []string
+	for _, table := range tables {
+		selects = append(selects, "SELECT url FROM "+table)
+	}
+	rows, err := tx.Query(strings.Join(selects, " UNION "))
+	if err != nil {
+		return fmt.Errorf("failed to query URLs: %w", err)
+	}
+	var urls []string
+	for rows.Next() {
+		var u string
+		if err := rows.Scan(&u); err != nil {
+			if closeErr := rows.Close(); closeErr != nil {
+				// Ignore close errors
+			}
+			return fmt.Errorf("failed to scan URL: %w", err)
+		}
+		urls = append(urls, u)
+	}
+	if err := rows.Close(); err != nil {
+		return fmt.Errorf("failed to read URLs: %w", err)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("URL iteration error: %w", err)
+	}
+
+	if _, err := tx.Exec("CREATE TEMP TABLE url_parts (url TEXT PRIMARY KEY, path TEXT, query TEXT, params TEXT, norm_path TEXT)"); err != nil {
+		return fmt.Errorf("failed to create URL table: %w", err)
+	}
+	for _, u := range urls {
+		p := splitURL(u)
+		if _, err := tx.Exec("INSERT INTO url_parts VALUES (?, ?, ?, ?, ?)", u, p.path, p.query, p.params, p.normPath); err != nil {
+			return fmt.Errorf("failed to store URL parts: %w", err)
+		}
+	}
+	for _, table := range tables {
+		set := "path = p.path, query = p.query, norm_path = p.norm_path"
+		if table == "request_logs" {
+			set += ", params = p.params"
+		}
+		if _, err := tx.Exec(`UPDATE ` + table + ` SET ` + set + `
+			FROM url_parts p WHERE p.url = ` + table + `.url`); err != nil {
+			return fmt.Errorf("failed to backfill %s: %w", table, err)
+		}
+	}
+	if _, err := tx.Exec("DROP TABLE temp.url_parts"); err != nil {
+		return fmt.Errorf("failed to drop URL table: %w", err)
+	}
+	return nil
+}
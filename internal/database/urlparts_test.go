@@ -0,0 +1,76 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSplitURL(t *testing.T) {
+	tests := []struct {
+		url, path, query, params, normPath string
+	}{
+		{"/wp-login.php?x=1", "/wp-login.php", "x=1", `{"x":["1"]}`, "/wp-login.php"},
+		{"/users/42/posts/7", "/users/42/posts/7", "", "", "/users/{id}/posts/{id}"},
+		{"/items/3F2504E0-4F89-11D3-9A0C-0305E82C3301", "/items/3F2504E0-4F89-11D3-9A0C-0305E82C3301", "", "", "/items/{uuid}"},
+		{"//admin///login/", "//admin///login/", "", "", "/admin/login/"},
+		{"/caf%C3%A9/a%2Fb", "/caf%C3%A9/a%2Fb", "", "", "/café/a%2Fb"},
+		{"/bad%zz/%00x", "/bad%zz/%00x", "", "", "/bad%zz/x"},
+		{"/search?q=a+b&q=%3Cscript%3E&t", "/search", "q=a+b&q=%3Cscript%3E&t", `{"q":["a b","\u003cscript\u003e"],"t":[""]}`, "/search"},
+		{"/q?bad=%zz&ok=1", "/q", "bad=%zz&ok=1", `{"ok":["1"]}`, "/q"},
+		{"http://example.com/proxy/1?a=b#frag", "/proxy/1", "a=b", `{"a":["b"]}`, "/proxy/{id}"},
+		{"", "", "", "", ""},
+	}
+	for _, tt := range tests {
+		p := splitURL(tt.url)
+		var params string
+		if p.params != nil {
+			params = p.params.(string)
+		}
+		if p.path != tt.path || p.query != tt.query || params != tt.params || p.normPath != tt.normPath {
+			t.Errorf("splitURL(%q) = %q, %q, %s, %q; want %q, %q, %s, %q",
+				tt.url, p.path, p.query, params, p.normPath, tt.path, tt.query, tt.params, tt.normPath)
+		}
+	}
+}
+
+func TestQueryEndpointStatsBy(t *testing.T) {
+	db := setupTestDB(t)
+	base := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	insertLog(t, db, "192.0.2.1", "/wp-login.php?x=1", base)
+	insertLog(t, db, "192.0.2.2", "/wp-login.php?x=2", base.Add(time.Minute))
+	insertLog(t, db, "192.0.2.1", "/users/1", base.Add(2*time.Minute))
+	insertLog(t, db, "192.0.2.3", "/users/2?x=1", base.Add(3*time.Minute))
+
+	tests := []struct {
+		by   string
+		want map[string]int64
+	}{
+		{GroupByURL, map[string]int64{"/wp-login.php?x=1": 1, "/wp-login.php?x=2": 1, "/users/1": 1, "/users/2?x=1": 1}},
+		{GroupByPath, map[string]int64{"/wp-login.php": 2, "/users/1": 1, "/users/2": 1}},
+		{GroupByQuery, map[string]int64{"x=1": 2, "x=2": 1, "": 1}},
+		{GroupByNormalized, map[string]int64{"/wp-login.php": 2, "/users/{id}": 2}},
+	}
+	for _, tt := range tests {
+		stats, err := db.QueryEndpointStatsBy(StatsFilter{}, tt.by)
+		if err != nil {
+			t.Fatalf("QueryEndpointStatsBy(%s) failed: %v", tt.by, err)
+		}
+		got := make(map[string]int64)
+		for _, s := range stats {
+			got[s.URL] = s.Count
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.by, tt.want, got)
+			continue
+		}
+		for url, count := range tt.want {
+			if got[url] != count {
+				t.Errorf("%s: expected %d for %q, got %d", tt.by, count, url, got[url])
+			}
+		}
+	}
+
+	if _, err := db.QueryEndpointStatsBy(StatsFilter{}, "host"); err == nil {
+		t.Error("Expected an unknown grouping to fail")
+	}
+}
@@ -0,0 +1,156 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotFound is returned when updating a record that doesn't exist
+var ErrNotFound = errors.New("not found")
+
+// ErrExists is returned when creating a record whose key is taken
+var ErrExists = errors.New("already exists")
+
+// User is a web interface and API account
+type User struct {
+	Username     string
+	PasswordHash string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// APIToken is a named bearer token; only its hash is stored
+type APIToken struct {
+	Name      string
+	TokenHash string
+	CreatedAt time.Time
+}
+
+// CreateUser adds a user, returning ErrExists if the username is taken
+func (db *DB) CreateUser(username, passwordHash string) error {
+	now := time.Now()
+	result, err := db.conn.Exec(`
+		INSERT INTO users (username, password_hash, created_at, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (username) DO NOTHING
+	`, username, passwordHash, now, now)
+	if err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	} else if n == 0 {
+		return fmt.Errorf("user %q: %w", username, ErrExists)
+	}
+	return nil
+}
+
+// SetPassword replaces a user's password hash, returning ErrNotFound for unknown users
+func (db *DB) SetPassword(username, passwordHash string) error {
+	result, err := db.conn.Exec(`
+		UPDATE users SET password_hash = ?, updated_at = ? WHERE username = ?
+	`, passwordHash, time.Now(), username)
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	} else if n == 0 {
+		return fmt.Errorf("user %q: %w", username, ErrNotFound)
+	}
+	return nil
+}
+
+// DeleteUser removes a user and reports whether it existed
+func (db *DB) DeleteUser(username string) (bool, error) {
+	result, err := db.conn.Exec(`DELETE FROM users WHERE username = ?`, username)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete user: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return n > 0, nil
+}
+
+// GetUser retrieves a user, returning nil if it doesn't exist
+func (db *DB) GetUser(username string) (*User, error) {
+	var u User
+	err := db.conn.QueryRow(`
+		SELECT username, password_hash, created_at, updated_at FROM users WHERE username = ?
+	`, username).Scan(&u.Username, &u.PasswordHash, &u.CreatedAt, &u.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user: %w", err)
+	}
+	return &u, nil
+}
+
+// CreateAPIToken stores a token hash under a name, returning ErrExists if the name is taken
+func (db *DB) CreateAPIToken(name, tokenHash string) error {
+	result, err := db.conn.Exec(`
+		INSERT INTO api_tokens (name, token_hash, created_at) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO NOTHING
+	`, name, tokenHash, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to create API token: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	} else if n == 0 {
+		return fmt.Errorf("token %q: %w", name, ErrExists)
+	}
+	return nil
+}
+
+// DeleteAPIToken removes a token and reports whether it existed
+func (db *DB) DeleteAPIToken(name string) (bool, error) {
+	result, err := db.conn.Exec(`DELETE FROM api_tokens WHERE name = ?`, name)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete API token: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return n > 0, nil
+}
+
+// GetAPITokenByHash retrieves the token with the given hash, returning nil if none matches
+func (db *DB) GetAPITokenByHash(tokenHash string) (*APIToken, error) {
+	var t APIToken
+	err := db.conn.QueryRow(`
+		SELECT name, token_hash, created_at FROM api_tokens WHERE token_hash = ?
+	`, tokenHash).Scan(&t.Name, &t.TokenHash, &t.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query API token: %w", err)
+	}
+	return &t, nil
+}
+
+// CountCredentials returns the number of users plus API tokens
+func (db *DB) CountCredentials() (int, error) {
+	var n int
+	err := db.conn.QueryRow(`SELECT (SELECT COUNT(*) FROM users) + (SELECT COUNT(*) FROM api_tokens)`).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count credentials: %w", err)
+	}
+	return n, nil
+}
+
+// CountUsers returns the number of users
+func (db *DB) CountUsers() (int, error) {
+	var n int
+	if err := db.conn.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to count users: %w", err)
+	}
+	return n, nil
+}
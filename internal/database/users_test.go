@@ -0,0 +1,83 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUsers(t *testing.T) {
+	db := setupTestDB(t)
+
+	if err := db.CreateUser("alice", "hash1"); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if err := db.CreateUser("alice", "hash2"); !errors.Is(err, ErrExists) {
+		t.Errorf("Expected ErrExists for duplicate user, got %v", err)
+	}
+
+	u, err := db.GetUser("alice")
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	if u == nil || u.PasswordHash != "hash1" || u.CreatedAt.IsZero() {
+		t.Fatalf("Unexpected user: %+v", u)
+	}
+
+	if err := db.SetPassword("alice", "hash3"); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+	if u, _ := db.GetUser("alice"); u == nil || u.PasswordHash != "hash3" {
+		t.Errorf("Expected updated password hash, got %+v", u)
+	}
+	if err := db.SetPassword("bob", "hash"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for unknown user, got %v", err)
+	}
+
+	missing, err := db.GetUser("bob")
+	if err != nil {
+		t.Fatalf("Failed to get missing user: %v", err)
+	}
+	if missing != nil {
+		t.Errorf("Expected nil for missing user, got %+v", missing)
+	}
+}
+
+func TestAPITokens(t *testing.T) {
+	db := setupTestDB(t)
+
+	if n, _ := db.CountCredentials(); n != 0 {
+		t.Fatalf("Expected no credentials, got %d", n)
+	}
+
+	if err := db.CreateAPIToken("ci", "abc123"); err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+	if err := db.CreateAPIToken("ci", "def456"); !errors.Is(err, ErrExists) {
+		t.Errorf("Expected ErrExists for duplicate token name, got %v", err)
+	}
+	if err := db.CreateUser("alice", "hash"); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if n, _ := db.CountCredentials(); n != 2 {
+		t.Errorf("Expected 2 credentials, got %d", n)
+	}
+
+	tok, err := db.GetAPITokenByHash("abc123")
+	if err != nil {
+		t.Fatalf("Failed to get token: %v", err)
+	}
+	if tok == nil || tok.Name != "ci" {
+		t.Fatalf("Unexpected token: %+v", tok)
+	}
+
+	deleted, err := db.DeleteAPIToken("ci")
+	if err != nil || !deleted {
+		t.Fatalf("Expected token to be deleted, got %v, %v", deleted, err)
+	}
+	if tok, _ := db.GetAPITokenByHash("abc123"); tok != nil {
+		t.Errorf("Expected revoked token to be gone, got %+v", tok)
+	}
+	if deleted, _ := db.DeleteAPIToken("ci"); deleted {
+		t.Error("Expected second delete to report nothing removed")
+	}
+}
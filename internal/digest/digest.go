@@ -0,0 +1,90 @@
+// Package digest builds summary reports of the logged requests for a
+// period and emails them to recipients on their schedules
+package digest
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/database"
+)
+
+//go:embed templates/*
+var templatesFS embed.FS
+
+// Report templates
+var (
+	htmlTemplate = htmltemplate.Must(htmltemplate.ParseFS(templatesFS, "templates/digest.html"))
+	textTemplate = texttemplate.Must(texttemplate.ParseFS(templatesFS, "templates/digest.txt"))
+)
+
+// Store answers the report's queries
+type Store interface {
+	QuerySummary(f database.StatsFilter) (*database.Summary, error)
+	QueryEndpointStats(f database.StatsFilter) ([]database.EndpointStats, error)
+	QuerySourceStats(f database.StatsFilter) ([]database.SourceStats, error)
+	NewSources(since time.Time, minURLs int) ([]database.NewSource, error)
+}
+
+// Report summarizes the requests logged in [Since, Until)
+type Report struct {
+	Title      string
+	Since      time.Time
+	Until      time.Time
+	Summary    database.Summary
+	Endpoints  []database.EndpointStats // the most requested URLs
+	Sources    []database.SourceStats   // the busiest addresses
+	NewSources []database.NewSource     // the busiest addresses first seen in the period
+	// NewSourceCount counts all addresses with no request in the logs
+	// before the period
+	NewSourceCount int
+}
+
+// Build gathers the report for [since, until), listing top entries of each
+// kind
+func Build(store Store, title string, since, until time.Time, top int) (*Report, error) {
+	f := database.StatsFilter{Since: since, Until: until}
+	summary, err := store.QuerySummary(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query summary: %w", err)
+	}
+	endpoints, err := store.QueryEndpointStats(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query endpoints: %w", err)
+	}
+	sources, err := store.QuerySourceStats(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sources: %w", err)
+	}
+	newSources, err := store.NewSources(since, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Report{
+		Title:          title,
+		Since:          since,
+		Until:          until,
+		Summary:        *summary,
+		Endpoints:      endpoints[:min(top, len(endpoints))],
+		Sources:        sources[:min(top, len(sources))],
+		NewSources:     newSources[:min(top, len(newSources))],
+		NewSourceCount: len(newSources),
+	}, nil
+}
+
+// Render returns the report as plain text and as HTML
+func (r *Report) Render() ([]byte, []byte, error) {
+	var text, html bytes.Buffer
+	if err := textTemplate.Execute(&text, r); err != nil {
+		return nil, nil, fmt.Errorf("failed to render text digest: %w", err)
+	}
+	if err := htmlTemplate.Execute(&html, r); err != nil {
+		return nil, nil, fmt.Errorf("failed to render HTML digest: %w", err)
+	}
+	return text.Bytes(), html.Bytes(), nil
+}
@@ -0,0 +1,346 @@
+package digest
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/config"
+	"github.com/dangogh/silver-eureka/internal/database"
+)
+
+// smtpServer is an in-process SMTP server that accepts every message,
+// recording the envelope, the credentials and the data
+type smtpServer struct {
+	addr      *net.TCPAddr
+	tlsConfig *tls.Config // the server's certificate
+	roots     *x509.CertPool
+
+	mu       sync.Mutex
+	auth     string // decoded AUTH PLAIN response
+	tls      bool   // whether the last session used STARTTLS
+	from, to string
+	data     []byte
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := ln.Close(); err != nil {
+			t.Errorf("Failed to close listener: %v", err)
+		}
+	})
+	s := &smtpServer{
+		addr:      ln.Addr().(*net.TCPAddr),
+		tlsConfig: &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}},
+		roots:     roots,
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// serve runs one SMTP session
+func (s *smtpServer) serve(conn net.Conn) {
+	defer func() {
+		if err := conn.Close(); err != nil {
+			// The client has gone
+		}
+	}()
+	secure := false
+	r := textproto.NewReader(bufio.NewReader(conn))
+	reply := func(lines ...string) bool {
+		for i, line := range lines {
+			sep := " "
+			if i < len(lines)-1 {
+				sep = "-"
+			}
+			if _, err := io.WriteString(conn, line[:3]+sep+line[4:]+"\r\n"); err != nil {
+				return false
+			}
+		}
+		return true
+	}
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			if secure {
+				reply("250 localhost", "250 AUTH PLAIN")
+			} else {
+				reply("250 localhost", "250 STARTTLS")
+			}
+		case "STARTTLS":
+			reply("220 ready")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, secure = tlsConn, true
+			r = textproto.NewReader(bufio.NewReader(conn))
+		case "AUTH":
+			response, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(arg, "PLAIN "))
+			if err != nil {
+				reply("501 bad response")
+				continue
+			}
+			s.mu.Lock()
+			s.auth = string(response)
+			s.mu.Unlock()
+			reply("235 ok")
+		case "MAIL":
+			s.mu.Lock()
+			s.from, s.tls = arg, secure
+			s.mu.Unlock()
+			reply("250 ok")
+		case "RCPT":
+			s.mu.Lock()
+			s.to = arg
+			s.mu.Unlock()
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			data, err := r.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.data = data
+			s.mu.Unlock()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+// newTestManager returns a Manager sending through s, with requests logged
+// in its database
+func newTestManager(t *testing.T, s *smtpServer) *Manager {
+	t.Helper()
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("Failed to close database: %v", err)
+		}
+	})
+	for _, req := range []struct{ ip, url string }{
+		{"192.0.2.1", "/wp-login.php"},
+		{"192.0.2.1", "/wp-login.php"},
+		{"192.0.2.2", "/wp-login.php"},
+		{"192.0.2.2", "/.env"},
+		{"192.0.2.3", "/<script>"},
+	} {
+		if err := db.LogRequest(req.ip, req.url); err != nil {
+			t.Fatalf("LogRequest failed: %v", err)
+		}
+	}
+
+	cfg := config.DefaultDigestConfig()
+	cfg.SMTP.Host = "127.0.0.1"
+	cfg.SMTP.Port = s.addr.Port
+	cfg.SMTP.Username = "digest"
+	cfg.SMTP.Password = "secret"
+	cfg.From = "Silver Eureka <digest@example.com>"
+	cfg.Top = 2
+	cfg.Recipients = []config.DigestRecipient{{Address: "ops@example.com", Schedule: config.DigestDaily}}
+	m, err := NewManager(db, cfg)
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	m.mailer.tlsConfig = &tls.Config{ServerName: "127.0.0.1", RootCAs: s.roots, MinVersion: tls.VersionTLS12}
+	return m
+}
+
+// parts decodes a multipart message, returning its subject and the
+// decoded body of each part by content type
+func parts(t *testing.T, data []byte) (string, map[string]string) {
+	t.Helper()
+	msg, err := mail.ReadMessage(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatalf("Failed to read message: %v", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		t.Fatalf("Failed to decode subject: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Unexpected content type %q: %v", msg.Header.Get("Content-Type"), err)
+	}
+	bodies := make(map[string]string)
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read part: %v", err)
+		}
+		// multipart.Reader undoes the quoted-printable encoding
+		body, err := io.ReadAll(p)
+		if err != nil {
+			t.Fatalf("Failed to read part body: %v", err)
+		}
+		contentType, _, _ := strings.Cut(p.Header.Get("Content-Type"), ";")
+		bodies[contentType] = string(body)
+	}
+	return subject, bodies
+}
+
+func TestSendTest(t *testing.T) {
+	s := newSMTPServer(t)
+	m := newTestManager(t, s)
+
+	if err := m.SendTest("someone@example.com"); err == nil {
+		t.Error("Expected a test digest to an unconfigured address to be refused")
+	}
+	if err := m.SendTest("ops@example.com"); err != nil {
+		t.Fatalf("SendTest failed: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.tls {
+		t.Error("Expected the message to be sent after STARTTLS")
+	}
+	if s.auth != "\x00digest\x00secret" {
+		t.Errorf("AUTH PLAIN response = %q", s.auth)
+	}
+	if s.from != "FROM:<digest@example.com>" || s.to != "TO:<ops@example.com>" {
+		t.Errorf("Unexpected envelope %q, %q", s.from, s.to)
+	}
+
+	subject, bodies := parts(t, s.data)
+	if subject != "[Test] Request digest" {
+		t.Errorf("Subject = %q", subject)
+	}
+	text, html := bodies["text/plain"], bodies["text/html"]
+	for _, want := range []string{"Requests:     5", "Unique IPs:   3", "New sources:  3", "/wp-login.php (2 IPs)", "192.0.2.1 (1 URLs)"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Text part missing %q:\n%s", want, text)
+		}
+	}
+	if strings.Count(text, "/.env") != 0 {
+		t.Errorf("Expected the text part to list only the top 2 endpoints:\n%s", text)
+	}
+	if !strings.Contains(html, "/wp-login.php") || strings.Contains(html, "<script>") {
+		t.Errorf("Expected an escaped HTML part listing the endpoints:\n%s", html)
+	}
+}
+
+func TestSend_RequiresStartTLS(t *testing.T) {
+	s := newSMTPServer(t)
+	m := newTestManager(t, s)
+	m.mailer.tlsConfig = &tls.Config{ServerName: "127.0.0.1", MinVersion: tls.VersionTLS12}
+
+	// The server's certificate is not trusted, so the upgrade fails and
+	// nothing is sent in the clear
+	if err := m.SendTest("ops@example.com"); err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Errorf("Expected a STARTTLS failure, got %v", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.auth != "" || s.data != nil {
+		t.Error("Expected no credentials or message after a failed STARTTLS")
+	}
+}
+
+func TestNextSend(t *testing.T) {
+	// Monday 2 March 2026, 09:30
+	after := time.Date(2026, 3, 2, 9, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		r    config.DigestRecipient
+		want time.Time
+	}{
+		{"daily later today", config.DigestRecipient{Schedule: config.DigestDaily, At: "17:00"},
+			time.Date(2026, 3, 2, 17, 0, 0, 0, time.UTC)},
+		{"daily tomorrow", config.DigestRecipient{Schedule: config.DigestDaily},
+			time.Date(2026, 3, 3, 8, 0, 0, 0, time.UTC)},
+		{"daily at the same minute", config.DigestRecipient{Schedule: config.DigestDaily, At: "09:30"},
+			time.Date(2026, 3, 3, 9, 30, 0, 0, time.UTC)},
+		{"weekly next monday", config.DigestRecipient{Schedule: config.DigestWeekly},
+			time.Date(2026, 3, 9, 8, 0, 0, 0, time.UTC)},
+		{"weekly friday", config.DigestRecipient{Schedule: config.DigestWeekly, Weekday: "Friday", At: "06:15"},
+			time.Date(2026, 3, 6, 6, 15, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextSend(tt.r, after); !got.Equal(tt.want) {
+				t.Errorf("nextSend = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Across a daylight saving change the local time of day is kept
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("No time zone data: %v", err)
+	}
+	got := nextSend(config.DigestRecipient{Schedule: config.DigestDaily}, time.Date(2026, 3, 7, 9, 0, 0, 0, loc))
+	if want := time.Date(2026, 3, 8, 8, 0, 0, 0, loc); !got.Equal(want) || got.Hour() != 8 {
+		t.Errorf("nextSend = %v, want %v", got, want)
+	}
+}
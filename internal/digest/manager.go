@@ -0,0 +1,170 @@
+package digest
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/config"
+	"github.com/dangogh/silver-eureka/internal/metrics"
+)
+
+// Manager sends the digest to each recipient on its schedule
+type Manager struct {
+	store  Store
+	cfg    config.DigestConfig
+	mailer *mailer
+
+	done chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+	now  func() time.Time
+}
+
+// NewManager creates a Manager; Start begins sending on schedule
+func NewManager(store Store, cfg config.DigestConfig) (*Manager, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &Manager{
+		store:  store,
+		cfg:    cfg,
+		mailer: &mailer{cfg: cfg.SMTP},
+		done:   make(chan struct{}),
+		now:    time.Now,
+	}, nil
+}
+
+// Start sends digests as they fall due until Stop is called. It does
+// nothing when digests are not enabled.
+func (m *Manager) Start() {
+	if !m.cfg.Enabled() {
+		return
+	}
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		last := m.now()
+		for {
+			next := m.nextDue(last)
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-timer.C:
+			case <-m.done:
+				timer.Stop()
+				return
+			}
+			for _, r := range m.cfg.Recipients {
+				if at := nextSend(r, last); !at.After(next) {
+					if err := m.Send(r, at); err != nil {
+						slog.Error("Failed to send digest", "to", r.Address, "error", err)
+					}
+				}
+			}
+			last = next
+		}
+	}()
+}
+
+// Stop ends scheduling, waiting for a digest being sent
+func (m *Manager) Stop() {
+	m.once.Do(func() {
+		close(m.done)
+		m.wg.Wait()
+	})
+}
+
+// Enabled reports whether digests are configured
+func (m *Manager) Enabled() bool {
+	return m.cfg.Enabled()
+}
+
+// Recipients returns the configured addresses
+func (m *Manager) Recipients() []string {
+	addrs := make([]string, len(m.cfg.Recipients))
+	for i, r := range m.cfg.Recipients {
+		addrs[i] = r.Address
+	}
+	return addrs
+}
+
+// Send emails r the digest for the period ending at until
+func (m *Manager) Send(r config.DigestRecipient, until time.Time) error {
+	title, since := "Daily request digest", until.AddDate(0, 0, -1)
+	if r.Schedule == config.DigestWeekly {
+		title, since = "Weekly request digest", until.AddDate(0, 0, -7)
+	}
+	return m.send(r.Address, title, since, until)
+}
+
+// SendTest emails a configured recipient the digest for the last 24 hours
+func (m *Manager) SendTest(to string) error {
+	for _, r := range m.cfg.Recipients {
+		if r.Address == to {
+			until := m.now()
+			return m.send(r.Address, "[Test] Request digest", until.Add(-24*time.Hour), until)
+		}
+	}
+	return fmt.Errorf("%q is not a digest recipient", to)
+}
+
+// send builds and emails one digest
+func (m *Manager) send(to, title string, since, until time.Time) error {
+	err := m.deliver(to, title, since, until)
+	if err != nil {
+		metrics.DigestsSent.WithLabelValues("error").Inc()
+		return err
+	}
+	metrics.DigestsSent.WithLabelValues("ok").Inc()
+	slog.Info("Digest sent", "to", to, "since", since, "until", until)
+	return nil
+}
+
+// deliver builds, renders and mails a digest
+func (m *Manager) deliver(to, title string, since, until time.Time) error {
+	report, err := Build(m.store, title, since, until, m.cfg.Top)
+	if err != nil {
+		return err
+	}
+	text, html, err := report.Render()
+	if err != nil {
+		return err
+	}
+	msg, err := message(m.cfg.From, to, title, m.now(), text, html)
+	if err != nil {
+		return err
+	}
+	return m.mailer.send(m.cfg.From, to, msg)
+}
+
+// nextDue returns the earliest send time of any recipient after t
+func (m *Manager) nextDue(t time.Time) time.Time {
+	var next time.Time
+	for _, r := range m.cfg.Recipients {
+		if at := nextSend(r, t); next.IsZero() || at.Before(next) {
+			next = at
+		}
+	}
+	return next
+}
+
+// nextSend returns the first time after t that r is due a digest, in t's
+// location
+func nextSend(r config.DigestRecipient, t time.Time) time.Time {
+	// Both were checked by Validate
+	offset, _ := r.TimeOfDay()
+	weekday, _ := r.Day()
+	hour, minute := int(offset/time.Hour), int(offset%time.Hour/time.Minute)
+
+	for days := 0; ; days++ {
+		at := time.Date(t.Year(), t.Month(), t.Day()+days, hour, minute, 0, 0, t.Location())
+		if !at.After(t) {
+			continue
+		}
+		if r.Schedule == config.DigestWeekly && at.Weekday() != weekday {
+			continue
+		}
+		return at
+	}
+}
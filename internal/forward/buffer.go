@@ -0,0 +1,141 @@
+package forward
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// bufferFile holds the buffered messages, one per line
+	bufferFile = "messages"
+
+	// offsetFile holds the position in bufferFile of the first message
+	// not yet sent, so that a restart doesn't send the others again
+	offsetFile = "offset"
+
+	// offsetEvery is how many messages are sent between saves of the offset
+	offsetEvery = 1000
+)
+
+// errBufferFull is returned by push when the buffer is at its size limit
+var errBufferFull = errors.New("syslog buffer full")
+
+// buffer is an on-disk queue of messages waiting for the collector. It is
+// emptied only once every message in it has been sent.
+type buffer struct {
+	dir    string
+	max    int64
+	file   *os.File
+	size   int64 // bytes in the file
+	offset int64 // bytes already sent
+}
+
+// openBuffer opens the buffer in dir, creating it if needed, with the
+// messages left by an earlier run
+func openBuffer(dir string, max int64) (*buffer, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create buffer dir: %w", err)
+	}
+	file, err := os.OpenFile(filepath.Join(dir, bufferFile), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open buffer: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		if err := file.Close(); err != nil {
+			// Already failing
+		}
+		return nil, fmt.Errorf("failed to open buffer: %w", err)
+	}
+
+	b := &buffer{dir: dir, max: max, file: file, size: info.Size()}
+	if data, err := os.ReadFile(filepath.Join(dir, offsetFile)); err == nil {
+		offset, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		if err == nil && offset >= 0 && offset <= b.size {
+			b.offset = offset
+		}
+	}
+	return b, nil
+}
+
+// pending reports whether messages are waiting
+func (b *buffer) pending() bool {
+	return b.offset < b.size
+}
+
+// push appends msg, which must not contain a line break
+func (b *buffer) push(msg []byte) error {
+	if b.size+int64(len(msg))+1 > b.max {
+		return errBufferFull
+	}
+	n, err := b.file.Write(append(msg, '\n'))
+	b.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write buffer: %w", err)
+	}
+	return nil
+}
+
+// drain sends the waiting messages in order until send fails, then
+// empties the buffer if all were sent
+func (b *buffer) drain(send func([]byte) error) error {
+	r := bufio.NewReader(io.NewSectionReader(b.file, b.offset, b.size-b.offset))
+	for sent := 1; ; sent++ {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return b.saveOffset(fmt.Errorf("failed to read buffer: %w", err))
+		}
+		if err := send(bytes.TrimSuffix(line, []byte("\n"))); err != nil {
+			return b.saveOffset(err)
+		}
+		b.offset += int64(len(line))
+		if sent%offsetEvery == 0 {
+			if err := b.saveOffset(nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := b.file.Truncate(0); err != nil {
+		return b.saveOffset(fmt.Errorf("failed to empty buffer: %w", err))
+	}
+	b.size, b.offset = 0, 0
+	return b.saveOffset(nil)
+}
+
+// saveOffset records the send position, returning cause or the error
+// saving it
+func (b *buffer) saveOffset(cause error) error {
+	path := filepath.Join(b.dir, offsetFile)
+	var err error
+	if b.offset == 0 {
+		err = os.Remove(path)
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+	} else {
+		err = os.WriteFile(path, []byte(strconv.FormatInt(b.offset, 10)), 0o600)
+	}
+	if cause != nil {
+		return cause
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save buffer offset: %w", err)
+	}
+	return nil
+}
+
+// close closes the buffer file
+func (b *buffer) close() error {
+	return b.file.Close()
+}
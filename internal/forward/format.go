@@ -0,0 +1,102 @@
+package forward
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/dangogh/silver-eureka/internal/config"
+	"github.com/dangogh/silver-eureka/internal/sink"
+)
+
+// Device fields of the CEF and LEEF headers
+const (
+	vendor  = "silver-eureka"
+	product = "gather-requests"
+	version = "1.0"
+	eventID = "http-request"
+)
+
+// severityInfo is the syslog severity of a logged request: informational
+const severityInfo = 6
+
+// rfc5424Time is the RFC 5424 timestamp, limited to microseconds
+const rfc5424Time = "2006-01-02T15:04:05.000000Z07:00"
+
+// message renders e in format, wrapped in an RFC 5424 syslog header. The
+// result never contains a line break, so the buffer can store one message
+// per line.
+func message(format string, facility int, hostname, appName string, e sink.Event) []byte {
+	var body string
+	if format == config.SyslogLEEF {
+		body = leef(e)
+	} else {
+		body = cef(e)
+	}
+	return fmt.Appendf(nil, "<%d>1 %s %s %s - request - %s",
+		facility*8+severityInfo, e.Time.Format(rfc5424Time), hostname, appName, body)
+}
+
+var (
+	// cefValueEscaper escapes CEF extension values
+	cefValueEscaper = strings.NewReplacer(`\`, `\\`, "=", `\=`, "\r", `\r`, "\n", `\n`)
+	// leefValueEscaper keeps LEEF values off the attribute delimiter and
+	// out of the next line
+	leefValueEscaper = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+)
+
+// cef renders e in ArcSight Common Event Format
+func cef(e sink.Event) string {
+	ext := []string{"rt=" + strconv.FormatInt(e.Time.UnixMilli(), 10)}
+	add := func(key, value string) {
+		if value != "" {
+			ext = append(ext, key+"="+cefValueEscaper.Replace(value))
+		}
+	}
+	if _, err := netip.ParseAddr(e.IPAddress); err == nil {
+		add("src", e.IPAddress)
+	} else {
+		// src must be an IP, which anonymized addresses are not
+		add("cs1Label", "clientId")
+		add("cs1", e.IPAddress)
+	}
+	add("requestMethod", e.Method)
+	add("request", e.URL)
+	add("dhost", e.Host)
+	if e.Port != 0 {
+		add("dpt", strconv.Itoa(e.Port))
+	}
+	add("requestClientApplication", e.UserAgent)
+	if e.Listener != "" {
+		add("cs2Label", "listener")
+		add("cs2", e.Listener)
+	}
+	return fmt.Sprintf("CEF:0|%s|%s|%s|%s|HTTP request logged|3|%s",
+		vendor, product, version, eventID, strings.Join(ext, " "))
+}
+
+// leef renders e in Log Event Extended Format 1.0, with tab-separated
+// attributes
+func leef(e sink.Event) string {
+	attrs := []string{
+		"devTime=" + e.Time.UTC().Format("Jan 02 2006 15:04:05.000 MST"),
+		"devTimeFormat=MMM dd yyyy HH:mm:ss.SSS z",
+		"src=" + leefValueEscaper.Replace(e.IPAddress),
+		"method=" + leefValueEscaper.Replace(e.Method),
+		"url=" + leefValueEscaper.Replace(e.URL),
+	}
+	if e.Host != "" {
+		attrs = append(attrs, "dstHost="+leefValueEscaper.Replace(e.Host))
+	}
+	if e.Port != 0 {
+		attrs = append(attrs, "dstPort="+strconv.Itoa(e.Port))
+	}
+	if e.UserAgent != "" {
+		attrs = append(attrs, "userAgent="+leefValueEscaper.Replace(e.UserAgent))
+	}
+	if e.Listener != "" {
+		attrs = append(attrs, "listener="+leefValueEscaper.Replace(e.Listener))
+	}
+	return fmt.Sprintf("LEEF:1.0|%s|%s|%s|%s|%s", vendor, product, version, eventID, strings.Join(attrs, "\t"))
+}
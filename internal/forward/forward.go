@@ -0,0 +1,288 @@
+// Package forward sends each logged request to a syslog collector as an
+// RFC 5424 message in CEF or LEEF format, buffering messages on disk while
+// the collector can't be reached. A Forwarder is an event sink fed by the
+// sink pipeline.
+package forward
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/config"
+	"github.com/dangogh/silver-eureka/internal/metrics"
+	"github.com/dangogh/silver-eureka/internal/sink"
+)
+
+const (
+	// queueSize is the number of events that can wait to be sent; more
+	// are dropped
+	queueSize = 1000
+
+	// ioTimeout bounds connecting to and writing to the collector
+	ioTimeout = 10 * time.Second
+)
+
+// Forwarder sends events to the collector from a single goroutine, in the
+// order they arrive. When a send fails the message and those after it go
+// to the buffer, which is retried every retry interval.
+type Forwarder struct {
+	cfg       config.SyslogConfig
+	facility  int
+	hostname  string
+	tlsConfig *tls.Config
+	buffer    *buffer
+	queue     chan sink.Event
+	conn      net.Conn
+
+	done chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+	now  func() time.Time
+}
+
+// New creates a Forwarder with the buffer left by an earlier run; Start
+// begins sending
+func New(cfg config.SyslogConfig) (*Forwarder, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if !cfg.Enabled() {
+		return nil, fmt.Errorf("syslog address must be set")
+	}
+
+	hostname := cfg.Hostname
+	if hostname == "" {
+		hostname = localHostname()
+	}
+	host, _, _ := net.SplitHostPort(cfg.Address)
+	tlsConfig := &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read syslog CA file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+	}
+
+	buf, err := openBuffer(cfg.BufferDir, int64(cfg.BufferMaxMB)<<20)
+	if err != nil {
+		return nil, err
+	}
+	metrics.SyslogBufferBytes.Set(float64(buf.size - buf.offset))
+
+	return &Forwarder{
+		cfg:       cfg,
+		facility:  config.SyslogFacilities[cfg.Facility],
+		hostname:  hostname,
+		tlsConfig: tlsConfig,
+		buffer:    buf,
+		queue:     make(chan sink.Event, queueSize),
+		done:      make(chan struct{}),
+		now:       time.Now,
+	}, nil
+}
+
+// localHostname returns the host's name as allowed in a syslog header, or
+// the nil value "-"
+func localHostname() string {
+	name, err := os.Hostname()
+	if err != nil || name == "" {
+		return "-"
+	}
+	return strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// Start sends queued events until Stop is called
+func (f *Forwarder) Start() {
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		ticker := time.NewTicker(f.cfg.RetryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case e := <-f.queue:
+				f.handle(e)
+			case <-ticker.C:
+				f.flush()
+			case <-f.done:
+				f.shutdown()
+				return
+			}
+		}
+	}()
+}
+
+// Stop sends or buffers the queued events and closes the connection
+func (f *Forwarder) Stop() {
+	f.once.Do(func() {
+		close(f.done)
+		f.wg.Wait()
+	})
+}
+
+// shutdown buffers what is still queued, unless it can be sent at once
+func (f *Forwarder) shutdown() {
+	for {
+		select {
+		case e := <-f.queue:
+			f.handle(e)
+		default:
+			f.disconnect()
+			if err := f.buffer.close(); err != nil {
+				slog.Error("Failed to close syslog buffer", "error", err)
+			}
+			return
+		}
+	}
+}
+
+// Name implements sink.EventSink
+func (f *Forwarder) Name() string {
+	return "syslog"
+}
+
+// Write implements sink.EventSink. It queues the events and never fails:
+// the Forwarder buffers and retries on its own.
+func (f *Forwarder) Write(ctx context.Context, events []sink.Event) error {
+	for _, e := range events {
+		f.Forward(e)
+	}
+	return nil
+}
+
+// Close implements sink.EventSink
+func (f *Forwarder) Close() error {
+	f.Stop()
+	return nil
+}
+
+// Forward queues e, dropping it when the queue is full
+func (f *Forwarder) Forward(e sink.Event) {
+	select {
+	case f.queue <- e:
+	default:
+		metrics.SyslogMessages.WithLabelValues("dropped").Inc()
+	}
+}
+
+// handle sends e, or buffers it behind the messages already waiting
+func (f *Forwarder) handle(e sink.Event) {
+	msg := message(f.cfg.Format, f.facility, f.hostname, f.cfg.AppName, e)
+	if !f.buffer.pending() {
+		err := f.send(msg)
+		if err == nil {
+			metrics.SyslogMessages.WithLabelValues("sent").Inc()
+			return
+		}
+		slog.Warn("Syslog collector unavailable, buffering messages", "address", f.cfg.Address, "error", err)
+	}
+	f.push(msg)
+}
+
+// push adds msg to the buffer
+func (f *Forwarder) push(msg []byte) {
+	if err := f.buffer.push(msg); err != nil {
+		metrics.SyslogMessages.WithLabelValues("dropped").Inc()
+		if errors.Is(err, errBufferFull) {
+			slog.Debug("Syslog buffer full, message dropped")
+		} else {
+			slog.Error("Failed to buffer syslog message", "error", err)
+		}
+		return
+	}
+	metrics.SyslogMessages.WithLabelValues("buffered").Inc()
+	metrics.SyslogBufferBytes.Set(float64(f.buffer.size - f.buffer.offset))
+}
+
+// flush sends the buffered messages, if the collector is back
+func (f *Forwarder) flush() {
+	if !f.buffer.pending() {
+		return
+	}
+	sent := 0
+	err := f.buffer.drain(func(msg []byte) error {
+		if err := f.send(msg); err != nil {
+			return err
+		}
+		sent++
+		return nil
+	})
+	metrics.SyslogMessages.WithLabelValues("sent").Add(float64(sent))
+	metrics.SyslogBufferBytes.Set(float64(f.buffer.size - f.buffer.offset))
+	if err != nil {
+		slog.Debug("Syslog collector still unavailable", "address", f.cfg.Address, "sent", sent, "error", err)
+		return
+	}
+	slog.Info("Syslog buffer sent", "address", f.cfg.Address, "messages", sent)
+}
+
+// send writes msg to the collector, connecting first if needed. Over TCP
+// and TLS messages are framed by octet counting (RFC 6587); over UDP each
+// is one datagram.
+func (f *Forwarder) send(msg []byte) error {
+	if f.conn == nil {
+		conn, err := f.dial()
+		if err != nil {
+			return err
+		}
+		f.conn = conn
+	}
+
+	frame := msg
+	if f.cfg.Network != config.SyslogUDP {
+		frame = append(strconv.AppendInt(nil, int64(len(msg)), 10), ' ')
+		frame = append(frame, msg...)
+	}
+	if err := f.conn.SetWriteDeadline(time.Now().Add(ioTimeout)); err != nil {
+		f.disconnect()
+		return err
+	}
+	if _, err := f.conn.Write(frame); err != nil {
+		f.disconnect()
+		return err
+	}
+	return nil
+}
+
+// dial connects to the collector
+func (f *Forwarder) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: ioTimeout}
+	switch f.cfg.Network {
+	case config.SyslogTLS:
+		return tls.DialWithDialer(dialer, "tcp", f.cfg.Address, f.tlsConfig)
+	case config.SyslogUDP:
+		return dialer.Dial("udp", f.cfg.Address)
+	default:
+		return dialer.Dial("tcp", f.cfg.Address)
+	}
+}
+
+// disconnect closes the connection, if any
+func (f *Forwarder) disconnect() {
+	if f.conn == nil {
+		return
+	}
+	if err := f.conn.Close(); err != nil {
+		// The connection had already failed
+	}
+	f.conn = nil
+}
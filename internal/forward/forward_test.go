@@ -0,0 +1,271 @@
+package forward
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/config"
+	"github.com/dangogh/silver-eureka/internal/sink"
+)
+
+var testEvent = sink.Event{
+	Time:      time.Date(2026, 3, 2, 12, 0, 0, 500_000_000, time.UTC),
+	IPAddress: "192.0.2.1",
+	Method:    "GET",
+	URL:       "/search?q=a=b|c\\d",
+	Host:      "example.com",
+	UserAgent: "curl/8.0\r\nX-Injected: 1",
+}
+
+func TestMessage(t *testing.T) {
+	got := string(message(config.SyslogCEF, 16, "sensor-1", "silver-eureka", testEvent))
+	want := `<134>1 2026-03-02T12:00:00.500000Z sensor-1 silver-eureka - request - ` +
+		`CEF:0|silver-eureka|gather-requests|1.0|http-request|HTTP request logged|3|` +
+		`rt=1772452800500 src=192.0.2.1 requestMethod=GET request=/search?q\=a\=b|c\\d ` +
+		`dhost=example.com requestClientApplication=curl/8.0\r\nX-Injected: 1`
+	if got != want {
+		t.Errorf("CEF message =\n%s\nwant\n%s", got, want)
+	}
+
+	e := testEvent
+	e.IPAddress = "h:1:0123456789abcdef"
+	if got := cef(e); !strings.Contains(got, "cs1Label=clientId cs1=h:1:0123456789abcdef") || strings.Contains(got, "src=") {
+		t.Errorf("Expected an anonymized address in cs1, got %s", got)
+	}
+
+	got = string(message(config.SyslogLEEF, 1, "-", "app", testEvent))
+	want = "<14>1 2026-03-02T12:00:00.500000Z - app - request - " +
+		"LEEF:1.0|silver-eureka|gather-requests|1.0|http-request|" +
+		"devTime=Mar 02 2026 12:00:00.500 UTC\tdevTimeFormat=MMM dd yyyy HH:mm:ss.SSS z\t" +
+		"src=192.0.2.1\tmethod=GET\turl=/search?q=a=b|c\\d\tdstHost=example.com\tuserAgent=curl/8.0  X-Injected: 1"
+	if got != want {
+		t.Errorf("LEEF message =\n%q\nwant\n%q", got, want)
+	}
+}
+
+// collector is a stand-in syslog server over TCP, reading octet-counted
+// frames
+type collector struct {
+	ln       net.Listener
+	messages chan string
+}
+
+func listenCollector(t *testing.T, addr string) *collector {
+	t.Helper()
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	c := &collector{ln: ln, messages: make(chan string, 100)}
+	t.Cleanup(c.close)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go c.read(t, conn)
+		}
+	}()
+	return c
+}
+
+func (c *collector) read(t *testing.T, conn net.Conn) {
+	defer func() {
+		if err := conn.Close(); err != nil {
+			// Closed by the test
+		}
+	}()
+	r := bufio.NewReader(conn)
+	for {
+		length, err := r.ReadString(' ')
+		if err != nil {
+			return
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+		if err != nil {
+			t.Errorf("Bad frame length %q", length)
+			return
+		}
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			return
+		}
+		c.messages <- string(msg)
+	}
+}
+
+func (c *collector) close() {
+	if err := c.ln.Close(); err != nil {
+		// Already closed
+	}
+}
+
+// next waits for a message
+func (c *collector) next(t *testing.T) string {
+	t.Helper()
+	select {
+	case msg := <-c.messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a syslog message")
+		return ""
+	}
+}
+
+func newTestForwarder(t *testing.T, network, addr, dir string) *Forwarder {
+	t.Helper()
+	cfg := config.DefaultSyslogConfig()
+	cfg.Address = addr
+	cfg.Network = network
+	cfg.Hostname = "sensor-1"
+	cfg.BufferDir = dir
+	cfg.RetryInterval = 100 * time.Millisecond
+	f, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	f.Start()
+	t.Cleanup(f.Stop)
+	return f
+}
+
+func TestForwarder_BuffersWhileCollectorDown(t *testing.T) {
+	// Reserve a port with nothing listening on it
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	if err := ln.Close(); err != nil {
+		t.Fatalf("Failed to close listener: %v", err)
+	}
+
+	dir := t.TempDir()
+	f := newTestForwarder(t, config.SyslogTCP, addr, dir)
+	for i := range 3 {
+		f.Forward(sink.Event{Time: time.Now(), IPAddress: "192.0.2.1", Method: "GET", URL: fmt.Sprintf("/%d", i)})
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, err := os.ReadFile(filepath.Join(dir, bufferFile))
+		if err == nil && strings.Count(string(data), "\n") == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 3 buffered messages, got %q", data)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Once the collector is up the buffer goes first, in order
+	c := listenCollector(t, addr)
+	f.Forward(sink.Event{Time: time.Now(), IPAddress: "192.0.2.1", Method: "GET", URL: "/3"})
+	for i := range 4 {
+		if msg := c.next(t); !strings.HasSuffix(msg, fmt.Sprintf("request=/%d", i)) {
+			t.Errorf("Message %d = %q", i, msg)
+		}
+	}
+	f.Stop()
+	if info, err := os.Stat(filepath.Join(dir, bufferFile)); err != nil || info.Size() != 0 {
+		t.Errorf("Expected an empty buffer, got %v, %v", info, err)
+	}
+}
+
+func TestForwarder_ResumesBufferAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	buf, err := openBuffer(dir, 1<<20)
+	if err != nil {
+		t.Fatalf("openBuffer failed: %v", err)
+	}
+	for _, msg := range []string{"sent already", "first", "second"} {
+		if err := buf.push([]byte(msg)); err != nil {
+			t.Fatalf("push failed: %v", err)
+		}
+	}
+	// The first message was sent before the collector went away
+	buf.offset = int64(len("sent already\n"))
+	if err := buf.saveOffset(nil); err != nil {
+		t.Fatalf("saveOffset failed: %v", err)
+	}
+	if err := buf.close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+
+	c := listenCollector(t, "127.0.0.1:0")
+	newTestForwarder(t, config.SyslogTCP, c.ln.Addr().String(), dir)
+	if first, second := c.next(t), c.next(t); first != "first" || second != "second" {
+		t.Errorf("Got %q and %q, want the unsent messages", first, second)
+	}
+}
+
+func TestBuffer_Full(t *testing.T) {
+	buf, err := openBuffer(t.TempDir(), 10)
+	if err != nil {
+		t.Fatalf("openBuffer failed: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := buf.close(); err != nil {
+			t.Errorf("close failed: %v", err)
+		}
+	})
+	if err := buf.push([]byte("12345678")); err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+	if err := buf.push([]byte("x")); err != errBufferFull {
+		t.Errorf("push = %v, want errBufferFull", err)
+	}
+}
+
+func TestForwarder_UDPPipeline(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := pc.Close(); err != nil {
+			t.Errorf("Failed to close: %v", err)
+		}
+	})
+
+	f := newTestForwarder(t, config.SyslogUDP, pc.LocalAddr().String(), t.TempDir())
+	events := config.DefaultEventsConfig()
+	events.FlushInterval = 10 * time.Millisecond
+	p := sink.NewPipeline()
+	if err := p.Add(f, events.Sink(config.SinkConfig{Name: f.Name()})); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	p.Start()
+	t.Cleanup(p.Stop)
+	handler := p.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/wp-login.php", nil)
+	req.RemoteAddr = "198.51.100.7:5000"
+	req.Header.Set("User-Agent", "scanner")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if err := pc.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("SetReadDeadline failed: %v", err)
+	}
+	datagram := make([]byte, 2048)
+	n, _, err := pc.ReadFrom(datagram)
+	if err != nil {
+		t.Fatalf("Failed to read datagram: %v", err)
+	}
+	msg := string(datagram[:n])
+	if !strings.HasPrefix(msg, "<134>1 ") ||
+		!strings.Contains(msg, "src=198.51.100.7 requestMethod=POST request=/wp-login.php dhost=example.com requestClientApplication=scanner") {
+		t.Errorf("Unexpected datagram %q", msg)
+	}
+}
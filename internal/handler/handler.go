@@ -1,51 +1,95 @@
 package handler
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
-	"strings"
+	"slices"
 
+	"github.com/dangogh/silver-eureka/internal/clientip"
+	"github.com/dangogh/silver-eureka/internal/config"
 	"github.com/dangogh/silver-eureka/internal/database"
+	"github.com/dangogh/silver-eureka/internal/listener"
+	"github.com/dangogh/silver-eureka/internal/telemetry"
 )
 
+// redacted replaces the values of the headers named in redact_headers
+const redacted = "[redacted]"
+
 // Handler handles HTTP requests and logs them to the database
 type Handler struct {
-	db *database.DB
+	db        *database.DB
+	capture   config.CaptureConfig
+	observers []Observer
+}
+
+// Observer is told of each request once it is logged, with what was
+// captured of it, anonymized as it is stored
+type Observer interface {
+	Logged(ctx context.Context, ipAddress, url string, d database.RequestDetails)
 }
 
-// New creates a new Handler
+// New creates a new Handler with the default capture settings
 func New(db *database.DB) *Handler {
-	return &Handler{db: db}
+	return NewWithCapture(db, config.DefaultCaptureConfig())
+}
+
+// NewWithCapture creates a new Handler storing what capture selects of
+// each request
+func NewWithCapture(db *database.DB, capture config.CaptureConfig) *Handler {
+	redact := make([]string, len(capture.RedactHeaders))
+	for i, name := range capture.RedactHeaders {
+		redact[i] = http.CanonicalHeaderKey(name)
+	}
+	capture.RedactHeaders = redact
+	return &Handler{db: db, capture: capture}
+}
+
+// Observe adds an observer of logged requests. It must be called before
+// the handler serves requests.
+func (h *Handler) Observe(o Observer) {
+	h.observers = append(h.observers, o)
 }
 
 // ServeHTTP implements the http.Handler interface
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	slog.Debug("Handler invoked: ServeHTTP (catch-all)", "method", r.Method, "path", r.URL.Path)
+	ctx, span := telemetry.StartSpan(r.Context(), "log request", telemetry.SpanInternal)
+	defer span.End()
+
 	// Limit request body size to 1MB to prevent memory exhaustion
 	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
 
 	// Extract IP address from request
-	ipAddress := getIPAddress(r)
+	ipAddress := clientip.FromRequest(r)
 
 	// Get the full URL
 	url := r.URL.String()
 
+	// Our own logs and the observers see the address as it is stored
+	details := h.details(r)
+	shownIP, shownDetails := h.db.Anonymize(ipAddress, details)
+
 	// Debug log for each incoming request
-	slog.Debug("Incoming request",
-		"method", r.Method,
-		"url", url,
-		"remote_addr", r.RemoteAddr,
-		"ip_address", ipAddress,
-		"user_agent", r.UserAgent(),
-		"headers", r.Header,
-	)
+	if slog.Default().Enabled(ctx, slog.LevelDebug) {
+		_, shown := h.db.Anonymize(ipAddress, database.RequestDetails{Headers: r.Header})
+		slog.DebugContext(ctx, "Incoming request",
+			"method", r.Method,
+			"url", url,
+			"ip_address", shownIP,
+			"user_agent", r.UserAgent(),
+			"headers", shown.Headers,
+		)
+	}
 
 	// Log the request to the database
-	if err := h.db.LogRequest(ipAddress, url); err != nil {
-		slog.Error("Error logging request to database",
+	if err := h.db.LogRequestContext(ctx, ipAddress, url, details); err != nil {
+		span.RecordError(err)
+		slog.ErrorContext(ctx, "Error logging request to database",
 			"error", err,
-			"ip_address", ipAddress,
+			"ip_address", shownIP,
 			"url", url,
 		)
 		// Graceful degradation: return error response but don't crash
@@ -57,10 +101,13 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	slog.Info("Request logged successfully",
-		"ip_address", ipAddress,
+	slog.InfoContext(ctx, "Request logged successfully",
+		"ip_address", shownIP,
 		"url", url,
 	)
+	for _, o := range h.observers {
+		o.Logged(ctx, shownIP, url, shownDetails)
+	}
 
 	// Return 404 for all unmatched routes
 	w.Header().Set("Content-Type", "text/plain")
@@ -70,28 +117,25 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// getIPAddress extracts the client IP address from the request
-// It checks X-Forwarded-For and X-Real-IP headers first, then falls back to RemoteAddr
-func getIPAddress(r *http.Request) string {
-	// Check X-Forwarded-For header
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		// X-Forwarded-For can contain multiple IPs, take the first one
-		ips := strings.Split(xff, ",")
-		if len(ips) > 0 {
-			return strings.TrimSpace(ips[0])
+// details returns what is captured of r beyond its address and URL
+func (h *Handler) details(r *http.Request) database.RequestDetails {
+	l := listener.FromContext(r.Context())
+	d := database.RequestDetails{UserAgent: r.UserAgent(), Listener: l.Label, LocalPort: l.Port}
+	if h.capture.Headers {
+		d.Headers = make(http.Header, len(r.Header))
+		for name, values := range r.Header {
+			if slices.Contains(h.capture.RedactHeaders, name) {
+				values = []string{redacted}
+			}
+			d.Headers[name] = values
 		}
 	}
-
-	// Check X-Real-IP header
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		return xri
-	}
-
-	// Fall back to RemoteAddr
-	// RemoteAddr format is "IP:port", we need just the IP
-	addr := r.RemoteAddr
-	if idx := strings.LastIndex(addr, ":"); idx != -1 {
-		return addr[:idx]
+	if h.capture.BodyBytes > 0 && r.Body != nil {
+		body, err := io.ReadAll(io.LimitReader(r.Body, int64(h.capture.BodyBytes)))
+		if err != nil {
+			// Keep what was read before the error
+		}
+		d.Body = body
 	}
-	return addr
+	return d
 }
@@ -4,8 +4,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/dangogh/silver-eureka/internal/config"
 	"github.com/dangogh/silver-eureka/internal/database"
 )
 
@@ -72,76 +75,6 @@ func TestServeHTTP(t *testing.T) {
 	}
 }
 
-func TestGetIPAddress(t *testing.T) {
-	tests := []struct {
-		name       string
-		remoteAddr string
-		xff        string
-		xri        string
-		expectedIP string
-	}{
-		{
-			name:       "RemoteAddr only",
-			remoteAddr: "192.168.1.1:12345",
-			expectedIP: "192.168.1.1",
-		},
-		{
-			name:       "X-Forwarded-For single IP",
-			remoteAddr: "192.168.1.1:12345",
-			xff:        "203.0.113.1",
-			expectedIP: "203.0.113.1",
-		},
-		{
-			name:       "X-Forwarded-For multiple IPs",
-			remoteAddr: "192.168.1.1:12345",
-			xff:        "203.0.113.1, 198.51.100.1, 192.0.2.1",
-			expectedIP: "203.0.113.1",
-		},
-		{
-			name:       "X-Real-IP",
-			remoteAddr: "192.168.1.1:12345",
-			xri:        "203.0.113.2",
-			expectedIP: "203.0.113.2",
-		},
-		{
-			name:       "X-Forwarded-For takes precedence over X-Real-IP",
-			remoteAddr: "192.168.1.1:12345",
-			xff:        "203.0.113.1",
-			xri:        "203.0.113.2",
-			expectedIP: "203.0.113.1",
-		},
-		{
-			name:       "RemoteAddr without port",
-			remoteAddr: "192.168.1.1",
-			expectedIP: "192.168.1.1",
-		},
-		{
-			name:       "X-Forwarded-For with whitespace",
-			remoteAddr: "192.168.1.1:12345",
-			xff:        " 203.0.113.1 , 198.51.100.1",
-			expectedIP: "203.0.113.1",
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			req := httptest.NewRequest(http.MethodGet, "/", nil)
-			req.RemoteAddr = tt.remoteAddr
-			if tt.xff != "" {
-				req.Header.Set("X-Forwarded-For", tt.xff)
-			}
-			if tt.xri != "" {
-				req.Header.Set("X-Real-IP", tt.xri)
-			}
-
-			ip := getIPAddress(req)
-			if ip != tt.expectedIP {
-				t.Errorf("Expected IP %s, got %s", tt.expectedIP, ip)
-			}
-		})
-	}
-}
-
 func TestServeHTTP_DatabaseError(t *testing.T) {
 	// Create temporary database
 	dbPath := "/tmp/test_handler_error.db"
@@ -211,7 +144,7 @@ func TestServeHTTP_WithHeaders(t *testing.T) {
 	// Create test request with X-Forwarded-For header
 	req := httptest.NewRequest(http.MethodPost, "/api/endpoint?param=value", nil)
 	req.RemoteAddr = "192.168.1.1:12345"
-	req.Header.Set("X-Forwarded-For", "203.0.113.1, 198.51.100.1")
+	req.Header.Set("X-Forwarded-For", "203.0.113.1, 10.0.0.5")
 	req.Header.Set("User-Agent", "TestAgent/1.0")
 	w := httptest.NewRecorder()
 
@@ -241,3 +174,50 @@ func TestServeHTTP_WithHeaders(t *testing.T) {
 		t.Errorf("Expected URL /api/endpoint?param=value, got %s", logs[0].URL)
 	}
 }
+
+func TestServeHTTP_Capture(t *testing.T) {
+	db, err := database.New(filepath.Join(t.TempDir(), "capture.db"))
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			// Ignore close errors in test cleanup
+		}
+	}()
+
+	capture := config.DefaultCaptureConfig()
+	capture.BodyBytes = 16
+	capture.RedactHeaders = append(capture.RedactHeaders, "x-api-key")
+	h := NewWithCapture(db, capture)
+
+	body := strings.NewReader("probe=wordpress&" + strings.Repeat("x", 64) + " overflow")
+	req := httptest.NewRequest(http.MethodPost, "/xmlrpc.php", body)
+	req.Header.Set("User-Agent", "scanner/2.1")
+	req.Header.Set("Authorization", "Basic c2VjcmV0")
+	req.Header.Set("X-Api-Key", "topsecret")
+	req.Header.Set("X-Probe", "canary")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	tests := []struct {
+		query string
+		found bool
+	}{
+		{"scanner", true},
+		{"canary", true},
+		{"wordpress", true},
+		{"redacted", true},
+		{"c2VjcmV0", false},
+		{"topsecret", false},
+		{"overflow", false}, // beyond body_bytes
+	}
+	for _, tt := range tests {
+		hits, err := db.Search(database.SearchFilter{Query: tt.query})
+		if err != nil {
+			t.Fatalf("Search %q failed: %v", tt.query, err)
+		}
+		if found := len(hits) > 0; found != tt.found {
+			t.Errorf("Search %q: expected found=%v, got %+v", tt.query, tt.found, hits)
+		}
+	}
+}
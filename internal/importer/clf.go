@@ -0,0 +1,150 @@
+package importer
+
+import (
+	"bytes"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/database"
+)
+
+// clfTime is the timestamp layout of the Common Log Format, also nginx's
+// $time_local
+const clfTime = "02/Jan/2006:15:04:05 -0700"
+
+// clfReader reads the Common Log Format,
+//
+//	host ident user [time] "request" status bytes
+//
+// and the Combined Log Format, which adds "referer" "user-agent". Further
+// quoted fields, such as nginx's "$http_x_forwarded_for", are taken as the
+// forwarded-for address when one follows the user agent.
+type clfReader struct {
+	lines lineReader
+}
+
+func (c *clfReader) next() (database.ImportRecord, error) {
+	line, err := c.lines.next()
+	if err != nil {
+		return database.ImportRecord{}, err
+	}
+	p := clfParser{s: string(line)}
+
+	host := p.token()
+	p.token() // ident
+	p.token() // user
+	stamp, ok := p.bracketed()
+	if !ok {
+		return database.ImportRecord{}, c.lines.invalid("no [time] field")
+	}
+	ts, err := time.Parse(clfTime, stamp)
+	if err != nil {
+		return database.ImportRecord{}, c.lines.invalid("invalid time %q", stamp)
+	}
+	request, ok := p.quoted()
+	if !ok {
+		return database.ImportRecord{}, c.lines.invalid("no quoted request field")
+	}
+	if _, err := netip.ParseAddr(host); err != nil {
+		return database.ImportRecord{}, c.lines.invalid("host %q is not an IP address", host)
+	}
+	url, ok := requestURL(request)
+	if !ok {
+		return database.ImportRecord{}, c.lines.invalid("empty request")
+	}
+
+	rec := database.ImportRecord{Timestamp: ts, IPAddress: host, URL: url}
+	p.token() // status
+	p.token() // bytes
+	var headers http.Header
+	if referer, ok := p.quoted(); ok && referer != "-" && referer != "" {
+		headers = http.Header{"Referer": {referer}}
+	}
+	if agent, ok := p.quoted(); ok && agent != "-" {
+		rec.Details.UserAgent = agent
+	}
+	if forwarded, ok := p.quoted(); ok && forwarded != "-" && forwarded != "" {
+		if headers == nil {
+			headers = http.Header{}
+		}
+		headers.Set("X-Forwarded-For", forwarded)
+	}
+	rec.Details.Headers = headers
+	return rec, nil
+}
+
+// requestURL returns the URL of a request line such as
+// "GET /path?q=1 HTTP/1.1". A line that isn't HTTP, such as the TLS
+// handshakes servers log as garbled requests, is kept whole.
+func requestURL(request string) (string, bool) {
+	if request == "" || request == "-" {
+		return "", false
+	}
+	fields := strings.Fields(request)
+	if len(fields) == 3 && strings.HasPrefix(fields[2], "HTTP/") || len(fields) == 2 && strings.HasPrefix(fields[1], "/") {
+		return fields[1], true
+	}
+	return request, true
+}
+
+// clfParser splits a log line into its fields
+type clfParser struct {
+	s string
+}
+
+// skipSpace drops the spaces before the next field
+func (p *clfParser) skipSpace() {
+	p.s = strings.TrimLeft(p.s, " \t")
+}
+
+// token returns the next field up to a space
+func (p *clfParser) token() string {
+	p.skipSpace()
+	i := strings.IndexAny(p.s, " \t")
+	if i < 0 {
+		i = len(p.s)
+	}
+	tok := p.s[:i]
+	p.s = p.s[i:]
+	return tok
+}
+
+// bracketed returns the next field in [brackets]
+func (p *clfParser) bracketed() (string, bool) {
+	p.skipSpace()
+	if !strings.HasPrefix(p.s, "[") {
+		return "", false
+	}
+	end := strings.IndexByte(p.s, ']')
+	if end < 0 {
+		return "", false
+	}
+	field := p.s[1:end]
+	p.s = p.s[end+1:]
+	return field, true
+}
+
+// quoted returns the next field in "quotes", undoing the \" and \\
+// escapes; other escapes, such as \x16, are kept as written
+func (p *clfParser) quoted() (string, bool) {
+	p.skipSpace()
+	if !strings.HasPrefix(p.s, `"`) {
+		return "", false
+	}
+	var b bytes.Buffer
+	for i := 1; i < len(p.s); i++ {
+		switch c := p.s[i]; {
+		case c == '"':
+			p.s = p.s[i+1:]
+			return b.String(), true
+		case c == '\\' && i+1 < len(p.s) && (p.s[i+1] == '"' || p.s[i+1] == '\\'):
+			b.WriteByte(p.s[i+1])
+			i++
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,269 @@
+// Package importer reads request logs from web server access logs and from
+// this application's own exports, and adds them to the database with their
+// original timestamps, skipping those already stored
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/dangogh/silver-eureka/internal/database"
+	"github.com/dangogh/silver-eureka/internal/metrics"
+)
+
+// Input formats
+const (
+	FormatAuto      = "auto"       // detected from the start of the input
+	FormatCLF       = "clf"        // Common or Combined Log Format, as written by Apache and nginx
+	FormatNginxJSON = "nginx-json" // one JSON object per line with nginx variable names
+	FormatJSON      = "json"       // a JSON array, as from /stats/download and export
+	FormatNDJSON    = "ndjson"     // one JSON log per line, as from export and archives
+	FormatCSV       = "csv"        // as from export
+)
+
+// Formats lists the input formats by name
+var Formats = []string{FormatAuto, FormatCLF, FormatNginxJSON, FormatJSON, FormatNDJSON, FormatCSV}
+
+const (
+	// batchSize is the number of records stored per transaction
+	batchSize = 1000
+
+	// maxErrors is the number of invalid records described in a Report
+	maxErrors = 10
+
+	// maxLine is the longest line read; longer ones are invalid
+	maxLine = 1 << 20
+)
+
+// Options controls an import
+type Options struct {
+	Format string // one of Formats; empty = auto
+	// Sensor tags the records that carry no sensor ID, as access logs don't
+	Sensor string
+	DryRun bool // count what would be imported without writing it
+	// Progress, when set, is called with the running totals after each
+	// batch
+	Progress func(Report)
+}
+
+// Report describes an import
+type Report struct {
+	Format     string   `json:"format"`
+	DryRun     bool     `json:"dry_run"`
+	Records    int64    `json:"records"` // read, blank lines aside
+	Imported   int64    `json:"imported"`
+	Duplicates int64    `json:"duplicates"`
+	Invalid    int64    `json:"invalid"`
+	Errors     []string `json:"errors,omitempty"` // the first invalid records, e.g. "line 3: ..."
+}
+
+// ErrInvalidInput marks an input that can't be read past a point, such as
+// a JSON array with a syntax error
+var ErrInvalidInput = errors.New("invalid input")
+
+// invalidError marks a record that is skipped; the import goes on
+type invalidError struct {
+	pos string // "line 3" or "record 3"
+	msg string
+}
+
+func (e *invalidError) Error() string {
+	return e.pos + ": " + e.msg
+}
+
+// reader reads records in one format. next returns io.EOF at the end, an
+// *invalidError for a record to skip, and any other error to stop.
+type reader interface {
+	next() (database.ImportRecord, error)
+}
+
+// Run imports the records read from r, which may be gzip-compressed, and
+// reports what it did. Records from access logs are stored with addresses
+// anonymized as configured; those from exports are stored as exported. A
+// failure leaves the batches already stored in place.
+func Run(ctx context.Context, db *database.DB, r io.Reader, opts Options) (Report, error) {
+	format := opts.Format
+	if format == "" {
+		format = FormatAuto
+	}
+	if !slices.Contains(Formats, format) {
+		return Report{}, fmt.Errorf("unknown format %q", format)
+	}
+
+	br := bufio.NewReaderSize(r, 64<<10)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return Report{}, fmt.Errorf("%w: not gzip: %v", ErrInvalidInput, err)
+		}
+		br = bufio.NewReaderSize(zr, 64<<10)
+	}
+	if format == FormatAuto {
+		format = detect(br)
+	}
+	rd := newReader(br, format)
+
+	report := Report{Format: format, DryRun: opts.DryRun}
+	im := db.NewImport(database.ImportOptions{DryRun: opts.DryRun})
+	batch := make([]database.ImportRecord, 0, batchSize)
+	flush := func() error {
+		imported, duplicates, err := im.Add(batch)
+		if err != nil {
+			return err
+		}
+		report.Imported += imported
+		report.Duplicates += duplicates
+		if !opts.DryRun {
+			metrics.ImportedLogs.WithLabelValues("imported").Add(float64(imported))
+			metrics.ImportedLogs.WithLabelValues("duplicate").Add(float64(duplicates))
+		}
+		batch = batch[:0]
+		if opts.Progress != nil {
+			opts.Progress(report)
+		}
+		return nil
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		rec, err := rd.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var invalid *invalidError
+		if errors.As(err, &invalid) {
+			report.Records++
+			report.Invalid++
+			if !opts.DryRun {
+				metrics.ImportedLogs.WithLabelValues("invalid").Inc()
+			}
+			if len(report.Errors) < maxErrors {
+				report.Errors = append(report.Errors, invalid.Error())
+			}
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+		report.Records++
+		if rec.Sensor == "" {
+			rec.Sensor = opts.Sensor
+		}
+		batch = append(batch, rec)
+		if len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return report, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// detect names the format of the input from its first line
+func detect(br *bufio.Reader) string {
+	head, err := br.Peek(4096)
+	if err != nil && len(head) == 0 {
+		return FormatCLF
+	}
+	head = bytes.TrimLeft(head, " \t\r\n\ufeff")
+	switch {
+	case bytes.HasPrefix(head, []byte("[")):
+		return FormatJSON
+	case bytes.HasPrefix(head, []byte("{")):
+		// Exports use the field names of database.RequestLog
+		if bytes.Contains(head[:firstLine(head)], []byte(`"IPAddress"`)) {
+			return FormatNDJSON
+		}
+		return FormatNginxJSON
+	case bytes.HasPrefix(head, []byte(csvHeader[0]+","+csvHeader[1])):
+		return FormatCSV
+	default:
+		return FormatCLF
+	}
+}
+
+// firstLine returns the length of the first line of b
+func firstLine(b []byte) int {
+	if i := bytes.IndexByte(b, '\n'); i >= 0 {
+		return i
+	}
+	return len(b)
+}
+
+// newReader returns the reader of a known format
+func newReader(br *bufio.Reader, format string) reader {
+	switch format {
+	case FormatCLF:
+		return &clfReader{lines: lineReader{r: br}}
+	case FormatNginxJSON:
+		return &nginxReader{lines: lineReader{r: br}}
+	case FormatJSON:
+		return &jsonReader{r: br}
+	case FormatNDJSON:
+		return &ndjsonReader{lines: lineReader{r: br}}
+	default:
+		return &csvReader{r: br}
+	}
+}
+
+// lineReader reads lines, skipping blank ones
+type lineReader struct {
+	r *bufio.Reader
+	n int // lines read
+}
+
+// next returns the next line without its line break
+func (l *lineReader) next() ([]byte, error) {
+	for {
+		l.n++
+		var line []byte
+		tooLong := false
+		for {
+			chunk, err := l.r.ReadSlice('\n')
+			if len(line)+len(chunk) > maxLine {
+				tooLong = true
+			} else {
+				line = append(line, chunk...)
+			}
+			if errors.Is(err, bufio.ErrBufferFull) {
+				continue
+			}
+			if errors.Is(err, io.EOF) {
+				if len(line) == 0 && !tooLong {
+					return nil, io.EOF
+				}
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read input: %w", err)
+			}
+			break
+		}
+		if tooLong {
+			return nil, l.invalid("longer than %d bytes", maxLine)
+		}
+		line = bytes.TrimRight(line, "\r\n")
+		if l.n == 1 {
+			line = bytes.TrimPrefix(line, []byte("\ufeff"))
+		}
+		if len(bytes.TrimSpace(line)) > 0 {
+			return line, nil
+		}
+	}
+}
+
+// invalid describes a problem with the current line
+func (l *lineReader) invalid(format string, args ...any) error {
+	return &invalidError{pos: fmt.Sprintf("line %d", l.n), msg: fmt.Sprintf(format, args...)}
+}
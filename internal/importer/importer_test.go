@@ -0,0 +1,315 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/anonymize"
+	"github.com/dangogh/silver-eureka/internal/database"
+)
+
+func setupTestDB(t *testing.T) *database.DB {
+	t.Helper()
+	dbPath := filepath.Join(t.TempDir(), "import.db")
+	db, err := database.New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			// Ignore close errors in test cleanup
+		}
+		if err := os.Remove(dbPath); err != nil {
+			// Ignore remove errors in test cleanup
+		}
+	})
+	return db
+}
+
+const combinedLog = `203.0.113.5 - - [10/Oct/2023:13:55:36 -0700] "GET /wp-login.php?action=register HTTP/1.1" 404 153 "-" "Mozilla/5.0 (compatible; \"quoted\")"
+203.0.113.5 - frank [10/Oct/2023:13:55:36 -0700] "GET /wp-login.php?action=register HTTP/1.1" 404 153 "-" "Mozilla/5.0 (compatible; \"quoted\")"
+2001:db8::1 - - [10/Oct/2023:13:55:40 -0700] "\x16\x03\x01\x00\xca\x01" 400 157 "-" "-"
+198.51.100.7 - - [10/Oct/2023:13:56:00 -0700] "POST /cgi-bin/luci HTTP/1.1" 403 0 "http://example.com/" "curl/8.0" "10.0.0.1"
+
+scanner.example.com - - [10/Oct/2023:13:57:00 -0700] "GET / HTTP/1.1" 200 612
+198.51.100.8 - - [yesterday] "GET / HTTP/1.1" 200 612
+198.51.100.9 - - [10/Oct/2023:13:58:00 -0700] "-" 408 0
+`
+
+func TestRun_CLF(t *testing.T) {
+	db := setupTestDB(t)
+	report, err := Run(context.Background(), db, strings.NewReader(combinedLog), Options{Sensor: "web-1"})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	want := Report{Format: FormatCLF, Records: 7, Imported: 4, Invalid: 3}
+	if report.Format != want.Format || report.Records != want.Records || report.Imported != want.Imported ||
+		report.Invalid != want.Invalid || report.Duplicates != 0 {
+		t.Errorf("Report = %+v, want %+v", report, want)
+	}
+	if len(report.Errors) != 3 || !strings.HasPrefix(report.Errors[0], "line 6: host") {
+		t.Errorf("Expected the three invalid lines described, got %q", report.Errors)
+	}
+
+	logs, err := db.QueryLogs(database.LogFilter{Sensor: "web-1", Ascending: true})
+	if err != nil {
+		t.Fatalf("Failed to query logs: %v", err)
+	}
+	if len(logs) != 4 {
+		t.Fatalf("Expected 4 logs, got %+v", logs)
+	}
+	if logs[0].URL != "/wp-login.php?action=register" || logs[0].IPAddress != "203.0.113.5" ||
+		!logs[0].Timestamp.Equal(time.Date(2023, 10, 10, 20, 55, 36, 0, time.UTC)) {
+		t.Errorf("Unexpected first log: %+v", logs[0])
+	}
+	if logs[2].URL != `\x16\x03\x01\x00\xca\x01` {
+		t.Errorf("Expected the garbled request kept whole, got %q", logs[2].URL)
+	}
+
+	hits, err := db.Search(database.SearchFilter{Query: "quoted"})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(hits) != 2 || hits[0].UserAgent != `Mozilla/5.0 (compatible; "quoted")` {
+		t.Errorf("Expected the unescaped user agent twice, got %+v", hits)
+	}
+	// The referer and forwarded-for address are kept as headers
+	for _, word := range []string{"referer", "forwarded"} {
+		hits, err = db.Search(database.SearchFilter{Query: word})
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if len(hits) != 1 || hits[0].URL != "/cgi-bin/luci" {
+			t.Errorf("Expected the %s header of one request, got %+v", word, hits)
+		}
+	}
+}
+
+func TestRun_Dedup(t *testing.T) {
+	db := setupTestDB(t)
+
+	// The first two lines are the same request twice in one second
+	report, err := Run(context.Background(), db, strings.NewReader(combinedLog), Options{DryRun: true})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !report.DryRun || report.Imported != 4 || report.Duplicates != 0 {
+		t.Errorf("Expected a dry run to count 4 imports, got %+v", report)
+	}
+	if logs, err := db.GetLogs(10); err != nil || len(logs) != 0 {
+		t.Fatalf("Expected a dry run to store nothing, got %d logs (%v)", len(logs), err)
+	}
+
+	if _, err := Run(context.Background(), db, strings.NewReader(combinedLog), Options{}); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	// Importing again adds nothing, and a longer log only its new lines
+	longer := combinedLog + `203.0.113.5 - - [10/Oct/2023:13:55:36 -0700] "GET /wp-login.php?action=register HTTP/1.1" 404 153 "-" "-"` + "\n"
+	for _, dryRun := range []bool{true, false} {
+		report, err = Run(context.Background(), db, strings.NewReader(longer), Options{DryRun: dryRun})
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if report.Imported != 1 || report.Duplicates != 4 {
+			t.Errorf("Expected the third identical request imported and 4 duplicates (dry run %v), got %+v", dryRun, report)
+		}
+	}
+	logs, err := db.QueryLogs(database.LogFilter{URL: "wp-login"})
+	if err != nil {
+		t.Fatalf("Failed to query logs: %v", err)
+	}
+	if len(logs) != 3 {
+		t.Errorf("Expected 3 identical requests, got %d", len(logs))
+	}
+
+	// A different sensor's requests are not duplicates
+	report, err = Run(context.Background(), db, strings.NewReader(combinedLog), Options{Sensor: "web-2"})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if report.Imported != 4 || report.Duplicates != 0 {
+		t.Errorf("Expected another sensor's log imported, got %+v", report)
+	}
+}
+
+func TestRun_NginxJSON(t *testing.T) {
+	db := setupTestDB(t)
+	input := `{"time_iso8601":"2024-05-01T10:00:00+00:00","remote_addr":"192.0.2.10","request_uri":"/.env","status":404,"http_user_agent":"zgrab/0.x","http_referer":""}
+{"msec":"1714557601.250","remote_addr":"192.0.2.11","uri":"/search","args":"q=1","http_x_forwarded_for":"-"}
+{"@timestamp":"2024-05-01T10:00:02Z","client_ip":"192.0.2.12","request":"GET /admin HTTP/1.1"}
+{"time_local":"01/May/2024:10:00:03 +0000","remote_addr":"192.0.2.13"}
+not json
+`
+	report, err := Run(context.Background(), db, strings.NewReader(input), Options{})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if report.Format != FormatNginxJSON || report.Imported != 3 || report.Invalid != 2 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+	logs, err := db.QueryLogs(database.LogFilter{Ascending: true})
+	if err != nil {
+		t.Fatalf("Failed to query logs: %v", err)
+	}
+	if len(logs) != 3 || logs[0].URL != "/.env" || logs[1].URL != "/search?q=1" || logs[2].URL != "/admin" {
+		t.Fatalf("Unexpected logs %+v", logs)
+	}
+	if want := time.Date(2024, 5, 1, 10, 0, 1, 250_000_000, time.UTC); !logs[1].Timestamp.Equal(want) {
+		t.Errorf("Expected $msec as %v, got %v", want, logs[1].Timestamp)
+	}
+}
+
+func TestRun_Anonymize(t *testing.T) {
+	tests := []struct {
+		format, input string
+	}{
+		{FormatCLF, `192.0.2.77 - - [10/Oct/2023:13:55:36 +0000] "GET /a HTTP/1.1" 404 0` + "\n"},
+		{FormatNginxJSON, `{"time_iso8601":"2023-10-10T13:55:36+00:00","remote_addr":"192.0.2.77","request_uri":"/a"}` + "\n"},
+		{FormatJSON, `[{"IPAddress":"192.0.2.77","URL":"/a","Timestamp":"2023-10-10T13:55:36Z"}]`},
+		{FormatNDJSON, `{"IPAddress":"192.0.2.77","URL":"/a","Timestamp":"2023-10-10T13:55:36Z"}` + "\n"},
+		{FormatCSV, "id,ip_address,url,timestamp\n1,192.0.2.77,/a,2023-10-10T13:55:36Z\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			db := setupTestDB(t)
+			anon, err := anonymize.New(anonymize.Truncate, nil)
+			if err != nil {
+				t.Fatalf("Failed to create anonymizer: %v", err)
+			}
+			if err := db.SetAnonymizer(anon); err != nil {
+				t.Fatalf("Failed to set anonymizer: %v", err)
+			}
+			report, err := Run(context.Background(), db, strings.NewReader(tt.input), Options{Format: tt.format})
+			if err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+			logs, err := db.QueryLogs(database.LogFilter{})
+			if err != nil {
+				t.Fatalf("Failed to query logs: %v", err)
+			}
+			if report.Imported != 1 || len(logs) != 1 || logs[0].IPAddress != "192.0.2.0" {
+				t.Errorf("Expected the address truncated, got %+v and %+v", report, logs)
+			}
+		})
+	}
+
+	// Pseudonyms in exports are kept, and so are truncated addresses
+	db := setupTestDB(t)
+	anon, err := anonymize.New(anonymize.HMAC, []string{"import-key-0123456789"})
+	if err != nil {
+		t.Fatalf("Failed to create anonymizer: %v", err)
+	}
+	if err := db.SetAnonymizer(anon); err != nil {
+		t.Fatalf("Failed to set anonymizer: %v", err)
+	}
+	pseudonym := anon.Anonymize("192.0.2.77")
+	export := `{"IPAddress":"` + pseudonym + `","URL":"/a","Timestamp":"2023-10-10T13:55:36Z"}
+{"IPAddress":"198.51.100.77","URL":"/b","Timestamp":"2023-10-10T13:55:37Z"}
+`
+	if _, err := Run(context.Background(), db, strings.NewReader(export), Options{}); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	logs, err := db.QueryLogs(database.LogFilter{Ascending: true})
+	if err != nil {
+		t.Fatalf("Failed to query logs: %v", err)
+	}
+	if len(logs) != 2 || logs[0].IPAddress != pseudonym || logs[1].IPAddress != anon.Anonymize("198.51.100.77") {
+		t.Errorf("Expected the pseudonym kept and the raw address hashed, got %+v", logs)
+	}
+}
+
+func TestRun_Exports(t *testing.T) {
+	tests := []struct {
+		name, format, input string
+		imported, invalid   int64
+	}{
+		{"json", FormatJSON, `[{"IPAddress":"192.0.2.1","URL":"/a","Timestamp":"2025-01-01T00:00:00Z","Sensor":"edge-1"},
+			{"IPAddress":"192.0.2.1","URL":"/b","Timestamp":"yesterday"},{"URL":"/c"}]`, 1, 2},
+		{"ndjson", FormatNDJSON, "{\"IPAddress\":\"192.0.2.1\",\"URL\":\"/a\",\"Timestamp\":\"2025-01-01T00:00:00Z\",\"Sensor\":\"edge-1\"}\n{\"IPAddress\":\n", 1, 1},
+		{"csv", FormatCSV, "id,ip_address,url,timestamp\n1,192.0.2.1,/a,2025-01-01T00:00:00Z\n2,192.0.2.1,/b\n", 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t)
+			report, err := Run(context.Background(), db, strings.NewReader(tt.input), Options{})
+			if err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+			if report.Format != tt.format || report.Imported != tt.imported || report.Invalid != tt.invalid {
+				t.Errorf("Unexpected report %+v", report)
+			}
+		})
+	}
+
+	// Details written by export and archives are stored again
+	db := setupTestDB(t)
+	line := `{"IPAddress":"192.0.2.1","URL":"/a","Timestamp":"2025-01-01T00:00:00Z","Details":{"UserAgent":"curl/8.0","Headers":{"Accept":["*/*"]},"Body":"dXNlcj1hZG1pbg==","Listener":"web","LocalPort":8080}}`
+	if _, err := Run(context.Background(), db, strings.NewReader(line+"\n"), Options{}); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	logs, err := db.QueryLogs(database.LogFilter{Details: true})
+	if err != nil {
+		t.Fatalf("Failed to query logs: %v", err)
+	}
+	if len(logs) != 1 || logs[0].Details.UserAgent != "curl/8.0" || logs[0].Details.Headers.Get("Accept") != "*/*" ||
+		string(logs[0].Details.Body) != "user=admin" || logs[0].Details.LocalPort != 8080 {
+		t.Errorf("Expected the exported details imported, got %+v", logs)
+	}
+
+	db = setupTestDB(t)
+	_, err = Run(context.Background(), db, strings.NewReader(`[{"IPAddress":"192.0.2.1","URL":"/a"},{`), Options{})
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for a truncated array, got %v", err)
+	}
+	if _, err := Run(context.Background(), db, strings.NewReader(""), Options{Format: "xml"}); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
+
+func TestRun_Compressed(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(combinedLog)); err != nil {
+		t.Fatalf("Failed to compress input: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to compress input: %v", err)
+	}
+
+	db := setupTestDB(t)
+	var progress []Report
+	report, err := Run(context.Background(), db, &buf, Options{Progress: func(r Report) { progress = append(progress, r) }})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if report.Format != FormatCLF || report.Imported != 4 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+	if len(progress) != 1 || progress[0].Imported != 4 {
+		t.Errorf("Expected progress after the batch, got %+v", progress)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := map[string]string{
+		"\ufeff[{\"IPAddress\":\"192.0.2.1\"}]":              FormatJSON,
+		"{\"IPAddress\":\"192.0.2.1\",\"URL\":\"/\"}\n":      FormatNDJSON,
+		"  {\"remote_addr\":\"192.0.2.1\"}\n":                FormatNginxJSON,
+		"id,ip_address,url,timestamp\n":                      FormatCSV,
+		`192.0.2.1 - - [10/Oct/2023:13:55:36 -0700] "GET /"`: FormatCLF,
+		"": FormatCLF,
+	}
+	for input, want := range tests {
+		if got := detect(bufio.NewReader(strings.NewReader(input))); got != want {
+			t.Errorf("detect(%q) = %s, want %s", input, got, want)
+		}
+	}
+}
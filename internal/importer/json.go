@@ -0,0 +1,246 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/database"
+)
+
+// nginxReader reads JSON access logs, one object per line, such as those
+// written by an nginx log_format with escape=json. Fields are found by their
+// usual nginx variable names, or a few common alternatives:
+//
+//   - time: time_iso8601, time_local, msec, @timestamp, timestamp or time
+//   - address: remote_addr, client_ip, remote_ip or ip
+//   - URL: request_uri, uri with args or query_string, or request
+//   - user agent, referer and forwarded-for: http_user_agent or user_agent,
+//     http_referer or referer, http_x_forwarded_for
+type nginxReader struct {
+	lines lineReader
+}
+
+func (n *nginxReader) next() (database.ImportRecord, error) {
+	line, err := n.lines.next()
+	if err != nil {
+		return database.ImportRecord{}, err
+	}
+	var fields map[string]any
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	if err := dec.Decode(&fields); err != nil {
+		return database.ImportRecord{}, n.lines.invalid("invalid JSON: %v", err)
+	}
+	field := func(names ...string) string {
+		for _, name := range names {
+			switch v := fields[name].(type) {
+			case string:
+				if v != "" && v != "-" {
+					return v
+				}
+			case json.Number:
+				return v.String()
+			}
+		}
+		return ""
+	}
+
+	ts, err := nginxTime(field("time_iso8601", "time_local", "msec", "@timestamp", "timestamp", "time"))
+	if err != nil {
+		return database.ImportRecord{}, n.lines.invalid("%v", err)
+	}
+	addr := field("remote_addr", "client_ip", "remote_ip", "ip")
+	if _, err := netip.ParseAddr(addr); err != nil {
+		return database.ImportRecord{}, n.lines.invalid("address %q is not an IP address", addr)
+	}
+	url := field("request_uri")
+	if url == "" {
+		if url = field("uri"); url != "" {
+			if query := field("args", "query_string"); query != "" {
+				url += "?" + query
+			}
+		} else if url, _ = requestURL(field("request")); url == "" {
+			return database.ImportRecord{}, n.lines.invalid("no request_uri, uri or request field")
+		}
+	}
+
+	rec := database.ImportRecord{Timestamp: ts, IPAddress: addr, URL: url}
+	rec.Details.UserAgent = field("http_user_agent", "user_agent")
+	var headers http.Header
+	if referer := field("http_referer", "referer"); referer != "" {
+		headers = http.Header{"Referer": {referer}}
+	}
+	if forwarded := field("http_x_forwarded_for"); forwarded != "" {
+		if headers == nil {
+			headers = http.Header{}
+		}
+		headers.Set("X-Forwarded-For", forwarded)
+	}
+	rec.Details.Headers = headers
+	return rec, nil
+}
+
+// nginxTime parses an RFC 3339 time, a Common Log Format time, or
+// seconds since the epoch as in $msec
+func nginxTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, errors.New("no time field")
+	}
+	if ts, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return ts, nil
+	}
+	if ts, err := time.Parse(clfTime, s); err == nil {
+		return ts, nil
+	}
+	if secs, err := strconv.ParseFloat(s, 64); err == nil && secs > 0 {
+		whole, frac := math.Modf(secs)
+		return time.Unix(int64(whole), int64(math.Round(frac*1e3))*int64(time.Millisecond)), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
+
+// exportRecord converts a log from an export, which must have an address
+// and a URL, with its details when they were exported
+func exportRecord(log database.RequestLog) (database.ImportRecord, string) {
+	if log.IPAddress == "" || log.URL == "" {
+		return database.ImportRecord{}, "no IPAddress or URL"
+	}
+	rec := database.ImportRecord{Timestamp: log.Timestamp, IPAddress: log.IPAddress, URL: log.URL, Sensor: log.Sensor}
+	if log.Details != nil {
+		rec.Details = *log.Details
+	}
+	return rec, ""
+}
+
+// jsonReader reads a JSON array of logs one at a time
+type jsonReader struct {
+	r       io.Reader
+	dec     *json.Decoder
+	n       int // records read
+	started bool
+}
+
+func (j *jsonReader) next() (database.ImportRecord, error) {
+	if !j.started {
+		j.started = true
+		j.dec = json.NewDecoder(j.r)
+		tok, err := j.dec.Token()
+		if err != nil {
+			return database.ImportRecord{}, j.fatal(err)
+		}
+		if tok != json.Delim('[') {
+			return database.ImportRecord{}, fmt.Errorf("%w: expected a JSON array of logs", ErrInvalidInput)
+		}
+	}
+	if !j.dec.More() {
+		if _, err := j.dec.Token(); err != nil {
+			return database.ImportRecord{}, j.fatal(err)
+		}
+		return database.ImportRecord{}, io.EOF
+	}
+	j.n++
+	var log database.RequestLog
+	if err := j.dec.Decode(&log); err != nil {
+		// The decoder has read past a record with the wrong types, but
+		// can't go on after a syntax or read error
+		var typeErr *json.UnmarshalTypeError
+		var timeErr *time.ParseError
+		if errors.As(err, &typeErr) || errors.As(err, &timeErr) {
+			return database.ImportRecord{}, &invalidError{pos: fmt.Sprintf("record %d", j.n), msg: err.Error()}
+		}
+		return database.ImportRecord{}, j.fatal(err)
+	}
+	rec, problem := exportRecord(log)
+	if problem != "" {
+		return database.ImportRecord{}, &invalidError{pos: fmt.Sprintf("record %d", j.n), msg: problem}
+	}
+	return rec, nil
+}
+
+// fatal describes an error that ends the array
+func (j *jsonReader) fatal(err error) error {
+	var syntax *json.SyntaxError
+	if errors.As(err, &syntax) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return fmt.Errorf("%w: JSON after record %d: %v", ErrInvalidInput, j.n, err)
+	}
+	return fmt.Errorf("failed to read input: %w", err)
+}
+
+// ndjsonReader reads one exported log per line
+type ndjsonReader struct {
+	lines lineReader
+}
+
+func (n *ndjsonReader) next() (database.ImportRecord, error) {
+	line, err := n.lines.next()
+	if err != nil {
+		return database.ImportRecord{}, err
+	}
+	var log database.RequestLog
+	if err := json.Unmarshal(line, &log); err != nil {
+		return database.ImportRecord{}, n.lines.invalid("invalid JSON: %v", err)
+	}
+	rec, problem := exportRecord(log)
+	if problem != "" {
+		return database.ImportRecord{}, n.lines.invalid("%s", problem)
+	}
+	return rec, nil
+}
+
+// csvHeader is the column layout of CSV exports
+var csvHeader = []string{"id", "ip_address", "url", "timestamp"}
+
+// csvReader reads a CSV export
+type csvReader struct {
+	r  *bufio.Reader
+	cr *csv.Reader
+}
+
+func (c *csvReader) next() (database.ImportRecord, error) {
+	if c.cr == nil {
+		c.cr = csv.NewReader(c.r)
+		c.cr.FieldsPerRecord = -1
+	}
+	for {
+		record, err := c.cr.Read()
+		if errors.Is(err, io.EOF) {
+			return database.ImportRecord{}, io.EOF
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return database.ImportRecord{}, &invalidError{pos: fmt.Sprintf("line %d", parseErr.Line), msg: parseErr.Err.Error()}
+		}
+		if err != nil {
+			return database.ImportRecord{}, fmt.Errorf("failed to read input: %w", err)
+		}
+		line, _ := c.cr.FieldPos(0)
+		if line == 1 && record[0] == csvHeader[0] {
+			continue
+		}
+		invalid := func(msg string) error {
+			return &invalidError{pos: fmt.Sprintf("line %d", line), msg: msg}
+		}
+		if len(record) != len(csvHeader) {
+			return database.ImportRecord{}, invalid(fmt.Sprintf("%d fields, want %d", len(record), len(csvHeader)))
+		}
+		ts, err := time.Parse(time.RFC3339Nano, record[3])
+		if err != nil {
+			return database.ImportRecord{}, invalid(fmt.Sprintf("invalid time %q", record[3]))
+		}
+		rec, problem := exportRecord(database.RequestLog{IPAddress: record[1], URL: record[2], Timestamp: ts})
+		if problem != "" {
+			return database.ImportRecord{}, invalid(problem)
+		}
+		return rec, nil
+	}
+}
@@ -0,0 +1,86 @@
+// Package listener opens the addresses the server accepts requests on,
+// each optionally behind a PROXY protocol header and TLS, and tells the
+// handlers which listener and port a request arrived on. A sniffing
+// listener serves only the clients that speak HTTP, or TLS, and hands the
+// others to a Recorder.
+package listener
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/dangogh/silver-eureka/internal/config"
+)
+
+// Listen opens the address of cfg. Connections give the client and port
+// named by their PROXY header as their remote and local address, when
+// cfg expects one, and are TLS when cfg has a certificate. When cfg sniffs,
+// clients may speak plain HTTP or TLS alike, and record, when not nil, is
+// given those speaking anything else.
+func Listen(cfg config.ListenerConfig, record Recorder) (net.Listener, error) {
+	var tlsConfig *tls.Config
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load certificate of %s: %w", cfg.Address, err)
+		}
+		tlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+			NextProtos:   []string{"h2", "http/1.1"},
+		}
+	}
+
+	ln, err := net.Listen("tcp", cfg.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", cfg.Address, err)
+	}
+	if cfg.ProxyProtocol {
+		ln = &proxyListener{Listener: ln}
+	}
+	switch {
+	case cfg.Sniff:
+		maxConns := cfg.SniffMaxConns
+		if maxConns == 0 {
+			maxConns = config.DefaultSniffMaxConns
+		}
+		ln = newSniffListener(ln, cfg.Label, maxConns, tlsConfig, record)
+	case tlsConfig != nil:
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+	return ln, nil
+}
+
+// Info identifies the listener a request arrived on
+type Info struct {
+	Label string // the listener's label, "" when it has none
+	Port  int    // the port the client connected to, 0 when unknown
+}
+
+// labelKey is the context key of a listener's label
+type labelKey struct{}
+
+// BaseContext returns an http.Server BaseContext that tags the requests
+// served with label
+func BaseContext(label string) func(net.Listener) context.Context {
+	return func(net.Listener) context.Context {
+		return context.WithValue(context.Background(), labelKey{}, label)
+	}
+}
+
+// FromContext returns the listener of the request whose context is ctx.
+// The port is that of the connection's local address, which for a PROXY
+// protocol listener is the one the client connected to at the proxy.
+func FromContext(ctx context.Context) Info {
+	var info Info
+	info.Label, _ = ctx.Value(labelKey{}).(string)
+	if addr, ok := ctx.Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if tcp, ok := addr.(*net.TCPAddr); ok {
+			info.Port = tcp.Port
+		}
+	}
+	return info
+}
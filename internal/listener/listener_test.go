@@ -0,0 +1,257 @@
+package listener
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/config"
+)
+
+func TestReadProxyHeader(t *testing.T) {
+	v2 := func(command, family byte, addrs []byte) string {
+		var b bytes.Buffer
+		b.Write(proxyV2Signature)
+		b.WriteByte(0x20 | command)
+		b.WriteByte(family)
+		if err := binary.Write(&b, binary.BigEndian, uint16(len(addrs))); err != nil {
+			t.Fatalf("Failed to build header: %v", err)
+		}
+		b.Write(addrs)
+		return b.String()
+	}
+	v4 := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0x01, 0xbb}
+	v6 := append(append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...), 0xdc, 0x04, 0x1f, 0x90)
+
+	tests := []struct {
+		name, header string
+		src, dst     string // "" = none named
+		err          bool
+	}{
+		{"v1 IPv4", "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n", "192.0.2.1:56324", "198.51.100.1:443", false},
+		{"v1 IPv6", "PROXY TCP6 2001:db8::1 2001:db8::2 56324 8080\r\n", "[2001:db8::1]:56324", "[2001:db8::2]:8080", false},
+		{"v1 unknown", "PROXY UNKNOWN\r\n", "", "", false},
+		{"v2 IPv4", v2(1, 0x11, v4), "192.0.2.1:56324", "198.51.100.1:443", false},
+		{"v2 IPv6 with TLV", v2(1, 0x21, append(v6, 0x04, 0x00, 0x01, 0x00)), "[2001:db8::1]:56324", "[2001:db8::2]:8080", false},
+		{"v2 local", v2(0, 0x00, nil), "", "", false},
+		{"plain HTTP", "GET / HTTP/1.1\r\nHost: example\r\n\r\n", "", "", true},
+		{"v1 bad port", "PROXY TCP4 192.0.2.1 198.51.100.1 56324 99999\r\n", "", "", true},
+		{"v1 unterminated", "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\n", "", "", true},
+		{"v2 short", v2(1, 0x11, v4[:8]), "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(tt.header + "rest"))
+			src, dst, err := readProxyHeader(r)
+			if tt.err {
+				if err == nil {
+					t.Errorf("Expected an error, got %v and %v", src, dst)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readProxyHeader failed: %v", err)
+			}
+			if got := addrString(src); got != tt.src {
+				t.Errorf("Expected source %q, got %q", tt.src, got)
+			}
+			if got := addrString(dst); got != tt.dst {
+				t.Errorf("Expected destination %q, got %q", tt.dst, got)
+			}
+			if rest, err := io.ReadAll(r); err != nil || string(rest) != "rest" {
+				t.Errorf("Expected the data after the header to be left, got %q (%v)", rest, err)
+			}
+		})
+	}
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
+
+// serve serves a handler reporting each request's listener and client on
+// a listener opened from cfg, with record as its Recorder
+func serve(t *testing.T, cfg config.ListenerConfig, record Recorder) string {
+	t.Helper()
+	cfg.Address = "127.0.0.1:0"
+	ln, err := Listen(cfg, record)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			info := FromContext(r.Context())
+			fmt.Fprintf(w, "%s %d %s", info.Label, info.Port, r.RemoteAddr)
+		}),
+		ReadHeaderTimeout: 5 * time.Second,
+		BaseContext:       BaseContext(cfg.Label),
+	}
+	go func() {
+		if err := server.Serve(ln); err != http.ErrServerClosed {
+			t.Errorf("Serve failed: %v", err)
+		}
+	}()
+	t.Cleanup(func() {
+		if err := server.Close(); err != nil {
+			// Closing is best effort in tests
+		}
+	})
+	return ln.Addr().String()
+}
+
+// exchange sends raw to addr and returns the response body, or "" when
+// the connection is closed without one
+func exchange(t *testing.T, addr, raw string) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			// Closing is best effort in tests
+		}
+	}()
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("SetDeadline failed: %v", err)
+	}
+	if _, err := io.WriteString(conn, raw); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return ""
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			// Closing is best effort in tests
+		}
+	}()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	return string(body)
+}
+
+func TestListen_ProxyProtocol(t *testing.T) {
+	addr := serve(t, config.ListenerConfig{Label: "behind-lb", ProxyProtocol: true}, nil)
+	request := "GET / HTTP/1.1\r\nHost: example\r\nConnection: close\r\n\r\n"
+
+	got := exchange(t, addr, "PROXY TCP4 192.0.2.1 198.51.100.1 56324 8443\r\n"+request)
+	if got != "behind-lb 8443 192.0.2.1:56324" {
+		t.Errorf("Expected the proxied client and port, got %q", got)
+	}
+
+	// A proxy's health check names no client, so the connection's own
+	// addresses are kept
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatalf("Invalid address %q: %v", addr, err)
+	}
+	got = exchange(t, addr, "PROXY UNKNOWN\r\n"+request)
+	if !strings.HasPrefix(got, "behind-lb "+port+" 127.0.0.1:") {
+		t.Errorf("Expected the connection's addresses, got %q", got)
+	}
+
+	if got := exchange(t, addr, request); got != "" {
+		t.Errorf("Expected a connection without a PROXY header to be closed, got %q", got)
+	}
+}
+
+func TestListen_TLS(t *testing.T) {
+	certFile, keyFile := writeCert(t)
+	addr := serve(t, config.ListenerConfig{Label: "alt-tls", CertFile: certFile, KeyFile: keyFile}, nil)
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatalf("Invalid address %q: %v", addr, err)
+	}
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err := client.Get("https://" + addr + "/")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			// Closing is best effort in tests
+		}
+	}()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	if !strings.HasPrefix(string(body), "alt-tls "+port+" ") {
+		t.Errorf("Expected the label and port, got %q", body)
+	}
+
+	if _, err := Listen(config.ListenerConfig{Address: "127.0.0.1:0", CertFile: keyFile, KeyFile: keyFile}, nil); err == nil {
+		t.Error("Expected an error for an invalid certificate")
+	}
+}
+
+func TestFromContext_NoListener(t *testing.T) {
+	r, err := http.NewRequest(http.MethodGet, "/", nil)
+	if err != nil {
+		t.Fatalf("NewRequest failed: %v", err)
+	}
+	if info := FromContext(r.Context()); info != (Info{}) {
+		t.Errorf("Expected no listener, got %+v", info)
+	}
+}
+
+// writeCert writes a self-signed certificate for 127.0.0.1 and its key,
+// returning their paths
+func writeCert(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	return certFile, keyFile
+}
@@ -0,0 +1,209 @@
+package listener
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyHeaderTimeout limits the wait for a connection's PROXY header
+const proxyHeaderTimeout = 5 * time.Second
+
+// proxyV2Signature starts a binary (version 2) PROXY header
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// errNoProxyHeader is the error of a connection without a valid PROXY
+// header
+var errNoProxyHeader = errors.New("invalid PROXY protocol header")
+
+// proxyListener accepts connections that start with a PROXY protocol
+// header. The header is read when the connection is first used, by the
+// goroutine serving it, so a slow client holds up no other.
+type proxyListener struct {
+	net.Listener
+}
+
+// Accept implements net.Listener
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+// proxyConn is a connection whose addresses are those of its PROXY header
+type proxyConn struct {
+	net.Conn
+	r *bufio.Reader
+
+	once   sync.Once
+	remote net.Addr // nil when the header names none
+	local  net.Addr
+	err    error
+}
+
+// readHeader reads the PROXY header once, before anything else is read
+func (c *proxyConn) readHeader() {
+	c.once.Do(func() {
+		if err := c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout)); err != nil {
+			c.err = err
+			return
+		}
+		c.remote, c.local, c.err = readProxyHeader(c.r)
+		if c.err != nil {
+			slog.Debug("Rejected connection without a PROXY header", "remote_addr", c.Conn.RemoteAddr().String(), "error", c.err)
+			// A read error, so the HTTP server closes the connection
+			// without answering
+			c.err = &net.OpError{Op: "read", Net: "tcp", Source: c.Conn.LocalAddr(), Addr: c.Conn.RemoteAddr(), Err: c.err}
+			return
+		}
+		// The server sets its own deadlines from here on
+		c.err = c.Conn.SetReadDeadline(time.Time{})
+	})
+}
+
+// Handshake reads the PROXY header of a connection accepted by a PROXY
+// protocol listener and reports an invalid one. It does nothing for other
+// connections. A server that speaks first calls it before writing.
+func Handshake(conn net.Conn) error {
+	if c, ok := conn.(*proxyConn); ok {
+		c.readHeader()
+		return c.err
+	}
+	return nil
+}
+
+// Read implements net.Conn, failing when the PROXY header is invalid
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+// RemoteAddr returns the client named by the PROXY header
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the address the client connected to at the proxy
+func (c *proxyConn) LocalAddr() net.Addr {
+	c.readHeader()
+	if c.local != nil {
+		return c.local
+	}
+	return c.Conn.LocalAddr()
+}
+
+// readProxyHeader reads a version 1 or 2 PROXY header and returns the
+// source and destination it names, or nil ones for a header that names
+// none, such as a proxy's health check
+func readProxyHeader(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	start, err := r.Peek(len(proxyV2Signature))
+	if err != nil && !bytes.HasPrefix(proxyV2Signature, start) && !bytes.HasPrefix(start, []byte("PROXY ")) {
+		return nil, nil, err
+	}
+	if bytes.Equal(start, proxyV2Signature) {
+		return readProxyV2(r)
+	}
+	return readProxyV1(r)
+}
+
+// readProxyV1 reads a text header such as
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"
+func readProxyV1(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	// The longest header is 107 bytes
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	text, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok || !strings.HasPrefix(text, "PROXY ") {
+		return nil, nil, errNoProxyHeader
+	}
+	fields := strings.Split(text, " ")
+	if fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || fields[1] != "TCP4" && fields[1] != "TCP6" {
+		return nil, nil, errNoProxyHeader
+	}
+	src, err := proxyV1Addr(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := proxyV1Addr(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+// proxyV1Addr parses an address and port of a text header
+func proxyV1Addr(ip, port string) (*net.TCPAddr, error) {
+	addr := net.ParseIP(ip)
+	n, err := strconv.ParseUint(port, 10, 16)
+	if addr == nil || err != nil {
+		return nil, fmt.Errorf("%w: address %s port %s", errNoProxyHeader, ip, port)
+	}
+	return &net.TCPAddr{IP: addr, Port: int(n)}, nil
+}
+
+// readProxyV2 reads a binary header. Only TCP over IPv4 and IPv6 is
+// understood; the addresses of other protocols are skipped, as are TLVs.
+func readProxyV2(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	var head [16]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, nil, err
+	}
+	version, command := head[12]>>4, head[12]&0x0f
+	if version != 2 || command > 1 {
+		return nil, nil, errNoProxyHeader
+	}
+	body := make([]byte, binary.BigEndian.Uint16(head[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, nil, err
+	}
+	if command == 0 {
+		// LOCAL: the proxy's own connection, such as a health check
+		return nil, nil, nil
+	}
+
+	var size int
+	switch head[13] {
+	case 0x11: // TCP over IPv4
+		size = net.IPv4len
+	case 0x21: // TCP over IPv6
+		size = net.IPv6len
+	default:
+		return nil, nil, nil
+	}
+	if len(body) < 2*size+4 {
+		return nil, nil, errNoProxyHeader
+	}
+	src := &net.TCPAddr{IP: net.IP(body[:size]), Port: int(binary.BigEndian.Uint16(body[2*size:]))}
+	dst := &net.TCPAddr{IP: net.IP(body[size : 2*size]), Port: int(binary.BigEndian.Uint16(body[2*size+2:]))}
+	return src, dst, nil
+}
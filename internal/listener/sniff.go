@@ -0,0 +1,256 @@
+package listener
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/config"
+	"github.com/dangogh/silver-eureka/internal/metrics"
+)
+
+// sniffTimeout limits the wait for a connection's first bytes
+const sniffTimeout = 5 * time.Second
+
+// sniffBytes is the most of a connection's first bytes read to classify
+// it, and recorded when it is not served
+const sniffBytes = 1024
+
+// Protocols told apart by a sniffing listener besides those of banner
+// listeners, such as config.BannerSSH
+const (
+	ProtocolHTTP    = "http"
+	ProtocolTLS     = "tls"
+	ProtocolUnknown = "unknown"
+
+	// ProtocolDropped counts the connections closed unclassified because
+	// too many were being sniffed
+	ProtocolDropped = "dropped"
+)
+
+// redisInline are Redis commands scanners send without the RESP framing
+var redisInline = []string{"PING", "INFO", "AUTH", "CONFIG", "SLAVEOF", "REPLICAOF"}
+
+// Sniffed is a connection to a sniffing listener whose client spoke a
+// protocol other than HTTP, or TLS to a listener without a certificate
+type Sniffed struct {
+	Conn     net.Conn // closed once recorded
+	Label    string   // the listener's label, "" when it has none
+	Protocol string   // ProtocolTLS, ProtocolUnknown or a banner protocol
+	Payload  []byte   // the first bytes the client sent
+}
+
+// Recorder records the connections a sniffing listener does not serve
+type Recorder func(Sniffed)
+
+// accepted is the result of an Accept
+type accepted struct {
+	conn net.Conn
+	err  error
+}
+
+// sniffListener classifies each connection by its first bytes, which are
+// awaited by a goroutine per connection, so Accept returns only those it
+// serves: HTTP, and TLS when it has a certificate. Connections beyond its
+// slots are closed unclassified.
+type sniffListener struct {
+	net.Listener
+	label  string
+	tls    *tls.Config // nil = TLS clients are recorded
+	record Recorder    // nil = other protocols are closed unrecorded
+	slots  chan struct{}
+
+	accepted  chan accepted
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// newSniffListener starts classifying the connections of ln, at most
+// maxConns at a time
+func newSniffListener(ln net.Listener, label string, maxConns int, tlsConfig *tls.Config, record Recorder) *sniffListener {
+	l := &sniffListener{
+		Listener: ln,
+		label:    label,
+		tls:      tlsConfig,
+		record:   record,
+		slots:    make(chan struct{}, maxConns),
+		accepted: make(chan accepted),
+		done:     make(chan struct{}),
+	}
+	go l.accept()
+	return l
+}
+
+// Accept implements net.Listener
+func (l *sniffListener) Accept() (net.Conn, error) {
+	select {
+	case a := <-l.accepted:
+		return a.conn, a.err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close implements net.Listener
+func (l *sniffListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// accept accepts connections until the listener is closed, passing on
+// errors for the server to back off from
+func (l *sniffListener) accept() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			select {
+			case l.accepted <- accepted{err: err}:
+			case <-l.done:
+				return
+			}
+			continue
+		}
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			metrics.SniffedConnections.WithLabelValues(ProtocolDropped).Inc()
+			closeConn(conn)
+			continue
+		}
+		go func() {
+			defer func() { <-l.slots }()
+			l.sniff(conn)
+		}()
+	}
+}
+
+// sniff classifies conn, then serves or records it
+func (l *sniffListener) sniff(conn net.Conn) {
+	protocol, r, ok := classifyConn(conn)
+	if !ok {
+		closeConn(conn)
+		return
+	}
+	metrics.SniffedConnections.WithLabelValues(protocol).Inc()
+
+	var served net.Conn
+	switch {
+	case protocol == ProtocolHTTP:
+		served = &bufferedConn{Conn: conn, r: r}
+	case protocol == ProtocolTLS && l.tls != nil:
+		served = tls.Server(&bufferedConn{Conn: conn, r: r}, l.tls)
+	default:
+		slog.Debug("Non-HTTP connection to an HTTP listener", "remote_addr", conn.RemoteAddr().String(),
+			"label", l.label, "protocol", protocol)
+		if l.record != nil {
+			payload, err := r.Peek(r.Buffered())
+			if err != nil {
+				// Only what was read is recorded
+			}
+			l.record(Sniffed{Conn: conn, Label: l.label, Protocol: protocol, Payload: payload})
+		}
+		closeConn(conn)
+		return
+	}
+
+	select {
+	case l.accepted <- accepted{conn: served}:
+	case <-l.done:
+		closeConn(conn)
+	}
+}
+
+// classifyConn waits for the first bytes of conn and returns the protocol
+// they show and a reader starting with them. It fails when the client
+// sends nothing in time, or an invalid PROXY header.
+func classifyConn(conn net.Conn) (string, *bufio.Reader, bool) {
+	if err := Handshake(conn); err != nil {
+		// Logged by the PROXY protocol listener
+		return "", nil, false
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(sniffTimeout)); err != nil {
+		return "", nil, false
+	}
+	r := bufio.NewReaderSize(conn, sniffBytes)
+	if _, err := r.Peek(1); err != nil {
+		return "", nil, false
+	}
+	first, err := r.Peek(r.Buffered())
+	if err != nil {
+		return "", nil, false
+	}
+	// The server sets its own deadlines from here on
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return "", nil, false
+	}
+	return classify(first), r, true
+}
+
+// classify returns the protocol that the first bytes of a connection show
+func classify(b []byte) string {
+	switch {
+	case len(b) == 0:
+		return ProtocolUnknown
+	case b[0] == 0x16 && (len(b) == 1 || b[1] == 0x03):
+		// A TLS handshake record, the ClientHello
+		return ProtocolTLS
+	case bytes.HasPrefix(b, []byte("SSH-")):
+		return config.BannerSSH
+	case b[0] == 0x03 && (len(b) == 1 || b[1] == 0x00):
+		// A TPKT header, around an X.224 Connection Request
+		return config.BannerRDP
+	case b[0] == '*' || isRedisInline(b):
+		return config.BannerRedis
+	case isRequestLine(b):
+		return ProtocolHTTP
+	}
+	return ProtocolUnknown
+}
+
+// isRedisInline reports whether b starts with an inline Redis command
+func isRedisInline(b []byte) bool {
+	word, _, _ := bytes.Cut(b, []byte(" "))
+	word = bytes.TrimRight(word, "\r\n")
+	for _, cmd := range redisInline {
+		if bytes.EqualFold(word, []byte(cmd)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRequestLine reports whether b starts with an HTTP method followed by a
+// space, or is the start of one
+func isRequestLine(b []byte) bool {
+	n := 0
+	for n < len(b) && (b[n] >= 'A' && b[n] <= 'Z' || b[n] == '-' || b[n] == '_') {
+		n++
+	}
+	return n > 0 && (n == len(b) || b[n] == ' ')
+}
+
+// bufferedConn is a connection whose first bytes were read ahead
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// Read implements net.Conn, returning the bytes read ahead first
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// closeConn closes a connection that is not served
+func closeConn(conn net.Conn) {
+	if err := conn.Close(); err != nil {
+		// Nothing was sent, nothing to lose
+	}
+}
@@ -0,0 +1,192 @@
+package listener
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/config"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name, first, want string
+	}{
+		{"GET", "GET / HTTP/1.1\r\nHost: example\r\n\r\n", ProtocolHTTP},
+		{"partial method", "PROP", ProtocolHTTP},
+		{"HTTP/2 preface", "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n", ProtocolHTTP},
+		{"TLS ClientHello", "\x16\x03\x01\x02\x00\x01\x00\x01\xfc\x03\x03", ProtocolTLS},
+		{"SSH", "SSH-2.0-Go\r\n", config.BannerSSH},
+		{"RDP", "\x03\x00\x00\x13\x0e\xe0\x00\x00", config.BannerRDP},
+		{"Redis RESP", "*1\r\n$4\r\nPING\r\n", config.BannerRedis},
+		{"Redis inline", "info\r\n", config.BannerRedis},
+		{"Redis CONFIG", "CONFIG SET dir /tmp\r\n", config.BannerRedis},
+		{"binary", "\x00\x00\x00\x54\xff\x53\x4d\x42", ProtocolUnknown},
+		{"lowercase method", "get / HTTP/1.0\r\n", ProtocolUnknown},
+		{"blank line", "\r\n", ProtocolUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classify([]byte(tt.first)); got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+// recorder returns a Recorder passing on what it is given
+func recorder() (Recorder, chan Sniffed) {
+	ch := make(chan Sniffed, 4)
+	return func(sn Sniffed) { ch <- sn }, ch
+}
+
+// nextSniffed waits for the next recorded connection
+func nextSniffed(t *testing.T, ch chan Sniffed) Sniffed {
+	t.Helper()
+	select {
+	case sn := <-ch:
+		return sn
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a connection to be recorded")
+	}
+	return Sniffed{}
+}
+
+// send writes raw to addr and returns what comes back before the
+// connection is closed
+func send(t *testing.T, addr, raw string) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			// Closing is best effort in tests
+		}
+	}()
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("SetDeadline failed: %v", err)
+	}
+	if _, err := io.WriteString(conn, raw); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	return string(reply)
+}
+
+func TestListen_SniffTLS(t *testing.T) {
+	certFile, keyFile := writeCert(t)
+	record, recorded := recorder()
+	addr := serve(t, config.ListenerConfig{Label: "mixed", CertFile: certFile, KeyFile: keyFile, Sniff: true}, record)
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatalf("Invalid address %q: %v", addr, err)
+	}
+
+	// Plain HTTP and HTTPS share the port
+	request := "GET / HTTP/1.1\r\nHost: example\r\nConnection: close\r\n\r\n"
+	if got := exchange(t, addr, request); !strings.HasPrefix(got, "mixed "+port+" 127.0.0.1:") {
+		t.Errorf("Expected plain HTTP to be served, got %q", got)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err := client.Get("https://" + addr + "/")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			// Closing is best effort in tests
+		}
+	}()
+	if resp.TLS == nil {
+		t.Error("Expected the response over TLS")
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	if !strings.HasPrefix(string(body), "mixed "+port+" ") {
+		t.Errorf("Expected HTTPS to be served, got %q", body)
+	}
+
+	// Anything else is recorded and closed without an answer
+	if got := send(t, addr, "SSH-2.0-Go\r\n"); got != "" {
+		t.Errorf("Expected no answer to SSH, got %q", got)
+	}
+	sn := nextSniffed(t, recorded)
+	if sn.Protocol != config.BannerSSH || sn.Label != "mixed" || string(sn.Payload) != "SSH-2.0-Go\r\n" ||
+		strconv.Itoa(sn.Conn.LocalAddr().(*net.TCPAddr).Port) != port {
+		t.Errorf("Unexpected recorded connection: %+v", sn)
+	}
+}
+
+func TestListen_SniffWithoutCertificate(t *testing.T) {
+	record, recorded := recorder()
+	addr := serve(t, config.ListenerConfig{Sniff: true}, record)
+
+	// TLS to a plain HTTP listener is recorded rather than answered with a
+	// 400
+	hello := "\x16\x03\x01\x00\x05\x01\x00\x00\x01\x00"
+	if got := send(t, addr, hello); got != "" {
+		t.Errorf("Expected no answer to TLS, got %q", got)
+	}
+	if sn := nextSniffed(t, recorded); sn.Protocol != ProtocolTLS || string(sn.Payload) != hello {
+		t.Errorf("Unexpected recorded connection: %+v", sn)
+	}
+
+	if got := send(t, addr, "\x00\x01binary"); got != "" {
+		t.Errorf("Expected no answer to unknown bytes, got %q", got)
+	}
+	if sn := nextSniffed(t, recorded); sn.Protocol != ProtocolUnknown {
+		t.Errorf("Unexpected recorded connection: %+v", sn)
+	}
+}
+
+func TestListen_SniffMaxConns(t *testing.T) {
+	addr := serve(t, config.ListenerConfig{Sniff: true, SniffMaxConns: 1}, nil)
+
+	// A silent client takes the only slot while it is sniffed
+	silent, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	over, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer func() {
+		if err := over.Close(); err != nil {
+			// Closing is best effort in tests
+		}
+	}()
+	if err := over.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("SetReadDeadline failed: %v", err)
+	}
+	var ne net.Error
+	if _, err := over.Read(make([]byte, 1)); err == nil || errors.As(err, &ne) && ne.Timeout() {
+		t.Errorf("Expected the connection over the limit to be closed at once, got %v", err)
+	}
+
+	// The slot is free again once the silent client leaves
+	if err := silent.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	request := "GET / HTTP/1.1\r\nHost: example\r\nConnection: close\r\n\r\n"
+	deadline := time.Now().Add(2 * time.Second)
+	for exchange(t, addr, request) == "" {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected a request to be served after the slot was freed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
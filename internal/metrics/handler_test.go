@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	DBRetries.Inc()
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Expected Prometheus text content type, got %s", ct)
+	}
+	body := rec.Body.String()
+	for _, name := range []string{
+		"silver_eureka_db_retries_total",
+		"silver_eureka_db_busy_total",
+		"silver_eureka_db_insert_duration_seconds_bucket",
+		"silver_eureka_rate_limit_rejections_total",
+		"silver_eureka_retention_runs_total",
+	} {
+		if !strings.Contains(body, "# TYPE "+name) && !strings.Contains(body, name+"{") {
+			t.Errorf("Expected %s in output", name)
+		}
+	}
+}
+
+func TestInstrument(t *testing.T) {
+	before := HTTPRequests.WithLabelValues("test-route", "418").Value()
+	h := Instrument("test-route", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusTeapot {
+		t.Errorf("Expected status 418, got %d", rec.Code)
+	}
+	if got := HTTPRequests.WithLabelValues("test-route", "418").Value() - before; got != 1 {
+		t.Errorf("Expected 1 counted response, got %g", got)
+	}
+}
+
+func TestInstrument_ImplicitOK(t *testing.T) {
+	before := HTTPRequests.WithLabelValues("test-implicit", "200").Value()
+	h := Instrument("test-implicit", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := w.Write([]byte("ok")); err != nil {
+			t.Errorf("Failed to write response: %v", err)
+		}
+	}))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if got := HTTPRequests.WithLabelValues("test-implicit", "200").Value() - before; got != 1 {
+		t.Errorf("Expected 1 counted 200 response, got %g", got)
+	}
+}
@@ -0,0 +1,160 @@
+package metrics
+
+// Application metrics registered on the Default registry
+var (
+	// HTTPRequests counts responses by route group and status code
+	HTTPRequests = Default.NewCounterVec("silver_eureka_http_requests_total",
+		"HTTP responses by route group and status code.", "route", "status")
+
+	// RequestsLogged counts catch-all requests by database logging result
+	RequestsLogged = Default.NewCounterVec("silver_eureka_requests_logged_total",
+		"Requests written to the database by result (ok or error).", "result")
+
+	// InvalidIPs counts client addresses stored that were not IP addresses
+	InvalidIPs = Default.NewCounter("silver_eureka_invalid_ips_total",
+		"Client addresses stored as text because they were not IP addresses.")
+
+	// DBInsertDuration observes the latency of request log inserts, including retries
+	DBInsertDuration = Default.NewHistogram("silver_eureka_db_insert_duration_seconds",
+		"Latency of request log inserts including retries.", DefaultBuckets)
+
+	// DBRetries counts database operations retried by executeWithRetry
+	DBRetries = Default.NewCounter("silver_eureka_db_retries_total",
+		"Database operations retried after a retryable error.")
+
+	// DBBusy counts SQLITE_BUSY / database locked errors seen by executeWithRetry
+	DBBusy = Default.NewCounter("silver_eureka_db_busy_total",
+		"SQLITE_BUSY and database locked errors.")
+
+	// DBRetryExhausted counts operations that failed after all retries
+	DBRetryExhausted = Default.NewCounter("silver_eureka_db_retry_exhausted_total",
+		"Database operations that failed after exhausting retries.")
+
+	// RateLimitRejections counts rate limit rejections by route group and scope
+	RateLimitRejections = Default.NewCounterVec("silver_eureka_rate_limit_rejections_total",
+		"Requests rejected by the rate limiter by route group and scope (global or per_ip).", "route", "scope")
+
+	// RateLimiterEntries reports the number of per-client limiters held
+	RateLimiterEntries = Default.NewGaugeFuncVec("silver_eureka_rate_limiter_entries",
+		"Per-client rate limiters held in memory by route group.", "route")
+
+	// RateLimiterEvictions reports per-client limiter evictions
+	RateLimiterEvictions = Default.NewCounterFuncVec("silver_eureka_rate_limiter_evictions_total",
+		"Per-client rate limiters evicted by route group and reason (lru or idle).", "route", "reason")
+
+	// ActiveSessions reports the number of live web sessions
+	ActiveSessions = Default.NewGaugeFuncVec("silver_eureka_active_sessions",
+		"Live web interface sessions.")
+
+	// RetentionRuns counts retention cleanup runs by result
+	RetentionRuns = Default.NewCounterVec("silver_eureka_retention_runs_total",
+		"Retention cleanup runs by result (ok, interrupted or error).", "result")
+
+	// RetentionDeleted counts rows deleted by retention cleanup
+	RetentionDeleted = Default.NewCounter("silver_eureka_retention_deleted_rows_total",
+		"Request log rows deleted by retention cleanup.")
+
+	// RetentionLastRun is the Unix time of the last successful retention run
+	RetentionLastRun = Default.NewGauge("silver_eureka_retention_last_success_timestamp_seconds",
+		"Unix time of the last successful retention cleanup.")
+
+	// RetentionRollupsDeleted counts rollup rows deleted by retention cleanup
+	RetentionRollupsDeleted = Default.NewCounter("silver_eureka_retention_deleted_rollup_rows_total",
+		"Hourly and daily rollup rows deleted by retention cleanup.")
+
+	// RetentionDetailsCleared counts request logs whose captured headers and
+	// body were cleared by retention cleanup
+	RetentionDetailsCleared = Default.NewCounter("silver_eureka_retention_cleared_details_total",
+		"Request logs whose captured headers and body were cleared by retention cleanup.")
+
+	// RetentionArchived counts request logs written to archive files before deletion
+	RetentionArchived = Default.NewCounter("silver_eureka_retention_archived_rows_total",
+		"Request log rows archived to NDJSON files by retention cleanup.")
+
+	// DBUsedSize is the size of the database's used pages after the last retention run
+	DBUsedSize = Default.NewGauge("silver_eureka_db_used_bytes",
+		"Bytes of the database file holding data, measured by retention cleanup.")
+
+	// MaintenanceSteps counts the batched delete and incremental vacuum steps
+	// of retention cleanup by task
+	MaintenanceSteps = Default.NewCounterVec("silver_eureka_maintenance_steps_total",
+		"Retention cleanup steps by task (logs, details, hourly_rollups, daily_rollups or vacuum).", "task")
+
+	// MaintenanceRunning is 1 while retention cleanup runs
+	MaintenanceRunning = Default.NewGauge("silver_eureka_maintenance_running",
+		"1 while retention cleanup is running, otherwise 0.")
+
+	// VacuumFreedPages counts pages returned to the file system by incremental vacuum
+	VacuumFreedPages = Default.NewCounter("silver_eureka_vacuum_freed_pages_total",
+		"Database pages returned to the file system by incremental vacuum.")
+
+	// DBFreePages is the number of unused pages left in the database file
+	DBFreePages = Default.NewGauge("silver_eureka_db_free_pages",
+		"Unused pages in the database file, measured by retention cleanup.")
+
+	// BackupRuns counts database snapshots by result
+	BackupRuns = Default.NewCounterVec("silver_eureka_backup_runs_total",
+		"Database snapshots by result (ok or error).", "result")
+
+	// BackupLastSuccess is the Unix time of the last successful snapshot
+	BackupLastSuccess = Default.NewGauge("silver_eureka_backup_last_success_timestamp_seconds",
+		"Unix time of the last successful database snapshot.")
+
+	// BackupSize is the compressed size of the last successful snapshot
+	BackupSize = Default.NewGauge("silver_eureka_backup_size_bytes",
+		"Compressed size of the last successful database snapshot.")
+
+	// AlertsRaised counts alerts by rule and whether they were sent or held
+	// back by the cooldown
+	AlertsRaised = Default.NewCounterVec("silver_eureka_alerts_total",
+		"Alerts raised by rule and result (sent or suppressed).", "rule", "result")
+
+	// AlertDeliveries counts webhook delivery attempts by result
+	AlertDeliveries = Default.NewCounterVec("silver_eureka_alert_deliveries_total",
+		"Alert webhook deliveries by result (ok, retry, failed or dropped).", "result")
+
+	// DigestsSent counts email digests by result
+	DigestsSent = Default.NewCounterVec("silver_eureka_digests_total",
+		"Email digests by result (ok or error).", "result")
+
+	// SyslogMessages counts messages for the syslog collector by result
+	SyslogMessages = Default.NewCounterVec("silver_eureka_syslog_messages_total",
+		"Syslog messages by result (sent, buffered or dropped).", "result")
+
+	// SyslogBufferBytes is the size of the messages waiting in the syslog buffer
+	SyslogBufferBytes = Default.NewGauge("silver_eureka_syslog_buffer_bytes",
+		"Bytes of messages waiting in the on-disk syslog buffer.")
+
+	// SensorRecords counts requests forwarded to the collector by result
+	SensorRecords = Default.NewCounterVec("silver_eureka_sensor_records_total",
+		"Requests forwarded to the collector by result (sent, rejected or dropped).", "result")
+
+	// SensorSpoolBytes is the size of the requests waiting in the sensor spool
+	SensorSpoolBytes = Default.NewGauge("silver_eureka_sensor_spool_bytes",
+		"Bytes of requests waiting in the on-disk sensor spool.")
+
+	// CollectorRecords counts requests received from sensors
+	CollectorRecords = Default.NewCounterVec("silver_eureka_collector_records_total",
+		"Requests received from sensors by sensor and result (accepted or rejected).", "sensor", "result")
+
+	// ImportedLogs counts records read by imports by result
+	ImportedLogs = Default.NewCounterVec("silver_eureka_imported_logs_total",
+		"Records read by log imports by result (imported, duplicate or invalid).", "result")
+
+	// SinkEvents counts events for each event sink by result
+	SinkEvents = Default.NewCounterVec("silver_eureka_sink_events_total",
+		"Events by sink and result (sent, retried, failed or dropped).", "sink", "result")
+
+	// OTelRecords counts log records and spans for the OTLP collector
+	OTelRecords = Default.NewCounterVec("silver_eureka_otel_records_total",
+		"OTLP log records and spans by signal and result (sent, failed or dropped).", "signal", "result")
+
+	// BannerConnections counts connections to banner listeners by protocol and result
+	BannerConnections = Default.NewCounterVec("silver_eureka_banner_connections_total",
+		"Connections to banner listeners by protocol and result (logged, error, banned or dropped).", "protocol", "result")
+
+	// SniffedConnections counts connections to sniffing HTTP listeners by
+	// the protocol their first bytes showed, or dropped over the limit
+	SniffedConnections = Default.NewCounterVec("silver_eureka_sniffed_connections_total",
+		"Connections to sniffing HTTP listeners by detected protocol (http, tls, ssh, rdp, redis or unknown), or dropped when too many were being sniffed.", "protocol")
+)
@@ -0,0 +1,401 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Registry holds metric families and renders them in the Prometheus text
+// exposition format
+type Registry struct {
+	mu       sync.RWMutex
+	families map[string]family
+}
+
+// family is one named metric with HELP and TYPE lines
+type family interface {
+	name() string
+	write(w *strings.Builder)
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]family)}
+}
+
+// Default is the registry served by Handler
+var Default = NewRegistry()
+
+// register adds a family, panicking on duplicate names as that is a programming error
+func (r *Registry) register(f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.families[f.name()]; exists {
+		panic("metrics: duplicate registration of " + f.name())
+	}
+	r.families[f.name()] = f
+}
+
+// WriteTo writes all metrics in the Prometheus text format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		r.families[name].write(&b)
+	}
+	r.mu.RUnlock()
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// writeHeader writes the HELP and TYPE lines of a family
+func writeHeader(b *strings.Builder, name, help, typ string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, typ)
+}
+
+// writeSample writes one sample line
+func writeSample(b *strings.Builder, name string, labels []string, values []string, v float64) {
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(b, `%s="%s"`, label, escapeLabel(values[i]))
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatFloat(v))
+	b.WriteByte('\n')
+}
+
+// formatFloat formats a sample value
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escapeHelp escapes backslashes and newlines in HELP text
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+// escapeLabel escapes backslashes, double quotes and newlines in label values
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	bits atomic.Uint64
+}
+
+// Inc adds one
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds v, which must not be negative
+func (c *Counter) Add(v float64) {
+	for {
+		old := c.bits.Load()
+		if c.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// Value returns the current value
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(c.bits.Load())
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	Counter
+}
+
+// Set replaces the value
+func (g *Gauge) Set(v float64) {
+	g.bits.Store(math.Float64bits(v))
+}
+
+// vec holds labelled children of a metric family
+type vec[T any] struct {
+	mu       sync.RWMutex
+	labels   []string
+	children map[string]*child[T]
+	newChild func() *T
+}
+
+// child is one labelled series
+type child[T any] struct {
+	values []string
+	metric *T
+}
+
+// with returns the child for the given label values, creating it if needed
+func (v *vec[T]) with(values ...string) *T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: expected %d label values, got %d", len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.RLock()
+	c, ok := v.children[key]
+	v.mu.RUnlock()
+	if ok {
+		return c.metric
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if c, ok := v.children[key]; ok {
+		return c.metric
+	}
+	c = &child[T]{values: append([]string(nil), values...), metric: v.newChild()}
+	v.children[key] = c
+	return c.metric
+}
+
+// sorted returns the children ordered by label values
+func (v *vec[T]) sorted() []*child[T] {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	keys := make([]string, 0, len(v.children))
+	for key := range v.children {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	out := make([]*child[T], len(keys))
+	for i, key := range keys {
+		out[i] = v.children[key]
+	}
+	return out
+}
+
+// CounterVec is a family of counters partitioned by labels
+type CounterVec struct {
+	metricName string
+	help       string
+	vec[Counter]
+}
+
+// NewCounterVec creates and registers a labelled counter family
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	cv := &CounterVec{
+		metricName: name,
+		help:       help,
+		vec: vec[Counter]{
+			labels:   labels,
+			children: make(map[string]*child[Counter]),
+			newChild: func() *Counter { return &Counter{} },
+		},
+	}
+	r.register(cv)
+	return cv
+}
+
+// WithLabelValues returns the counter for the given label values
+func (cv *CounterVec) WithLabelValues(values ...string) *Counter {
+	return cv.with(values...)
+}
+
+func (cv *CounterVec) name() string { return cv.metricName }
+
+func (cv *CounterVec) write(b *strings.Builder) {
+	writeHeader(b, cv.metricName, cv.help, "counter")
+	for _, c := range cv.sorted() {
+		writeSample(b, cv.metricName, cv.labels, c.values, c.metric.Value())
+	}
+}
+
+// NewCounter creates and registers an unlabelled counter
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).WithLabelValues()
+}
+
+// GaugeVec is a family of gauges partitioned by labels
+type GaugeVec struct {
+	metricName string
+	help       string
+	vec[Gauge]
+}
+
+// NewGaugeVec creates and registers a labelled gauge family
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	gv := &GaugeVec{
+		metricName: name,
+		help:       help,
+		vec: vec[Gauge]{
+			labels:   labels,
+			children: make(map[string]*child[Gauge]),
+			newChild: func() *Gauge { return &Gauge{} },
+		},
+	}
+	r.register(gv)
+	return gv
+}
+
+// WithLabelValues returns the gauge for the given label values
+func (gv *GaugeVec) WithLabelValues(values ...string) *Gauge {
+	return gv.with(values...)
+}
+
+func (gv *GaugeVec) name() string { return gv.metricName }
+
+func (gv *GaugeVec) write(b *strings.Builder) {
+	writeHeader(b, gv.metricName, gv.help, "gauge")
+	for _, c := range gv.sorted() {
+		writeSample(b, gv.metricName, gv.labels, c.values, c.metric.Value())
+	}
+}
+
+// NewGauge creates and registers an unlabelled gauge
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	return r.NewGaugeVec(name, help).WithLabelValues()
+}
+
+// FuncVec is a family whose values are read from callbacks at scrape time,
+// for state owned elsewhere such as session counts or map sizes
+type FuncVec struct {
+	metricName string
+	help       string
+	typ        string
+	vec[func() float64]
+}
+
+// NewGaugeFuncVec creates and registers a labelled family of gauge callbacks
+func (r *Registry) NewGaugeFuncVec(name, help string, labels ...string) *FuncVec {
+	return r.newFuncVec(name, help, "gauge", labels)
+}
+
+// NewCounterFuncVec creates and registers a labelled family of counter callbacks
+func (r *Registry) NewCounterFuncVec(name, help string, labels ...string) *FuncVec {
+	return r.newFuncVec(name, help, "counter", labels)
+}
+
+func (r *Registry) newFuncVec(name, help, typ string, labels []string) *FuncVec {
+	fv := &FuncVec{
+		metricName: name,
+		help:       help,
+		typ:        typ,
+		vec: vec[func() float64]{
+			labels:   labels,
+			children: make(map[string]*child[func() float64]),
+			newChild: func() *func() float64 {
+				fn := func() float64 { return 0 }
+				return &fn
+			},
+		},
+	}
+	r.register(fv)
+	return fv
+}
+
+// Set installs the callback for the given label values, replacing any
+// previous callback for the same series
+func (fv *FuncVec) Set(fn func() float64, values ...string) {
+	ptr := fv.with(values...)
+	fv.mu.Lock()
+	*ptr = fn
+	fv.mu.Unlock()
+}
+
+func (fv *FuncVec) name() string { return fv.metricName }
+
+func (fv *FuncVec) write(b *strings.Builder) {
+	writeHeader(b, fv.metricName, fv.help, fv.typ)
+	for _, c := range fv.sorted() {
+		fv.mu.RLock()
+		fn := *c.metric
+		fv.mu.RUnlock()
+		writeSample(b, fv.metricName, fv.labels, c.values, fn())
+	}
+}
+
+// Histogram counts observations into cumulative buckets
+type Histogram struct {
+	metricName string
+	help       string
+	upper      []float64
+	mu         sync.Mutex
+	counts     []uint64 // per bucket, non-cumulative; last is +Inf
+	sum        float64
+	count      uint64
+}
+
+// DefaultBuckets suit latencies from 100µs to 10s
+var DefaultBuckets = []float64{0.0001, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// NewHistogram creates and registers a histogram with the given upper bounds
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	upper := append([]float64(nil), buckets...)
+	sort.Float64s(upper)
+	h := &Histogram{
+		metricName: name,
+		help:       help,
+		upper:      upper,
+		counts:     make([]uint64, len(upper)+1),
+	}
+	r.register(h)
+	return h
+}
+
+// Observe records one value
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.upper, v)
+	h.mu.Lock()
+	h.counts[i]++
+	h.sum += v
+	h.count++
+	h.mu.Unlock()
+}
+
+// Count returns the number of observations
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+func (h *Histogram) name() string { return h.metricName }
+
+func (h *Histogram) write(b *strings.Builder) {
+	h.mu.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	sum, count := h.sum, h.count
+	h.mu.Unlock()
+
+	writeHeader(b, h.metricName, h.help, "histogram")
+	var cumulative uint64
+	for i, upper := range h.upper {
+		cumulative += counts[i]
+		writeSample(b, h.metricName+"_bucket", []string{"le"}, []string{formatFloat(upper)}, float64(cumulative))
+	}
+	writeSample(b, h.metricName+"_bucket", []string{"le"}, []string{"+Inf"}, float64(count))
+	writeSample(b, h.metricName+"_sum", nil, nil, sum)
+	writeSample(b, h.metricName+"_count", nil, nil, float64(count))
+}
@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func render(t *testing.T, r *Registry) string {
+	t.Helper()
+	var b strings.Builder
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatalf("Failed to write metrics: %v", err)
+	}
+	return b.String()
+}
+
+func TestCounterVec(t *testing.T) {
+	r := NewRegistry()
+	cv := r.NewCounterVec("test_requests_total", "Test requests.", "route", "status")
+	cv.WithLabelValues("stats", "200").Inc()
+	cv.WithLabelValues("stats", "200").Add(2)
+	cv.WithLabelValues("catchall", "404").Inc()
+
+	out := render(t, r)
+	for _, want := range []string{
+		"# HELP test_requests_total Test requests.\n",
+		"# TYPE test_requests_total counter\n",
+		`test_requests_total{route="catchall",status="404"} 1` + "\n",
+		`test_requests_total{route="stats",status="200"} 3` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, out)
+		}
+	}
+	// Series are sorted by label values
+	if strings.Index(out, `route="catchall"`) > strings.Index(out, `route="stats"`) {
+		t.Errorf("Expected series sorted by labels, got:\n%s", out)
+	}
+}
+
+func TestGaugeAndFuncVec(t *testing.T) {
+	r := NewRegistry()
+	g := r.NewGauge("test_last_run", "Last run.")
+	g.Set(1700000000)
+	fv := r.NewGaugeFuncVec("test_entries", "Entries.", "route")
+	fv.Set(func() float64 { return 7 }, "web")
+	fv.Set(func() float64 { return 9 }, "web")
+
+	out := render(t, r)
+	if !strings.Contains(out, "test_last_run 1.7e+09\n") {
+		t.Errorf("Expected gauge sample, got:\n%s", out)
+	}
+	if !strings.Contains(out, `test_entries{route="web"} 9`+"\n") {
+		t.Errorf("Expected replaced callback value 9, got:\n%s", out)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogram("test_duration_seconds", "Durations.", []float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(5)
+
+	out := render(t, r)
+	for _, want := range []string{
+		"# TYPE test_duration_seconds histogram\n",
+		`test_duration_seconds_bucket{le="0.1"} 1` + "\n",
+		`test_duration_seconds_bucket{le="1"} 2` + "\n",
+		`test_duration_seconds_bucket{le="+Inf"} 3` + "\n",
+		"test_duration_seconds_sum 5.55\n",
+		"test_duration_seconds_count 3\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, out)
+		}
+	}
+	if h.Count() != 3 {
+		t.Errorf("Expected 3 observations, got %d", h.Count())
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	r := NewRegistry()
+	cv := r.NewCounterVec("test_escape_total", "Escaping.", "value")
+	cv.WithLabelValues("a\"b\\c\nd").Inc()
+
+	out := render(t, r)
+	if !strings.Contains(out, `test_escape_total{value="a\"b\\c\nd"} 1`) {
+		t.Errorf("Expected escaped label value, got:\n%s", out)
+	}
+}
+
+func TestDuplicateRegistrationPanics(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("test_dup_total", "Dup.")
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected duplicate registration to panic")
+		}
+	}()
+	r.NewCounter("test_dup_total", "Dup.")
+}
+
+func TestWrongLabelCountPanics(t *testing.T) {
+	r := NewRegistry()
+	cv := r.NewCounterVec("test_labels_total", "Labels.", "a", "b")
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected wrong label count to panic")
+		}
+	}()
+	cv.WithLabelValues("only-one")
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// TokenOrAllowList returns a middleware that admits requests carrying the
+// bearer token or coming directly from an allowed network. Forwarding
+// headers are ignored since they are trivially spoofed. Rejected requests
+// go to fallback, so they are handled like any unknown path.
+func TokenOrAllowList(token string, allow []netip.Prefix, fallback http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token != "" {
+				if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok &&
+					subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) == 1 {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			if remoteAllowed(r.RemoteAddr, allow) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			fallback.ServeHTTP(w, r)
+		})
+	}
+}
+
+// remoteAllowed reports whether the connection's peer address is in allow
+func remoteAllowed(remoteAddr string, allow []netip.Prefix) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range allow {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
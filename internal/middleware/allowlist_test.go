@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestTokenOrAllowList(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	allow := []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+
+	tests := []struct {
+		name       string
+		token      string
+		remoteAddr string
+		auth       string
+		xff        string
+		wantCode   int
+	}{
+		{"allowed loopback", "", "127.0.0.1:5000", "", "", http.StatusOK},
+		{"allowed IPv6 loopback", "", "[::1]:5000", "", "", http.StatusOK},
+		{"allowed IPv4-mapped", "", "[::ffff:127.0.0.1]:5000", "", "", http.StatusOK},
+		{"remote without token", "secret", "203.0.113.5:5000", "", "", http.StatusNotFound},
+		{"remote with token", "secret", "203.0.113.5:5000", "Bearer secret", "", http.StatusOK},
+		{"remote with wrong token", "secret", "203.0.113.5:5000", "Bearer nope", "", http.StatusNotFound},
+		{"bearer ignored when no token configured", "", "203.0.113.5:5000", "Bearer ", "", http.StatusNotFound},
+		{"forwarded header ignored", "", "203.0.113.5:5000", "", "127.0.0.1", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			rec := httptest.NewRecorder()
+
+			TokenOrAllowList(tt.token, allow, http.NotFoundHandler())(ok).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("Expected status %d, got %d", tt.wantCode, rec.Code)
+			}
+		})
+	}
+}
@@ -3,6 +3,9 @@ package middleware
 import (
 	"crypto/subtle"
 	"net/http"
+	"strings"
+
+	"github.com/dangogh/silver-eureka/internal/clientip"
 )
 
 // BasicAuth returns a middleware that performs HTTP Basic Authentication
@@ -35,6 +38,62 @@ func BasicAuth(username, password string) func(http.Handler) http.Handler {
 	}
 }
 
+// Authenticator verifies API credentials
+type Authenticator interface {
+	// Enabled reports whether credentials are required at all
+	Enabled() bool
+	// CheckPassword verifies a login attempt from the client address
+	CheckPassword(client, username, password string) bool
+	CheckToken(token string) bool
+}
+
+// RequireAuth returns a middleware that accepts HTTP Basic credentials or an
+// "Authorization: Bearer" API token. Requests pass through unchecked when the
+// authenticator is not enabled.
+func RequireAuth(a Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !a.Enabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+				if a.CheckToken(strings.TrimSpace(token)) {
+					next.ServeHTTP(w, r)
+					return
+				}
+				notFound(w)
+				return
+			}
+
+			user, pass, ok := r.BasicAuth()
+			if !ok || !a.CheckPassword(clientip.FromRequest(r), user, pass) {
+				notFound(w)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireAuthEnabled returns a middleware that serves requests only while
+// the authenticator is enabled; otherwise they go to fallback, as if the
+// route did not exist. It keeps endpoints that change state or hand out
+// the database closed on servers without credentials.
+func RequireAuthEnabled(a Authenticator, fallback http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !a.Enabled() {
+				fallback.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func notFound(w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "text/plain")
 	w.WriteHeader(http.StatusNotFound)
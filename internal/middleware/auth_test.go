@@ -117,3 +117,76 @@ func TestBasicAuth(t *testing.T) {
 		}
 	})
 }
+
+// staticAuth accepts one user and one token
+type staticAuth struct {
+	enabled bool
+}
+
+func (a staticAuth) Enabled() bool { return a.enabled }
+
+func (a staticAuth) CheckPassword(client, username, password string) bool {
+	return username == "alice" && password == "pass"
+}
+
+func (a staticAuth) CheckToken(token string) bool { return token == "tok" }
+
+func TestRequireAuth(t *testing.T) {
+	successHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name    string
+		enabled bool
+		header  string
+		want    int
+	}{
+		{name: "disabled allows all", enabled: false, want: http.StatusOK},
+		{name: "missing credentials", enabled: true, want: http.StatusNotFound},
+		{name: "valid basic", enabled: true, header: "Basic " + base64.StdEncoding.EncodeToString([]byte("alice:pass")), want: http.StatusOK},
+		{name: "invalid basic", enabled: true, header: "Basic " + base64.StdEncoding.EncodeToString([]byte("alice:nope")), want: http.StatusNotFound},
+		{name: "valid bearer", enabled: true, header: "Bearer tok", want: http.StatusOK},
+		{name: "invalid bearer", enabled: true, header: "Bearer other", want: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := RequireAuth(staticAuth{enabled: tt.enabled})(successHandler)
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestRequireAuthEnabled(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	fallback := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	for _, tt := range []struct {
+		enabled bool
+		want    int
+	}{
+		{enabled: false, want: http.StatusTeapot},
+		{enabled: true, want: http.StatusOK},
+	} {
+		rec := httptest.NewRecorder()
+		RequireAuthEnabled(staticAuth{enabled: tt.enabled}, fallback)(next).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/test", nil))
+		if rec.Code != tt.want {
+			t.Errorf("Enabled %v: expected status %d, got %d", tt.enabled, tt.want, rec.Code)
+		}
+	}
+}
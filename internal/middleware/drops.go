@@ -0,0 +1,155 @@
+package middleware
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/database"
+)
+
+// Rejection scopes reported to a RejectObserver
+const (
+	ScopeGlobal = "global"
+	ScopePerIP  = "per_ip"
+)
+
+// MaxDropKeys is the most per-IP counters a DropCounter holds between
+// flushes. Rejections from further addresses are folded into DropOverflowIP.
+const MaxDropKeys = 10000
+
+// DropOverflowIP is the address recorded for rejections beyond MaxDropKeys
+// distinct counters in one flush interval
+const DropOverflowIP = "other"
+
+// RejectObserver is notified whenever a RateLimiter rejects a request
+type RejectObserver interface {
+	ObserveReject(routeGroup, ip, scope string)
+}
+
+// DropStore persists aggregated rate limit drop counters
+type DropStore interface {
+	RecordRateLimitDrops(drops []database.RateLimitDrop) error
+}
+
+// dropKey identifies one per-minute drop counter
+type dropKey struct {
+	ip         string
+	minute     time.Time
+	routeGroup string
+	scope      string
+}
+
+// DropCounter aggregates rejected requests into per-IP per-minute counters
+// in memory and periodically flushes them to a DropStore, so a flood costs
+// one row per IP per minute instead of one write per request
+type DropCounter struct {
+	store  DropStore
+	mu     sync.Mutex
+	counts map[dropKey]int64
+	max    int // distinct counters kept before overflowing
+	ticker *time.Ticker
+	done   chan struct{}
+	wg     sync.WaitGroup
+	once   sync.Once
+}
+
+// NewDropCounter creates a DropCounter that flushes to store every interval
+func NewDropCounter(store DropStore, interval time.Duration) *DropCounter {
+	dc := &DropCounter{
+		store:  store,
+		counts: make(map[dropKey]int64),
+		max:    MaxDropKeys,
+		ticker: time.NewTicker(interval),
+		done:   make(chan struct{}),
+	}
+
+	dc.wg.Add(1)
+	go dc.flushRoutine()
+
+	return dc
+}
+
+// ObserveReject implements RejectObserver
+func (dc *DropCounter) ObserveReject(routeGroup, ip, scope string) {
+	key := dropKey{
+		ip:         ip,
+		minute:     time.Now().Truncate(time.Minute),
+		routeGroup: routeGroup,
+		scope:      scope,
+	}
+
+	dc.mu.Lock()
+	dc.add(key, 1)
+	dc.mu.Unlock()
+}
+
+// add counts n drops under key; dc.mu must be held
+func (dc *DropCounter) add(key dropKey, n int64) {
+	if _, ok := dc.counts[key]; !ok && len(dc.counts) >= dc.max {
+		// A flood of distinct or spoofed addresses can't grow memory
+		key.ip = DropOverflowIP
+	}
+	dc.counts[key] += n
+}
+
+// Flush writes all pending counters to the store. Counters that fail to
+// be written are kept for the next flush.
+func (dc *DropCounter) Flush() error {
+	dc.mu.Lock()
+	if len(dc.counts) == 0 {
+		dc.mu.Unlock()
+		return nil
+	}
+	pending := dc.counts
+	dc.counts = make(map[dropKey]int64)
+	dc.mu.Unlock()
+
+	drops := make([]database.RateLimitDrop, 0, len(pending))
+	for key, count := range pending {
+		drops = append(drops, database.RateLimitDrop{
+			IPAddress:  key.ip,
+			Minute:     key.minute,
+			RouteGroup: key.routeGroup,
+			Scope:      key.scope,
+			Count:      count,
+		})
+	}
+
+	if err := dc.store.RecordRateLimitDrops(drops); err != nil {
+		dc.mu.Lock()
+		for key, count := range pending {
+			dc.add(key, count)
+		}
+		dc.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// flushRoutine periodically flushes counters until Stop is called
+func (dc *DropCounter) flushRoutine() {
+	defer dc.wg.Done()
+	for {
+		select {
+		case <-dc.ticker.C:
+			if err := dc.Flush(); err != nil {
+				slog.Error("Failed to record rate limit drops", "error", err)
+			}
+		case <-dc.done:
+			return
+		}
+	}
+}
+
+// Stop stops the flush goroutine and writes any pending counters
+func (dc *DropCounter) Stop() error {
+	var err error
+	dc.once.Do(func() {
+		dc.ticker.Stop()
+		close(dc.done)
+		dc.wg.Wait()
+		err = dc.Flush()
+	})
+	return err
+}
@@ -0,0 +1,165 @@
+package middleware
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/database"
+)
+
+// fakeDropStore records flushed drops in memory
+type fakeDropStore struct {
+	mu    sync.Mutex
+	drops []database.RateLimitDrop
+	err   error
+}
+
+func (f *fakeDropStore) RecordRateLimitDrops(drops []database.RateLimitDrop) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	f.drops = append(f.drops, drops...)
+	return nil
+}
+
+func TestDropCounter_AggregatesPerMinute(t *testing.T) {
+	store := &fakeDropStore{}
+	dc := NewDropCounter(store, time.Hour)
+
+	for i := 0; i < 5; i++ {
+		dc.ObserveReject("catchall", "192.0.2.1", ScopePerIP)
+	}
+	dc.ObserveReject("catchall", "192.0.2.2", ScopeGlobal)
+
+	if err := dc.Stop(); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+
+	if len(store.drops) != 2 {
+		t.Fatalf("Expected 2 aggregated counters, got %d", len(store.drops))
+	}
+	for _, d := range store.drops {
+		switch d.IPAddress {
+		case "192.0.2.1":
+			if d.Count != 5 || d.Scope != ScopePerIP {
+				t.Errorf("Expected 5 per-IP drops, got %d %s", d.Count, d.Scope)
+			}
+		case "192.0.2.2":
+			if d.Count != 1 || d.Scope != ScopeGlobal {
+				t.Errorf("Expected 1 global drop, got %d %s", d.Count, d.Scope)
+			}
+		default:
+			t.Errorf("Unexpected IP %s", d.IPAddress)
+		}
+		if d.Minute.Second() != 0 || d.Minute.Nanosecond() != 0 {
+			t.Errorf("Expected minute-truncated timestamp, got %v", d.Minute)
+		}
+	}
+}
+
+func TestDropCounter_Overflow(t *testing.T) {
+	store := &fakeDropStore{}
+	dc := NewDropCounter(store, time.Hour)
+	dc.max = 2
+
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4", "192.0.2.1"} {
+		dc.ObserveReject("catchall", ip, ScopePerIP)
+	}
+	if err := dc.Stop(); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+
+	counts := make(map[string]int64)
+	for _, d := range store.drops {
+		counts[d.IPAddress] += d.Count
+	}
+	want := map[string]int64{"192.0.2.1": 2, "192.0.2.2": 1, DropOverflowIP: 2}
+	if len(counts) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, counts)
+	}
+	for ip, n := range want {
+		if counts[ip] != n {
+			t.Errorf("Expected %d drops for %s, got %d", n, ip, counts[ip])
+		}
+	}
+}
+
+func TestDropCounter_FlushEmpty(t *testing.T) {
+	store := &fakeDropStore{err: errors.New("should not be called")}
+	dc := NewDropCounter(store, time.Hour)
+
+	if err := dc.Flush(); err != nil {
+		t.Errorf("Expected no error flushing empty counter, got: %v", err)
+	}
+	if err := dc.Stop(); err != nil {
+		t.Errorf("Expected no error stopping, got: %v", err)
+	}
+	// Stop is idempotent
+	if err := dc.Stop(); err != nil {
+		t.Errorf("Expected no error on second Stop, got: %v", err)
+	}
+}
+
+func TestDropCounter_FlushError(t *testing.T) {
+	store := &fakeDropStore{err: errors.New("database is down")}
+	dc := NewDropCounter(store, time.Hour)
+
+	dc.ObserveReject("stats", "192.0.2.1", ScopePerIP)
+	if err := dc.Stop(); err == nil {
+		t.Error("Expected flush error to be returned from Stop")
+	}
+}
+
+func TestDropCounter_FlushErrorKeepsCounters(t *testing.T) {
+	store := &fakeDropStore{err: errors.New("database is down")}
+	dc := NewDropCounter(store, time.Hour)
+
+	dc.ObserveReject("catchall", "192.0.2.1", ScopePerIP)
+	dc.ObserveReject("catchall", "192.0.2.1", ScopePerIP)
+	if err := dc.Flush(); err == nil {
+		t.Fatal("Expected flush error")
+	}
+	dc.ObserveReject("catchall", "192.0.2.1", ScopePerIP)
+
+	store.mu.Lock()
+	store.err = nil
+	store.mu.Unlock()
+	if err := dc.Stop(); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	var total int64
+	for _, d := range store.drops {
+		total += d.Count
+	}
+	if total != 3 {
+		t.Errorf("Expected the failed flush's drops to be written later, got %+v", store.drops)
+	}
+}
+
+func TestDropCounter_PeriodicFlush(t *testing.T) {
+	store := &fakeDropStore{}
+	dc := NewDropCounter(store, 10*time.Millisecond)
+	defer func() {
+		if err := dc.Stop(); err != nil {
+			// Ignore flush errors in test cleanup
+		}
+	}()
+
+	dc.ObserveReject("catchall", "192.0.2.1", ScopePerIP)
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		store.mu.Lock()
+		n := len(store.drops)
+		store.mu.Unlock()
+		if n > 0 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Error("Expected drops to be flushed by the background routine")
+}
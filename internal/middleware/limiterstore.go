@@ -0,0 +1,155 @@
+package middleware
+
+import (
+	"container/list"
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// limiterShards is the number of independently locked shards
+	limiterShards = 64
+
+	// DefaultMaxLimiterEntries bounds the per-client limiters kept in memory
+	DefaultMaxLimiterEntries = 100000
+)
+
+// LimiterStoreStats reports the size and eviction counters of a limiter store
+type LimiterStoreStats struct {
+	Entries       int    // limiters currently held
+	MaxEntries    int    // configured capacity
+	LRUEvictions  uint64 // limiters evicted to stay within capacity
+	IdleEvictions uint64 // limiters evicted by the idle cleanup
+}
+
+// limiterStore is a sharded, LRU-bounded map of per-client rate limiters.
+// Each shard has its own lock so lookups for different clients rarely
+// contend, and each shard evicts its least recently used entry once it is
+// full, so a sweep of spoofed or distinct addresses can't grow memory
+// without bound.
+type limiterStore struct {
+	shards      [limiterShards]limiterShard
+	seed        maphash.Seed
+	maxPerShard int
+
+	lruEvictions  atomic.Uint64
+	idleEvictions atomic.Uint64
+}
+
+// limiterShard holds one slice of the key space
+type limiterShard struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // front = most recently used
+}
+
+// limiterEntry is one client's limiter and when it was last used
+type limiterEntry struct {
+	key      string
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// newLimiterStore creates a store holding at most maxEntries limiters
+func newLimiterStore(maxEntries int) *limiterStore {
+	if maxEntries <= 0 {
+		maxEntries = DefaultMaxLimiterEntries
+	}
+	s := &limiterStore{
+		seed:        maphash.MakeSeed(),
+		maxPerShard: max(maxEntries/limiterShards, 1),
+	}
+	for i := range s.shards {
+		s.shards[i].entries = make(map[string]*list.Element)
+		s.shards[i].lru = list.New()
+	}
+	return s
+}
+
+// shard returns the shard responsible for key
+func (s *limiterStore) shard(key string) *limiterShard {
+	return &s.shards[maphash.String(s.seed, key)%limiterShards]
+}
+
+// get returns the limiter for key, creating it with newLimiter if needed
+func (s *limiterStore) get(key string, now time.Time, newLimiter func() *rate.Limiter) *rate.Limiter {
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if el, ok := sh.entries[key]; ok {
+		entry := el.Value.(*limiterEntry)
+		entry.lastSeen = now
+		sh.lru.MoveToFront(el)
+		return entry.limiter
+	}
+
+	entry := &limiterEntry{key: key, limiter: newLimiter(), lastSeen: now}
+	sh.entries[key] = sh.lru.PushFront(entry)
+
+	for sh.lru.Len() > s.maxPerShard {
+		oldest := sh.lru.Back()
+		sh.lru.Remove(oldest)
+		delete(sh.entries, oldest.Value.(*limiterEntry).key)
+		s.lruEvictions.Add(1)
+	}
+
+	return entry.limiter
+}
+
+// evictIdle removes limiters not used since cutoff
+func (s *limiterStore) evictIdle(cutoff time.Time) {
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		// The list is ordered by last use, so stop at the first recent entry
+		for el := sh.lru.Back(); el != nil; el = sh.lru.Back() {
+			entry := el.Value.(*limiterEntry)
+			if entry.lastSeen.After(cutoff) {
+				break
+			}
+			sh.lru.Remove(el)
+			delete(sh.entries, entry.key)
+			s.idleEvictions.Add(1)
+		}
+		sh.mu.Unlock()
+	}
+}
+
+// each calls fn for every limiter held
+func (s *limiterStore) each(fn func(*rate.Limiter)) {
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		for el := sh.lru.Front(); el != nil; el = el.Next() {
+			fn(el.Value.(*limiterEntry).limiter)
+		}
+		sh.mu.Unlock()
+	}
+}
+
+// Len returns the number of limiters held
+func (s *limiterStore) Len() int {
+	n := 0
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		n += sh.lru.Len()
+		sh.mu.Unlock()
+	}
+	return n
+}
+
+// stats returns the current size and eviction counters
+func (s *limiterStore) stats() LimiterStoreStats {
+	return LimiterStoreStats{
+		Entries:       s.Len(),
+		MaxEntries:    s.maxPerShard * limiterShards,
+		LRUEvictions:  s.lruEvictions.Load(),
+		IdleEvictions: s.idleEvictions.Load(),
+	}
+}
@@ -0,0 +1,193 @@
+package middleware
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func newTestLimiter() *rate.Limiter {
+	return rate.NewLimiter(1, 1)
+}
+
+func TestLimiterStore_GetReturnsSameLimiter(t *testing.T) {
+	s := newLimiterStore(1000)
+	now := time.Now()
+
+	a := s.get("192.0.2.1", now, newTestLimiter)
+	b := s.get("192.0.2.1", now, newTestLimiter)
+	if a != b {
+		t.Error("Expected the same limiter for the same key")
+	}
+	if s.Len() != 1 {
+		t.Errorf("Expected 1 entry, got %d", s.Len())
+	}
+}
+
+func TestLimiterStore_BoundedByMaxEntries(t *testing.T) {
+	s := newLimiterStore(limiterShards * 2)
+	now := time.Now()
+
+	for i := 0; i < 10000; i++ {
+		s.get("10.0."+strconv.Itoa(i/256)+"."+strconv.Itoa(i%256), now, newTestLimiter)
+	}
+
+	stats := s.stats()
+	if stats.Entries > stats.MaxEntries {
+		t.Errorf("Expected at most %d entries, got %d", stats.MaxEntries, stats.Entries)
+	}
+	if stats.LRUEvictions == 0 {
+		t.Error("Expected LRU evictions to be counted")
+	}
+	if uint64(stats.Entries)+stats.LRUEvictions != 10000 {
+		t.Errorf("Expected entries + evictions = 10000, got %d + %d", stats.Entries, stats.LRUEvictions)
+	}
+}
+
+func TestLimiterStore_EvictsLeastRecentlyUsed(t *testing.T) {
+	s := newLimiterStore(1) // one entry per shard
+	now := time.Now()
+
+	// Find two keys that land in the same shard
+	first := "key-0"
+	var second string
+	for i := 1; ; i++ {
+		candidate := "key-" + strconv.Itoa(i)
+		if s.shard(candidate) == s.shard(first) {
+			second = candidate
+			break
+		}
+	}
+
+	original := s.get(first, now, newTestLimiter)
+	s.get(second, now, newTestLimiter)
+	if replaced := s.get(first, now, newTestLimiter); replaced == original {
+		t.Error("Expected the least recently used limiter to have been evicted")
+	}
+}
+
+func TestLimiterStore_EvictIdle(t *testing.T) {
+	s := newLimiterStore(1000)
+	now := time.Now()
+
+	s.get("old", now.Add(-10*time.Minute), newTestLimiter)
+	s.get("recent", now, newTestLimiter)
+
+	s.evictIdle(now.Add(-5 * time.Minute))
+
+	if s.Len() != 1 {
+		t.Fatalf("Expected 1 entry after idle eviction, got %d", s.Len())
+	}
+	if got := s.stats().IdleEvictions; got != 1 {
+		t.Errorf("Expected 1 idle eviction, got %d", got)
+	}
+}
+
+func TestLimiterStore_DefaultCapacity(t *testing.T) {
+	s := newLimiterStore(0)
+	if got := s.stats().MaxEntries; got < DefaultMaxLimiterEntries-limiterShards {
+		t.Errorf("Expected default capacity near %d, got %d", DefaultMaxLimiterEntries, got)
+	}
+}
+
+func TestLimiterStore_Concurrent(t *testing.T) {
+	s := newLimiterStore(1000)
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				s.get(fmt.Sprintf("%d-%d", g, i%50), now, newTestLimiter)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if s.Len() != 400 {
+		t.Errorf("Expected 400 entries, got %d", s.Len())
+	}
+}
+
+func TestRateLimiter_Stats(t *testing.T) {
+	rl := NewRateLimiterWithPolicy(RateLimitPolicy{PerIPPerMin: 100, PerIPBurst: 10, MaxEntries: 5000})
+	defer rl.Stop()
+
+	rl.getLimiter("192.0.2.1")
+	stats := rl.Stats()
+	if stats.Entries != 1 {
+		t.Errorf("Expected 1 entry, got %d", stats.Entries)
+	}
+	if stats.MaxEntries > 5000 {
+		t.Errorf("Expected capacity at most 5000, got %d", stats.MaxEntries)
+	}
+}
+
+// ipv4 formats n as a dotted quad
+func ipv4(n uint32) string {
+	return strconv.Itoa(int(n>>24)) + "." + strconv.Itoa(int(n>>16&0xff)) + "." +
+		strconv.Itoa(int(n>>8&0xff)) + "." + strconv.Itoa(int(n&0xff))
+}
+
+// BenchmarkLimiterStore_RepeatedIPs looks up a small working set of clients
+func BenchmarkLimiterStore_RepeatedIPs(b *testing.B) {
+	s := newLimiterStore(DefaultMaxLimiterEntries)
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = ipv4(uint32(i))
+	}
+	now := time.Now()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.get(keys[i%len(keys)], now, newTestLimiter)
+	}
+}
+
+// BenchmarkLimiterStore_DistinctIPs simulates a sweep where every request
+// comes from a never-seen address; with b.N in the millions the store stays
+// at its capacity while evicting
+func BenchmarkLimiterStore_DistinctIPs(b *testing.B) {
+	s := newLimiterStore(DefaultMaxLimiterEntries)
+	now := time.Now()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.get(ipv4(uint32(i)), now, newTestLimiter)
+	}
+	b.StopTimer()
+
+	stats := s.stats()
+	b.ReportMetric(float64(stats.Entries), "entries")
+	b.ReportMetric(float64(stats.LRUEvictions), "evictions")
+}
+
+// BenchmarkLimiterStore_DistinctIPsParallel runs the sweep from all CPUs to
+// measure shard lock contention
+func BenchmarkLimiterStore_DistinctIPsParallel(b *testing.B) {
+	s := newLimiterStore(DefaultMaxLimiterEntries)
+	now := time.Now()
+	var counter atomic.Uint32
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			s.get(ipv4(counter.Add(1)), now, newTestLimiter)
+		}
+	})
+	b.StopTimer()
+
+	stats := s.stats()
+	b.ReportMetric(float64(stats.Entries), "entries")
+	b.ReportMetric(float64(stats.LRUEvictions), "evictions")
+}
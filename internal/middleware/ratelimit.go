@@ -1,24 +1,58 @@
 package middleware
 
 import (
+	"fmt"
 	"log/slog"
+	"math"
 	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/dangogh/silver-eureka/internal/clientip"
+	"github.com/dangogh/silver-eureka/internal/metrics"
 	"golang.org/x/time/rate"
 )
 
+// RateLimitPolicy configures the limits enforced by a RateLimiter
+type RateLimitPolicy struct {
+	Name         string         // route group label used when reporting rejections
+	PerIPPerMin  int            // requests per minute per client key (0 = unlimited)
+	PerIPBurst   int            // burst size per client key
+	GlobalPerMin int            // requests per minute across all clients (0 = unlimited)
+	GlobalBurst  int            // global burst size
+	Exempt       []netip.Prefix // client networks that bypass the limiter
+	IPv4Prefix   int            // aggregate IPv4 clients by prefix length (0 or 32 = single address)
+	IPv6Prefix   int            // aggregate IPv6 clients by prefix length (0 or 128 = single address)
+	MaxEntries   int            // per-client limiters kept in memory (0 = DefaultMaxLimiterEntries)
+}
+
 // RateLimiter manages rate limiting for incoming requests
 type RateLimiter struct {
+	name string
+
 	// Per-IP rate limiters
-	perIP      map[string]*rate.Limiter
-	mu         sync.Mutex
+	limiters *limiterStore
+
+	// mu guards the policy fields below, which SetPolicy may replace
+	mu         sync.RWMutex
 	perIPRate  rate.Limit
 	perIPBurst int
+	perIPLimit int
+
+	// Global rate limiter (nil when unlimited)
+	global      *rate.Limiter
+	globalLimit int
+
+	// Observers notified of rejected requests
+	observers []RejectObserver
 
-	// Global rate limiter
-	global *rate.Limiter
+	// Client grouping and exemptions
+	exempt     []netip.Prefix
+	ipv4Prefix int
+	ipv6Prefix int
 
 	// Cleanup ticker
 	cleanup *time.Ticker
@@ -28,12 +62,30 @@ type RateLimiter struct {
 // perIPReqPerMin: requests per minute per IP (e.g., 100)
 // globalReqPerMin: total requests per minute globally (e.g., 10000)
 func NewRateLimiter(perIPReqPerMin, globalReqPerMin int) *RateLimiter {
+	return NewRateLimiterWithPolicy(RateLimitPolicy{
+		PerIPPerMin:  perIPReqPerMin,
+		PerIPBurst:   perIPReqPerMin / 10, // Allow bursts of 10% of per-minute rate
+		GlobalPerMin: globalReqPerMin,
+		GlobalBurst:  globalReqPerMin / 10,
+	})
+}
+
+// NewRateLimiterWithPolicy creates a new rate limiter from a policy
+func NewRateLimiterWithPolicy(policy RateLimitPolicy) *RateLimiter {
 	rl := &RateLimiter{
-		perIP:      make(map[string]*rate.Limiter),
-		perIPRate:  rate.Limit(float64(perIPReqPerMin) / 60.0), // Convert to per-second rate
-		perIPBurst: perIPReqPerMin / 10,                        // Allow bursts of 10% of per-minute rate
-		global:     rate.NewLimiter(rate.Limit(float64(globalReqPerMin)/60.0), globalReqPerMin/10),
-		cleanup:    time.NewTicker(5 * time.Minute),
+		name:        policy.Name,
+		limiters:    newLimiterStore(policy.MaxEntries),
+		perIPRate:   perMinute(policy.PerIPPerMin),
+		perIPBurst:  max(policy.PerIPBurst, 1),
+		perIPLimit:  policy.PerIPPerMin,
+		globalLimit: policy.GlobalPerMin,
+		exempt:      policy.Exempt,
+		ipv4Prefix:  policy.IPv4Prefix,
+		ipv6Prefix:  policy.IPv6Prefix,
+		cleanup:     time.NewTicker(5 * time.Minute),
+	}
+	if policy.GlobalPerMin > 0 {
+		rl.global = rate.NewLimiter(perMinute(policy.GlobalPerMin), max(policy.GlobalBurst, 1))
 	}
 
 	// Start cleanup goroutine to remove inactive IP limiters
@@ -42,20 +94,70 @@ func NewRateLimiter(perIPReqPerMin, globalReqPerMin int) *RateLimiter {
 	return rl
 }
 
+// SetPolicy applies a new policy to a running limiter. Existing client
+// limiters keep their tokens but refill at the new rate; Name and
+// MaxEntries are fixed when the limiter is created.
+func (rl *RateLimiter) SetPolicy(policy RateLimitPolicy) {
+	perIPRate := perMinute(policy.PerIPPerMin)
+	perIPBurst := max(policy.PerIPBurst, 1)
+	globalRate := perMinute(policy.GlobalPerMin)
+	globalBurst := max(policy.GlobalBurst, 1)
+
+	rl.mu.Lock()
+	rl.perIPRate = perIPRate
+	rl.perIPBurst = perIPBurst
+	rl.perIPLimit = policy.PerIPPerMin
+	rl.globalLimit = policy.GlobalPerMin
+	switch {
+	case policy.GlobalPerMin <= 0:
+		rl.global = nil
+	case rl.global == nil:
+		rl.global = rate.NewLimiter(globalRate, globalBurst)
+	default:
+		rl.global.SetLimit(globalRate)
+		rl.global.SetBurst(globalBurst)
+	}
+	rl.exempt = policy.Exempt
+	rl.ipv4Prefix = policy.IPv4Prefix
+	rl.ipv6Prefix = policy.IPv6Prefix
+	rl.mu.Unlock()
+
+	rl.limiters.each(func(l *rate.Limiter) {
+		l.SetLimit(perIPRate)
+		l.SetBurst(perIPBurst)
+	})
+}
+
+// perMinute converts a per-minute request count to a per-second rate (0 = unlimited)
+func perMinute(reqPerMin int) rate.Limit {
+	if reqPerMin <= 0 {
+		return rate.Inf
+	}
+	return rate.Limit(float64(reqPerMin) / 60.0)
+}
+
+// limiterIdleTimeout is how long a client limiter may go unused before it is
+// dropped; by then its bucket has refilled, so dropping it is invisible
+const limiterIdleTimeout = 5 * time.Minute
+
 // cleanupRoutine periodically cleans up inactive IP rate limiters
 func (rl *RateLimiter) cleanupRoutine() {
 	for range rl.cleanup.C {
-		rl.mu.Lock()
-		// Remove limiters that haven't been used in the last 5 minutes
-		for ip, limiter := range rl.perIP {
-			if limiter.Tokens() >= float64(rl.perIPBurst) {
-				delete(rl.perIP, ip)
-			}
-		}
-		rl.mu.Unlock()
+		rl.limiters.evictIdle(time.Now().Add(-limiterIdleTimeout))
 	}
 }
 
+// Stats returns the size and eviction counters of the per-client limiters
+func (rl *RateLimiter) Stats() LimiterStoreStats {
+	return rl.limiters.stats()
+}
+
+// Observe registers an observer that is notified of every rejected request.
+// It must be called before the middleware starts serving requests.
+func (rl *RateLimiter) Observe(o RejectObserver) {
+	rl.observers = append(rl.observers, o)
+}
+
 // Stop stops the cleanup goroutine
 func (rl *RateLimiter) Stop() {
 	rl.cleanup.Stop()
@@ -63,16 +165,11 @@ func (rl *RateLimiter) Stop() {
 
 // getLimiter returns the rate limiter for a specific IP address
 func (rl *RateLimiter) getLimiter(ip string) *rate.Limiter {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	limiter, exists := rl.perIP[ip]
-	if !exists {
-		limiter = rate.NewLimiter(rl.perIPRate, rl.perIPBurst)
-		rl.perIP[ip] = limiter
-	}
-
-	return limiter
+	return rl.limiters.get(ip, time.Now(), func() *rate.Limiter {
+		rl.mu.RLock()
+		defer rl.mu.RUnlock()
+		return rate.NewLimiter(rl.perIPRate, rl.perIPBurst)
+	})
 }
 
 // Middleware returns a middleware function that applies rate limiting
@@ -80,26 +177,37 @@ func (rl *RateLimiter) Middleware() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Extract IP address from request
-			ip := getIPAddress(r)
+			ip := clientip.FromRequest(r)
+
+			key, exempt := rl.clientKey(ip)
+			if exempt {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			rl.mu.RLock()
+			global, globalLimit, perIPLimit := rl.global, rl.globalLimit, rl.perIPLimit
+			rl.mu.RUnlock()
 
 			// Check global rate limit first
-			if !rl.global.Allow() {
+			if global != nil && !global.Allow() {
 				slog.Warn("Global rate limit exceeded",
 					"ip", ip,
 					"path", r.URL.Path,
 				)
-				http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+				rl.reject(w, ip, ScopeGlobal, global, globalLimit)
 				return
 			}
 
 			// Check per-IP rate limit
-			limiter := rl.getLimiter(ip)
+			limiter := rl.getLimiter(key)
 			if !limiter.Allow() {
 				slog.Warn("Per-IP rate limit exceeded",
 					"ip", ip,
+					"key", key,
 					"path", r.URL.Path,
 				)
-				http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+				rl.reject(w, ip, ScopePerIP, limiter, perIPLimit)
 				return
 			}
 
@@ -108,36 +216,62 @@ func (rl *RateLimiter) Middleware() func(http.Handler) http.Handler {
 	}
 }
 
-// getIPAddress extracts the real IP address from the request
-// Priority: X-Forwarded-For > X-Real-IP > RemoteAddr
-func getIPAddress(r *http.Request) string {
-	// Check X-Forwarded-For header (for proxies/load balancers)
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		// X-Forwarded-For can contain multiple IPs, take the first one
-		if idx := indexOf(xff, ','); idx > 0 {
-			return xff[:idx]
-		}
-		return xff
+// reject notifies observers and writes a 429 response carrying Retry-After
+// and RateLimit-* headers describing the limiter that was exceeded
+func (rl *RateLimiter) reject(w http.ResponseWriter, ip, scope string, limiter *rate.Limiter, perMin int) {
+	metrics.RateLimitRejections.WithLabelValues(rl.name, scope).Inc()
+	for _, o := range rl.observers {
+		o.ObserveReject(rl.name, ip, scope)
 	}
 
-	// Check X-Real-IP header
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		return xri
+	// Time until the next token is available
+	reset := 1
+	if limit := limiter.Limit(); limit > 0 && limit != rate.Inf {
+		missing := 1 - limiter.Tokens()
+		if seconds := int(math.Ceil(missing / float64(limit))); seconds > reset {
+			reset = seconds
+		}
 	}
 
-	// Fall back to RemoteAddr (format: "IP:port")
-	if idx := indexOf(r.RemoteAddr, ':'); idx > 0 {
-		return r.RemoteAddr[:idx]
-	}
-	return r.RemoteAddr
+	h := w.Header()
+	h.Set("Retry-After", strconv.Itoa(reset))
+	h.Set("RateLimit-Limit", strconv.Itoa(perMin))
+	h.Set("RateLimit-Remaining", "0")
+	h.Set("RateLimit-Reset", strconv.Itoa(reset))
+	h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=60", perMin))
+	http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
 }
 
-// indexOf returns the index of the first occurrence of c in s, or -1 if not found
-func indexOf(s string, c rune) int {
-	for i, ch := range s {
-		if ch == c {
-			return i
+// clientKey returns the limiter key for an IP address, aggregated to the
+// configured prefix length, and whether the address is exempt
+func (rl *RateLimiter) clientKey(ip string) (string, bool) {
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if err != nil {
+		// Not a parseable address; limit on the raw value
+		return ip, false
+	}
+	addr = addr.Unmap()
+
+	rl.mu.RLock()
+	exempt, ipv4Prefix, ipv6Prefix := rl.exempt, rl.ipv4Prefix, rl.ipv6Prefix
+	rl.mu.RUnlock()
+
+	for _, prefix := range exempt {
+		if prefix.Contains(addr) {
+			return "", true
 		}
 	}
-	return -1
+
+	bits := ipv6Prefix
+	if addr.Is4() {
+		bits = ipv4Prefix
+	}
+	if bits <= 0 || bits >= addr.BitLen() {
+		return addr.String(), false
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return addr.String(), false
+	}
+	return prefix.String(), false
 }
@@ -3,7 +3,9 @@ package middleware
 import (
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"testing"
+	"time"
 
 	"golang.org/x/time/rate"
 )
@@ -137,10 +139,10 @@ func TestRateLimiter_XForwardedFor(t *testing.T) {
 		w.WriteHeader(http.StatusOK)
 	}))
 
-	// Request with X-Forwarded-For header
+	// Request with X-Forwarded-For header from a trusted proxy
 	req := httptest.NewRequest("GET", "/", nil)
 	req.RemoteAddr = "10.0.0.1:12345"
-	req.Header.Set("X-Forwarded-For", "203.0.113.1, 198.51.100.1")
+	req.Header.Set("X-Forwarded-For", "198.51.100.1, 203.0.113.1")
 	w := httptest.NewRecorder()
 	handler.ServeHTTP(w, req)
 
@@ -148,16 +150,17 @@ func TestRateLimiter_XForwardedFor(t *testing.T) {
 		t.Errorf("Expected status 200, got %d", w.Code)
 	}
 
-	// Same X-Forwarded-For IP should share rate limit
+	// The same client behind another proxy shares the rate limit, whatever
+	// it claims in X-Forwarded-For itself
 	for i := 0; i < 5; i++ {
 		req = httptest.NewRequest("GET", "/", nil)
 		req.RemoteAddr = "10.0.0.2:12345" // Different RemoteAddr
-		req.Header.Set("X-Forwarded-For", "203.0.113.1, 198.51.100.2")
+		req.Header.Set("X-Forwarded-For", "198.51.100.2, 203.0.113.1")
 		w = httptest.NewRecorder()
 		handler.ServeHTTP(w, req)
 	}
 
-	// Should be rate limited (same first IP in X-Forwarded-For)
+	// Should be rate limited (same nearest untrusted hop)
 	if w.Code != http.StatusTooManyRequests {
 		t.Errorf("Expected status 429, got %d", w.Code)
 	}
@@ -196,9 +199,7 @@ func TestRateLimiter_CleanupRoutine(t *testing.T) {
 	}
 
 	// Verify limiters exist
-	rl.mu.Lock()
-	count := len(rl.perIP)
-	rl.mu.Unlock()
+	count := rl.limiters.Len()
 
 	if count != 2 {
 		t.Errorf("Expected 2 limiters, got %d", count)
@@ -208,95 +209,248 @@ func TestRateLimiter_CleanupRoutine(t *testing.T) {
 	// Just verify the structure is correct
 }
 
-func TestGetIPAddress(t *testing.T) {
-	tests := []struct {
-		name          string
-		remoteAddr    string
-		xForwardedFor string
-		xRealIP       string
-		expectedIP    string
-	}{
-		{
-			name:       "RemoteAddr only",
-			remoteAddr: "192.168.1.1:12345",
-			expectedIP: "192.168.1.1",
-		},
-		{
-			name:          "X-Forwarded-For takes priority",
-			remoteAddr:    "10.0.0.1:12345",
-			xForwardedFor: "203.0.113.1",
-			expectedIP:    "203.0.113.1",
-		},
-		{
-			name:          "X-Forwarded-For with multiple IPs",
-			remoteAddr:    "10.0.0.1:12345",
-			xForwardedFor: "203.0.113.1, 198.51.100.1, 192.0.2.1",
-			expectedIP:    "203.0.113.1",
-		},
-		{
-			name:       "X-Real-IP",
-			remoteAddr: "10.0.0.1:12345",
-			xRealIP:    "203.0.113.1",
-			expectedIP: "203.0.113.1",
-		},
-		{
-			name:          "X-Forwarded-For over X-Real-IP",
-			remoteAddr:    "10.0.0.1:12345",
-			xForwardedFor: "203.0.113.1",
-			xRealIP:       "198.51.100.1",
-			expectedIP:    "203.0.113.1",
-		},
+func TestRateLimiter_Stop(t *testing.T) {
+	rl := NewRateLimiter(100, 10000)
+
+	// Stop should not panic
+	rl.Stop()
+
+	// Calling Stop again should not panic
+	rl.Stop()
+}
+
+func TestRateLimiter_ExemptPrefix(t *testing.T) {
+	rl := NewRateLimiterWithPolicy(RateLimitPolicy{
+		PerIPPerMin: 10,
+		PerIPBurst:  1,
+		Exempt:      []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+	})
+	defer rl.Stop()
+
+	handler := rl.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for i := 0; i < 5; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "10.1.2.3:12345"
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Request %d from exempt IP got status %d", i, w.Code)
+		}
 	}
+}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			req := httptest.NewRequest("GET", "/", nil)
-			req.RemoteAddr = tt.remoteAddr
-			if tt.xForwardedFor != "" {
-				req.Header.Set("X-Forwarded-For", tt.xForwardedFor)
-			}
-			if tt.xRealIP != "" {
-				req.Header.Set("X-Real-IP", tt.xRealIP)
-			}
+func TestRateLimiter_IPv6RemoteAddr(t *testing.T) {
+	rl := NewRateLimiterWithPolicy(RateLimitPolicy{
+		PerIPPerMin: 10,
+		PerIPBurst:  1,
+		Exempt:      []netip.Prefix{netip.MustParsePrefix("::1/128")},
+		IPv6Prefix:  64,
+	})
+	defer rl.Stop()
 
-			ip := getIPAddress(req)
-			if ip != tt.expectedIP {
-				t.Errorf("Expected IP %s, got %s", tt.expectedIP, ip)
-			}
-		})
+	handler := rl.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	status := func(remoteAddr string) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Addresses in one /64 share a bucket
+	if code := status("[2001:db8:0:1::1]:443"); code != http.StatusOK {
+		t.Fatalf("Expected the first request to pass, got %d", code)
+	}
+	if code := status("[2001:db8:0:1::2]:443"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected the same /64 to be limited, got %d", code)
+	}
+	// Another /64 with the same leading group does not
+	if code := status("[2001:db8:0:2::1]:443"); code != http.StatusOK {
+		t.Errorf("Expected another /64 to pass, got %d", code)
+	}
+	// The IPv6 loopback is exempt
+	for i := 0; i < 3; i++ {
+		if code := status("[::1]:5000"); code != http.StatusOK {
+			t.Fatalf("Request %d from ::1 got status %d", i, code)
+		}
+	}
+}
+
+func TestRateLimiter_PrefixAggregation(t *testing.T) {
+	rl := NewRateLimiterWithPolicy(RateLimitPolicy{
+		PerIPPerMin: 10,
+		PerIPBurst:  1,
+		IPv4Prefix:  24,
+	})
+	defer rl.Stop()
+
+	handler := rl.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "203.0.113.1:12345"
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	// A neighbour in the same /24 shares the budget
+	req = httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "203.0.113.200:12345"
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429 for same /24, got %d", w.Code)
+	}
+
+	// A different /24 has its own budget
+	req = httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "203.0.114.1:12345"
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200 for different /24, got %d", w.Code)
 	}
 }
 
-func TestIndexOf(t *testing.T) {
+func TestRateLimiter_ClientKey(t *testing.T) {
+	rl := NewRateLimiterWithPolicy(RateLimitPolicy{
+		PerIPPerMin: 100,
+		IPv4Prefix:  24,
+		IPv6Prefix:  64,
+		Exempt:      []netip.Prefix{netip.MustParsePrefix("::1/128")},
+	})
+	defer rl.Stop()
+
 	tests := []struct {
-		name     string
-		s        string
-		c        rune
-		expected int
+		ip         string
+		wantKey    string
+		wantExempt bool
 	}{
-		{"Found at start", "hello", 'h', 0},
-		{"Found in middle", "hello", 'l', 2},
-		{"Found at end", "hello", 'o', 4},
-		{"Not found", "hello", 'x', -1},
-		{"Empty string", "", 'a', -1},
+		{ip: "192.0.2.77", wantKey: "192.0.2.0/24"},
+		{ip: "::ffff:192.0.2.77", wantKey: "192.0.2.0/24"},
+		{ip: "2001:db8:1:2:3:4:5:6", wantKey: "2001:db8:1:2::/64"},
+		{ip: "::1", wantExempt: true},
+		{ip: "garbage", wantKey: "garbage"},
 	}
 
 	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			result := indexOf(tt.s, tt.c)
-			if result != tt.expected {
-				t.Errorf("indexOf(%q, %q) = %d, expected %d", tt.s, tt.c, result, tt.expected)
+		t.Run(tt.ip, func(t *testing.T) {
+			key, exempt := rl.clientKey(tt.ip)
+			if exempt != tt.wantExempt {
+				t.Errorf("clientKey(%q) exempt = %v, want %v", tt.ip, exempt, tt.wantExempt)
+			}
+			if !tt.wantExempt && key != tt.wantKey {
+				t.Errorf("clientKey(%q) = %q, want %q", tt.ip, key, tt.wantKey)
 			}
 		})
 	}
 }
 
-func TestRateLimiter_Stop(t *testing.T) {
-	rl := NewRateLimiter(100, 10000)
+func TestRateLimiter_UnlimitedGlobal(t *testing.T) {
+	rl := NewRateLimiterWithPolicy(RateLimitPolicy{PerIPPerMin: 6000, PerIPBurst: 100})
+	defer rl.Stop()
 
-	// Stop should not panic
-	rl.Stop()
+	if rl.global != nil {
+		t.Error("Expected no global limiter when global rate is 0")
+	}
+}
 
-	// Calling Stop again should not panic
-	rl.Stop()
+func TestRateLimiter_SetPolicy(t *testing.T) {
+	rl := NewRateLimiterWithPolicy(RateLimitPolicy{PerIPPerMin: 60, PerIPBurst: 1})
+	defer rl.Stop()
+	handler := rl.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	serve("192.0.2.1:1000")
+	if code := serve("192.0.2.1:1000"); code != http.StatusTooManyRequests {
+		t.Fatalf("Expected second request to be limited, got %d", code)
+	}
+
+	// Raising the burst applies to the existing client limiter
+	rl.SetPolicy(RateLimitPolicy{PerIPPerMin: 6000, PerIPBurst: 100, GlobalPerMin: 60, GlobalBurst: 5})
+	time.Sleep(20 * time.Millisecond)
+	if code := serve("192.0.2.1:1000"); code != http.StatusOK {
+		t.Errorf("Expected request to pass after raising the limit, got %d", code)
+	}
+	if rl.global == nil || rl.global.Burst() != 5 {
+		t.Error("Expected a global limiter with burst 5")
+	}
+
+	// Exemptions take effect immediately
+	rl.SetPolicy(RateLimitPolicy{PerIPPerMin: 1, PerIPBurst: 1, Exempt: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}})
+	if rl.global != nil {
+		t.Error("Expected global limiter to be removed")
+	}
+	for i := 0; i < 5; i++ {
+		if code := serve("192.0.2.1:1000"); code != http.StatusOK {
+			t.Fatalf("Expected exempt client to pass, got %d", code)
+		}
+	}
+}
+
+// recordingObserver collects rejection notifications
+type recordingObserver struct {
+	rejects []string
+}
+
+func (o *recordingObserver) ObserveReject(routeGroup, ip, scope string) {
+	o.rejects = append(o.rejects, routeGroup+"|"+ip+"|"+scope)
+}
+
+func TestRateLimiter_RejectHeadersAndObserver(t *testing.T) {
+	rl := NewRateLimiterWithPolicy(RateLimitPolicy{Name: "catchall", PerIPPerMin: 10, PerIPBurst: 1})
+	defer rl.Stop()
+
+	observer := &recordingObserver{}
+	rl.Observe(observer)
+
+	handler := rl.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	var w *httptest.ResponseRecorder
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "192.0.2.1:12345"
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+	}
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429, got %d", w.Code)
+	}
+	if ra := w.Header().Get("Retry-After"); ra == "" || ra == "0" {
+		t.Errorf("Expected positive Retry-After header, got %q", ra)
+	}
+	if got := w.Header().Get("RateLimit-Limit"); got != "10" {
+		t.Errorf("Expected RateLimit-Limit 10, got %q", got)
+	}
+	if got := w.Header().Get("RateLimit-Remaining"); got != "0" {
+		t.Errorf("Expected RateLimit-Remaining 0, got %q", got)
+	}
+	if w.Header().Get("RateLimit-Reset") == "" {
+		t.Error("Expected RateLimit-Reset header")
+	}
+
+	if len(observer.rejects) != 1 || observer.rejects[0] != "catchall|192.0.2.1|per_ip" {
+		t.Errorf("Expected one per-IP rejection, got %v", observer.rejects)
+	}
 }
@@ -0,0 +1,94 @@
+package retention
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/database"
+)
+
+// ArchiveName returns the archive file name for a UTC day
+func ArchiveName(day time.Time) string {
+	return "requests-" + day.UTC().Format(time.DateOnly) + ".ndjson.gz"
+}
+
+// Archive appends the logs before cutoff, with their captured details, to
+// one gzip-compressed NDJSON file per UTC day in dir and returns the number
+// written. Each call adds a new gzip member, so a day archived over several
+// runs is still one readable file; the lines are in the format "export
+// -format ndjson" writes and "import -format ndjson" reads.
+func Archive(store Store, dir string, cutoff time.Time) (int64, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	var written int64
+	var current *dayFile
+	err := store.EachLog(database.LogFilter{Until: cutoff, Ascending: true, Details: true}, func(log database.RequestLog) error {
+		name := ArchiveName(log.Timestamp)
+		if current == nil || current.name != name {
+			if current != nil {
+				if err := current.close(); err != nil {
+					return err
+				}
+			}
+			f, err := openDayFile(dir, name)
+			if err != nil {
+				return err
+			}
+			current = f
+		}
+		if err := current.enc.Encode(log); err != nil {
+			return fmt.Errorf("failed to write archive: %w", err)
+		}
+		written++
+		return nil
+	})
+	if current != nil {
+		if closeErr := current.close(); err == nil {
+			err = closeErr
+		}
+	}
+	return written, err
+}
+
+// dayFile is an open archive file for one day
+type dayFile struct {
+	name string
+	file *os.File
+	zw   *gzip.Writer
+	enc  *json.Encoder
+}
+
+func openDayFile(dir, name string) (*dayFile, error) {
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	zw := gzip.NewWriter(f)
+	return &dayFile{name: name, file: f, zw: zw, enc: json.NewEncoder(zw)}, nil
+}
+
+// close finishes the gzip member and flushes it to disk
+func (d *dayFile) close() error {
+	if err := d.zw.Close(); err != nil {
+		if closeErr := d.file.Close(); closeErr != nil {
+			// The compression error is reported
+		}
+		return fmt.Errorf("failed to write archive %s: %w", d.name, err)
+	}
+	if err := d.file.Sync(); err != nil {
+		if closeErr := d.file.Close(); closeErr != nil {
+			// The sync error is reported
+		}
+		return fmt.Errorf("failed to write archive %s: %w", d.name, err)
+	}
+	if err := d.file.Close(); err != nil {
+		return fmt.Errorf("failed to write archive %s: %w", d.name, err)
+	}
+	return nil
+}
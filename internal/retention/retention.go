@@ -0,0 +1,405 @@
+// Package retention expires stored data in tiers: captured request details,
+// raw request logs, hourly rollups and daily rollups each have their own
+// lifetime, and the oldest
+// data also goes when the database grows beyond a size cap. Expired raw
+// logs can be archived to compressed NDJSON files first.
+package retention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/config"
+	"github.com/dangogh/silver-eureka/internal/database"
+	"github.com/dangogh/silver-eureka/internal/metrics"
+)
+
+// day is the length of a UTC day, the unit archives and size-cap deletes use
+const day = 24 * time.Hour
+
+// progressInterval is the most often a run logs its progress
+const progressInterval = 10 * time.Second
+
+// Store is the database the Manager expires data from
+type Store interface {
+	EachLog(f database.LogFilter, fn func(database.RequestLog) error) error
+	PurgeLogs(ctx context.Context, cutoff time.Time, opts database.BatchOptions) (int64, error)
+	ClearDetails(ctx context.Context, cutoff time.Time, opts database.BatchOptions) (int64, error)
+	PurgeHourlyRollups(ctx context.Context, cutoff time.Time, opts database.BatchOptions) (int64, error)
+	PurgeDailyRollups(ctx context.Context, cutoff time.Time, opts database.BatchOptions) (int64, error)
+	OldestLog() (time.Time, error)
+	OldestHourlyRollup() (time.Time, error)
+	UsedSize() (int64, error)
+	AutoVacuumMode() (string, error)
+	FreePages() (int64, error)
+	IncrementalVacuum(ctx context.Context, opts database.BatchOptions) (int64, error)
+}
+
+// Policy holds the lifetimes in days (0 = keep forever), the archive
+// directory ("" = no archive) and the size cap in bytes (0 = no cap), and
+// how cleanup is paced
+type Policy struct {
+	BodyDays   int
+	LogDays    int
+	HourlyDays int
+	DailyDays  int
+	ArchiveDir string
+	MaxSize    int64
+
+	// Window limits scheduled runs to a daily period (zero = any time)
+	Window      config.Window
+	BatchSize   int           // rows deleted per step
+	BatchPause  time.Duration // wait between steps
+	VacuumPages int           // pages returned to the file system per step
+}
+
+// PolicyFrom returns the retention policy set by cfg
+func PolicyFrom(cfg *config.Config) (Policy, error) {
+	window, err := config.ParseWindow(cfg.Maintenance.Window)
+	if err != nil {
+		return Policy{}, fmt.Errorf("maintenance window: %w", err)
+	}
+	return Policy{
+		BodyDays:    cfg.Retention.BodyDays,
+		LogDays:     cfg.LogRetentionDays,
+		HourlyDays:  cfg.HourlyRollupRetention(),
+		DailyDays:   cfg.DailyRollupRetention(),
+		ArchiveDir:  cfg.Retention.ArchiveDir,
+		MaxSize:     int64(cfg.Retention.MaxDBSizeMB) << 20,
+		Window:      window,
+		BatchSize:   cfg.Maintenance.BatchSize,
+		BatchPause:  cfg.Maintenance.BatchPause,
+		VacuumPages: cfg.Maintenance.VacuumPages,
+	}, nil
+}
+
+// Result counts what one run removed
+type Result struct {
+	DetailsCleared int64
+	LogsDeleted    int64
+	LogsArchived   int64
+	RollupsDeleted int64
+	PagesFreed     int64
+	// Interrupted is set when the maintenance window closed, or the
+	// Manager stopped, before the run finished; the next run carries on
+	Interrupted bool
+}
+
+// Manager applies a retention policy once a day, within the policy's
+// maintenance window when it has one
+type Manager struct {
+	store  Store
+	policy atomic.Pointer[Policy]
+
+	// mu serialises runs
+	mu sync.Mutex
+
+	// ctx is cancelled by Stop, interrupting a run in progress
+	ctx    context.Context
+	cancel context.CancelFunc
+	now    func() time.Time
+}
+
+// NewManager creates a Manager applying p
+func NewManager(store Store, p Policy) *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &Manager{
+		store:  store,
+		ctx:    ctx,
+		cancel: cancel,
+		now:    time.Now,
+	}
+	m.policy.Store(&p)
+	return m
+}
+
+// SetPolicy replaces the policy; it applies from the next run
+func (m *Manager) SetPolicy(p Policy) {
+	m.policy.Store(&p)
+}
+
+// Start runs the policy daily until Stop is called: straight away and then
+// every 24 hours, or at the opening of each maintenance window. A run
+// still going when its window closes stops there.
+func (m *Manager) Start() {
+	go func() {
+		next := m.now()
+		for {
+			start, end := m.policy.Load().Window.Next(next)
+			if !m.sleepUntil(start) {
+				return
+			}
+			if end.IsZero() {
+				m.runLogged(m.ctx)
+				next = start.Add(day)
+				continue
+			}
+			ctx, cancel := context.WithDeadline(m.ctx, end)
+			m.runLogged(ctx)
+			cancel()
+			next = end
+		}
+	}()
+}
+
+// sleepUntil waits for t and reports whether the Manager is still running
+func (m *Manager) sleepUntil(t time.Time) bool {
+	timer := time.NewTimer(t.Sub(m.now()))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-m.ctx.Done():
+		return false
+	}
+}
+
+// Stop ends the daily runs and interrupts one in progress
+func (m *Manager) Stop() {
+	m.cancel()
+}
+
+// runLogged runs the policy and logs the outcome
+func (m *Manager) runLogged(ctx context.Context) {
+	res, err := m.runContext(ctx)
+	attrs := []any{"details_cleared", res.DetailsCleared, "logs_deleted", res.LogsDeleted, "logs_archived", res.LogsArchived,
+		"rollups_deleted", res.RollupsDeleted, "pages_freed", res.PagesFreed}
+	switch {
+	case err != nil:
+		slog.Error("Retention cleanup failed", append([]any{"error", err}, attrs...)...)
+	case res.Interrupted:
+		slog.Info("Retention cleanup interrupted, it carries on in the next run", attrs...)
+	case res != (Result{}):
+		slog.Info("Retention cleanup complete", attrs...)
+	default:
+		slog.Debug("Retention cleanup ran, nothing expired")
+	}
+}
+
+// Run applies the policy once, outside any maintenance window: expired
+// logs (archived first when configured), expired request details, expired
+// rollups, then the size cap, and finally returns the freed pages to the
+// file system
+func (m *Manager) Run() (Result, error) {
+	return m.runContext(context.Background())
+}
+
+// runContext runs the policy until done or ctx ends
+func (m *Manager) runContext(ctx context.Context) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	metrics.MaintenanceRunning.Set(1)
+	defer metrics.MaintenanceRunning.Set(0)
+
+	res, err := m.run(ctx, *m.policy.Load())
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		res.Interrupted, err = true, nil
+	}
+	switch {
+	case err != nil:
+		metrics.RetentionRuns.WithLabelValues("error").Inc()
+		return res, err
+	case res.Interrupted:
+		metrics.RetentionRuns.WithLabelValues("interrupted").Inc()
+	default:
+		metrics.RetentionRuns.WithLabelValues("ok").Inc()
+		metrics.RetentionLastRun.Set(float64(m.now().Unix()))
+	}
+	return res, nil
+}
+
+func (m *Manager) run(ctx context.Context, p Policy) (Result, error) {
+	var res Result
+	now := m.now()
+
+	if p.LogDays > 0 {
+		if err := m.expireLogs(ctx, p, now.AddDate(0, 0, -p.LogDays), &res); err != nil {
+			return res, err
+		}
+	}
+	if p.BodyDays > 0 && (p.LogDays == 0 || p.BodyDays < p.LogDays) {
+		n, err := m.store.ClearDetails(ctx, now.AddDate(0, 0, -p.BodyDays), withProgress("details", p.deletes(), metrics.RetentionDetailsCleared))
+		res.DetailsCleared += n
+		if err != nil {
+			return res, err
+		}
+	}
+	if p.HourlyDays > 0 {
+		n, err := m.store.PurgeHourlyRollups(ctx, now.AddDate(0, 0, -p.HourlyDays), withProgress("hourly_rollups", p.deletes(), metrics.RetentionRollupsDeleted))
+		res.RollupsDeleted += n
+		if err != nil {
+			return res, err
+		}
+	}
+	if p.DailyDays > 0 {
+		n, err := m.store.PurgeDailyRollups(ctx, now.AddDate(0, 0, -p.DailyDays), withProgress("daily_rollups", p.deletes(), metrics.RetentionRollupsDeleted))
+		res.RollupsDeleted += n
+		if err != nil {
+			return res, err
+		}
+	}
+	if p.MaxSize > 0 {
+		if err := m.enforceSize(ctx, p, &res); err != nil {
+			return res, err
+		}
+	}
+
+	if used, err := m.store.UsedSize(); err == nil {
+		metrics.DBUsedSize.Set(float64(used))
+	}
+	err := m.vacuum(ctx, p, &res)
+	if free, freeErr := m.store.FreePages(); freeErr == nil {
+		metrics.DBFreePages.Set(float64(free))
+	}
+	return res, err
+}
+
+// expireLogs archives, if configured, and deletes the logs before cutoff
+func (m *Manager) expireLogs(ctx context.Context, p Policy, cutoff time.Time, res *Result) error {
+	opts := withProgress("logs", p.deletes(), metrics.RetentionDeleted)
+	if p.ArchiveDir == "" {
+		n, err := m.store.PurgeLogs(ctx, cutoff, opts)
+		res.LogsDeleted += n
+		return err
+	}
+
+	// A day at a time: once a day is archived its deletes run to the end,
+	// so an interrupted run never leaves logs to be archived twice
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		oldest, err := m.store.OldestLog()
+		if err != nil {
+			return err
+		}
+		if oldest.IsZero() || !oldest.Before(cutoff) {
+			return nil
+		}
+		end := oldest.UTC().Truncate(day).Add(day)
+		if end.After(cutoff) {
+			end = cutoff
+		}
+
+		n, err := Archive(m.store, p.ArchiveDir, end)
+		res.LogsArchived += n
+		metrics.RetentionArchived.Add(float64(n))
+		if err != nil {
+			return err
+		}
+		n, err = m.store.PurgeLogs(context.WithoutCancel(ctx), end, opts)
+		res.LogsDeleted += n
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// enforceSize expires the oldest day of raw logs, then of hourly rollups,
+// until the used size is within the cap. Daily rollups are kept; they are
+// what remains of expired data.
+func (m *Manager) enforceSize(ctx context.Context, p Policy, res *Result) error {
+	steps := []struct {
+		oldest func() (time.Time, error)
+		expire func(cutoff time.Time) (int64, error)
+	}{
+		{m.store.OldestLog, func(cutoff time.Time) (int64, error) {
+			before := res.LogsDeleted
+			err := m.expireLogs(ctx, p, cutoff, res)
+			return res.LogsDeleted - before, err
+		}},
+		{m.store.OldestHourlyRollup, func(cutoff time.Time) (int64, error) {
+			n, err := m.store.PurgeHourlyRollups(ctx, cutoff, withProgress("hourly_rollups", p.deletes(), metrics.RetentionRollupsDeleted))
+			res.RollupsDeleted += n
+			return n, err
+		}},
+	}
+
+	for _, step := range steps {
+		for {
+			used, err := m.store.UsedSize()
+			if err != nil {
+				return err
+			}
+			if used <= p.MaxSize {
+				return nil
+			}
+			oldest, err := step.oldest()
+			if err != nil {
+				return err
+			}
+			if oldest.IsZero() {
+				break
+			}
+			n, err := step.expire(oldest.UTC().Truncate(day).Add(day))
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				break
+			}
+		}
+	}
+
+	used, err := m.store.UsedSize()
+	if err != nil {
+		return err
+	}
+	if used > p.MaxSize {
+		slog.Warn("Database is still over its size cap after expiring logs and hourly rollups",
+			"used_bytes", used, "max_bytes", p.MaxSize)
+	}
+	return nil
+}
+
+// vacuum returns free pages to the file system in small steps, so request
+// logging is never locked out the way a full VACUUM would
+func (m *Manager) vacuum(ctx context.Context, p Policy, res *Result) error {
+	mode, err := m.store.AutoVacuumMode()
+	if err != nil {
+		return err
+	}
+	if mode != "incremental" {
+		if res.LogsDeleted > 0 || res.DetailsCleared > 0 || res.RollupsDeleted > 0 {
+			slog.Warn("Database does not use incremental auto-vacuum; freed space is reused but the file "+
+				"won't shrink until the vacuum command is run once with the server stopped", "auto_vacuum", mode)
+		}
+		return nil
+	}
+	opts := withProgress("vacuum", database.BatchOptions{Size: p.VacuumPages, Pause: p.BatchPause}, metrics.VacuumFreedPages)
+	n, err := m.store.IncrementalVacuum(ctx, opts)
+	res.PagesFreed += n
+	return err
+}
+
+// deletes returns the pacing of batched deletes
+func (p Policy) deletes() database.BatchOptions {
+	return database.BatchOptions{Size: p.BatchSize, Pause: p.BatchPause}
+}
+
+// withProgress adds a progress callback to opts that counts each step of
+// task, adds its rows or pages to counter and logs the running total at
+// most every progressInterval
+func withProgress(task string, opts database.BatchOptions, counter *metrics.Counter) database.BatchOptions {
+	steps := metrics.MaintenanceSteps.WithLabelValues(task)
+	var total int64
+	start := time.Now()
+	last := start
+	opts.Progress = func(n int64) {
+		steps.Inc()
+		counter.Add(float64(n))
+		total += n
+		if now := time.Now(); now.Sub(last) >= progressInterval {
+			last = now
+			slog.Info("Retention cleanup progress", "task", task, "done", total,
+				"elapsed", now.Sub(start).Round(time.Second))
+		}
+	}
+	return opts
+}
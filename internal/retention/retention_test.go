@@ -0,0 +1,370 @@
+package retention
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/config"
+	"github.com/dangogh/silver-eureka/internal/database"
+)
+
+func setupTestDB(t *testing.T) *database.DB {
+	t.Helper()
+	db, err := database.New(filepath.Join(t.TempDir(), "requests.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("Failed to close database: %v", err)
+		}
+	})
+	return db
+}
+
+// readArchive decodes every log in an archive file
+func readArchive(t *testing.T, path string) []database.RequestLog {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			// Read-only, nothing to flush
+		}
+	}()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+
+	var logs []database.RequestLog
+	scanner := bufio.NewScanner(zr)
+	for scanner.Scan() {
+		var log database.RequestLog
+		if err := json.Unmarshal(scanner.Bytes(), &log); err != nil {
+			t.Fatalf("Invalid archive line %q: %v", scanner.Text(), err)
+		}
+		logs = append(logs, log)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	return logs
+}
+
+func TestRun_Tiers(t *testing.T) {
+	db := setupTestDB(t)
+	now := time.Now()
+	old, older := now.AddDate(0, 0, -40), now.AddDate(0, 0, -100)
+	if _, err := db.ImportLogs([]database.RequestLog{
+		{IPAddress: "192.0.2.1", URL: "/older", Timestamp: older},
+		{IPAddress: "192.0.2.2", URL: "/old", Timestamp: old},
+		{IPAddress: "192.0.2.3", URL: "/recent", Timestamp: now.AddDate(0, 0, -5)},
+	}); err != nil {
+		t.Fatalf("Failed to import logs: %v", err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "archive")
+	m := NewManager(db, Policy{LogDays: 30, HourlyDays: 60, ArchiveDir: dir})
+	res, err := m.Run()
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if res.LogsDeleted != 2 || res.LogsArchived != 2 {
+		t.Errorf("Expected 2 logs archived and deleted, got %+v", res)
+	}
+	// Its total, URL and address rows
+	if res.RollupsDeleted != 3 {
+		t.Errorf("Expected the oldest hourly rollups to be deleted, got %+v", res)
+	}
+
+	// Each expired day has its own archive
+	for _, ts := range []time.Time{older, old} {
+		logs := readArchive(t, filepath.Join(dir, ArchiveName(ts)))
+		if len(logs) != 1 || !logs[0].Timestamp.Equal(ts) {
+			t.Errorf("Unexpected archive for %s: %+v", ArchiveName(ts), logs)
+		}
+	}
+
+	// The daily rollups still count everything
+	summary, err := db.GetSummary()
+	if err != nil {
+		t.Fatalf("Failed to get summary: %v", err)
+	}
+	if summary.TotalRequests != 3 {
+		t.Errorf("Expected 3 requests in the daily rollups, got %d", summary.TotalRequests)
+	}
+	logs, err := db.QueryLogs(database.LogFilter{})
+	if err != nil {
+		t.Fatalf("Failed to query logs: %v", err)
+	}
+	if len(logs) != 1 || logs[0].URL != "/recent" {
+		t.Errorf("Expected only the recent log to remain, got %+v", logs)
+	}
+}
+
+func TestRun_ClearsDetails(t *testing.T) {
+	db := setupTestDB(t)
+	details := database.RequestDetails{UserAgent: "curl/8.0", Headers: http.Header{"Authorization": {"Basic c2VjcmV0"}}, Body: []byte("password=hunter2")}
+	if _, err := db.LogSensorRequests("", []database.SensorRequest{
+		{Timestamp: time.Now().AddDate(0, 0, -10), IPAddress: "192.0.2.1", URL: "/old", Details: details},
+		{Timestamp: time.Now().AddDate(0, 0, -1), IPAddress: "192.0.2.2", URL: "/new", Details: details},
+	}); err != nil {
+		t.Fatalf("Failed to log requests: %v", err)
+	}
+
+	res, err := NewManager(db, Policy{BodyDays: 7, LogDays: 30}).Run()
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if res.DetailsCleared != 1 || res.LogsDeleted != 0 {
+		t.Errorf("Expected the old log's details cleared and no logs deleted, got %+v", res)
+	}
+
+	logs, err := db.QueryLogs(database.LogFilter{Details: true})
+	if err != nil {
+		t.Fatalf("Failed to query logs: %v", err)
+	}
+	if len(logs) != 2 {
+		t.Fatalf("Expected both logs kept, got %+v", logs)
+	}
+	if d := logs[1].Details; d.Headers != nil || d.Body != nil || d.UserAgent != "curl/8.0" {
+		t.Errorf("Expected the old log's headers and body cleared and its user agent kept, got %+v", d)
+	}
+	if d := logs[0].Details; d.Headers.Get("Authorization") == "" || string(d.Body) != "password=hunter2" {
+		t.Errorf("Expected the new log's details kept, got %+v", d)
+	}
+	if hits, err := db.Search(database.SearchFilter{Query: "hunter2"}); err != nil || len(hits) != 1 || hits[0].URL != "/new" {
+		t.Errorf("Expected only the new log's body to be searchable, got %+v (%v)", hits, err)
+	}
+}
+
+func TestRun_KeepForever(t *testing.T) {
+	db := setupTestDB(t)
+	if _, err := db.ImportLogs([]database.RequestLog{
+		{IPAddress: "192.0.2.1", URL: "/ancient", Timestamp: time.Now().AddDate(-5, 0, 0)},
+	}); err != nil {
+		t.Fatalf("Failed to import logs: %v", err)
+	}
+
+	res, err := NewManager(db, Policy{}).Run()
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if res != (Result{}) {
+		t.Errorf("Expected nothing expired without a policy, got %+v", res)
+	}
+}
+
+func TestRun_SizeCap(t *testing.T) {
+	db := setupTestDB(t)
+	start := time.Now().UTC().Truncate(day).AddDate(0, 0, -5)
+	// Few distinct URLs keep the rollups small next to the raw logs
+	var logs []database.RequestLog
+	for d := 0; d < 5; d++ {
+		for i := 0; i < 500; i++ {
+			logs = append(logs, database.RequestLog{
+				IPAddress: "192.0.2.1",
+				URL:       "/" + strings.Repeat("x", 1000) + strconv.Itoa(i%10),
+				Timestamp: start.AddDate(0, 0, d).Add(time.Duration(i) * time.Second),
+			})
+		}
+	}
+	if _, err := db.ImportLogs(logs); err != nil {
+		t.Fatalf("Failed to import logs: %v", err)
+	}
+	used, err := db.UsedSize()
+	if err != nil {
+		t.Fatalf("Failed to read database size: %v", err)
+	}
+
+	limit := used / 2
+	res, err := NewManager(db, Policy{MaxSize: limit}).Run()
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if used, err = db.UsedSize(); err != nil || used > limit {
+		t.Errorf("Expected the database within %d bytes, got %d (%v)", limit, used, err)
+	}
+	if res.LogsDeleted == 0 || res.LogsDeleted%500 != 0 {
+		t.Errorf("Expected whole days of logs deleted, got %+v", res)
+	}
+
+	// The oldest days go first
+	oldest, err := db.OldestLog()
+	if err != nil {
+		t.Fatalf("Failed to read oldest log: %v", err)
+	}
+	if want := start.AddDate(0, 0, int(res.LogsDeleted/500)); !oldest.Equal(want) {
+		t.Errorf("Expected the oldest remaining log at %v, got %v", want, oldest)
+	}
+}
+
+func TestArchive_AppendsToDay(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	ts := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	for i, url := range []string{"/first", "/second"} {
+		if _, err := db.ImportLogs([]database.RequestLog{
+			{IPAddress: "192.0.2.1", URL: url, Timestamp: ts.Add(time.Duration(i) * time.Hour)},
+		}); err != nil {
+			t.Fatalf("Failed to import logs: %v", err)
+		}
+		if _, err := Archive(db, dir, ts.Add(time.Duration(i)*time.Hour+time.Minute)); err != nil {
+			t.Fatalf("Archive failed: %v", err)
+		}
+		if _, err := db.PurgeBefore(ts.Add(time.Duration(i)*time.Hour + time.Minute)); err != nil {
+			t.Fatalf("Failed to purge logs: %v", err)
+		}
+	}
+
+	logs := readArchive(t, filepath.Join(dir, ArchiveName(ts)))
+	if len(logs) != 2 || logs[0].URL != "/first" || logs[1].URL != "/second" {
+		t.Errorf("Expected both runs in one archive, got %+v", logs)
+	}
+}
+
+func TestArchive_FullRows(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	ts := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	if _, err := db.LogSensorRequests("edge-1", []database.SensorRequest{{
+		Timestamp: ts, IPAddress: "192.0.2.1", URL: "/login",
+		Details: database.RequestDetails{
+			UserAgent: "curl/8.0",
+			Headers:   http.Header{"Accept": {"*/*"}, "X-Probe": {"a", "b"}},
+			Body:      []byte("user=admin"),
+			Listener:  "web",
+			LocalPort: 8080,
+		},
+	}}); err != nil {
+		t.Fatalf("Failed to log request: %v", err)
+	}
+
+	if _, err := Archive(db, dir, ts.Add(time.Hour)); err != nil {
+		t.Fatalf("Archive failed: %v", err)
+	}
+	logs := readArchive(t, filepath.Join(dir, ArchiveName(ts)))
+	if len(logs) != 1 || logs[0].Sensor != "edge-1" || logs[0].Details == nil {
+		t.Fatalf("Expected the log with its details, got %+v", logs)
+	}
+	d := logs[0].Details
+	if d.UserAgent != "curl/8.0" || string(d.Body) != "user=admin" || d.Listener != "web" || d.LocalPort != 8080 {
+		t.Errorf("Unexpected archived details: %+v", d)
+	}
+	if d.Headers.Get("Accept") != "*/*" || len(d.Headers.Values("X-Probe")) != 2 {
+		t.Errorf("Unexpected archived headers: %v", d.Headers)
+	}
+}
+
+func TestRun_ReclaimsSpace(t *testing.T) {
+	db := setupTestDB(t)
+	var logs []database.RequestLog
+	for i := 0; i < 200; i++ {
+		logs = append(logs, database.RequestLog{
+			IPAddress: "192.0.2.1",
+			URL:       "/" + strings.Repeat("x", 2000),
+			Timestamp: time.Now().AddDate(0, 0, -40).Add(time.Duration(i) * time.Second),
+		})
+	}
+	if _, err := db.ImportLogs(logs); err != nil {
+		t.Fatalf("Failed to import logs: %v", err)
+	}
+
+	res, err := NewManager(db, Policy{LogDays: 30, BatchSize: 50, VacuumPages: 20}).Run()
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if res.LogsDeleted != 200 || res.PagesFreed == 0 {
+		t.Errorf("Expected 200 logs deleted and their pages freed, got %+v", res)
+	}
+	if free, err := db.FreePages(); err != nil || free != 0 {
+		t.Errorf("Expected no free pages left, got %d (%v)", free, err)
+	}
+}
+
+// interruptingStore stops a run once the first batch of logs is purged,
+// as the end of a maintenance window would
+type interruptingStore struct {
+	Store
+	cancel context.CancelFunc
+}
+
+func (s interruptingStore) PurgeLogs(ctx context.Context, cutoff time.Time, opts database.BatchOptions) (int64, error) {
+	n, err := s.Store.PurgeLogs(ctx, cutoff, opts)
+	s.cancel()
+	return n, err
+}
+
+func TestRun_InterruptedArchivesOnce(t *testing.T) {
+	db := setupTestDB(t)
+	start := time.Now().UTC().Truncate(day).AddDate(0, 0, -10)
+	var logs []database.RequestLog
+	for d := 0; d < 3; d++ {
+		for i := 0; i < 5; i++ {
+			logs = append(logs, database.RequestLog{
+				IPAddress: "192.0.2.1",
+				URL:       "/day" + strconv.Itoa(d),
+				Timestamp: start.AddDate(0, 0, d).Add(time.Duration(i) * time.Hour),
+			})
+		}
+	}
+	if _, err := db.ImportLogs(logs); err != nil {
+		t.Fatalf("Failed to import logs: %v", err)
+	}
+
+	dir := t.TempDir()
+	policy := Policy{LogDays: 5, ArchiveDir: dir, BatchSize: 2}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	res, err := NewManager(interruptingStore{db, cancel}, policy).runContext(ctx)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !res.Interrupted || res.LogsArchived != 5 || res.LogsDeleted != 5 {
+		t.Errorf("Expected the run to stop after the first whole day, got %+v", res)
+	}
+
+	// The next run carries on without archiving the first day again
+	res, err = NewManager(db, policy).Run()
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if res.Interrupted || res.LogsArchived != 10 || res.LogsDeleted != 10 {
+		t.Errorf("Expected the remaining two days expired, got %+v", res)
+	}
+	for d := 0; d < 3; d++ {
+		if got := readArchive(t, filepath.Join(dir, ArchiveName(start.AddDate(0, 0, d)))); len(got) != 5 {
+			t.Errorf("Expected 5 logs archived for day %d, got %d", d, len(got))
+		}
+	}
+}
+
+func TestPolicyFrom(t *testing.T) {
+	cfg := config.Default()
+	cfg.Maintenance.Window = "02:00-04:30"
+	p, err := PolicyFrom(cfg)
+	if err != nil {
+		t.Fatalf("PolicyFrom failed: %v", err)
+	}
+	if p.Window.Start != 2*time.Hour || p.Window.End != 4*time.Hour+30*time.Minute || p.LogDays != cfg.LogRetentionDays {
+		t.Errorf("Unexpected policy %+v", p)
+	}
+
+	cfg.Maintenance.Window = "late"
+	if _, err := PolicyFrom(cfg); err == nil {
+		t.Error("Expected an invalid window to be reported")
+	}
+}
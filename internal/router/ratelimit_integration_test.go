@@ -4,6 +4,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	"github.com/dangogh/silver-eureka/internal/config"
 )
 
 func TestRateLimitingIntegration(t *testing.T) {
@@ -72,3 +74,111 @@ func TestRateLimitingDisabled(t *testing.T) {
 		}
 	}
 }
+
+func TestRateLimitingPerRouteGroup(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			// Ignore close errors in test cleanup
+		}
+	}()
+
+	rateLimit := config.DefaultRateLimitConfig()
+	rateLimit.CatchAll.PerIPPerMin = 10
+	rateLimit.CatchAll.PerIPBurst = 1
+
+	router, err := NewWithOptions(db, Options{RateLimit: &rateLimit})
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+
+	// Exhaust the catch-all budget
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/scanner/probe", nil)
+		req.RemoteAddr = "192.0.2.1:12345"
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/scanner/probe", nil)
+	req.RemoteAddr = "192.0.2.1:12345"
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected catch-all to be rate limited, got %d", rec.Code)
+	}
+
+	// The stats group has its own budget
+	req = httptest.NewRequest(http.MethodGet, "/stats/summary", nil)
+	req.RemoteAddr = "192.0.2.1:12345"
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected stats request to succeed, got %d", rec.Code)
+	}
+}
+
+func TestNewWithOptions_InvalidPolicy(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			// Ignore close errors in test cleanup
+		}
+	}()
+
+	rateLimit := config.DefaultRateLimitConfig()
+	rateLimit.Stats.Exempt = []string{"not-a-cidr"}
+
+	if _, err := NewWithOptions(db, Options{RateLimit: &rateLimit}); err == nil {
+		t.Error("Expected error for invalid exempt CIDR")
+	}
+}
+
+func TestSetRateLimits(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			// Ignore close errors in test cleanup
+		}
+	}()
+
+	rateLimit := config.DefaultRateLimitConfig()
+	rateLimit.CatchAll.PerIPPerMin = 60
+	rateLimit.CatchAll.PerIPBurst = 1
+	router, err := NewWithOptions(db, Options{RateLimit: &rateLimit})
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+
+	serve := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/probe", nil)
+		req.RemoteAddr = "192.0.2.1:12345"
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	serve()
+	if code := serve(); code != http.StatusTooManyRequests {
+		t.Fatalf("Expected second request to be limited, got %d", code)
+	}
+
+	// An invalid policy is rejected and nothing changes
+	bad := rateLimit
+	bad.Stats.IPv4Prefix = 40
+	bad.CatchAll.Exempt = []string{"192.0.2.0/24"}
+	if err := router.SetRateLimits(bad); err == nil {
+		t.Error("Expected invalid policy to be rejected")
+	}
+	if code := serve(); code != http.StatusTooManyRequests {
+		t.Errorf("Expected limits unchanged after failed update, got %d", code)
+	}
+
+	// A valid policy applies immediately
+	rateLimit.CatchAll.Exempt = []string{"192.0.2.0/24"}
+	if err := router.SetRateLimits(rateLimit); err != nil {
+		t.Fatalf("SetRateLimits() error = %v", err)
+	}
+	if code := serve(); code != http.StatusNotFound {
+		t.Errorf("Expected exempt client to reach the handler, got %d", code)
+	}
+}
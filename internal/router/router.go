@@ -1,58 +1,342 @@
 package router
 
 import (
+	"fmt"
 	"log/slog"
 	"net/http"
+	"net/netip"
+	"slices"
 
+	"github.com/dangogh/silver-eureka/internal/alert"
+	"github.com/dangogh/silver-eureka/internal/auth"
+	"github.com/dangogh/silver-eureka/internal/backup"
+	"github.com/dangogh/silver-eureka/internal/ban"
+	"github.com/dangogh/silver-eureka/internal/config"
 	"github.com/dangogh/silver-eureka/internal/database"
+	"github.com/dangogh/silver-eureka/internal/digest"
 	"github.com/dangogh/silver-eureka/internal/handler"
+	"github.com/dangogh/silver-eureka/internal/metrics"
 	"github.com/dangogh/silver-eureka/internal/middleware"
+	"github.com/dangogh/silver-eureka/internal/sink"
 	"github.com/dangogh/silver-eureka/internal/stats"
+	"github.com/dangogh/silver-eureka/internal/telemetry"
 	"github.com/dangogh/silver-eureka/internal/web"
 )
 
+// Options configures the router
+type Options struct {
+	AuthUsername string
+	AuthPassword string
+
+	// Auth verifies credentials for the API and web interface; when nil it
+	// is built from AuthUsername, AuthPassword and the database's accounts
+	Auth middleware.Authenticator
+
+	// RateLimit holds the policy for each route group; nil disables rate limiting
+	RateLimit *config.RateLimitConfig
+
+	// RejectObservers are notified of every request rejected by a rate limiter
+	RejectObservers []middleware.RejectObserver
+
+	// Bans enforces the IP ban list and serves the ban API; nil disables bans
+	Bans *ban.Manager
+
+	// Metrics configures the /metrics endpoint; nil disables it
+	Metrics *config.MetricsConfig
+
+	// Backups serves the backup API; nil disables it
+	Backups *backup.Manager
+
+	// Capture selects what is stored of each logged request; nil uses the
+	// default capture settings
+	Capture *config.CaptureConfig
+
+	// Alerts checks logged requests against the signature alert rules; nil
+	// disables it
+	Alerts *alert.Engine
+
+	// Digest sends test digests from the dashboard; nil disables it
+	Digest *digest.Manager
+
+	// Events sends each logged request to the configured event sinks; nil
+	// disables it
+	Events *sink.Pipeline
+
+	// Telemetry traces logged requests and exports them to an
+	// OpenTelemetry collector; nil disables it
+	Telemetry *telemetry.Exporter
+
+	// LogObservers are told of every request logged by the catch-all
+	// handler, such as the sensor forwarding them to a collector
+	LogObservers []handler.Observer
+}
+
+// Router is the application's HTTP handler. It keeps the per-group rate
+// limiters so their policies can be changed while serving.
+type Router struct {
+	http.Handler
+	limiters map[string]*middleware.RateLimiter
+}
+
 // New creates a new HTTP router with all application routes
 func New(db *database.DB, authUsername, authPassword string) http.Handler {
 	return NewWithRateLimiter(db, authUsername, authPassword, true)
 }
 
 // NewWithRateLimiter creates a new HTTP router with optional rate limiting
+// using the default rate limit policies
 func NewWithRateLimiter(db *database.DB, authUsername, authPassword string, enableRateLimit bool) http.Handler {
+	opts := Options{
+		AuthUsername: authUsername,
+		AuthPassword: authPassword,
+	}
+	if enableRateLimit {
+		rateLimit := config.DefaultRateLimitConfig()
+		opts.RateLimit = &rateLimit
+	}
+
+	h, err := NewWithOptions(db, opts)
+	if err != nil {
+		// The default policies are always valid
+		panic(err)
+	}
+	return h
+}
+
+// NewWithOptions creates a new HTTP router with per-route-group rate limiting
+func NewWithOptions(db *database.DB, opts Options) (*Router, error) {
 	mux := http.NewServeMux()
+	rt := &Router{limiters: make(map[string]*middleware.RateLimiter)}
+
+	// Build one rate limiter per route group
+	catchAllLimit, statsLimit, webLimit, healthLimit := passThrough, passThrough, passThrough, passThrough
+	if opts.RateLimit != nil {
+		for _, group := range rateLimitGroups(opts.RateLimit) {
+			observers := opts.RejectObservers
+			if opts.Bans != nil && group.name == "catchall" {
+				// Only honeypot traffic earns strikes
+				observers = append(slices.Clone(observers), opts.Bans)
+			}
+			rateLimiter, err := newRateLimiter(group.name, group.policy, observers)
+			if err != nil {
+				return nil, fmt.Errorf("%s rate limit policy: %w", group.name, err)
+			}
+			rt.limiters[group.name] = rateLimiter
+		}
+		catchAllLimit = rt.limiters["catchall"].Middleware()
+		statsLimit = rt.limiters["stats"].Middleware()
+		webLimit = rt.limiters["web"].Middleware()
+		healthLimit = rt.limiters["health"].Middleware()
+	}
+
+	// Count responses per route group
+	catchAllLimit = instrumented("catchall", catchAllLimit)
+	statsLimit = instrumented("stats", statsLimit)
+	webLimit = instrumented("web", webLimit)
+	healthLimit = instrumented("health", healthLimit)
+
+	// Default handler for all other requests (logs them, returns 404)
+	capture := config.DefaultCaptureConfig()
+	if opts.Capture != nil {
+		capture = *opts.Capture
+	}
+	catchAllHandler := handler.NewWithCapture(db, capture)
+	for _, o := range opts.LogObservers {
+		catchAllHandler.Observe(o)
+	}
+	var logHandler http.Handler = catchAllHandler
+	if opts.Bans != nil {
+		logHandler = opts.Bans.SignatureMiddleware()(logHandler)
+	}
+	if opts.Alerts != nil {
+		logHandler = opts.Alerts.Middleware()(logHandler)
+	}
+	if opts.Events != nil {
+		logHandler = opts.Events.Middleware()(logHandler)
+	}
+	// Tracing starts before the rate limiter so rejections are traced too
+	catchAll := catchAllLimit(logHandler)
+	if opts.Telemetry != nil {
+		catchAll = opts.Telemetry.Middleware()(catchAll)
+	}
+	// Banned IPs are turned away from the honeypot routes before any other
+	// processing; the admin, stats and health routes are never banned
+	if opts.Bans != nil {
+		catchAll = opts.Bans.Middleware()(catchAll)
+	}
+	mux.Handle("/", catchAll)
 
 	// Health check endpoint (public, no auth)
-	mux.HandleFunc("/health", handleHealth(db))
+	mux.Handle("/health", healthLimit(handleHealth(db)))
+
+	// Prometheus metrics (bearer token or allow-listed network); anyone else
+	// is a probe, logged and rate limited by the catch-all
+	if opts.Metrics != nil && opts.Metrics.Enabled {
+		allow := make([]netip.Prefix, 0, len(opts.Metrics.AllowList))
+		for _, cidr := range opts.Metrics.AllowList {
+			prefix, err := netip.ParsePrefix(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid metrics allow-list CIDR %q: %w", cidr, err)
+			}
+			allow = append(allow, prefix.Masked())
+		}
+		mux.Handle("GET /metrics", middleware.TokenOrAllowList(opts.Metrics.Token, allow, catchAll)(metrics.Handler()))
+	}
+
+	authenticator := opts.Auth
+	if authenticator == nil {
+		authenticator = auth.New(db, opts.AuthUsername, opts.AuthPassword)
+	}
 
 	// Web interface routes (session-based auth)
-	if authUsername != "" && authPassword != "" {
-		webHandler := web.NewHandler(db, authUsername, authPassword)
-		mux.HandleFunc("GET /login", webHandler.HandleLoginPage)
-		mux.HandleFunc("POST /login", webHandler.HandleLoginSubmit)
-		mux.HandleFunc("POST /logout", webHandler.RequireAuth(webHandler.HandleLogout))
-		mux.HandleFunc("GET /dashboard", webHandler.RequireAuth(webHandler.HandleDashboard))
-		mux.HandleFunc("GET /stats-view/{type}", webHandler.RequireAuth(webHandler.HandleStatsView))
+	if authenticator.Enabled() {
+		webHandler := web.NewHandler(db, opts.AuthUsername, opts.AuthPassword)
+		webHandler.SetAuthenticator(authenticator)
+		metrics.ActiveSessions.Set(func() float64 { return float64(webHandler.SessionCount()) })
+		mux.Handle("GET /login", webLimit(http.HandlerFunc(webHandler.HandleLoginPage)))
+		mux.Handle("POST /login", webLimit(http.HandlerFunc(webHandler.HandleLoginSubmit)))
+		mux.Handle("POST /logout", webLimit(webHandler.RequireAuth(webHandler.HandleLogout)))
+		mux.Handle("GET /dashboard", webLimit(webHandler.RequireAuth(webHandler.HandleDashboard)))
+		mux.Handle("GET /stats-view/{type}", webLimit(webHandler.RequireAuth(webHandler.HandleStatsView)))
+		mux.Handle("GET /search", webLimit(webHandler.RequireAuth(webHandler.HandleSearch)))
+		if opts.Bans != nil {
+			webHandler.SetBanManager(opts.Bans)
+			mux.Handle("GET /bans", webLimit(webHandler.RequireAuth(webHandler.HandleBans)))
+			mux.Handle("POST /bans", webLimit(webHandler.RequireAuth(webHandler.HandleBanAdd)))
+			mux.Handle("POST /bans/remove", webLimit(webHandler.RequireAuth(webHandler.HandleBanRemove)))
+		}
+		if opts.Digest != nil {
+			webHandler.SetDigestManager(opts.Digest)
+			mux.Handle("POST /digest/test", webLimit(webHandler.RequireAuth(webHandler.HandleDigestTest)))
+		}
 	}
 
-	// API stats endpoints (protected with basic auth if configured)
-	authMiddleware := middleware.BasicAuth(authUsername, authPassword)
+	// API stats endpoints (protected with basic auth or API tokens if configured)
+	authMiddleware := middleware.RequireAuth(authenticator)
+	// Routes that change state or hand out the database exist only while
+	// credentials are required; otherwise their requests are logged like
+	// any other probe
+	adminOnly := middleware.RequireAuthEnabled(authenticator, catchAll)
 	statsHandler := stats.New(db)
-	mux.Handle("/stats/endpoints", authMiddleware(http.HandlerFunc(statsHandler.HandleEndpointStats)))
-	mux.Handle("/stats/sources", authMiddleware(http.HandlerFunc(statsHandler.HandleSourceStats)))
-	mux.Handle("/stats/summary", authMiddleware(http.HandlerFunc(statsHandler.HandleSummary)))
-	mux.Handle("/stats/download", authMiddleware(http.HandlerFunc(statsHandler.HandleDownload)))
+	mux.Handle("/stats/endpoints", statsLimit(authMiddleware(http.HandlerFunc(statsHandler.HandleEndpointStats))))
+	mux.Handle("/stats/sources", statsLimit(authMiddleware(http.HandlerFunc(statsHandler.HandleSourceStats))))
+	mux.Handle("/stats/summary", statsLimit(authMiddleware(http.HandlerFunc(statsHandler.HandleSummary))))
+	mux.Handle("/stats/sensors", statsLimit(authMiddleware(http.HandlerFunc(statsHandler.HandleSensorStats))))
+	mux.Handle("/stats/listeners", statsLimit(authMiddleware(http.HandlerFunc(statsHandler.HandleListenerStats))))
+	mux.Handle("/stats/connections", statsLimit(authMiddleware(http.HandlerFunc(statsHandler.HandleConnectionStats))))
+	mux.Handle("/stats/download", statsLimit(authMiddleware(http.HandlerFunc(statsHandler.HandleDownload))))
+	mux.Handle("/stats/search", statsLimit(authMiddleware(http.HandlerFunc(statsHandler.HandleSearch))))
+	mux.Handle("POST /stats/import", adminOnly(statsLimit(authMiddleware(http.HandlerFunc(statsHandler.HandleImport)))))
+	if opts.Bans != nil {
+		mux.Handle("GET /stats/bans", statsLimit(authMiddleware(http.HandlerFunc(opts.Bans.HandleList))))
+		mux.Handle("POST /stats/bans", adminOnly(statsLimit(authMiddleware(http.HandlerFunc(opts.Bans.HandleAdd)))))
+		mux.Handle("DELETE /stats/bans", adminOnly(statsLimit(authMiddleware(http.HandlerFunc(opts.Bans.HandleRemove)))))
+		mux.Handle("GET /stats/bans/export", statsLimit(authMiddleware(http.HandlerFunc(opts.Bans.HandleExport))))
+	}
+	if opts.Backups != nil {
+		mux.Handle("GET /stats/backups", statsLimit(authMiddleware(http.HandlerFunc(opts.Backups.HandleList))))
+		mux.Handle("POST /stats/backups", adminOnly(statsLimit(authMiddleware(http.HandlerFunc(opts.Backups.HandleCreate)))))
+		mux.Handle("GET /stats/backups/{name}", adminOnly(statsLimit(authMiddleware(http.HandlerFunc(opts.Backups.HandleDownload)))))
+	}
 
-	// Default handler for all other requests (logs them, returns 404)
-	logHandler := handler.New(db)
-	mux.Handle("/", logHandler)
+	rt.Handler = mux
+	return rt, nil
+}
 
-	// Apply rate limiting to all routes if enabled
-	if enableRateLimit {
-		// Initialize rate limiter: 100 req/min per IP, 10,000 req/min global
-		rateLimiter := middleware.NewRateLimiter(100, 10000)
-		return rateLimiter.Middleware()(mux)
+// SetRateLimits applies new rate limit policies to the running limiters.
+// All policies are checked before any is applied. It has no effect when
+// the router was built without rate limiting.
+func (rt *Router) SetRateLimits(cfg config.RateLimitConfig) error {
+	policies := make(map[string]middleware.RateLimitPolicy, len(rt.limiters))
+	for _, group := range rateLimitGroups(&cfg) {
+		if _, ok := rt.limiters[group.name]; !ok {
+			continue
+		}
+		policy, err := limiterPolicy(group.name, group.policy)
+		if err != nil {
+			return fmt.Errorf("%s rate limit policy: %w", group.name, err)
+		}
+		policies[group.name] = policy
 	}
+	for name, policy := range policies {
+		rt.limiters[name].SetPolicy(policy)
+	}
+	return nil
+}
+
+// rateLimitGroup pairs a route group name with its configured policy
+type rateLimitGroup struct {
+	name   string
+	policy config.RateLimitPolicy
+}
 
-	return mux
+// rateLimitGroups lists the rate-limited route groups
+func rateLimitGroups(cfg *config.RateLimitConfig) []rateLimitGroup {
+	return []rateLimitGroup{
+		{"catchall", cfg.CatchAll},
+		{"stats", cfg.Stats},
+		{"web", cfg.Web},
+		{"health", cfg.Health},
+	}
+}
+
+// passThrough is a middleware that applies no rate limiting
+func passThrough(next http.Handler) http.Handler {
+	return next
+}
+
+// instrumented wraps a route group's middleware so every response is counted
+// under the group name; the count is taken outside the rate limiter so 429s
+// are included
+func instrumented(route string, limit func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return metrics.Instrument(route, limit(next))
+	}
+}
+
+// limiterPolicy converts a configured policy into a middleware policy
+func limiterPolicy(name string, policy config.RateLimitPolicy) (middleware.RateLimitPolicy, error) {
+	if err := policy.Validate(); err != nil {
+		return middleware.RateLimitPolicy{}, err
+	}
+
+	exempt := make([]netip.Prefix, 0, len(policy.Exempt))
+	for _, cidr := range policy.Exempt {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return middleware.RateLimitPolicy{}, fmt.Errorf("invalid exempt CIDR %q: %w", cidr, err)
+		}
+		exempt = append(exempt, prefix.Masked())
+	}
+
+	return middleware.RateLimitPolicy{
+		Name:         name,
+		PerIPPerMin:  policy.PerIPPerMin,
+		PerIPBurst:   policy.PerIPBurst,
+		GlobalPerMin: policy.GlobalPerMin,
+		GlobalBurst:  policy.GlobalBurst,
+		Exempt:       exempt,
+		IPv4Prefix:   policy.IPv4Prefix,
+		IPv6Prefix:   policy.IPv6Prefix,
+		MaxEntries:   policy.MaxEntries,
+	}, nil
+}
+
+// newRateLimiter builds a route group's rate limiter from a configured policy
+func newRateLimiter(name string, policy config.RateLimitPolicy, observers []middleware.RejectObserver) (*middleware.RateLimiter, error) {
+	p, err := limiterPolicy(name, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	rateLimiter := middleware.NewRateLimiterWithPolicy(p)
+	for _, o := range observers {
+		rateLimiter.Observe(o)
+	}
+	metrics.RateLimiterEntries.Set(func() float64 { return float64(rateLimiter.Stats().Entries) }, name)
+	metrics.RateLimiterEvictions.Set(func() float64 { return float64(rateLimiter.Stats().LRUEvictions) }, name, "lru")
+	metrics.RateLimiterEvictions.Set(func() float64 { return float64(rateLimiter.Stats().IdleEvictions) }, name, "idle")
+	return rateLimiter, nil
 }
 
 // handleHealth returns a health check handler
@@ -6,8 +6,16 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/dangogh/silver-eureka/internal/alert"
+	"github.com/dangogh/silver-eureka/internal/auth"
+	"github.com/dangogh/silver-eureka/internal/backup"
+	"github.com/dangogh/silver-eureka/internal/ban"
+	"github.com/dangogh/silver-eureka/internal/config"
 	"github.com/dangogh/silver-eureka/internal/database"
 )
 
@@ -314,3 +322,416 @@ func TestBasicAuthProtectsStatsEndpoints(t *testing.T) {
 		}
 	})
 }
+
+func TestBannedIPRejected(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			// Ignore close errors in test cleanup
+		}
+	}()
+
+	cfg := config.DefaultBanConfig()
+	cfg.Action = config.BanActionStatic
+	bans, err := ban.NewManager(db, cfg)
+	if err != nil {
+		t.Fatalf("Failed to create ban manager: %v", err)
+	}
+	defer bans.Stop()
+	if _, err := bans.Ban("192.0.2.66", "test", ban.SourceManual, 0); err != nil {
+		t.Fatalf("Failed to ban: %v", err)
+	}
+
+	router, err := NewWithOptions(db, Options{Bans: bans})
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/wp-login.php", nil)
+	req.RemoteAddr = "192.0.2.66:4444"
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for banned IP, got %d", rec.Code)
+	}
+
+	// Banned requests are not logged
+	logs, err := db.GetLogs(0)
+	if err != nil {
+		t.Fatalf("Failed to get logs: %v", err)
+	}
+	if len(logs) != 0 {
+		t.Errorf("Expected banned request not to be logged, got %d logs", len(logs))
+	}
+
+	// Ban API is registered, and a banned IP still reaches the admin and
+	// health routes
+	req = httptest.NewRequest(http.MethodGet, "/stats/bans/export", nil)
+	req.RemoteAddr = "192.0.2.66:4444"
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != "192.0.2.66/32\n" {
+		t.Errorf("Unexpected export response %d: %q", rec.Code, rec.Body.String())
+	}
+	req = httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.RemoteAddr = "192.0.2.66:4444"
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status 200 from /health for banned IP, got %d", rec.Code)
+	}
+}
+
+func TestBanAPI_RequiresAuth(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			// Ignore close errors in test cleanup
+		}
+	}()
+
+	bans, err := ban.NewManager(db, config.DefaultBanConfig())
+	if err != nil {
+		t.Fatalf("Failed to create ban manager: %v", err)
+	}
+	defer bans.Stop()
+
+	banRequest := func(router http.Handler, user, password string) int {
+		req := httptest.NewRequest(http.MethodPost, "/stats/bans", strings.NewReader(`{"cidr":"198.51.100.0/24"}`))
+		if user != "" {
+			req.SetBasicAuth(user, password)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Without credentials the route doesn't exist, and the request is
+	// logged like any other probe
+	open, err := NewWithOptions(db, Options{Bans: bans})
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	if code := banRequest(open, "", ""); code != http.StatusNotFound {
+		t.Errorf("Expected 404 without authentication, got %d", code)
+	}
+	if bans.IsBanned("198.51.100.1") {
+		t.Error("Expected no ban without authentication")
+	}
+	logs, err := db.GetLogs(0)
+	if err != nil {
+		t.Fatalf("Failed to get logs: %v", err)
+	}
+	if len(logs) != 1 || logs[0].URL != "/stats/bans" {
+		t.Errorf("Expected the request to be logged, got %+v", logs)
+	}
+
+	protected, err := NewWithOptions(db, Options{Bans: bans, AuthUsername: "admin", AuthPassword: "secret"})
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	if code := banRequest(protected, "admin", "secret"); code != http.StatusCreated {
+		t.Errorf("Expected 201 with authentication, got %d", code)
+	}
+	if !bans.IsBanned("198.51.100.1") {
+		t.Error("Expected the network to be banned")
+	}
+}
+
+func TestBackupAPI_RequiresAuth(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			// Ignore close errors in test cleanup
+		}
+	}()
+
+	backups, err := backup.NewManager(db, config.BackupConfig{Dir: filepath.Join(t.TempDir(), "backups"), Keep: 2})
+	if err != nil {
+		t.Fatalf("Failed to create backup manager: %v", err)
+	}
+	snapshot, err := backups.Create()
+	if err != nil {
+		t.Fatalf("Failed to take snapshot: %v", err)
+	}
+
+	request := func(router http.Handler, method, path string, auth bool) int {
+		req := httptest.NewRequest(method, path, nil)
+		if auth {
+			req.SetBasicAuth("admin", "secret")
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	open, err := NewWithOptions(db, Options{Backups: backups})
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	if code := request(open, http.MethodPost, "/stats/backups", false); code != http.StatusNotFound {
+		t.Errorf("Expected taking a snapshot without authentication to be a 404, got %d", code)
+	}
+	if code := request(open, http.MethodGet, "/stats/backups/"+snapshot.Name, false); code != http.StatusNotFound {
+		t.Errorf("Expected downloading a snapshot without authentication to be a 404, got %d", code)
+	}
+	if list, err := backups.List(); err != nil || len(list) != 1 {
+		t.Errorf("Expected no snapshot taken without authentication, got %v (%v)", list, err)
+	}
+
+	protected, err := NewWithOptions(db, Options{Backups: backups, AuthUsername: "admin", AuthPassword: "secret"})
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	if code := request(protected, http.MethodGet, "/stats/backups/"+snapshot.Name, true); code != http.StatusOK {
+		t.Errorf("Expected the snapshot with authentication, got %d", code)
+	}
+}
+
+func TestImportAPI_RequiresAuth(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			// Ignore close errors in test cleanup
+		}
+	}()
+
+	body := `{"IPAddress":"192.0.2.1","URL":"/imported","Timestamp":"2025-01-01T00:00:00Z"}` + "\n"
+	importRequest := func(router http.Handler, auth bool) int {
+		req := httptest.NewRequest(http.MethodPost, "/stats/import?format=ndjson", strings.NewReader(body))
+		if auth {
+			req.SetBasicAuth("admin", "secret")
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	imported := func() int {
+		logs, err := db.QueryLogs(database.LogFilter{URL: "/imported"})
+		if err != nil {
+			t.Fatalf("Failed to query logs: %v", err)
+		}
+		return len(logs)
+	}
+
+	open, err := NewWithOptions(db, Options{})
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	if code := importRequest(open, false); code != http.StatusNotFound || imported() != 0 {
+		t.Errorf("Expected no import without authentication, got %d", code)
+	}
+
+	protected, err := NewWithOptions(db, Options{AuthUsername: "admin", AuthPassword: "secret"})
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	if code := importRequest(protected, true); code != http.StatusOK || imported() != 1 {
+		t.Errorf("Expected the import with authentication, got %d", code)
+	}
+}
+
+func TestBanStrikesOnlyFromCatchAll(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			// Ignore close errors in test cleanup
+		}
+	}()
+
+	cfg := config.DefaultBanConfig()
+	cfg.AutoBan = true
+	cfg.Threshold = 1
+	bans, err := ban.NewManager(db, cfg)
+	if err != nil {
+		t.Fatalf("Failed to create ban manager: %v", err)
+	}
+	defer bans.Stop()
+
+	rateLimit := config.DefaultRateLimitConfig()
+	rateLimit.Health = config.RateLimitPolicy{PerIPPerMin: 1, PerIPBurst: 1}
+	rateLimit.CatchAll = config.RateLimitPolicy{PerIPPerMin: 1, PerIPBurst: 1}
+	router, err := NewWithOptions(db, Options{RateLimit: &rateLimit, Bans: bans})
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+
+	get := func(path string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "192.0.2.77:4444"
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Exceeding the health limit is not a strike
+	get("/health")
+	if code := get("/health"); code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429 from /health, got %d", code)
+	}
+	if bans.IsBanned("192.0.2.77") {
+		t.Fatal("Expected health rate limiting not to ban")
+	}
+
+	// Exceeding the catch-all limit is
+	get("/wp-login.php")
+	if code := get("/wp-login.php"); code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429 from the catch-all, got %d", code)
+	}
+	if !bans.IsBanned("192.0.2.77") {
+		t.Error("Expected catch-all rate limiting to ban")
+	}
+}
+
+func TestAlertSignatureRule(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			// Ignore close errors in test cleanup
+		}
+	}()
+
+	received := make(chan alert.Alert, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a alert.Alert
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			t.Errorf("Failed to decode alert: %v", err)
+		}
+		received <- a
+	}))
+	defer webhook.Close()
+
+	cfg := config.DefaultAlertConfig()
+	cfg.Webhooks = []config.Webhook{{URL: webhook.URL}}
+	cfg.Rules = []config.AlertRule{{Name: "traversal", Type: config.AlertSignature, Patterns: []string{"/etc/passwd"}}}
+	alerts, err := alert.NewEngine(db, cfg)
+	if err != nil {
+		t.Fatalf("Failed to create alert engine: %v", err)
+	}
+	alerts.Start()
+	defer alerts.Stop()
+
+	router, err := NewWithOptions(db, Options{Alerts: alerts})
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/download.php?file=/etc/passwd", nil)
+	req.RemoteAddr = "192.0.2.7:4444"
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	select {
+	case a := <-received:
+		if a.Rule != "traversal" || a.Details["ip_address"] != "192.0.2.7" {
+			t.Errorf("Unexpected alert: %+v", a)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the alert")
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			// Ignore close errors in test cleanup
+		}
+	}()
+
+	metricsCfg := config.DefaultMetricsConfig()
+	metricsCfg.Token = "scrape-token"
+	rateLimit := config.DefaultRateLimitConfig()
+	router, err := NewWithOptions(db, Options{RateLimit: &rateLimit, Metrics: &metricsCfg})
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+
+	// Generate a logged request so the counters move
+	req := httptest.NewRequest(http.MethodGet, "/probe", nil)
+	req.RemoteAddr = "198.51.100.9:1234"
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	// Loopback scrapes without a token
+	req = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.RemoteAddr = "127.0.0.1:9999"
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 from loopback, got %d", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`silver_eureka_http_requests_total{route="catchall",status="404"}`,
+		`silver_eureka_requests_logged_total{result="ok"}`,
+		`silver_eureka_rate_limiter_entries{route="catchall"}`,
+		"silver_eureka_db_insert_duration_seconds_count",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected metrics output to contain %q", want)
+		}
+	}
+
+	// Remote scrapes need the token
+	req = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.RemoteAddr = "203.0.113.7:9999"
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 without token, got %d", rec.Code)
+	}
+	// The rejected scrape is a probe like any other
+	logs, err := db.GetLogs(0)
+	if err != nil {
+		t.Fatalf("Failed to get logs: %v", err)
+	}
+	if len(logs) != 2 || logs[0].URL != "/metrics" || logs[0].IPAddress != "203.0.113.7" {
+		t.Errorf("Expected the rejected scrape to be logged, got %+v", logs)
+	}
+
+	req.Header.Set("Authorization", "Bearer scrape-token")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status 200 with token, got %d", rec.Code)
+	}
+}
+
+func TestStatsAuth_StoredToken(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			// Ignore close errors in test cleanup
+		}
+	}()
+
+	// A stored API token enables authentication without a configured admin
+	token, hash, err := auth.NewToken()
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	if err := db.CreateAPIToken("ci", hash); err != nil {
+		t.Fatalf("Failed to store token: %v", err)
+	}
+	router := NewWithRateLimiter(db, "", "", false)
+
+	req := httptest.NewRequest(http.MethodGet, "/stats/summary", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without a token, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/stats/summary", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 with a valid token, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/login", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected web login to be available, got %d", rec.Code)
+	}
+}
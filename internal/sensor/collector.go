@@ -0,0 +1,268 @@
+package sensor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/config"
+	"github.com/dangogh/silver-eureka/internal/database"
+	"github.com/dangogh/silver-eureka/internal/metrics"
+)
+
+const (
+	// maxIngestBytes limits the body of one upload
+	maxIngestBytes = 32 << 20
+
+	// batchHeader carries the ID a sensor gives a batch and sends it again
+	// under on every retry
+	batchHeader = "X-Batch-ID"
+
+	// maxBatchID limits the length of a batch ID
+	maxBatchID = 64
+)
+
+// Collector serves the ingest API that sensors forward requests to and
+// stores them tagged with the sensor's ID
+type Collector struct {
+	db     *database.DB
+	cfg    config.CollectorConfig
+	tokens map[string]string // token to sensor ID
+	server *http.Server
+}
+
+// NewCollector creates the ingest API for cfg; Start serves it
+func NewCollector(db *database.DB, cfg config.CollectorConfig) (*Collector, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if !cfg.Enabled() {
+		return nil, fmt.Errorf("collector listen must be set")
+	}
+
+	c := &Collector{db: db, cfg: cfg, tokens: make(map[string]string, len(cfg.Tokens))}
+	for id, token := range cfg.Tokens {
+		c.tokens[token] = id
+	}
+	c.server = &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           c.Handler(),
+		ReadTimeout:       time.Minute,
+		WriteTimeout:      time.Minute,
+		IdleTimeout:       2 * time.Minute,
+		ReadHeaderTimeout: 5 * time.Second,
+		MaxHeaderBytes:    1 << 20,
+	}
+	if cfg.CertFile != "" {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+		if cfg.ClientCAFile != "" {
+			pem, err := os.ReadFile(cfg.ClientCAFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read collector client CA file: %w", err)
+			}
+			tlsConfig.ClientCAs = x509.NewCertPool()
+			if !tlsConfig.ClientCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in %s", cfg.ClientCAFile)
+			}
+			// Sensors with a token need no certificate
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load collector certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+		c.server.TLSConfig = tlsConfig
+	}
+	return c, nil
+}
+
+// Start serves the ingest API until Stop is called
+func (c *Collector) Start() error {
+	slog.Info("Collector ingest API starting", "listen", c.cfg.Listen, "tls", c.server.TLSConfig != nil)
+	var err error
+	if c.server.TLSConfig != nil {
+		// The certificate is already loaded in TLSConfig
+		err = c.server.ListenAndServeTLS("", "")
+	} else {
+		err = c.server.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Stop waits briefly for uploads in progress and closes the ingest API
+func (c *Collector) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	if err := c.server.Shutdown(ctx); err != nil {
+		slog.Error("Failed to shut down collector ingest API", "error", err)
+	}
+}
+
+// ingestResponse is the reply to an upload
+type ingestResponse struct {
+	Accepted  int  `json:"accepted"`
+	Rejected  int  `json:"rejected"`
+	Duplicate bool `json:"duplicate,omitempty"` // the batch was stored before
+}
+
+// Handler returns the ingest API: POST /ingest with one Record per line
+func (c *Collector) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ingest", c.handleIngest)
+	return mux
+}
+
+// handleIngest stores an upload from an authenticated sensor
+func (c *Collector) handleIngest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sensor, ok := c.authenticate(r)
+	if !ok {
+		slog.Warn("Unauthenticated upload to collector", "remote_addr", r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="collector"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	batch := r.Header.Get(batchHeader)
+	if !validBatchID(batch) {
+		slog.Warn("Rejected upload from sensor", "sensor", sensor, "error", "invalid batch ID")
+		http.Error(w, "Invalid "+batchHeader, http.StatusBadRequest)
+		return
+	}
+
+	reqs, rejected, err := decodeRecords(http.MaxBytesReader(w, r.Body, maxIngestBytes))
+	if err != nil {
+		status := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) || errors.Is(err, errTooManyRecords) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		slog.Warn("Rejected upload from sensor", "sensor", sensor, "error", err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	accepted, duplicate, err := c.db.LogSensorBatch(sensor, batch, reqs)
+	if err != nil {
+		slog.Error("Failed to store requests from sensor", "sensor", sensor, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if duplicate {
+		// A retry of a batch whose response the sensor didn't get
+		slog.Debug("Sensor sent a stored batch again", "sensor", sensor, "batch", batch)
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(ingestResponse{Accepted: int(accepted), Rejected: rejected, Duplicate: true}); err != nil {
+			slog.Error("Error encoding ingest response", "error", err)
+		}
+		return
+	}
+	metrics.CollectorRecords.WithLabelValues(sensor, "accepted").Add(float64(accepted))
+	if rejected > 0 {
+		metrics.CollectorRecords.WithLabelValues(sensor, "rejected").Add(float64(rejected))
+	}
+	slog.Debug("Stored requests from sensor", "sensor", sensor, "accepted", accepted, "rejected", rejected)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ingestResponse{Accepted: int(accepted), Rejected: rejected}); err != nil {
+		slog.Error("Error encoding ingest response", "error", err)
+	}
+}
+
+// authenticate returns the ID of the sensor making r: the common name of a
+// verified client certificate, or the ID the bearer token belongs to
+func (c *Collector) authenticate(r *http.Request) (string, bool) {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		if config.ValidSensorID(cn) {
+			return cn, true
+		}
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", false
+	}
+	for known, id := range c.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(known)) == 1 {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// validBatchID reports whether id is empty, for sensors that send none,
+// or printable ASCII of at most maxBatchID bytes
+func validBatchID(id string) bool {
+	if len(id) > maxBatchID {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// errTooManyRecords is returned for an upload of more records than a
+// sensor may send at once
+var errTooManyRecords = fmt.Errorf("more than %d records in one upload", config.MaxSensorBatch)
+
+// decodeRecords reads one Record per line, counting those without an
+// address or URL as rejected
+func decodeRecords(r io.Reader) ([]database.SensorRequest, int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), maxIngestBytes)
+	var reqs []database.SensorRequest
+	rejected := 0
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if len(reqs)+rejected >= config.MaxSensorBatch {
+			return nil, 0, errTooManyRecords
+		}
+		var rec Record
+		if err := json.Unmarshal(line, &rec); err != nil || rec.IPAddress == "" || rec.URL == "" {
+			rejected++
+			continue
+		}
+		reqs = append(reqs, database.SensorRequest{
+			Timestamp: rec.Time,
+			IPAddress: rec.IPAddress,
+			URL:       rec.URL,
+			Details: database.RequestDetails{
+				UserAgent: rec.UserAgent,
+				Headers:   rec.Headers,
+				Body:      rec.Body,
+				Listener:  rec.Listener,
+				LocalPort: rec.LocalPort,
+			},
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to read upload: %w", err)
+	}
+	return reqs, rejected, nil
+}
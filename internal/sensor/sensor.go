@@ -0,0 +1,310 @@
+// Package sensor lets many instances report to a central one. A Sensor
+// forwards each request logged by its instance to a collector's ingest
+// API, authenticated by a client certificate or a bearer token, spooling
+// them on disk while the collector can't be reached. A Collector serves
+// the ingest API and stores what it receives tagged with the ID each
+// sensor authenticated as.
+package sensor
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/config"
+	"github.com/dangogh/silver-eureka/internal/database"
+	"github.com/dangogh/silver-eureka/internal/metrics"
+)
+
+// queueSize is the number of records that can wait to be spooled; more
+// are dropped
+const queueSize = 1000
+
+// Record is a captured request as sent to the collector, one JSON object
+// per line
+type Record struct {
+	Time      time.Time   `json:"timestamp"`
+	IPAddress string      `json:"ip_address"`
+	URL       string      `json:"url"`
+	UserAgent string      `json:"user_agent,omitempty"`
+	Headers   http.Header `json:"headers,omitempty"`
+	Body      []byte      `json:"body,omitempty"`
+	Listener  string      `json:"listener,omitempty"`
+	LocalPort int         `json:"local_port,omitempty"`
+}
+
+// Sensor forwards logged requests to the collector. Every record goes
+// through the spool, so none is lost to a crash or restart; a single
+// goroutine appends to it and uploads it in batches.
+type Sensor struct {
+	cfg    config.SensorConfig
+	client *http.Client
+	spool  *spool
+	queue  chan Record
+
+	retryAt time.Time // no upload is tried before this after a failure
+
+	done chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+	now  func() time.Time
+}
+
+// New creates a Sensor with the spool left by an earlier run; Start begins
+// forwarding
+func New(cfg config.SensorConfig) (*Sensor, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if !cfg.Enabled() {
+		return nil, fmt.Errorf("sensor collector_url must be set")
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sensor CA file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load sensor certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	sp, err := openSpool(cfg.SpoolDir, int64(cfg.SpoolMaxMB)<<20)
+	if err != nil {
+		return nil, err
+	}
+	metrics.SensorSpoolBytes.Set(float64(sp.bytes()))
+
+	return &Sensor{
+		cfg:    cfg,
+		client: &http.Client{Transport: transport, Timeout: cfg.Timeout},
+		spool:  sp,
+		queue:  make(chan Record, queueSize),
+		done:   make(chan struct{}),
+		now:    time.Now,
+	}, nil
+}
+
+// Start forwards records until Stop is called
+func (s *Sensor) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.cfg.FlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case rec := <-s.queue:
+				s.push(rec)
+				if s.spool.pending >= s.cfg.BatchSize {
+					s.flush()
+				}
+			case <-ticker.C:
+				s.flush()
+			case <-s.done:
+				s.shutdown()
+				return
+			}
+		}
+	}()
+}
+
+// Stop spools the queued records, makes a last upload and closes the spool
+func (s *Sensor) Stop() {
+	s.once.Do(func() {
+		close(s.done)
+		s.wg.Wait()
+	})
+}
+
+// shutdown spools what is still queued and tries to upload it; what isn't
+// accepted waits in the spool for the next run
+func (s *Sensor) shutdown() {
+	s.spoolQueued()
+	s.flush()
+	if err := s.spool.close(); err != nil {
+		slog.Error("Failed to close sensor spool", "error", err)
+	}
+	s.client.CloseIdleConnections()
+}
+
+// Logged implements handler.Observer. It queues the request, dropping it
+// when the queue is full.
+func (s *Sensor) Logged(ctx context.Context, ipAddress, url string, d database.RequestDetails) {
+	rec := Record{
+		Time:      s.now(),
+		IPAddress: ipAddress,
+		URL:       url,
+		UserAgent: d.UserAgent,
+		Headers:   d.Headers,
+		Body:      d.Body,
+		Listener:  d.Listener,
+		LocalPort: d.LocalPort,
+	}
+	select {
+	case s.queue <- rec:
+	default:
+		metrics.SensorRecords.WithLabelValues("dropped").Inc()
+	}
+}
+
+// push appends rec to the spool
+func (s *Sensor) push(rec Record) {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		metrics.SensorRecords.WithLabelValues("dropped").Inc()
+		slog.Error("Failed to encode sensor record", "error", err)
+		return
+	}
+	if err := s.spool.push(line); err != nil {
+		metrics.SensorRecords.WithLabelValues("dropped").Inc()
+		if errors.Is(err, errSpoolFull) {
+			slog.Debug("Sensor spool full, record dropped")
+		} else {
+			slog.Error("Failed to spool sensor record", "error", err)
+		}
+		return
+	}
+	metrics.SensorSpoolBytes.Set(float64(s.spool.bytes()))
+}
+
+// spoolQueued moves the queued records to the spool without waiting
+func (s *Sensor) spoolQueued() {
+	for {
+		select {
+		case rec := <-s.queue:
+			s.push(rec)
+		default:
+			return
+		}
+	}
+}
+
+// flush uploads the spooled records in batches until none are left or an
+// upload fails, after which none is tried for the retry interval. Records
+// queued meanwhile are spooled between batches.
+func (s *Sensor) flush() {
+	if s.spool.pending == 0 || s.now().Before(s.retryAt) {
+		return
+	}
+	sent := 0
+	for s.spool.pending > 0 {
+		batch, records, size, err := s.spool.next(s.cfg.BatchSize)
+		if err == nil {
+			err = s.upload(batch, records)
+		}
+		if err != nil {
+			s.retryAt = s.now().Add(s.cfg.RetryInterval)
+			slog.Warn("Collector unavailable, spooling requests", "sent", sent, "spooled", s.spool.pending, "error", err)
+			return
+		}
+		if err := s.spool.commit(len(records), size); err != nil {
+			// A batch still saved is sent again under the same ID
+			s.retryAt = s.now().Add(s.cfg.RetryInterval)
+			slog.Error("Failed to update sensor spool", "error", err)
+			return
+		}
+		sent += len(records)
+		metrics.SensorSpoolBytes.Set(float64(s.spool.bytes()))
+		s.spoolQueued()
+	}
+	slog.Debug("Requests forwarded to collector", "records", sent)
+}
+
+// errRejected marks a batch the collector refused as malformed, which
+// sending again can't fix
+var errRejected = errors.New("collector rejected the batch")
+
+// upload sends one batch as NDJSON under its ID, so the collector stores
+// it only once however often it is sent. A batch the collector rejects as malformed is
+// dropped rather than retried forever.
+func (s *Sensor) upload(batch string, records [][]byte) error {
+	var body bytes.Buffer
+	valid := 0
+	for _, rec := range records {
+		// A record cut short by a crash is left out
+		if !json.Valid(rec) {
+			metrics.SensorRecords.WithLabelValues("dropped").Inc()
+			continue
+		}
+		body.Write(rec)
+		body.WriteByte('\n')
+		valid++
+	}
+	if valid == 0 {
+		return nil
+	}
+
+	err := s.post(batch, &body)
+	switch {
+	case errors.Is(err, errRejected):
+		metrics.SensorRecords.WithLabelValues("rejected").Add(float64(valid))
+		slog.Error("Collector rejected forwarded requests, dropping them", "records", valid, "error", err)
+		return nil
+	case err != nil:
+		return err
+	}
+	metrics.SensorRecords.WithLabelValues("sent").Add(float64(valid))
+	return nil
+}
+
+// post sends body to the collector's ingest URL
+func (s *Sensor) post(batch string, body io.Reader) error {
+	req, err := http.NewRequest(http.MethodPost, s.cfg.CollectorURL, body)
+	if err != nil {
+		return fmt.Errorf("invalid collector request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set(batchHeader, batch)
+	if s.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.cfg.Token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		// The URL is left out: it may carry credentials
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return err
+	}
+	defer func() {
+		if _, err := io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)); err != nil {
+			// Draining only lets the connection be reused
+		}
+		if err := resp.Body.Close(); err != nil {
+			// Nothing left to read
+		}
+	}()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusRequestEntityTooLarge:
+		return fmt.Errorf("%w: %s", errRejected, resp.Status)
+	default:
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+}
@@ -0,0 +1,471 @@
+package sensor
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/config"
+	"github.com/dangogh/silver-eureka/internal/database"
+)
+
+const testToken = "0123456789abcdef-edge"
+
+func setupTestDB(t *testing.T) *database.DB {
+	t.Helper()
+	db, err := database.New(filepath.Join(t.TempDir(), "collector.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			// Ignore close errors in test cleanup
+		}
+	})
+	return db
+}
+
+func testSensorConfig(t *testing.T, url string) config.SensorConfig {
+	t.Helper()
+	cfg := config.DefaultSensorConfig()
+	cfg.CollectorURL = url
+	cfg.Token = testToken
+	cfg.SpoolDir = t.TempDir()
+	cfg.FlushInterval = time.Hour // only Stop uploads
+	return cfg
+}
+
+func TestSpool(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openSpool(dir, 1<<20)
+	if err != nil {
+		t.Fatalf("Failed to open spool: %v", err)
+	}
+	for _, rec := range []string{`{"n":1}`, `{"n":2}`, `{"n":3}`} {
+		if err := s.push([]byte(rec)); err != nil {
+			t.Fatalf("Failed to push: %v", err)
+		}
+	}
+	records, size, err := s.peek(2)
+	if err != nil {
+		t.Fatalf("Failed to peek: %v", err)
+	}
+	if len(records) != 2 || string(records[1]) != `{"n":2}` {
+		t.Fatalf("Expected the first two records, got %q", records)
+	}
+	if err := s.commit(len(records), size); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	if err := s.close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	// A crash left half a record behind
+	f, err := os.OpenFile(filepath.Join(dir, spoolFile), os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatalf("Failed to open spool file: %v", err)
+	}
+	if _, err := f.WriteString(`{"n":`); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	s, err = openSpool(dir, 1<<20)
+	if err != nil {
+		t.Fatalf("Failed to reopen spool: %v", err)
+	}
+	defer func() {
+		if err := s.close(); err != nil {
+			t.Errorf("Failed to close: %v", err)
+		}
+	}()
+	if s.pending != 2 {
+		t.Errorf("Expected the third record and the fragment pending, got %d", s.pending)
+	}
+	if err := s.push([]byte(`{"n":4}`)); err != nil {
+		t.Fatalf("Failed to push: %v", err)
+	}
+	records, size, err = s.peek(10)
+	if err != nil {
+		t.Fatalf("Failed to peek: %v", err)
+	}
+	if len(records) != 3 || string(records[0]) != `{"n":3}` || string(records[2]) != `{"n":4}` {
+		t.Fatalf("Expected the rest with the fragment on its own line, got %q", records)
+	}
+	if err := s.commit(len(records), size); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	if s.bytes() != 0 || s.pending != 0 {
+		t.Errorf("Expected an empty spool, got %d bytes and %d records", s.bytes(), s.pending)
+	}
+	if _, err := os.Stat(filepath.Join(dir, offsetFile)); !os.IsNotExist(err) {
+		t.Errorf("Expected no offset file once empty, got %v", err)
+	}
+
+	full, err := openSpool(t.TempDir(), 10)
+	if err != nil {
+		t.Fatalf("Failed to open spool: %v", err)
+	}
+	defer func() {
+		if err := full.close(); err != nil {
+			t.Errorf("Failed to close: %v", err)
+		}
+	}()
+	if err := full.push([]byte(`{"too":"long"}`)); err != errSpoolFull {
+		t.Errorf("Expected errSpoolFull, got %v", err)
+	}
+}
+
+func TestSensorForwardsWithToken(t *testing.T) {
+	db := setupTestDB(t)
+	collector, err := NewCollector(db, config.CollectorConfig{
+		Listen: "127.0.0.1:0",
+		Tokens: map[string]string{"edge-1": testToken},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create collector: %v", err)
+	}
+	var down atomic.Bool
+	down.Store(true)
+	ingest := collector.Handler()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		ingest.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	// The collector is down: the requests wait in the spool
+	cfg := testSensorConfig(t, srv.URL+"/ingest")
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create sensor: %v", err)
+	}
+	s.Start()
+	s.Logged(context.Background(), "192.0.2.1", "/wp-login.php", database.RequestDetails{UserAgent: "scanner"})
+	s.Logged(context.Background(), "192.0.2.2", "/.env", database.RequestDetails{})
+	s.Stop()
+
+	logs, err := db.GetLogs(100)
+	if err != nil {
+		t.Fatalf("Failed to get logs: %v", err)
+	}
+	if len(logs) != 0 {
+		t.Fatalf("Expected nothing stored while the collector is down, got %+v", logs)
+	}
+
+	// The next run sends them once it's back
+	down.Store(false)
+	s, err = New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create sensor: %v", err)
+	}
+	s.Start()
+	s.Logged(context.Background(), "192.0.2.3", "/admin", database.RequestDetails{})
+	s.Stop()
+
+	logs, err = db.QueryLogs(database.LogFilter{Sensor: "edge-1", Ascending: true})
+	if err != nil {
+		t.Fatalf("Failed to query logs: %v", err)
+	}
+	if len(logs) != 3 {
+		t.Fatalf("Expected 3 requests from edge-1, got %+v", logs)
+	}
+	if logs[0].URL != "/wp-login.php" || logs[2].IPAddress != "192.0.2.3" {
+		t.Errorf("Unexpected logs: %+v", logs)
+	}
+
+	sensors, err := db.QuerySensorStats(database.StatsFilter{})
+	if err != nil {
+		t.Fatalf("QuerySensorStats failed: %v", err)
+	}
+	if len(sensors) != 1 || sensors[0].Sensor != "edge-1" || sensors[0].Count != 3 {
+		t.Errorf("Expected 3 requests from edge-1, got %+v", sensors)
+	}
+}
+
+func TestSensorRetriesLostResponse(t *testing.T) {
+	db := setupTestDB(t)
+	collector, err := NewCollector(db, config.CollectorConfig{
+		Listen: "127.0.0.1:0",
+		Tokens: map[string]string{"edge-1": testToken},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create collector: %v", err)
+	}
+	var mu sync.Mutex
+	var batches []string
+	lose := true
+	ingest := collector.Handler()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		batches = append(batches, r.Header.Get(batchHeader))
+		if lose {
+			// Stored, but the sensor never hears so
+			ingest.ServeHTTP(httptest.NewRecorder(), r)
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+			return
+		}
+		ingest.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	cfg := testSensorConfig(t, srv.URL+"/ingest")
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create sensor: %v", err)
+	}
+	s.Start()
+	s.Logged(context.Background(), "192.0.2.1", "/wp-login.php", database.RequestDetails{})
+	s.Logged(context.Background(), "192.0.2.2", "/.env", database.RequestDetails{})
+	s.Stop()
+
+	// The next run sends the batch again under the same ID, then a new one
+	mu.Lock()
+	lose = false
+	mu.Unlock()
+	s, err = New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create sensor: %v", err)
+	}
+	s.Start()
+	s.Logged(context.Background(), "192.0.2.3", "/admin", database.RequestDetails{})
+	s.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(batches) < 3 || batches[0] == "" || batches[1] != batches[0] || batches[len(batches)-1] == batches[0] {
+		t.Errorf("Expected the lost batch retried under its ID before a new one, got %q", batches)
+	}
+	logs, err := db.QueryLogs(database.LogFilter{Sensor: "edge-1", Ascending: true})
+	if err != nil {
+		t.Fatalf("Failed to query logs: %v", err)
+	}
+	if len(logs) != 3 {
+		t.Errorf("Expected each request stored once, got %+v", logs)
+	}
+}
+
+func TestCollectorIngest(t *testing.T) {
+	db := setupTestDB(t)
+	collector, err := NewCollector(db, config.CollectorConfig{
+		Listen: "127.0.0.1:0",
+		Tokens: map[string]string{"edge-1": testToken},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create collector: %v", err)
+	}
+	h := collector.Handler()
+
+	post := func(token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	body := `{"timestamp":"2026-03-02T12:00:00Z","ip_address":"192.0.2.1","url":"/a"}` + "\n" +
+		`{"ip_address":"192.0.2.2"}` + "\n" +
+		"not json\n"
+	for _, token := range []string{"", "wrong-token-0123456789"} {
+		if rec := post(token, body); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for token %q, got %d", token, rec.Code)
+		}
+	}
+
+	rec := post(testToken, body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := strings.TrimSpace(rec.Body.String()); got != `{"accepted":1,"rejected":2}` {
+		t.Errorf("Unexpected response %s", got)
+	}
+	logs, err := db.GetLogs(100)
+	if err != nil {
+		t.Fatalf("Failed to get logs: %v", err)
+	}
+	if len(logs) != 1 || logs[0].Sensor != "edge-1" || !logs[0].Timestamp.Equal(time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the valid request with its timestamp, got %+v", logs)
+	}
+
+	// A batch sent again is stored once
+	postBatch := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(`{"ip_address":"192.0.2.3","url":"/b"}`))
+		req.Header.Set("Authorization", "Bearer "+testToken)
+		req.Header.Set(batchHeader, id)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	if got := strings.TrimSpace(postBatch("batch-1").Body.String()); got != `{"accepted":1,"rejected":0}` {
+		t.Errorf("Unexpected response %s", got)
+	}
+	if got := strings.TrimSpace(postBatch("batch-1").Body.String()); got != `{"accepted":1,"rejected":0,"duplicate":true}` {
+		t.Errorf("Unexpected response to a retry %s", got)
+	}
+	if logs, err := db.GetLogs(100); err != nil || len(logs) != 2 {
+		t.Errorf("Expected the batch stored once, got %d logs (%v)", len(logs), err)
+	}
+	for _, id := range []string{"has space", strings.Repeat("x", maxBatchID+1)} {
+		if rec := postBatch(id); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for batch ID %q, got %d", id, rec.Code)
+		}
+	}
+
+	if rec := post(testToken, strings.Repeat(`{"ip_address":"192.0.2.1","url":"/a"}`+"\n", config.MaxSensorBatch+1)); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for an oversized batch, got %d", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/ingest", nil)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for GET, got %d", rec.Code)
+	}
+}
+
+// writeCert creates a certificate signed by parent, or self-signed without
+// one, and writes it and its key as PEM files in dir
+func writeCert(t *testing.T, dir, name string, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	return cert, key, certFile, keyFile
+}
+
+func TestSensorForwardsWithClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	ca, caKey, caFile, _ := writeCert(t, dir, "ca", &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	_, _, serverCert, serverKey := writeCert(t, dir, "server", &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "collector"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	_, _, clientCert, clientKey := writeCert(t, dir, "client", &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "edge-tls"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+
+	db := setupTestDB(t)
+	collector, err := NewCollector(db, config.CollectorConfig{
+		Listen:       "127.0.0.1:0",
+		CertFile:     serverCert,
+		KeyFile:      serverKey,
+		ClientCAFile: caFile,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create collector: %v", err)
+	}
+	srv := httptest.NewUnstartedServer(collector.Handler())
+	srv.TLS = collector.server.TLSConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	cfg := testSensorConfig(t, srv.URL+"/ingest")
+	cfg.Token = ""
+	cfg.CertFile, cfg.KeyFile, cfg.CAFile = clientCert, clientKey, caFile
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create sensor: %v", err)
+	}
+	s.Start()
+	s.Logged(context.Background(), "192.0.2.1", "/cgi-bin/luci", database.RequestDetails{})
+	s.Stop()
+
+	logs, err := db.GetLogs(100)
+	if err != nil {
+		t.Fatalf("Failed to get logs: %v", err)
+	}
+	if len(logs) != 1 || logs[0].Sensor != "edge-tls" {
+		t.Errorf("Expected the request tagged with the certificate's name, got %+v", logs)
+	}
+
+	// Without a certificate or token the collector refuses the upload
+	cfg.SpoolDir = t.TempDir()
+	cfg.CertFile, cfg.KeyFile, cfg.Token = "", "", "not-a-known-token"
+	s, err = New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create sensor: %v", err)
+	}
+	s.Start()
+	s.Logged(context.Background(), "192.0.2.9", "/x", database.RequestDetails{})
+	s.Stop()
+	if logs, err := db.GetLogs(100); err != nil || len(logs) != 1 {
+		t.Errorf("Expected the unauthenticated upload refused, got %d logs, %v", len(logs), err)
+	}
+	sp, err := openSpool(cfg.SpoolDir, 1<<20)
+	if err != nil {
+		t.Fatalf("Failed to open spool: %v", err)
+	}
+	defer func() {
+		if err := sp.close(); err != nil {
+			t.Errorf("Failed to close: %v", err)
+		}
+	}()
+	if sp.pending != 1 {
+		t.Errorf("Expected the refused request kept in the spool, got %d", sp.pending)
+	}
+}
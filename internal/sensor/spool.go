@@ -0,0 +1,216 @@
+package sensor
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// spoolFile holds the spooled records, one JSON object per line
+	spoolFile = "records"
+
+	// offsetFile holds the position in spoolFile of the first record the
+	// collector hasn't accepted, so a restart doesn't send the others again
+	offsetFile = "offset"
+
+	// batchFile holds the ID, record count and offset of the batch being
+	// sent, so a retry, even after a restart, sends the same records under
+	// the same ID
+	batchFile = "batch"
+)
+
+// errSpoolFull is returned by push when the spool is at its size limit
+var errSpoolFull = errors.New("sensor spool full")
+
+// spool is an on-disk queue of records for the collector. Records are
+// read in batches from the offset and the file is emptied once all of
+// them were accepted.
+type spool struct {
+	dir     string
+	max     int64
+	file    *os.File
+	size    int64 // bytes in the file
+	offset  int64 // bytes already accepted
+	pending int   // records after the offset
+
+	batch        string // ID of the batch being sent, or ""
+	batchRecords int    // records in it
+}
+
+// openSpool opens the spool in dir, creating it if needed, with the
+// records left by an earlier run
+func openSpool(dir string, max int64) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create spool dir: %w", err)
+	}
+	file, err := os.OpenFile(filepath.Join(dir, spoolFile), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spool: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		if err := file.Close(); err != nil {
+			// Already failing
+		}
+		return nil, fmt.Errorf("failed to open spool: %w", err)
+	}
+
+	s := &spool{dir: dir, max: max, file: file, size: info.Size()}
+	if data, err := os.ReadFile(filepath.Join(dir, offsetFile)); err == nil {
+		offset, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		if err == nil && offset >= 0 && offset <= s.size {
+			s.offset = offset
+		}
+	}
+	// A batch saved for another offset was accepted before a crash
+	if data, err := os.ReadFile(filepath.Join(dir, batchFile)); err == nil {
+		var id string
+		var n int
+		var offset int64
+		if _, err := fmt.Sscan(string(data), &id, &n, &offset); err == nil && n > 0 && offset == s.offset {
+			s.batch, s.batchRecords = id, n
+		}
+	}
+	// A record cut short by a crash is ended, so the next one isn't joined
+	// to it; the sensor skips it as invalid
+	if s.size > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, s.size-1); err == nil && last[0] != '\n' {
+			n, err := file.Write([]byte("\n"))
+			s.size += int64(n)
+			if err != nil {
+				if err := file.Close(); err != nil {
+					// Already failing
+				}
+				return nil, fmt.Errorf("failed to repair spool: %w", err)
+			}
+		}
+	}
+
+	// Count what an earlier run left
+	r := bufio.NewReader(io.NewSectionReader(file, s.offset, s.size-s.offset))
+	for {
+		_, err := r.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil {
+			break
+		}
+		s.pending++
+	}
+	return s, nil
+}
+
+// push appends a record, which must not contain a line break
+func (s *spool) push(record []byte) error {
+	if s.size+int64(len(record))+1 > s.max {
+		return errSpoolFull
+	}
+	n, err := s.file.Write(append(record, '\n'))
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write spool: %w", err)
+	}
+	s.pending++
+	return nil
+}
+
+// next returns the batch to send: the one sent last if it wasn't
+// accepted, or else up to n records from the offset under a new ID
+func (s *spool) next(n int) (string, [][]byte, int64, error) {
+	if s.batch != "" {
+		records, size, err := s.peek(s.batchRecords)
+		return s.batch, records, size, err
+	}
+	records, size, err := s.peek(n)
+	if err != nil || len(records) == 0 {
+		return "", records, size, err
+	}
+	id := rand.Text()
+	data := fmt.Sprintf("%s %d %d", id, len(records), s.offset)
+	if err := os.WriteFile(filepath.Join(s.dir, batchFile), []byte(data), 0o600); err != nil {
+		return "", nil, 0, fmt.Errorf("failed to save spool batch: %w", err)
+	}
+	s.batch, s.batchRecords = id, len(records)
+	return id, records, size, nil
+}
+
+// peek returns up to n records from the offset and the bytes they take up
+func (s *spool) peek(n int) ([][]byte, int64, error) {
+	r := bufio.NewReader(io.NewSectionReader(s.file, s.offset, s.size-s.offset))
+	var records [][]byte
+	var size int64
+	for len(records) < n {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read spool: %w", err)
+		}
+		size += int64(len(line))
+		records = append(records, bytes.TrimSuffix(line, []byte("\n")))
+	}
+	return records, size, nil
+}
+
+// commit marks the records of the batch, taking up size bytes, as
+// accepted, and empties the spool once none are left
+func (s *spool) commit(records int, size int64) error {
+	// The batch is forgotten first: a crash before the offset is saved
+	// only sends its records again under another ID
+	if err := os.Remove(filepath.Join(s.dir, batchFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to clear spool batch: %w", err)
+	}
+	s.batch, s.batchRecords = "", 0
+	s.offset += size
+	s.pending = max(s.pending-records, 0)
+	if s.offset >= s.size {
+		if err := s.file.Truncate(0); err != nil {
+			return s.saveOffset(fmt.Errorf("failed to empty spool: %w", err))
+		}
+		s.size, s.offset, s.pending = 0, 0, 0
+	}
+	return s.saveOffset(nil)
+}
+
+// saveOffset records the read position, returning cause or the error
+// saving it
+func (s *spool) saveOffset(cause error) error {
+	path := filepath.Join(s.dir, offsetFile)
+	var err error
+	if s.offset == 0 {
+		err = os.Remove(path)
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+	} else {
+		err = os.WriteFile(path, []byte(strconv.FormatInt(s.offset, 10)), 0o600)
+	}
+	if cause != nil {
+		return cause
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save spool offset: %w", err)
+	}
+	return nil
+}
+
+// bytes returns the size of the records waiting
+func (s *spool) bytes() int64 {
+	return s.size - s.offset
+}
+
+// close closes the spool file
+func (s *spool) close() error {
+	return s.file.Close()
+}
@@ -0,0 +1,76 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dangogh/silver-eureka/internal/config"
+)
+
+func TestFileSink_WritesNDJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events", "requests.ndjson")
+	s, err := newFileSink(config.SinkConfig{Name: "file", Path: path, MaxSizeMB: 1, MaxFiles: 2})
+	if err != nil {
+		t.Fatalf("newFileSink failed: %v", err)
+	}
+	events := testEvents(2)
+	if err := s.Write(context.Background(), events); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %q", data)
+	}
+	var got Event
+	if err := json.Unmarshal([]byte(lines[1]), &got); err != nil || got != events[1] {
+		t.Errorf("Line 2 = %q (%v), want %+v", lines[1], err, events[1])
+	}
+	if !strings.Contains(lines[0], `"timestamp":"2026-03-02T12:00:00Z"`) || strings.Contains(lines[0], "user_agent") {
+		t.Errorf("Unexpected line %q", lines[0])
+	}
+}
+
+func TestFileSink_Rotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "requests.ndjson")
+	s, err := newFileSink(config.SinkConfig{Name: "file", Path: path, MaxSizeMB: 1, MaxFiles: 2})
+	if err != nil {
+		t.Fatalf("newFileSink failed: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := s.Close(); err != nil {
+			t.Errorf("Close failed: %v", err)
+		}
+	})
+	// Each batch fills most of a file, so every write after the first
+	// rotates
+	s.maxSize = 150
+	for i := range 4 {
+		e := testEvents(1)
+		e[0].URL = "/" + strings.Repeat(string(rune('a'+i)), 40)
+		if err := s.Write(context.Background(), e); err != nil {
+			t.Fatalf("Write %d failed: %v", i, err)
+		}
+	}
+
+	for suffix, want := range map[string]string{"": "dddd", ".1": "cccc", ".2": "bbbb"} {
+		data, err := os.ReadFile(path + suffix)
+		if err != nil || !strings.Contains(string(data), want) {
+			t.Errorf("%s%s = %q (%v), want the %s batch", path, suffix, data, err, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected only 2 rotated files, got %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/dangogh/silver-eureka/internal/config"
+)
+
+// httpSink POSTs each batch to an endpoint as NDJSON
+type httpSink struct {
+	name    string
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+// newHTTPSink creates an HTTP sink; the batch context bounds each request
+func newHTTPSink(cfg config.SinkConfig) *httpSink {
+	return &httpSink{
+		name:    cfg.Name,
+		url:     cfg.URL,
+		headers: cfg.Headers,
+		client:  &http.Client{},
+	}
+}
+
+// Name implements EventSink
+func (s *httpSink) Name() string {
+	return s.name
+}
+
+// Write implements EventSink. Any response other than 2xx is a failure.
+func (s *httpSink) Write(ctx context.Context, events []Event) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return fmt.Errorf("failed to encode event: %w", err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, &body)
+	if err != nil {
+		return fmt.Errorf("invalid sink request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	for name, value := range s.headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		// The URL is left out: it may carry credentials
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return err
+	}
+	defer func() {
+		if _, err := io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)); err != nil {
+			// Draining only lets the connection be reused
+		}
+		if err := resp.Body.Close(); err != nil {
+			// Nothing left to read
+		}
+	}()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Close implements EventSink
+func (s *httpSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
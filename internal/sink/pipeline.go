@@ -0,0 +1,224 @@
+package sink
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/config"
+	"github.com/dangogh/silver-eureka/internal/metrics"
+)
+
+// Pipeline sends each published event to every sink whose filter it
+// passes
+type Pipeline struct {
+	runners []*runner
+	// anonymize rewrites client addresses before events are queued
+	anonymize func(ip string) string
+
+	done chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+	now  func() time.Time
+}
+
+// runner batches and writes the events for one sink
+type runner struct {
+	sink   EventSink
+	cfg    config.SinkConfig
+	filter filter
+	queue  chan Event
+}
+
+// NewPipeline creates a Pipeline with no sinks
+func NewPipeline() *Pipeline {
+	return &Pipeline{
+		done: make(chan struct{}),
+		now:  time.Now,
+	}
+}
+
+// FromConfig creates a Pipeline with the sinks in cfg
+func FromConfig(cfg config.EventsConfig) (*Pipeline, error) {
+	p := NewPipeline()
+	for _, sc := range cfg.Sinks {
+		sc = cfg.Sink(sc)
+		s, err := New(sc)
+		if err != nil {
+			p.closeSinks()
+			return nil, err
+		}
+		if err := p.Add(s, sc); err != nil {
+			p.closeSinks()
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Add sends events to s with the batching, retry and filter settings in
+// cfg. Sinks must be added before Start.
+func (p *Pipeline) Add(s EventSink, cfg config.SinkConfig) error {
+	f, err := newFilter(cfg.Filter)
+	if err != nil {
+		return err
+	}
+	p.runners = append(p.runners, &runner{
+		sink:   s,
+		cfg:    cfg,
+		filter: f,
+		queue:  make(chan Event, cfg.QueueSize),
+	})
+	return nil
+}
+
+// Len returns the number of sinks
+func (p *Pipeline) Len() int {
+	return len(p.runners)
+}
+
+// Start runs a writer for each sink until Stop is called
+func (p *Pipeline) Start() {
+	for _, r := range p.runners {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			r.run(p.done)
+		}()
+	}
+}
+
+// Stop writes the events still queued, with no retries, and closes the
+// sinks
+func (p *Pipeline) Stop() {
+	p.once.Do(func() {
+		close(p.done)
+		p.wg.Wait()
+		p.closeSinks()
+	})
+}
+
+// closeSinks closes every sink
+func (p *Pipeline) closeSinks() {
+	for _, r := range p.runners {
+		if err := r.sink.Close(); err != nil {
+			slog.Error("Failed to close event sink", "sink", r.sink.Name(), "error", err)
+		}
+	}
+}
+
+// AnonymizeWith sets how client addresses are rewritten before events
+// reach the sinks, after the filters have seen them. It must be called
+// before Start.
+func (p *Pipeline) AnonymizeWith(fn func(ip string) string) {
+	p.anonymize = fn
+}
+
+// Publish queues e for each sink it passes the filter of, dropping it for
+// sinks whose queue is full
+func (p *Pipeline) Publish(e Event) {
+	shown := e
+	anonymized := p.anonymize == nil
+	for _, r := range p.runners {
+		if !r.filter.match(e) {
+			continue
+		}
+		if !anonymized {
+			shown.IPAddress, anonymized = p.anonymize(e.IPAddress), true
+		}
+		select {
+		case r.queue <- shown:
+		default:
+			metrics.SinkEvents.WithLabelValues(r.sink.Name(), "dropped").Inc()
+		}
+	}
+}
+
+// Middleware publishes an event for each request
+func (p *Pipeline) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p.Publish(NewEvent(r, p.now()))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// run writes batches when they fill or every flush interval, until done
+// is closed
+func (r *runner) run(done <-chan struct{}) {
+	ticker := time.NewTicker(r.cfg.FlushInterval)
+	defer ticker.Stop()
+	batch := make([]Event, 0, r.cfg.BatchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			r.write(batch, done)
+			batch = batch[:0]
+		}
+	}
+
+	for {
+		select {
+		case e := <-r.queue:
+			batch = append(batch, e)
+			if len(batch) >= r.cfg.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-done:
+			for {
+				select {
+				case e := <-r.queue:
+					batch = append(batch, e)
+					if len(batch) >= r.cfg.BatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// write delivers batch, retrying with doubling backoff. Once done is
+// closed failures are not retried.
+func (r *runner) write(batch []Event, done <-chan struct{}) {
+	name := r.sink.Name()
+	backoff := r.cfg.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), r.cfg.Timeout)
+		err := r.sink.Write(ctx, batch)
+		cancel()
+		if err == nil {
+			metrics.SinkEvents.WithLabelValues(name, "sent").Add(float64(len(batch)))
+			return
+		}
+
+		retry := attempt < r.cfg.Retries
+		select {
+		case <-done:
+			retry = false
+		default:
+		}
+		if !retry {
+			metrics.SinkEvents.WithLabelValues(name, "failed").Add(float64(len(batch)))
+			slog.Error("Event sink write failed", "sink", name, "events", len(batch), "attempts", attempt+1, "error", err)
+			return
+		}
+		metrics.SinkEvents.WithLabelValues(name, "retried").Add(float64(len(batch)))
+		slog.Warn("Event sink write failed, retrying", "sink", name, "in", backoff.String(), "error", err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-done:
+			timer.Stop()
+		}
+		backoff *= 2
+	}
+}
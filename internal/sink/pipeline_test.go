@@ -0,0 +1,317 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/config"
+)
+
+// recordingSink keeps the batches written to it, failing the first fail
+// writes
+type recordingSink struct {
+	mu      sync.Mutex
+	fail    int
+	calls   int
+	batches [][]Event
+	closed  bool
+}
+
+func (s *recordingSink) Name() string { return "recording" }
+
+func (s *recordingSink) Write(ctx context.Context, events []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if s.calls <= s.fail {
+		return errors.New("unavailable")
+	}
+	s.batches = append(s.batches, append([]Event(nil), events...))
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *recordingSink) events() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var all []Event
+	for _, b := range s.batches {
+		all = append(all, b...)
+	}
+	return all
+}
+
+func testSinkConfig() config.SinkConfig {
+	events := config.DefaultEventsConfig()
+	events.FlushInterval = 10 * time.Millisecond
+	events.RetryBackoff = time.Millisecond
+	return events.Sink(config.SinkConfig{Name: "test"})
+}
+
+func testEvents(n int) []Event {
+	events := make([]Event, n)
+	for i := range events {
+		events[i] = Event{
+			Time:      time.Date(2026, 3, 2, 12, 0, i, 0, time.UTC),
+			IPAddress: "192.0.2.1",
+			Method:    http.MethodGet,
+			URL:       "/" + string(rune('a'+i)),
+		}
+	}
+	return events
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	f, err := newFilter(config.SinkFilter{
+		Methods:    []string{"post"},
+		Paths:      []string{"/wp-", "/admin"},
+		ExcludeIPs: []string{"10.0.0.0/8", "2001:db8::/32"},
+	})
+	if err != nil {
+		t.Fatalf("newFilter failed: %v", err)
+	}
+	for _, tc := range []struct {
+		e    Event
+		want bool
+	}{
+		{Event{Method: "POST", URL: "/wp-login.php", IPAddress: "192.0.2.1"}, true},
+		{Event{Method: "POST", URL: "/admin?x=/wp-", IPAddress: "::ffff:192.0.2.1"}, true},
+		{Event{Method: "GET", URL: "/wp-login.php", IPAddress: "192.0.2.1"}, false},
+		{Event{Method: "POST", URL: "/index.php?p=/wp-", IPAddress: "192.0.2.1"}, false},
+		{Event{Method: "POST", URL: "/admin", IPAddress: "10.1.2.3"}, false},
+		{Event{Method: "POST", URL: "/admin", IPAddress: "::ffff:10.1.2.3"}, false},
+		{Event{Method: "POST", URL: "/admin", IPAddress: "2001:db8::1"}, false},
+	} {
+		if got := f.match(tc.e); got != tc.want {
+			t.Errorf("match(%+v) = %v, want %v", tc.e, got, tc.want)
+		}
+	}
+
+	if _, err := newFilter(config.SinkFilter{ExcludeIPs: []string{"10.0.0.1"}}); err == nil {
+		t.Error("Expected an error for an address that is not a CIDR")
+	}
+}
+
+func TestPipeline_BatchesAndFilters(t *testing.T) {
+	all, posts := &recordingSink{}, &recordingSink{}
+	p := NewPipeline()
+	cfg := testSinkConfig()
+	cfg.BatchSize = 2
+	cfg.FlushInterval = time.Hour
+	if err := p.Add(all, cfg); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	cfg.Filter.Methods = []string{"POST"}
+	if err := p.Add(posts, cfg); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	p.Start()
+
+	events := testEvents(5)
+	events[4].Method = http.MethodPost
+	for _, e := range events {
+		p.Publish(e)
+	}
+	// Full batches go out without waiting for the flush interval
+	waitFor(t, "two batches", func() bool { return len(all.events()) == 4 })
+
+	// Stop writes the partial batch
+	p.Stop()
+	if got := all.events(); len(got) != 5 || got[4].URL != "/e" {
+		t.Errorf("Expected all 5 events in order, got %+v", got)
+	}
+	if got := posts.events(); len(got) != 1 || got[0].Method != http.MethodPost {
+		t.Errorf("Expected only the POST, got %+v", got)
+	}
+	if !all.closed || !posts.closed {
+		t.Error("Expected Stop to close the sinks")
+	}
+}
+
+func TestPipeline_Anonymizes(t *testing.T) {
+	all, external := &recordingSink{}, &recordingSink{}
+	p := NewPipeline()
+	p.AnonymizeWith(func(ip string) string { return "anon:" + ip })
+	cfg := testSinkConfig()
+	if err := p.Add(all, cfg); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	cfg.Filter.ExcludeIPs = []string{"10.0.0.0/8"}
+	if err := p.Add(external, cfg); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	p.Start()
+
+	p.Publish(Event{Method: http.MethodGet, URL: "/a", IPAddress: "10.1.2.3"})
+	p.Publish(Event{Method: http.MethodGet, URL: "/b", IPAddress: "192.0.2.1"})
+	p.Stop()
+
+	// The filters see the real address, the sinks only its replacement
+	if got := all.events(); len(got) != 2 || got[0].IPAddress != "anon:10.1.2.3" || got[1].IPAddress != "anon:192.0.2.1" {
+		t.Errorf("Expected both events anonymized, got %+v", got)
+	}
+	if got := external.events(); len(got) != 1 || got[0].IPAddress != "anon:192.0.2.1" {
+		t.Errorf("Expected only the external address, anonymized, got %+v", got)
+	}
+}
+
+func TestPipeline_Retries(t *testing.T) {
+	s := &recordingSink{fail: 2}
+	p := NewPipeline()
+	if err := p.Add(s, testSinkConfig()); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	p.Start()
+	t.Cleanup(p.Stop)
+
+	for _, e := range testEvents(3) {
+		p.Publish(e)
+	}
+	waitFor(t, "the batch to be retried", func() bool { return len(s.events()) == 3 })
+}
+
+func TestPipeline_GivesUpAfterRetries(t *testing.T) {
+	s := &recordingSink{fail: 100}
+	p := NewPipeline()
+	cfg := testSinkConfig()
+	cfg.Retries = 2
+	if err := p.Add(s, cfg); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	p.Start()
+	p.Publish(testEvents(1)[0])
+	waitFor(t, "three attempts", func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.calls == 3
+	})
+	p.Stop()
+	if s.calls != 3 {
+		t.Errorf("Expected 3 attempts, got %d", s.calls)
+	}
+}
+
+func TestPipeline_DropsWhenQueueFull(t *testing.T) {
+	s := &recordingSink{}
+	p := NewPipeline()
+	cfg := testSinkConfig()
+	cfg.QueueSize = 2
+	if err := p.Add(s, cfg); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	// Not started, so nothing leaves the queue
+	for _, e := range testEvents(5) {
+		p.Publish(e)
+	}
+	p.Start()
+	p.Stop()
+	if got := s.events(); len(got) != 2 {
+		t.Errorf("Expected the 2 queued events, got %d", len(got))
+	}
+}
+
+func TestPipeline_MiddlewareToHTTP(t *testing.T) {
+	var mu sync.Mutex
+	var received []Event
+	var header string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/x-ndjson" {
+			t.Errorf("Unexpected content type %q", r.Header.Get("Content-Type"))
+		}
+		scanner := bufio.NewScanner(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		header = r.Header.Get("Authorization")
+		for scanner.Scan() {
+			var e Event
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				t.Errorf("Invalid line %q: %v", scanner.Text(), err)
+			}
+			received = append(received, e)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := testSinkConfig()
+	cfg.Type = config.SinkHTTP
+	cfg.URL = srv.URL
+	cfg.Headers = map[string]string{"Authorization": "Bearer secret"}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	p := NewPipeline()
+	p.now = func() time.Time { return time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC) }
+	if err := p.Add(s, cfg); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	p.Start()
+	t.Cleanup(p.Stop)
+
+	handler := p.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/.env?x=1", nil)
+	req.RemoteAddr = "10.0.0.2:41000" // a trusted proxy
+	req.Header.Set("X-Forwarded-For", "198.51.100.7, 10.0.0.1")
+	req.Header.Set("User-Agent", "scanner")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	waitFor(t, "the event to be posted", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == 1
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	want := Event{
+		Time:      time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC),
+		IPAddress: "198.51.100.7",
+		Method:    http.MethodGet,
+		URL:       "/.env?x=1",
+		Host:      "example.com",
+		UserAgent: "scanner",
+	}
+	if received[0] != want {
+		t.Errorf("Got %+v, want %+v", received[0], want)
+	}
+	if header != "Bearer secret" {
+		t.Errorf("Expected the configured header, got %q", header)
+	}
+}
+
+func TestHTTPSink_ErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(srv.Close)
+
+	s := newHTTPSink(config.SinkConfig{Name: "http", URL: srv.URL})
+	if err := s.Write(context.Background(), testEvents(1)); err == nil {
+		t.Error("Expected an error for a 503 response")
+	}
+}
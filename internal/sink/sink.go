@@ -0,0 +1,125 @@
+// Package sink fans captured requests out to destinations besides the
+// database: rotating NDJSON files and HTTP endpoints. Each sink has its own queue, batching, filter and retries, so
+// a slow or failing destination holds up no other.
+package sink
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/clientip"
+	"github.com/dangogh/silver-eureka/internal/config"
+	"github.com/dangogh/silver-eureka/internal/listener"
+)
+
+// Event is a captured request as sent to sinks
+type Event struct {
+	Time      time.Time `json:"timestamp"`
+	IPAddress string    `json:"ip_address"`
+	Method    string    `json:"method"`
+	URL       string    `json:"url"`
+	Host      string    `json:"host,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Listener  string    `json:"listener,omitempty"` // label of the listener it arrived on
+	Port      int       `json:"port,omitempty"`     // local port it arrived on
+}
+
+// NewEvent returns the event for r, received at t
+func NewEvent(r *http.Request, t time.Time) Event {
+	l := listener.FromContext(r.Context())
+	return Event{
+		Time:      t,
+		IPAddress: clientip.FromRequest(r),
+		Method:    r.Method,
+		URL:       r.URL.String(),
+		Host:      r.Host,
+		UserAgent: r.UserAgent(),
+		Listener:  l.Label,
+		Port:      l.Port,
+	}
+}
+
+// EventSink is a destination for events
+type EventSink interface {
+	// Name identifies the sink in logs and metrics
+	Name() string
+	// Write delivers a batch in order. It must not keep events after it
+	// returns; a batch that fails is written again whole.
+	Write(ctx context.Context, events []Event) error
+	// Close releases the sink's connections and files
+	Close() error
+}
+
+// New creates the sink described by cfg
+func New(cfg config.SinkConfig) (EventSink, error) {
+	switch cfg.Type {
+	case config.SinkFile:
+		return newFileSink(cfg)
+	case config.SinkHTTP:
+		return newHTTPSink(cfg), nil
+	default:
+		return nil, fmt.Errorf("unknown sink type %q", cfg.Type)
+	}
+}
+
+// filter selects the events a sink receives
+type filter struct {
+	methods map[string]bool
+	paths   []string
+	exclude []netip.Prefix
+}
+
+// newFilter compiles f
+func newFilter(f config.SinkFilter) (filter, error) {
+	var c filter
+	if len(f.Methods) > 0 {
+		c.methods = make(map[string]bool, len(f.Methods))
+		for _, m := range f.Methods {
+			c.methods[strings.ToUpper(m)] = true
+		}
+	}
+	c.paths = f.Paths
+	for _, cidr := range f.ExcludeIPs {
+		p, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return filter{}, fmt.Errorf("invalid CIDR %q in exclude_ips", cidr)
+		}
+		c.exclude = append(c.exclude, p.Masked())
+	}
+	return c, nil
+}
+
+// match reports whether e passes the filter
+func (f filter) match(e Event) bool {
+	if f.methods != nil && !f.methods[e.Method] {
+		return false
+	}
+	if len(f.paths) > 0 {
+		path, _, _ := strings.Cut(e.URL, "?")
+		matched := false
+		for _, prefix := range f.paths {
+			if strings.HasPrefix(path, prefix) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(f.exclude) > 0 {
+		if addr, err := netip.ParseAddr(e.IPAddress); err == nil {
+			addr = addr.Unmap()
+			for _, p := range f.exclude {
+				if p.Contains(addr) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,131 @@
+package stats
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/config"
+	"github.com/dangogh/silver-eureka/internal/importer"
+)
+
+// MaxImportBytes limits the size of an uploaded log, compressed or not
+const MaxImportBytes = 1 << 30
+
+// importProgressInterval is the least time between progress log lines
+const importProgressInterval = 10 * time.Second
+
+// HandleImport imports an access log or export uploaded as the request body,
+// or as the "file" field of a multipart form. The format, sensor and
+// dry_run parameters are those of the import command. It responds with
+// the import report.
+func (h *Handler) HandleImport(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("Import requested",
+		"method", r.Method,
+		"remote_addr", r.RemoteAddr,
+	)
+
+	q := r.URL.Query()
+	opts := importer.Options{Format: q.Get("format"), Sensor: q.Get("sensor")}
+	if opts.Format != "" && !slices.Contains(importer.Formats, opts.Format) {
+		writeBadRequest(w, fmt.Errorf("invalid format %q (want one of %v)", opts.Format, importer.Formats))
+		return
+	}
+	if opts.Sensor != "" && !config.ValidSensorID(opts.Sensor) {
+		writeBadRequest(w, fmt.Errorf("invalid sensor %q", opts.Sensor))
+		return
+	}
+	if v := q.Get("dry_run"); v != "" {
+		dryRun, err := strconv.ParseBool(v)
+		if err != nil {
+			writeBadRequest(w, fmt.Errorf("invalid dry_run %q", v))
+			return
+		}
+		opts.DryRun = dryRun
+	}
+
+	// A large log takes longer than the server's timeouts allow
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(time.Time{}); err != nil {
+		slog.Debug("Could not lift the read deadline for an import", "error", err)
+	}
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		slog.Debug("Could not lift the write deadline for an import", "error", err)
+	}
+
+	body, err := importBody(http.MaxBytesReader(w, r.Body, MaxImportBytes), r.Header.Get("Content-Type"))
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	last := time.Now()
+	opts.Progress = func(report importer.Report) {
+		if time.Since(last) >= importProgressInterval {
+			last = time.Now()
+			slog.Info("Import progress", "records", report.Records, "imported", report.Imported,
+				"duplicates", report.Duplicates, "invalid", report.Invalid, "dry_run", report.DryRun)
+		}
+	}
+	report, err := importer.Run(r.Context(), h.db, body, opts)
+	if err != nil {
+		status := http.StatusInternalServerError
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			status = http.StatusRequestEntityTooLarge
+		case errors.Is(err, importer.ErrInvalidInput):
+			status = http.StatusBadRequest
+		}
+		slog.Error("Import failed", "error", err, "records", report.Records, "imported", report.Imported)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		// The batches already imported stay, so the report goes with the error
+		if encodeErr := json.NewEncoder(w).Encode(map[string]any{"error": "import failed", "details": err.Error(), "report": report}); encodeErr != nil {
+			// Response already started
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		slog.Error("Failed to encode import report", "error", err)
+	}
+
+	slog.Info("Import completed", "format", report.Format, "records", report.Records, "imported", report.Imported,
+		"duplicates", report.Duplicates, "invalid", report.Invalid, "dry_run", report.DryRun)
+}
+
+// importBody returns the uploaded log: the "file" part of a multipart form,
+// or else the whole body
+func importBody(body io.Reader, contentType string) (io.Reader, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "multipart/form-data" {
+		return body, nil
+	}
+	if params["boundary"] == "" {
+		return nil, errors.New("multipart form without a boundary")
+	}
+	mr := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New(`no "file" field in the form`)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid multipart form: %w", err)
+		}
+		if part.FormName() == "file" {
+			return part, nil
+		}
+	}
+}
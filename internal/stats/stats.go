@@ -2,8 +2,11 @@ package stats
 
 import (
 	"encoding/json"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/dangogh/silver-eureka/internal/database"
 )
@@ -25,7 +28,21 @@ func (h *Handler) HandleEndpointStats(w http.ResponseWriter, r *http.Request) {
 		"remote_addr", r.RemoteAddr,
 	)
 
-	stats, err := h.db.GetEndpointStats()
+	filter, err := parseFilter(r)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	groupBy := r.URL.Query().Get("group_by")
+	if groupBy == "" {
+		groupBy = database.GroupByURL
+	} else if !database.ValidGrouping(groupBy) {
+		writeBadRequest(w, fmt.Errorf("invalid group_by %q (want url, path, query or normalized)", groupBy))
+		return
+	}
+
+	stats, err := h.db.QueryEndpointStatsBy(filter, groupBy)
 	if err != nil {
 		slog.Error("Failed to get endpoint stats", "error", err)
 		w.Header().Set("Content-Type", "application/json")
@@ -53,7 +70,24 @@ func (h *Handler) HandleSourceStats(w http.ResponseWriter, r *http.Request) {
 		"remote_addr", r.RemoteAddr,
 	)
 
-	stats, err := h.db.GetSourceStats()
+	filter, err := parseFilter(r)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	v4Bits, v6Bits, err := parsePrefixLengths(r)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	var stats []database.SourceStats
+	if v4Bits > 0 || v6Bits > 0 {
+		stats, err = h.db.QueryPrefixStats(filter, v4Bits, v6Bits)
+	} else {
+		stats, err = h.db.QuerySourceStats(filter)
+	}
 	if err != nil {
 		slog.Error("Failed to get source stats", "error", err)
 		w.Header().Set("Content-Type", "application/json")
@@ -81,7 +115,13 @@ func (h *Handler) HandleSummary(w http.ResponseWriter, r *http.Request) {
 		"remote_addr", r.RemoteAddr,
 	)
 
-	summary, err := h.db.GetSummary()
+	filter, err := parseFilter(r)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	summary, err := h.db.QuerySummary(filter)
 	if err != nil {
 		slog.Error("Failed to get summary stats", "error", err)
 		w.Header().Set("Content-Type", "application/json")
@@ -106,14 +146,216 @@ func (h *Handler) HandleSummary(w http.ResponseWriter, r *http.Request) {
 	)
 }
 
-// HandleDownload returns all request logs as JSON
+// parseFilter reads the optional since and until query parameters, each an
+// RFC 3339 time or a YYYY-MM-DD date in UTC, ip, an address or CIDR
+// prefix, sensor, a sensor ID, listener, a listener label, and port, the
+// local port requests arrived on
+func parseFilter(r *http.Request) (database.StatsFilter, error) {
+	var f database.StatsFilter
+	f.Sensor = r.URL.Query().Get("sensor")
+	f.Listener = r.URL.Query().Get("listener")
+	if value := r.URL.Query().Get("port"); value != "" {
+		port, err := strconv.Atoi(value)
+		if err != nil || port < 1 || port > 65535 {
+			return f, fmt.Errorf("invalid port %q (want 1 to 65535)", value)
+		}
+		f.Port = port
+	}
+	if value := r.URL.Query().Get("ip"); value != "" {
+		prefix, err := database.ParseIPFilter(value)
+		if err != nil {
+			return f, err
+		}
+		f.IP = prefix
+	}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"since", &f.Since}, {"until", &f.Until}} {
+		value := r.URL.Query().Get(p.name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			if t, err = time.Parse(time.DateOnly, value); err != nil {
+				return f, fmt.Errorf("invalid %s %q (want RFC 3339 or YYYY-MM-DD)", p.name, value)
+			}
+		}
+		*p.dst = t
+	}
+	if !f.Since.IsZero() && !f.Until.IsZero() && !f.Since.Before(f.Until) {
+		return f, fmt.Errorf("since must be before until")
+	}
+	return f, nil
+}
+
+// HandleSensorStats returns statistics grouped by the sensor that captured
+// the requests
+func (h *Handler) HandleSensorStats(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("Sensor stats requested",
+		"method", r.Method,
+		"remote_addr", r.RemoteAddr,
+	)
+
+	filter, err := parseFilter(r)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	stats, err := h.db.QuerySensorStats(filter)
+	if err != nil {
+		slog.Error("Failed to get sensor stats", "error", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		if encodeErr := json.NewEncoder(w).Encode(map[string]string{"error": "failed to retrieve sensor statistics", "details": err.Error()}); encodeErr != nil {
+			// Response already started
+		}
+		return
+	}
+	if stats == nil {
+		stats = []database.SensorStats{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		slog.Error("Failed to encode sensor stats", "error", err)
+	}
+
+	slog.Info("Sensor stats retrieved", "count", len(stats))
+}
+
+// HandleListenerStats returns statistics grouped by the listener and local
+// port the requests arrived on
+func (h *Handler) HandleListenerStats(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("Listener stats requested",
+		"method", r.Method,
+		"remote_addr", r.RemoteAddr,
+	)
+
+	filter, err := parseFilter(r)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	stats, err := h.db.QueryListenerStats(filter)
+	if err != nil {
+		slog.Error("Failed to get listener stats", "error", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		if encodeErr := json.NewEncoder(w).Encode(map[string]string{"error": "failed to retrieve listener statistics", "details": err.Error()}); encodeErr != nil {
+			// Response already started
+		}
+		return
+	}
+	if stats == nil {
+		stats = []database.ListenerStats{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		slog.Error("Failed to encode listener stats", "error", err)
+	}
+
+	slog.Info("Listener stats retrieved", "count", len(stats))
+}
+
+// HandleConnectionStats returns the connections to banner listeners
+// grouped by protocol, listener, port and payload
+func (h *Handler) HandleConnectionStats(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("Connection stats requested",
+		"method", r.Method,
+		"remote_addr", r.RemoteAddr,
+	)
+
+	filter, err := parseFilter(r)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	stats, err := h.db.QueryConnectionStats(filter)
+	if err != nil {
+		slog.Error("Failed to get connection stats", "error", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		if encodeErr := json.NewEncoder(w).Encode(map[string]string{"error": "failed to retrieve connection statistics", "details": err.Error()}); encodeErr != nil {
+			// Response already started
+		}
+		return
+	}
+	if stats == nil {
+		stats = []database.ConnectionStats{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		slog.Error("Failed to encode connection stats", "error", err)
+	}
+
+	slog.Info("Connection stats retrieved", "count", len(stats))
+}
+
+// parsePrefixLengths reads the optional prefix_v4 and prefix_v6 query
+// parameters that group source statistics by network
+func parsePrefixLengths(r *http.Request) (int, int, error) {
+	var bits [2]int
+	for i, p := range []struct {
+		name string
+		max  int
+	}{{"prefix_v4", 32}, {"prefix_v6", 128}} {
+		value := r.URL.Query().Get(p.name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 || n > p.max {
+			return 0, 0, fmt.Errorf("invalid %s %q (want 0-%d)", p.name, value, p.max)
+		}
+		bits[i] = n
+	}
+	return bits[0], bits[1], nil
+}
+
+// writeBadRequest reports an invalid query parameter
+func writeBadRequest(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	if encodeErr := json.NewEncoder(w).Encode(map[string]string{"error": err.Error()}); encodeErr != nil {
+		// Response already started
+	}
+}
+
+// HandleDownload returns all request logs as JSON, or those from the
+// address or CIDR prefix given as ip and the sensor given as sensor
 func (h *Handler) HandleDownload(w http.ResponseWriter, r *http.Request) {
 	slog.Debug("Download requested",
 		"method", r.Method,
 		"remote_addr", r.RemoteAddr,
 	)
 
-	logs, err := h.db.GetAllLogs()
+	var logs []database.RequestLog
+	var err error
+	ip, sensor := r.URL.Query().Get("ip"), r.URL.Query().Get("sensor")
+	if ip != "" || sensor != "" {
+		if ip != "" {
+			if _, err := database.ParseIPFilter(ip); err != nil {
+				writeBadRequest(w, err)
+				return
+			}
+		}
+		logs, err = h.db.QueryLogs(database.LogFilter{IP: ip, Sensor: sensor, Limit: database.MaxDownloadLogs})
+	} else {
+		logs, err = h.db.GetAllLogs()
+	}
 	if err != nil {
 		slog.Error("Failed to get all logs", "error", err)
 		w.Header().Set("Content-Type", "application/json")
@@ -134,3 +376,64 @@ func (h *Handler) HandleDownload(w http.ResponseWriter, r *http.Request) {
 
 	slog.Info("Download completed", "count", len(logs))
 }
+
+// HandleSearch returns the newest request logs whose URL, user agent,
+// headers or body contain every word of q, with highlighted snippets. It
+// takes the filters of the statistics, and limit.
+func (h *Handler) HandleSearch(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("Search requested",
+		"method", r.Method,
+		"remote_addr", r.RemoteAddr,
+	)
+
+	filter, err := parseFilter(r)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+	query := r.URL.Query().Get("q")
+	if database.MatchQuery(query) == "" {
+		writeBadRequest(w, fmt.Errorf("q must contain a word to search for"))
+		return
+	}
+	limit := database.DefaultSearchLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > database.MaxSearchLimit {
+			writeBadRequest(w, fmt.Errorf("invalid limit %q (want 1-%d)", value, database.MaxSearchLimit))
+			return
+		}
+	}
+
+	hits, err := h.db.Search(database.SearchFilter{
+		Query:    query,
+		Since:    filter.Since,
+		Until:    filter.Until,
+		IP:       filter.IP,
+		Limit:    limit,
+		Sensor:   filter.Sensor,
+		Listener: filter.Listener,
+		Port:     filter.Port,
+	})
+	if err != nil {
+		slog.Error("Failed to search logs", "error", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		if encodeErr := json.NewEncoder(w).Encode(map[string]string{"error": "failed to search logs", "details": err.Error()}); encodeErr != nil {
+			// Response already started
+		}
+		return
+	}
+	if hits == nil {
+		hits = []database.SearchHit{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(hits); err != nil {
+		slog.Error("Failed to encode search hits", "error", err)
+	}
+
+	slog.Info("Search completed", "count", len(hits))
+}
@@ -1,14 +1,19 @@
 package stats
 
 import (
+	"bytes"
 	"encoding/json"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/dangogh/silver-eureka/internal/database"
+	"github.com/dangogh/silver-eureka/internal/importer"
 )
 
 func setupTestDB(t *testing.T) (*database.DB, func()) {
@@ -357,3 +362,406 @@ func TestHandleDownload_DatabaseError(t *testing.T) {
 		t.Error("Expected error field in response")
 	}
 }
+
+func TestHandleSummary_TimeRange(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := db.LogRequest("192.168.1.1", "/test"); err != nil {
+		t.Fatalf("Failed to log request: %v", err)
+	}
+	handler := New(db)
+
+	tomorrow := time.Now().UTC().AddDate(0, 0, 1).Format(time.DateOnly)
+	tests := []struct {
+		query string
+		code  int
+		total int64
+	}{
+		{"", http.StatusOK, 1},
+		{"?until=" + tomorrow, http.StatusOK, 1},
+		{"?since=" + tomorrow, http.StatusOK, 0},
+		{"?since=2020-01-01T00:00:00Z&until=2020-01-02T00:00:00Z", http.StatusOK, 0},
+		{"?since=yesterday", http.StatusBadRequest, 0},
+		{"?since=2020-01-02&until=2020-01-01", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/stats/summary"+tt.query, nil)
+		w := httptest.NewRecorder()
+		handler.HandleSummary(w, req)
+
+		if w.Code != tt.code {
+			t.Errorf("%q: expected status %d, got %d", tt.query, tt.code, w.Code)
+			continue
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+		var summary database.Summary
+		if err := json.NewDecoder(w.Body).Decode(&summary); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if summary.TotalRequests != tt.total {
+			t.Errorf("%q: expected %d requests, got %d", tt.query, tt.total, summary.TotalRequests)
+		}
+	}
+}
+
+func TestHandleSourceStats_Prefix(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, ip := range []string{"185.220.101.1", "185.220.101.2", "185.220.102.1", "2001:db8::1"} {
+		if err := db.LogRequest(ip, "/test"); err != nil {
+			t.Fatalf("Failed to log request: %v", err)
+		}
+	}
+	handler := New(db)
+
+	tests := []struct {
+		query string
+		code  int
+		want  []string
+	}{
+		{"?ip=185.220.0.0/16", http.StatusOK, []string{"185.220.101.1", "185.220.101.2", "185.220.102.1"}},
+		{"?ip=185.220.0.0/16&prefix_v4=24", http.StatusOK, []string{"185.220.101.0/24", "185.220.102.0/24"}},
+		{"?prefix_v4=16&prefix_v6=32", http.StatusOK, []string{"185.220.0.0/16", "2001:db8::/32"}},
+		{"?ip=2001:db8::1", http.StatusOK, []string{"2001:db8::1"}},
+		{"?ip=185.220.0.0/99", http.StatusBadRequest, nil},
+		{"?ip=unknown", http.StatusBadRequest, nil},
+		{"?prefix_v4=33", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/stats/sources"+tt.query, nil)
+		w := httptest.NewRecorder()
+		handler.HandleSourceStats(w, req)
+
+		if w.Code != tt.code {
+			t.Errorf("%q: expected status %d, got %d", tt.query, tt.code, w.Code)
+			continue
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+		var stats []database.SourceStats
+		if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		var got []string
+		for _, s := range stats {
+			got = append(got, s.IPAddress)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q: expected %v, got %v", tt.query, tt.want, got)
+		}
+	}
+}
+
+func TestHandleEndpointStats_GroupBy(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, url := range []string{"/users/1?x=1", "/users/2?x=2", "/users/2"} {
+		if err := db.LogRequest("192.0.2.1", url); err != nil {
+			t.Fatalf("Failed to log request: %v", err)
+		}
+	}
+	handler := New(db)
+
+	tests := []struct {
+		query string
+		code  int
+		want  []string
+	}{
+		{"", http.StatusOK, []string{"/users/1?x=1", "/users/2", "/users/2?x=2"}},
+		{"?group_by=path", http.StatusOK, []string{"/users/1", "/users/2"}},
+		{"?group_by=normalized", http.StatusOK, []string{"/users/{id}"}},
+		{"?group_by=host", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/stats/endpoints"+tt.query, nil)
+		w := httptest.NewRecorder()
+		handler.HandleEndpointStats(w, req)
+
+		if w.Code != tt.code {
+			t.Errorf("%q: expected status %d, got %d", tt.query, tt.code, w.Code)
+			continue
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+		var stats []database.EndpointStats
+		if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		var got []string
+		for _, s := range stats {
+			got = append(got, s.URL)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q: expected %v, got %v", tt.query, tt.want, got)
+		}
+	}
+}
+
+func TestHandleSensorStats(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if _, err := db.LogSensorRequests("edge-1", []database.SensorRequest{
+		{IPAddress: "192.0.2.1", URL: "/wp-login.php"},
+		{IPAddress: "192.0.2.2", URL: "/wp-login.php"},
+	}); err != nil {
+		t.Fatalf("Failed to log sensor requests: %v", err)
+	}
+	if err := db.LogRequest("192.0.2.3", "/local"); err != nil {
+		t.Fatalf("Failed to log request: %v", err)
+	}
+	handler := New(db)
+
+	req := httptest.NewRequest(http.MethodGet, "/stats/sensors", nil)
+	w := httptest.NewRecorder()
+	handler.HandleSensorStats(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var stats []database.SensorStats
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(stats) != 2 || stats[0].Sensor != "edge-1" || stats[0].Count != 2 || stats[1].Sensor != "" {
+		t.Errorf("Expected edge-1 and the untagged requests, got %+v", stats)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/stats/endpoints?sensor=edge-1", nil)
+	w = httptest.NewRecorder()
+	handler.HandleEndpointStats(w, req)
+	var endpoints []database.EndpointStats
+	if err := json.NewDecoder(w.Body).Decode(&endpoints); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(endpoints) != 1 || endpoints[0].URL != "/wp-login.php" {
+		t.Errorf("Expected only edge-1's endpoint, got %+v", endpoints)
+	}
+}
+
+func TestHandleConnectionStats(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, c := range []database.Connection{
+		{IPAddress: "192.0.2.1", Protocol: "ssh", LocalPort: 22, Payload: []byte("SSH-2.0-Go\r\n")},
+		{IPAddress: "192.0.2.2", Protocol: "ssh", LocalPort: 22, Payload: []byte("SSH-2.0-Go\r\n")},
+		{IPAddress: "192.0.2.2", Protocol: "telnet", LocalPort: 23},
+	} {
+		if err := db.LogConnection(c); err != nil {
+			t.Fatalf("Failed to log connection: %v", err)
+		}
+	}
+	handler := New(db)
+
+	req := httptest.NewRequest(http.MethodGet, "/stats/connections", nil)
+	w := httptest.NewRecorder()
+	handler.HandleConnectionStats(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var stats []database.ConnectionStats
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(stats) != 2 || stats[0].Protocol != "ssh" || stats[0].Count != 2 || string(stats[0].Payload) != "SSH-2.0-Go\r\n" {
+		t.Errorf("Expected SSH then telnet, got %+v", stats)
+	}
+
+	// Connections count in the source statistics
+	req = httptest.NewRequest(http.MethodGet, "/stats/sources?port=23", nil)
+	w = httptest.NewRecorder()
+	handler.HandleSourceStats(w, req)
+	var sources []database.SourceStats
+	if err := json.NewDecoder(w.Body).Decode(&sources); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(sources) != 1 || sources[0].IPAddress != "192.0.2.2" || sources[0].Connections != 1 {
+		t.Errorf("Expected the telnet client, got %+v", sources)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/stats/connections?since=yesterday", nil)
+	w = httptest.NewRecorder()
+	handler.HandleConnectionStats(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
+
+func TestHandleListenerStats(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, port := range []int{8080, 8080, 3000} {
+		if err := db.LogRequestDetails("192.0.2.1", "/", database.RequestDetails{Listener: "alt", LocalPort: port}); err != nil {
+			t.Fatalf("Failed to log request: %v", err)
+		}
+	}
+	handler := New(db)
+
+	req := httptest.NewRequest(http.MethodGet, "/stats/listeners", nil)
+	w := httptest.NewRecorder()
+	handler.HandleListenerStats(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var stats []database.ListenerStats
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(stats) != 2 || stats[0].Listener != "alt" || stats[0].Port != 8080 || stats[0].Count != 2 {
+		t.Errorf("Expected port 8080 then 3000, got %+v", stats)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/stats/summary?listener=alt&port=3000", nil)
+	w = httptest.NewRecorder()
+	handler.HandleSummary(w, req)
+	var summary database.Summary
+	if err := json.NewDecoder(w.Body).Decode(&summary); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if summary.TotalRequests != 1 {
+		t.Errorf("Expected the one request on port 3000, got %d", summary.TotalRequests)
+	}
+
+	for _, port := range []string{"http", "0", "70000"} {
+		req = httptest.NewRequest(http.MethodGet, "/stats/listeners?port="+port, nil)
+		w = httptest.NewRecorder()
+		handler.HandleListenerStats(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("port=%s: expected status 400, got %d", port, w.Code)
+		}
+	}
+}
+
+func TestHandleSearch(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, r := range []struct{ ip, url, agent string }{
+		{"192.0.2.1", "/cgi-bin/luci", "${jndi:ldap://x}"},
+		{"198.51.100.1", "/", "${jndi:ldap://y}"},
+		{"192.0.2.2", "/robots.txt", "Googlebot"},
+	} {
+		if err := db.LogRequestDetails(r.ip, r.url, database.RequestDetails{UserAgent: r.agent}); err != nil {
+			t.Fatalf("Failed to log request: %v", err)
+		}
+	}
+	handler := New(db)
+
+	tests := []struct {
+		query string
+		code  int
+		want  []string
+	}{
+		{"?q=jndi:ldap", http.StatusOK, []string{"/", "/cgi-bin/luci"}},
+		{"?q=jndi&ip=192.0.2.0/24", http.StatusOK, []string{"/cgi-bin/luci"}},
+		{"?q=jndi&limit=1", http.StatusOK, []string{"/"}},
+		{"?q=nothing", http.StatusOK, []string{}},
+		{"", http.StatusBadRequest, nil},
+		{"?q=%24%7B", http.StatusBadRequest, nil},
+		{"?q=jndi&limit=0", http.StatusBadRequest, nil},
+		{"?q=jndi&ip=bogus", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/stats/search"+tt.query, nil)
+		w := httptest.NewRecorder()
+		handler.HandleSearch(w, req)
+
+		if w.Code != tt.code {
+			t.Errorf("%q: expected status %d, got %d", tt.query, tt.code, w.Code)
+			continue
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+		var hits []database.SearchHit
+		if err := json.NewDecoder(w.Body).Decode(&hits); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		got := []string{}
+		for _, h := range hits {
+			got = append(got, h.URL)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q: expected %v, got %v", tt.query, tt.want, got)
+		}
+		if len(hits) > 0 && !strings.Contains(hits[0].Snippet, "<mark>") {
+			t.Errorf("%q: expected a highlighted snippet, got %q", tt.query, hits[0].Snippet)
+		}
+	}
+}
+
+func TestHandleImport(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	handler := New(db)
+
+	clf := `192.0.2.1 - - [10/Oct/2023:13:55:36 -0700] "GET /wp-login.php HTTP/1.1" 404 153 "-" "curl/8.0"
+junk
+`
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	part, err := mw.CreateFormFile("file", "access.log")
+	if err != nil {
+		t.Fatalf("Failed to create form: %v", err)
+	}
+	if _, err := part.Write([]byte(clf)); err != nil {
+		t.Fatalf("Failed to write form: %v", err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatalf("Failed to close form: %v", err)
+	}
+
+	tests := []struct {
+		name, query, contentType, body string
+		code                           int
+		imported, duplicates           int64
+	}{
+		{"dry run", "?dry_run=true", "text/plain", clf, http.StatusOK, 1, 0},
+		{"raw body", "?sensor=edge-1", "text/plain", clf, http.StatusOK, 1, 0},
+		{"multipart", "?sensor=edge-1&format=clf", mw.FormDataContentType(), form.String(), http.StatusOK, 0, 1},
+		{"truncated export", "?format=json", "application/json", `[{"IPAddress":"192.0.2.1"`, http.StatusBadRequest, 0, 0},
+		{"bad format", "?format=xml", "text/plain", clf, http.StatusBadRequest, 0, 0},
+		{"bad sensor", "?sensor=a%20b", "text/plain", clf, http.StatusBadRequest, 0, 0},
+		{"bad dry run", "?dry_run=maybe", "text/plain", clf, http.StatusBadRequest, 0, 0},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/stats/import"+tt.query, strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", tt.contentType)
+		w := httptest.NewRecorder()
+		handler.HandleImport(w, req)
+
+		if w.Code != tt.code {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.name, tt.code, w.Code, w.Body.String())
+			continue
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+		var report importer.Report
+		if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if report.Format != importer.FormatCLF || report.Imported != tt.imported ||
+			report.Duplicates != tt.duplicates || report.Invalid != 1 || len(report.Errors) != 1 {
+			t.Errorf("%s: unexpected report %+v", tt.name, report)
+		}
+	}
+
+	logs, err := db.QueryLogs(database.LogFilter{Sensor: "edge-1"})
+	if err != nil {
+		t.Fatalf("Failed to query logs: %v", err)
+	}
+	if len(logs) != 1 || logs[0].URL != "/wp-login.php" {
+		t.Errorf("Expected one imported log, got %+v", logs)
+	}
+}
@@ -0,0 +1,229 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/dangogh/silver-eureka/internal/config"
+)
+
+// signal is a kind of telemetry with its OTLP/HTTP path and gRPC method
+type signal struct {
+	name   string
+	path   string
+	method string
+}
+
+var (
+	logsSignal   = signal{"logs", "/v1/logs", "/opentelemetry.proto.collector.logs.v1.LogsService/Export"}
+	tracesSignal = signal{"traces", "/v1/traces", "/opentelemetry.proto.collector.trace.v1.TraceService/Export"}
+)
+
+// client sends export requests over OTLP/HTTP with protobuf bodies, or
+// over gRPC, which is the same protobuf framed and sent over HTTP/2
+type client struct {
+	endpoint string
+	grpc     bool
+	headers  map[string]string
+	http     *http.Client
+}
+
+// newClient creates a client for cfg's collector. gRPC to an http://
+// endpoint uses HTTP/2 without TLS.
+func newClient(cfg config.OTelConfig) *client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.Protocol == config.OTLPGRPC {
+		var protocols http.Protocols
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+		transport.Protocols = &protocols
+	}
+	return &client{
+		endpoint: strings.TrimSuffix(cfg.Endpoint, "/"),
+		grpc:     cfg.Protocol == config.OTLPGRPC,
+		headers:  cfg.Headers,
+		http:     &http.Client{Transport: transport},
+	}
+}
+
+// export sends one encoded export request
+func (c *client) export(ctx context.Context, sig signal, msg []byte) error {
+	if c.grpc {
+		return c.exportGRPC(ctx, sig, msg)
+	}
+	return c.exportHTTP(ctx, sig, msg)
+}
+
+// exportHTTP POSTs msg as application/x-protobuf
+func (c *client) exportHTTP(ctx context.Context, sig signal, msg []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint+sig.path, bytes.NewReader(msg))
+	if err != nil {
+		return fmt.Errorf("invalid OTLP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	c.setHeaders(req)
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer closeBody(resp)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return fmt.Errorf("failed to read OTLP response: %w", err)
+	}
+	return partialSuccess(body)
+}
+
+// exportGRPC makes a unary gRPC call: the message goes in a length-prefixed
+// frame and the result comes back in the grpc-status trailer
+func (c *client) exportGRPC(ctx context.Context, sig signal, msg []byte) error {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	frame = append(frame, msg...)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint+sig.method, bytes.NewReader(frame))
+	if err != nil {
+		return fmt.Errorf("invalid OTLP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	c.setHeaders(req)
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer closeBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return fmt.Errorf("failed to read OTLP response: %w", err)
+	}
+
+	// A call that fails at once may send its status in the headers
+	status, message := resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	if status == "" {
+		status, message = resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	}
+	if status != "0" {
+		if message, err := url.PathUnescape(message); err == nil && message != "" {
+			return fmt.Errorf("collector returned gRPC status %s: %s", status, message)
+		}
+		return fmt.Errorf("collector returned gRPC status %q", status)
+	}
+	if len(body) < 5 {
+		return nil
+	}
+	return partialSuccess(body[5:])
+}
+
+// setHeaders adds the configured headers to req
+func (c *client) setHeaders(req *http.Request) {
+	for name, value := range c.headers {
+		req.Header.Set(name, value)
+	}
+}
+
+// do sends req, leaving the endpoint out of errors: it may carry
+// credentials
+func (c *client) do(req *http.Request) (*http.Response, error) {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return nil, err
+	}
+	return resp, nil
+}
+
+// close releases idle connections
+func (c *client) close() {
+	c.http.CloseIdleConnections()
+}
+
+// closeBody drains and closes a response body
+func closeBody(resp *http.Response) {
+	if _, err := io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)); err != nil {
+		// Draining only lets the connection be reused
+	}
+	if err := resp.Body.Close(); err != nil {
+		// Nothing left to read
+	}
+}
+
+// partialSuccess reports the records a collector accepted the request but
+// rejected, from the partial_success field both export responses share
+func partialSuccess(body []byte) error {
+	fields := readFields(body)
+	ps, ok := fields[1]
+	if !ok {
+		return nil
+	}
+	inner := readFields(ps.bytes)
+	rejected, message := inner[1].varint, string(inner[2].bytes)
+	if rejected == 0 && message == "" {
+		return nil
+	}
+	return fmt.Errorf("collector rejected %d records: %s", rejected, message)
+}
+
+// protoField is the last value of a field, as read by readFields
+type protoField struct {
+	varint uint64
+	bytes  []byte
+}
+
+// readFields reads the varint and length-delimited fields of a message,
+// skipping others. Reading stops at malformed input.
+func readFields(b []byte) map[int]protoField {
+	fields := make(map[int]protoField)
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return fields
+		}
+		b = b[n:]
+		field := int(key >> 3)
+		switch key & 7 {
+		case wireVarint:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return fields
+			}
+			b = b[n:]
+			fields[field] = protoField{varint: v}
+		case wireFixed64:
+			if len(b) < 8 {
+				return fields
+			}
+			b = b[8:]
+		case wireBytes:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || size > uint64(len(b)-n) {
+				return fields
+			}
+			fields[field] = protoField{bytes: b[n : n+int(size)]}
+			b = b[n+int(size):]
+		case wireFixed32:
+			if len(b) < 4 {
+				return fields
+			}
+			b = b[4:]
+		default:
+			return fields
+		}
+	}
+	return fields
+}
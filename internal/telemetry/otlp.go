@@ -0,0 +1,243 @@
+package telemetry
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// protoWriter appends protobuf fields. Fields holding their type's zero
+// value are left out, as proto3 does.
+type protoWriter struct {
+	b []byte
+}
+
+func (w *protoWriter) tag(field, wireType int) {
+	w.b = binary.AppendUvarint(w.b, uint64(field)<<3|uint64(wireType))
+}
+
+func (w *protoWriter) varint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	w.tag(field, wireVarint)
+	w.b = binary.AppendUvarint(w.b, v)
+}
+
+func (w *protoWriter) fixed64(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	w.tag(field, wireFixed64)
+	w.b = binary.LittleEndian.AppendUint64(w.b, v)
+}
+
+func (w *protoWriter) fixed32(field int, v uint32) {
+	if v == 0 {
+		return
+	}
+	w.tag(field, wireFixed32)
+	w.b = binary.LittleEndian.AppendUint32(w.b, v)
+}
+
+func (w *protoWriter) bytes(field int, v []byte) {
+	if len(v) == 0 {
+		return
+	}
+	w.tag(field, wireBytes)
+	w.b = binary.AppendUvarint(w.b, uint64(len(v)))
+	w.b = append(w.b, v...)
+}
+
+func (w *protoWriter) string(field int, v string) {
+	if v == "" {
+		return
+	}
+	w.tag(field, wireBytes)
+	w.b = binary.AppendUvarint(w.b, uint64(len(v)))
+	w.b = append(w.b, v...)
+}
+
+// message writes an embedded message, even an empty one
+func (w *protoWriter) message(field int, v []byte) {
+	w.tag(field, wireBytes)
+	w.b = binary.AppendUvarint(w.b, uint64(len(v)))
+	w.b = append(w.b, v...)
+}
+
+// unixNano returns t as OTLP timestamps are sent
+func unixNano(t time.Time) uint64 {
+	return uint64(t.UnixNano())
+}
+
+// Attr is a span or log record attribute
+type Attr struct {
+	Key   string
+	Value any // string, bool, int64 or float64
+}
+
+// String returns a string attribute
+func String(key, value string) Attr {
+	return Attr{key, value}
+}
+
+// Int returns an integer attribute
+func Int(key string, value int) Attr {
+	return Attr{key, int64(value)}
+}
+
+// Bool returns a boolean attribute
+func Bool(key string, value bool) Attr {
+	return Attr{key, value}
+}
+
+// anyValue encodes an OTLP AnyValue. Types without an OTLP equivalent are
+// sent as their string form.
+func anyValue(v any) []byte {
+	var w protoWriter
+	switch v := v.(type) {
+	case string:
+		w.tag(1, wireBytes)
+		w.b = binary.AppendUvarint(w.b, uint64(len(v)))
+		w.b = append(w.b, v...)
+	case bool:
+		w.tag(2, wireVarint)
+		if v {
+			w.b = append(w.b, 1)
+		} else {
+			w.b = append(w.b, 0)
+		}
+	case int64:
+		w.tag(3, wireVarint)
+		w.b = binary.AppendUvarint(w.b, uint64(v))
+	case float64:
+		w.tag(4, wireFixed64)
+		w.b = binary.LittleEndian.AppendUint64(w.b, math.Float64bits(v))
+	default:
+		return anyValue(fmt.Sprint(v))
+	}
+	return w.b
+}
+
+// keyValue encodes an OTLP KeyValue
+func keyValue(a Attr) []byte {
+	var w protoWriter
+	w.string(1, a.Key)
+	w.message(2, anyValue(a.Value))
+	return w.b
+}
+
+// resource encodes the OTLP Resource naming this service
+func resource(serviceName string) []byte {
+	var w protoWriter
+	for _, a := range []Attr{
+		String("service.name", serviceName),
+		String("telemetry.sdk.name", "silver-eureka"),
+		String("telemetry.sdk.language", "go"),
+	} {
+		w.message(1, keyValue(a))
+	}
+	return w.b
+}
+
+// scope encodes the OTLP InstrumentationScope records are reported under
+func scope() []byte {
+	var w protoWriter
+	w.string(1, "github.com/dangogh/silver-eureka")
+	return w.b
+}
+
+// exportRequest wraps encoded log records or spans in an
+// ExportLogsServiceRequest or ExportTraceServiceRequest, which share a
+// layout: resource_X { resource, scope_X { scope, records } }
+func exportRequest(resource, scope []byte, records [][]byte) []byte {
+	var scoped protoWriter
+	scoped.message(1, scope)
+	for _, r := range records {
+		scoped.message(2, r)
+	}
+	var res protoWriter
+	res.message(1, resource)
+	res.message(2, scoped.b)
+	var req protoWriter
+	req.message(1, res.b)
+	return req.b
+}
+
+// OTLP severity numbers for the slog levels
+const (
+	severityDebug = 5
+	severityInfo  = 9
+	severityWarn  = 13
+	severityError = 17
+)
+
+// logRecord holds an OTLP LogRecord before encoding
+type logRecord struct {
+	time      time.Time
+	observed  time.Time
+	severity  int
+	level     string
+	body      string
+	eventName string
+	attrs     []Attr
+	traceID   traceID
+	spanID    spanID
+	sampled   bool
+}
+
+// encode returns the LogRecord message
+func (r logRecord) encode() []byte {
+	var w protoWriter
+	w.fixed64(1, unixNano(r.time))
+	w.varint(2, uint64(r.severity))
+	w.string(3, r.level)
+	w.message(5, anyValue(r.body))
+	for _, a := range r.attrs {
+		w.message(6, keyValue(a))
+	}
+	if r.sampled {
+		w.fixed32(8, 1) // W3C sampled flag
+	}
+	if r.traceID.valid() {
+		w.bytes(9, r.traceID[:])
+		w.bytes(10, r.spanID[:])
+	}
+	w.fixed64(11, unixNano(r.observed))
+	w.string(12, r.eventName)
+	return w.b
+}
+
+// encode returns the Span message
+func (s *Span) encode(end time.Time) []byte {
+	var w protoWriter
+	w.bytes(1, s.traceID[:])
+	w.bytes(2, s.spanID[:])
+	if s.parentID.valid() {
+		w.bytes(4, s.parentID[:])
+	}
+	w.string(5, s.name)
+	w.varint(6, uint64(s.kind))
+	w.fixed64(7, unixNano(s.start))
+	w.fixed64(8, unixNano(end))
+	for _, a := range s.attrs {
+		w.message(9, keyValue(a))
+	}
+	if s.status != statusUnset {
+		var status protoWriter
+		status.string(2, s.statusMessage)
+		status.varint(3, uint64(s.status))
+		w.message(15, status.b)
+	}
+	w.fixed32(16, 0x100|1) // span is not remote; sampled
+	return w.b
+}
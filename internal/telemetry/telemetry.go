@@ -0,0 +1,364 @@
+// Package telemetry exports to an OpenTelemetry collector over OTLP: each
+// captured request as a log record, traces of requests through middleware,
+// handler and database insert, and optionally the application's own log
+// lines. Both OTLP/HTTP and gRPC carry the same protobuf messages, built
+// here without the OpenTelemetry SDK.
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/clientip"
+	"github.com/dangogh/silver-eureka/internal/config"
+	"github.com/dangogh/silver-eureka/internal/metrics"
+)
+
+// Exporter batches log records and spans and sends them to the collector
+type Exporter struct {
+	cfg         config.OTelConfig
+	client      *client
+	logs        *batcher
+	spans       *batcher
+	sampleBound uint64 // trace IDs below this are sampled
+	log         *slog.Logger
+	// anonymize rewrites client addresses before they are exported
+	anonymize func(ip string) string
+
+	done chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+	now  func() time.Time
+}
+
+// New creates an Exporter for cfg's collector; Start begins exporting.
+// The Exporter's own errors go to the logger that is the default now, so
+// they are not exported once slog is bridged.
+func New(cfg config.OTelConfig) (*Exporter, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if !cfg.Enabled() {
+		return nil, fmt.Errorf("otel endpoint must be set")
+	}
+	e := &Exporter{
+		cfg:    cfg,
+		client: newClient(cfg),
+		log:    slog.Default(),
+		done:   make(chan struct{}),
+		now:    time.Now,
+	}
+	// Sampling compares the low 63 bits of the trace ID with the ratio
+	if cfg.SampleRatio >= 1 {
+		e.sampleBound = math.MaxUint64
+	} else {
+		e.sampleBound = uint64(cfg.SampleRatio * (1 << 63))
+	}
+	e.logs = e.newBatcher(logsSignal)
+	e.spans = e.newBatcher(tracesSignal)
+	return e, nil
+}
+
+// Start exports batches until Stop is called
+func (e *Exporter) Start() {
+	for _, b := range []*batcher{e.logs, e.spans} {
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			b.run(e.done)
+		}()
+	}
+}
+
+// Stop exports what is still queued and closes the connections
+func (e *Exporter) Stop() {
+	e.once.Do(func() {
+		close(e.done)
+		e.wg.Wait()
+		e.client.close()
+	})
+}
+
+// AnonymizeWith sets how client addresses are rewritten in exported
+// records and spans. It must be called before the Exporter is used.
+func (e *Exporter) AnonymizeWith(fn func(ip string) string) {
+	e.anonymize = fn
+}
+
+// Middleware traces each request and exports it as a log record, as
+// configured. The log record carries the trace's IDs when it is sampled.
+func (e *Exporter) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := e.now()
+			client := clientip.FromRequest(r)
+			if e.anonymize != nil {
+				client = e.anonymize(client)
+			}
+			attrs := requestAttrs(r, client)
+			ctx, span := r.Context(), (*Span)(nil)
+			if e.cfg.Traces {
+				ctx, span = e.startRoot(ctx, r.Method, SpanServer, attrs...)
+			}
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, r.WithContext(ctx))
+
+			if span != nil {
+				span.SetAttributes(Int("http.response.status_code", sw.status))
+				if sw.status >= 500 {
+					span.RecordError(fmt.Errorf("%d %s", sw.status, http.StatusText(sw.status)))
+					span.SetAttributes(String("error.type", fmt.Sprint(sw.status)))
+				}
+				span.End()
+			}
+			if e.cfg.Logs {
+				rec := logRecord{
+					time:      start,
+					observed:  e.now(),
+					severity:  severityInfo,
+					level:     "INFO",
+					body:      r.Method + " " + r.URL.RequestURI(),
+					eventName: "silver_eureka.request",
+					attrs:     append(attrs, Int("http.response.status_code", sw.status)),
+				}
+				if span != nil {
+					rec.traceID, rec.spanID, rec.sampled = span.traceID, span.spanID, true
+				}
+				e.logs.add(rec.encode())
+			}
+		})
+	}
+}
+
+// requestAttrs returns the semantic convention attributes of r, sent by
+// client
+func requestAttrs(r *http.Request, client string) []Attr {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	attrs := []Attr{
+		String("http.request.method", r.Method),
+		String("url.scheme", scheme),
+		String("url.path", r.URL.Path),
+		String("client.address", client),
+		String("network.protocol.version", fmt.Sprintf("%d.%d", r.ProtoMajor, r.ProtoMinor)),
+	}
+	if r.URL.RawQuery != "" {
+		attrs = append(attrs, String("url.query", r.URL.RawQuery))
+	}
+	if r.Host != "" {
+		attrs = append(attrs, String("server.address", r.Host))
+	}
+	if ua := r.UserAgent(); ua != "" {
+		attrs = append(attrs, String("user_agent.original", ua))
+	}
+	return attrs
+}
+
+// statusWriter records the response status
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	wrote  bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wrote {
+		w.status, w.wrote = status, true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Handler returns a slog.Handler that passes records to next and also
+// exports them, with the trace's IDs when logged within a sampled span
+func (e *Exporter) Handler(next slog.Handler) slog.Handler {
+	return &bridge{exporter: e, next: next}
+}
+
+// bridge is the slog.Handler returned by Handler
+type bridge struct {
+	exporter *Exporter
+	next     slog.Handler
+	attrs    []Attr
+	group    string // prefix for keys, with a trailing dot
+}
+
+func (h *bridge) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *bridge) Handle(ctx context.Context, r slog.Record) error {
+	rec := logRecord{
+		time:     r.Time,
+		observed: h.exporter.now(),
+		body:     r.Message,
+		attrs:    append([]Attr(nil), h.attrs...),
+	}
+	switch {
+	case r.Level >= slog.LevelError:
+		rec.severity, rec.level = severityError, "ERROR"
+	case r.Level >= slog.LevelWarn:
+		rec.severity, rec.level = severityWarn, "WARN"
+	case r.Level >= slog.LevelInfo:
+		rec.severity, rec.level = severityInfo, "INFO"
+	default:
+		rec.severity, rec.level = severityDebug, "DEBUG"
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		rec.attrs = appendSlogAttr(rec.attrs, h.group, a)
+		return true
+	})
+	if span := SpanFromContext(ctx); span != nil {
+		rec.traceID, rec.spanID, rec.sampled = span.traceID, span.spanID, true
+	}
+	h.exporter.logs.add(rec.encode())
+	return h.next.Handle(ctx, r)
+}
+
+func (h *bridge) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.next = h.next.WithAttrs(attrs)
+	c.attrs = append([]Attr(nil), h.attrs...)
+	for _, a := range attrs {
+		c.attrs = appendSlogAttr(c.attrs, h.group, a)
+	}
+	return &c
+}
+
+func (h *bridge) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := *h
+	c.next = h.next.WithGroup(name)
+	c.group = h.group + name + "."
+	return &c
+}
+
+// appendSlogAttr appends a as OTLP attributes, flattening groups into
+// dotted keys
+func appendSlogAttr(attrs []Attr, prefix string, a slog.Attr) []Attr {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range v.Group() {
+			attrs = appendSlogAttr(attrs, prefix, ga)
+		}
+		return attrs
+	}
+	if a.Key == "" {
+		return attrs
+	}
+	key := prefix + a.Key
+	switch v.Kind() {
+	case slog.KindString:
+		return append(attrs, String(key, v.String()))
+	case slog.KindInt64:
+		return append(attrs, Attr{key, v.Int64()})
+	case slog.KindUint64:
+		return append(attrs, Attr{key, int64(v.Uint64())})
+	case slog.KindFloat64:
+		return append(attrs, Attr{key, v.Float64()})
+	case slog.KindBool:
+		return append(attrs, Bool(key, v.Bool()))
+	case slog.KindDuration:
+		return append(attrs, String(key, v.Duration().String()))
+	case slog.KindTime:
+		return append(attrs, String(key, v.Time().Format(time.RFC3339Nano)))
+	default:
+		return append(attrs, String(key, fmt.Sprint(v.Any())))
+	}
+}
+
+// batcher queues encoded records of one signal and exports them in batches
+type batcher struct {
+	exporter *Exporter
+	signal   signal
+	queue    chan []byte
+}
+
+func (e *Exporter) newBatcher(sig signal) *batcher {
+	return &batcher{exporter: e, signal: sig, queue: make(chan []byte, e.cfg.QueueSize)}
+}
+
+// add queues a record, dropping it when the queue is full
+func (b *batcher) add(record []byte) {
+	select {
+	case b.queue <- record:
+	default:
+		metrics.OTelRecords.WithLabelValues(b.signal.name, "dropped").Inc()
+	}
+}
+
+// run exports batches when they fill or every flush interval, until done
+// is closed
+func (b *batcher) run(done <-chan struct{}) {
+	cfg := b.exporter.cfg
+	ticker := time.NewTicker(cfg.FlushInterval)
+	defer ticker.Stop()
+	batch := make([][]byte, 0, cfg.BatchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			b.export(batch)
+			batch = batch[:0]
+		}
+	}
+
+	for {
+		select {
+		case rec := <-b.queue:
+			batch = append(batch, rec)
+			if len(batch) >= cfg.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-done:
+			for {
+				select {
+				case rec := <-b.queue:
+					batch = append(batch, rec)
+					if len(batch) >= cfg.BatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// export sends one batch. A failed batch is dropped: the collector's own
+// queue is where telemetry waits out an outage.
+func (b *batcher) export(batch [][]byte) {
+	e := b.exporter
+	ctx, cancel := context.WithTimeout(context.Background(), e.cfg.Timeout)
+	defer cancel()
+	msg := exportRequest(resource(e.cfg.ServiceName), scope(), batch)
+	if err := e.client.export(ctx, b.signal, msg); err != nil {
+		metrics.OTelRecords.WithLabelValues(b.signal.name, "failed").Add(float64(len(batch)))
+		e.log.Warn("OTLP export failed", "signal", b.signal.name, "records", len(batch), "error", err)
+		return
+	}
+	metrics.OTelRecords.WithLabelValues(b.signal.name, "sent").Add(float64(len(batch)))
+}
@@ -0,0 +1,460 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/dangogh/silver-eureka/internal/config"
+)
+
+// attrs returns KeyValues as strings, as the collector would display them
+func attrs(kvs []*commonpb.KeyValue) map[string]string {
+	m := make(map[string]string)
+	for _, kv := range kvs {
+		switch v := kv.GetValue().GetValue().(type) {
+		case *commonpb.AnyValue_StringValue:
+			m[kv.Key] = v.StringValue
+		case *commonpb.AnyValue_IntValue:
+			m[kv.Key] = strconv.FormatInt(v.IntValue, 10)
+		case *commonpb.AnyValue_BoolValue:
+			m[kv.Key] = strconv.FormatBool(v.BoolValue)
+		}
+	}
+	return m
+}
+
+// collector is an in-process OTLP collector that decodes requests with the
+// generated OTLP types and keeps the records and spans it receives
+type collector struct {
+	t        *testing.T
+	endpoint string
+
+	mu       sync.Mutex
+	logs     []*logspb.LogRecord
+	spans    []*tracepb.Span
+	service  string
+	paths    []string
+	apiKey   string
+	rejected int64 // records to report as rejected in a partial success
+	err      error // gRPC error to answer with
+}
+
+// resource keeps the service name of res
+func (c *collector) resource(res *resourcepb.Resource) {
+	c.service = attrs(res.GetAttributes())["service.name"]
+}
+
+// exportLogs keeps the records of req and returns the response
+func (c *collector) exportLogs(path, apiKey string, req *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paths = append(c.paths, path)
+	c.apiKey = apiKey
+	if c.err != nil {
+		return nil, c.err
+	}
+	for _, rl := range req.ResourceLogs {
+		c.resource(rl.Resource)
+		for _, sl := range rl.ScopeLogs {
+			c.logs = append(c.logs, sl.LogRecords...)
+		}
+	}
+	resp := &collogspb.ExportLogsServiceResponse{}
+	if c.rejected > 0 {
+		resp.PartialSuccess = &collogspb.ExportLogsPartialSuccess{RejectedLogRecords: c.rejected, ErrorMessage: "timestamp too old"}
+	}
+	return resp, nil
+}
+
+// exportTraces keeps the spans of req and returns the response
+func (c *collector) exportTraces(path, apiKey string, req *coltracepb.ExportTraceServiceRequest) (*coltracepb.ExportTraceServiceResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paths = append(c.paths, path)
+	c.apiKey = apiKey
+	if c.err != nil {
+		return nil, c.err
+	}
+	for _, rs := range req.ResourceSpans {
+		c.resource(rs.Resource)
+		for _, ss := range rs.ScopeSpans {
+			c.spans = append(c.spans, ss.Spans...)
+		}
+	}
+	resp := &coltracepb.ExportTraceServiceResponse{}
+	if c.rejected > 0 {
+		resp.PartialSuccess = &coltracepb.ExportTracePartialSuccess{RejectedSpans: c.rejected, ErrorMessage: "timestamp too old"}
+	}
+	return resp, nil
+}
+
+// startHTTPCollector serves OTLP/HTTP with protobuf bodies
+func startHTTPCollector(t *testing.T) *collector {
+	c := &collector{t: t}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/x-protobuf" {
+			t.Errorf("Unexpected content type %q", r.Header.Get("Content-Type"))
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("Failed to read body: %v", err)
+		}
+		var resp proto.Message
+		switch r.URL.Path {
+		case "/v1/logs":
+			req := &collogspb.ExportLogsServiceRequest{}
+			if err := proto.Unmarshal(body, req); err != nil {
+				t.Errorf("Failed to decode logs request: %v", err)
+			}
+			resp, err = c.exportLogs(r.URL.Path, r.Header.Get("Api-Key"), req)
+		case "/v1/traces":
+			req := &coltracepb.ExportTraceServiceRequest{}
+			if err := proto.Unmarshal(body, req); err != nil {
+				t.Errorf("Failed to decode traces request: %v", err)
+			}
+			resp, err = c.exportTraces(r.URL.Path, r.Header.Get("Api-Key"), req)
+		default:
+			t.Errorf("Unexpected path %q", r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		out, err := proto.Marshal(resp)
+		if err != nil {
+			t.Errorf("Failed to encode response: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		if _, err := w.Write(out); err != nil {
+			t.Errorf("Failed to write response: %v", err)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	c.endpoint = srv.URL
+	return c
+}
+
+// grpcLogs and grpcTraces serve the OTLP gRPC services from a collector
+type grpcLogs struct {
+	collogspb.UnimplementedLogsServiceServer
+	c *collector
+}
+
+func (s grpcLogs) Export(ctx context.Context, req *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceResponse, error) {
+	method, _ := grpc.Method(ctx)
+	return s.c.exportLogs(method, apiKey(ctx), req)
+}
+
+type grpcTraces struct {
+	coltracepb.UnimplementedTraceServiceServer
+	c *collector
+}
+
+func (s grpcTraces) Export(ctx context.Context, req *coltracepb.ExportTraceServiceRequest) (*coltracepb.ExportTraceServiceResponse, error) {
+	method, _ := grpc.Method(ctx)
+	return s.c.exportTraces(method, apiKey(ctx), req)
+}
+
+// apiKey returns the Api-Key metadata of a gRPC call
+func apiKey(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("api-key"); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// startGRPCCollector serves OTLP over gRPC without TLS
+func startGRPCCollector(t *testing.T) *collector {
+	c := &collector{t: t}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	srv := grpc.NewServer()
+	collogspb.RegisterLogsServiceServer(srv, grpcLogs{c: c})
+	coltracepb.RegisterTraceServiceServer(srv, grpcTraces{c: c})
+	go func() {
+		if err := srv.Serve(lis); err != nil {
+			t.Errorf("gRPC server failed: %v", err)
+		}
+	}()
+	t.Cleanup(srv.Stop)
+	c.endpoint = "http://" + lis.Addr().String()
+	return c
+}
+
+func newTestExporter(t *testing.T, protocol, endpoint string) *Exporter {
+	t.Helper()
+	cfg := config.DefaultOTelConfig()
+	cfg.Endpoint = endpoint
+	cfg.Protocol = protocol
+	cfg.ServiceName = "sensor-test"
+	cfg.Headers = map[string]string{"Api-Key": "secret"}
+	cfg.FlushInterval = time.Hour
+	e, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return e
+}
+
+// tracedHandler stands in for the handler and database insert
+var tracedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	ctx, span := StartSpan(r.Context(), "log request", SpanInternal)
+	defer span.End()
+	_, db := StartSpan(ctx, "INSERT request_logs", SpanClient, String("db.system.name", "sqlite"))
+	db.RecordError(errors.New("database is locked"))
+	db.End()
+	w.WriteHeader(http.StatusNotFound)
+})
+
+func TestExporter_HTTP(t *testing.T) {
+	c := startHTTPCollector(t)
+	e := newTestExporter(t, config.OTLPHTTP, c.endpoint)
+	e.Start()
+
+	handler := e.Middleware()(tracedHandler)
+	req := httptest.NewRequest(http.MethodPost, "/wp-login.php?log=admin", nil)
+	req.RemoteAddr = "198.51.100.7:5000"
+	req.Header.Set("User-Agent", "scanner")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	e.Stop()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.service != "sensor-test" || c.apiKey != "secret" {
+		t.Errorf("Unexpected service %q or Api-Key %q", c.service, c.apiKey)
+	}
+	if len(c.spans) != 3 || len(c.logs) != 1 {
+		t.Fatalf("Expected 3 spans and 1 log record, got %d and %d (paths %q)", len(c.spans), len(c.logs), c.paths)
+	}
+
+	// Spans end innermost first
+	dbSpan, handlerSpan, server := c.spans[0], c.spans[1], c.spans[2]
+	for i, s := range c.spans {
+		if !bytes.Equal(s.TraceId, server.TraceId) || len(s.SpanId) != 8 {
+			t.Errorf("Span %d is in another trace or has no ID", i)
+		}
+		if s.EndTimeUnixNano < s.StartTimeUnixNano || s.Flags != 0x101 {
+			t.Errorf("Span %d has times %d-%d and flags %#x", i, s.StartTimeUnixNano, s.EndTimeUnixNano, s.Flags)
+		}
+	}
+	if len(server.TraceId) != 16 || len(server.ParentSpanId) != 0 || server.Name != "POST" || server.Kind != tracepb.Span_SPAN_KIND_SERVER {
+		t.Errorf("Unexpected server span %v", server)
+	}
+	if !bytes.Equal(handlerSpan.ParentSpanId, server.SpanId) || !bytes.Equal(dbSpan.ParentSpanId, handlerSpan.SpanId) ||
+		handlerSpan.Kind != tracepb.Span_SPAN_KIND_INTERNAL || dbSpan.Kind != tracepb.Span_SPAN_KIND_CLIENT {
+		t.Error("Expected server, handler and database spans to be nested")
+	}
+	a := attrs(server.Attributes)
+	if a["http.request.method"] != "POST" || a["url.path"] != "/wp-login.php" || a["url.query"] != "log=admin" ||
+		a["client.address"] != "198.51.100.7" || a["user_agent.original"] != "scanner" || a["http.response.status_code"] != "404" {
+		t.Errorf("Unexpected server span attributes %v", a)
+	}
+	if dbSpan.Status.GetMessage() != "database is locked" || dbSpan.Status.GetCode() != tracepb.Status_STATUS_CODE_ERROR {
+		t.Errorf("Expected the database span to be failed, got %v", dbSpan.Status)
+	}
+	if handlerSpan.Status != nil {
+		t.Errorf("Expected no status on the handler span, got %v", handlerSpan.Status)
+	}
+
+	rec := c.logs[0]
+	if rec.EventName != "silver_eureka.request" || rec.SeverityNumber != logspb.SeverityNumber_SEVERITY_NUMBER_INFO ||
+		!bytes.Equal(rec.TraceId, server.TraceId) || !bytes.Equal(rec.SpanId, server.SpanId) || rec.Flags != 1 {
+		t.Errorf("Unexpected log record %v", rec)
+	}
+	if rec.TimeUnixNano == 0 || rec.ObservedTimeUnixNano == 0 {
+		t.Errorf("Expected the log record to be timed, got %v", rec)
+	}
+	if rec.Body.GetStringValue() != "POST /wp-login.php?log=admin" {
+		t.Errorf("Unexpected log body %v", rec.Body)
+	}
+	if a := attrs(rec.Attributes); a["client.address"] != "198.51.100.7" || a["http.response.status_code"] != "404" {
+		t.Errorf("Unexpected log attributes %v", a)
+	}
+}
+
+func TestExporter_GRPC(t *testing.T) {
+	c := startGRPCCollector(t)
+	e := newTestExporter(t, config.OTLPGRPC, c.endpoint)
+	e.Start()
+
+	handler := e.Middleware()(tracedHandler)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/.env", nil))
+	e.Stop()
+
+	c.mu.Lock()
+	if c.service != "sensor-test" || c.apiKey != "secret" {
+		t.Errorf("Unexpected service %q or Api-Key %q", c.service, c.apiKey)
+	}
+	if len(c.spans) != 3 || len(c.logs) != 1 || c.logs[0].Body.GetStringValue() != "GET /.env" {
+		t.Errorf("Expected 3 spans and the request's log record, got %v and %v", c.spans, c.logs)
+	}
+	if !slices.Contains(c.paths, "/opentelemetry.proto.collector.logs.v1.LogsService/Export") ||
+		!slices.Contains(c.paths, "/opentelemetry.proto.collector.trace.v1.TraceService/Export") {
+		t.Errorf("Unexpected calls %q", c.paths)
+	}
+	c.rejected = 2
+	c.mu.Unlock()
+
+	msg := exportRequest(resource("x"), scope(), [][]byte{logRecord{time: time.Now(), body: "hello"}.encode()})
+	err := e.client.export(context.Background(), logsSignal, msg)
+	if err == nil || !strings.Contains(err.Error(), "rejected 2 records: timestamp too old") {
+		t.Errorf("Expected the partial success, got %v", err)
+	}
+
+	c.mu.Lock()
+	c.err = status.Error(codes.Unavailable, "collector overloaded")
+	c.mu.Unlock()
+	err = e.client.export(context.Background(), tracesSignal, exportRequest(resource("x"), scope(), nil))
+	if err == nil || !strings.Contains(err.Error(), "status 14: collector overloaded") {
+		t.Errorf("Expected the gRPC status, got %v", err)
+	}
+}
+
+func TestExporter_Sampling(t *testing.T) {
+	c := startHTTPCollector(t)
+	e := newTestExporter(t, config.OTLPHTTP, c.endpoint)
+	e.sampleBound = 0
+	e.AnonymizeWith(func(ip string) string { return "anon:" + ip })
+	e.Start()
+	handler := e.Middleware()(tracedHandler)
+	for range 3 {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	e.Stop()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.spans) != 0 || len(c.logs) != 3 {
+		t.Errorf("Expected only log records, got %d spans and %d records", len(c.spans), len(c.logs))
+	}
+	for _, rec := range c.logs {
+		if len(rec.TraceId) != 0 || rec.Flags != 0 {
+			t.Error("Expected no trace ID on an unsampled request's log record")
+		}
+		if a := attrs(rec.Attributes); a["client.address"] != "anon:192.0.2.1" {
+			t.Errorf("Expected the anonymized client address, got %v", a)
+		}
+	}
+
+	// A ratio keeps about that share of traces
+	e.sampleBound = uint64(0.25 * (1 << 63))
+	kept := 0
+	for range 4000 {
+		if e.sampled(newTraceID()) {
+			kept++
+		}
+	}
+	if kept < 800 || kept > 1200 {
+		t.Errorf("Sampling 0.25 kept %d of 4000", kept)
+	}
+	e.sampleBound = math.MaxUint64
+	if !e.sampled(traceID{15: 0xff, 8: 0xff}) {
+		t.Error("Expected a ratio of 1 to keep every trace")
+	}
+}
+
+func TestBridge(t *testing.T) {
+	c := startHTTPCollector(t)
+	e := newTestExporter(t, config.OTLPHTTP, c.endpoint)
+	e.Start()
+
+	var out bytes.Buffer
+	logger := slog.New(e.Handler(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelInfo})))
+	ctx, span := e.startRoot(context.Background(), "GET", SpanServer)
+	logger.With("component", "test").WithGroup("req").WarnContext(ctx, "Slow request", "ms", 1500, "cached", false,
+		slog.Group("client", "ip", "192.0.2.1"))
+	logger.Debug("Not enabled")
+	span.End()
+	e.Stop()
+
+	if !strings.Contains(out.String(), "Slow request") || strings.Contains(out.String(), "Not enabled") {
+		t.Errorf("Expected the wrapped handler to get enabled records, got %q", out.String())
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.logs) != 1 {
+		t.Fatalf("Expected 1 exported record, got %d", len(c.logs))
+	}
+	rec := c.logs[0]
+	if rec.SeverityNumber != logspb.SeverityNumber_SEVERITY_NUMBER_WARN || rec.SeverityText != "WARN" ||
+		!bytes.Equal(rec.TraceId, span.traceID[:]) || rec.Body.GetStringValue() != "Slow request" {
+		t.Errorf("Unexpected record %v", rec)
+	}
+	if a := attrs(rec.Attributes); a["component"] != "test" || a["req.ms"] != "1500" || a["req.cached"] != "false" ||
+		a["req.client.ip"] != "192.0.2.1" {
+		t.Errorf("Unexpected attributes %v", a)
+	}
+}
+
+func TestExporter_PartialSuccess(t *testing.T) {
+	c := startHTTPCollector(t)
+	e := newTestExporter(t, config.OTLPHTTP, c.endpoint)
+	c.rejected = 3
+
+	msg := exportRequest(resource("x"), scope(), [][]byte{logRecord{time: time.Now(), body: "hello"}.encode()})
+	if err := e.client.export(context.Background(), logsSignal, msg); err == nil ||
+		!strings.Contains(err.Error(), "rejected 3 records: timestamp too old") {
+		t.Errorf("Expected a partial success error, got %v", err)
+	}
+	if err := e.client.export(context.Background(), tracesSignal, exportRequest(resource("x"), scope(), nil)); err == nil ||
+		!strings.Contains(err.Error(), "rejected 3 records") {
+		t.Errorf("Expected a partial success error for spans, got %v", err)
+	}
+	if err := partialSuccess(nil); err != nil {
+		t.Errorf("Expected an empty response to be a success, got %v", err)
+	}
+}
+
+func TestAnyValue(t *testing.T) {
+	tests := []struct {
+		value any
+		want  *commonpb.AnyValue
+	}{
+		{"text", &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "text"}}},
+		{"", &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: ""}}},
+		{true, &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: true}}},
+		{false, &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: false}}},
+		{int64(-7), &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: -7}}},
+		{1.5, &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: 1.5}}},
+		{time.Second, &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "1s"}}},
+	}
+	for _, tt := range tests {
+		got := &commonpb.AnyValue{}
+		if err := proto.Unmarshal(anyValue(tt.value), got); err != nil {
+			t.Fatalf("anyValue(%v) doesn't decode: %v", tt.value, err)
+		}
+		if !proto.Equal(got, tt.want) {
+			t.Errorf("anyValue(%#v) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
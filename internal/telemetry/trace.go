@@ -0,0 +1,160 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/binary"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// SpanKind is the OTLP span kind
+type SpanKind int
+
+// Span kinds used by the application
+const (
+	SpanInternal SpanKind = 1
+	SpanServer   SpanKind = 2
+	SpanClient   SpanKind = 3
+)
+
+// OTLP span status codes
+const (
+	statusUnset = 0
+	statusError = 2
+)
+
+type traceID [16]byte
+
+func (id traceID) valid() bool {
+	return id != traceID{}
+}
+
+type spanID [8]byte
+
+func (id spanID) valid() bool {
+	return id != spanID{}
+}
+
+// Span is an operation in a trace. A nil *Span is valid and does nothing,
+// so code can trace without checking whether the request is sampled.
+type Span struct {
+	exporter *Exporter
+	traceID  traceID
+	spanID   spanID
+	parentID spanID
+	name     string
+	kind     SpanKind
+	start    time.Time
+
+	mu            sync.Mutex
+	attrs         []Attr
+	status        int
+	statusMessage string
+	ended         bool
+}
+
+// spanKey is the context key of the current span
+type spanKey struct{}
+
+// SpanFromContext returns the current span, or nil
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// StartSpan starts a child of the span in ctx. Without one, the trace is
+// not sampled and StartSpan returns ctx and a nil span.
+func StartSpan(ctx context.Context, name string, kind SpanKind, attrs ...Attr) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	s := &Span{
+		exporter: parent.exporter,
+		traceID:  parent.traceID,
+		spanID:   newSpanID(),
+		parentID: parent.spanID,
+		name:     name,
+		kind:     kind,
+		start:    parent.exporter.now(),
+		attrs:    attrs,
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// startRoot starts a new trace if the sampler keeps it
+func (e *Exporter) startRoot(ctx context.Context, name string, kind SpanKind, attrs ...Attr) (context.Context, *Span) {
+	id := newTraceID()
+	if !e.sampled(id) {
+		return ctx, nil
+	}
+	s := &Span{
+		exporter: e,
+		traceID:  id,
+		spanID:   newSpanID(),
+		name:     name,
+		kind:     kind,
+		start:    e.now(),
+		attrs:    attrs,
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// sampled applies the trace ID ratio sampler, deciding as the
+// OpenTelemetry SDKs do so a ratio means the same thing everywhere
+func (e *Exporter) sampled(id traceID) bool {
+	return binary.BigEndian.Uint64(id[8:])>>1 < e.sampleBound
+}
+
+// SetAttributes adds attributes to s
+func (s *Span) SetAttributes(attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs = append(s.attrs, attrs...)
+}
+
+// RecordError marks s as failed with err
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = statusError
+	s.statusMessage = err.Error()
+}
+
+// End finishes s and queues it for export. Only the first call counts.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	s.ended = true
+	s.exporter.spans.add(s.encode(s.exporter.now()))
+}
+
+func newTraceID() traceID {
+	var id traceID
+	for id == (traceID{}) {
+		binary.BigEndian.PutUint64(id[:8], rand.Uint64())
+		binary.BigEndian.PutUint64(id[8:], rand.Uint64())
+	}
+	return id
+}
+
+func newSpanID() spanID {
+	var id spanID
+	for id == (spanID{}) {
+		binary.BigEndian.PutUint64(id[:], rand.Uint64())
+	}
+	return id
+}
@@ -0,0 +1,128 @@
+package web
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/ban"
+)
+
+// SetBanManager enables the ban list pages
+func (h *Handler) SetBanManager(m *ban.Manager) {
+	h.bans = m
+}
+
+// HandleBans displays the ban list with forms to add and remove bans
+func (h *Handler) HandleBans(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("Handler invoked: HandleBans", "method", r.Method, "path", r.URL.Path)
+	if h.bans == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	bans, err := h.bans.List()
+	if err != nil {
+		slog.Error("Failed to retrieve bans", "error", err)
+		http.Error(w, "Failed to retrieve bans", http.StatusInternalServerError)
+		return
+	}
+
+	var csrfToken string
+	if session, ok := h.currentSession(r); ok {
+		csrfToken = session.CSRFToken
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	templateData := map[string]interface{}{
+		"Bans":      bans,
+		"Now":       time.Now(),
+		"CSRFToken": csrfToken,
+	}
+	if err := h.templates.ExecuteTemplate(w, "bans.html", templateData); err != nil {
+		slog.Error("Failed to render bans template", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// HandleBanAdd processes the add ban form
+func (h *Handler) HandleBanAdd(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("Handler invoked: HandleBanAdd", "method", r.Method, "path", r.URL.Path)
+	if h.bans == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !h.validCSRF(w, r) {
+		return
+	}
+
+	var duration time.Duration
+	if value := r.FormValue("duration"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			http.Error(w, "Invalid duration", http.StatusBadRequest)
+			return
+		}
+		duration = d
+	}
+
+	if _, err := h.bans.Ban(r.FormValue("cidr"), r.FormValue("reason"), ban.SourceManual, duration); err != nil {
+		slog.Warn("Failed to add ban", "error", err)
+		http.Error(w, "Failed to add ban: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, "/bans", http.StatusSeeOther)
+}
+
+// HandleBanRemove processes the remove ban form
+func (h *Handler) HandleBanRemove(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("Handler invoked: HandleBanRemove", "method", r.Method, "path", r.URL.Path)
+	if h.bans == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !h.validCSRF(w, r) {
+		return
+	}
+
+	if _, err := h.bans.Unban(r.FormValue("cidr")); err != nil {
+		slog.Warn("Failed to remove ban", "error", err)
+		http.Error(w, "Failed to remove ban: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, "/bans", http.StatusSeeOther)
+}
+
+// currentSession returns the session for the request's session cookie
+func (h *Handler) currentSession(r *http.Request) (Session, bool) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return Session{}, false
+	}
+	return h.sessions.Get(cookie.Value)
+}
+
+// validCSRF checks the submitted form token against the session's CSRF token,
+// writing a 403 response when it does not match
+func (h *Handler) validCSRF(w http.ResponseWriter, r *http.Request) bool {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return false
+	}
+
+	session, ok := h.currentSession(r)
+	if ok && subtle.ConstantTimeCompare([]byte(r.FormValue("csrf_token")), []byte(session.CSRFToken)) == 1 {
+		return true
+	}
+
+	slog.Warn("CSRF token validation failed", "remote_addr", r.RemoteAddr, "path", r.URL.Path)
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusForbidden)
+	if _, err := w.Write([]byte("403 forbidden\n")); err != nil {
+		// Response already started
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/dangogh/silver-eureka/internal/ban"
+	"github.com/dangogh/silver-eureka/internal/config"
+)
+
+func setupBanHandler(t *testing.T) (*Handler, *ban.Manager, string, string) {
+	t.Helper()
+	db := setupTestDB(t)
+	handler := NewHandler(db, "admin", "secret")
+
+	bans, err := ban.NewManager(db, config.DefaultBanConfig())
+	if err != nil {
+		t.Fatalf("Failed to create ban manager: %v", err)
+	}
+	t.Cleanup(bans.Stop)
+	handler.SetBanManager(bans)
+
+	sessionID, err := handler.sessions.Create("admin")
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	session, _ := handler.sessions.Get(sessionID)
+
+	return handler, bans, sessionID, session.CSRFToken
+}
+
+func postBanForm(handler http.HandlerFunc, path, sessionID string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "session_id", Value: sessionID})
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestHandleBans(t *testing.T) {
+	handler, bans, sessionID, _ := setupBanHandler(t)
+	if _, err := bans.Ban("192.0.2.1", "scanner", ban.SourceManual, 0); err != nil {
+		t.Fatalf("Failed to ban: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/bans", nil)
+	req.AddCookie(&http.Cookie{Name: "session_id", Value: sessionID})
+	rec := httptest.NewRecorder()
+	handler.HandleBans(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, "192.0.2.1/32") || !strings.Contains(body, "scanner") {
+		t.Error("Response does not list the ban")
+	}
+}
+
+func TestHandleBanAddAndRemove(t *testing.T) {
+	handler, bans, sessionID, csrfToken := setupBanHandler(t)
+
+	rec := postBanForm(handler.HandleBanAdd, "/bans", sessionID, url.Values{
+		"csrf_token": {csrfToken},
+		"cidr":       {"198.51.100.0/24"},
+		"reason":     {"manual"},
+		"duration":   {"1h"},
+	})
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("Add status = %d, want %d", rec.Code, http.StatusSeeOther)
+	}
+	if !bans.IsBanned("198.51.100.5") {
+		t.Fatal("Expected network to be banned")
+	}
+
+	rec = postBanForm(handler.HandleBanRemove, "/bans/remove", sessionID, url.Values{
+		"csrf_token": {csrfToken},
+		"cidr":       {"198.51.100.0/24"},
+	})
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("Remove status = %d, want %d", rec.Code, http.StatusSeeOther)
+	}
+	if bans.IsBanned("198.51.100.5") {
+		t.Error("Expected network to be unbanned")
+	}
+}
+
+func TestHandleBanAdd_Errors(t *testing.T) {
+	handler, _, sessionID, csrfToken := setupBanHandler(t)
+
+	tests := []struct {
+		name       string
+		form       url.Values
+		wantStatus int
+	}{
+		{"bad CSRF", url.Values{"csrf_token": {"wrong"}, "cidr": {"192.0.2.1"}}, http.StatusForbidden},
+		{"bad CIDR", url.Values{"csrf_token": {csrfToken}, "cidr": {"nope"}}, http.StatusBadRequest},
+		{"bad duration", url.Values{"csrf_token": {csrfToken}, "cidr": {"192.0.2.1"}, "duration": {"later"}}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := postBanForm(handler.HandleBanAdd, "/bans", sessionID, tt.form)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("Status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestHandleBans_Disabled(t *testing.T) {
+	db := setupTestDB(t)
+	handler := NewHandler(db, "admin", "secret")
+
+	rec := httptest.NewRecorder()
+	handler.HandleBans(rec, httptest.NewRequest(http.MethodGet, "/bans", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
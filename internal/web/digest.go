@@ -0,0 +1,32 @@
+package web
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/dangogh/silver-eureka/internal/digest"
+)
+
+// SetDigestManager enables the dashboard's test digest button
+func (h *Handler) SetDigestManager(m *digest.Manager) {
+	h.digest = m
+}
+
+// HandleDigestTest sends a test digest to the chosen recipient
+func (h *Handler) HandleDigestTest(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("Handler invoked: HandleDigestTest", "method", r.Method, "path", r.URL.Path)
+	if h.digest == nil || !h.digest.Enabled() {
+		http.NotFound(w, r)
+		return
+	}
+	if !h.validCSRF(w, r) {
+		return
+	}
+
+	status := "sent"
+	if err := h.digest.SendTest(r.FormValue("to")); err != nil {
+		slog.Error("Failed to send test digest", "error", err)
+		status = "failed"
+	}
+	http.Redirect(w, r, "/dashboard?digest="+status, http.StatusSeeOther)
+}
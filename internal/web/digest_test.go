@@ -0,0 +1,67 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/dangogh/silver-eureka/internal/config"
+	"github.com/dangogh/silver-eureka/internal/digest"
+)
+
+func TestHandleDigestTest(t *testing.T) {
+	db := setupTestDB(t)
+	handler := NewHandler(db, "admin", "secret")
+	sessionID, err := handler.sessions.Create("admin")
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	session, _ := handler.sessions.Get(sessionID)
+
+	dashboard := func() string {
+		req := httptest.NewRequest(http.MethodGet, "/dashboard?digest=failed", nil)
+		req.AddCookie(&http.Cookie{Name: "session_id", Value: sessionID})
+		rec := httptest.NewRecorder()
+		handler.HandleDashboard(rec, req)
+		return rec.Body.String()
+	}
+	if strings.Contains(dashboard(), "/digest/test") {
+		t.Error("Expected no digest card without a digest manager")
+	}
+
+	// Nothing listens on the port, so every send fails
+	cfg := config.DefaultDigestConfig()
+	cfg.SMTP.Host = "127.0.0.1"
+	cfg.SMTP.Port = 1
+	cfg.SMTP.TLS = config.SMTPNone
+	cfg.From = "digest@example.com"
+	cfg.Recipients = []config.DigestRecipient{{Address: "ops@example.com", Schedule: config.DigestDaily}}
+	digests, err := digest.NewManager(db, cfg)
+	if err != nil {
+		t.Fatalf("Failed to create digest manager: %v", err)
+	}
+	handler.SetDigestManager(digests)
+
+	body := dashboard()
+	if !strings.Contains(body, "<option>ops@example.com</option>") || !strings.Contains(body, "Test digest failed") {
+		t.Error("Expected the digest card with its recipient and the last result")
+	}
+
+	rec := postBanForm(handler.HandleDigestTest, "/digest/test", sessionID, url.Values{
+		"csrf_token": {"wrong"},
+		"to":         {"ops@example.com"},
+	})
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Status with a bad CSRF token = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	rec = postBanForm(handler.HandleDigestTest, "/digest/test", sessionID, url.Values{
+		"csrf_token": {session.CSRFToken},
+		"to":         {"ops@example.com"},
+	})
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/dashboard?digest=failed" {
+		t.Errorf("Got %d to %q, want a redirect reporting the failure", rec.Code, rec.Header().Get("Location"))
+	}
+}
@@ -7,9 +7,14 @@ import (
 	"html/template"
 	"log/slog"
 	"net/http"
+	"slices"
+	"strconv"
 	"time"
 
+	"github.com/dangogh/silver-eureka/internal/ban"
+	"github.com/dangogh/silver-eureka/internal/clientip"
 	"github.com/dangogh/silver-eureka/internal/database"
+	"github.com/dangogh/silver-eureka/internal/digest"
 )
 
 //go:embed templates/*.html
@@ -24,6 +29,26 @@ type Handler struct {
 	templates    *template.Template
 	authUsername string
 	authPassword string
+	auth         PasswordChecker
+	bans         *ban.Manager
+	digest       *digest.Manager
+}
+
+// PasswordChecker verifies login credentials from a client address
+type PasswordChecker interface {
+	CheckPassword(client, username, password string) bool
+}
+
+// maxPayloadText is the most of a payload shown in the web interface
+const maxPayloadText = 96
+
+// payloadText shows the start of a connection's payload as a quoted
+// string, with unprintable bytes escaped
+func payloadText(p []byte) string {
+	if len(p) > maxPayloadText {
+		return strconv.QuoteToASCII(string(p[:maxPayloadText])) + "…"
+	}
+	return strconv.QuoteToASCII(string(p))
 }
 
 // NewHandler creates a new web interface handler
@@ -36,6 +61,7 @@ func NewHandler(db *database.DB, authUsername, authPassword string) *Handler {
 			}
 			return a / b
 		},
+		"payload": payloadText,
 	}
 	tmpl := template.Must(template.New("").Funcs(funcMap).ParseFS(templatesFS, "templates/*.html"))
 
@@ -48,6 +74,27 @@ func NewHandler(db *database.DB, authUsername, authPassword string) *Handler {
 	}
 }
 
+// SetAuthenticator checks logins with a, which also knows the accounts
+// stored in the database, instead of only the configured admin user
+func (h *Handler) SetAuthenticator(a PasswordChecker) {
+	h.auth = a
+}
+
+// checkCredentials reports whether a login from client is valid
+func (h *Handler) checkCredentials(client, username, password string) bool {
+	if h.auth != nil {
+		return h.auth.CheckPassword(client, username, password)
+	}
+	userMatch := subtle.ConstantTimeCompare([]byte(username), []byte(h.authUsername)) == 1
+	passMatch := subtle.ConstantTimeCompare([]byte(password), []byte(h.authPassword)) == 1
+	return h.authUsername != "" && h.authPassword != "" && userMatch && passMatch
+}
+
+// SessionCount returns the number of live sessions
+func (h *Handler) SessionCount() int {
+	return h.sessions.Count()
+}
+
 // HandleLoginPage displays the login form
 func (h *Handler) HandleLoginPage(w http.ResponseWriter, r *http.Request) {
 	slog.Debug("Handler invoked: HandleLoginPage", "method", r.Method, "path", r.URL.Path)
@@ -118,11 +165,7 @@ func (h *Handler) HandleLoginSubmit(w http.ResponseWriter, r *http.Request) {
 	username := r.FormValue("username")
 	password := r.FormValue("password")
 
-	// Validate credentials using constant-time comparison
-	userMatch := subtle.ConstantTimeCompare([]byte(username), []byte(h.authUsername)) == 1
-	passMatch := subtle.ConstantTimeCompare([]byte(password), []byte(h.authPassword)) == 1
-
-	if !userMatch || !passMatch {
+	if !h.checkCredentials(clientip.FromRequest(r), username, password) {
 		time.Sleep(100 * time.Millisecond) // Prevent timing attacks
 		w.Header().Set("Content-Type", "text/plain")
 		w.WriteHeader(http.StatusUnauthorized)
@@ -199,16 +242,35 @@ func (h *Handler) HandleDashboard(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	if err := h.templates.ExecuteTemplate(w, "dashboard.html", map[string]interface{}{"CSRFToken": csrfToken}); err != nil {
+	templateData := map[string]interface{}{
+		"CSRFToken":   csrfToken,
+		"BansEnabled": h.bans != nil,
+	}
+	if h.digest != nil && h.digest.Enabled() {
+		templateData["DigestRecipients"] = h.digest.Recipients()
+		templateData["DigestStatus"] = r.URL.Query().Get("digest")
+	}
+	if err := h.templates.ExecuteTemplate(w, "dashboard.html", templateData); err != nil {
 		slog.Error("Failed to render dashboard template", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
 }
 
-// HandleStatsView displays stats in HTML format
+// HandleStatsView displays stats in HTML format, for all sensors or the
+// one given as sensor, and for all listeners or the one given as listener
+// and port
 func (h *Handler) HandleStatsView(w http.ResponseWriter, r *http.Request) {
 	slog.Debug("Handler invoked: HandleStatsView", "method", r.Method, "path", r.URL.Path)
 	statsType := r.PathValue("type")
+	filter := database.StatsFilter{Sensor: r.URL.Query().Get("sensor"), Listener: r.URL.Query().Get("listener")}
+	if value := r.URL.Query().Get("port"); value != "" {
+		port, err := strconv.Atoi(value)
+		if err != nil || port < 1 || port > 65535 {
+			http.Error(w, "Invalid port", http.StatusBadRequest)
+			return
+		}
+		filter.Port = port
+	}
 
 	var data interface{}
 	var err error
@@ -217,13 +279,22 @@ func (h *Handler) HandleStatsView(w http.ResponseWriter, r *http.Request) {
 	switch statsType {
 	case "summary":
 		title = "Summary Statistics"
-		data, err = h.db.GetSummary()
+		data, err = h.db.QuerySummary(filter)
 	case "endpoints":
 		title = "Endpoint Statistics"
-		data, err = h.db.GetEndpointStats()
+		data, err = h.db.QueryEndpointStats(filter)
 	case "sources":
 		title = "Source IP Statistics"
-		data, err = h.db.GetSourceStats()
+		data, err = h.db.QuerySourceStats(filter)
+	case "sensors":
+		title = "Sensor Statistics"
+		data, err = h.db.QuerySensorStats(filter)
+	case "listeners":
+		title = "Listener Statistics"
+		data, err = h.db.QueryListenerStats(filter)
+	case "connections":
+		title = "Banner Connections"
+		data, err = h.db.QueryConnectionStats(filter)
 	default:
 		http.NotFound(w, r)
 		return
@@ -259,6 +330,14 @@ func (h *Handler) HandleStatsView(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// The sensor filter is offered once any request carries a sensor ID
+	sensors, err := h.sensorIDs()
+	if err != nil {
+		slog.Error("Failed to retrieve sensors", "error", err)
+		http.Error(w, "Failed to retrieve statistics", http.StatusInternalServerError)
+		return
+	}
+
 	// Render HTML
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	templateData := map[string]interface{}{
@@ -267,6 +346,10 @@ func (h *Handler) HandleStatsView(w http.ResponseWriter, r *http.Request) {
 		"Data":         data,
 		"MaxCount":     maxCount,
 		"MaxUniqueIPs": maxUniqueIPs,
+		"Sensor":       filter.Sensor,
+		"Sensors":      sensors,
+		"Listener":     filter.Listener,
+		"Port":         filter.Port,
 	}
 
 	if err := h.templates.ExecuteTemplate(w, "stats.html", templateData); err != nil {
@@ -275,30 +358,21 @@ func (h *Handler) HandleStatsView(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// getIPAddress extracts the client IP address from the request
-func getIPAddress(r *http.Request) string {
-	// Check X-Forwarded-For header first (for proxied requests)
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		// X-Forwarded-For can contain multiple IPs; take the first one
-		if idx := len(xff); idx > 0 {
-			if commaIdx := 0; commaIdx < idx {
-				for i, c := range xff {
-					if c == ',' {
-						return xff[:i]
-					}
-				}
-			}
-			return xff
-		}
+// sensorIDs returns the IDs of the sensors that captured requests, leaving
+// out the requests logged without one
+func (h *Handler) sensorIDs() ([]string, error) {
+	stats, err := h.db.QuerySensorStats(database.StatsFilter{})
+	if err != nil {
+		return nil, err
 	}
-
-	// Check X-Real-IP header
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		return xri
+	var ids []string
+	for _, s := range stats {
+		if s.Sensor != "" {
+			ids = append(ids, s.Sensor)
+		}
 	}
-
-	// Fall back to RemoteAddr
-	return r.RemoteAddr
+	slices.Sort(ids)
+	return ids, nil
 }
 
 // RequireAuth is middleware that ensures user is authenticated
@@ -308,7 +382,7 @@ func (h *Handler) RequireAuth(next http.HandlerFunc) http.HandlerFunc {
 		cookie, err := r.Cookie(sessionCookieName)
 		if err != nil {
 			// Log the request before returning 404
-			if err := h.db.LogRequest(getIPAddress(r), r.URL.Path); err != nil {
+			if err := h.db.LogRequest(clientip.FromRequest(r), r.URL.Path); err != nil {
 				slog.Error("Failed to log request", "error", err)
 			}
 			w.Header().Set("Content-Type", "text/plain")
@@ -322,7 +396,7 @@ func (h *Handler) RequireAuth(next http.HandlerFunc) http.HandlerFunc {
 		_, ok := h.sessions.Get(cookie.Value)
 		if !ok {
 			// Log the request before returning 404
-			if err := h.db.LogRequest(getIPAddress(r), r.URL.Path); err != nil {
+			if err := h.db.LogRequest(clientip.FromRequest(r), r.URL.Path); err != nil {
 				slog.Error("Failed to log request", "error", err)
 			}
 			w.Header().Set("Content-Type", "text/plain")
@@ -308,6 +308,9 @@ func TestHandleStatsView(t *testing.T) {
 		{"summary stats", "summary", http.StatusOK},
 		{"endpoints stats", "endpoints", http.StatusOK},
 		{"sources stats", "sources", http.StatusOK},
+		{"sensors stats", "sensors", http.StatusOK},
+		{"listeners stats", "listeners", http.StatusOK},
+		{"connections stats", "connections", http.StatusOK},
 		{"invalid type", "invalid", http.StatusNotFound},
 	}
 
@@ -456,65 +459,6 @@ func TestTemplateFunctions(t *testing.T) {
 	}
 }
 
-func TestGetIPAddress(t *testing.T) {
-	tests := []struct {
-		name       string
-		xff        string
-		xri        string
-		remoteAddr string
-		want       string
-	}{
-		{
-			name:       "X-Forwarded-For single IP",
-			xff:        "203.0.113.195",
-			remoteAddr: "192.168.1.1:1234",
-			want:       "203.0.113.195",
-		},
-		{
-			name:       "X-Forwarded-For multiple IPs",
-			xff:        "203.0.113.195, 70.41.3.18, 150.172.238.178",
-			remoteAddr: "192.168.1.1:1234",
-			want:       "203.0.113.195",
-		},
-		{
-			name:       "X-Real-IP",
-			xri:        "203.0.113.195",
-			remoteAddr: "192.168.1.1:1234",
-			want:       "203.0.113.195",
-		},
-		{
-			name:       "X-Forwarded-For takes precedence over X-Real-IP",
-			xff:        "203.0.113.1",
-			xri:        "203.0.113.2",
-			remoteAddr: "192.168.1.1:1234",
-			want:       "203.0.113.1",
-		},
-		{
-			name:       "RemoteAddr fallback",
-			remoteAddr: "192.168.1.1:1234",
-			want:       "192.168.1.1:1234",
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			req := httptest.NewRequest(http.MethodGet, "/test", nil)
-			if tt.xff != "" {
-				req.Header.Set("X-Forwarded-For", tt.xff)
-			}
-			if tt.xri != "" {
-				req.Header.Set("X-Real-IP", tt.xri)
-			}
-			req.RemoteAddr = tt.remoteAddr
-
-			got := getIPAddress(req)
-			if got != tt.want {
-				t.Errorf("getIPAddress() = %q, want %q", got, tt.want)
-			}
-		})
-	}
-}
-
 func TestHandleLoginPage_Redirect(t *testing.T) {
 	db := setupTestDB(t)
 	handler := NewHandler(db, "admin", "secret")
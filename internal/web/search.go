@@ -0,0 +1,90 @@
+package web
+
+import (
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/dangogh/silver-eureka/internal/database"
+)
+
+// searchResult is a search hit as the search page shows it
+type searchResult struct {
+	database.SearchHit
+	Highlighted template.HTML
+}
+
+// HandleSearch displays the search form and, when q is given, the newest
+// logs matching it within the optional ip, sensor, since and until filters
+func (h *Handler) HandleSearch(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("Handler invoked: HandleSearch", "method", r.Method, "path", r.URL.Path)
+	query := r.URL.Query()
+	templateData := map[string]interface{}{
+		"Query":  query.Get("q"),
+		"IP":     query.Get("ip"),
+		"Sensor": query.Get("sensor"),
+		"Since":  query.Get("since"),
+		"Until":  query.Get("until"),
+	}
+
+	if query.Get("q") != "" {
+		results, err := h.search(r)
+		if err != nil {
+			templateData["Error"] = err.Error()
+		}
+		templateData["Results"] = results
+		templateData["Searched"] = err == nil
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := h.templates.ExecuteTemplate(w, "search.html", templateData); err != nil {
+		slog.Error("Failed to render search template", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// search runs the search given by the form in r
+func (h *Handler) search(r *http.Request) ([]searchResult, error) {
+	query := r.URL.Query()
+	filter := database.SearchFilter{Query: query.Get("q"), Sensor: query.Get("sensor")}
+	if database.MatchQuery(filter.Query) == "" {
+		return nil, fmt.Errorf("enter a word to search for")
+	}
+	if ip := query.Get("ip"); ip != "" {
+		prefix, err := database.ParseIPFilter(ip)
+		if err != nil {
+			return nil, err
+		}
+		filter.IP = prefix
+	}
+	// The form's dates are whole UTC days, until inclusive
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+		days int
+	}{{"since", &filter.Since, 0}, {"until", &filter.Until, 1}} {
+		value := query.Get(p.name)
+		if value == "" {
+			continue
+		}
+		day, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s date %q", p.name, value)
+		}
+		*p.dst = day.AddDate(0, 0, p.days)
+	}
+
+	hits, err := h.db.Search(filter)
+	if err != nil {
+		slog.Error("Failed to search logs", "error", err)
+		return nil, fmt.Errorf("search failed")
+	}
+	results := make([]searchResult, len(hits))
+	for i, hit := range hits {
+		// Snippets come escaped, with only the <mark> elements added
+		results[i] = searchResult{SearchHit: hit, Highlighted: template.HTML(hit.Snippet)}
+	}
+	return results, nil
+}
@@ -0,0 +1,71 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dangogh/silver-eureka/internal/database"
+)
+
+func TestHandleSearch(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.LogRequestDetails("192.0.2.1", "/shell", database.RequestDetails{UserAgent: "<b>${jndi:ldap://x}</b>"}); err != nil {
+		t.Fatalf("Failed to log request: %v", err)
+	}
+	handler := NewHandler(db, "admin", "secret")
+
+	tests := []struct {
+		name     string
+		query    string
+		contains []string
+		excludes []string
+	}{
+		{
+			name:     "form only",
+			query:    "",
+			contains: []string{`name="q"`},
+			excludes: []string{"matching request"},
+		},
+		{
+			name:     "highlighted and escaped",
+			query:    "?q=jndi",
+			contains: []string{"1 matching request<", "<mark>jndi</mark>", "&lt;b&gt;", "/shell"},
+			excludes: []string{"<b>"},
+		},
+		{
+			name:     "filtered out",
+			query:    "?q=jndi&ip=198.51.100.0/24",
+			contains: []string{"0 matching requests"},
+		},
+		{
+			name:     "invalid filter",
+			query:    "?q=jndi&since=yesterday",
+			contains: []string{"invalid since date"},
+			excludes: []string{"matching request"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/search"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			handler.HandleSearch(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			body := rec.Body.String()
+			for _, s := range tt.contains {
+				if !strings.Contains(body, s) {
+					t.Errorf("Response does not contain %q", s)
+				}
+			}
+			for _, s := range tt.excludes {
+				if strings.Contains(body, s) {
+					t.Errorf("Response contains %q", s)
+				}
+			}
+		})
+	}
+}
@@ -75,6 +75,19 @@ func (s *SessionStore) Delete(sessionID string) {
 	s.sessions.Delete(sessionID)
 }
 
+// Count returns the number of unexpired sessions
+func (s *SessionStore) Count() int {
+	now := time.Now()
+	n := 0
+	s.sessions.Range(func(key, value interface{}) bool {
+		if now.Before(value.(Session).ExpiresAt) {
+			n++
+		}
+		return true
+	})
+	return n
+}
+
 // cleanupExpired periodically removes expired sessions
 func (s *SessionStore) cleanupExpired() {
 	ticker := time.NewTicker(10 * time.Minute)
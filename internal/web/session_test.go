@@ -136,3 +136,25 @@ func TestGenerateToken(t *testing.T) {
 		tokens[token] = true
 	}
 }
+
+func TestSessionStore_Count(t *testing.T) {
+	store := NewSessionStore(50 * time.Millisecond)
+	if got := store.Count(); got != 0 {
+		t.Errorf("Count() = %d, want 0", got)
+	}
+
+	for _, user := range []string{"alice", "bob"} {
+		if _, err := store.Create(user); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+	}
+	if got := store.Count(); got != 2 {
+		t.Errorf("Count() = %d, want 2", got)
+	}
+
+	// Expired sessions are not counted even before cleanup removes them
+	time.Sleep(60 * time.Millisecond)
+	if got := store.Count(); got != 0 {
+		t.Errorf("Count() after expiry = %d, want 0", got)
+	}
+}